@@ -63,25 +63,87 @@ func loadCredentials(dir string) ([]dex.PK, error) {
 		}
 
 		path := path.Join(dir, f.Name())
-		b, err := ioutil.ReadFile(path)
-		if err != nil {
-			return nil, err
-		}
-
-		dec := gob.NewDecoder(bytes.NewReader(b))
-		var c dex.Credential
-		err = dec.Decode(&c)
+		pk, err := loadCredential(path)
 		if err != nil {
 			fmt.Printf("error decode credential from file: %s, err: %v, skip\n", path, err)
 			continue
 		}
 
-		r = append(r, c.PK)
+		r = append(r, pk)
 	}
 
 	return r, nil
 }
 
+func loadCredential(path string) (dex.PK, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(b))
+	var c dex.Credential
+	err = dec.Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.PK, nil
+}
+
+// loadMarkets reads the market params file, the token IDs follow the
+// order of the genesis tokens: BNB first and then the additional
+// tokens.
+func loadMarkets(path string, additionalTokens []dex.TokenInfo) ([]dex.GenesisMarket, error) {
+	ids := map[dex.TokenSymbol]dex.TokenID{dex.BNBInfo.Symbol: 0}
+	for i, t := range additionalTokens {
+		ids[t.Symbol] = dex.TokenID(i + 1)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r []dex.GenesisMarket
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		ss := strings.Split(s.Text(), ",")
+		if len(ss) != 5 {
+			return nil, fmt.Errorf("row %q is not in format BASE,QUOTE,TICK_SIZE,LOT_SIZE,MIN_NOTIONAL", s.Text())
+		}
+
+		base, ok := ids[dex.TokenSymbol(ss[0])]
+		if !ok {
+			return nil, fmt.Errorf("unknown token %s", ss[0])
+		}
+
+		quote, ok := ids[dex.TokenSymbol(ss[1])]
+		if !ok {
+			return nil, fmt.Errorf("unknown token %s", ss[1])
+		}
+
+		var v [3]uint64
+		for i := range v {
+			v[i], err = strconv.ParseUint(ss[i+2], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		m := dex.GenesisMarket{
+			Market: dex.MarketSymbol{Base: base, Quote: quote},
+			Params: dex.MarketParams{TickSize: v[0], LotSize: v[1], MinNotional: v[2]},
+		}
+		if !m.Market.Valid() || !m.Params.Valid() {
+			return nil, fmt.Errorf("invalid market %s/%s", ss[0], ss[1])
+		}
+		r = append(r, m)
+	}
+
+	return r, s.Err()
+}
+
 func main() {
 	numNode := flag.Int("N", 9, "number of nodes registered in the genesis block")
 	numGroup := flag.Int("g", 3, "number of groups registered in the genesis block")
@@ -91,8 +153,29 @@ func main() {
 	distributeTo := flag.String("distribute-to", "./credentials", "the native token (and the optionally created tokens) will be evenly distributed to all credentials in this folder")
 	seed := flag.String("seed", "dex-genesis-group", "random seed")
 	additionalTokenPath := flag.String("tokens", "", "path to the file which contains additional tokens to evenly distribute, each row is in format SYMBOL,QUANTITY,DECIMALS. BNB does not have to be in this file, it's distributed by default")
+	makerFeeBps := flag.Uint64("maker-fee-bps", 0, "trading fee rate of the maker in basis points")
+	takerFeeBps := flag.Uint64("taker-fee-bps", 0, "trading fee rate of the taker in basis points")
+	feePoolPath := flag.String("fee-pool", "", "path to the credential of the account that collects the trading fee, no trading fee is charged if empty")
+	minTxnFee := flag.Uint64("min-txn-fee", 0, "minimal fee of a txn in the smallest unit of BNB")
+	marketsPath := flag.String("markets", "", "path to the file which contains the trading rules of the markets, each row is in format BASE,QUOTE,TICK_SIZE,LOT_SIZE,MIN_NOTIONAL. The markets not in this file use the default rules")
 	flag.Parse()
 
+	params := dex.GenesisParams{MinTxnFee: *minTxnFee}
+	if *feePoolPath != "" {
+		pool, err := loadCredential(*feePoolPath)
+		if err != nil {
+			fmt.Printf("error loading fee pool credential: %v\n", err)
+			return
+		}
+
+		f := dex.TradingFee{MakerBps: *makerFeeBps, TakerBps: *takerFeeBps, Pool: pool}
+		if !f.Valid() {
+			fmt.Println("invalid trading fee, the rates must not exceed 10000 basis points")
+			return
+		}
+		params.TradingFee = &f
+	}
+
 	var additionalTokens []dex.TokenInfo
 	if *additionalTokenPath != "" {
 		b, err := ioutil.ReadFile(*additionalTokenPath)
@@ -132,6 +215,15 @@ func main() {
 		}
 	}
 
+	if *marketsPath != "" {
+		markets, err := loadMarkets(*marketsPath, additionalTokens)
+		if err != nil {
+			fmt.Printf("error loading markets file: %v\n", err)
+			return
+		}
+		params.Markets = markets
+	}
+
 	owners, err := loadCredentials(*distributeTo)
 	if err != nil {
 		fmt.Printf("error loading credentials to which the tokens will be distributed to, err: %v\n", err)
@@ -217,7 +309,7 @@ func main() {
 		Data: gobEncode(l),
 	})
 
-	state := dex.CreateGenesisState(owners, additionalTokens)
+	state := dex.CreateGenesisStateWithParams(owners, additionalTokens, params)
 	stateBlob, err := state.Serialize()
 	if err != nil {
 		panic(err)
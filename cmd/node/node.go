@@ -7,13 +7,17 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum/ethdb"
 
 	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/helinwang/dex/pkg/dex"
+	"github.com/helinwang/dex/pkg/dex/grpc"
 	"github.com/helinwang/log15"
 )
 
@@ -30,12 +34,36 @@ func decodeFromFile(path string, v interface{}) {
 	}
 }
 
-func createNode(c consensus.NodeCredentials, genesis consensus.Genesis, u consensus.Updater, cfg consensus.Config) *consensus.Node {
+// updaters updates each of the updaters with the leader state.
+type updaters []consensus.Updater
+
+func (u updaters) Update(s consensus.State) {
+	for _, v := range u {
+		v.Update(s)
+	}
+}
+
+func (u updaters) Finalized(round uint64, s consensus.State) {
+	for _, v := range u {
+		if f, ok := v.(consensus.Finalizer); ok {
+			f.Finalized(round, s)
+		}
+	}
+}
+
+func createNode(c consensus.NodeCredentials, genesis consensus.Genesis, u consensus.Updater, cfg consensus.Config, order dex.PoolOrder) (*consensus.Node, *dex.TxnPool) {
 	state := dex.NewState(ethdb.NewMemDatabase())
+	state.SetChainID(genesis.Block.Hash())
 	pk, _ := dex.RandKeyPair()
-	return consensus.MakeNode(c, cfg, genesis, state, dex.NewTxnPool(state), u, pk)
+	pool := dex.NewTxnPool(state)
+	pool.SetOrder(order)
+	return consensus.MakeNode(c, cfg, genesis, state, pool, updaters{u, pool}, pk), pool
 }
 
+// version is the software version of the node, it is set at build
+// time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
 func main() {
 	rand.Seed(time.Now().UnixNano())
 	groupSize := flag.Int("g", 3, "group size")
@@ -46,8 +74,67 @@ func main() {
 	host := flag.String("host", "127.0.0.1", "node address to listen connection on")
 	port := flag.Int("port", 11001, "node address to listen connection on")
 	seedNode := flag.String("seed", "", "seed node address")
+	dnsSeeds := flag.String("dns-seeds", "", "comma separated host:port of the DNS seeds, the addresses of a host are the seed nodes listening on the port")
+	maxInbound := flag.Int("max-inbound-peers", 64, "the maximum number of the peers connected to the node, 0 means unlimited")
+	maxOutbound := flag.Int("max-outbound-peers", 16, "the maximum number of the peers the node connects to, 0 means unlimited")
+	dataDir := flag.String("data-dir", ".", "path to the directory where the known peers and the bans are saved in the files peers and bans, the peers are connected before the seed node and the bans are loaded at start, empty disables saving")
+	peerMaxAge := flag.Duration("peer-max-age", 7*24*time.Hour, "the known peers not seen for the duration are dropped from the peer file, 0 keeps them")
+	gossipCacheSize := flag.Int("gossip-cache-size", 8192, "the number of the recently seen items remembered to drop the duplicates relayed by the peers")
+	gossipCacheTTL := flag.Duration("gossip-cache-ttl", 10*time.Minute, "how long a seen item is remembered to drop its duplicates")
+	gossipFanout := flag.Int("gossip-fanout", 0, "the number of the peers a block or txn is pushed to, the rest are sent its announcement, 0 uses the square root of the peer count and a negative value only announces")
+	maxGetData := flag.Int("max-get-data", 512, "the number of the items served from a batch of the item requests of a peer, the items over it are sent back to be requested again")
+	announceDelay := flag.Duration("announce-delay", 20*time.Millisecond, "how long the item announcements and requests to a peer wait to be sent in a batch, a negative value sends them at once")
+	sendQueue := flag.Int("peer-send-queue", 1024, "the number of the messages queued to a peer, the txns are dropped first when it is full")
+	saturationTimeout := flag.Duration("peer-saturation-timeout", 30*time.Second, "how long the send queue to a peer can stay full before the peer is disconnected")
+	snapshotDir := flag.String("snapshot-dir", "", "path to the directory of the state snapshots served to the peers and downloaded from them, empty disables them")
+	rateLimits := flag.String("peer-rate-limits", "", "comma separated rate limits of the messages from a peer overriding the defaults, as type=rate:burst such as get_data=100:512, the rate of 0 is unlimited")
+	publicOnly := flag.Bool("peer-exchange-public-only", false, "reject the loopback and the private addresses sent by the peers, set it on the public networks")
+	bandwidthWindow := flag.Duration("bandwidth-window", time.Minute, "the window of the peer traffic rates and the outbound budget")
+	outboundBudget := flag.Uint64("peer-outbound-budget", 0, "the bytes sent to a peer in a bandwidth window after which the historical blocks it requests are deferred, 0 means unlimited")
+	dialTimeout := flag.Duration("dial-timeout", 5*time.Second, "the time allowed for dialing a peer")
+	handshakeTimeout := flag.Duration("handshake-timeout", 5*time.Second, "the time allowed for the handshake with a peer")
+	keepaliveInterval := flag.Duration("keepalive-interval", 15*time.Second, "how long a peer can be idle before it is pinged")
+	readTimeout := flag.Duration("read-timeout", time.Minute, "how long a peer can send nothing before it is disconnected as dead")
+	outboundOnly := flag.Bool("outbound-only", false, "only dial the peers without listening on -host and -port, for the nodes behind a firewall")
+	proxy := flag.String("proxy", "", "host:port of the SOCKS5 proxy the peers are dialed through, empty dials them directly")
+	proxyUser := flag.String("proxy-user", "", "the user authenticating with the SOCKS5 proxy, empty uses no authentication")
+	proxyPasswordFile := flag.String("proxy-password-file", "", "path to the file of the password authenticating -proxy-user with the SOCKS5 proxy")
+	proxyDNS := flag.Bool("proxy-dns", false, "resolve the DNS seeds through the SOCKS5 proxy, it must support the RESOLVE extension of Tor")
+	nat := flag.String("nat", consensus.NATNone, "map the listen port on the NAT router so that the peers can connect to a node behind it: none, any, upnp or pmp")
 	g := flag.String("genesis", "", "path to the genesis block file")
 	rpcAddr := flag.String("rpc-addr", ":12001", "rpc address used to serve wallet RPC calls")
+	grpcAddr := flag.String("grpc-addr", "", "address serving the wallet gRPC service, it shares the TLS, auth and rate limit settings of the rpc address, empty disables it")
+	jsonRPCPath := flag.String("json-rpc-path", dex.DefaultJSONRPCPath, "HTTP path of the JSON-RPC endpoint served on the rpc address, empty disables it")
+	wsPath := flag.String("ws-path", dex.DefaultWebSocketPath, "HTTP path of the WebSocket subscription endpoint served on the rpc address, empty disables it")
+	walletStateOrders := flag.Int("wallet-state-orders", 1000, "the maximum number of the pending orders in the WalletState response, the rest are returned by the Orders RPC")
+	tradesPerMarket := flag.Int("trades-per-market", 1000, "the number of the recent trades of each market kept for the trades RPC")
+	readRate := flag.Float64("rpc-read-rate", 100, "the read requests per second allowed for each RPC client, 0 disables the limit")
+	readBurst := flag.Int("rpc-read-burst", 200, "the read requests an RPC client can make at once")
+	sendTxnRate := flag.Float64("rpc-send-txn-rate", 20, "the SendTxn calls per second allowed for each RPC client, 0 disables the limit")
+	sendTxnBurst := flag.Int("rpc-send-txn-burst", 50, "the SendTxn calls an RPC client can make at once")
+	rawBlockRate := flag.Float64("rpc-raw-block-rate", 2, "the RawBlock calls per second allowed for each RPC client, 0 disables the limit")
+	rawBlockBurst := flag.Int("rpc-raw-block-burst", 10, "the RawBlock calls an RPC client can make at once")
+	archiveRate := flag.Float64("rpc-archive-rate", 2, "the BalanceAt calls per second allowed for each RPC client, 0 disables the limit")
+	archiveBurst := flag.Int("rpc-archive-burst", 10, "the BalanceAt calls an RPC client can make at once")
+	limitLocalhost := flag.Bool("rpc-limit-localhost", false, "rate limit the RPC clients connecting from localhost")
+	tlsCert := flag.String("rpc-tls-cert", "", "path to the PEM encoded TLS certificate of the rpc address, empty serves plain HTTP, which should only be used on localhost")
+	tlsKey := flag.String("rpc-tls-key", "", "path to the PEM encoded TLS key of the rpc address")
+	tlsClientCA := flag.String("rpc-tls-client-ca", "", "path to the PEM encoded CA certificates that verify the RPC client certificates")
+	requireClientCert := flag.Bool("rpc-require-client-cert", false, "require a client certificate verified by -rpc-tls-client-ca for sending txns")
+	authTokenFile := flag.String("rpc-auth-token-file", "", "path to the file of the bearer token required by the authenticated RPC methods, empty disables the authentication")
+	corsOrigins := flag.String("rpc-cors-origins", "", "comma separated origins allowed to call the JSON-RPC and REST endpoints from the browsers, * allows any origin when the auth is disabled, empty disables the cross-origin access")
+	authMethods := flag.String("rpc-auth-methods", strings.Join(dex.DefaultAuthMethods, ","), "comma separated RPC methods that require the bearer token")
+	logSampleRate := flag.Float64("rpc-log-sample-rate", 0, "the fraction of the RPC requests logged with their latency, 0 disables the request log and 1 logs every request")
+	metricsAddr := flag.String("metrics-addr", "", "address serving the metrics at "+dex.MetricsPath+", empty serves them on the rpc address")
+	readyRoundsBehind := flag.Uint64("ready-max-rounds-behind", 1, "the rounds the chain can be behind the random beacon for "+dex.ReadyzPath+" to report ready")
+	readyMinPeers := flag.Int("ready-min-peers", 1, "the connected peers required for "+dex.ReadyzPath+" to report ready")
+	adminRPC := flag.Bool("admin-rpc", false, "enable the admin RPC calls used for debugging")
+	adminService := flag.Bool("admin-service", false, "serve the AdminService managing the peers over net/rpc on the rpc address, it requires -rpc-auth-token-file")
+	peerBanDuration := flag.Duration("peer-ban-duration", 24*time.Hour, "how long a peer banned by the AdminService is refused when the ban does not set the duration")
+	fairPool := flag.Bool("fair-txn-pool", false, "propose the txns of different accounts in turn rather than the highest fee first")
+	archiveInterval := flag.Uint64("archive-interval", 0, "retain the finalized state of every this many rounds for the historical RPC queries such as BalanceAt, 0 disables the archive")
+	archiveSnapshots := flag.Int("archive-snapshots", 1000, "the number of the most recent finalized states retained by the archive")
+	statusRounds := flag.Uint64("txn-status-rounds", 1000, "the number of recent rounds whose included txns can be looked up by the txn status RPC")
 	flag.Parse()
 
 	if *profileDur > 0 {
@@ -87,21 +174,125 @@ func main() {
 		panic(err)
 	}
 
+	var proxyPassword string
+	if *proxyPasswordFile != "" {
+		b, err := ioutil.ReadFile(*proxyPasswordFile)
+		if err != nil {
+			panic(err)
+		}
+
+		proxyPassword = strings.TrimSpace(string(b))
+	}
+
+	limits, err := consensus.ParseRateLimits(*rateLimits)
+	if err != nil {
+		panic(err)
+	}
+
 	cfg := consensus.Config{
 		BlockTime:      time.Second,
 		GroupSize:      *groupSize,
 		GroupThreshold: *threshold,
+		MaxInbound:     *maxInbound,
+		MaxOutbound:    *maxOutbound,
+		DataDir:        *dataDir,
+		PeerMaxAge:     *peerMaxAge,
+
+		SoftwareVersion: version,
+		GossipCacheSize: *gossipCacheSize,
+		GossipCacheTTL:  *gossipCacheTTL,
+		GossipFanout:    *gossipFanout,
+		AnnounceDelay:   *announceDelay,
+		MaxGetData:      *maxGetData,
+		PeerSendQueue:   *sendQueue,
+		NAT:             *nat,
+
+		PeerExchangePublicOnly: *publicOnly,
+		BandwidthWindow:        *bandwidthWindow,
+		PeerOutboundBudget:     *outboundBudget,
+		DNSSeeds:               splitList(*dnsSeeds),
+		DialTimeout:            *dialTimeout,
+		HandshakeTimeout:       *handshakeTimeout,
+		OutboundOnly:           *outboundOnly,
+		KeepaliveInterval:      *keepaliveInterval,
+		ReadTimeout:            *readTimeout,
+		SaturationTimeout:      *saturationTimeout,
+		RateLimits:             limits,
+		Proxy:                  *proxy,
+		ProxyUser:              *proxyUser,
+		ProxyPassword:          proxyPassword,
+		ProxyDNS:               *proxyDNS,
+		SnapshotDir:            *snapshotDir,
 	}
 
 	server := dex.NewRPCServer()
-	n := createNode(credential, genesis, server, cfg)
+	order := dex.FeePriority
+	if *fairPool {
+		order = dex.OwnerFair
+	}
+
+	n, pool := createNode(credential, genesis, server, cfg, order)
+	pool.SetStatusRetention(*statusRounds)
 	server.SetSender(n)
+	server.SetTxnPool(pool)
 	server.SetStater(n.Chain())
+	server.SetJSONRPCPath(*jsonRPCPath)
+	server.SetWebSocketPath(*wsPath)
+	server.SetTradesPerMarket(*tradesPerMarket)
+	server.SetWalletStateOrders(*walletStateOrders)
+	server.SetMetricsAddr(*metricsAddr)
+	server.SetRequestLog(*logSampleRate)
+	server.SetPeerCounter(n)
+	server.SetNodeStater(n)
+	server.SetArchiveInterval(*archiveInterval, *archiveSnapshots)
+	server.SetReadiness(dex.ReadinessConfig{MaxRoundsBehind: *readyRoundsBehind, MinPeers: *readyMinPeers})
+	server.SetRateLimit(dex.RateLimitConfig{
+		Read:            dex.RateLimit{Rate: *readRate, Burst: *readBurst},
+		SendTxn:         dex.RateLimit{Rate: *sendTxnRate, Burst: *sendTxnBurst},
+		RawBlock:        dex.RateLimit{Rate: *rawBlockRate, Burst: *rawBlockBurst},
+		Archive:         dex.RateLimit{Rate: *archiveRate, Burst: *archiveBurst},
+		ExemptLocalhost: !*limitLocalhost,
+	})
+	if *tlsCert != "" {
+		tlsCfg, err := dex.LoadTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			panic(err)
+		}
+		server.SetTLS(tlsCfg, *requireClientCert)
+	}
+	if *authTokenFile != "" {
+		b, err := ioutil.ReadFile(*authTokenFile)
+		if err != nil {
+			panic(err)
+		}
+
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			panic("the RPC auth token file is empty")
+		}
+		server.SetAuth(token, strings.Split(*authMethods, ","))
+	}
+	if *corsOrigins != "" {
+		server.SetCORS(dex.CORSConfig{AllowedOrigins: strings.Split(*corsOrigins, ",")})
+	}
+	if *adminRPC {
+		server.EnableAdmin()
+	}
+	if *adminService {
+		server.EnableAdminService(n, *peerBanDuration)
+	}
 	err = server.Start(*rpcAddr)
 	if err != nil {
 		log15.Warn("can not start wallet service", "err", err)
 	}
 
+	if *grpcAddr != "" {
+		_, err = grpc.Start(server, *grpcAddr)
+		if err != nil {
+			log15.Warn("can not start wallet gRPC service", "err", err)
+		}
+	}
+
 	err = n.Start(*host, *port, *seedNode)
 	if err != nil {
 		log15.Error("can not connect to seed node", "seed", *seedNode, "err", err)
@@ -109,8 +300,27 @@ func main() {
 	}
 
 	pk := credential.SK.MustPK()
-	log15.Info("node info", "addr", pk.Addr(), "member of groups", credential.Groups)
+	log15.Info("node info", "version", version, "addr", pk.Addr(), "member of groups", credential.Groups)
 	n.EndRound(0)
 
-	select {}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	err = n.SavePeers()
+	if err != nil {
+		log15.Error("can not save the known peers", "dir", *dataDir, "err", err)
+	}
+	n.Stop()
+}
+
+// splitList splits the comma separated list, the empty items are
+// dropped.
+func splitList(s string) []string {
+	var r []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			r = append(r, item)
+		}
+	}
+	return r
 }
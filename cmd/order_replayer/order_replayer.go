@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
@@ -10,7 +11,6 @@ import (
 	"log"
 	"math"
 	"math/rand"
-	"net/rpc"
 	"os"
 	"path"
 	"strconv"
@@ -19,38 +19,9 @@ import (
 
 	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/helinwang/dex/pkg/dex"
+	rpcclient "github.com/helinwang/dex/pkg/dex/client"
 )
 
-func getTokens(client *rpc.Client) ([]dex.Token, error) {
-	var tokens dex.TokenState
-	err := client.Call("WalletService.Tokens", 0, &tokens)
-	if err != nil {
-		return nil, err
-	}
-
-	return tokens.Tokens, nil
-}
-
-func txnPoolSize(client *rpc.Client) (int, error) {
-	var size int
-	err := client.Call("WalletService.TxnPoolSize", 0, &size)
-	if err != nil {
-		return 0, err
-	}
-
-	return size, nil
-}
-
-func nonce(client *rpc.Client, addr consensus.Addr) (uint64, error) {
-	var nonce uint64
-	err := client.Call("WalletService.Nonce", addr, &nonce)
-	if err != nil {
-		return 0, err
-	}
-
-	return nonce, nil
-}
-
 func loadCredentials(dir string) ([]dex.Credential, error) {
 	var r []dex.Credential
 	files, err := ioutil.ReadDir(dir)
@@ -94,15 +65,22 @@ func main() {
 	flag.Parse()
 	rand.Seed(time.Now().UnixNano())
 
-	client, err := rpc.DialHTTP("tcp", *addr)
+	ctx := context.Background()
+	client, err := rpcclient.Dial(ctx, *addr, rpcclient.Config{})
+	if err != nil {
+		panic(err)
+	}
+
+	tokens, err := client.Tokens(ctx)
 	if err != nil {
 		panic(err)
 	}
 
-	tokens, err := getTokens(client)
+	status, err := client.ChainStatus(ctx)
 	if err != nil {
 		panic(err)
 	}
+	chainID := status.ChainID
 
 	tokenCache := make(map[string]dex.Token)
 	for _, t := range tokens {
@@ -127,7 +105,7 @@ func main() {
 	s := bufio.NewScanner(f)
 	for s.Scan() {
 	retry:
-		poolSize, err := txnPoolSize(client)
+		poolSize, err := client.TxnPoolSize(ctx)
 		if err != nil {
 			panic(err)
 		}
@@ -186,7 +164,7 @@ func main() {
 
 		n, ok := nonces[credential.PK.Addr()]
 		if !ok {
-			n, err = nonce(client, credential.PK.Addr())
+			n, err = client.Nonce(ctx, credential.PK.Addr())
 			if err != nil {
 				panic(err)
 			}
@@ -200,11 +178,15 @@ func main() {
 			ExpireRound: 0,
 			Market:      dex.MarketSymbol{Base: baseToken.ID, Quote: quoteToken.ID},
 		}
-		txn := dex.MakePlaceOrderTxn(credential.SK, credential.PK.Addr(), t, n)
-		err = client.Call("WalletService.SendTxn", txn, nil)
+		txn := dex.MakePlaceOrderTxn(credential.SK, chainID, credential.PK.Addr(), t, n)
+		r, err := client.SendTxn(ctx, txn)
 		if err != nil {
 			panic(err)
 		}
+
+		if !r.Accepted {
+			panic(fmt.Errorf("txn %v is rejected: %s", r.Hash, r.Reason))
+		}
 		nonces[credential.PK.Addr()]++
 	}
 
@@ -2,13 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"math"
-	"net/rpc"
 	"os"
 	"sort"
 	"strconv"
@@ -18,6 +20,7 @@ import (
 
 	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/helinwang/dex/pkg/dex"
+	rpcclient "github.com/helinwang/dex/pkg/dex/client"
 	"github.com/urfave/cli"
 )
 
@@ -28,25 +31,81 @@ const (
 
 var rpcAddr string
 var credentialPath string
+var txnFee uint64
+var tlsCA, tlsCert, tlsKey string
+var authTokenPath string
+
+// rpcTimeout is the timeout of an RPC call to the node.
+const rpcTimeout = 30 * time.Second
+
+// dial connects to the node's wallet RPC endpoint, over TLS if the
+// CA certificate is set.
+func dial() (*rpcclient.Client, error) {
+	cfg := rpcclient.Config{Timeout: rpcTimeout}
+	if authTokenPath != "" {
+		b, err := ioutil.ReadFile(authTokenPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Token = strings.TrimSpace(string(b))
+	}
+
+	if tlsCA == "" {
+		return rpcclient.Dial(context.Background(), rpcAddr, cfg)
+	}
 
-func nonce(client *rpc.Client, addr consensus.Addr) (uint64, error) {
-	var nonce uint64
-	err := client.Call("WalletService.Nonce", addr, &nonce)
+	b, err := ioutil.ReadFile(tlsCA)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in %s", tlsCA)
 	}
 
-	return nonce, nil
+	cfg.TLS = &tls.Config{RootCAs: pool}
+	if tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLS.Certificates = []tls.Certificate{cert}
+	}
+
+	return rpcclient.Dial(context.Background(), rpcAddr, cfg)
 }
 
-func getTokens(client *rpc.Client) ([]dex.Token, error) {
-	var tokens dex.TokenState
-	err := client.Call("WalletService.Tokens", 0, &tokens)
+func nonce(client *rpcclient.Client, addr consensus.Addr) (uint64, error) {
+	return client.Nonce(context.Background(), addr)
+}
+
+// sendTxn attaches the txn fee set by the --fee flag to the txn and
+// sends it to the node.
+func sendTxn(client *rpcclient.Client, sk dex.SK, chainID consensus.Hash, txn []byte) error {
+	if txnFee > 0 {
+		var err error
+		txn, err = dex.WithFee(txn, sk, chainID, txnFee)
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := client.SendTxn(context.Background(), txn)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if !r.Accepted {
+		return fmt.Errorf("txn %v is rejected: %s", r.Hash, r.Reason)
 	}
 
-	return tokens.Tokens, nil
+	fmt.Println("txn hash:", r.Hash.Hex())
+	return nil
+}
+
+func getTokens(client *rpcclient.Client) ([]dex.Token, error) {
+	return client.Tokens(context.Background())
 }
 
 func parseAddr(accountAddr string) (consensus.Addr, error) {
@@ -96,7 +155,7 @@ func printAccount(c *cli.Context) error {
 		}
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -111,14 +170,26 @@ func printAccount(c *cli.Context) error {
 		idToToken[t.ID] = t.TokenInfo
 	}
 
-	var w dex.WalletState
-	err = client.Call("WalletService.WalletState", addr, &w)
+	w, err := client.WalletState(context.Background(), addr)
 	if err != nil {
 		return err
 	}
 
+	// fetch the pending orders that are not included in the
+	// wallet state
+	for truncated, cursor := w.Truncated, w.Cursor; truncated; {
+		resp, err := client.Orders(context.Background(), dex.OrdersRequest{Addr: addr, After: cursor})
+		if err != nil {
+			return err
+		}
+
+		w.PendingOrders = append(w.PendingOrders, resp.Orders...)
+		truncated, cursor = resp.Truncated, resp.Cursor
+	}
+
 	fmt.Printf("Addr:\n%x\n", addr[:])
-	fmt.Println("\nBalances:")
+	fmt.Printf("\nRound:\n%d\n", w.Round)
+	fmt.Println("\nBalances (frozen as quantity@available round):")
 	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
 	_, err = fmt.Fprintln(tw, "\tSymbol\tAvailable\tPending\tFrozen\t")
 	if err != nil {
@@ -144,7 +215,6 @@ func printAccount(c *cli.Context) error {
 		return err
 	}
 
-	// TODO: sort pending orders by key
 	fmt.Println("\nPending Orders:")
 	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
 	_, err = fmt.Fprintln(tw, "\tID\tMarket\tSide\tPrice\tAmount\tExecuted\tExpiry Block Height\t")
@@ -253,7 +323,7 @@ func sendToken(c *cli.Context) error {
 		return err
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -284,8 +354,13 @@ func sendToken(c *cli.Context) error {
 		return err
 	}
 
-	txn := dex.MakeSendTokenTxn(credential.SK, credential.PK.Addr(), pk, tokenID, uint64(quant*mul), n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	chainID, err := getChainID(client)
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeSendTokenTxn(credential.SK, chainID, credential.PK.Addr(), pk, tokenID, uint64(quant*mul), n)
+	err = sendTxn(client, credential.SK, chainID, txn)
 	if err != nil {
 		return err
 	}
@@ -294,7 +369,7 @@ func sendToken(c *cli.Context) error {
 }
 
 func listToken(c *cli.Context) error {
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -305,7 +380,7 @@ func listToken(c *cli.Context) error {
 	}
 
 	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
-	_, err = fmt.Fprintln(tw, "\tSymbol\tTotal Supply\tDecimals\t")
+	_, err = fmt.Fprintln(tw, "\tSymbol\tTotal Supply\tMax Supply\tDecimals\t")
 	if err != nil {
 		return err
 	}
@@ -313,7 +388,11 @@ func listToken(c *cli.Context) error {
 	for _, t := range tokens {
 		decimals := int(t.Decimals)
 		supply := quantToStr(t.TotalUnits, decimals)
-		_, err = fmt.Fprintf(tw, "\t%s\t%s\t%d\t\n", string(t.Symbol), supply, decimals)
+		maxSupply := supply
+		if t.MaxSupply > 0 {
+			maxSupply = quantToStr(t.MaxSupply, decimals)
+		}
+		_, err = fmt.Fprintf(tw, "\t%s\t%s\t%s\t%d\t\n", string(t.Symbol), supply, maxSupply, decimals)
 		if err != nil {
 			return err
 		}
@@ -327,7 +406,7 @@ func listToken(c *cli.Context) error {
 }
 
 func printStatus(c *cli.Context) error {
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -413,6 +492,23 @@ func printStatus(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+
+	stats, err := client.PoolStats(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Transaction pool")
+	fmt.Printf("size: %d txns, %d bytes, admitted: %d (%.2f/s), replaced: %d, evicted: %d, expired: %d\n", stats.Count, stats.Bytes, stats.Admitted, stats.AdmissionRate, stats.Replaced, stats.Evicted, stats.Expired)
+	fmt.Printf("time to inclusion p50: %v, p90: %v, p99: %v\n", stats.InclusionP50, stats.InclusionP90, stats.InclusionP99)
+	reasons := make([]string, 0, len(stats.Rejected))
+	for reason := range stats.Rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("rejected %s: %d\n", reason, stats.Rejected[reason])
+	}
 	return nil
 }
 
@@ -434,8 +530,17 @@ func issueToken(c *cli.Context) error {
 	}
 
 	units := supply * uint64(math.Pow10(int(decimals)))
+	var maxUnits uint64
+	if len(args) > 3 {
+		maxSupply, err := strconv.ParseUint(args[3], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		maxUnits = maxSupply * uint64(math.Pow10(int(decimals)))
+	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -467,10 +572,16 @@ func issueToken(c *cli.Context) error {
 		Symbol:     dex.TokenSymbol(symbol),
 		Decimals:   uint8(decimals),
 		TotalUnits: units,
+		MaxSupply:  maxUnits,
 	}
 
-	txn := dex.MakeIssueTokenTxn(credential.SK, credential.PK.Addr(), tokenInfo, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	chainID, err := getChainID(client)
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeIssueTokenTxn(credential.SK, chainID, credential.PK.Addr(), tokenInfo, n)
+	err = sendTxn(client, credential.SK, chainID, txn)
 	if err != nil {
 		return err
 	}
@@ -478,24 +589,45 @@ func issueToken(c *cli.Context) error {
 	return nil
 }
 
-func chainStatus(client *rpc.Client) (consensus.ChainStatus, error) {
-	var state consensus.ChainStatus
-	err := client.Call("WalletService.ChainStatus", 0, &state)
+// getChainID returns the ID of the chain that the txns are signed
+// for.
+func getChainID(client *rpcclient.Client) (consensus.Hash, error) {
+	s, err := chainStatus(client)
 	if err != nil {
-		return state, err
+		return consensus.Hash{}, err
 	}
 
-	return state, nil
+	return s.ChainID, nil
+}
+
+func chainStatus(client *rpcclient.Client) (consensus.ChainStatus, error) {
+	return client.ChainStatus(context.Background())
 }
 
 func printGraphviz(c *cli.Context) error {
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	var req dex.GraphvizRequest
+	args := c.Args()
+	if len(args) > 0 {
+		max, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		req.MaxFinalized = max
+	}
+
+	if len(args) > 1 {
+		if args[1] != "weights" {
+			return fmt.Errorf("unknown argument %q, please check usage using ./wallet -h", args[1])
+		}
+		req.ShowWeights = true
+	}
+
+	client, err := dial()
 	if err != nil {
 		return err
 	}
 
-	var graph string
-	err = client.Call("WalletService.Graphviz", 0, &graph)
+	graph, err := client.Graphviz(context.Background(), req)
 	if err != nil {
 		return err
 	}
@@ -521,7 +653,7 @@ func burnToken(c *cli.Context) error {
 		return fmt.Errorf("error parse freeze token amount: %v", err)
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -553,8 +685,76 @@ func burnToken(c *cli.Context) error {
 	}
 
 	t := dex.BurnTokenTxn{ID: tokenID, Quant: uint64(quant * mul)}
-	txn := dex.MakeBurnTokenTxn(credential.SK, credential.PK.Addr(), t, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	chainID, err := getChainID(client)
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeBurnTokenTxn(credential.SK, chainID, credential.PK.Addr(), t, n)
+	err = sendTxn(client, credential.SK, chainID, txn)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func mintToken(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("mint token needs 2 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	symbol := args[0]
+	quant, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("error parse mint token amount: %v", err)
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	var tokenID dex.TokenID
+	var mul float64
+	found := false
+	for _, t := range tokens {
+		if strings.ToLower(string(t.Symbol)) == strings.ToLower(symbol) {
+			tokenID = t.ID
+			mul = math.Pow10(int(t.Decimals))
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("symbol not found: %s", symbol)
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.MintTokenTxn{ID: tokenID, Quant: uint64(quant * mul)}
+	chainID, err := getChainID(client)
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeMintTokenTxn(credential.SK, chainID, credential.PK.Addr(), t, n)
+	err = sendTxn(client, credential.SK, chainID, txn)
 	if err != nil {
 		return err
 	}
@@ -583,7 +783,7 @@ func freezeToken(c *cli.Context) error {
 		return fmt.Errorf("error parse freeze token available height: %v", err)
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -615,8 +815,13 @@ func freezeToken(c *cli.Context) error {
 	}
 
 	t := dex.FreezeTokenTxn{TokenID: tokenID, AvailableRound: availableHeight, Quant: uint64(quant * mul)}
-	txn := dex.MakeFreezeTokenTxn(credential.SK, credential.PK.Addr(), t, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	chainID, err := getChainID(client)
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeFreezeTokenTxn(credential.SK, chainID, credential.PK.Addr(), t, n)
+	err = sendTxn(client, credential.SK, chainID, txn)
 	if err != nil {
 		return err
 	}
@@ -637,7 +842,7 @@ func cancelOrder(c *cli.Context) error {
 		return err
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -647,8 +852,13 @@ func cancelOrder(c *cli.Context) error {
 		return err
 	}
 
-	txn := dex.MakeCancelOrderTxn(credential.SK, credential.PK.Addr(), id, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	chainID, err := getChainID(client)
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeCancelOrderTxn(credential.SK, chainID, credential.PK.Addr(), id, n)
+	err = sendTxn(client, credential.SK, chainID, txn)
 	if err != nil {
 		return err
 	}
@@ -696,7 +906,7 @@ func placeOrder(c *cli.Context) error {
 		return fmt.Errorf("parse expiry time error: %v", err)
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -750,8 +960,13 @@ func placeOrder(c *cli.Context) error {
 		ExpireRound: expireRound,
 		Market:      market,
 	}
-	txn := dex.MakePlaceOrderTxn(credential.SK, credential.PK.Addr(), placeOrderTxn, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	chainID, err := getChainID(client)
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakePlaceOrderTxn(credential.SK, chainID, credential.PK.Addr(), placeOrderTxn, n)
+	err = sendTxn(client, credential.SK, chainID, txn)
 	if err != nil {
 		return err
 	}
@@ -776,6 +991,31 @@ func main() {
 			Usage:       "node's wallet RPC endpoint",
 			Destination: &rpcAddr,
 		},
+		cli.StringFlag{
+			Name:        "tls-ca",
+			Usage:       "path to the PEM encoded CA certificate of the node's TLS endpoint, empty connects in plain HTTP",
+			Destination: &tlsCA,
+		},
+		cli.StringFlag{
+			Name:        "tls-cert",
+			Usage:       "path to the PEM encoded client certificate, required by the nodes that verify the clients sending txns",
+			Destination: &tlsCert,
+		},
+		cli.StringFlag{
+			Name:        "tls-key",
+			Usage:       "path to the PEM encoded client key",
+			Destination: &tlsKey,
+		},
+		cli.StringFlag{
+			Name:        "auth-token-file",
+			Usage:       "path to the file of the bearer token required by the nodes that authenticate the clients sending txns",
+			Destination: &authTokenPath,
+		},
+		cli.Uint64Flag{
+			Name:        "fee",
+			Usage:       "fee in the smallest unit of BNB paid to the block proposer for each txn",
+			Destination: &txnFee,
+		},
 	}
 
 	app.Commands = []cli.Command{
@@ -786,7 +1026,7 @@ func main() {
 		},
 		{
 			Name:   "graphviz",
-			Usage:  "Print the chain visualization in graphviz format, please go to http://www.webgraphviz.com/ for visualization",
+			Usage:  "Print the chain visualization in graphviz format, please go to http://www.webgraphviz.com/ for visualization: ./wallet graphviz [MAX_FINALIZED] [weights] (MAX_FINALIZED is the number of the finalized blocks shown, weights labels the blocks with their weights)",
 			Action: printGraphviz,
 		},
 		{
@@ -796,7 +1036,7 @@ func main() {
 		},
 		{
 			Name:   "issue_token",
-			Usage:  "Issue new token: ./wallet issue_token SYMBOL TOTAL_SUPPLY DECIMALS",
+			Usage:  "Issue new token: ./wallet issue_token SYMBOL TOTAL_SUPPLY DECIMALS [MAX_SUPPLY] (MAX_SUPPLY is the cap for minting, omit it for a fixed supply)",
 			Action: issueToken,
 		},
 		{
@@ -829,6 +1069,11 @@ func main() {
 			Usage:  "Burn token: ./wallet -c NODE_CREDENTIAL_FILE_PATH burn SYMBOL AMOUNT",
 			Action: burnToken,
 		},
+		{
+			Name:   "mint",
+			Usage:  "Mint token up to its max supply, only the issuer can mint: ./wallet -c NODE_CREDENTIAL_FILE_PATH mint SYMBOL AMOUNT",
+			Action: mintToken,
+		},
 	}
 
 	err := app.Run(os.Args)
@@ -0,0 +1,285 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// ErrBanNotFound is returned when removing a ban that does not exist.
+var ErrBanNotFound = errors.New("ban not found")
+
+// banFileName is the name of the ban file in Config.DataDir, next to
+// the peer file.
+const banFileName = "bans"
+
+// Ban refuses the connections of a peer ID, or of the hosts in an IP
+// network. Exactly one of ID and Net is set.
+type Ban struct {
+	ID Addr
+	// an IP, or a network in the CIDR notation such as
+	// 10.0.0.0/24.
+	Net string
+	// the zero time never expires
+	Until  time.Time
+	Reason string
+}
+
+func (b Ban) expired(now time.Time) bool {
+	return !b.Until.IsZero() && now.After(b.Until)
+}
+
+// netBan is a ban of the hosts in an IP network.
+type netBan struct {
+	Ban
+	ipNet *net.IPNet
+}
+
+// parseBanNet parses an IP or a network in the CIDR notation, an IP
+// is the network of the single IP.
+func parseBanNet(s string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err == nil {
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP or CIDR network %q", s)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}, nil
+}
+
+// setBanFile sets the file where the bans are saved, they are loaded
+// by Start. Empty disables saving.
+func (n *network) setBanFile(path string) {
+	n.mu.Lock()
+	n.banFile = path
+	n.mu.Unlock()
+}
+
+// AddBan closes the connections of the banned peer or hosts, and
+// refuses to connect with them until the ban expires. The ban
+// replaces the previous ban of the same ID or network, and is saved
+// to the ban file.
+func (n *network) AddBan(b Ban) error {
+	n.mu.Lock()
+	err := n.addBan(b)
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return n.saveBans()
+}
+
+// addBan adds the ban, the caller must hold n.mu.
+func (n *network) addBan(b Ban) error {
+	if (b.ID == Addr{}) == (b.Net == "") {
+		return errors.New("exactly one of the ID and the network of a ban must be set")
+	}
+
+	if b.ID != (Addr{}) {
+		n.banned[b.ID] = b
+		for _, c := range n.conns {
+			if c.id == b.ID && c.observer {
+				n.bannedHosts[c.host] = b.Until
+			}
+		}
+		dropped := n.dropPeer(b.ID, false)
+		log.Info("peer banned", "id", b.ID, "until", b.Until, "reason", b.Reason, "dropped connections", dropped)
+		return nil
+	}
+
+	ipNet, err := parseBanNet(b.Net)
+	if err != nil {
+		return err
+	}
+
+	b.Net = ipNet.String()
+	n.bannedNets[b.Net] = &netBan{Ban: b, ipNet: ipNet}
+	dropped := 0
+	for addr, c := range n.conns {
+		ip := net.ParseIP(c.host)
+		if ip == nil || !ipNet.Contains(ip) {
+			continue
+		}
+
+		delete(n.conns, addr)
+		delete(n.known, addr)
+		c.Close()
+		dropped++
+	}
+	log.Info("network banned", "net", b.Net, "until", b.Until, "reason", b.Reason, "dropped connections", dropped)
+	return nil
+}
+
+// RemoveBan removes the ban of the ID or the network, it returns
+// ErrBanNotFound if there is none.
+func (n *network) RemoveBan(b Ban) error {
+	n.mu.Lock()
+	removed := false
+	if b.ID != (Addr{}) {
+		_, removed = n.banned[b.ID]
+		delete(n.banned, b.ID)
+	}
+
+	if b.Net != "" {
+		ipNet, err := parseBanNet(b.Net)
+		if err != nil {
+			n.mu.Unlock()
+			return err
+		}
+
+		_, ok := n.bannedNets[ipNet.String()]
+		removed = removed || ok
+		delete(n.bannedNets, ipNet.String())
+	}
+	n.mu.Unlock()
+
+	if !removed {
+		return ErrBanNotFound
+	}
+	return n.saveBans()
+}
+
+// Bans returns the bans not expired, the bans of the IDs first.
+func (n *network) Bans() []Ban {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.banList()
+}
+
+// banList returns the bans not expired and purges the expired ones,
+// the caller must hold n.mu.
+func (n *network) banList() []Ban {
+	now := time.Now()
+	bans := make([]Ban, 0, len(n.banned)+len(n.bannedNets))
+	for id, b := range n.banned {
+		if b.expired(now) {
+			delete(n.banned, id)
+			continue
+		}
+		bans = append(bans, b)
+	}
+
+	for key, b := range n.bannedNets {
+		if b.expired(now) {
+			delete(n.bannedNets, key)
+			continue
+		}
+		bans = append(bans, b.Ban)
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].Net != bans[j].Net {
+			return bans[i].Net < bans[j].Net
+		}
+		return bytes.Compare(bans[i].ID[:], bans[j].ID[:]) < 0
+	})
+	return bans
+}
+
+// isBannedHost returns true if the host is in a banned network, the
+// caller must hold n.mu.
+func (n *network) isBannedHost(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	now := time.Now()
+	for key, b := range n.bannedNets {
+		if b.expired(now) {
+			delete(n.bannedNets, key)
+			continue
+		}
+
+		if b.ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveBans saves the bans to the ban file.
+func (n *network) saveBans() error {
+	n.banFileMu.Lock()
+	defer n.banFileMu.Unlock()
+
+	n.mu.Lock()
+	path := n.banFile
+	bans := n.banList()
+	n.mu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(bans)
+	if err != nil {
+		return err
+	}
+
+	// replace the file at once, so that a crash does not leave a
+	// partially written file.
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadBans loads the bans from the ban file, the expired bans are
+// dropped. A missing file has no bans.
+func (n *network) loadBans() error {
+	n.mu.Lock()
+	path := n.banFile
+	n.mu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var bans []Ban
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&bans)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, b := range bans {
+		if b.expired(now) {
+			continue
+		}
+
+		err = n.addBan(b)
+		if err != nil {
+			log.Warn("invalid ban in the ban file", "file", path, "err", err)
+		}
+	}
+	return nil
+}
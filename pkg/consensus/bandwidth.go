@@ -0,0 +1,272 @@
+package consensus
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// defaultBandwidthWindow is the default window of the peer traffic
+// rates and the outbound budget.
+const defaultBandwidthWindow = time.Minute
+
+// the names of the msgTypes
+var msgTypeNames = map[msgType]string{
+	txnType:                "txn",
+	randBeaconSigType:      "rand_beacon_sig",
+	randBeaconSigShareType: "rand_beacon_sig_share",
+	blockType:              "block",
+	blockProposalType:      "block_proposal",
+	ntShareType:            "nt_share",
+	itemMsgType:            "item",
+	itemRequestType:        "item_request",
+	connectRequestType:     "connect_request",
+	addrsType:              "addrs",
+	ackType:                "ack",
+	pingType:               "ping",
+	pongType:               "pong",
+	peerRejectionType:      "peer_rejection",
+	helloType:              "hello",
+	requestType:            "request",
+	responseType:           "response",
+	inventoryType:          "inventory",
+	getDataType:            "get_data",
+	disconnectType:         "disconnect",
+	snapshotManifestType:   "snapshot_manifest",
+	snapshotChunkType:      "snapshot_chunk",
+	truncatedGetDataType:   "truncated_get_data",
+}
+
+func (t msgType) String() string {
+	if name, ok := msgTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// msgName returns the name of the message type of the packet data,
+// the same for both the gob and the RLP encoding.
+func msgName(data interface{}) string {
+	return wireTypeOf[reflect.TypeOf(data)].String()
+}
+
+// MsgTraffic is the traffic of a message type.
+type MsgTraffic struct {
+	MsgsIn   uint64
+	MsgsOut  uint64
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// PeerTraffic is the traffic of a peer.
+type PeerTraffic struct {
+	// Msgs is the cumulative traffic by the message types, the
+	// bytes include the frame headers.
+	Msgs map[string]MsgTraffic
+	// RateIn and RateOut are the bytes per second over the last
+	// bandwidth window.
+	RateIn  float64
+	RateOut float64
+	// Deferred is the number of the low-priority responses
+	// deferred since the peer exceeded its outbound budget.
+	Deferred uint64
+	// Dropped is the number of the packets dropped from the full
+	// send queue to the peer, see sendQueue.
+	Dropped uint64
+	// Throttled is the number of the messages from the peer
+	// dropped over the rate limits of their types, see RateLimit.
+	Throttled uint64
+	// Queued is the number of the packets waiting in the send
+	// queue to the peer by their classes, the higher classes are
+	// written first.
+	Queued map[string]int
+}
+
+// trafficStats counts the traffic of a connection at the framing
+// layer. The bytes are also counted in fixed windows, the rates are
+// estimated from the current and the previous windows.
+type trafficStats struct {
+	deferred  uint64
+	dropped   uint64
+	throttled uint64
+	// only counted by the network's traffic, see NetworkMetrics
+	handshakeFailures uint64
+	decodeErrors      uint64
+	// the network's traffic the connection's traffic is counted
+	// in too, nil if it is not.
+	total *trafficStats
+
+	mu     sync.Mutex
+	window time.Duration
+	msgs   map[string]*MsgTraffic
+	start  time.Time
+	// the bytes in and out of the current and the previous
+	// windows
+	cur, prev [2]uint64
+}
+
+func newTrafficStats() *trafficStats {
+	return &trafficStats{
+		window: defaultBandwidthWindow,
+		msgs:   make(map[string]*MsgTraffic),
+		start:  time.Now(),
+	}
+}
+
+// setWindow sets the length of the windows.
+func (s *trafficStats) setWindow(d time.Duration) {
+	s.mu.Lock()
+	s.window = d
+	s.mu.Unlock()
+}
+
+// roll moves to the window of now, the caller must hold s.mu.
+func (s *trafficStats) roll(now time.Time) {
+	k := now.Sub(s.start) / s.window
+	if k <= 0 {
+		return
+	}
+
+	if k == 1 {
+		s.prev = s.cur
+	} else {
+		s.prev = [2]uint64{}
+	}
+	s.cur = [2]uint64{}
+	s.start = s.start.Add(k * s.window)
+}
+
+func (s *trafficStats) msg(name string) *MsgTraffic {
+	m, ok := s.msgs[name]
+	if !ok {
+		m = &MsgTraffic{}
+		s.msgs[name] = m
+	}
+	return m
+}
+
+// received counts a received frame of the message type.
+func (s *trafficStats) received(name string, size int) {
+	s.mu.Lock()
+	s.roll(time.Now())
+	m := s.msg(name)
+	m.MsgsIn++
+	m.BytesIn += uint64(size)
+	s.cur[0] += uint64(size)
+	s.mu.Unlock()
+
+	if s.total != nil {
+		s.total.received(name, size)
+	}
+}
+
+// sent counts a sent frame of the message type.
+func (s *trafficStats) sent(name string, size int) {
+	s.mu.Lock()
+	s.roll(time.Now())
+	m := s.msg(name)
+	m.MsgsOut++
+	m.BytesOut += uint64(size)
+	s.cur[1] += uint64(size)
+	s.mu.Unlock()
+
+	if s.total != nil {
+		s.total.sent(name, size)
+	}
+}
+
+// decodeFailed counts a frame failed to decode.
+func (s *trafficStats) decodeFailed() {
+	atomic.AddUint64(&s.decodeErrors, 1)
+	if s.total != nil {
+		s.total.decodeFailed()
+	}
+}
+
+// windowOut returns the bytes sent in the current window, and when
+// the next window starts.
+func (s *trafficStats) windowOut() (uint64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.roll(time.Now())
+	return s.cur[1], s.start.Add(s.window)
+}
+
+// snapshot returns the traffic so far.
+func (s *trafficStats) snapshot() PeerTraffic {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.roll(now)
+	t := PeerTraffic{
+		Msgs:      make(map[string]MsgTraffic, len(s.msgs)),
+		Deferred:  atomic.LoadUint64(&s.deferred),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+		Throttled: atomic.LoadUint64(&s.throttled),
+	}
+	for name, m := range s.msgs {
+		t.Msgs[name] = *m
+	}
+
+	// the previous window is weighted by its part still in the
+	// sliding window ending now.
+	w := s.window.Seconds()
+	prevWeight := 1 - now.Sub(s.start).Seconds()/w
+	t.RateIn = (float64(s.prev[0])*prevWeight + float64(s.cur[0])) / w
+	t.RateOut = (float64(s.prev[1])*prevWeight + float64(s.cur[1])) / w
+	return t
+}
+
+// setBandwidth sets the window of the peer traffic rates, and the
+// outbound bytes per window after which the low-priority responses
+// to a peer are deferred, 0 means unlimited. The window of 0 uses
+// the default.
+func (n *network) setBandwidth(window time.Duration, outboundBudget uint64) {
+	if window <= 0 {
+		window = defaultBandwidthWindow
+	}
+
+	n.mu.Lock()
+	n.bandwidthWindow = window
+	n.outboundBudget = outboundBudget
+	for _, c := range n.conns {
+		c.traffic.setWindow(window)
+	}
+	n.mu.Unlock()
+}
+
+// waitBudget waits until the peer has the outbound budget for a
+// low-priority response, it returns false if the peer is gone.
+func (n *network) waitBudget(addr unicastAddr) bool {
+	deferred := false
+	for {
+		n.mu.Lock()
+		c, ok := n.conns[addr]
+		budget := n.outboundBudget
+		n.mu.Unlock()
+		if !ok {
+			return false
+		}
+
+		if budget == 0 {
+			return true
+		}
+
+		out, next := c.traffic.windowOut()
+		if out < budget {
+			return true
+		}
+
+		if !deferred {
+			deferred = true
+			atomic.AddUint64(&c.traffic.deferred, 1)
+			log.Debug("deferred the response to the peer over the outbound budget", "addr", addr.Addr, "bytes", out)
+		}
+		time.Sleep(time.Until(next))
+	}
+}
@@ -27,7 +27,11 @@ type blockNode struct {
 
 // ChainStatus is the chain consensus state.
 type ChainStatus struct {
+	// ChainID is the hash of the genesis block, the txns are
+	// signed for it.
+	ChainID         Hash
 	Round           uint64
+	FinalizedRound  uint64
 	RandBeaconDepth uint64
 	RoundMetrics    []RoundMetric
 }
@@ -70,6 +74,14 @@ type Updater interface {
 	Update(s State)
 }
 
+// Finalizer is implemented by the Updater that wants to know the
+// finalized states. Finalized is called once for each finalized
+// round in the round order, with the chain locked, so it must not
+// block or call the chain.
+type Finalizer interface {
+	Finalized(round uint64, s State)
+}
+
 // NewChain creates a new chain.
 func NewChain(genesis *Block, genesisState State, seed Rand, cfg Config, txnPool TxnPool, u Updater, store *storage, proposerPK []byte) *Chain {
 	if genesisState.Hash() != genesis.StateRoot {
@@ -118,7 +130,9 @@ func (c *Chain) ChainStatus() ChainStatus {
 	defer c.mu.Unlock()
 
 	s := ChainStatus{}
+	s.ChainID = c.finalized[0]
 	s.Round = c.round()
+	s.FinalizedRound = uint64(len(c.finalized) - 1)
 	s.RandBeaconDepth = c.randomBeacon.Round()
 	s.RoundMetrics = make([]RoundMetric, len(c.roundMetrics))
 	copy(s.RoundMetrics, c.roundMetrics)
@@ -149,6 +163,18 @@ func (c *Chain) WaitUntil(round uint64) {
 	<-ch
 }
 
+// waitTxns returns when the txn pool is not empty or ctx is done.
+func (c *Chain) waitTxns(ctx context.Context) {
+	ch := c.txnPool.Notify()
+	for c.txnPool.Size() == 0 {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // ProposeBlock proposes a new block proposal.
 func (c *Chain) ProposeBlock(ctx context.Context, sk SK, round uint64) *BlockProposal {
 	txns := c.txnPool.Txns()
@@ -304,6 +330,49 @@ func (c *Chain) BlockState(h Hash) State {
 	return c.blockState(h)
 }
 
+// Block returns the block given the block's hash, nil if the block
+// is unknown, and whether the block is finalized.
+func (c *Chain) Block(h Hash) (*Block, bool) {
+	b := c.store.Block(h)
+	if b == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return b, b.Round < uint64(len(c.finalized)) && c.finalized[b.Round] == h
+}
+
+// BlockAtRound returns the finalized block of the round, or the
+// block of the round on the heaviest fork if the round is not
+// finalized. It returns nil if there is no such block, the bool
+// tells whether the block is finalized.
+func (c *Chain) BlockAtRound(round uint64) (*Block, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if round < uint64(len(c.finalized)) {
+		return c.store.Block(c.finalized[round]), true
+	}
+
+	b, _, _ := c.leader()
+	for b != nil && b.Round > round {
+		b = c.store.Block(b.PrevBlock)
+	}
+
+	if b == nil || b.Round != round {
+		return nil, false
+	}
+
+	return b, false
+}
+
+// BlockProposal returns the block proposal given its hash, nil if
+// the block proposal is unknown.
+func (c *Chain) BlockProposal(h Hash) *BlockProposal {
+	return c.store.BlockProposal(h)
+}
+
 func (c *Chain) blockState(h Hash) State {
 	if h == c.finalized[len(c.finalized)-1] {
 		return c.lastFinalizedState
@@ -384,6 +453,7 @@ func (c *Chain) AddBlock(b *Block, s State, weight float64, txnCount int) (bool,
 		c.lastEndRoundTime = now
 
 		go c.n.EndRound(startingRound)
+		go c.txnPool.AdvanceRound(round)
 		if ch, ok := c.roundWaitCh[round]; ok {
 			close(ch)
 			delete(c.roundWaitCh, round)
@@ -487,6 +557,9 @@ func (c *Chain) finalize(round uint64) {
 	c.finalized = append(c.finalized, root.Block)
 	c.lastFinalizedState = c.unFinalizedState[root.Block]
 	delete(c.unFinalizedState, root.Block)
+	if f, ok := c.updater.(Finalizer); ok {
+		f.Finalized(count, c.lastFinalizedState)
+	}
 	c.fork = root.blockChildren
 
 	for i := range c.fork {
@@ -496,17 +569,26 @@ func (c *Chain) finalize(round uint64) {
 	// TODO: delete the state/block/bp of the removed branches from the map
 }
 
+// GraphvizOptions are the options of the chain visualization.
+type GraphvizOptions struct {
+	// only MaxFinalized number of finalized blocks will be
+	// shown, the rest will be hidden to save graph space. 0
+	// shows all of them.
+	MaxFinalized int
+	// label the not finalized blocks with their weights and the
+	// weights of their forks.
+	ShowWeights bool
+}
+
 // Graphviz returns the Graphviz format encoded chain visualization.
-//
-// only maxFinalized number of blocks will be shown, the rest will be
-// hidden to save graph space.
-func (c *Chain) Graphviz(maxFinalized int) string {
+func (c *Chain) Graphviz(opts GraphvizOptions) string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.graphviz(maxFinalized)
+	return c.graphviz(opts)
 }
 
-func (c *Chain) graphviz(maxFinalized int) string {
+func (c *Chain) graphviz(opts GraphvizOptions) string {
+	maxFinalized := opts.MaxFinalized
 
 	const (
 		arrow = " -> "
@@ -530,7 +612,11 @@ size="12,8"`
 	omitted := len(finalizedSlice) - maxFinalized
 	if maxFinalized > 0 && len(finalizedSlice) > maxFinalized {
 		dotIdx = maxFinalized / 2
-		finalizedSlice = append(finalizedSlice[:dotIdx], finalizedSlice[len(finalizedSlice)-(maxFinalized-dotIdx):]...)
+		// copy rather than append to c.finalized[:dotIdx],
+		// which would overwrite the finalized blocks.
+		shown := make([]Hash, 0, maxFinalized)
+		shown = append(shown, finalizedSlice[:dotIdx]...)
+		finalizedSlice = append(shown, finalizedSlice[len(finalizedSlice)-(maxFinalized-dotIdx):]...)
 	}
 
 	for i, f := range finalizedSlice {
@@ -554,9 +640,23 @@ size="12,8"`
 	graph += "\n"
 
 	graph, notFinalized = graphUpdateBlock(c.fork, start, graph, notFinalized)
+	if opts.ShowWeights {
+		graph = graphWeights(c.fork, graph)
+	}
 	return strings.Join([]string{begin, finalized, notFinalized, graph, end}, "\n")
 }
 
+// graphWeights labels the blocks with their weights and the
+// weights of the forks ending at them.
+func graphWeights(ns []*blockNode, graph string) string {
+	for _, u := range ns {
+		str := fmt.Sprintf("block_%x", u.Block[:2])
+		graph += fmt.Sprintf("%s [label=\"%s\\nweight %g\\nfork weight %g\"];\n", str, str, u.Weight, weight(u))
+		graph = graphWeights(u.blockChildren, graph)
+	}
+	return graph
+}
+
 func graphUpdateBlock(ns []*blockNode, start, graph, block string) (string, string) {
 	for _, u := range ns {
 		str := fmt.Sprintf("block_%x", u.Block[:2])
@@ -1,7 +1,9 @@
 package consensus
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -23,9 +25,30 @@ func (s *myState) CommitCache() {
 }
 
 func (s *myState) Transition(uint64, []byte) Transition {
+	return &myTransition{}
+}
+
+type myTransition struct {
+	txns []byte
+}
+
+func (t *myTransition) Record(txn *Txn) error {
+	t.txns = append(t.txns, txn.Raw...)
 	return nil
 }
 
+func (t *myTransition) Txns() []byte {
+	return t.txns
+}
+
+func (t *myTransition) Commit() State {
+	return &myState{}
+}
+
+func (t *myTransition) StateHash() Hash {
+	return Hash{}
+}
+
 func (s *myState) Serialize() (TrieBlob, error) {
 	return TrieBlob{}, nil
 }
@@ -67,7 +90,17 @@ block_0400 -> block_0c00
 block_0c00 -> block_0d00
 
 }
-`, chain.Graphviz(0))
+`, chain.Graphviz(GraphvizOptions{}))
+
+	fork0.Weight = 1
+	fork01.Weight = 2
+	fork01.parent = fork0
+	g := chain.Graphviz(GraphvizOptions{MaxFinalized: 2, ShowWeights: true})
+	assert.Contains(t, g, "block_26df -> num_blocks_omitted_to_save_space_3 -> block_0400\n")
+	assert.Contains(t, g, `block_0700 [label="block_0700\nweight 1\nfork weight 1"];`+"\n")
+	assert.Contains(t, g, `block_0800 [label="block_0800\nweight 2\nfork weight 3"];`+"\n")
+	// the omitted blocks are not removed from the chain
+	assert.Equal(t, []Hash{{1}, {2}, {3}, {4}}, chain.finalized[1:])
 }
 
 func TestForkTraversal(t *testing.T) {
@@ -118,3 +151,73 @@ func TestHeaviestFork(t *testing.T) {
 	assert.Equal(t, n1, r)
 	assert.Equal(t, 4, maxHeight(fork))
 }
+
+func TestBlockAtRound(t *testing.T) {
+	store := newStorage()
+	genesis := &Block{}
+	chain := NewChain(genesis, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, store, nil)
+
+	add := func(b *Block) Hash {
+		h := b.Hash()
+		store.AddBlock(b, h)
+		return h
+	}
+
+	h1 := add(&Block{Round: 1, PrevBlock: genesis.Hash()})
+	chain.finalized = append(chain.finalized, h1)
+	h2a := add(&Block{Round: 2, PrevBlock: h1, StateRoot: Hash{1}})
+	h2b := add(&Block{Round: 2, PrevBlock: h1, StateRoot: Hash{2}})
+	h3 := add(&Block{Round: 3, PrevBlock: h2b})
+	n2b := &blockNode{Block: h2b, Weight: 2}
+	n3 := &blockNode{Block: h3, Weight: 1, parent: n2b}
+	n2b.blockChildren = []*blockNode{n3}
+	chain.fork = []*blockNode{{Block: h2a, Weight: 1}, n2b}
+
+	b, finalized := chain.BlockAtRound(1)
+	assert.Equal(t, h1, b.Hash())
+	assert.True(t, finalized)
+
+	b, finalized = chain.BlockAtRound(2)
+	assert.Equal(t, h2b, b.Hash())
+	assert.False(t, finalized)
+
+	b, _ = chain.BlockAtRound(4)
+	assert.Nil(t, b)
+
+	b, finalized = chain.Block(h2a)
+	assert.Equal(t, uint64(2), b.Round)
+	assert.False(t, finalized)
+	_, finalized = chain.Block(genesis.Hash())
+	assert.True(t, finalized)
+	b, _ = chain.Block(Hash{9})
+	assert.Nil(t, b)
+}
+
+func TestProposeBlockWaitTxns(t *testing.T) {
+	pool := &myTxnPool{notify: make(chan struct{}, 1)}
+	chain := NewChain(&Block{}, &myState{}, Rand{}, Config{}, pool, &myUpdater{}, newStorage(), nil)
+	sk := Rand(SHA3([]byte{1})).SK()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pool.Add([]byte{1, 2, 3})
+	}()
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	chain.waitTxns(ctx)
+	assert.True(t, time.Since(start) < time.Second)
+
+	bp := chain.ProposeBlock(context.Background(), sk, 1)
+	assert.Equal(t, []byte{1, 2, 3}, bp.Txns)
+}
+
+func TestWaitTxnsTimeout(t *testing.T) {
+	pool := &myTxnPool{notify: make(chan struct{}, 1)}
+	chain := &Chain{txnPool: pool}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	chain.waitTxns(ctx)
+	assert.Equal(t, 0, pool.Size())
+}
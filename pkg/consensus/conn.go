@@ -1,8 +1,17 @@
 package consensus
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/helinwang/log15"
 )
@@ -21,6 +30,18 @@ func init() {
 	var i []unicastAddr
 	var j ack
 	var k *NtShare
+	var l ping
+	var m pong
+	var o *peerRejection
+	var p *hello
+	var q *request
+	var r *response
+	var s inventory
+	var t getData
+	var u *disconnect
+	var v *snapshotManifest
+	var w *snapshotChunk
+	var x truncatedGetData
 
 	gob.Register(a)
 	gob.Register(b)
@@ -33,42 +54,336 @@ func init() {
 	gob.Register(i)
 	gob.Register(j)
 	gob.Register(k)
+	gob.Register(l)
+	gob.Register(m)
+	gob.Register(o)
+	gob.Register(p)
+	gob.Register(q)
+	gob.Register(r)
+	gob.Register(s)
+	gob.Register(t)
+	gob.Register(u)
+	gob.Register(v)
+	gob.Register(w)
+	gob.Register(x)
 }
 
 type packet struct {
 	Data interface{}
 }
 
+// ping is sent to the peers periodically, the peer replies with a
+// pong carrying the same send time, from which the round trip time
+// is measured.
+type ping struct {
+	Sent uint64
+}
+
+type pong ping
+
+const (
+	// maxMissedPings is the number of the unanswered pings in a
+	// row after which the peer is disconnected.
+	maxMissedPings = 3
+	// rttSmoothing is the inverse of the weight of the newest
+	// pong in the smoothed round trip time.
+	rttSmoothing = 4
+)
+
+// errPeerDead is returned when the peer does not answer the pings.
+var errPeerDead = errors.New("the peer does not answer the pings")
+
 type conn struct {
+	// the bytes read from and written to the connection, the
+	// smoothed round trip time of the pongs in nanoseconds, when
+	// the last packet was received in Unix nanoseconds, and the
+	// pings sent since the last pong. They are accessed
+	// atomically, so they come first to be 64-bit aligned.
+	bytesIn    uint64
+	bytesOut   uint64
+	rtt        int64
+	lastRecv   int64
+	unanswered int32
+
 	conn net.Conn
+	r    io.Reader
+	w    io.Writer
+
+	// the packets are encoded into wbuf and decoded from rbuf a
+	// frame at a time, see frameHeaderSize.
+	wmu  sync.Mutex
+	wbuf bytes.Buffer
 	enc  *gob.Encoder
+	rbuf bytes.Buffer
 	dec  *gob.Decoder
+
+	// set when the connection is registered as a peer
+	inbound     bool
+	connectedAt time.Time
+	// the software version sent by the peer in the hello
+	software string
+	// the protocol version negotiated in the handshake, 0 before
+	// the handshake. It is set before the connection is shared.
+	version uint32
+	// the hash of the hellos signed by both peers in the connect
+	// requests, zero before identityProtocolVersion. The ID
+	// proved by the peer, the host it connects from or is dialed
+	// at, and if it is an observer, see setIdentity.
+	transcript Hash
+	id         Addr
+	host       string
+	observer   bool
+
+	// the requests waiting for their responses by the request
+	// IDs, and the requests of the older protocol versions by
+	// the requested items, see Request.
+	reqMu   sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *response
+	legacy  map[Item][]chan interface{}
+	// closed when the connection is closed, failing the requests
+	// waiting for their responses.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// the traffic by the message types, see trafficStats
+	traffic *trafficStats
+	// the packets waiting to be written, set when the connection
+	// is registered as a peer, see send. writerDone is closed
+	// when the writer stops.
+	sendq      *sendQueue
+	writerDone chan struct{}
+	// limits the messages from the peer, set with sendq.
+	limiter *rateLimiter
+
+	// the number of the items the peer serves from a getData, 0
+	// if the peer does not advertise it, see getDataPage.
+	peerMaxGetData int
+
+	// the item announcements and requests waiting to be sent in
+	// a batch, see batch.
+	batchMu    sync.Mutex
+	announcing []Item
+	requesting []Item
+	flushTimer *time.Timer
 }
 
 func newConn(c net.Conn) *conn {
-	enc := gob.NewEncoder(c)
-	dec := gob.NewDecoder(c)
-	return &conn{
-		enc:  enc,
-		dec:  dec,
-		conn: c,
+	p := &conn{
+		conn:     c,
+		pending:  make(map[uint64]chan *response),
+		legacy:   make(map[Item][]chan interface{}),
+		closed:   make(chan struct{}),
+		traffic:  newTrafficStats(),
+		lastRecv: time.Now().UnixNano(),
 	}
+	p.w = countingWriter{w: c, n: &p.bytesOut}
+	p.r = countingReader{r: c, n: &p.bytesIn}
+	p.enc = gob.NewEncoder(&p.wbuf)
+	p.dec = gob.NewDecoder(&p.rbuf)
+	return p
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *uint64
 }
 
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n *uint64
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+// Write writes the packet in a frame, the packet is RLP encoded if
+// the negotiated protocol version supports it, or gob encoded.
 func (p *conn) Write(pac packet) error {
-	return p.enc.Encode(pac)
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+
+	p.wbuf.Reset()
+	var h [frameHeaderSize]byte
+	p.wbuf.Write(h[:])
+	kind := kindOf(pac.Data)
+	tag := byte(kind)
+	if p.version >= rlpProtocolVersion {
+		t, b, err := encodeWire(pac.Data)
+		if err != nil {
+			return err
+		}
+
+		tag = byte(t)
+		p.wbuf.Write(b)
+	} else {
+		err := p.enc.Encode(pac)
+		if err != nil {
+			return err
+		}
+	}
+
+	b := p.wbuf.Bytes()
+	size := uint32(len(b) - frameHeaderSize)
+	err := checkFrame(kind, size)
+	if err != nil {
+		// the peer would ban the node
+		return err
+	}
+
+	binary.BigEndian.PutUint32(b, size)
+	b[4] = tag
+	_, err = p.w.Write(b)
+	if err != nil {
+		return err
+	}
+
+	p.traffic.sent(msgName(pac.Data), len(b))
+	return nil
+}
+
+// ping sends a ping, the round trip time is updated when the pong is
+// received. It returns errPeerDead without sending the ping if the
+// last maxMissedPings pings are not answered.
+func (p *conn) ping() error {
+	if atomic.AddInt32(&p.unanswered, 1) > maxMissedPings {
+		return errPeerDead
+	}
+
+	return p.Write(packet{Data: ping{Sent: uint64(time.Now().UnixNano())}})
 }
 
+// pong updates the smoothed round trip time with the pong of the
+// ping sent at sent.
+func (p *conn) pong(sent uint64) {
+	atomic.StoreInt32(&p.unanswered, 0)
+	sample := time.Now().UnixNano() - int64(sent)
+	if sample <= 0 {
+		sample = 1
+	}
+
+	for {
+		old := atomic.LoadInt64(&p.rtt)
+		rtt := sample
+		if old > 0 {
+			rtt = old + (sample-old)/rttSmoothing
+		}
+
+		if atomic.CompareAndSwapInt64(&p.rtt, old, rtt) {
+			return
+		}
+	}
+}
+
+// RTT returns the smoothed round trip time, 0 before the first pong
+// is received.
+func (p *conn) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.rtt))
+}
+
+// fasterRTT returns true if the round trip time a is shorter than b,
+// the unknown time 0 is the longest.
+func fasterRTT(a, b time.Duration) bool {
+	if (a == 0) != (b == 0) {
+		return b == 0
+	}
+	return a < b
+}
+
+// idle returns how long since the last packet was received.
+func (p *conn) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&p.lastRecv)))
+}
+
+// Read reads a packet, it returns a *frameError if the peer sent an
+// invalid frame.
 func (p *conn) Read() (pac packet, err error) {
+	// the I/O errors are not counted as the decode errors
+	decoding := false
+	defer func() {
+		if _, ok := err.(*frameError); ok || (decoding && err != nil) {
+			p.traffic.decodeFailed()
+		}
+	}()
+
+	var h [frameHeaderSize]byte
+	_, err = io.ReadFull(p.r, h[:])
+	if err != nil {
+		return
+	}
+
+	size := binary.BigEndian.Uint32(h[:4])
+	rlpEncoded := p.version >= rlpProtocolVersion
+	kind := msgKind(h[4])
+	if rlpEncoded {
+		rt, ok := wireTypes[msgType(h[4])]
+		if !ok {
+			err = &frameError{reason: fmt.Sprintf("unknown message type %d", h[4])}
+			return
+		}
+		kind = kindOf(reflect.Zero(rt).Interface())
+	}
+
+	err = checkFrame(kind, size)
+	if err != nil {
+		return
+	}
+
+	// the buffer grows with the bytes actually received rather
+	// than the claimed size.
+	p.rbuf.Reset()
+	_, err = io.CopyN(&p.rbuf, p.r, int64(size))
+	if err != nil {
+		return
+	}
+
+	atomic.StoreInt64(&p.lastRecv, time.Now().UnixNano())
+
+	decoding = true
+	if rlpEncoded {
+		p.traffic.received(msgType(h[4]).String(), frameHeaderSize+int(size))
+		pac.Data, err = decodeWire(msgType(h[4]), p.rbuf.Bytes())
+		return
+	}
+
 	err = p.dec.Decode(&pac)
 	if err != nil {
 		return
 	}
 
+	p.traffic.received(msgName(pac.Data), frameHeaderSize+int(size))
+
+	if p.rbuf.Len() > 0 {
+		err = &frameError{reason: "trailing bytes after the packet"}
+		return
+	}
+
+	if k := kindOf(pac.Data); k != kind {
+		err = &frameError{reason: fmt.Sprintf("packet of kind %d sent as kind %d", k, kind)}
+		return
+	}
+
 	return
 }
 
 func (p *conn) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+
+	if p.sendq != nil {
+		p.sendq.close()
+	}
+
 	err := p.conn.Close()
 	if err != nil {
 		log.Warn("error close connection", "err", err)
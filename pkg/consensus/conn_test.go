@@ -0,0 +1,351 @@
+package consensus
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnFrame(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	w, r := newConn(a), newConn(b)
+
+	pacs := []packet{
+		{Data: ping{Sent: 1}},
+		{Data: []byte{1, 2, 3}},
+		{Data: &Block{Round: 1}},
+		{Data: ping{Sent: 2}},
+	}
+	go func() {
+		for _, p := range pacs {
+			assert.Nil(t, w.Write(p))
+		}
+	}()
+
+	for _, p := range pacs {
+		pac, err := r.Read()
+		assert.Nil(t, err)
+		assert.Equal(t, p, pac)
+	}
+
+	// a packet beyond the cap of its kind is not sent
+	err := w.Write(packet{Data: make([]byte, maxFrameSize[txnMsg])})
+	assert.IsType(t, &frameError{}, err)
+}
+
+func TestConnRTT(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := newConn(a)
+	go io.Copy(ioutil.Discard, b)
+
+	// the pings are answered
+	assert.Equal(t, time.Duration(0), c.RTT())
+	c.pong(uint64(time.Now().Add(-100 * time.Millisecond).UnixNano()))
+	assert.InDelta(t, 100*time.Millisecond, c.RTT(), float64(10*time.Millisecond))
+	c.pong(uint64(time.Now().Add(-500 * time.Millisecond).UnixNano()))
+	assert.InDelta(t, 200*time.Millisecond, c.RTT(), float64(10*time.Millisecond))
+
+	// the peer is dead after maxMissedPings unanswered pings
+	for i := 0; i < maxMissedPings; i++ {
+		assert.Nil(t, c.ping())
+	}
+	assert.Equal(t, errPeerDead, c.ping())
+	c.pong(uint64(time.Now().UnixNano()))
+	assert.Nil(t, c.ping())
+
+	assert.True(t, fasterRTT(time.Millisecond, time.Second))
+	assert.True(t, fasterRTT(time.Second, 0))
+	assert.False(t, fasterRTT(0, time.Second))
+}
+
+func TestConnTraffic(t *testing.T) {
+	for _, version := range []uint32{minProtocolVersion, protocolVersion} {
+		a, b := net.Pipe()
+		w, r := newConn(a), newConn(b)
+		w.version = version
+		r.version = version
+		w.traffic.setWindow(time.Hour)
+
+		txn := make([]byte, 1000)
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < 3; i++ {
+				assert.Nil(t, w.Write(packet{Data: txn}))
+			}
+			assert.Nil(t, w.Write(packet{Data: ping{Sent: 1}}))
+			close(done)
+		}()
+		for i := 0; i < 4; i++ {
+			_, err := r.Read()
+			assert.Nil(t, err)
+		}
+		<-done
+
+		// the frames are counted by the message types
+		sent, recv := w.traffic.snapshot(), r.traffic.snapshot()
+		size := recv.Msgs["txn"].BytesIn
+		if version >= rlpProtocolVersion {
+			_, enc, err := encodeWire(txn)
+			assert.Nil(t, err)
+			assert.Equal(t, uint64(3*(frameHeaderSize+len(enc))), size)
+		} else {
+			// the first gob frame carries the type definitions
+			assert.True(t, size > 3000, size)
+		}
+		assert.Equal(t, MsgTraffic{MsgsOut: 3, BytesOut: size}, sent.Msgs["txn"])
+		assert.Equal(t, MsgTraffic{MsgsIn: 3, BytesIn: size}, recv.Msgs["txn"])
+		assert.Equal(t, uint64(1), sent.Msgs["ping"].MsgsOut)
+		assert.Equal(t, w.bytesOut, sent.Msgs["txn"].BytesOut+sent.Msgs["ping"].BytesOut)
+		assert.Equal(t, r.bytesIn, recv.Msgs["txn"].BytesIn+recv.Msgs["ping"].BytesIn)
+		assert.InDelta(t, float64(w.bytesOut)/3600, sent.RateOut, 0.01)
+		assert.Equal(t, 0.0, sent.RateIn)
+		out, _ := w.traffic.windowOut()
+		assert.Equal(t, w.bytesOut, out)
+
+		// the rate decays with the windows
+		r.traffic.setWindow(20 * time.Millisecond)
+		time.Sleep(30 * time.Millisecond)
+		rate := r.traffic.snapshot().RateIn
+		assert.True(t, rate > 0 && rate < float64(r.bytesIn)/0.02, rate)
+		time.Sleep(40 * time.Millisecond)
+		assert.Equal(t, 0.0, r.traffic.snapshot().RateIn)
+		assert.Equal(t, uint64(3), r.traffic.snapshot().Msgs["txn"].MsgsIn)
+
+		a.Close()
+		b.Close()
+	}
+}
+
+func TestConnFrameRLP(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	w, r := newConn(a), newConn(b)
+	w.version = protocolVersion
+	r.version = protocolVersion
+
+	pk := RandSK().MustPK()
+	pacs := []packet{
+		{Data: []byte{1, 2, 3}},
+		{Data: &RandBeaconSig{Round: 1, Sig: Sig{1}}},
+		{Data: &RandBeaconSigShare{Owner: Addr{1}, Round: 2, Share: Sig{2}, OwnerSig: Sig{3}}},
+		{Data: &Block{Round: 1, SysTxns: []SysTxn{{Type: RegGroup, Data: []byte{1}}}, Notarization: Sig{4}}},
+		{Data: &BlockProposal{Round: 1, Txns: []byte{5}, OwnerSig: Sig{6}}},
+		{Data: &NtShare{Round: 1, SigShare: Sig{7}, Sig: Sig{8}}},
+		{Data: Item{T: blockItem, Hash: Hash{9}}},
+		{Data: itemRequest{T: randBeaconSigItem, Round: 3}},
+		{Data: &connectRequest{Port: 11001, PK: pk, Sig: Sig{10}}},
+		{Data: []unicastAddr{{Addr: "127.0.0.1:11001", PKStr: string(pk)}}},
+		{Data: ack{}},
+		{Data: ping{Sent: 1}},
+		{Data: pong{Sent: 2}},
+		{Data: &peerRejection{Reason: tooManyPeers}},
+		{Data: &hello{ProtocolVersion: protocolVersion, Software: "v2"}},
+		{Data: &request{ID: 1, Item: Item{T: blockItem, Hash: Hash{11}}}},
+		{Data: &response{ID: 1, Type: blockType, Payload: []byte{12}}},
+		{Data: inventory{{T: txnItem, Hash: Hash{13}}, {T: ntShareItem, Round: 4, Hash: Hash{14}}}},
+		{Data: getData{{T: blockProposalItem, Hash: Hash{15}}}},
+		{Data: &disconnect{Reason: disconnectShutdown}},
+		{Data: &snapshotManifest{Root: Hash{16}, Size: 3, ChunkSize: 2, Chunks: []Hash{{17}, {18}}}},
+		{Data: &snapshotChunk{Root: Hash{16}, Index: 1, Data: []byte{19}}},
+		{Data: truncatedGetData{{T: blockItem, Hash: Hash{20}}}},
+	}
+	assert.Equal(t, len(wireTypes), len(pacs))
+	go func() {
+		for _, p := range pacs {
+			assert.Nil(t, w.Write(p))
+		}
+	}()
+
+	for _, p := range pacs {
+		pac, err := r.Read()
+		assert.Nil(t, err)
+		assert.IsType(t, p.Data, pac.Data)
+		// the nil slices are decoded as the empty slices, so
+		// the encodings are compared.
+		_, expected, err := encodeWire(p.Data)
+		assert.Nil(t, err)
+		_, decoded, err := encodeWire(pac.Data)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, decoded)
+	}
+
+	go writeFrame(t, a, 1, 0, nil)
+	_, err := r.Read()
+	assert.IsType(t, &frameError{}, err)
+}
+
+func benchmarkConnBlock(b *testing.B, version uint32) {
+	x, y := net.Pipe()
+	defer x.Close()
+	defer y.Close()
+	w, r := newConn(x), newConn(y)
+	w.version = version
+	r.version = version
+
+	bp := &BlockProposal{Round: 1, Txns: make([]byte, 4<<20), OwnerSig: make(Sig, 64)}
+	go func() {
+		for i := 0; i < b.N; i++ {
+			w.Write(packet{Data: bp})
+		}
+	}()
+
+	b.SetBytes(int64(len(bp.Txns)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := r.Read()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConnBlockGob(b *testing.B) {
+	benchmarkConnBlock(b, minProtocolVersion)
+}
+
+func BenchmarkConnBlockRLP(b *testing.B) {
+	benchmarkConnBlock(b, rlpProtocolVersion)
+}
+
+// writeFrame writes a frame with the tag, the msgKind or the msgType
+// of the packet.
+func writeFrame(t *testing.T, c net.Conn, size uint32, tag byte, payload []byte) {
+	var h [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(h[:], size)
+	h[4] = tag
+	_, err := c.Write(append(h[:], payload...))
+	assert.Nil(t, err)
+}
+
+func TestConnFrameTooLarge(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	r := newConn(b)
+
+	go writeFrame(t, a, 1<<32-1, byte(txnMsg), nil)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := r.Read()
+	runtime.ReadMemStats(&after)
+	assert.IsType(t, &frameError{}, err)
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 1<<20)
+
+	go writeFrame(t, a, 1, 100, nil)
+	_, err = r.Read()
+	assert.IsType(t, &frameError{}, err)
+}
+
+func TestConnFrameKindMismatch(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	w, r := newConn(a), newConn(b)
+
+	// a block sent as a txn
+	w.wbuf.Reset()
+	assert.Nil(t, w.enc.Encode(packet{Data: &Block{Round: 1}}))
+	payload := w.wbuf.Bytes()
+	go writeFrame(t, a, uint32(len(payload)), byte(txnMsg), payload)
+	_, err := r.Read()
+	assert.IsType(t, &frameError{}, err)
+}
+
+func TestNetworkBanInvalidFrame(t *testing.T) {
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11013)
+	assert.Nil(t, err)
+
+	n1 := makeNetwork()
+	_, err = n1.Start("127.0.0.1", 11014)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+
+	n1.mu.Lock()
+	c := n1.conns[addr0]
+	n1.mu.Unlock()
+	writeFrame(t, c.conn, maxFrameSize[controlMsg]+1, byte(pingType), nil)
+
+	id1 := n1.sk.MustPK().Addr()
+	waitUntil(t, func() bool {
+		n0.mu.Lock()
+		defer n0.mu.Unlock()
+		return n0.isBanned(id1)
+	})
+	assert.Equal(t, 0, n0.PeerCount())
+}
+
+func TestSendQueue(t *testing.T) {
+	var dropped uint64
+	q := newSendQueue(2, 50*time.Millisecond, &dropped)
+	txn := packet{Data: []byte{1}}
+	bp := packet{Data: &BlockProposal{Round: 1}}
+	share := packet{Data: &NtShare{Round: 1}}
+
+	// the oldest packets of the lowest classes are dropped first,
+	// the share is queued even if nothing can be dropped
+	assert.Nil(t, q.push(txn))
+	assert.Nil(t, q.push(bp))
+	assert.Nil(t, q.push(bp))
+	assert.Equal(t, uint64(1), dropped)
+	assert.Nil(t, q.push(share))
+	assert.Nil(t, q.push(share))
+	assert.Equal(t, uint64(3), dropped)
+	assert.Nil(t, q.push(share))
+	assert.Nil(t, q.push(txn))
+	assert.Equal(t, uint64(4), dropped)
+
+	assert.Equal(t, map[string]int{"gossip": 0, "normal": 0, "consensus": 0, "critical": 3}, q.depths())
+
+	// the shares are written first
+	for i := 0; i < 3; i++ {
+		p, ok := q.pop()
+		assert.True(t, ok)
+		assert.Equal(t, share, p)
+	}
+
+	// the queue full for the saturation timeout fails
+	assert.Nil(t, q.push(bp))
+	assert.Nil(t, q.push(bp))
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, errSaturated, q.push(bp))
+	p, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, bp, p)
+	assert.Nil(t, q.push(bp))
+
+	// the consensus packets are written before the others
+	q = newSendQueue(10, time.Second, &dropped)
+	assert.Nil(t, q.push(txn))
+	assert.Nil(t, q.push(packet{Data: Item{T: txnItem}}))
+	assert.Nil(t, q.push(packet{Data: []unicastAddr{}}))
+	assert.Nil(t, q.push(packet{Data: &Block{Round: 1}}))
+	assert.Nil(t, q.push(packet{Data: Item{T: blockProposalItem}}))
+	for _, want := range []sendClass{consensusClass, consensusClass, normalClass, gossipClass, gossipClass} {
+		p, ok := q.pop()
+		assert.True(t, ok)
+		assert.Equal(t, want, classOf(p.Data))
+	}
+
+	q.close()
+	_, ok = q.pop()
+	assert.False(t, ok)
+}
@@ -0,0 +1,103 @@
+package consensus
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// disconnectProtocolVersion is the protocol version from which a
+// disconnect is sent before the connection is closed gracefully. A
+// peerRejection carrying the reason is sent to the peers speaking the
+// older versions.
+const disconnectProtocolVersion = 6
+
+// flushTimeout is how long the packets queued to a peer are written
+// before the connection is closed gracefully.
+const flushTimeout = 2 * time.Second
+
+// errClosing is returned when sending to a peer whose connection is
+// being closed gracefully.
+var errClosing = errors.New("the connection to the peer is closing")
+
+// disconnectReason is the reason why a connection is closed
+// gracefully.
+type disconnectReason uint8
+
+// different reasons of closing a connection
+const (
+	// the peer is disconnected by the operator
+	disconnectRequested disconnectReason = iota + 1
+	// the node is shutting down
+	disconnectShutdown
+	// the peer is evicted for a priority peer
+	disconnectEvicted
+)
+
+var disconnectReasonNames = map[disconnectReason]string{
+	disconnectRequested: "requested",
+	disconnectShutdown:  "shutdown",
+	disconnectEvicted:   "evicted for a priority peer",
+}
+
+func (r disconnectReason) String() string {
+	if name, ok := disconnectReasonNames[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// disconnect is the last packet sent before the connection is closed
+// gracefully.
+type disconnect struct {
+	Reason disconnectReason
+}
+
+// closeGracefully stops accepting the packets to the peer, and writes
+// the queued ones and a disconnect before closing the connection. It
+// gives up flushing after the timeout.
+func (p *conn) closeGracefully(reason disconnectReason, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	p.flushBatch()
+	if p.sendq != nil {
+		p.sendq.drain()
+		select {
+		case <-p.writerDone:
+		case <-time.After(time.Until(deadline)):
+			log.Info("timed out flushing the packets to the peer", "remote", p.conn.RemoteAddr())
+		}
+	}
+
+	p.conn.SetWriteDeadline(deadline)
+	var data interface{} = &disconnect{Reason: reason}
+	if p.version < disconnectProtocolVersion {
+		data = &peerRejection{Reason: reason.String()}
+	}
+	p.Write(packet{Data: data})
+	p.Close()
+}
+
+// closeAllGracefully closes the connections gracefully at the same
+// time, it returns once they are closed.
+func closeAllGracefully(conns []*conn, reason disconnectReason, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *conn) {
+			defer wg.Done()
+			c.closeGracefully(reason, timeout)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// peerDisconnected records the reason the peer gave for closing the
+// connection, it is saved in the peer file.
+func (n *network) peerDisconnected(addr unicastAddr, reason string) {
+	log.Info("peer closed the connection", "addr", addr.Addr, "reason", reason)
+	n.mu.Lock()
+	n.disconnects[addr] = reason
+	n.mu.Unlock()
+}
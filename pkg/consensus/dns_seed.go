@@ -0,0 +1,139 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+const (
+	// defaultDNSSeedInterval is how often the DNS seeds are
+	// resolved again while the node has few peers.
+	defaultDNSSeedInterval = 5 * time.Minute
+	// dnsSeedMinPeers is the peer count below which the DNS
+	// seeds are resolved again.
+	dnsSeedMinPeers = intialConn / 2
+)
+
+// errNoDNSSeedAddr is returned when no DNS seed resolves to an
+// address.
+var errNoDNSSeedAddr = errors.New("the DNS seeds resolved to no address")
+
+// setDNSSeeds sets the host:port of the DNS seeds, the addresses of
+// a host are the candidate seed nodes listening on the port.
+func (n *network) setDNSSeeds(seeds []string) {
+	n.mu.Lock()
+	n.dnsSeeds = seeds
+	n.mu.Unlock()
+}
+
+// resolveDNSSeeds resolves the DNS seeds, it returns the addresses
+// of the candidate seed nodes shuffled. The seeds failed to resolve
+// are skipped.
+func (n *network) resolveDNSSeeds() ([]string, error) {
+	n.mu.Lock()
+	seeds := n.dnsSeeds
+	lookup := n.lookupHost
+	n.mu.Unlock()
+
+	var addrs []string
+	seen := make(map[string]bool)
+	var lastErr error
+	for _, seed := range seeds {
+		host, port, err := net.SplitHostPort(seed)
+		if err != nil {
+			lastErr = err
+			log.Warn("invalid DNS seed", "seed", seed, "err", err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutDur)
+		ips, err := lookup(ctx, host)
+		cancel()
+		if err != nil {
+			lastErr = err
+			log.Warn("error resolving the DNS seed", "seed", seed, "err", err)
+			continue
+		}
+
+		for _, s := range ips {
+			ip := net.ParseIP(s)
+			if ip == nil || ip.IsUnspecified() || ip.IsMulticast() {
+				continue
+			}
+
+			addr := net.JoinHostPort(ip.String(), port)
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, errNoDNSSeedAddr
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	return addrs, nil
+}
+
+// connectDNSSeeds connects to the candidate seed nodes of the DNS
+// seeds one at a time, until one of them passes the handshake and
+// the node connects to the peers it knows, see ConnectSeed.
+func (n *network) connectDNSSeeds() error {
+	addrs, err := n.resolveDNSSeeds()
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	self := n.listenAddr
+	n.mu.Unlock()
+
+	for _, addr := range addrs {
+		if addr == self {
+			continue
+		}
+
+		err = n.ConnectSeed(addr)
+		if err == nil {
+			log.Info("connected to the DNS seed node", "addr", addr)
+			return nil
+		}
+		log.Debug("error connecting to the DNS seed node", "addr", addr, "err", err)
+	}
+
+	if err == nil {
+		err = errNoDNSSeedAddr
+	}
+	return err
+}
+
+// dnsSeedLoop connects to the DNS seeds again while the node has few
+// peers.
+func (n *network) dnsSeedLoop() {
+	n.mu.Lock()
+	interval := n.dnsSeedInterval
+	n.mu.Unlock()
+
+	for range time.Tick(interval) {
+		if n.PeerCount() >= dnsSeedMinPeers {
+			continue
+		}
+
+		err := n.connectDNSSeeds()
+		if err != nil {
+			log.Warn("error connecting to the DNS seeds", "err", err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package consensus
+
+import (
+	"fmt"
+	"time"
+)
+
+// Every packet is sent in a frame: the 4-byte big-endian size of
+// the payload, the 1-byte tag of the packet, and the payload. From
+// rlpProtocolVersion on, the tag is the msgType of the packet and the
+// payload is the RLP encoding of the packet data. With the older
+// versions, and for the hellos, the tag is the msgKind and the
+// payload is the gob encoded packet. The size is checked against the
+// cap of the packet's kind before the payload is read, so a peer can
+// not make the node allocate more than the cap.
+const frameHeaderSize = 5
+
+// frameViolationBan is how long a peer is banned after it sent an
+// invalid frame.
+const frameViolationBan = 24 * time.Hour
+
+// msgKind is the kind of a packet, the kinds have different size
+// caps.
+type msgKind byte
+
+// different kinds of packets
+const (
+	// the handshake, the item requests, the signatures and the
+	// shares
+	controlMsg msgKind = iota
+	txnMsg
+	// the blocks, the block proposals, and the responses which
+	// can carry them
+	blockMsg
+)
+
+// maxFrameSize is the maximum size of the encoded packet of each
+// kind.
+var maxFrameSize = map[msgKind]uint32{
+	controlMsg: 1 << 20,
+	txnMsg:     MaxTxnBytes + 1<<10,
+	blockMsg:   32 << 20,
+}
+
+func kindOf(data interface{}) msgKind {
+	switch data.(type) {
+	case []byte:
+		return txnMsg
+	case *Block, *BlockProposal, *response:
+		return blockMsg
+	default:
+		return controlMsg
+	}
+}
+
+// frameError is returned when a peer sent an invalid frame, the peer
+// is banned.
+type frameError struct {
+	reason string
+}
+
+func (e *frameError) Error() string {
+	return "invalid frame: " + e.reason
+}
+
+// checkFrame returns an error if the size exceeds the cap of the
+// kind.
+func checkFrame(kind msgKind, size uint32) error {
+	max, ok := maxFrameSize[kind]
+	if !ok {
+		return &frameError{reason: fmt.Sprintf("unknown kind %d", kind)}
+	}
+
+	if size > max {
+		return &frameError{reason: fmt.Sprintf("size %d exceeds the cap %d of kind %d", size, max, kind)}
+	}
+
+	return nil
+}
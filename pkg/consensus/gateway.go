@@ -24,11 +24,11 @@ type gateway struct {
 	store                    *storage
 	ntShareCollector         *collector
 	randBeaconShareCollector *collector
+	// serves the state snapshots to the peers, nil if the node
+	// does not serve them.
+	snapshots snapshotSource
 
 	mu             sync.Mutex
-	rbSigWaiters   map[uint64][]chan *RandBeaconSig
-	blockWaiters   map[Hash][]chan *Block
-	bpWaiters      map[Hash][]chan *BlockProposal
 	requestingItem map[Item]bool
 }
 
@@ -45,15 +45,19 @@ func (i Item) String() string {
 		return fmt.Sprintf("%v_hash_%v", i.T, i.Hash)
 	case randBeaconSigShareItem, randBeaconSigItem:
 		return fmt.Sprintf("%v_round_%v", i.T, i.Round)
+	case snapshotManifestItem:
+		return fmt.Sprintf("%v_root_%v", i.T, i.Hash)
+	case snapshotChunkItem:
+		return fmt.Sprintf("%v_root_%v_index_%v", i.T, i.Hash, i.Round)
 	default:
-		panic(i.T)
+		return fmt.Sprintf("%v_round_%v_hash_%v", i.T, i.Round, i.Hash)
 	}
 }
 
 type itemRequest Item
 
 // itemType is the different type of items.
-type itemType int
+type itemType uint8
 
 // different types of items
 const (
@@ -64,8 +68,18 @@ const (
 	ntShareItem
 	randBeaconSigShareItem
 	randBeaconSigItem
+	// the manifest of the snapshot of the state root Hash, and
+	// the chunk Round of the snapshot, they are only requested.
+	snapshotManifestItem
+	snapshotChunkItem
 )
 
+// known returns true if the item type is one of the types above, the
+// item types in the packets of the peers are not trusted.
+func (i itemType) known() bool {
+	return i <= snapshotChunkItem
+}
+
 func (i itemType) String() string {
 	switch i {
 	case txnItem:
@@ -82,8 +96,12 @@ func (i itemType) String() string {
 		return "RandBeaconSigShareItem"
 	case randBeaconSigItem:
 		return "RandBeaconSigItem"
+	case snapshotManifestItem:
+		return "SnapshotManifestItem"
+	case snapshotChunkItem:
+		return "SnapshotChunkItem"
 	default:
-		panic("unknown item")
+		return fmt.Sprintf("UnknownItem(%d)", uint8(i))
 	}
 }
 
@@ -110,9 +128,6 @@ func newGateway(net *network, chain *Chain, store *storage, groupThreshold int)
 		bpCache:                  bpCache,
 		randBeaconSigCache:       randBeaconSigCache,
 		chain:                    chain,
-		rbSigWaiters:             make(map[uint64][]chan *RandBeaconSig),
-		blockWaiters:             make(map[Hash][]chan *Block),
-		bpWaiters:                make(map[Hash][]chan *BlockProposal),
 		requestingItem:           make(map[Item]bool),
 		ntShareCollector:         newCollector(groupThreshold),
 		randBeaconShareCollector: newCollector(groupThreshold),
@@ -151,8 +166,8 @@ func (n *gateway) onPeerConnect(addr unicastAddr) {
 	}
 }
 
-func (n *gateway) requestItem(addr unicastAddr, item Item, forceRequest bool) error {
-	if !forceRequest && n.requestingItem[item] {
+func (n *gateway) requestItem(addr unicastAddr, item Item) error {
+	if n.requestingItem[item] {
 		return nil
 	}
 
@@ -177,29 +192,23 @@ func (n *gateway) RequestRandBeaconSig(ctx context.Context, addr unicastAddr, ro
 		return v, nil
 	}
 
-	c := make(chan *RandBeaconSig, 1)
-	n.mu.Lock()
-	n.rbSigWaiters[round] = append(n.rbSigWaiters[round], c)
-	if len(n.rbSigWaiters[round]) == 1 {
-		err := n.requestItem(addr, Item{
-			T:     randBeaconSigItem,
-			Round: round,
-		}, true)
-		if err != nil {
-			n.mu.Unlock()
-			return nil, err
-		}
+	data, err := n.net.Request(ctx, addr, Item{T: randBeaconSigItem, Round: round})
+	if err != nil {
+		return nil, err
 	}
-	n.mu.Unlock()
 
-	select {
-	case r := <-c:
-		return r, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	r, ok := data.(*RandBeaconSig)
+	if !ok || r.Round != round {
+		n.net.penalize(addr)
+		return nil, fmt.Errorf("peer %s replied an invalid rand beacon sig of round %d", addr.Addr, round)
 	}
+
+	n.randBeaconSigCache.Add(round, r)
+	return r, nil
 }
 
+// RequestBlock requests the block from the peer and the other peers
+// that announced it, see network.requestAny.
 func (n *gateway) RequestBlock(ctx context.Context, addr unicastAddr, hash Hash) (*Block, error) {
 	v, ok := n.blockCache.Get(hash)
 	if ok {
@@ -210,29 +219,21 @@ func (n *gateway) RequestBlock(ctx context.Context, addr unicastAddr, hash Hash)
 		return b, nil
 	}
 
-	c := make(chan *Block, 1)
-	n.mu.Lock()
-	n.blockWaiters[hash] = append(n.blockWaiters[hash], c)
-	if len(n.blockWaiters[hash]) == 1 {
-		err := n.requestItem(addr, Item{
-			T:    blockItem,
-			Hash: hash,
-		}, true)
-		if err != nil {
-			n.mu.Unlock()
-			return nil, err
-		}
+	data, err := n.net.requestAny(ctx, addr, Item{T: blockItem, Hash: hash}, func(data interface{}) bool {
+		b, ok := data.(*Block)
+		return ok && b.Hash() == hash
+	})
+	if err != nil {
+		return nil, err
 	}
-	n.mu.Unlock()
 
-	select {
-	case b := <-c:
-		return b, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	}
+	b := data.(*Block)
+	n.blockCache.Add(hash, b)
+	return b, nil
 }
 
+// RequestBlockProposal requests the block proposal from the peer and
+// the other peers that announced it, see network.requestAny.
 func (n *gateway) RequestBlockProposal(ctx context.Context, addr unicastAddr, hash Hash) (*BlockProposal, error) {
 	v, ok := n.bpCache.Get(hash)
 	if ok {
@@ -243,27 +244,17 @@ func (n *gateway) RequestBlockProposal(ctx context.Context, addr unicastAddr, ha
 		return bp, nil
 	}
 
-	c := make(chan *BlockProposal, 1)
-	n.mu.Lock()
-	n.bpWaiters[hash] = append(n.bpWaiters[hash], c)
-	if len(n.bpWaiters[hash]) == 1 {
-		err := n.requestItem(addr, Item{
-			T:    blockProposalItem,
-			Hash: hash,
-		}, true)
-		if err != nil {
-			n.mu.Unlock()
-			return nil, err
-		}
+	data, err := n.net.requestAny(ctx, addr, Item{T: blockProposalItem, Hash: hash}, func(data interface{}) bool {
+		bp, ok := data.(*BlockProposal)
+		return ok && bp.Hash() == hash
+	})
+	if err != nil {
+		return nil, err
 	}
-	n.mu.Unlock()
 
-	select {
-	case bp := <-c:
-		return bp, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	}
+	bp := data.(*BlockProposal)
+	n.bpCache.Add(hash, bp)
+	return bp, nil
 }
 
 // Start starts the networking component.
@@ -275,11 +266,7 @@ func (n *gateway) Start(host string, port int, seedAddr string) error {
 	n.addr = myAddr
 
 	go n.recvData()
-	if seedAddr == "" {
-		return nil
-	}
-
-	return n.net.ConnectSeed(seedAddr)
+	return n.net.bootstrap(seedAddr)
 }
 
 func (n *gateway) recvData() {
@@ -313,17 +300,26 @@ func (n *gateway) recvData() {
 			go n.recvInventory(addr, v)
 		case itemRequest:
 			go n.serveData(addr, Item(v))
+		case *request:
+			go n.serveRequest(addr, v)
 		default:
 			panic(fmt.Errorf("received unsupported data type: %T", pac.Data))
 		}
 	}
 }
 
+// broadcast gossips the item, it is pushed to some of the peers and
+// announced to the rest, see network.Gossip.
 func (n *gateway) broadcast(item Item) {
-	n.net.Send(broadcast{}, packet{Data: item})
+	n.net.Gossip(item, n.lookupItem(item))
 }
 
 func (n *gateway) recvTxn(t []byte) {
+	if len(t) > MaxTxnBytes {
+		log.Warn("received txn exceeds the max txn size", "size", len(t))
+		return
+	}
+
 	_, broadcast := n.chain.txnPool.Add(t)
 	if broadcast {
 		go n.broadcast(Item{T: txnItem, Hash: SHA3(t)})
@@ -342,13 +338,6 @@ func (n *gateway) recvRandBeaconSig(addr unicastAddr, r *RandBeaconSig) {
 
 	n.randBeaconSigCache.Add(r.Round, r)
 
-	n.mu.Lock()
-	for _, ch := range n.rbSigWaiters[r.Round] {
-		ch <- r
-	}
-	n.rbSigWaiters[r.Round] = nil
-	n.mu.Unlock()
-
 	broadcast, err := n.syncer.SyncRandBeaconSig(addr, r.Round)
 	if err != nil {
 		log.Warn("SyncRandBeaconSig failed", "err", err)
@@ -475,13 +464,6 @@ func (n *gateway) recvBlock(addr unicastAddr, b *Block, h Hash) {
 	go n.node.BlockForRoundProduced(b.Round)
 	n.blockCache.Add(h, b)
 
-	n.mu.Lock()
-	for _, c := range n.blockWaiters[h] {
-		c <- b
-	}
-	n.blockWaiters[h] = nil
-	n.mu.Unlock()
-
 	_, broadcast, err := n.syncer.SyncBlock(addr, h, b.Round)
 	if err != nil {
 		log.Warn("sync block error", "err", err)
@@ -496,13 +478,6 @@ func (n *gateway) recvBlock(addr unicastAddr, b *Block, h Hash) {
 func (n *gateway) recvBlockProposal(addr unicastAddr, bp *BlockProposal, h Hash) {
 	n.bpCache.Add(h, bp)
 
-	n.mu.Lock()
-	for _, c := range n.bpWaiters[h] {
-		c <- bp
-	}
-	n.bpWaiters[h] = nil
-	n.mu.Unlock()
-
 	_, broadcast, err := n.syncer.SyncBlockProposal(addr, h)
 	if err != nil {
 		log.Warn("sync block proposal error", "err", err)
@@ -522,6 +497,7 @@ func (n *gateway) recvNtShare(addr unicastAddr, s *NtShare, h Hash) {
 
 	if !n.validateNtShare(addr, s) {
 		log.Error("received invalid nt share")
+		n.net.penalize(addr)
 		return
 	}
 
@@ -594,10 +570,11 @@ func (n *gateway) recvInventory(addr unicastAddr, item Item) {
 	switch item.T {
 	case txnItem:
 		if n.chain.txnPool.NotSeen(item.Hash) {
-			n.requestItem(addr, item, false)
+			n.requestItem(addr, item)
 		}
 	case sysTxnItem:
-		panic(sysTxnNotImplemented)
+		// the sys txns are not implemented, the
+		// announcement is ignored.
 	case blockItem:
 		if n.blockCache.Contains(item.Hash) {
 			return
@@ -607,7 +584,7 @@ func (n *gateway) recvInventory(addr unicastAddr, item Item) {
 			return
 		}
 
-		n.requestItem(addr, item, false)
+		n.requestItem(addr, item)
 	case blockProposalItem:
 		if n.bpCache.Contains(item.Hash) {
 			return
@@ -617,7 +594,7 @@ func (n *gateway) recvInventory(addr unicastAddr, item Item) {
 			return
 		}
 
-		n.requestItem(addr, item, false)
+		n.requestItem(addr, item)
 	case ntShareItem:
 		if n.chain.Round() > item.Round {
 			return
@@ -627,7 +604,7 @@ func (n *gateway) recvInventory(addr unicastAddr, item Item) {
 			return
 		}
 
-		n.requestItem(addr, item, false)
+		n.requestItem(addr, item)
 	case randBeaconSigShareItem:
 		if n.chain.randomBeacon.Round()+1 != item.Round {
 			return
@@ -638,60 +615,108 @@ func (n *gateway) recvInventory(addr unicastAddr, item Item) {
 			return
 		}
 
-		n.requestItem(addr, item, false)
+		n.requestItem(addr, item)
 	case randBeaconSigItem:
 		if n.chain.randomBeacon.Round() >= item.Round {
 			return
 		}
-		n.requestItem(addr, item, false)
+		n.requestItem(addr, item)
 	}
 }
 
-func (n *gateway) serveData(addr unicastAddr, item Item) {
+// lookupItem returns the item, or nil if the node does not have it.
+func (n *gateway) lookupItem(item Item) interface{} {
 	switch item.T {
 	case txnItem:
 		b := n.chain.txnPool.Get(item.Hash)
 		if b == nil {
-			return
+			return nil
 		}
-		go n.net.Send(addr, packet{Data: b.Raw})
+		return b.Raw
 	case sysTxnItem:
-		panic(sysTxnNotImplemented)
+		// the sys txns are not implemented
+		return nil
 	case blockProposalItem:
 		bp := n.store.BlockProposal(item.Hash)
 		if bp == nil {
-			return
+			return nil
 		}
-		go n.net.Send(addr, packet{Data: bp})
+		return bp
 	case blockItem:
 		b := n.store.Block(item.Hash)
 		if b == nil {
-			return
+			return nil
 		}
-		go n.net.Send(addr, packet{Data: b})
+		return b
 	case ntShareItem:
-		nts := n.ntShareCollector.Get(item.Hash)
-		if nts == nil {
-			return
-		}
-		go n.net.Send(addr, packet{Data: nts})
+		return n.ntShareCollector.Get(item.Hash)
 	case randBeaconSigShareItem:
-		share := n.randBeaconShareCollector.Get(item.Hash)
-		if share == nil {
-			return
-		}
-		go n.net.Send(addr, packet{Data: share})
+		return n.randBeaconShareCollector.Get(item.Hash)
 	case randBeaconSigItem:
 		history := n.chain.randomBeacon.History()
 		if item.Round >= uint64(len(history)) {
-			return
+			return nil
 		}
 
-		r := history[item.Round]
-		go n.net.Send(addr, packet{Data: r})
+		return history[item.Round]
+	case snapshotManifestItem, snapshotChunkItem:
+		return lookupSnapshot(n.snapshots, item)
 	default:
-		panic(fmt.Errorf("unknow requested item type: %v", item.T))
+		// the item type comes from the peer
+		return nil
+	}
+}
+
+// lowPriority returns true if the data is a historical block or
+// random beacon signature, or a snapshot chunk, which a peer
+// requests when it syncs. The low-priority data is deferred when the
+// peer exceeded its outbound budget.
+func (n *gateway) lowPriority(data interface{}) bool {
+	switch v := data.(type) {
+	case *snapshotChunk:
+		return true
+	case *Block:
+		return v.Round+1 < n.chain.Round()
+	case *RandBeaconSig:
+		return v.Round+1 < n.chain.randomBeacon.Round()
+	default:
+		return false
+	}
+}
+
+func (n *gateway) serveData(addr unicastAddr, item Item) {
+	if item.T == snapshotManifestItem || item.T == snapshotChunkItem {
+		// only served in the responses, see serveRequest
+		return
+	}
+
+	data := n.lookupItem(item)
+	if data == nil {
+		return
+	}
+
+	if n.lowPriority(data) && !n.net.waitBudget(addr) {
+		return
 	}
 
 	log.Debug("serving item", "item", item, "addr", addr.Addr)
+	go n.net.Send(addr, packet{Data: data})
+}
+
+// serveRequest replies the request, the response is empty if the
+// node does not have the item.
+func (n *gateway) serveRequest(addr unicastAddr, r *request) {
+	data := n.lookupItem(r.Item)
+	if n.lowPriority(data) && !n.net.waitBudget(addr) {
+		return
+	}
+
+	resp, err := newResponse(r.ID, data)
+	if err != nil {
+		log.Error("error encoding the response", "item", r.Item, "err", err)
+		return
+	}
+
+	log.Debug("serving request", "item", r.Item, "addr", addr.Addr, "found", resp.Type != 0)
+	n.net.Send(addr, packet{Data: resp})
 }
@@ -0,0 +1,85 @@
+package consensus
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type myTxnPool struct {
+	mu     sync.Mutex
+	added  [][]byte
+	notify chan struct{}
+}
+
+func (p *myTxnPool) Add(b []byte) (*Txn, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.added = append(p.added, b)
+	if len(p.added) == 1 && p.notify != nil {
+		p.notify <- struct{}{}
+	}
+	return &Txn{Raw: b}, false
+}
+
+func (p *myTxnPool) Get(Hash) *Txn {
+	return nil
+}
+
+func (p *myTxnPool) NotSeen(Hash) bool {
+	return true
+}
+
+func (p *myTxnPool) Txns() []*Txn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	txns := make([]*Txn, len(p.added))
+	for i, b := range p.added {
+		txns[i] = &Txn{Raw: b}
+	}
+	return txns
+}
+
+func (p *myTxnPool) Remove(Hash) {
+}
+
+func (p *myTxnPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.added)
+}
+
+func (p *myTxnPool) AdvanceRound(uint64) {
+}
+
+func (p *myTxnPool) Included(uint64, Hash, []byte) {
+}
+
+func (p *myTxnPool) Notify() <-chan struct{} {
+	return p.notify
+}
+
+func TestRecvTxnMaxTxnBytes(t *testing.T) {
+	pool := &myTxnPool{}
+	g := &gateway{chain: &Chain{txnPool: pool}}
+	g.recvTxn(make([]byte, MaxTxnBytes+1))
+	assert.Equal(t, 0, len(pool.added))
+
+	g.recvTxn(make([]byte, MaxTxnBytes))
+	assert.Equal(t, 1, len(pool.added))
+}
+
+func TestItemUnknownType(t *testing.T) {
+	item := Item{T: 200, Round: 1, Hash: Hash{1}}
+	assert.False(t, item.T.known())
+	assert.True(t, snapshotChunkItem.known())
+	assert.NotEqual(t, "", item.String())
+
+	g := &gateway{}
+	assert.Nil(t, g.lookupItem(item))
+	assert.Nil(t, g.lookupItem(Item{T: sysTxnItem}))
+}
@@ -0,0 +1,180 @@
+package consensus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// the defaults of Config.GossipCacheSize and Config.GossipCacheTTL
+const (
+	defaultGossipCacheSize = 8192
+	defaultGossipCacheTTL  = 10 * time.Minute
+)
+
+// gossipCache remembers the recently seen items and the peers that
+// sent or announced them. An item relayed by many peers is delivered
+// to the gateway once, and the item is not announced back to the
+// peers that have it.
+type gossipCache struct {
+	// the number of the dropped duplicates, it is accessed
+	// atomically, so it comes first to be 64-bit aligned.
+	duplicates uint64
+	// the number of the received items, duplicates or not
+	receivedItems uint64
+	ttl           time.Duration
+
+	mu    sync.Mutex
+	items *lru.Cache
+}
+
+type gossipEntry struct {
+	seenAt time.Time
+	// the peers that sent or announced the item
+	peers map[unicastAddr]bool
+	// delivered is true once the item is delivered to the
+	// gateway, requested is true while the gateway is waiting
+	// for the item it requested, which is delivered even if it
+	// was seen.
+	delivered bool
+	requested bool
+}
+
+// newGossipCache creates a gossip cache of the size and the TTL, 0
+// uses the defaults.
+func newGossipCache(size int, ttl time.Duration) *gossipCache {
+	if size <= 0 {
+		size = defaultGossipCacheSize
+	}
+
+	if ttl <= 0 {
+		ttl = defaultGossipCacheTTL
+	}
+
+	items, err := lru.New(size)
+	if err != nil {
+		panic(err)
+	}
+
+	return &gossipCache{ttl: ttl, items: items}
+}
+
+// entry returns the entry of the item, a new one if the item is not
+// seen or its entry expired. The caller must hold c.mu.
+func (c *gossipCache) entry(item Item) *gossipEntry {
+	if v, ok := c.items.Get(item); ok {
+		e := v.(*gossipEntry)
+		if time.Since(e.seenAt) < c.ttl {
+			return e
+		}
+	}
+
+	e := &gossipEntry{seenAt: time.Now(), peers: make(map[unicastAddr]bool)}
+	c.items.Add(item, e)
+	return e
+}
+
+// received records the item received from the peer, it returns true
+// if the item should be delivered to the gateway, or false if the
+// item is a duplicate.
+func (c *gossipCache) received(item Item, from unicastAddr) bool {
+	atomic.AddUint64(&c.receivedItems, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entry(item)
+	e.peers[from] = true
+	if !e.delivered || e.requested {
+		e.delivered = true
+		e.requested = false
+		return true
+	}
+
+	atomic.AddUint64(&c.duplicates, 1)
+	return false
+}
+
+// announced records that the peer has the item.
+func (c *gossipCache) announced(item Item, from unicastAddr) {
+	c.mu.Lock()
+	c.entry(item).peers[from] = true
+	c.mu.Unlock()
+}
+
+// requesting records that the item is requested by the gateway.
+func (c *gossipCache) requesting(item Item) {
+	c.mu.Lock()
+	c.entry(item).requested = true
+	c.mu.Unlock()
+}
+
+// has returns true if the peer sent or announced the item.
+func (c *gossipCache) has(item Item, peer unicastAddr) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.items.Get(item)
+	if !ok {
+		return false
+	}
+
+	e := v.(*gossipEntry)
+	return time.Since(e.seenAt) < c.ttl && e.peers[peer]
+}
+
+// peers returns the peers that sent or announced the item.
+func (c *gossipCache) peers(item Item) []unicastAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.items.Get(item)
+	if !ok {
+		return nil
+	}
+
+	e := v.(*gossipEntry)
+	if time.Since(e.seenAt) >= c.ttl {
+		return nil
+	}
+
+	peers := make([]unicastAddr, 0, len(e.peers))
+	for p := range e.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Duplicates returns the number of the dropped duplicate items.
+func (c *gossipCache) Duplicates() uint64 {
+	return atomic.LoadUint64(&c.duplicates)
+}
+
+// Received returns the number of the received items, including the
+// duplicates.
+func (c *gossipCache) Received() uint64 {
+	return atomic.LoadUint64(&c.receivedItems)
+}
+
+// itemOf returns the item of the packet data, false if the data is
+// not a gossiped item. The items are identified the same way as the
+// gateway announces them.
+func itemOf(data interface{}) (Item, bool) {
+	switch v := data.(type) {
+	case []byte:
+		return Item{T: txnItem, Hash: SHA3(v)}, true
+	case *RandBeaconSig:
+		return Item{T: randBeaconSigItem, Round: v.Round}, true
+	case *RandBeaconSigShare:
+		return Item{T: randBeaconSigShareItem, Round: v.Round, Hash: v.Hash()}, true
+	case *Block:
+		return Item{T: blockItem, Hash: v.Hash()}, true
+	case *BlockProposal:
+		return Item{T: blockProposalItem, Hash: v.Hash()}, true
+	case *NtShare:
+		return Item{T: ntShareItem, Round: v.Round, Hash: v.Hash()}, true
+	default:
+		return Item{}, false
+	}
+}
@@ -0,0 +1,72 @@
+package consensus
+
+import (
+	"math"
+	"math/rand"
+)
+
+// fullFanoutItems are the small consensus-critical items pushed to
+// every peer, the round can not wait for them to be pulled.
+var fullFanoutItems = map[itemType]bool{
+	ntShareItem:            true,
+	randBeaconSigShareItem: true,
+}
+
+// setGossipFanout sets the number of the peers a gossiped item is
+// pushed to in full, the other peers are sent the announcement of
+// the item and pull it if they need it. 0 uses the square root of
+// the peer count, and a negative fanout only announces the items.
+func (n *network) setGossipFanout(fanout int) {
+	n.mu.Lock()
+	n.fanout = fanout
+	n.mu.Unlock()
+}
+
+// fanoutSize returns the number of the peers an item of the type is
+// pushed to in full, out of the peers peers. The caller must hold
+// n.mu.
+func (n *network) fanoutSize(t itemType, peers int) int {
+	switch {
+	case fullFanoutItems[t]:
+		return peers
+	case n.fanout < 0:
+		return 0
+	case n.fanout > 0:
+		return n.fanout
+	default:
+		return int(math.Ceil(math.Sqrt(float64(peers))))
+	}
+}
+
+// Gossip pushes the item's data to a random subset of the peers, and
+// announces the item to the rest, see setGossipFanout. The peers that
+// sent or announced the item are skipped. The item is only announced
+// if data is nil.
+func (n *network) Gossip(item Item, data interface{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	push := 0
+	if data != nil {
+		push = n.fanoutSize(item.T, len(n.conns))
+	}
+
+	peers := make([]unicastAddr, 0, len(n.conns))
+	for addr := range n.conns {
+		if !n.gossip.has(item, addr) {
+			peers = append(peers, addr)
+		}
+	}
+
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+
+	for i, addr := range peers {
+		if i < push {
+			go n.Send(addr, packet{Data: data})
+		} else {
+			go n.Send(addr, packet{Data: item})
+		}
+	}
+}
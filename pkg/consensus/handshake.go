@@ -0,0 +1,184 @@
+package consensus
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// The newest protocol version spoken by the node, and the oldest
+// version it can talk with. protocolVersion is bumped on every
+// incompatible change of the packets, and a connection uses the
+// newest version spoken by both peers.
+const (
+	protocolVersion    = 8
+	minProtocolVersion = 1
+)
+
+// hello is the first packet sent by both sides of a connection. The
+// connection is closed with a peerRejection when the peers are not
+// compatible.
+type hello struct {
+	ProtocolVersion uint32
+	// the oldest version spoken by the node, 0 means
+	// ProtocolVersion.
+	MinProtocolVersion uint32
+	Genesis            Hash
+	// the software version of the node, for diagnosis only
+	Software string
+	// the address the node listens on, its host can be
+	// unspecified. It is the external address of the port mapped
+	// on the NAT router when the node is behind one.
+	ListenAddr string
+	// a random nonce making the transcript of the handshake
+	// unique, see transcript.
+	Nonce Hash
+	// the number of the items served from a getData, from
+	// getDataCapProtocolVersion.
+	MaxGetData uint32
+}
+
+func (n *network) hello() *hello {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	h := &hello{
+		ProtocolVersion:    protocolVersion,
+		MinProtocolVersion: minProtocolVersion,
+		Genesis:            n.genesis,
+		Software:           n.software,
+		ListenAddr:         n.advertisedAddr(),
+		MaxGetData:         uint32(n.maxGetData),
+	}
+	_, err := rand.Read(h.Nonce[:])
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// negotiate returns the newest protocol version spoken by both the
+// node and the peer, or 0 if there is none.
+func negotiate(h *hello) uint32 {
+	min := h.MinProtocolVersion
+	if min == 0 {
+		min = h.ProtocolVersion
+	}
+
+	v := h.ProtocolVersion
+	if v > protocolVersion {
+		v = protocolVersion
+	}
+
+	if v < min || v < minProtocolVersion {
+		return 0
+	}
+	return v
+}
+
+// checkHello returns the reason why the peer is not compatible, or
+// empty if it is.
+func (n *network) checkHello(h *hello) string {
+	if negotiate(h) == 0 {
+		return fmt.Sprintf("unsupported protocol versions [%d, %d], supported versions are [%d, %d]", h.MinProtocolVersion, h.ProtocolVersion, minProtocolVersion, protocolVersion)
+	}
+
+	if h.Genesis != n.genesis {
+		return fmt.Sprintf("genesis mismatch, expected %v, got %v", n.genesis, h.Genesis)
+	}
+
+	return ""
+}
+
+// sendHello sends the node's hello and reads the peer's hello, the
+// connection uses the negotiated protocol version and the transcript
+// of the hellos afterwards. The caller must close the connection if
+// it fails.
+func (n *network) sendHello(conn *conn) (*hello, error) {
+	conn.conn.SetDeadline(time.Now().Add(n.handshakeTimeout))
+	defer conn.conn.SetDeadline(time.Time{})
+
+	mine := n.hello()
+	err := conn.Write(packet{Data: mine})
+	if err != nil {
+		return nil, err
+	}
+
+	pac, err := conn.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := pac.Data.(type) {
+	case *hello:
+		if reason := n.checkHello(v); reason != "" {
+			conn.Write(packet{Data: &peerRejection{Reason: reason}})
+			return nil, fmt.Errorf("incompatible peer: %s", reason)
+		}
+
+		conn.version = negotiate(v)
+		conn.setTranscript(mine, v)
+		conn.setPeerMaxGetData(v)
+		return v, nil
+	case *peerRejection:
+		return nil, fmt.Errorf("peer rejected the connection: %s", v.Reason)
+	default:
+		return nil, fmt.Errorf("the first packet should be a hello, got %T", v)
+	}
+}
+
+// acceptHello reads the peer's hello and replies with the node's
+// hello, or a peerRejection if the peer is not compatible. The
+// connection uses the negotiated protocol version and the transcript
+// of the hellos afterwards. The
+// caller must set the handshake deadline, and close the connection
+// if it fails.
+func (n *network) acceptHello(conn *conn) (*hello, error) {
+	pac, err := conn.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	h, ok := pac.Data.(*hello)
+	if !ok {
+		return nil, fmt.Errorf("the first packet should be a hello, got %T", pac.Data)
+	}
+
+	if reason := n.checkHello(h); reason != "" {
+		conn.Write(packet{Data: &peerRejection{Reason: reason}})
+		return nil, fmt.Errorf("incompatible peer: %s", reason)
+	}
+
+	mine := n.hello()
+	err = conn.Write(packet{Data: mine})
+	if err != nil {
+		return nil, err
+	}
+
+	conn.version = negotiate(h)
+	conn.setTranscript(h, mine)
+	conn.setPeerMaxGetData(h)
+	return h, nil
+}
+
+// peerListenAddr returns the address of an inbound peer: the address
+// the peer listens on, with the host of the connection when the
+// listen host is unspecified.
+func peerListenAddr(remote net.Addr, listenAddr string, port uint16) string {
+	remoteHost, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		remoteHost = remote.String()
+	}
+
+	host, p, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return net.JoinHostPort(remoteHost, strconv.Itoa(int(port)))
+	}
+
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = remoteHost
+	}
+	return net.JoinHostPort(host, p)
+}
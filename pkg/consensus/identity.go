@@ -0,0 +1,131 @@
+package consensus
+
+import (
+	"net"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// identityProtocolVersion is the protocol version from which the
+// peers sign the transcript of the handshake in the connect requests,
+// proving they own the keys of their IDs on the connection. The
+// signatures of the older versions can be replayed by anyone, so
+// their peers are treated as the observers.
+const identityProtocolVersion = 5
+
+// identityHello is a hello without the fields added after
+// identityProtocolVersion, the transcripts of the older versions are
+// of the hellos in this form, as the peers speaking them know.
+type identityHello struct {
+	ProtocolVersion    uint32
+	MinProtocolVersion uint32
+	Genesis            Hash
+	Software           string
+	ListenAddr         string
+	Nonce              Hash
+}
+
+func toIdentityHello(h *hello) *identityHello {
+	return &identityHello{
+		ProtocolVersion:    h.ProtocolVersion,
+		MinProtocolVersion: h.MinProtocolVersion,
+		Genesis:            h.Genesis,
+		Software:           h.Software,
+		ListenAddr:         h.ListenAddr,
+		Nonce:              h.Nonce,
+	}
+}
+
+// transcript returns the hash of the hellos of the dialing and the
+// accepting peers, it is unique to the connection since the hellos
+// carry random nonces. The hellos are in the form known by the
+// protocol version.
+func transcript(dialer, acceptor *hello, version uint32) Hash {
+	var v interface{} = []*hello{dialer, acceptor}
+	if version < getDataCapProtocolVersion {
+		v = []*identityHello{toIdentityHello(dialer), toIdentityHello(acceptor)}
+	}
+
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		panic(err)
+	}
+	return SHA3(b)
+}
+
+// setTranscript sets the transcript of the handshake if the protocol
+// version binds the connect requests to it.
+func (p *conn) setTranscript(dialer, acceptor *hello) {
+	if p.version >= identityProtocolVersion {
+		p.transcript = transcript(dialer, acceptor, p.version)
+	}
+}
+
+// bytesToSign returns the bytes signed in the connect request, the
+// transcript is appended when it is set.
+func (c *connectRequest) bytesToSign(transcript Hash) []byte {
+	b := c.ByteToSign()
+	if transcript != (Hash{}) {
+		b = append(b, transcript[:]...)
+	}
+	return b
+}
+
+// sign signs the connect request with the key, bound to the
+// transcript of the connection.
+func (c *connectRequest) sign(sk SK, transcript Hash) {
+	c.PK = sk.MustPK()
+	c.Sig = sk.Sign(c.bytesToSign(transcript))
+}
+
+// verify returns true if the connect request is signed by its key,
+// bound to the transcript of the connection.
+func (c *connectRequest) verify(transcript Hash) bool {
+	return c.Sig.Verify(c.PK, c.bytesToSign(transcript))
+}
+
+// setIdentity records the ID proved by the peer in the connect
+// request and the host of the peer. The peer is an observer if it
+// could not prove the ID on the connection, or the ID is not a
+// validator's.
+func (n *network) setIdentity(p *conn, pk PK, host string) {
+	p.id = pk.Addr()
+	p.host = host
+	p.observer = p.transcript == (Hash{}) || (n.validator != nil && !n.validator(p.id))
+}
+
+// hostOf returns the host of the address, or the address if it has
+// no port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// isBannedPeer returns true if the peer's ID or network is banned,
+// or the peer is an observer connecting from a banned host. The
+// observers can change their IDs freely, so their hosts are banned
+// too. The caller must hold n.mu.
+func (n *network) isBannedPeer(p *conn) bool {
+	if n.isBanned(p.id) || n.isBannedHost(p.host) {
+		return true
+	}
+
+	if !p.observer {
+		return false
+	}
+
+	until, ok := n.bannedHosts[p.host]
+	if !ok {
+		return false
+	}
+
+	if !until.IsZero() && time.Now().After(until) {
+		delete(n.bannedHosts, p.host)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,234 @@
+package consensus
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// batchProtocolVersion is the protocol version from which the item
+// announcements and the item requests to a peer are batched in the
+// inventory and the getData packets. They are sent one at a time to
+// the peers speaking the older versions.
+const batchProtocolVersion = 4
+
+// getDataCapProtocolVersion is the protocol version from which the
+// peers advertise the number of the items they serve from a getData
+// in the hello, the requests are sent in the getData pages of at
+// most that many items. The items over the cap are ignored, and sent
+// back in a truncatedGetData to be requested again.
+const getDataCapProtocolVersion = 8
+
+const (
+	// defaultAnnounceDelay is the default of how long the item
+	// announcements and requests wait in a batch.
+	defaultAnnounceDelay = 20 * time.Millisecond
+	// maxBatchItems is the number of the items in a batch after
+	// which it is sent without waiting for the delay.
+	maxBatchItems = 512
+	// defaultMaxGetData is the default number of the items served
+	// from a getData.
+	defaultMaxGetData = maxBatchItems
+)
+
+// inventory is a batch of the item announcements.
+type inventory []Item
+
+// getData is a batch of the item requests.
+type getData []Item
+
+// truncatedGetData is the items of a getData ignored over the cap,
+// the peer requests them again.
+type truncatedGetData []Item
+
+// hasUnknownItem returns true if the data announces or requests an
+// item of an unknown type, which a peer must not send.
+func hasUnknownItem(data interface{}) bool {
+	var items []Item
+	switch v := data.(type) {
+	case Item:
+		return !v.T.known()
+	case itemRequest:
+		return !v.T.known()
+	case *request:
+		return !v.Item.T.known()
+	case inventory:
+		items = v
+	case getData:
+		items = v
+	case truncatedGetData:
+		items = v
+	}
+
+	for _, item := range items {
+		if !item.T.known() {
+			return true
+		}
+	}
+	return false
+}
+
+// itemPriority is the order the item types are sent in a batch, the
+// receiver handles the consensus items before the txns.
+var itemPriority = map[itemType]int{
+	randBeaconSigItem:      0,
+	blockItem:              1,
+	blockProposalItem:      2,
+	ntShareItem:            3,
+	randBeaconSigShareItem: 4,
+	sysTxnItem:             5,
+	txnItem:                6,
+}
+
+// setAnnounceDelay sets how long the item announcements and requests
+// to a peer wait to be sent in a batch, 0 uses the default and a
+// negative delay sends them at once.
+func (n *network) setAnnounceDelay(d time.Duration) {
+	if d == 0 {
+		d = defaultAnnounceDelay
+	}
+
+	n.mu.Lock()
+	n.announceDelay = d
+	n.mu.Unlock()
+}
+
+// setMaxGetData sets the number of the items served from a getData,
+// 0 uses the default.
+func (n *network) setMaxGetData(max int) {
+	if max <= 0 {
+		max = defaultMaxGetData
+	}
+
+	n.mu.Lock()
+	n.maxGetData = max
+	n.mu.Unlock()
+}
+
+// setPeerMaxGetData records the cap of the getData advertised by the
+// peer in the hello.
+func (p *conn) setPeerMaxGetData(h *hello) {
+	if p.version >= getDataCapProtocolVersion {
+		p.peerMaxGetData = int(h.MaxGetData)
+	}
+}
+
+// getDataPage returns the number of the items sent in a getData to
+// the peer.
+func (p *conn) getDataPage() int {
+	if p.peerMaxGetData > 0 {
+		return p.peerMaxGetData
+	}
+	return maxBatchItems
+}
+
+// truncateGetData returns the items of the getData within the cap.
+// The items over the cap are sent back to the peers that can request
+// them again, at most maxBatchItems of them, and the peers that
+// ignored the advertised cap are penalized.
+func (n *network) truncateGetData(addr unicastAddr, c *conn, items getData) getData {
+	n.mu.Lock()
+	max := n.maxGetData
+	n.mu.Unlock()
+
+	if len(items) <= max {
+		return items
+	}
+
+	ignored := items[max:]
+	log.Debug("truncating the getData over the cap", "addr", addr.Addr, "items", len(items), "cap", max)
+	if c.version < getDataCapProtocolVersion {
+		return items[:max]
+	}
+
+	n.penalize(addr)
+	if len(ignored) > maxBatchItems {
+		ignored = ignored[:maxBatchItems]
+	}
+
+	err := c.send(packet{Data: truncatedGetData(ignored)})
+	if err != nil && err != errClosing {
+		log.Warn("error sending the truncated getData", "addr", addr.Addr, "err", err)
+	}
+	return items[:max]
+}
+
+// batch queues the item announcement or request to the peer, it
+// returns false if the data is neither or the peer does not speak a
+// batching protocol version, then the data should be sent at once.
+// The batch is sent after the delay, or when it is full.
+func (p *conn) batch(data interface{}, delay time.Duration) bool {
+	if delay <= 0 || p.version < batchProtocolVersion {
+		return false
+	}
+
+	p.batchMu.Lock()
+	switch v := data.(type) {
+	case Item:
+		p.announcing = append(p.announcing, v)
+	case itemRequest:
+		p.requesting = append(p.requesting, Item(v))
+	default:
+		p.batchMu.Unlock()
+		return false
+	}
+
+	full := len(p.announcing) >= maxBatchItems || len(p.requesting) >= p.getDataPage()
+	if !full && p.flushTimer == nil {
+		p.flushTimer = time.AfterFunc(delay, p.flushBatch)
+	}
+	p.batchMu.Unlock()
+
+	if full {
+		p.flushBatch()
+	}
+	return true
+}
+
+// flushBatch sends the queued item announcements and requests, the
+// items are grouped by their types, and the requests are paginated by
+// the peer's cap of the getData. The connection is closed if the
+// write fails, so that the peer is dropped.
+func (p *conn) flushBatch() {
+	p.batchMu.Lock()
+	announcing, requesting := p.announcing, p.requesting
+	p.announcing, p.requesting = nil, nil
+	if p.flushTimer != nil {
+		p.flushTimer.Stop()
+		p.flushTimer = nil
+	}
+	p.batchMu.Unlock()
+
+	var err error
+	if len(announcing) > 0 {
+		sortByPriority(announcing)
+		err = p.send(packet{Data: inventory(announcing)})
+	}
+
+	if len(requesting) > 0 {
+		sortByPriority(requesting)
+	}
+
+	page := p.getDataPage()
+	for err == nil && len(requesting) > 0 {
+		n := page
+		if n > len(requesting) {
+			n = len(requesting)
+		}
+
+		err = p.send(packet{Data: getData(requesting[:n])})
+		requesting = requesting[n:]
+	}
+
+	if err != nil && err != errClosing {
+		log.Warn("error sending the batched items, closing the connection", "err", err)
+		p.Close()
+	}
+}
+
+func sortByPriority(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return itemPriority[items[i].T] < itemPriority[items[j].T]
+	})
+}
@@ -0,0 +1,74 @@
+package consensus
+
+import (
+	"net"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+const (
+	// defaultKeepaliveInterval is how long a peer can be idle
+	// before it is pinged.
+	defaultKeepaliveInterval = 15 * time.Second
+	// defaultReadTimeout is how long a peer can send nothing
+	// before it is disconnected as dead.
+	defaultReadTimeout = time.Minute
+	// tcpKeepAlive is the period of the TCP keep-alives of the
+	// peer connections, the backstop of the keepalive pings.
+	tcpKeepAlive = 30 * time.Second
+)
+
+// setKeepalive sets how long a peer can be idle before it is pinged,
+// and how long it can send nothing before it is disconnected as
+// dead, 0 uses the defaults. The read timeout should be several
+// keepalive intervals, so a live peer has the time to answer the
+// pings.
+func (n *network) setKeepalive(interval, readTimeout time.Duration) {
+	if interval <= 0 {
+		interval = defaultKeepaliveInterval
+	}
+
+	if readTimeout <= 0 {
+		readTimeout = defaultReadTimeout
+	}
+
+	n.mu.Lock()
+	n.keepaliveInterval = interval
+	n.readTimeout = readTimeout
+	n.mu.Unlock()
+}
+
+// keepalivePeers pings the peers idle for the keepalive interval, so
+// that the live peers always send something before the read timeout.
+func (n *network) keepalivePeers() {
+	n.mu.Lock()
+	interval := n.keepaliveInterval
+	n.mu.Unlock()
+
+	for range time.Tick(interval / 2) {
+		n.mu.Lock()
+		for addr, c := range n.conns {
+			if c.idle() >= interval {
+				go n.pingPeer(addr, c)
+			}
+		}
+		n.mu.Unlock()
+	}
+}
+
+// isTimeout returns true if the error is a timeout of the connection.
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+// logReadErr logs the error reading from the peer.
+func logReadErr(addr unicastAddr, err error) {
+	if isTimeout(err) {
+		log.Info("disconnecting the dead peer sending nothing", "addr", addr.Addr)
+		return
+	}
+
+	log.Warn("read peer conn error", "err", err)
+}
@@ -0,0 +1,212 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// The lifetime of the port mappings requested from the router, and
+// how often they are refreshed before they expire.
+const (
+	natMappingLifetime = 20 * time.Minute
+	natRefreshInterval = 15 * time.Minute
+)
+
+// the modes of Config.NAT
+const (
+	NATNone = "none"
+	NATAny  = "any"
+	NATUPnP = "upnp"
+	NATPMP  = "pmp"
+)
+
+// errNoNAT is returned when no router supporting the protocol is
+// found.
+var errNoNAT = errors.New("no NAT router found")
+
+// natClient maps the ports on the router of a node behind a NAT, so
+// that the peers outside the local network can connect to the node.
+type natClient interface {
+	// ExternalIP returns the IP of the router on the internet.
+	ExternalIP() (net.IP, error)
+	// AddMapping maps the external port of the router to the
+	// internal port of the node for the lifetime, it returns the
+	// external port mapped, which can be different from the
+	// requested one.
+	AddMapping(protocol string, extPort, intPort int, name string, lifetime time.Duration) (int, error)
+	String() string
+}
+
+// newNAT returns the NAT client of the mode, nil for NATNone.
+func newNAT(mode string) (natClient, error) {
+	switch mode {
+	case "", NATNone:
+		return nil, nil
+	case NATAny:
+		return &anyNAT{clients: []natClient{newUPnP(), newPMP()}}, nil
+	case NATUPnP:
+		return newUPnP(), nil
+	case NATPMP:
+		return newPMP(), nil
+	default:
+		return nil, fmt.Errorf("unknown NAT mode %q, supported modes are %s, %s, %s and %s", mode, NATNone, NATAny, NATUPnP, NATPMP)
+	}
+}
+
+// anyNAT uses the first of the clients that finds a router.
+type anyNAT struct {
+	clients []natClient
+
+	mu    sync.Mutex
+	found natClient
+}
+
+func (a *anyNAT) ExternalIP() (net.IP, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.found != nil {
+		ip, err := a.found.ExternalIP()
+		if err == nil {
+			return ip, nil
+		}
+		a.found = nil
+	}
+
+	for _, c := range a.clients {
+		ip, err := c.ExternalIP()
+		if err == nil {
+			a.found = c
+			return ip, nil
+		}
+	}
+	return nil, errNoNAT
+}
+
+func (a *anyNAT) AddMapping(protocol string, extPort, intPort int, name string, lifetime time.Duration) (int, error) {
+	a.mu.Lock()
+	c := a.found
+	a.mu.Unlock()
+	if c == nil {
+		return 0, errNoNAT
+	}
+
+	return c.AddMapping(protocol, extPort, intPort, name, lifetime)
+}
+
+func (a *anyNAT) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.found != nil {
+		return a.found.String()
+	}
+	return NATAny
+}
+
+// setNAT sets the client mapping the listen port when the network
+// starts, nil disables the port mapping.
+func (n *network) setNAT(c natClient) {
+	n.mu.Lock()
+	n.nat = c
+	n.mu.Unlock()
+}
+
+// mapPort maps the listen port on the router and advertises the
+// external address to the peers. When the mapping fails the node
+// advertises its listen address, the peers outside the local
+// network can not connect to it, but it still connects to them.
+func (n *network) mapPort() {
+	n.mu.Lock()
+	c := n.nat
+	port := int(n.port)
+	n.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	addr, err := natAddr(c, port)
+
+	n.mu.Lock()
+	prev := n.advertised
+	tried := n.natTried
+	n.advertised = addr
+	n.natTried = true
+	n.mu.Unlock()
+
+	if err != nil {
+		if !tried || prev != "" {
+			log.Warn("NAT port mapping failed, only the peers in the local network can connect to the node", "nat", c, "err", err)
+		}
+		return
+	}
+
+	if addr != prev {
+		log.Info("mapped the listen port on the NAT router", "nat", c, "external", addr)
+	}
+}
+
+// natAddr maps the port on the router, it returns the external
+// address of the mapped port.
+func natAddr(c natClient, port int) (string, error) {
+	ip, err := c.ExternalIP()
+	if err != nil {
+		return "", err
+	}
+
+	if ip == nil || ip.IsUnspecified() {
+		return "", fmt.Errorf("invalid external IP %v", ip)
+	}
+
+	extPort, err := c.AddMapping("tcp", port, port, "dex", natMappingLifetime)
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(extPort)), nil
+}
+
+// natLoop refreshes the port mapping before it expires, it also
+// picks up a router that appears or changes its external IP.
+func (n *network) natLoop() {
+	for range time.Tick(natRefreshInterval) {
+		n.mapPort()
+	}
+}
+
+// advertisedAddr returns the address advertised to the peers: the
+// external address of the mapped port, or the listen address if the
+// port is not mapped. The caller must hold n.mu.
+func (n *network) advertisedAddr() string {
+	if n.advertised != "" {
+		return n.advertised
+	}
+	return n.listenAddr
+}
+
+// advertisedPort returns the port of advertisedAddr.
+func (n *network) advertisedPort() uint16 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.advertised == "" {
+		return n.port
+	}
+
+	_, p, err := net.SplitHostPort(n.advertised)
+	if err != nil {
+		return n.port
+	}
+
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return n.port
+	}
+	return uint16(port)
+}
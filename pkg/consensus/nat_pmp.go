@@ -0,0 +1,225 @@
+package consensus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the port of the NAT-PMP server on the router, see RFC 6886.
+const pmpPort = 5351
+
+// pmpTries is the number of the times a NAT-PMP request is sent, the
+// wait for the reply starts at 250ms and doubles every time.
+const pmpTries = 3
+
+// pmp is the NAT-PMP client. The router is the first of the
+// potential gateways that replies, it is looked up again when it
+// stops replying.
+type pmp struct {
+	mu sync.Mutex
+	gw net.IP
+}
+
+func newPMP() *pmp {
+	return &pmp{}
+}
+
+func (p *pmp) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.gw == nil {
+		return "nat-pmp"
+	}
+	return "nat-pmp(" + p.gw.String() + ")"
+}
+
+func (p *pmp) ExternalIP() (net.IP, error) {
+	r, err := p.call([]byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.IPv4(r[8], r[9], r[10], r[11]), nil
+}
+
+func (p *pmp) AddMapping(protocol string, extPort, intPort int, name string, lifetime time.Duration) (int, error) {
+	var op byte
+	switch strings.ToLower(protocol) {
+	case "udp":
+		op = 1
+	case "tcp":
+		op = 2
+	default:
+		return 0, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+
+	msg := make([]byte, 12)
+	msg[1] = op
+	binary.BigEndian.PutUint16(msg[4:], uint16(intPort))
+	binary.BigEndian.PutUint16(msg[6:], uint16(extPort))
+	binary.BigEndian.PutUint32(msg[8:], uint32(lifetime/time.Second))
+	r, err := p.call(msg, 16)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(binary.BigEndian.Uint16(r[10:])), nil
+}
+
+// call sends the request to the router, and returns the reply of
+// the size.
+func (p *pmp) call(msg []byte, size int) ([]byte, error) {
+	p.mu.Lock()
+	gw := p.gw
+	p.mu.Unlock()
+
+	gws := []net.IP{gw}
+	if gw == nil {
+		gws = potentialGateways()
+	}
+
+	err := errNoNAT
+	for _, gw := range gws {
+		var r []byte
+		r, err = pmpCall(gw, msg, size)
+		if err == nil {
+			p.mu.Lock()
+			p.gw = gw
+			p.mu.Unlock()
+			return r, nil
+		}
+	}
+
+	p.mu.Lock()
+	p.gw = nil
+	p.mu.Unlock()
+	return nil, err
+}
+
+func pmpCall(gw net.IP, msg []byte, size int) ([]byte, error) {
+	c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gw, Port: pmpPort})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	r := make([]byte, 16)
+	wait := 250 * time.Millisecond
+	for i := 0; i < pmpTries; i++ {
+		_, err = c.Write(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		c.SetReadDeadline(time.Now().Add(wait))
+		wait *= 2
+
+		var m int
+		m, err = c.Read(r)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+
+		if m < size || r[0] != 0 || r[1] != msg[1]|0x80 {
+			return nil, errors.New("invalid NAT-PMP reply")
+		}
+
+		if code := binary.BigEndian.Uint16(r[2:]); code != 0 {
+			return nil, fmt.Errorf("NAT-PMP error code %d", code)
+		}
+
+		return r[:size], nil
+	}
+	return nil, err
+}
+
+// potentialGateways returns the default gateways of the routing
+// table, or guesses them from the private IPv4 addresses of the
+// interfaces when the table is not available.
+func potentialGateways() []net.IP {
+	if gws := defaultGateways(); len(gws) > 0 {
+		return gws
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var gws []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip := ipNet.IP.To4()
+		if ip == nil || !isPrivateIPv4(ip) {
+			continue
+		}
+
+		gw := ip.Mask(ipNet.Mask)
+		gw[3] |= 1
+		if !gw.Equal(ip) {
+			gws = append(gws, gw)
+		}
+	}
+	return gws
+}
+
+// defaultGateways returns the default gateways in the Linux routing
+// table.
+func defaultGateways() []net.IP {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var gws []net.IP
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+
+		// the addresses are in the host byte order, which is
+		// little-endian on the supported platforms.
+		gw := net.IPv4(b[3], b[2], b[1], b[0])
+		if !gw.IsUnspecified() {
+			gws = append(gws, gw)
+		}
+	}
+	return gws
+}
+
+func isPrivateIPv4(ip net.IP) bool {
+	switch {
+	case ip[0] == 10:
+		return true
+	case ip[0] == 172 && ip[1]&0xf0 == 16:
+		return true
+	case ip[0] == 192 && ip[1] == 168:
+		return true
+	default:
+		return false
+	}
+}
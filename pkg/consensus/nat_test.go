@@ -0,0 +1,125 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeNAT struct {
+	mu       sync.Mutex
+	ip       net.IP
+	extPort  int
+	err      error
+	mappings []string
+}
+
+func (f *fakeNAT) set(ip string, extPort int, err error) {
+	f.mu.Lock()
+	f.ip = net.ParseIP(ip)
+	f.extPort = extPort
+	f.err = err
+	f.mu.Unlock()
+}
+
+func (f *fakeNAT) ExternalIP() (net.IP, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ip, f.err
+}
+
+func (f *fakeNAT) AddMapping(protocol string, extPort, intPort int, name string, lifetime time.Duration) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	f.mappings = append(f.mappings, fmt.Sprintf("%s %d->%d %v", protocol, extPort, intPort, lifetime))
+	return f.extPort, nil
+}
+
+func (f *fakeNAT) String() string {
+	return "fake"
+}
+
+func TestNATAdvertise(t *testing.T) {
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11026)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// the external address of the mapped port is advertised
+	nat := &fakeNAT{}
+	nat.set("203.0.113.7", 31027, nil)
+	n1 := makeNetwork()
+	n1.setNAT(nat)
+	_, err = n1.Start("127.0.0.1", 11027)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tcp 11027->11027 20m0s"}, nat.mappings)
+	assert.Equal(t, "203.0.113.7:31027", n1.hello().ListenAddr)
+	assert.Equal(t, uint16(31027), n1.advertisedPort())
+	assert.Equal(t, "127.0.0.1:11027", n1.ListenAddr())
+
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		n0.mu.Lock()
+		defer n0.mu.Unlock()
+		_, ok := n0.conns[unicastAddr{Addr: "203.0.113.7:31027", PKStr: string(n1.sk.MustPK())}]
+		return ok
+	})
+
+	// the listen address is advertised when the mapping fails
+	nat.set("", 0, errors.New("no router"))
+	n1.mapPort()
+	assert.Equal(t, "127.0.0.1:11027", n1.hello().ListenAddr)
+	assert.Equal(t, uint16(11027), n1.advertisedPort())
+
+	// the refresh picks up the new external IP
+	nat.set("203.0.113.8", 31027, nil)
+	n1.mapPort()
+	assert.Equal(t, "203.0.113.8:31027", n1.hello().ListenAddr)
+
+	// an unspecified external IP is not advertised
+	nat.set("0.0.0.0", 31027, nil)
+	n1.mapPort()
+	assert.Equal(t, "127.0.0.1:11027", n1.hello().ListenAddr)
+
+	// the node without a NAT client advertises its listen address
+	assert.Equal(t, addr0.Addr, n0.hello().ListenAddr)
+	assert.Equal(t, uint16(11026), n0.advertisedPort())
+}
+
+func TestNATAny(t *testing.T) {
+	none, found := &fakeNAT{}, &fakeNAT{}
+	none.set("", 0, errNoNAT)
+	found.set("203.0.113.7", 31000, nil)
+	a := &anyNAT{clients: []natClient{none, found}}
+
+	_, err := a.AddMapping("tcp", 31000, 31000, "dex", natMappingLifetime)
+	assert.Equal(t, errNoNAT, err)
+
+	addr, err := natAddr(a, 31000)
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.7:31000", addr)
+	assert.Equal(t, "fake", a.String())
+
+	// the client is looked up again when it stops working
+	found.set("", 0, errNoNAT)
+	_, err = natAddr(a, 31000)
+	assert.Equal(t, errNoNAT, err)
+	assert.Equal(t, NATAny, a.String())
+
+	_, err = newNAT("stun")
+	assert.NotNil(t, err)
+	c, err := newNAT("")
+	assert.Nil(t, err)
+	assert.Nil(t, c)
+}
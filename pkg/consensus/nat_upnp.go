@@ -0,0 +1,348 @@
+package consensus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upnpTimeout is how long the discovery and each UPnP call wait for
+// the router.
+const upnpTimeout = 3 * time.Second
+
+// the UPnP services that map the ports, by preference.
+var upnpServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnp is the UPnP IGD client. The router is discovered by SSDP, it
+// is discovered again when it stops replying.
+type upnp struct {
+	client *http.Client
+
+	mu  sync.Mutex
+	dev *upnpDevice
+}
+
+// upnpDevice is the port mapping service of a router.
+type upnpDevice struct {
+	service    string
+	controlURL string
+	// the IP of the node in the router's network
+	localIP net.IP
+}
+
+func newUPnP() *upnp {
+	return &upnp{client: &http.Client{Timeout: upnpTimeout}}
+}
+
+func (u *upnp) String() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.dev == nil {
+		return "upnp"
+	}
+	return "upnp(" + u.dev.controlURL + ")"
+}
+
+func (u *upnp) ExternalIP() (net.IP, error) {
+	r, err := u.call("GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(r["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external IP %q", r["NewExternalIPAddress"])
+	}
+	return ip, nil
+}
+
+func (u *upnp) AddMapping(protocol string, extPort, intPort int, name string, lifetime time.Duration) (int, error) {
+	dev, err := u.device()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = u.call("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(extPort)},
+		{"NewProtocol", strings.ToUpper(protocol)},
+		{"NewInternalPort", strconv.Itoa(intPort)},
+		{"NewInternalClient", dev.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", name},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return extPort, nil
+}
+
+// device returns the router, it discovers the router if it is not
+// known.
+func (u *upnp) device() (*upnpDevice, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.dev != nil {
+		return u.dev, nil
+	}
+
+	locations, err := ssdpSearch()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, loc := range locations {
+		dev, err := u.describe(loc)
+		if err == nil {
+			u.dev = dev
+			return dev, nil
+		}
+	}
+	return nil, errNoNAT
+}
+
+// call calls the action of the router's port mapping service, it
+// returns the output arguments.
+func (u *upnp) call(action string, args [][2]string) (map[string]string, error) {
+	dev, err := u.device()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := u.soap(dev, action, args)
+	if err != nil {
+		u.mu.Lock()
+		if u.dev == dev {
+			u.dev = nil
+		}
+		u.mu.Unlock()
+	}
+	return r, err
+}
+
+func (u *upnp) soap(dev *upnpDevice, action string, args [][2]string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, dev.service)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest("POST", dev.controlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, dev.service, action))
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r, err := xmlLeaves(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP %s failed: %s %s", action, resp.Status, r["errorDescription"])
+	}
+	return r, nil
+}
+
+// xmlLeaves returns the text of the leaf elements of the XML
+// document by their local names.
+func xmlLeaves(r io.Reader) (map[string]string, error) {
+	leaves := make(map[string]string)
+	dec := xml.NewDecoder(r)
+	var name string
+	var text []byte
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return leaves, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			text = text[:0]
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			if name == t.Name.Local {
+				leaves[name] = strings.TrimSpace(string(text))
+			}
+			name = ""
+		}
+	}
+}
+
+type upnpDesc struct {
+	URLBase string         `xml:"URLBase"`
+	Device  upnpDescDevice `xml:"device"`
+}
+
+type upnpDescDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDescDevice `xml:"deviceList>device"`
+}
+
+// services returns the control URLs of the services of the device
+// and its embedded devices, by the service types.
+func (d *upnpDescDevice) services(m map[string]string) {
+	for _, s := range d.Services {
+		m[s.ServiceType] = s.ControlURL
+	}
+
+	for i := range d.Devices {
+		d.Devices[i].services(m)
+	}
+}
+
+// describe fetches the description of the router at the location,
+// and returns its port mapping service.
+func (u *upnp) describe(location string) (*upnpDevice, error) {
+	resp, err := u.client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var desc upnpDesc
+	err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&desc)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if desc.URLBase != "" {
+		if b, err := url.Parse(desc.URLBase); err == nil {
+			base = b
+		}
+	}
+
+	m := make(map[string]string)
+	desc.Device.services(m)
+	for _, service := range upnpServices {
+		control, ok := m[service]
+		if !ok {
+			continue
+		}
+
+		controlURL, err := base.Parse(control)
+		if err != nil {
+			return nil, err
+		}
+
+		localIP, err := localIPTo(controlURL.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		return &upnpDevice{service: service, controlURL: controlURL.String(), localIP: localIP}, nil
+	}
+	return nil, errors.New("the UPnP device does not map ports")
+}
+
+// localIPTo returns the IP of the node used to reach the host.
+func localIPTo(hostport string) (net.IP, error) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+
+	c, err := net.Dial("udp", net.JoinHostPort(host, "1"))
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// ssdpSearch multicasts the SSDP search of the internet gateway
+// devices, it returns the locations of their descriptions.
+func ssdpSearch() ([]string, error) {
+	c, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	const msg = "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	_, err = c.WriteTo([]byte(msg), &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900})
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetReadDeadline(time.Now().Add(upnpTimeout))
+	seen := make(map[string]bool)
+	var locations []string
+	buf := make([]byte, 2048)
+	for {
+		m, _, err := c.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:m])), nil)
+		if err != nil {
+			continue
+		}
+
+		loc := resp.Header.Get("Location")
+		if loc != "" && !seen[loc] {
+			seen[loc] = true
+			locations = append(locations, loc)
+			// give the other routers a moment to reply, rather
+			// than waiting for the whole timeout.
+			c.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		}
+	}
+
+	if len(locations) == 0 {
+		return nil, errNoNAT
+	}
+	return locations, nil
+}
@@ -0,0 +1,85 @@
+package consensus
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// NetworkMetrics are the metrics of the network aggregated over the
+// peers, the counters include the peers disconnected since the start.
+type NetworkMetrics struct {
+	// Msgs is the traffic by the message types, see
+	// PeerTraffic.Msgs.
+	Msgs map[string]MsgTraffic
+	// the connected peers by the direction
+	Inbound  int
+	Outbound int
+	// HandshakeFailures is the number of the connections failed
+	// before the peers were registered, DecodeErrors is the
+	// number of the frames failed to decode.
+	HandshakeFailures uint64
+	DecodeErrors      uint64
+	// the gossiped items received, and the duplicates among them
+	// dropped by the gossip cache.
+	GossipReceived   uint64
+	GossipDuplicates uint64
+	// QueueDepths is the number of the packets waiting in the
+	// send queue of each peer by the send classes, see
+	// PeerTraffic.Queued.
+	QueueDepths map[string][]int
+}
+
+// DedupHitRate returns the fraction of the received gossiped items
+// dropped as the duplicates, 0 if none is received.
+func (m NetworkMetrics) DedupHitRate() float64 {
+	if m.GossipReceived == 0 {
+		return 0
+	}
+	return float64(m.GossipDuplicates) / float64(m.GossipReceived)
+}
+
+// wrapConn wraps the connection to a peer, its traffic is counted in
+// the network's traffic too.
+func (n *network) wrapConn(c net.Conn) *conn {
+	conn := newConn(c)
+	conn.traffic.total = n.traffic
+	return conn
+}
+
+// handshakeFailed counts a failed handshake.
+func (n *network) handshakeFailed() {
+	atomic.AddUint64(&n.traffic.handshakeFailures, 1)
+}
+
+// Metrics returns the metrics of the network.
+func (n *network) Metrics() NetworkMetrics {
+	t := n.traffic.snapshot()
+	m := NetworkMetrics{
+		Msgs:              t.Msgs,
+		HandshakeFailures: atomic.LoadUint64(&n.traffic.handshakeFailures),
+		DecodeErrors:      atomic.LoadUint64(&n.traffic.decodeErrors),
+		GossipReceived:    n.gossip.Received(),
+		GossipDuplicates:  n.gossip.Duplicates(),
+		QueueDepths:       make(map[string][]int, numSendClasses),
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, c := range n.conns {
+		if c.inbound {
+			m.Inbound++
+		} else {
+			m.Outbound++
+		}
+
+		if c.sendq == nil {
+			continue
+		}
+
+		for class, depth := range c.sendq.depths() {
+			m.QueueDepths[class] = append(m.QueueDepths[class], depth)
+		}
+	}
+	return m
+}
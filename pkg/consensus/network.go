@@ -1,12 +1,12 @@
 package consensus
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net"
-	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +17,9 @@ import (
 const (
 	timeoutDur = 5 * time.Second
 	intialConn = 8
+	// defaultPingInterval is how often the peers are pinged to
+	// measure the round trip time, and to find the dead peers.
+	defaultPingInterval = 30 * time.Second
 )
 
 type unicastAddr struct {
@@ -40,17 +43,151 @@ type network struct {
 	ch            chan packetAndAddr
 	onPeerConnect func(addr unicastAddr)
 
-	mu    sync.Mutex
-	conns map[unicastAddr]*conn
-	// nodes with a public IP
+	mu         sync.Mutex
+	listenAddr string
+	conns      map[unicastAddr]*conn
+	// nodes with a public IP, sent in the peer exchange. They are
+	// confirmed by a handshake, see addPublicNode.
 	publicNodes []unicastAddr
+	// the rate limit windows of the peer exchange by the peer's
+	// ID, and if the private addresses are rejected, see
+	// acceptAddrs.
+	exchanged  map[Addr]*exchangeWindow
+	publicOnly bool
+	// the bans by the peer's ID and by the network, and the time
+	// until which the hosts of the banned observers are banned,
+	// see isBannedPeer. The bans are saved in the ban file, see
+	// setBanFile.
+	banned      map[Addr]Ban
+	bannedNets  map[string]*netBan
+	bannedHosts map[string]time.Time
+	banFile     string
+	banFileMu   sync.Mutex
+	// the reputation of the peers by the peer's ID, see
+	// PeerInfo.Reputation.
+	reputation map[Addr]int
+	// the caps of the inbound and the outbound peers, 0 means
+	// unlimited. connecting is the number of the slots reserved
+	// for the peers being connected, by the direction.
+	maxInbound  int
+	maxOutbound int
+	connecting  map[bool]int
+	// priority returns true if the peer has the priority for the
+	// slots, nil means no peer has. validator returns true if the
+	// ID is a validator's, nil means every ID is, see
+	// setIdentity.
+	priority  func(id Addr) bool
+	validator func(id Addr) bool
+	// the outbound peers redialed when their connections drop,
+	// and the addresses being dialed.
+	known   map[unicastAddr]*redialState
+	dialing map[string]bool
+	backoff redialBackoff
+	// the file where the known peers are saved, and when the
+	// peers were last seen, see setPeerFile.
+	peerFile   string
+	peerMaxAge time.Duration
+	seen       map[unicastAddr]time.Time
+	// the reasons the peers gave when they last closed the
+	// connections, saved in the peer file.
+	disconnects map[unicastAddr]string
+	// sent in the hello, see hello.
+	genesis  Hash
+	software string
+	// the time allowed for dialing a peer and for the handshake
+	// of a connection, see setTimeouts.
+	dialTimeout      time.Duration
+	handshakeTimeout time.Duration
+	pingInterval     time.Duration
+	// how long a peer can be idle before it is pinged, and how
+	// long it can send nothing before it is disconnected, see
+	// setKeepalive.
+	keepaliveInterval time.Duration
+	readTimeout       time.Duration
+	// how long the item announcements and requests to a peer wait
+	// to be sent in a batch, see setAnnounceDelay.
+	announceDelay time.Duration
+	// the number of the items served from a getData, see
+	// setMaxGetData.
+	maxGetData int
+	// the number of the packets queued to a peer, and how long
+	// the queue can stay full before the peer is disconnected,
+	// see setSendQueue.
+	sendQueueSize     int
+	saturationTimeout time.Duration
+	// the rate limits of the messages from a peer by their types,
+	// see setRateLimits.
+	rateLimits map[string]RateLimit
+	gossip     *gossipCache
+	// the traffic of all the peers, see Metrics.
+	traffic *trafficStats
+	// the number of the peers a gossiped item is pushed to, see
+	// setGossipFanout.
+	fanout int
+	// the node only dials the peers, it does not listen for the
+	// connections, see setOutboundOnly.
+	outboundOnly bool
+	// the window of the peer traffic rates, and the outbound
+	// bytes per window of a peer, see setBandwidth.
+	bandwidthWindow time.Duration
+	outboundBudget  uint64
+	// the client mapping the listen port on the NAT router, and
+	// the external address of the mapped port advertised to the
+	// peers, see mapPort.
+	nat        natClient
+	advertised string
+	natTried   bool
+	// the host:port of the DNS seeds, the resolver of their
+	// hosts, and how often they are resolved again while the
+	// node has few peers.
+	dnsSeeds        []string
+	lookupHost      func(ctx context.Context, host string) ([]string, error)
+	dnsSeedInterval time.Duration
+	// the transport of the peer connections, the listener
+	// accepting them, and the context canceled when the network
+	// stops, see Stop.
+	transport transport
+	ln        net.Listener
+	ctx       context.Context
+	stop      context.CancelFunc
 }
 
 func newNetwork(sk SK) *network {
+	ctx, stop := context.WithCancel(context.Background())
 	return &network{
-		sk:    sk,
-		ch:    make(chan packetAndAddr, 100),
-		conns: make(map[unicastAddr]*conn),
+		sk:          sk,
+		ch:          make(chan packetAndAddr, 100),
+		conns:       make(map[unicastAddr]*conn),
+		banned:      make(map[Addr]Ban),
+		bannedNets:  make(map[string]*netBan),
+		bannedHosts: make(map[string]time.Time),
+		reputation:  make(map[Addr]int),
+		connecting:  make(map[bool]int),
+		known:       make(map[unicastAddr]*redialState),
+		dialing:     make(map[string]bool),
+		backoff:     defaultRedialBackoff,
+		seen:        make(map[unicastAddr]time.Time),
+		disconnects: make(map[unicastAddr]string),
+		exchanged:   make(map[Addr]*exchangeWindow),
+
+		dialTimeout:       timeoutDur,
+		handshakeTimeout:  timeoutDur,
+		transport:         tcpTransport{},
+		ctx:               ctx,
+		stop:              stop,
+		pingInterval:      defaultPingInterval,
+		keepaliveInterval: defaultKeepaliveInterval,
+		readTimeout:       defaultReadTimeout,
+		announceDelay:     defaultAnnounceDelay,
+		maxGetData:        defaultMaxGetData,
+		sendQueueSize:     defaultSendQueueSize,
+		saturationTimeout: defaultSaturationTimeout,
+		rateLimits:        defaultRateLimits,
+		bandwidthWindow:   defaultBandwidthWindow,
+		lookupHost:        net.DefaultResolver.LookupHost,
+		dnsSeedInterval:   defaultDNSSeedInterval,
+		gossip:            newGossipCache(0, 0),
+		traffic:           newTrafficStats(),
 	}
 }
 
@@ -58,18 +195,51 @@ func newNetwork(sk SK) *network {
 // TODO: periodically ping peer and remove peer if offline
 
 func (n *network) acceptPeerOrDisconnect(c net.Conn) {
-	conn := newConn(c)
+	host := hostOf(c.RemoteAddr().String())
+	n.mu.Lock()
+	banned := n.isBannedHost(host)
+	n.mu.Unlock()
+	if banned {
+		log.Info("refused connection from banned host", "host", host)
+		c.Close()
+		return
+	}
+
+	c.SetDeadline(time.Now().Add(n.handshakeTimeout))
+	conn := n.wrapConn(c)
+	h, err := n.acceptHello(conn)
+	if err != nil {
+		log.Warn("handshake of newly accepted conn failed", "remote", c.RemoteAddr(), "err", err)
+		n.handshakeFailed()
+		conn.Close()
+		return
+	}
+
 	pac, err := conn.Read()
 	if err != nil {
 		log.Warn("err read from newly accepted conn", "err", err)
+		n.handshakeFailed()
+		conn.Close()
 		return
 	}
 
 	var recv *connectRequest
 	switch v := pac.Data.(type) {
 	case *connectRequest:
-		if !v.Sig.Verify(v.PK, v.ByteToSign()) {
+		if !v.verify(conn.transcript) {
 			log.Warn("connect request signature validation failed")
+			n.handshakeFailed()
+			conn.Close()
+			return
+		}
+
+		n.setIdentity(conn, v.PK, host)
+		n.mu.Lock()
+		banned := n.isBannedPeer(conn)
+		n.mu.Unlock()
+		if banned {
+			log.Info("refused connection from banned peer", "id", v.PK.Addr())
+			conn.Close()
 			return
 		}
 
@@ -80,37 +250,56 @@ func (n *network) acceptPeerOrDisconnect(c net.Conn) {
 		conn.Write(packet{Data: ack{}})
 		conn.Close()
 		return
+	case *peerRejection:
+		log.Info("peer closed the connection", "remote", c.RemoteAddr(), "reason", v.Reason)
+		conn.Close()
+		return
 	default:
 		log.Warn("first received packet should be a connect request or an ack")
+		conn.Close()
 		return
 	}
 
-	ip := strings.Split(c.RemoteAddr().String(), ":")[0]
-	addrStr := fmt.Sprintf("%s:%d", ip, recv.Port)
+	addrStr := peerListenAddr(c.RemoteAddr(), h.ListenAddr, recv.Port)
 	addr := unicastAddr{Addr: addrStr, PKStr: string(recv.PK)}
-	go func() {
-		// check if the connecting node is a public node
-		ctx, cancel := context.WithTimeout(context.Background(), timeoutDur)
-		isPubAddr := n.isPubAddr(ctx, addrStr)
-		cancel()
-		if isPubAddr {
-			n.mu.Lock()
-			n.publicNodes = append(n.publicNodes, addr)
-			n.mu.Unlock()
-		}
-	}()
-
 	n.mu.Lock()
-	pubNodes := dedup(n.publicNodes)
+	err = n.checkPeerAddr(c.RemoteAddr().String(), addr)
+	pubNodes := n.exchangedNodes()
 	n.mu.Unlock()
+	if err != nil {
+		log.Debug("not checking the invalid listen address of the peer", "addr", addrStr, "err", err)
+	} else {
+		go func() {
+			// check if the connecting node is a public node
+			ctx, cancel := context.WithTimeout(n.ctx, n.dialTimeout+n.handshakeTimeout)
+			isPubAddr := n.isPubAddr(ctx, addrStr)
+			cancel()
+			if isPubAddr {
+				n.mu.Lock()
+				n.addPublicNode(addr)
+				n.mu.Unlock()
+			}
+		}()
+	}
 
 	conn.Write(packet{Data: pubNodes})
 
+	if !recv.GetNodesOnly {
+		n.mu.Lock()
+		ok := n.reserve(recv.PK.Addr(), conn.observer, true)
+		n.mu.Unlock()
+		if !ok {
+			log.Info("rejected inbound peer", "addr", addrStr, "reason", tooManyPeers)
+			conn.Write(packet{Data: &peerRejection{Reason: tooManyPeers}})
+			conn.Close()
+			return
+		}
+	}
+
 	// send a connect reuqest just to tell the other node about my
 	// public key.
 	req := &connectRequest{}
-	req.PK = n.sk.MustPK()
-	req.Sig = n.sk.Sign(req.ByteToSign())
+	req.sign(n.sk, conn.transcript)
 	conn.Write(packet{Data: req})
 
 	if recv.GetNodesOnly {
@@ -118,9 +307,11 @@ func (n *network) acceptPeerOrDisconnect(c net.Conn) {
 		return
 	}
 
+	c.SetDeadline(time.Time{})
+	conn.software = h.Software
 	n.mu.Lock()
-	n.conns[addr] = conn
-	go n.readConn(addr, conn)
+	n.release(true)
+	n.addConn(addr, conn, true)
 	n.mu.Unlock()
 
 	if n.onPeerConnect != nil {
@@ -128,10 +319,30 @@ func (n *network) acceptPeerOrDisconnect(c net.Conn) {
 	}
 }
 
+// Start starts listening for the peer connections on host:port. In
+// the outbound-only mode nothing is listened on, and the returned
+// address is empty.
 func (n *network) Start(host string, port int) (unicastAddr, error) {
+	err := n.loadBans()
+	if err != nil {
+		return unicastAddr{}, fmt.Errorf("error loading the bans: %v", err)
+	}
+
+	go n.pingPeers()
+	go n.keepalivePeers()
+	go n.savePeersLoop()
+
+	n.mu.Lock()
+	outboundOnly := n.outboundOnly
+	n.mu.Unlock()
+	if outboundOnly {
+		log.Info("outbound-only mode, not listening for the peer connections")
+		return unicastAddr{PKStr: string(n.sk.MustPK())}, nil
+	}
+
 	n.port = uint16(port)
 	addr := fmt.Sprintf("%s:%d", host, port)
-	ln, err := net.Listen("tcp", addr)
+	ln, err := n.transport.Listen(addr)
 	if err != nil {
 		panic(err)
 	}
@@ -140,16 +351,101 @@ func (n *network) Start(host string, port int) (unicastAddr, error) {
 		for {
 			c, err := ln.Accept()
 			if err != nil {
-				log.Error("error accepting connection", "err", err)
+				if n.ctx.Err() == nil {
+					log.Error("error accepting connection", "err", err)
+				}
 				return
 			}
 
 			go n.acceptPeerOrDisconnect(c)
 		}
 	}()
+
+	n.mu.Lock()
+	n.ln = ln
+	n.listenAddr = addr
+	n.mu.Unlock()
+
+	n.mapPort()
+	go n.natLoop()
 	return unicastAddr{Addr: addr, PKStr: string(n.sk.MustPK())}, nil
 }
 
+// setTimeouts sets the time allowed for dialing a peer and for the
+// handshake of a connection, 0 uses the default.
+func (n *network) setTimeouts(dial, handshake time.Duration) {
+	if dial <= 0 {
+		dial = timeoutDur
+	}
+
+	if handshake <= 0 {
+		handshake = timeoutDur
+	}
+
+	n.mu.Lock()
+	n.dialTimeout = dial
+	n.handshakeTimeout = handshake
+	n.mu.Unlock()
+}
+
+// setOutboundOnly sets if the node only dials the peers, for the
+// nodes that can not accept the connections. It must be set before
+// Start, the peers see no listen address of the node, so it is never
+// sent in the peer exchange.
+func (n *network) setOutboundOnly(outboundOnly bool) {
+	n.mu.Lock()
+	n.outboundOnly = outboundOnly
+	n.mu.Unlock()
+}
+
+// dial connects to the peer address in the dial timeout, the dial is
+// canceled with ctx or when the network stops.
+func (n *network) dial(ctx context.Context, addr string) (net.Conn, error) {
+	host := hostOf(addr)
+	n.mu.Lock()
+	banned := n.isBannedHost(host)
+	n.mu.Unlock()
+	if banned {
+		return nil, fmt.Errorf("host %s is banned", host)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.dialTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-n.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return n.transport.Dial(ctx, addr)
+}
+
+// Stop stops listening for the peer connections, cancels the dials
+// in progress and closes the peer connections gracefully. The peers
+// are not redialed afterwards.
+func (n *network) Stop() {
+	n.stop()
+
+	n.mu.Lock()
+	if n.ln != nil {
+		err := n.ln.Close()
+		if err != nil {
+			log.Warn("error closing the listener", "err", err)
+		}
+	}
+
+	conns := make([]*conn, 0, len(n.conns))
+	for _, c := range n.conns {
+		conns = append(conns, c)
+	}
+	n.mu.Unlock()
+
+	closeAllGracefully(conns, disconnectShutdown, flushTimeout)
+}
+
 func dedup(nodes []unicastAddr) []unicastAddr {
 	m := make(map[string]bool)
 	r := make([]unicastAddr, 0, len(nodes))
@@ -165,52 +461,73 @@ func dedup(nodes []unicastAddr) []unicastAddr {
 }
 
 func (n *network) ConnectSeed(addr string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeoutDur)
+	ctx, cancel := context.WithTimeout(n.ctx, n.dialTimeout+n.handshakeTimeout)
 	pk, nodes, err := n.getAddrsFromSeed(ctx, addr)
 	cancel()
 	if err != nil {
 		return err
 	}
 
-	nodes = dedup(nodes)
-	myPKStr := string(n.sk.MustPK())
+	seed := unicastAddr{PKStr: string(pk), Addr: addr}
+	nodes = n.acceptAddrs(seed, nodes)
 	if len(nodes) == 0 {
-		nodes = []unicastAddr{{PKStr: string(pk), Addr: addr}}
-	} else if len(nodes) == 1 && nodes[0].PKStr == myPKStr {
-		nodes = append(nodes, unicastAddr{PKStr: string(pk), Addr: addr})
+		nodes = []unicastAddr{seed}
 	}
-	perm := rand.Perm(len(nodes))
 
 	log.Info("received nodes", "count", len(nodes))
 
+	n.mu.Lock()
+	n.addPublicNode(seed)
+	n.mu.Unlock()
+
+	n.connectSome(nodes, intialConn)
+	return nil
+}
+
+// connectSome connects to at most max of the nodes chosen at random,
+// while there are free outbound slots.
+func (n *network) connectSome(nodes []unicastAddr, max int) {
+	myPKStr := string(n.sk.MustPK())
 	connected := 0
-	for _, idx := range perm {
+	for _, idx := range rand.Perm(len(nodes)) {
 		addr := nodes[idx]
 		if addr.PKStr == myPKStr {
 			continue
 		}
 
+		n.mu.Lock()
+		banned := n.isBanned(PK(addr.PKStr).Addr())
+		full := n.maxOutbound > 0 && n.peerCount(false) >= n.maxOutbound
+		n.mu.Unlock()
+		if full {
+			break
+		}
+
+		if banned {
+			continue
+		}
+
 		go n.connect(addr, PK([]byte(addr.PKStr)))
 		connected++
-		if connected >= intialConn {
+		if connected >= max {
 			break
 		}
 	}
-
-	n.mu.Lock()
-	n.publicNodes = nodes
-	n.mu.Unlock()
-
-	return nil
 }
 
 func (n *network) isPubAddr(ctx context.Context, addr string) bool {
-	c, err := net.Dial("tcp", addr)
+	c, err := n.dial(ctx, addr)
+	if err != nil {
+		return false
+	}
+
+	conn := n.wrapConn(c)
+	_, err = n.sendHello(conn)
 	if err != nil {
+		conn.Close()
 		return false
 	}
 
-	conn := newConn(c)
 	err = conn.Write(packet{Data: ack{}})
 	if err != nil {
 		return false
@@ -242,19 +559,35 @@ func (n *network) isPubAddr(ctx context.Context, addr string) bool {
 }
 
 func (n *network) getAddrsFromSeed(ctx context.Context, addr string) (PK, []unicastAddr, error) {
-	c, err := net.Dial("tcp", addr)
+	c, err := n.dial(ctx, addr)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	conn := newConn(c)
-	req := &connectRequest{GetNodesOnly: true, Port: n.port}
-	req.PK = n.sk.MustPK()
-	req.Sig = n.sk.Sign(req.ByteToSign())
+	conn := n.wrapConn(c)
+	_, err = n.sendHello(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	req := &connectRequest{GetNodesOnly: true, Port: n.advertisedPort()}
+	req.sign(n.sk, conn.transcript)
 	err = conn.Write(packet{Data: req})
 	if err != nil {
 		return nil, nil, err
 	}
+
+	pk, addrs, err := readHandshake(ctx, conn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get public node addresses err: %v", err)
+	}
+	return pk, addrs, nil
+}
+
+// readHandshake reads the reply of a connect request: the public
+// nodes known by the peer and the peer's connect request.
+func readHandshake(ctx context.Context, conn *conn) (PK, []unicastAddr, error) {
 	type result struct {
 		addrs []unicastAddr
 		pk    PK
@@ -282,13 +615,18 @@ func (n *network) getAddrsFromSeed(ctx context.Context, addr string) (PK, []unic
 			return
 		}
 
+		if r, ok := pac.Data.(*peerRejection); ok {
+			ch <- result{err: fmt.Errorf("peer rejected the connection: %s", r.Reason)}
+			return
+		}
+
 		req, ok := pac.Data.(*connectRequest)
 		if !ok {
 			ch <- result{err: errors.New("the second packet should be of type *connectRequest")}
 			return
 		}
 
-		if !req.Sig.Verify(req.PK, req.ByteToSign()) {
+		if !req.verify(conn.transcript) {
 			ch <- result{err: errors.New("peer signature validation failed")}
 			return
 		}
@@ -298,7 +636,7 @@ func (n *network) getAddrsFromSeed(ctx context.Context, addr string) (PK, []unic
 
 	select {
 	case <-ctx.Done():
-		return nil, nil, fmt.Errorf("get public node addresses err: %v", ctx.Err())
+		return nil, nil, ctx.Err()
 	case r := <-ch:
 		return r.pk, r.addrs, r.err
 	}
@@ -307,59 +645,228 @@ func (n *network) getAddrsFromSeed(ctx context.Context, addr string) (PK, []unic
 func (n *network) connect(addr unicastAddr, pk PK) error {
 	log.Info("connecting to peer", "addr", addr.Addr)
 
+	if !n.startDial(addr.Addr) {
+		return errDialing
+	}
+	defer n.endDial(addr.Addr)
+
 	n.mu.Lock()
 	if _, ok := n.conns[addr]; ok {
 		n.mu.Unlock()
 		return nil
 	}
+
+	if n.isBanned(pk.Addr()) {
+		n.mu.Unlock()
+		return fmt.Errorf("peer %v is banned", pk.Addr())
+	}
+
+	// the ID claimed in the peer exchange is verified in the
+	// handshake below.
+	if !n.reserve(pk.Addr(), false, false) {
+		n.mu.Unlock()
+		return errTooManyPeers
+	}
 	n.mu.Unlock()
 
-	c, err := net.Dial("tcp", addr.Addr)
+	defer func() {
+		n.mu.Lock()
+		n.release(false)
+		n.mu.Unlock()
+	}()
+
+	c, err := n.dial(n.ctx, addr.Addr)
 	if err != nil {
 		return err
 	}
 
-	conn := newConn(c)
-	req := &connectRequest{Port: n.port}
-	req.PK = n.sk.MustPK()
-	req.Sig = n.sk.Sign(req.ByteToSign())
+	conn := n.wrapConn(c)
+	h, err := n.sendHello(conn)
+	if err != nil {
+		n.handshakeFailed()
+		conn.Close()
+		return err
+	}
+
+	conn.software = h.Software
+	req := &connectRequest{Port: n.advertisedPort()}
+	req.sign(n.sk, conn.transcript)
 	err = conn.Write(packet{Data: req})
 	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, n.handshakeTimeout)
+	proved, addrs, err := readHandshake(ctx, conn)
+	cancel()
+	if err != nil {
+		n.handshakeFailed()
+		conn.Close()
 		return err
 	}
 
+	if !bytes.Equal(proved, pk) {
+		n.handshakeFailed()
+		conn.Close()
+		return fmt.Errorf("peer %s proved the ID %v instead of %v", addr.Addr, proved.Addr(), pk.Addr())
+	}
+
+	n.setIdentity(conn, pk, hostOf(addr.Addr))
 	n.mu.Lock()
+	if n.isBannedPeer(conn) {
+		n.mu.Unlock()
+		conn.Close()
+		return fmt.Errorf("peer %v is banned", pk.Addr())
+	}
+
 	if _, ok := n.conns[addr]; !ok {
-		n.conns[addr] = conn
-		go n.readConn(addr, conn)
+		n.addConn(addr, conn, false)
+		n.addPublicNode(addr)
 	} else {
 		c.Close()
 	}
 	n.mu.Unlock()
+
+	go n.connectSome(n.acceptAddrs(addr, addrs), intialConn)
 	return nil
 }
 
+// addConn registers the connection of the peer, and starts reading
+// from it. The outbound peers are redialed when their connections
+// drop. The caller must hold n.mu.
+func (n *network) addConn(addr unicastAddr, conn *conn, inbound bool) {
+	conn.inbound = inbound
+	conn.connectedAt = time.Now()
+	conn.traffic.setWindow(n.bandwidthWindow)
+	conn.sendq = newSendQueue(n.sendQueueSize, n.saturationTimeout, &conn.traffic.dropped)
+	conn.writerDone = make(chan struct{})
+	conn.limiter = newRateLimiter(n.rateLimits)
+	if !inbound {
+		n.addKnown(addr)
+	}
+	n.conns[addr] = conn
+	n.seen[addr] = conn.connectedAt
+	go n.readConn(addr, conn)
+	go conn.writeLoop()
+	go conn.ping()
+}
+
+func (n *network) pingPeers() {
+	for range time.Tick(n.pingInterval) {
+		n.mu.Lock()
+		for addr, c := range n.conns {
+			go n.pingPeer(addr, c)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// pingPeer pings the peer, the peer is disconnected if it does not
+// answer the pings. An outbound peer is redialed afterwards.
+func (n *network) pingPeer(addr unicastAddr, c *conn) {
+	if c.ping() == errPeerDead {
+		log.Info("disconnecting the peer not answering the pings", "addr", addr.Addr)
+		c.Close()
+	}
+}
+
+// readConn reads the packets from the peer until the connection
+// fails, the peer is disconnected if it sends nothing in the read
+// timeout. An outbound peer is redialed afterwards.
 func (n *network) readConn(addr unicastAddr, conn *conn) {
+loop:
 	for {
+		conn.conn.SetReadDeadline(time.Now().Add(n.readTimeout))
 		pac, err := conn.Read()
 		if err != nil {
-			log.Warn("read peer conn error", "err", err)
+			logReadErr(addr, err)
 			conn.Close()
+			if _, ok := err.(*frameError); ok {
+				n.banPeer(PK(addr.PKStr).Addr(), frameViolationBan, err.Error())
+			}
 			break
 		}
 
+		if !conn.limiter.allow(pac.Data) {
+			n.throttle(addr, conn, pac.Data)
+			continue
+		}
+
+		if hasUnknownItem(pac.Data) {
+			log.Debug("dropping the packet of an unknown item type", "addr", addr.Addr, "type", fmt.Sprintf("%T", pac.Data))
+			n.penalize(addr)
+			continue
+		}
+
 		switch v := pac.Data.(type) {
 		case []unicastAddr:
-			_ = v
+			go n.connectSome(n.acceptAddrs(addr, v), intialConn)
 		case *connectRequest:
 			// connection already established, discard
+		case *snapshotManifest, *snapshotChunk:
+			// only sent in the responses, discard
+		case truncatedGetData:
+			log.Debug("requesting the items over the getData cap again", "addr", addr.Addr, "items", len(v))
+			for _, item := range v {
+				n.Send(addr, packet{Data: itemRequest(item)})
+			}
+		case ping:
+			go conn.Write(packet{Data: pong(v)})
+		case pong:
+			conn.pong(v.Sent)
+		case *peerRejection:
+			n.peerDisconnected(addr, v.Reason)
+			conn.Close()
+			break loop
+		case *disconnect:
+			n.peerDisconnected(addr, v.Reason.String())
+			conn.Close()
+			break loop
+		case *response:
+			if !conn.respond(v) {
+				log.Debug("dropped the response of a timed out request", "addr", addr.Addr, "id", v.ID)
+			}
+		case Item:
+			n.gossip.announced(v, addr)
+			n.ch <- packetAndAddr{A: addr, P: pac}
+		case inventory:
+			for _, item := range v {
+				n.gossip.announced(item, addr)
+				n.ch <- packetAndAddr{A: addr, P: packet{Data: item}}
+			}
+		case getData:
+			for _, item := range n.truncateGetData(addr, conn, v) {
+				n.ch <- packetAndAddr{A: addr, P: packet{Data: itemRequest(item)}}
+			}
 		default:
+			item, isItem := itemOf(v)
+			if isItem && conn.respondLegacy(item, v) {
+				continue
+			}
+
+			if isItem && !n.gossip.received(item, addr) {
+				continue
+			}
+
 			n.ch <- packetAndAddr{A: addr, P: pac}
 		}
 	}
 
 	n.mu.Lock()
-	delete(n.conns, addr)
+	// the peer may have reconnected with a new connection
+	if n.conns[addr] == conn {
+		delete(n.conns, addr)
+	}
+
+	// the peer may have been removed
+	if _, ok := n.seen[addr]; ok {
+		n.seen[addr] = time.Now()
+	}
+
+	if !conn.inbound {
+		n.scheduleRedial(addr, conn)
+	}
 	n.mu.Unlock()
 }
 
@@ -368,14 +875,25 @@ func (n *network) Send(addr netAddr, p packet) error {
 	case unicastAddr:
 		n.mu.Lock()
 		conn, ok := n.conns[v]
+		delay := n.announceDelay
 		n.mu.Unlock()
 		if !ok {
 			log.Warn("sending to unknown address", "addr", v.Addr)
 			return errors.New("can not find the send address")
 		}
 
-		err := conn.Write(p)
-		if err != nil {
+		if r, ok := p.Data.(itemRequest); ok {
+			n.gossip.requesting(Item(r))
+		}
+
+		if conn.batch(p.Data, delay) {
+			return nil
+		}
+
+		err := conn.send(p)
+		if err == errClosing {
+			return err
+		} else if err != nil {
 			log.Warn("send failed, removing this peer", "err", err)
 			n.mu.Lock()
 			if conn, ok = n.conns[v]; ok {
@@ -386,8 +904,15 @@ func (n *network) Send(addr netAddr, p packet) error {
 			return err
 		}
 	case broadcast:
+		item, isItem := p.Data.(Item)
 		n.mu.Lock()
 		for addr := range n.conns {
+			// the peer that sent or announced the item
+			// has it
+			if isItem && n.gossip.has(item, addr) {
+				continue
+			}
+
 			go n.Send(addr, p)
 		}
 		n.mu.Unlock()
@@ -402,6 +927,21 @@ func (n *network) Recv() (unicastAddr, packet) {
 	return p.A, p.P
 }
 
+// PeerCount returns the number of the connected peers.
+func (n *network) PeerCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.conns)
+}
+
+// ListenAddr returns the address accepting the peer connections,
+// empty if the network is not started.
+func (n *network) ListenAddr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.listenAddr
+}
+
 type connectRequest struct {
 	Port         uint16
 	GetNodesOnly bool
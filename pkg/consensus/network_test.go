@@ -1,9 +1,21 @@
 package consensus
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -38,3 +50,2077 @@ func TestNetworkConnectSeed(t *testing.T) {
 	n0.mu.Unlock()
 	n1.mu.Unlock()
 }
+
+// waitUntil waits for the condition to become true, it fails the test
+// if the condition is not true in a second.
+func waitUntil(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNetworkPeerAdmin(t *testing.T) {
+	sim := newSimNet(simLink{latency: 2 * time.Millisecond, jitter: time.Millisecond})
+	n0 := makeSimNetwork(sim, "10.0.0.1")
+	n1 := makeSimNetwork(sim, "10.0.0.2")
+	addr0, err := n0.Start("10.0.0.1", 11002)
+	assert.Nil(t, err)
+
+	_, err = n1.Start("10.0.0.2", 11003)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	id0 := PK(addr0.PKStr).Addr()
+	id1 := n1.sk.MustPK().Addr()
+	info, err := n1.AddPeer(ctx, addr0.Addr)
+	assert.Nil(t, err)
+	assert.Equal(t, id0, info.ID)
+	assert.Equal(t, addr0.Addr, info.Addr)
+	assert.False(t, info.Inbound)
+
+	// the RTT is measured by the ping sent on connecting
+	waitUntil(t, func() bool {
+		p := n0.Peers()
+		return len(p) == 1 && p[0].RTT > 0
+	})
+	waitUntil(t, func() bool {
+		p := n1.Peers()
+		return len(p) == 1 && p[0].RTT > 0
+	})
+
+	peers := n0.Peers()
+	assert.Equal(t, id1, peers[0].ID)
+	assert.Equal(t, "10.0.0.2:11003", peers[0].Addr)
+	assert.True(t, peers[0].RTT >= 4*time.Millisecond)
+	assert.True(t, peers[0].Inbound)
+	assert.True(t, peers[0].BytesIn > 0)
+	assert.True(t, peers[0].BytesOut > 0)
+	assert.Equal(t, 0, peers[0].Reputation)
+
+	// adding a connected peer returns the connection
+	again, err := n1.AddPeer(ctx, addr0.Addr)
+	assert.Nil(t, err)
+	assert.Equal(t, info.ConnectedAt, again.ConnectedAt)
+	assert.Equal(t, 1, n1.PeerCount())
+
+	n0.penalize(unicastAddr{Addr: peers[0].Addr, PKStr: string(n1.sk.MustPK())})
+	assert.Equal(t, -1, n0.Peers()[0].Reputation)
+
+	// the connection drops on both sides, and the banned peer can
+	// not reconnect.
+	n0.BanPeer(id1, time.Minute)
+	assert.Empty(t, n0.Peers())
+	waitUntil(t, func() bool {
+		return n1.PeerCount() == 0
+	})
+
+	_, err = n1.AddPeer(ctx, addr0.Addr)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n0.PeerCount())
+	assert.Equal(t, ErrPeerNotFound, n0.DisconnectPeer(id1))
+
+	// the peer reconnects after the ban expires, and keeps its
+	// reputation.
+	n0.BanPeer(id1, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	_, err = n1.AddPeer(ctx, addr0.Addr)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+	assert.Equal(t, -1, n0.Peers()[0].Reputation)
+
+	assert.Nil(t, n1.DisconnectPeer(id0))
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 0
+	})
+}
+
+func TestNetworkPeerLimits(t *testing.T) {
+	n0 := makeNetwork()
+	n0.setPeerLimits(2, 1)
+	addr0, err := n0.Start("127.0.0.1", 11004)
+	assert.Nil(t, err)
+
+	peers := make([]*network, 4)
+	addrs := make([]unicastAddr, len(peers))
+	for i := range peers {
+		peers[i] = makeNetwork()
+		addrs[i], err = peers[i].Start("127.0.0.1", 11005+i)
+		assert.Nil(t, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// the peers connect at the same time, the ones beyond the
+	// inbound limit are rejected after the handshake.
+	ctx := context.Background()
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Add(1)
+		go func(i int, p *network) {
+			defer wg.Done()
+			_, errs[i] = p.AddPeer(ctx, addr0.Addr)
+		}(i, p)
+	}
+	wg.Wait()
+
+	var accepted, rejected []int
+	for i, err := range errs {
+		if err == nil {
+			accepted = append(accepted, i)
+			continue
+		}
+
+		assert.Contains(t, err.Error(), tooManyPeers)
+		rejected = append(rejected, i)
+	}
+	assert.Equal(t, 2, len(accepted))
+	assert.Equal(t, 2, len(rejected))
+	for _, i := range rejected {
+		assert.Equal(t, 0, peers[i].PeerCount())
+	}
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 2
+	})
+
+	// a priority peer takes the slot of the lowest-scoring peer
+	vip := makeNetwork()
+	_, err = vip.Start("127.0.0.1", 11009)
+	assert.Nil(t, err)
+	vipID := vip.sk.MustPK().Addr()
+	n0.mu.Lock()
+	n0.priority = func(id Addr) bool {
+		return id == vipID
+	}
+	n0.mu.Unlock()
+
+	evicted := peers[accepted[1]]
+	n0.penalize(unicastAddr{PKStr: addrs[accepted[1]].PKStr})
+	_, err = vip.AddPeer(ctx, addr0.Addr)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		return evicted.PeerCount() == 0
+	})
+
+	var ids []Addr
+	for _, p := range n0.Peers() {
+		ids = append(ids, p.ID)
+	}
+	assert.Equal(t, 2, len(ids))
+	assert.Contains(t, ids, vipID)
+	assert.NotContains(t, ids, evicted.sk.MustPK().Addr())
+
+	// the outbound dialing stops at the limit
+	a, b := addrs[rejected[0]], addrs[rejected[1]]
+	assert.Nil(t, n0.connect(a, PK(a.PKStr)))
+	assert.Equal(t, errTooManyPeers, n0.connect(b, PK(b.PKStr)))
+	assert.Equal(t, 3, n0.PeerCount())
+}
+
+// flappingPeer accepts the connections and drops them right after
+// the handshake until it is told to keep them, the accept times are
+// recorded.
+type flappingPeer struct {
+	l  net.Listener
+	sk SK
+
+	mu       sync.Mutex
+	accepted []time.Time
+	keep     bool
+}
+
+func newFlappingPeer(t *testing.T) *flappingPeer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	p := &flappingPeer{l: l, sk: RandSK()}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			p.mu.Lock()
+			p.accepted = append(p.accepted, time.Now())
+			keep := p.keep
+			p.mu.Unlock()
+
+			conn := newConn(c)
+			_, err = conn.Read()
+			if err != nil {
+				c.Close()
+				continue
+			}
+
+			conn.Write(packet{Data: &hello{ProtocolVersion: minProtocolVersion}})
+			conn.Read()
+			req := &connectRequest{}
+			req.sign(p.sk, Hash{})
+			conn.Write(packet{Data: []unicastAddr{}})
+			conn.Write(packet{Data: req})
+			if !keep {
+				// drop the connection once the handshake
+				// is done.
+				c.Close()
+			}
+		}
+	}()
+	return p
+}
+
+func (p *flappingPeer) acceptedCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.accepted)
+}
+
+func TestNetworkRedial(t *testing.T) {
+	n := makeNetwork()
+	n.backoff = redialBackoff{min: 40 * time.Millisecond, max: time.Second, minSession: time.Hour}
+	p := newFlappingPeer(t)
+	defer p.l.Close()
+
+	pk := p.sk.MustPK()
+	addr := unicastAddr{Addr: p.l.Addr().String(), PKStr: string(pk)}
+	assert.Nil(t, n.connect(addr, pk))
+
+	// the dropped connection is redialed with growing intervals,
+	// the jitter keeps an interval within [backoff/2, backoff).
+	deadline := time.Now().Add(5 * time.Second)
+	for p.acceptedCount() < 6 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p.mu.Lock()
+	accepted := append([]time.Time(nil), p.accepted...)
+	p.keep = true
+	p.mu.Unlock()
+	assert.True(t, len(accepted) >= 6)
+	for i := 2; i < 5; i++ {
+		a := accepted[i-1].Sub(accepted[i-2])
+		b := accepted[i+1].Sub(accepted[i])
+		assert.True(t, b > a, "interval %d: %v, interval %d: %v", i-2, a, i, b)
+	}
+
+	// the concurrent dials to the same address are deduplicated,
+	// an address not redialed is used so that the redial does
+	// not race with the test.
+	other := unicastAddr{Addr: "127.0.0.1:1", PKStr: string(RandSK().MustPK())}
+	assert.True(t, n.startDial(other.Addr))
+	assert.Equal(t, errDialing, n.connect(other, PK(other.PKStr)))
+	_, err := n.AddPeer(context.Background(), other.Addr)
+	assert.Equal(t, errDialing, err)
+	n.endDial(other.Addr)
+
+	// the peer stays connected once it keeps the connection, and
+	// is no longer redialed once removed.
+	waitUntil(t, func() bool {
+		return n.PeerCount() == 1
+	})
+	assert.Nil(t, n.DisconnectPeer(pk.Addr()))
+	count := p.acceptedCount()
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, count, p.acceptedCount())
+	assert.Equal(t, 0, n.PeerCount())
+}
+
+func TestNetworkRestartFromPeerFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer-file")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11010)
+	assert.Nil(t, err)
+
+	n1 := makeNetwork()
+	n1.setPeerFile(path, time.Hour)
+	_, err = n1.Start("127.0.0.1", 11011)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	id0 := n0.sk.MustPK().Addr()
+	n1.mu.Lock()
+	n1.reputation[id0] = 3
+	n1.mu.Unlock()
+	assert.Nil(t, n1.savePeers())
+
+	// the restarted node connects to the saved peer, the seed node
+	// is unreachable and not dialed.
+	restarted := newNetwork(n1.sk)
+	restarted.setPeerFile(path, time.Hour)
+	_, err = restarted.Start("127.0.0.1", 11012)
+	assert.Nil(t, err)
+	assert.Nil(t, restarted.bootstrap("127.0.0.1:1"))
+	assert.Equal(t, 1, restarted.PeerCount())
+	restarted.mu.Lock()
+	assert.Equal(t, 3, restarted.reputation[id0])
+	restarted.mu.Unlock()
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 2
+	})
+
+	// the stale peers are dropped
+	restarted.setPeerFile(path, time.Nanosecond)
+	peers, err := restarted.loadPeers()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(peers))
+}
+
+func TestNetworkHandshake(t *testing.T) {
+	genesis := SHA3([]byte("genesis"))
+	n0 := makeNetwork()
+	n0.genesis = genesis
+	n0.software = "v1"
+	n0.handshakeTimeout = 200 * time.Millisecond
+	addr0, err := n0.Start("127.0.0.1", 11015)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// matching versions connect
+	n1 := makeNetwork()
+	n1.genesis = genesis
+	_, err = n1.Start("127.0.0.1", 11016)
+	assert.Nil(t, err)
+	info, err := n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", info.Software)
+	assert.False(t, info.Observer)
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+
+	// mismatched genesis disconnects
+	n2 := makeNetwork()
+	n2.genesis = SHA3([]byte("other genesis"))
+	_, err = n2.Start("127.0.0.1", 11017)
+	assert.Nil(t, err)
+	_, err = n2.AddPeer(context.Background(), addr0.Addr)
+	assert.Contains(t, err.Error(), "genesis mismatch")
+	assert.Equal(t, 0, n2.PeerCount())
+	assert.Equal(t, 1, n0.PeerCount())
+
+	// unsupported versions are rejected
+	h := n0.hello()
+	h.MinProtocolVersion = protocolVersion + 1
+	h.ProtocolVersion = protocolVersion + 1
+	assert.Contains(t, n0.checkHello(h), "unsupported protocol versions")
+	h.MinProtocolVersion = 0
+	h.ProtocolVersion = minProtocolVersion - 1
+	assert.Contains(t, n0.checkHello(h), "unsupported protocol versions")
+
+	// the connection uses gob encoding with a peer speaking the
+	// older version
+	c, err := net.Dial("tcp", addr0.Addr)
+	assert.Nil(t, err)
+	old := newConn(c)
+	sk := RandSK()
+	assert.Nil(t, old.Write(packet{Data: &hello{ProtocolVersion: minProtocolVersion, Genesis: genesis}}))
+	pac, err := old.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(protocolVersion), pac.Data.(*hello).ProtocolVersion)
+	req := &connectRequest{Port: 11018, PK: sk.MustPK()}
+	req.Sig = sk.Sign(req.ByteToSign())
+	assert.Nil(t, old.Write(packet{Data: req}))
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 2
+	})
+	old.Close()
+
+	// the older version can not prove the ID
+	for _, p := range n0.Peers() {
+		assert.Equal(t, p.ID == sk.MustPK().Addr(), p.Observer)
+	}
+
+	// the socket is dropped when the handshake times out
+	c, err = net.Dial("tcp", addr0.Addr)
+	assert.Nil(t, err)
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+// recvTimeout returns the packet delivered to the gateway, false if
+// there is none within the timeout.
+func recvTimeout(n *network, d time.Duration) (packetAndAddr, bool) {
+	select {
+	case p := <-n.ch:
+		return p, true
+	case <-time.After(d):
+		return packetAndAddr{}, false
+	}
+}
+
+func TestNetworkGossipDedup(t *testing.T) {
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11019)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	peers := make([]*network, 3)
+	for i := range peers {
+		peers[i] = makeNetwork()
+		_, err = peers[i].Start("127.0.0.1", 11020+i)
+		assert.Nil(t, err)
+		_, err = peers[i].AddPeer(context.Background(), addr0.Addr)
+		assert.Nil(t, err)
+	}
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == len(peers)
+	})
+
+	// the block proposal relayed by two peers is delivered once
+	bp := &BlockProposal{Round: 1, Txns: []byte{1}}
+	assert.Nil(t, peers[0].Send(addr0, packet{Data: bp}))
+	assert.Nil(t, peers[1].Send(addr0, packet{Data: bp}))
+	p, ok := recvTimeout(n0, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, bp.Hash(), p.P.Data.(*BlockProposal).Hash())
+	waitUntil(t, func() bool {
+		return n0.gossip.Duplicates() == 1
+	})
+	_, ok = recvTimeout(n0, 100*time.Millisecond)
+	assert.False(t, ok)
+
+	// the item is not announced back to the peers that sent it
+	item := Item{T: blockProposalItem, Hash: bp.Hash()}
+	assert.Nil(t, n0.Send(broadcast{}, packet{Data: item}))
+	p, ok = recvTimeout(peers[2], time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, item, p.P.Data)
+	for _, peer := range peers[:2] {
+		_, ok = recvTimeout(peer, 100*time.Millisecond)
+		assert.False(t, ok)
+	}
+
+	// the requested item is delivered even if it was seen
+	var addr2 unicastAddr
+	n0.mu.Lock()
+	for addr := range n0.conns {
+		if addr.PKStr == string(peers[2].sk.MustPK()) {
+			addr2 = addr
+		}
+	}
+	n0.mu.Unlock()
+	assert.Nil(t, n0.Send(addr2, packet{Data: itemRequest(item)}))
+	_, ok = recvTimeout(peers[2], time.Second)
+	assert.True(t, ok)
+	assert.Nil(t, peers[2].Send(addr0, packet{Data: bp}))
+	p, ok = recvTimeout(n0, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, bp.Hash(), p.P.Data.(*BlockProposal).Hash())
+}
+
+func TestNetworkRequestAny(t *testing.T) {
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11023)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// the dead provider never replies, the live one does
+	dead, live := makeNetwork(), makeNetwork()
+	_, err = dead.Start("127.0.0.1", 11024)
+	assert.Nil(t, err)
+	_, err = live.Start("127.0.0.1", 11025)
+	assert.Nil(t, err)
+
+	bp := &BlockProposal{Round: 1, Txns: []byte{1}}
+	item := Item{T: blockProposalItem, Hash: bp.Hash()}
+	go func() {
+		for {
+			p := <-live.ch
+			req, ok := p.P.Data.(*request)
+			if !ok {
+				continue
+			}
+
+			resp, err := newResponse(req.ID, bp)
+			assert.Nil(t, err)
+			live.Send(p.A, packet{Data: resp})
+		}
+	}()
+
+	for _, p := range []*network{dead, live} {
+		_, err = p.AddPeer(context.Background(), addr0.Addr)
+		assert.Nil(t, err)
+		assert.Nil(t, p.Send(addr0, packet{Data: item}))
+	}
+
+	var deadAddr, liveAddr unicastAddr
+	waitUntil(t, func() bool {
+		n0.mu.Lock()
+		for addr := range n0.conns {
+			switch addr.PKStr {
+			case string(dead.sk.MustPK()):
+				deadAddr = addr
+			case string(live.sk.MustPK()):
+				liveAddr = addr
+			}
+		}
+		n0.mu.Unlock()
+		return len(n0.ProvidersOf(item.Hash)) == 2
+	})
+
+	// the provider of the higher reputation first
+	n0.penalize(liveAddr)
+	assert.Equal(t, []unicastAddr{deadAddr, liveAddr}, n0.ProvidersOf(item.Hash))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	data, err := n0.requestAny(ctx, deadAddr, item, func(data interface{}) bool {
+		bp, ok := data.(*BlockProposal)
+		return ok && bp.Hash() == item.Hash
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, item.Hash, data.(*BlockProposal).Hash())
+	assert.True(t, time.Since(start) < time.Second)
+
+	// the invalid response is rejected, and its provider
+	// penalized
+	_, err = n0.requestAny(ctx, liveAddr, Item{T: blockItem, Hash: Hash{1}}, func(data interface{}) bool {
+		return false
+	})
+	assert.Equal(t, errInvalidResponse, err)
+	n0.mu.Lock()
+	assert.Equal(t, -2, n0.reputation[PK(liveAddr.PKStr).Addr()])
+	n0.mu.Unlock()
+}
+
+func TestNetworkPeerExchange(t *testing.T) {
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11028)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	n1, n2 := makeNetwork(), makeNetwork()
+	_, err = n1.Start("127.0.0.1", 11029)
+	assert.Nil(t, err)
+	addr2, err := n2.Start("127.0.0.1", 11030)
+	assert.Nil(t, err)
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+
+	// only the valid addresses are dialed
+	pk := string(RandSK().MustPK())
+	bogus := []unicastAddr{
+		{Addr: "not an address", PKStr: pk},
+		{Addr: "example.com:80", PKStr: pk},
+		{Addr: "127.0.0.1:0", PKStr: pk},
+		{Addr: "0.0.0.0:11030", PKStr: pk},
+		{Addr: "224.0.0.1:11030", PKStr: pk},
+		{Addr: "127.0.0.1:11031"},
+		{Addr: addr0.Addr, PKStr: pk},
+		{Addr: "127.0.0.1:11031", PKStr: addr0.PKStr},
+	}
+	msg := append(bogus, addr2)
+	n1.mu.Lock()
+	var to unicastAddr
+	for addr := range n1.conns {
+		to = addr
+	}
+	n1.mu.Unlock()
+	assert.Nil(t, n1.Send(to, packet{Data: msg}))
+	waitUntil(t, func() bool {
+		return n2.PeerCount() == 1
+	})
+
+	// the addresses are remembered for the peer exchange once
+	// confirmed by the handshake
+	addr1 := unicastAddr{Addr: "127.0.0.1:11029", PKStr: string(n1.sk.MustPK())}
+	waitUntil(t, func() bool {
+		n0.mu.Lock()
+		defer n0.mu.Unlock()
+		return len(n0.exchangedNodes()) == 2
+	})
+	n0.mu.Lock()
+	assert.ElementsMatch(t, []unicastAddr{addr1, addr2}, n0.exchangedNodes())
+	n0.mu.Unlock()
+
+	for _, addr := range bogus {
+		assert.Empty(t, n0.acceptAddrs(addr1, []unicastAddr{addr}), addr.Addr)
+	}
+
+	// the loopback addresses are only accepted from the local
+	// peers, and the private ones from none on the public networks
+	remote := unicastAddr{Addr: "203.0.113.1:11029", PKStr: pk}
+	local := unicastAddr{Addr: "127.0.0.1:11031", PKStr: string(RandSK().MustPK())}
+	private := unicastAddr{Addr: "10.0.0.1:11031", PKStr: string(RandSK().MustPK())}
+	assert.Empty(t, n0.acceptAddrs(remote, []unicastAddr{local}))
+	assert.Equal(t, []unicastAddr{private}, n0.acceptAddrs(remote, []unicastAddr{private}))
+	n0.setPeerExchangePublicOnly(true)
+	assert.Empty(t, n0.acceptAddrs(remote, []unicastAddr{private}))
+	n0.setPeerExchangePublicOnly(false)
+
+	// the oversized messages are truncated, and the peer is rate
+	// limited
+	var many []unicastAddr
+	for i := 0; i < 1000; i++ {
+		many = append(many, unicastAddr{Addr: fmt.Sprintf("198.51.100.%d:%d", i%250+1, 20000+i), PKStr: string(RandSK().MustPK())})
+	}
+	flooder := unicastAddr{Addr: "203.0.113.2:11029", PKStr: string(RandSK().MustPK())}
+	total := 0
+	for i := 0; i < 10; i++ {
+		accepted := n0.acceptAddrs(flooder, many)
+		assert.True(t, len(accepted) <= maxExchangedAddrs)
+		total += len(accepted)
+	}
+	assert.Equal(t, maxExchangedAddrsPerWindow, total)
+	n0.mu.Lock()
+	assert.ElementsMatch(t, []unicastAddr{addr1, addr2}, n0.exchangedNodes())
+	_, ok := n0.seen[many[0]]
+	assert.False(t, ok)
+	n0.mu.Unlock()
+}
+
+// delayedPeer is an in-process peer answering the pings after the
+// delay, it never answers them if the delay is negative. It answers
+// the requests with the block proposal.
+type delayedPeer struct {
+	addr     unicastAddr
+	conn     *conn
+	requests int32
+}
+
+func newDelayedPeer(t *testing.T, to string, delay time.Duration, bp *BlockProposal) *delayedPeer {
+	n := makeNetwork()
+	c, err := net.Dial("tcp", to)
+	assert.Nil(t, err)
+	conn := newConn(c)
+	_, err = n.sendHello(conn)
+	assert.Nil(t, err)
+	req := &connectRequest{}
+	req.sign(n.sk, conn.transcript)
+	assert.Nil(t, conn.Write(packet{Data: req}))
+	_, _, err = readHandshake(context.Background(), conn)
+	assert.Nil(t, err)
+
+	p := &delayedPeer{addr: unicastAddr{Addr: "127.0.0.1:0", PKStr: string(req.PK)}, conn: conn}
+	go func() {
+		for {
+			pac, err := conn.Read()
+			if err != nil {
+				return
+			}
+
+			switch v := pac.Data.(type) {
+			case ping:
+				if delay >= 0 {
+					time.AfterFunc(delay, func() {
+						conn.Write(packet{Data: pong(v)})
+					})
+				}
+			case *request:
+				atomic.AddInt32(&p.requests, 1)
+				resp, err := newResponse(v.ID, bp)
+				assert.Nil(t, err)
+				conn.Write(packet{Data: resp})
+			}
+		}
+	}()
+	assert.Nil(t, conn.Write(packet{Data: Item{T: blockProposalItem, Hash: bp.Hash()}}))
+	return p
+}
+
+func TestNetworkPeerRTT(t *testing.T) {
+	n0 := makeNetwork()
+	n0.pingInterval = 20 * time.Millisecond
+	addr0, err := n0.Start("127.0.0.1", 11031)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	bp := &BlockProposal{Round: 1, Txns: []byte{1}}
+	item := Item{T: blockProposalItem, Hash: bp.Hash()}
+	slow := newDelayedPeer(t, addr0.Addr, 150*time.Millisecond, bp)
+	fast := newDelayedPeer(t, addr0.Addr, 0, bp)
+	mid := newDelayedPeer(t, addr0.Addr, 30*time.Millisecond, bp)
+	rtt := func(p *delayedPeer) time.Duration {
+		n0.mu.Lock()
+		defer n0.mu.Unlock()
+		c, ok := n0.conns[p.addr]
+		if !ok {
+			return 0
+		}
+		return c.RTT()
+	}
+	waitUntil(t, func() bool {
+		return rtt(fast) > 0 && fasterRTT(rtt(fast), rtt(mid)) && fasterRTT(rtt(mid), rtt(slow)) && len(n0.ProvidersOf(item.Hash)) == 3
+	})
+
+	// the faster providers are requested first
+	assert.Equal(t, []unicastAddr{fast.addr, mid.addr, slow.addr}, n0.rankPeers(n0.ProvidersOf(item.Hash)))
+	for i := 0; i < 5; i++ {
+		_, err = n0.requestAny(context.Background(), slow.addr, item, func(interface{}) bool { return true })
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&slow.requests))
+	assert.True(t, atomic.LoadInt32(&fast.requests) > 0)
+
+	// the slowest peer is evicted first
+	n0.setPeerLimits(3, 0)
+	priority := RandSK().MustPK().Addr()
+	n0.priority = func(id Addr) bool { return id == priority }
+	n0.mu.Lock()
+	assert.True(t, n0.makeRoom(priority, false, true))
+	_, ok := n0.conns[slow.addr]
+	n0.mu.Unlock()
+	assert.False(t, ok)
+
+	// the peer not answering the pings is disconnected
+	dead := newDelayedPeer(t, addr0.Addr, -1, bp)
+	waitUntil(t, func() bool {
+		n0.mu.Lock()
+		defer n0.mu.Unlock()
+		_, ok := n0.conns[dead.addr]
+		return ok
+	})
+	waitUntil(t, func() bool {
+		n0.mu.Lock()
+		defer n0.mu.Unlock()
+		_, ok := n0.conns[dead.addr]
+		return !ok
+	})
+	assert.Equal(t, 2, n0.PeerCount())
+}
+
+func TestNetworkOutboundBudget(t *testing.T) {
+	n0 := makeNetwork()
+	n0.setBandwidth(200*time.Millisecond, 2000)
+	_, err := n0.Start("127.0.0.1", 11032)
+	assert.Nil(t, err)
+	n1 := makeNetwork()
+	addr1, err := n1.Start("127.0.0.1", 11033)
+	assert.Nil(t, err)
+	_, err = n0.AddPeer(context.Background(), addr1.Addr)
+	assert.Nil(t, err)
+	n0.mu.Lock()
+	var to unicastAddr
+	for addr := range n0.conns {
+		to = addr
+	}
+	n0.mu.Unlock()
+
+	// the peer is within the budget
+	start := time.Now()
+	assert.True(t, n0.waitBudget(to))
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	// the response is deferred to the next window once the peer
+	// exceeded the budget
+	assert.Nil(t, n0.Send(to, packet{Data: make([]byte, 3000)}))
+	_, ok := recvTimeout(n1, time.Second)
+	assert.True(t, ok)
+	start = time.Now()
+	assert.True(t, n0.waitBudget(to))
+	assert.True(t, time.Since(start) < 250*time.Millisecond)
+	info := n0.Peers()[0]
+	assert.Equal(t, uint64(1), info.Traffic.Deferred)
+	assert.True(t, info.Traffic.Msgs["txn"].BytesOut > 3000)
+	assert.Equal(t, uint64(1), info.Traffic.Msgs["txn"].MsgsOut)
+
+	// a gone peer has no budget
+	assert.False(t, n0.waitBudget(unicastAddr{Addr: "127.0.0.1:1"}))
+}
+
+func TestNetworkDNSSeed(t *testing.T) {
+	seed := makeNetwork()
+	_, err := seed.Start("127.0.0.1", 11034)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// the addresses of the DNS seed are dialed until one of them
+	// passes the handshake
+	var mu sync.Mutex
+	var lookups []string
+	n1 := makeNetwork()
+	n1.setDNSSeeds([]string{"seed.example.com:11034", "no port"})
+	n1.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		lookups = append(lookups, host)
+		mu.Unlock()
+		return []string{"127.0.0.2", "127.0.0.1", "0.0.0.0", "127.0.0.1"}, nil
+	}
+	_, err = n1.Start("127.0.0.1", 11035)
+	assert.Nil(t, err)
+	assert.Nil(t, n1.bootstrap(""))
+	waitUntil(t, func() bool {
+		return n1.PeerCount() == 1 && seed.PeerCount() == 1
+	})
+	mu.Lock()
+	assert.Equal(t, []string{"seed.example.com"}, lookups)
+	mu.Unlock()
+	addrs, err := n1.resolveDNSSeeds()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"127.0.0.2:11034", "127.0.0.1:11034"}, addrs)
+
+	// the failed resolution fails the bootstrap only when there is
+	// no other peer to wait for
+	resolvable := false
+	n2 := makeNetwork()
+	n2.dnsSeedInterval = 20 * time.Millisecond
+	n2.setDNSSeeds([]string{"seed.example.com:11034"})
+	n2.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !resolvable {
+			return nil, errors.New("no such host")
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+	_, err = n2.Start("127.0.0.1", 11036)
+	assert.Nil(t, err)
+	assert.NotNil(t, n2.bootstrap(""))
+
+	dir, err := ioutil.TempDir("", "peer-file")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+	n2.setPeerFile(path, time.Hour)
+	n2.mu.Lock()
+	n2.seen[unicastAddr{Addr: "127.0.0.1:1", PKStr: string(RandSK().MustPK())}] = time.Now()
+	n2.mu.Unlock()
+	assert.Nil(t, n2.savePeers())
+	assert.Nil(t, n2.bootstrap(""))
+	assert.Equal(t, 0, n2.PeerCount())
+
+	// the DNS seeds are resolved again while the node has few
+	// peers
+	mu.Lock()
+	resolvable = true
+	mu.Unlock()
+	waitUntil(t, func() bool {
+		return n2.PeerCount() > 0
+	})
+}
+
+func TestNetworkDialTimeout(t *testing.T) {
+	// 10.255.255.1 is not routed, the dial hangs until the timeout
+	const unroutable = "10.255.255.1:11040"
+	n0 := makeNetwork()
+	n0.setTimeouts(100*time.Millisecond, 0)
+	pk := RandSK().MustPK()
+	start := time.Now()
+	err := n0.connect(unicastAddr{Addr: unroutable, PKStr: string(pk)}, pk)
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 0, n0.PeerCount())
+
+	// the dial in progress is canceled when the network stops
+	n1 := makeNetwork()
+	n1.setTimeouts(time.Minute, 0)
+	ch := make(chan error, 1)
+	start = time.Now()
+	go func() {
+		_, err := n1.AddPeer(context.Background(), unroutable)
+		ch <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	n1.Stop()
+	select {
+	case err := <-ch:
+		assert.NotNil(t, err)
+		assert.True(t, time.Since(start) < time.Second)
+	case <-time.After(time.Second):
+		t.Fatal("the dial is not canceled by Stop")
+	}
+}
+
+func TestNetworkOutboundOnly(t *testing.T) {
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11037)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// nothing is listened on in the outbound-only mode
+	n1 := makeNetwork()
+	n1.setOutboundOnly(true)
+	addr1, err := n1.Start("127.0.0.1", 11038)
+	assert.Nil(t, err)
+	assert.Equal(t, "", addr1.Addr)
+	assert.Equal(t, "", n1.ListenAddr())
+	_, err = net.Dial("tcp", "127.0.0.1:11038")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, n1.ConnectSeed(addr0.Addr))
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1 && n1.PeerCount() == 1
+	})
+
+	// the node's address is not sent in the peer exchange
+	n0.mu.Lock()
+	assert.Empty(t, n0.exchangedNodes())
+	n0.mu.Unlock()
+
+	// the items flow both ways over the outbound connection
+	bp := &BlockProposal{Round: 1, Txns: []byte{1}}
+	assert.Nil(t, n1.Send(broadcast{}, packet{Data: bp}))
+	p, ok := recvTimeout(n0, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, bp.Hash(), p.P.Data.(*BlockProposal).Hash())
+	bp = &BlockProposal{Round: 2, Txns: []byte{2}}
+	assert.Nil(t, n0.Send(broadcast{}, packet{Data: bp}))
+	p, ok = recvTimeout(n1, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, bp.Hash(), p.P.Data.(*BlockProposal).Hash())
+
+	// the seed is not redialed after the network stops
+	n1.Stop()
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 0
+	})
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, n1.PeerCount())
+	assert.Equal(t, 0, n0.PeerCount())
+}
+
+// relayProposals handles the block proposals like the gateway: a new
+// proposal is gossiped on, an announced one is pulled, and the
+// requested ones are served. The received proposals are sent to got.
+func relayProposals(n *network, got chan<- Hash) {
+	bps := make(map[Hash]*BlockProposal)
+	requested := make(map[Item]bool)
+	for {
+		addr, p := n.Recv()
+		switch v := p.Data.(type) {
+		case *BlockProposal:
+			h := v.Hash()
+			if _, ok := bps[h]; ok {
+				continue
+			}
+
+			bps[h] = v
+			got <- h
+			n.Gossip(Item{T: blockProposalItem, Hash: h}, v)
+		case Item:
+			if _, ok := bps[v.Hash]; !ok && !requested[v] {
+				requested[v] = true
+				n.Send(addr, packet{Data: itemRequest(v)})
+			}
+		case itemRequest:
+			if bp, ok := bps[v.Hash]; ok {
+				n.Send(addr, packet{Data: bp})
+			}
+		}
+	}
+}
+
+// bytesSent returns the bytes sent to the connected peers by the
+// nodes.
+func bytesSent(nodes []*network) uint64 {
+	var total uint64
+	for _, n := range nodes {
+		n.mu.Lock()
+		for _, c := range n.conns {
+			total += atomic.LoadUint64(&c.bytesOut)
+		}
+		n.mu.Unlock()
+	}
+	return total
+}
+
+func TestNetworkGossipFanout(t *testing.T) {
+	n0 := makeNetwork()
+	assert.Equal(t, 5, n0.fanoutSize(blockProposalItem, 20))
+	assert.Equal(t, 20, n0.fanoutSize(ntShareItem, 20))
+	n0.setGossipFanout(3)
+	assert.Equal(t, 3, n0.fanoutSize(blockItem, 20))
+	n0.setGossipFanout(-1)
+	assert.Equal(t, 0, n0.fanoutSize(txnItem, 20))
+	assert.Equal(t, 20, n0.fanoutSize(randBeaconSigShareItem, 20))
+
+	// a cluster of 20 nodes, each connected to 3 earlier ones
+	const size = 20
+	nodes := make([]*network, size)
+	addrs := make([]unicastAddr, size)
+	got := make(chan Hash, size)
+	for i := range nodes {
+		nodes[i] = makeNetwork()
+		var err error
+		addrs[i], err = nodes[i].Start("127.0.0.1", 11041+i)
+		assert.Nil(t, err)
+		peers := rand.Perm(i)
+		if len(peers) > 3 {
+			peers = peers[:3]
+		}
+		for _, j := range peers {
+			_, err = nodes[i].AddPeer(context.Background(), addrs[j].Addr)
+			assert.Nil(t, err)
+		}
+		go relayProposals(nodes[i], got)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// the bytes sent for a proposal announced to every peer,
+	// pushed to the square root of the peers, and pushed to every
+	// peer
+	sent := make(map[int]uint64)
+	for i, fanout := range []int{-1, 0, size} {
+		for _, n := range nodes {
+			n.setGossipFanout(fanout)
+		}
+
+		before := bytesSent(nodes)
+		bp := &BlockProposal{Round: uint64(i + 1), Txns: make([]byte, 16<<10)}
+		nodes[0].ch <- packetAndAddr{P: packet{Data: bp}}
+		for range nodes {
+			select {
+			case h := <-got:
+				assert.Equal(t, bp.Hash(), h)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("the proposal did not reach every node with the fanout %d", fanout)
+			}
+		}
+
+		// the duplicates still on the way
+		time.Sleep(200 * time.Millisecond)
+		sent[fanout] = bytesSent(nodes) - before
+		t.Logf("fanout %d: %d bytes sent", fanout, sent[fanout])
+	}
+
+	assert.True(t, sent[0] < sent[size])
+}
+
+// dialSilent connects to the node at the address as a peer that
+// stops reading and writing after the handshake.
+func dialSilent(t *testing.T, to string) net.Conn {
+	c, err := net.Dial("tcp", to)
+	assert.Nil(t, err)
+	conn := newConn(c)
+	n := makeNetwork()
+	_, err = n.sendHello(conn)
+	assert.Nil(t, err)
+	req := &connectRequest{}
+	req.sign(n.sk, conn.transcript)
+	assert.Nil(t, conn.Write(packet{Data: req}))
+	_, _, err = readHandshake(context.Background(), conn)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestNetworkKeepalive(t *testing.T) {
+	n0 := makeNetwork()
+	n0.setKeepalive(30*time.Millisecond, 200*time.Millisecond)
+	addr0, err := n0.Start("127.0.0.1", 11061)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// the idle peer is pinged and stays connected
+	n1 := makeNetwork()
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+
+	// the peer that stops reading and writing after the
+	// handshake is disconnected in the read timeout
+	c := dialSilent(t, addr0.Addr)
+	defer c.Close()
+	start := time.Now()
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 2
+	})
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 1, n0.PeerCount())
+	assert.Equal(t, 1, n1.PeerCount())
+}
+
+func TestNetworkBatchInventory(t *testing.T) {
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11062)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	n1 := makeNetwork()
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+
+	peerOf := func(n *network) (unicastAddr, *conn) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for addr, c := range n.conns {
+			return addr, c
+		}
+		return unicastAddr{}, nil
+	}
+	_, c := peerOf(n0)
+
+	// the announcements of 1,000 txns are sent in a few batches
+	const count = 1000
+	for i := 0; i < count; i++ {
+		n0.Gossip(Item{T: txnItem, Hash: SHA3([]byte(fmt.Sprint(i)))}, nil)
+	}
+	n0.Gossip(Item{T: blockItem, Hash: Hash{1}}, nil)
+	for i := 0; i <= count; i++ {
+		p, ok := recvTimeout(n1, time.Second)
+		assert.True(t, ok)
+		assert.IsType(t, Item{}, p.P.Data)
+	}
+	msgs := c.traffic.snapshot().Msgs
+	assert.Equal(t, uint64(0), msgs["item"].MsgsOut)
+	assert.True(t, msgs["inventory"].MsgsOut >= 2)
+	assert.True(t, msgs["inventory"].MsgsOut <= 10)
+	t.Logf("%d inventory messages sent for %d txns", msgs["inventory"].MsgsOut, count)
+
+	// the items of a batch are grouped by their types
+	c.batch(Item{T: txnItem, Hash: Hash{2}}, time.Hour)
+	c.batch(Item{T: blockItem, Hash: Hash{3}}, time.Hour)
+	c.flushBatch()
+	p, ok := recvTimeout(n1, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, Item{T: blockItem, Hash: Hash{3}}, p.P.Data)
+	_, ok = recvTimeout(n1, time.Second)
+	assert.True(t, ok)
+
+	// so are the requests
+	to, c1 := peerOf(n1)
+	for i := 0; i < count; i++ {
+		assert.Nil(t, n1.Send(to, packet{Data: itemRequest{T: txnItem, Hash: SHA3([]byte(fmt.Sprint(i)))}}))
+	}
+	for i := 0; i < count; i++ {
+		p, ok := recvTimeout(n0, time.Second)
+		assert.True(t, ok)
+		assert.IsType(t, itemRequest{}, p.P.Data)
+	}
+	msgs = c1.traffic.snapshot().Msgs
+	assert.Equal(t, uint64(0), msgs["item_request"].MsgsOut)
+	assert.True(t, msgs["get_data"].MsgsOut <= 10)
+
+	// they are sent at once without the delay
+	n0.setAnnounceDelay(-1)
+	n0.Gossip(Item{T: txnItem, Hash: Hash{4}}, nil)
+	_, ok = recvTimeout(n1, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), c.traffic.snapshot().Msgs["item"].MsgsOut)
+}
+
+func TestNetworkSlowPeer(t *testing.T) {
+	n0 := makeNetwork()
+	n0.setSendQueue(32, 100*time.Millisecond)
+	addr0, err := n0.Start("127.0.0.1", 11063)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	n1 := makeNetwork()
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	slow := dialSilent(t, addr0.Addr)
+	defer slow.Close()
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 2
+	})
+
+	// the socket buffers of the slow peer fill up at once
+	var slowConn *conn
+	n0.mu.Lock()
+	for addr, c := range n0.conns {
+		if addr.PKStr != string(n1.sk.MustPK()) {
+			slowConn = c
+		}
+	}
+	n0.mu.Unlock()
+	assert.Nil(t, slowConn.conn.(*net.TCPConn).SetWriteBuffer(4096))
+
+	// the fast peer receives every txn in time, while the txns
+	// to the slow peer are dropped until it is disconnected
+	const count = 200
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			txn := make([]byte, 16<<10)
+			txn[0], txn[1], txn[2] = byte(i), byte(i>>8), byte(i>>16)
+			n0.Send(broadcast{}, packet{Data: txn})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < count; i++ {
+		_, ok := recvTimeout(n1, time.Second)
+		assert.True(t, ok)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for n0.PeerCount() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, n0.PeerCount())
+	assert.True(t, slowConn.traffic.snapshot().Dropped > 0)
+}
+
+// forkNode follows the heaviest fork of the blocks it receives from
+// the network as the chain does, see heaviestFork. The weight of a
+// block is the weight of its owner.
+type forkNode struct {
+	net     *network
+	weights map[Addr]float64
+	genesis Hash
+
+	mu     sync.Mutex
+	blocks map[Hash]*Block
+	nodes  map[Hash]*blockNode
+	fork   []*blockNode
+	// the blocks waiting for their previous blocks
+	orphans map[Hash][]*Block
+}
+
+func newForkNode(n *network, genesis *Block, weights map[Addr]float64) *forkNode {
+	gh := genesis.Hash()
+	f := &forkNode{
+		net:     n,
+		weights: weights,
+		genesis: gh,
+		blocks:  map[Hash]*Block{gh: genesis},
+		nodes:   make(map[Hash]*blockNode),
+		orphans: make(map[Hash][]*Block),
+	}
+	go f.relay()
+	return f
+}
+
+// add adds the block, and the orphans waiting for it. It returns the
+// blocks added, and false if the previous block is missing.
+func (f *forkNode) add(b *Block) ([]*Block, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	h := b.Hash()
+	if _, ok := f.blocks[h]; ok {
+		return nil, true
+	}
+
+	if _, ok := f.blocks[b.PrevBlock]; !ok {
+		f.orphans[b.PrevBlock] = append(f.orphans[b.PrevBlock], b)
+		return nil, false
+	}
+
+	var added []*Block
+	queue := []*Block{b}
+	for len(queue) > 0 {
+		b := queue[0]
+		queue = queue[1:]
+		h := b.Hash()
+		if _, ok := f.blocks[h]; ok {
+			continue
+		}
+
+		node := &blockNode{Block: h, Weight: f.weights[b.Owner]}
+		if prev, ok := f.nodes[b.PrevBlock]; ok {
+			node.parent = prev
+			prev.blockChildren = append(prev.blockChildren, node)
+		} else {
+			f.fork = append(f.fork, node)
+		}
+
+		f.blocks[h] = b
+		f.nodes[h] = node
+		added = append(added, b)
+		queue = append(queue, f.orphans[h]...)
+		delete(f.orphans, h)
+	}
+	return added, true
+}
+
+func (f *forkNode) block(h Hash) *Block {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.blocks[h]
+}
+
+// leader returns the block at the tip of the heaviest fork.
+func (f *forkNode) leader() *Block {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.fork) == 0 {
+		return f.blocks[f.genesis]
+	}
+
+	n := heaviestFork(f.fork, maxHeight(f.fork)-1)
+	return f.blocks[n.Block]
+}
+
+// propose adds a block extending the leader, and gossips it.
+func (f *forkNode) propose() *Block {
+	prev := f.leader()
+	b := &Block{
+		Owner:     f.net.sk.MustPK().Addr(),
+		Round:     prev.Round + 1,
+		PrevBlock: prev.Hash(),
+	}
+	f.add(b)
+	f.net.Gossip(Item{T: blockItem, Hash: b.Hash()}, b)
+	return b
+}
+
+// relay adds and gossips the blocks received, the missing previous
+// blocks are requested from the peer sending the block.
+func (f *forkNode) relay() {
+	for {
+		addr, p := f.net.Recv()
+		switch v := p.Data.(type) {
+		case *Block:
+			added, ok := f.add(v)
+			if !ok {
+				f.net.Send(addr, packet{Data: itemRequest{T: blockItem, Hash: v.PrevBlock}})
+			}
+
+			for _, b := range added {
+				f.net.Gossip(Item{T: blockItem, Hash: b.Hash()}, b)
+			}
+		case Item:
+			if v.T == blockItem && f.block(v.Hash) == nil {
+				f.net.Send(addr, packet{Data: itemRequest(v)})
+			}
+		case itemRequest:
+			if b := f.block(v.Hash); v.T == blockItem && b != nil {
+				f.net.Send(addr, packet{Data: b})
+			}
+		}
+	}
+}
+
+func TestNetworkPartitionForkHeals(t *testing.T) {
+	sim := newSimNet(simLink{latency: 5 * time.Millisecond, jitter: 5 * time.Millisecond, bandwidth: 1 << 20})
+	hosts := []string{"10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.1.4", "10.0.1.5"}
+	nets := make([]*network, len(hosts))
+	weights := make(map[Addr]float64)
+	for i, host := range hosts {
+		nets[i] = makeSimNetwork(sim, host)
+		// the majority side proposes the heavier blocks
+		weights[nets[i].sk.MustPK().Addr()] = 1
+		if i >= 3 {
+			weights[nets[i].sk.MustPK().Addr()] = 0.5
+		}
+	}
+
+	genesis := &Block{}
+	nodes := make([]*forkNode, len(hosts))
+	for i, n := range nets {
+		addr, err := n.Start(hosts[i], 11064)
+		assert.Nil(t, err)
+		nodes[i] = newForkNode(n, genesis, weights)
+		for _, prev := range nets[:i] {
+			_, err := prev.AddPeer(context.Background(), addr.Addr)
+			assert.Nil(t, err)
+		}
+	}
+	for _, n := range nets {
+		n := n
+		waitUntil(t, func() bool {
+			return n.PeerCount() == len(hosts)-1
+		})
+	}
+
+	// waitLeader waits until the nodes follow the block.
+	waitLeader := func(nodes []*forkNode, b *Block) {
+		for _, f := range nodes {
+			f := f
+			deadline := time.Now().Add(5 * time.Second)
+			for f.leader().Hash() != b.Hash() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.Equal(t, b.Hash(), f.leader().Hash())
+		}
+	}
+
+	// both sides of the partition build their own fork
+	sim.partition(hosts[:3], hosts[3:])
+	var major, minor *Block
+	for round := 0; round < 3; round++ {
+		major = nodes[round%3].propose()
+		minor = nodes[3+round%2].propose()
+		waitLeader(nodes[:3], major)
+		waitLeader(nodes[3:], minor)
+	}
+	assert.Equal(t, uint64(3), major.Round)
+	assert.Equal(t, uint64(3), minor.Round)
+
+	// after the partition heals, the next block pulls the
+	// minority onto the heavier fork.
+	sim.heal()
+	next := nodes[0].propose()
+	waitLeader(nodes, next)
+	for _, f := range nodes[3:] {
+		f.mu.Lock()
+		assert.Equal(t, 2, len(f.fork))
+		f.mu.Unlock()
+	}
+}
+
+// claimID dials the address, and sends the connect request signed by
+// the signer for the PK over the transcript. It returns the error
+// reading the reply of the peer.
+func claimID(t *testing.T, to string, pk PK, signer SK, transcript *Hash) error {
+	c, err := net.Dial("tcp", to)
+	assert.Nil(t, err)
+	defer c.Close()
+	conn := newConn(c)
+	_, err = makeNetwork().sendHello(conn)
+	assert.Nil(t, err)
+	if transcript == nil {
+		transcript = &conn.transcript
+	}
+
+	req := &connectRequest{PK: pk}
+	req.Sig = signer.Sign(req.bytesToSign(*transcript))
+	assert.Nil(t, conn.Write(packet{Data: req}))
+	_, _, err = readHandshake(context.Background(), conn)
+	return err
+}
+
+func TestNetworkIdentity(t *testing.T) {
+	n0 := makeNetwork()
+	victim := RandSK()
+	validator := RandSK()
+	validators := map[Addr]bool{
+		n0.sk.MustPK().Addr():     true,
+		victim.MustPK().Addr():    true,
+		validator.MustPK().Addr(): true,
+	}
+	n0.validator = func(id Addr) bool { return validators[id] }
+	addr0, err := n0.Start("127.0.0.1", 11066)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// a peer claiming the victim's ID without its key is rejected,
+	// the victim's signature of another connection can not be
+	// replayed either.
+	assert.NotNil(t, claimID(t, addr0.Addr, victim.MustPK(), RandSK(), nil))
+	assert.NotNil(t, claimID(t, addr0.Addr, victim.MustPK(), victim, &Hash{}))
+	assert.NotNil(t, claimID(t, addr0.Addr, victim.MustPK(), victim, &Hash{1}))
+	assert.Nil(t, claimID(t, addr0.Addr, victim.MustPK(), victim, nil))
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 0
+	})
+
+	// a peer dialed for the victim's ID must prove it
+	impostor := makeNetwork()
+	impostorAddr, err := impostor.Start("127.0.0.1", 11067)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	n1 := makeNetwork()
+	err = n1.connect(unicastAddr{Addr: impostorAddr.Addr, PKStr: string(victim.MustPK())}, victim.MustPK())
+	assert.Contains(t, err.Error(), "proved the ID")
+	assert.Equal(t, 0, n1.PeerCount())
+
+	// the observers are allowed but flagged, and banning one bans
+	// its host, which is not enforced on the validators.
+	observer := makeNetwork()
+	info, err := observer.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	assert.False(t, info.Observer)
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+	peers := n0.Peers()
+	assert.Equal(t, observer.sk.MustPK().Addr(), peers[0].ID)
+	assert.True(t, peers[0].Observer)
+
+	n0.BanPeer(peers[0].ID, time.Minute)
+	_, err = makeNetwork().AddPeer(context.Background(), addr0.Addr)
+	assert.NotNil(t, err)
+	n2 := newNetwork(validator)
+	_, err = n2.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		p := n0.Peers()
+		return len(p) == 1 && !p[0].Observer
+	})
+}
+
+func TestNetworkGracefulDisconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer-file")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the queued txns take a while to be written at the bandwidth
+	sim := newSimNet(simLink{latency: 2 * time.Millisecond, bandwidth: 100 << 10})
+	n0 := makeSimNetwork(sim, "10.0.3.1")
+	addr0, err := n0.Start("10.0.3.1", 11068)
+	assert.Nil(t, err)
+	n1 := makeSimNetwork(sim, "10.0.3.2")
+	n1.setPeerFile(filepath.Join(dir, "peers"), time.Hour)
+	_, err = n1.Start("10.0.3.2", 11069)
+	assert.Nil(t, err)
+
+	id1 := n1.sk.MustPK().Addr()
+	connect := func() unicastAddr {
+		_, err := n1.AddPeer(context.Background(), addr0.Addr)
+		assert.Nil(t, err)
+		waitUntil(t, func() bool {
+			return n0.PeerCount() == 1
+		})
+
+		n0.mu.Lock()
+		defer n0.mu.Unlock()
+		for addr := range n0.conns {
+			return addr
+		}
+		return unicastAddr{}
+	}
+
+	const count = 20
+	sendTxns := func(to unicastAddr) {
+		for i := 0; i < count; i++ {
+			txn := make([]byte, 4<<10)
+			txn[0] = byte(i)
+			assert.Nil(t, n0.Send(to, packet{Data: txn}))
+		}
+	}
+
+	recvTxns := func(d time.Duration) []byte {
+		var recv []byte
+		for {
+			p, ok := recvTimeout(n1, d)
+			if !ok {
+				return recv
+			}
+
+			if txn, ok := p.P.Data.([]byte); ok {
+				recv = append(recv, txn[0])
+			}
+		}
+	}
+
+	// the queued txns arrive in order before the connection is
+	// closed, and the peer learns the reason.
+	to := connect()
+	sendTxns(to)
+	assert.Nil(t, n0.DisconnectPeer(id1))
+	assert.NotNil(t, n0.Send(to, packet{Data: []byte{1}}))
+	recv := recvTxns(500 * time.Millisecond)
+	assert.Equal(t, count, len(recv))
+	for i, b := range recv {
+		assert.Equal(t, byte(i), b)
+	}
+
+	waitUntil(t, func() bool {
+		return n1.PeerCount() == 0
+	})
+	n1.mu.Lock()
+	assert.Equal(t, "requested", n1.disconnects[addr0])
+	n1.mu.Unlock()
+
+	assert.Nil(t, n1.savePeers())
+	peers, err := n1.loadPeers()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(peers))
+	assert.Equal(t, "requested", peers[0].LastDisconnect)
+
+	// a ban closes the connection at once, dropping the queued
+	// txns
+	to = connect()
+	sendTxns(to)
+	n0.BanPeer(id1, time.Minute)
+	assert.True(t, len(recvTxns(500*time.Millisecond)) < count)
+}
+
+func TestNetworkConsensusPriority(t *testing.T) {
+	sim := newSimNet(simLink{latency: 2 * time.Millisecond, bandwidth: 100 << 10})
+	n0 := makeSimNetwork(sim, "10.0.4.1")
+	addr0, err := n0.Start("10.0.4.1", 11070)
+	assert.Nil(t, err)
+	n1 := makeSimNetwork(sim, "10.0.4.2")
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+
+	var to unicastAddr
+	n0.mu.Lock()
+	for addr := range n0.conns {
+		to = addr
+	}
+	n0.mu.Unlock()
+
+	// the txns queued to the peer take seconds to be written, the
+	// proposal sent after them is written next
+	for i := 0; i < 100; i++ {
+		txn := make([]byte, 4<<10)
+		txn[0] = byte(i)
+		assert.Nil(t, n0.Send(to, packet{Data: txn}))
+	}
+	assert.True(t, n0.Peers()[0].Traffic.Queued["gossip"] > 50)
+
+	start := time.Now()
+	bp := &BlockProposal{Round: 1}
+	assert.Nil(t, n0.Send(to, packet{Data: bp}))
+	for {
+		p, ok := recvTimeout(n1, time.Second)
+		if !assert.True(t, ok) {
+			return
+		}
+
+		if _, ok := p.P.Data.(*BlockProposal); ok {
+			break
+		}
+	}
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+}
+
+func TestNetworkBanList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ban-file")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bans")
+
+	sim := newSimNet(simLink{latency: time.Millisecond})
+	n0 := makeSimNetwork(sim, "10.0.5.1")
+	n0.setBanFile(path)
+	_, err = n0.Start("10.0.5.1", 11071)
+	assert.Nil(t, err)
+	banned := makeSimNetwork(sim, "10.0.6.7")
+	bannedAddr, err := banned.Start("10.0.6.7", 11072)
+	assert.Nil(t, err)
+	other := makeSimNetwork(sim, "10.0.7.1")
+
+	// the connected peer of the banned network is dropped
+	_, err = banned.AddPeer(context.Background(), "10.0.5.1:11071")
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+	id := Addr{1}
+	assert.Nil(t, n0.AddBan(Ban{Net: "10.0.6.0/24", Until: time.Now().Add(time.Hour), Reason: "spam"}))
+	assert.Nil(t, n0.AddBan(Ban{ID: id, Reason: "invalid blocks"}))
+	assert.NotNil(t, n0.AddBan(Ban{Net: "10.0.6.0/33"}))
+	assert.NotNil(t, n0.AddBan(Ban{ID: id, Net: "10.0.6.0/24"}))
+	assert.Equal(t, 0, n0.PeerCount())
+
+	// the bans are loaded by the restarted network
+	restarted := newNetwork(n0.sk)
+	restarted.transport = sim.transport("10.0.5.2")
+	restarted.setBanFile(path)
+	addr, err := restarted.Start("10.0.5.2", 11071)
+	assert.Nil(t, err)
+	bans := restarted.Bans()
+	assert.Equal(t, 2, len(bans))
+	assert.Equal(t, id, bans[0].ID)
+	assert.True(t, bans[0].Until.IsZero())
+	assert.Equal(t, "10.0.6.0/24", bans[1].Net)
+	assert.Equal(t, "spam", bans[1].Reason)
+
+	// a dial from within the range is rejected, while one outside
+	// is accepted
+	_, err = banned.AddPeer(context.Background(), addr.Addr)
+	assert.NotNil(t, err)
+	_, err = other.AddPeer(context.Background(), addr.Addr)
+	assert.Nil(t, err)
+	waitUntil(t, func() bool {
+		return restarted.PeerCount() == 1
+	})
+
+	// the hosts in the range are not dialed
+	_, err = restarted.AddPeer(context.Background(), bannedAddr.Addr)
+	assert.Contains(t, err.Error(), "banned")
+
+	// the removed ban is removed from the file too
+	assert.Nil(t, restarted.RemoveBan(Ban{Net: "10.0.6.0/24"}))
+	assert.Equal(t, ErrBanNotFound, restarted.RemoveBan(Ban{Net: "10.0.6.0/24"}))
+	_, err = banned.AddPeer(context.Background(), addr.Addr)
+	assert.Nil(t, err)
+	again := newNetwork(n0.sk)
+	again.setBanFile(path)
+	assert.Nil(t, again.loadBans())
+	assert.Equal(t, 1, len(again.Bans()))
+
+	// the expired bans are purged lazily
+	assert.Nil(t, again.AddBan(Ban{Net: "10.0.8.1", Until: time.Now().Add(200 * time.Millisecond)}))
+	bans = again.Bans()
+	if assert.Equal(t, 2, len(bans)) {
+		assert.Equal(t, "10.0.8.1/32", bans[1].Net)
+	}
+	time.Sleep(250 * time.Millisecond)
+	again.mu.Lock()
+	assert.False(t, again.isBannedHost("10.0.8.1"))
+	assert.Equal(t, 0, len(again.bannedNets))
+	again.mu.Unlock()
+}
+
+func TestNetworkRateLimits(t *testing.T) {
+	sim := newSimNet(simLink{latency: time.Millisecond})
+	n0 := makeSimNetwork(sim, "10.0.9.1")
+	n0.setRateLimits(map[string]RateLimit{"get_data": {Rate: 10, Burst: 10}})
+	addr0, err := n0.Start("10.0.9.1", 11073)
+	assert.Nil(t, err)
+	n1 := makeSimNetwork(sim, "10.0.9.2")
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+
+	// the mock peer floods the requests of the historical blocks,
+	// interleaved with the blocks
+	const count = 120
+	for i := 0; i < count; i++ {
+		req := getData{{T: blockItem, Hash: Hash{byte(i)}}}
+		assert.Nil(t, n1.Send(addr0, packet{Data: req}))
+		if i%10 == 0 {
+			assert.Nil(t, n1.Send(addr0, packet{Data: &Block{Round: uint64(i)}}))
+		}
+	}
+
+	// the requests over the burst are dropped, while the blocks
+	// are all received
+	requests, blocks := 0, 0
+	for {
+		p, ok := recvTimeout(n0, 200*time.Millisecond)
+		if !ok {
+			break
+		}
+
+		switch p.P.Data.(type) {
+		case itemRequest:
+			requests++
+		case *Block:
+			blocks++
+		}
+	}
+	assert.True(t, requests >= 10 && requests < 20)
+	assert.Equal(t, count/10, blocks)
+
+	// the peer is penalized for the sustained violations
+	info := n0.Peers()[0]
+	assert.Equal(t, uint64(count-requests), info.Traffic.Throttled)
+	assert.Equal(t, -2, info.Reputation)
+
+	limits, err := ParseRateLimits("get_data=100:512, request=0:0")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]RateLimit{"get_data": {Rate: 100, Burst: 512}, "request": {}}, limits)
+	_, err = ParseRateLimits("unknown=1:1")
+	assert.NotNil(t, err)
+	_, err = ParseRateLimits("get_data=1")
+	assert.NotNil(t, err)
+}
+
+func TestNetworkMetrics(t *testing.T) {
+	sim := newSimNet(simLink{latency: time.Millisecond})
+	n0 := makeSimNetwork(sim, "10.0.10.1")
+	addr0, err := n0.Start("10.0.10.1", 11074)
+	assert.Nil(t, err)
+	n1 := makeSimNetwork(sim, "10.0.10.2")
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+
+	// a txn is sent twice, the duplicate is not delivered
+	for _, data := range []interface{}{
+		[]byte{1}, []byte{2}, []byte{3}, []byte{1},
+		&Block{Round: 1}, &Block{Round: 2},
+	} {
+		assert.Nil(t, n1.Send(addr0, packet{Data: data}))
+	}
+
+	received := 0
+	for {
+		_, ok := recvTimeout(n0, 200*time.Millisecond)
+		if !ok {
+			break
+		}
+		received++
+	}
+	assert.Equal(t, 5, received)
+
+	m := n0.Metrics()
+	assert.Equal(t, uint64(4), m.Msgs["txn"].MsgsIn)
+	assert.Equal(t, uint64(2), m.Msgs["block"].MsgsIn)
+	assert.True(t, m.Msgs["txn"].BytesIn > 0)
+	assert.Equal(t, 1, m.Inbound)
+	assert.Equal(t, 0, m.Outbound)
+	assert.Equal(t, uint64(6), m.GossipReceived)
+	assert.Equal(t, uint64(1), m.GossipDuplicates)
+	assert.Equal(t, 1.0/6, m.DedupHitRate())
+	assert.Equal(t, []int{0}, m.QueueDepths["gossip"])
+	assert.Equal(t, uint64(0), m.HandshakeFailures)
+
+	m = n1.Metrics()
+	assert.Equal(t, uint64(4), m.Msgs["txn"].MsgsOut)
+	assert.Equal(t, uint64(2), m.Msgs["block"].MsgsOut)
+	assert.Equal(t, 0, m.Inbound)
+	assert.Equal(t, 1, m.Outbound)
+
+	// the handshake with a mismatched genesis fails on both sides
+	n2 := makeSimNetwork(sim, "10.0.10.3")
+	n2.genesis = SHA3([]byte("other genesis"))
+	_, err = n2.AddPeer(context.Background(), addr0.Addr)
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(1), n2.Metrics().HandshakeFailures)
+	waitUntil(t, func() bool {
+		return n0.Metrics().HandshakeFailures == 1
+	})
+	assert.Equal(t, 1, n0.Metrics().Inbound)
+}
+
+func TestNetworkSnapshotSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewSnapshotStore(filepath.Join(dir, "store"), 64)
+	assert.Nil(t, err)
+	blob := snapshotTestBlob(40)
+	assert.Nil(t, store.Add(blob))
+	m, err := store.manifest(blob.Root)
+	assert.Nil(t, err)
+	assert.True(t, len(m.Chunks) > 20)
+
+	sim := newSimNet(simLink{latency: time.Millisecond})
+	n0 := makeSimNetwork(sim, "10.0.11.1")
+	addr0, err := n0.Start("10.0.11.1", 11075)
+	assert.Nil(t, err)
+	n1 := makeSimNetwork(sim, "10.0.11.2")
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+
+	// the peer serves the snapshot, and drops the connection
+	// instead of serving the 10th chunk
+	var mu sync.Mutex
+	served := make(map[int]bool)
+	requested := 0
+	go func() {
+		for {
+			addr, pac := n0.Recv()
+			r, ok := pac.Data.(*request)
+			if !ok {
+				continue
+			}
+
+			if r.Item.T == snapshotChunkItem {
+				mu.Lock()
+				requested++
+				drop := requested == 10
+				served[int(r.Item.Round)] = !drop
+				mu.Unlock()
+				if drop {
+					n0.mu.Lock()
+					for _, c := range n0.conns {
+						c.Close()
+					}
+					n0.mu.Unlock()
+					continue
+				}
+			}
+
+			resp, err := newResponse(r.ID, lookupSnapshot(store, r.Item))
+			assert.Nil(t, err)
+			n0.Send(addr, packet{Data: resp})
+		}
+	}()
+
+	downloads := filepath.Join(dir, "downloads")
+	_, err = n1.FetchSnapshot(context.Background(), blob.Root, downloads)
+	assert.NotNil(t, err)
+	d, err := openSnapshotDownload(downloads, blob.Root)
+	assert.Nil(t, err)
+	verified := d.verified()
+	assert.True(t, len(verified) > 0 && len(verified) < len(m.Chunks))
+
+	// the retry only downloads the chunks not verified
+	waitUntil(t, func() bool {
+		return n1.PeerCount() == 0
+	})
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	mu.Lock()
+	served = make(map[int]bool)
+	mu.Unlock()
+	fetched, err := n1.FetchSnapshot(context.Background(), blob.Root, downloads)
+	assert.Nil(t, err)
+	assert.Equal(t, blob, fetched)
+	mu.Lock()
+	assert.Equal(t, len(m.Chunks)-len(verified), len(served))
+	for i := range served {
+		assert.False(t, verified[i], "chunk %d downloaded again", i)
+	}
+	mu.Unlock()
+
+	// the download is removed once assembled
+	_, err = os.Stat(d.dir)
+	assert.True(t, os.IsNotExist(err))
+	_, err = n1.FetchSnapshot(context.Background(), Hash{1}, downloads)
+	assert.Equal(t, errSnapshotNotFound, err)
+}
+
+// snapshotTestBlob returns a TrieBlob of n nodes keyed by their
+// hashes, the first node is the root.
+func snapshotTestBlob(n int) TrieBlob {
+	blob := TrieBlob{Data: make(map[Hash][]byte)}
+	for i := 0; i < n; i++ {
+		v := []byte(fmt.Sprintf("the value %d of the trie", i))
+		h := Hash(crypto.Keccak256Hash(v))
+		if i == 0 {
+			blob.Root = h
+		}
+		blob.Data[h] = v
+	}
+	return blob
+}
+
+func TestNetworkSnapshotSyncForged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewSnapshotStore(filepath.Join(dir, "store"), 64)
+	assert.Nil(t, err)
+
+	// the manifest and the chunks are consistent, but a node is
+	// not keyed by its hash.
+	forged := snapshotTestBlob(10)
+	forged.Data[SHA3([]byte("existing node"))] = []byte("forged node")
+	assert.Nil(t, store.Add(forged))
+
+	// the nodes match their keys, but the root node is missing.
+	noRoot := snapshotTestBlob(10)
+	noRoot.Root = SHA3([]byte("root"))
+	assert.Nil(t, store.Add(noRoot))
+
+	sim := newSimNet(simLink{latency: time.Millisecond})
+	n0 := makeSimNetwork(sim, "10.0.11.3")
+	addr0, err := n0.Start("10.0.11.3", 11077)
+	assert.Nil(t, err)
+	n1 := makeSimNetwork(sim, "10.0.11.4")
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			addr, pac := n0.Recv()
+			r, ok := pac.Data.(*request)
+			if !ok {
+				continue
+			}
+
+			resp, err := newResponse(r.ID, lookupSnapshot(store, r.Item))
+			assert.Nil(t, err)
+			n0.Send(addr, packet{Data: resp})
+		}
+	}()
+
+	downloads := filepath.Join(dir, "downloads")
+	for _, root := range []Hash{forged.Root, noRoot.Root} {
+		_, err = n1.FetchSnapshot(context.Background(), root, downloads)
+		assert.NotNil(t, err)
+
+		// the download starts over
+		d, err := openSnapshotDownload(downloads, root)
+		assert.Nil(t, err)
+		assert.Nil(t, d.manifest)
+	}
+}
+
+func TestNetworkUnknownItemType(t *testing.T) {
+	sim := newSimNet(simLink{latency: time.Millisecond})
+	n0 := makeSimNetwork(sim, "10.0.12.3")
+	addr0, err := n0.Start("10.0.12.3", 11078)
+	assert.Nil(t, err)
+	n1 := makeSimNetwork(sim, "10.0.12.4")
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+
+	var c1 *conn
+	n1.mu.Lock()
+	for _, c := range n1.conns {
+		c1 = c
+	}
+	n1.mu.Unlock()
+	id1 := n1.sk.MustPK().Addr()
+
+	unknown := Item{T: 200, Hash: Hash{1}}
+	assert.Nil(t, c1.send(packet{Data: &request{ID: 1, Item: unknown}}))
+	assert.Nil(t, c1.send(packet{Data: unknown}))
+	assert.Nil(t, c1.send(packet{Data: getData{{T: txnItem, Hash: Hash{2}}, unknown}}))
+	assert.Nil(t, c1.send(packet{Data: itemRequest{T: txnItem, Hash: Hash{3}}}))
+
+	// only the valid request is passed on
+	p, ok := recvTimeout(n0, time.Second)
+	if assert.True(t, ok) {
+		assert.Equal(t, itemRequest{T: txnItem, Hash: Hash{3}}, p.P.Data)
+	}
+	_, ok = recvTimeout(n0, 100*time.Millisecond)
+	assert.False(t, ok)
+
+	n0.mu.Lock()
+	assert.Equal(t, -3, n0.reputation[id1])
+	n0.mu.Unlock()
+}
+
+func TestNetworkGetDataCap(t *testing.T) {
+	sim := newSimNet(simLink{latency: time.Millisecond})
+	n0 := makeSimNetwork(sim, "10.0.12.1")
+	n0.setMaxGetData(100)
+	addr0, err := n0.Start("10.0.12.1", 11076)
+	assert.Nil(t, err)
+	n1 := makeSimNetwork(sim, "10.0.12.2")
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+
+	var to unicastAddr
+	var c1 *conn
+	n1.mu.Lock()
+	for addr, c := range n1.conns {
+		to, c1 = addr, c
+	}
+	n1.mu.Unlock()
+	assert.Equal(t, 100, c1.peerMaxGetData)
+
+	recvRequests := func(count int) {
+		for i := 0; i < count; i++ {
+			p, ok := recvTimeout(n0, time.Second)
+			if !assert.True(t, ok) {
+				return
+			}
+			assert.IsType(t, itemRequest{}, p.P.Data)
+		}
+		_, ok := recvTimeout(n0, 100*time.Millisecond)
+		assert.False(t, ok)
+	}
+
+	// the requests are paginated by the advertised cap
+	const count = 1000
+	for i := 0; i < count; i++ {
+		assert.Nil(t, n1.Send(to, packet{Data: itemRequest{T: txnItem, Hash: SHA3([]byte(fmt.Sprint(i)))}}))
+	}
+	recvRequests(count)
+	assert.True(t, c1.traffic.snapshot().Msgs["get_data"].MsgsOut >= count/100)
+	assert.Equal(t, uint64(0), c1.traffic.snapshot().Msgs["truncated_get_data"].MsgsIn)
+
+	// the items over the cap are sent back, and requested again
+	items := make(getData, 250)
+	for i := range items {
+		items[i] = Item{T: txnItem, Hash: SHA3([]byte(fmt.Sprint("truncated", i)))}
+	}
+	assert.Nil(t, c1.send(packet{Data: items}))
+	recvRequests(len(items))
+	assert.Equal(t, uint64(1), c1.traffic.snapshot().Msgs["truncated_get_data"].MsgsIn)
+	assert.Equal(t, -1, n0.Peers()[0].Reputation)
+
+	// the transcripts of the older versions do not cover the cap,
+	// which their peers do not know
+	h := n0.hello()
+	old := *h
+	old.MaxGetData = 0
+	assert.Equal(t, transcript(h, h, getDataCapProtocolVersion-1), transcript(&old, &old, getDataCapProtocolVersion-1))
+	assert.NotEqual(t, transcript(h, h, getDataCapProtocolVersion), transcript(&old, &old, getDataCapProtocolVersion))
+}
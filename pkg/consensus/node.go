@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -20,6 +23,8 @@ type Node struct {
 	gateway *gateway
 	chain   *Chain
 	store   *storage
+	// nil if Config.SnapshotDir is not set
+	snapshots *SnapshotStore
 
 	mu sync.Mutex
 	// the memberships of different groups
@@ -31,6 +36,10 @@ type Node struct {
 	cancelNotarize map[uint64]func()
 }
 
+// the proposer waits at most BlockTime/txnWaitDivisor since the end
+// of the last round for the txns when the txn pool is empty.
+const txnWaitDivisor = 4
+
 // NodeCredentials stores the credentials of the node.
 type NodeCredentials struct {
 	SK          SK
@@ -48,6 +57,100 @@ type Config struct {
 	BlockTime      time.Duration
 	GroupSize      int
 	GroupThreshold int
+	// the caps of the inbound and the outbound peers, 0 means
+	// unlimited. The members of the node's groups take the slots
+	// of the other peers when the slots are full.
+	MaxInbound  int
+	MaxOutbound int
+	// the directory where the node saves its peer data: the
+	// known peers in the file peers, they are connected before
+	// the seed node at start, and the bans of the peers and the
+	// networks in the file bans, they are loaded at start. Empty
+	// disables saving. The peers not seen for PeerMaxAge are
+	// dropped, 0 keeps them.
+	DataDir    string
+	PeerMaxAge time.Duration
+	// the software version sent to the peers in the handshake
+	SoftwareVersion string
+	// the number of the recently seen items remembered to drop
+	// the duplicates relayed by the peers, and how long an item
+	// is remembered. 0 uses the defaults.
+	GossipCacheSize int
+	GossipCacheTTL  time.Duration
+	// the number of the peers a block, block proposal, random
+	// beacon signature or txn is pushed to in full, the other
+	// peers are sent its announcement and pull it. 0 uses the
+	// square root of the peer count, a negative fanout only
+	// announces. The shares are always pushed to every peer.
+	GossipFanout int
+	// how the listen port is mapped on the NAT router so that the
+	// peers outside the local network can connect to the node:
+	// NATNone, NATAny, NATUPnP or NATPMP. Empty is NATNone.
+	NAT string
+	// reject the loopback and the private addresses sent by the
+	// peers in the peer exchange, it should be set on the public
+	// networks.
+	PeerExchangePublicOnly bool
+	// the window of the peer traffic rates, 0 uses the default,
+	// and the bytes sent to a peer in a window after which the
+	// historical blocks and random beacon signatures requested by
+	// the peer are deferred to the next window, 0 means
+	// unlimited.
+	BandwidthWindow    time.Duration
+	PeerOutboundBudget uint64
+	// the host:port of the DNS seeds, the addresses of a host are
+	// the seed nodes listening on the port. They are tried after
+	// the saved peers and the seed node.
+	DNSSeeds []string
+	// the time allowed for dialing a peer and for the handshake
+	// of a connection, 0 uses the default.
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+	// only dial the peers without listening for the connections,
+	// for the nodes behind a firewall. The node's address is not
+	// sent to the peers.
+	OutboundOnly bool
+	// how long a peer can be idle before it is pinged, and how
+	// long it can send nothing before it is disconnected as dead,
+	// 0 uses the defaults.
+	KeepaliveInterval time.Duration
+	ReadTimeout       time.Duration
+	// how long the item announcements and requests to a peer
+	// wait to be sent in a batch, 0 uses the default of 20ms and
+	// a negative delay sends them at once.
+	AnnounceDelay time.Duration
+	// the number of the items served from a getData, advertised
+	// to the peers so that they paginate their requests. The
+	// items over it are ignored and sent back to be requested
+	// again. 0 uses the default of 512.
+	MaxGetData int
+	// the number of the packets queued to a peer, the txns are
+	// dropped first when the queue is full and the shares are
+	// never dropped, and how long the queue can stay full before
+	// the peer is disconnected. 0 uses the defaults. The shares,
+	// and then the blocks, the proposals and the random beacon
+	// signatures are written before the other packets.
+	PeerSendQueue     int
+	SaturationTimeout time.Duration
+	// the rate limits of the messages from a peer by the names of
+	// their types, overriding the defaults. The messages over the
+	// limits are dropped, and the peer is penalized.
+	RateLimits map[string]RateLimit
+	// the host:port of the SOCKS5 proxy the peers are dialed
+	// through, empty dials them directly. The user and password
+	// authenticate with the proxy if the user is not empty. If
+	// ProxyDNS is set, the DNS seeds are resolved through the
+	// proxy too, it must support the RESOLVE extension of Tor.
+	Proxy         string
+	ProxyUser     string
+	ProxyPassword string
+	ProxyDNS      bool
+	// the directory of the state snapshots served to the peers,
+	// and of the snapshots being downloaded from them, empty
+	// disables both. The snapshots are split into the chunks of
+	// SnapshotChunkSize bytes, 0 uses the default of 1MB.
+	SnapshotDir       string
+	SnapshotChunkSize int
 }
 
 // NewNode creates a new node.
@@ -79,11 +182,166 @@ func (n *Node) Chain() *Chain {
 	return n.chain
 }
 
+// PeerCount returns the number of the connected peers.
+func (n *Node) PeerCount() int {
+	return n.gateway.net.PeerCount()
+}
+
+// Peers returns the connected peers.
+func (n *Node) Peers() []PeerInfo {
+	return n.gateway.net.Peers()
+}
+
+// AddPeer connects to the peer accepting the connections on the
+// address.
+func (n *Node) AddPeer(ctx context.Context, addr string) (PeerInfo, error) {
+	return n.gateway.net.AddPeer(ctx, addr)
+}
+
+// DisconnectPeer closes the connections to the peer, it returns
+// ErrPeerNotFound if the peer is not connected.
+func (n *Node) DisconnectPeer(id Addr) error {
+	return n.gateway.net.DisconnectPeer(id)
+}
+
+// BanPeer disconnects the peer and refuses to connect with it for the
+// duration, the ban is saved in Config.DataDir.
+func (n *Node) BanPeer(id Addr, d time.Duration) {
+	n.gateway.net.BanPeer(id, d)
+}
+
+// AddBan disconnects the banned peer or hosts, and refuses to connect
+// with them until the ban expires. The ban is saved in
+// Config.DataDir.
+func (n *Node) AddBan(b Ban) error {
+	return n.gateway.net.AddBan(b)
+}
+
+// RemoveBan removes the ban of the ID or the network, it returns
+// ErrBanNotFound if there is none.
+func (n *Node) RemoveBan(b Ban) error {
+	return n.gateway.net.RemoveBan(b)
+}
+
+// Bans returns the bans not expired.
+func (n *Node) Bans() []Ban {
+	return n.gateway.net.Bans()
+}
+
+// errNoSnapshotDir is returned when the snapshots are used but
+// Config.SnapshotDir is not set.
+var errNoSnapshotDir = errors.New("the snapshot directory is not set")
+
+// AddSnapshot saves the snapshot of the state to Config.SnapshotDir,
+// it is served to the peers.
+func (n *Node) AddSnapshot(blob TrieBlob) error {
+	if n.snapshots == nil {
+		return errNoSnapshotDir
+	}
+	return n.snapshots.Add(blob)
+}
+
+// FetchSnapshot downloads the snapshot of the state root from the
+// peers into Config.SnapshotDir. The download resumes from the
+// chunks downloaded by the previous calls.
+func (n *Node) FetchSnapshot(ctx context.Context, root Hash) (TrieBlob, error) {
+	if n.snapshots == nil {
+		return TrieBlob{}, errNoSnapshotDir
+	}
+	return n.gateway.net.FetchSnapshot(ctx, root, n.cfg.SnapshotDir)
+}
+
+// SavePeers saves the known peers in Config.DataDir, they are also
+// saved periodically.
+func (n *Node) SavePeers() error {
+	return n.gateway.net.savePeers()
+}
+
+// sharesGroup returns true if the peer is a member of a group that
+// the node is a member of.
+func (n *Node) sharesGroup(id Addr) bool {
+	for _, m := range n.memberships {
+		if _, ok := n.chain.randomBeacon.groups[m.groupID].MemberPK[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidator returns true if the ID is a node registered in the
+// finalized system state.
+func (n *Node) isValidator(id Addr) bool {
+	n.chain.mu.RLock()
+	defer n.chain.mu.RUnlock()
+
+	_, ok := n.chain.lastFinalizedSysState.addrToPK[id]
+	return ok
+}
+
+// NodeStatus is the status of the node's networking and syncing.
+type NodeStatus struct {
+	// ListenAddr is the address accepting the peer connections,
+	// empty before the node is started.
+	ListenAddr string
+	Peers      int
+	// Syncing is true while the node downloads the blocks or the
+	// random beacon signatures it missed, SyncTargetRound is the
+	// highest round being downloaded.
+	Syncing         bool
+	SyncTargetRound uint64
+	// DuplicateItems is the number of the items relayed by the
+	// peers dropped since they were seen.
+	DuplicateItems uint64
+	// PeerRTTs is the smoothed round trip time of the connected
+	// peers by their IDs, see PeerInfo.RTT.
+	PeerRTTs map[Addr]time.Duration
+	// PeerTraffic is the traffic of the connected peers by their
+	// IDs.
+	PeerTraffic map[Addr]PeerTraffic
+	// Network is the traffic aggregated over the peers.
+	Network NetworkMetrics
+}
+
+// NodeStatus returns the status of the node's networking and
+// syncing.
+func (n *Node) NodeStatus() NodeStatus {
+	syncing, target := n.gateway.syncer.Status()
+	rtts := make(map[Addr]time.Duration)
+	traffic := make(map[Addr]PeerTraffic)
+	for _, p := range n.gateway.net.Peers() {
+		rtts[p.ID] = p.RTT
+		traffic[p.ID] = p.Traffic
+	}
+
+	return NodeStatus{
+		ListenAddr:      n.gateway.net.ListenAddr(),
+		Peers:           n.gateway.net.PeerCount(),
+		Syncing:         syncing,
+		SyncTargetRound: target,
+		DuplicateItems:  n.gateway.net.gossip.Duplicates(),
+		PeerRTTs:        rtts,
+		PeerTraffic:     traffic,
+		Network:         n.gateway.net.Metrics(),
+	}
+}
+
+// NetworkMetrics returns the metrics of the network aggregated over
+// the peers.
+func (n *Node) NetworkMetrics() NetworkMetrics {
+	return n.gateway.net.Metrics()
+}
+
 // Start starts the p2p network service.
 func (n *Node) Start(host string, port int, seedAddr string) error {
 	return n.gateway.Start(host, port, seedAddr)
 }
 
+// Stop stops the p2p network service, the dials in progress are
+// canceled and the peers are disconnected.
+func (n *Node) Stop() {
+	n.gateway.net.Stop()
+}
+
 func (n *Node) proposeBlock(round uint64, group int, lastRoundEndTime time.Time) {
 	n.chain.WaitUntil(round)
 	n.mu.Lock()
@@ -95,6 +353,13 @@ func (n *Node) proposeBlock(round uint64, group int, lastRoundEndTime time.Time)
 		return
 	}
 
+	// wait for the txns for a while if the pool is empty, so that
+	// a txn arrives shortly after the round starts is included
+	// in this round rather than the next one.
+	waitCtx, cancelWait := context.WithDeadline(context.Background(), lastRoundEndTime.Add(n.cfg.BlockTime/txnWaitDivisor))
+	n.chain.waitTxns(waitCtx)
+	cancelWait()
+
 	// at most spend blockTime/3 for proposing block, to avoid
 	// delayed block time when there are too many transactions to
 	// be included in the block proposal
@@ -246,6 +511,12 @@ func (n *Node) SendTxn(t []byte) {
 	n.gateway.recvTxn(t)
 }
 
+// BroadcastTxn broadcasts the txn that is already added to the txn
+// pool.
+func (n *Node) BroadcastTxn(t []byte) {
+	n.gateway.broadcast(Item{T: txnItem, Hash: SHA3(t)})
+}
+
 // MakeNode makes a new node with the given configurations.
 func MakeNode(credentials NodeCredentials, cfg Config, genesis Genesis, state State, txnPool TxnPool, u Updater, proposerPK []byte) *Node {
 	randSeed := Rand(SHA3([]byte("dex")))
@@ -259,7 +530,46 @@ func MakeNode(credentials NodeCredentials, cfg Config, genesis Genesis, state St
 	net := newNetwork(credentials.SK)
 	gateway := newGateway(net, chain, store, cfg.GroupThreshold)
 	net.onPeerConnect = gateway.onPeerConnect
+	net.setPeerLimits(cfg.MaxInbound, cfg.MaxOutbound)
+	if cfg.DataDir != "" {
+		err = os.MkdirAll(cfg.DataDir, 0700)
+		if err != nil {
+			panic(err)
+		}
+
+		net.setPeerFile(filepath.Join(cfg.DataDir, peerFileName), cfg.PeerMaxAge)
+		net.setBanFile(filepath.Join(cfg.DataDir, banFileName))
+	}
+	net.genesis = genesis.Block.Hash()
+	net.software = cfg.SoftwareVersion
+	net.gossip = newGossipCache(cfg.GossipCacheSize, cfg.GossipCacheTTL)
+	net.setGossipFanout(cfg.GossipFanout)
+	nat, err := newNAT(cfg.NAT)
+	if err != nil {
+		panic(err)
+	}
+	net.setNAT(nat)
+	net.setPeerExchangePublicOnly(cfg.PeerExchangePublicOnly)
+	net.setBandwidth(cfg.BandwidthWindow, cfg.PeerOutboundBudget)
+	net.setDNSSeeds(cfg.DNSSeeds)
+	net.setTimeouts(cfg.DialTimeout, cfg.HandshakeTimeout)
+	net.setOutboundOnly(cfg.OutboundOnly)
+	net.setKeepalive(cfg.KeepaliveInterval, cfg.ReadTimeout)
+	net.setAnnounceDelay(cfg.AnnounceDelay)
+	net.setMaxGetData(cfg.MaxGetData)
+	net.setSendQueue(cfg.PeerSendQueue, cfg.SaturationTimeout)
+	net.setRateLimits(cfg.RateLimits)
+	net.setProxy(cfg.Proxy, cfg.ProxyUser, cfg.ProxyPassword, cfg.ProxyDNS)
 	node := NewNode(chain, credentials.SK, gateway, cfg, store)
+	if cfg.SnapshotDir != "" {
+		node.snapshots, err = NewSnapshotStore(cfg.SnapshotDir, cfg.SnapshotChunkSize)
+		if err != nil {
+			panic(err)
+		}
+		gateway.snapshots = node.snapshots
+	}
+	net.priority = node.sharesGroup
+	net.validator = node.isValidator
 	for j := range credentials.Groups {
 		share := credentials.GroupShares[j]
 		m := membership{groupID: credentials.Groups[j], skShare: share}
@@ -0,0 +1,196 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+const (
+	// maxExchangedAddrs caps the addresses sent in and accepted
+	// from a peer exchange message.
+	maxExchangedAddrs = 64
+	// maxExchangedAddrsPerWindow caps the addresses accepted from
+	// a peer in peerExchangeWindow.
+	maxExchangedAddrsPerWindow = 256
+	peerExchangeWindow         = time.Minute
+	// maxPublicNodes caps the public nodes remembered for the peer
+	// exchange.
+	maxPublicNodes = 1000
+)
+
+// exchangeWindow is the number of the addresses accepted from a peer
+// since start.
+type exchangeWindow struct {
+	start time.Time
+	count int
+}
+
+// setPeerExchangePublicOnly sets if the loopback and the private
+// addresses are rejected from the peer exchange, it should be set on
+// the public networks.
+func (n *network) setPeerExchangePublicOnly(publicOnly bool) {
+	n.mu.Lock()
+	n.publicOnly = publicOnly
+	n.mu.Unlock()
+}
+
+// checkPeerAddr returns an error if the address sent by the peer
+// from is not one the node should dial. The caller must hold n.mu.
+func (n *network) checkPeerAddr(from string, addr unicastAddr) error {
+	if addr.PKStr == "" {
+		return errors.New("missing public key")
+	}
+
+	if addr.PKStr == string(n.sk.MustPK()) || addr.Addr == n.listenAddr || addr.Addr == n.advertised {
+		return errors.New("the node's own address")
+	}
+
+	host, p, err := net.SplitHostPort(addr.Addr)
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil || port == 0 {
+		return fmt.Errorf("invalid port %q", p)
+	}
+
+	// only the IPs are accepted, a host name could resolve to
+	// anything.
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid IP %q", host)
+	}
+
+	if ip.IsUnspecified() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return fmt.Errorf("not a unicast IP %v", ip)
+	}
+
+	if n.publicOnly && !isPublicIP(ip) {
+		return fmt.Errorf("not a public IP %v", ip)
+	}
+
+	// a remote peer must not point the node at the services on
+	// the node's host.
+	if ip.IsLoopback() && !isLoopbackAddr(from) {
+		return fmt.Errorf("loopback IP %v from a remote peer", ip)
+	}
+
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast()
+}
+
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// acceptAddrs returns the valid addresses of the peer exchange
+// message from the peer, at most maxExchangedAddrs, and at most
+// maxExchangedAddrsPerWindow from the peer in peerExchangeWindow.
+// The addresses are only dialed, they are remembered for the peer
+// exchange and in the peer file once the handshake with them
+// succeeds.
+func (n *network) acceptAddrs(from unicastAddr, addrs []unicastAddr) []unicastAddr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	id := PK(from.PKStr).Addr()
+	w := n.exchanged[id]
+	if w == nil || time.Since(w.start) >= peerExchangeWindow {
+		n.pruneExchanged()
+		w = &exchangeWindow{start: time.Now()}
+		n.exchanged[id] = w
+	}
+
+	quota := maxExchangedAddrsPerWindow - w.count
+	if quota > maxExchangedAddrs {
+		quota = maxExchangedAddrs
+	}
+
+	if quota <= 0 {
+		log.Debug("dropped the peer exchange over the rate limit", "addr", from.Addr, "count", len(addrs))
+		return nil
+	}
+
+	valid := make([]unicastAddr, 0, len(addrs))
+	invalid := 0
+	for _, addr := range dedup(addrs) {
+		if err := n.checkPeerAddr(from.Addr, addr); err != nil {
+			invalid++
+			continue
+		}
+		valid = append(valid, addr)
+	}
+
+	if invalid > 0 {
+		log.Debug("dropped the invalid addresses of the peer exchange", "addr", from.Addr, "count", invalid)
+	}
+
+	// the peer decides the order, so the addresses over the
+	// quota are dropped at random.
+	if len(valid) > quota {
+		rand.Shuffle(len(valid), func(i, j int) {
+			valid[i], valid[j] = valid[j], valid[i]
+		})
+		valid = valid[:quota]
+	}
+
+	w.count += len(valid)
+	return valid
+}
+
+// pruneExchanged drops the expired rate limit windows, the caller
+// must hold n.mu.
+func (n *network) pruneExchanged() {
+	for id, w := range n.exchanged {
+		if time.Since(w.start) >= peerExchangeWindow {
+			delete(n.exchanged, id)
+		}
+	}
+}
+
+// addPublicNode remembers the node confirmed by a handshake for the
+// peer exchange, the caller must hold n.mu.
+func (n *network) addPublicNode(addr unicastAddr) {
+	for i, node := range n.publicNodes {
+		if node.PKStr == addr.PKStr {
+			n.publicNodes[i] = addr
+			return
+		}
+	}
+
+	if len(n.publicNodes) >= maxPublicNodes {
+		n.publicNodes = n.publicNodes[1:]
+	}
+	n.publicNodes = append(n.publicNodes, addr)
+}
+
+// exchangedNodes returns the public nodes sent in a peer exchange
+// message, at most maxExchangedAddrs of them chosen at random. The
+// caller must hold n.mu.
+func (n *network) exchangedNodes() []unicastAddr {
+	nodes := dedup(n.publicNodes)
+	if len(nodes) <= maxExchangedAddrs {
+		return nodes
+	}
+
+	rand.Shuffle(len(nodes), func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	return nodes[:maxExchangedAddrs]
+}
@@ -0,0 +1,262 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+const (
+	// peerFileName is the name of the peer file in
+	// Config.DataDir.
+	peerFileName = "peers"
+	// savePeersInterval is how often the known peers are saved
+	// to the peer file.
+	savePeersInterval = time.Minute
+	// maxSavedPeers caps the peers saved in the peer file.
+	maxSavedPeers = 1000
+)
+
+// savedPeer is a known peer saved in the peer file.
+type savedPeer struct {
+	Addr     string
+	PK       PK
+	LastSeen time.Time
+	Score    int
+	// the reason the peer gave when it last closed the
+	// connection, empty if unknown.
+	LastDisconnect string
+}
+
+// setPeerFile sets the file where the known peers are saved, empty
+// disables saving. The peers not seen for maxAge are dropped, 0
+// keeps them.
+func (n *network) setPeerFile(path string, maxAge time.Duration) {
+	n.mu.Lock()
+	n.peerFile = path
+	n.peerMaxAge = maxAge
+	n.mu.Unlock()
+}
+
+// stale returns true if the peer last seen at t is too old to be
+// kept, the caller must hold n.mu.
+func (n *network) stale(t time.Time) bool {
+	return n.peerMaxAge > 0 && time.Since(t) > n.peerMaxAge
+}
+
+// sortSavedPeers sorts the peers by the score, and then by the last
+// seen time, the best first.
+func sortSavedPeers(peers []savedPeer) {
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].Score != peers[j].Score {
+			return peers[i].Score > peers[j].Score
+		}
+		return peers[i].LastSeen.After(peers[j].LastSeen)
+	})
+}
+
+// savePeers saves the known peers to the peer file, with the
+// connected peers seen now.
+func (n *network) savePeers() error {
+	n.mu.Lock()
+	path := n.peerFile
+	now := time.Now()
+	peers := make([]savedPeer, 0, len(n.seen))
+	for addr, t := range n.seen {
+		if _, ok := n.conns[addr]; ok {
+			t = now
+		}
+
+		if n.stale(t) {
+			delete(n.seen, addr)
+			continue
+		}
+
+		pk := PK(addr.PKStr)
+		peers = append(peers, savedPeer{Addr: addr.Addr, PK: pk, LastSeen: t, Score: n.reputation[pk.Addr()], LastDisconnect: n.disconnects[addr]})
+	}
+	n.mu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+
+	sortSavedPeers(peers)
+	if len(peers) > maxSavedPeers {
+		peers = peers[:maxSavedPeers]
+	}
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(peers)
+	if err != nil {
+		return err
+	}
+
+	// replace the file at once, so that a crash does not leave a
+	// partially written file.
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (n *network) savePeersLoop() {
+	for range time.Tick(savePeersInterval) {
+		err := n.savePeers()
+		if err != nil {
+			log.Warn("error saving the known peers", "err", err)
+		}
+	}
+}
+
+// loadPeers loads the known peers from the peer file, the stale
+// peers are dropped. The peers are returned in the order of
+// sortSavedPeers, and their scores are restored.
+func (n *network) loadPeers() ([]savedPeer, error) {
+	n.mu.Lock()
+	path := n.peerFile
+	n.mu.Unlock()
+
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved []savedPeer
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&saved)
+	if err != nil {
+		return nil, err
+	}
+
+	myPK := n.sk.MustPK()
+	peers := saved[:0]
+	n.mu.Lock()
+	for _, p := range saved {
+		if n.stale(p.LastSeen) || bytes.Equal(p.PK, myPK) {
+			continue
+		}
+
+		addr := unicastAddr{Addr: p.Addr, PKStr: string(p.PK)}
+		if t, ok := n.seen[addr]; !ok || t.Before(p.LastSeen) {
+			n.seen[addr] = p.LastSeen
+		}
+		n.reputation[p.PK.Addr()] = p.Score
+		if _, ok := n.disconnects[addr]; !ok && p.LastDisconnect != "" {
+			n.disconnects[addr] = p.LastDisconnect
+		}
+		peers = append(peers, p)
+	}
+	n.mu.Unlock()
+
+	sortSavedPeers(peers)
+	return peers, nil
+}
+
+// connectSaved connects to the saved peers in the order of
+// sortSavedPeers, a batch at a time, until a peer of a batch is
+// connected. It returns the number of the connected peers and the
+// saved peers.
+func (n *network) connectSaved() (int, int) {
+	peers, err := n.loadPeers()
+	if err != nil {
+		log.Warn("error loading the known peers", "err", err)
+		return 0, 0
+	}
+
+	saved := len(peers)
+
+	batch := intialConn
+	n.mu.Lock()
+	if n.maxOutbound > 0 && n.maxOutbound < batch {
+		batch = n.maxOutbound
+	}
+	n.mu.Unlock()
+
+	var connected int32
+	for len(peers) > 0 && connected == 0 {
+		size := batch
+		if size > len(peers) {
+			size = len(peers)
+		}
+
+		var wg sync.WaitGroup
+		for _, p := range peers[:size] {
+			wg.Add(1)
+			go func(p savedPeer) {
+				defer wg.Done()
+				err := n.connect(unicastAddr{Addr: p.Addr, PKStr: string(p.PK)}, p.PK)
+				if err != nil {
+					log.Debug("error connecting to the known peer", "addr", p.Addr, "err", err)
+					return
+				}
+				atomic.AddInt32(&connected, 1)
+			}(p)
+		}
+		wg.Wait()
+		peers = peers[size:]
+	}
+
+	log.Info("connected to the known peers", "count", connected)
+	return int(connected), saved
+}
+
+// bootstrap connects to the known peers saved in the peer file, and
+// falls back to the seed node, and then to the DNS seeds, if none of
+// them can be connected. The DNS seeds only fail the bootstrap when
+// there is neither a seed node nor a saved peer, they are connected
+// again later while the node has few peers.
+func (n *network) bootstrap(seedAddr string) error {
+	n.mu.Lock()
+	dnsSeeds := len(n.dnsSeeds) > 0
+	n.mu.Unlock()
+	if dnsSeeds {
+		go n.dnsSeedLoop()
+	}
+
+	connected, saved := n.connectSaved()
+	if connected > 0 {
+		return nil
+	}
+
+	var err error
+	if seedAddr != "" {
+		err = n.ConnectSeed(seedAddr)
+		if err == nil {
+			return nil
+		}
+	}
+
+	if !dnsSeeds {
+		return err
+	}
+
+	dnsErr := n.connectDNSSeeds()
+	if dnsErr == nil {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if saved > 0 {
+		log.Warn("error connecting to the DNS seeds, waiting for the saved peers", "err", dnsErr)
+		return nil
+	}
+	return dnsErr
+}
@@ -0,0 +1,125 @@
+package consensus
+
+import (
+	"errors"
+
+	log "github.com/helinwang/log15"
+)
+
+// tooManyPeers is the reason sent to a peer rejected since the peer
+// slots are full.
+const tooManyPeers = "too many peers"
+
+// errTooManyPeers is returned when connecting to a peer while the
+// outbound peer slots are full.
+var errTooManyPeers = errors.New(tooManyPeers)
+
+// peerRejection is sent to the peer before its connection is closed,
+// when the connection is refused after the handshake or evicted.
+type peerRejection struct {
+	Reason string
+}
+
+// setPeerLimits caps the inbound and the outbound peers, 0 means
+// unlimited.
+func (n *network) setPeerLimits(maxInbound, maxOutbound int) {
+	n.mu.Lock()
+	n.maxInbound = maxInbound
+	n.maxOutbound = maxOutbound
+	n.mu.Unlock()
+}
+
+// peerCount returns the number of the connected and the connecting
+// peers of the direction, the caller must hold n.mu.
+func (n *network) peerCount(inbound bool) int {
+	count := n.connecting[inbound]
+	for _, c := range n.conns {
+		if c.inbound == inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// makeRoom returns true if there is a slot for the peer of the
+// direction. When the slots are full and the peer has the priority,
+// the lowest-scoring peer without the priority is evicted to make
+// room for it. An observer never has the priority. The caller must
+// hold n.mu.
+func (n *network) makeRoom(id Addr, observer, inbound bool) bool {
+	max := n.maxOutbound
+	if inbound {
+		max = n.maxInbound
+	}
+
+	if max <= 0 || n.peerCount(inbound) < max {
+		return true
+	}
+
+	if observer || n.priority == nil || !n.priority(id) {
+		return false
+	}
+
+	var victim unicastAddr
+	var victimConn *conn
+	for addr, c := range n.conns {
+		if c.inbound != inbound {
+			continue
+		}
+
+		vid := PK(addr.PKStr).Addr()
+		if !c.observer && n.priority(vid) {
+			continue
+		}
+
+		// the lowest reputation first, then the longest round
+		// trip time, and then the newest connection.
+		if victimConn != nil {
+			r, vr := n.reputation[vid], n.reputation[PK(victim.PKStr).Addr()]
+			rtt, vrtt := c.RTT(), victimConn.RTT()
+			switch {
+			case r != vr:
+				if r > vr {
+					continue
+				}
+			case rtt != vrtt:
+				if fasterRTT(rtt, vrtt) {
+					continue
+				}
+			case !c.connectedAt.After(victimConn.connectedAt):
+				continue
+			}
+		}
+
+		victim = addr
+		victimConn = c
+	}
+
+	if victimConn == nil {
+		return false
+	}
+
+	log.Info("evicting peer for a priority peer", "evicted", victim.Addr, "priority peer", id)
+	delete(n.conns, victim)
+	delete(n.known, victim)
+	go victimConn.closeGracefully(disconnectEvicted, flushTimeout)
+	return true
+}
+
+// reserve reserves a slot for the peer being connected, it returns
+// false if there is no slot. The caller must hold n.mu, and must
+// release the slot once the peer is registered or failed to connect.
+func (n *network) reserve(id Addr, observer, inbound bool) bool {
+	if !n.makeRoom(id, observer, inbound) {
+		return false
+	}
+
+	n.connecting[inbound]++
+	return true
+}
+
+// release releases a slot reserved by reserve, the caller must hold
+// n.mu.
+func (n *network) release(inbound bool) {
+	n.connecting[inbound]--
+}
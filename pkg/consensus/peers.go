@@ -0,0 +1,257 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// ErrPeerNotFound is returned when the peer is not connected.
+var ErrPeerNotFound = errors.New("peer not found")
+
+// PeerInfo is the information of a connected peer.
+type PeerInfo struct {
+	// ID is the address of the peer's public key.
+	ID Addr
+	// Addr is the address accepting the peer's connections.
+	Addr string
+	// Inbound is true if the peer connected to the node, false if
+	// the node connected to the peer.
+	Inbound     bool
+	ConnectedAt time.Time
+	// RTT is the round trip time of the pings smoothed by an
+	// exponentially weighted moving average, 0 before the first
+	// pong is received.
+	RTT      time.Duration
+	BytesIn  uint64
+	BytesOut uint64
+	// Reputation starts from 0, and is decreased for every invalid
+	// item received from the peer. It is kept when the peer
+	// reconnects.
+	Reputation int
+	// Software is the software version sent by the peer in the
+	// handshake.
+	Software string
+	// Observer is true if the peer did not prove the key of its
+	// ID in the handshake, or the ID is not a validator's. The
+	// observers are served, but never have the priority for the
+	// peer slots, and their hosts are banned with them.
+	Observer bool
+	Traffic  PeerTraffic
+}
+
+// peerInfo returns the information of the peer, the caller must hold
+// n.mu.
+func (n *network) peerInfo(addr unicastAddr, c *conn) PeerInfo {
+	id := PK(addr.PKStr).Addr()
+	traffic := c.traffic.snapshot()
+	if c.sendq != nil {
+		traffic.Queued = c.sendq.depths()
+	}
+
+	return PeerInfo{
+		ID:          id,
+		Addr:        addr.Addr,
+		Inbound:     c.inbound,
+		ConnectedAt: c.connectedAt,
+		RTT:         c.RTT(),
+		BytesIn:     atomic.LoadUint64(&c.bytesIn),
+		BytesOut:    atomic.LoadUint64(&c.bytesOut),
+		Reputation:  n.reputation[id],
+		Software:    c.software,
+		Observer:    c.observer,
+		Traffic:     traffic,
+	}
+}
+
+// Peers returns the connected peers sorted by their IDs.
+func (n *network) Peers() []PeerInfo {
+	n.mu.Lock()
+	peers := make([]PeerInfo, 0, len(n.conns))
+	for addr, c := range n.conns {
+		peers = append(peers, n.peerInfo(addr, c))
+	}
+	n.mu.Unlock()
+
+	sort.Slice(peers, func(i, j int) bool {
+		return bytes.Compare(peers[i].ID[:], peers[j].ID[:]) < 0
+	})
+	return peers
+}
+
+// AddPeer connects to the peer accepting the connections on the
+// address, it returns after the handshake is done.
+func (n *network) AddPeer(ctx context.Context, addr string) (PeerInfo, error) {
+	n.mu.Lock()
+	for peer, c := range n.conns {
+		if peer.Addr == addr {
+			info := n.peerInfo(peer, c)
+			n.mu.Unlock()
+			return info, nil
+		}
+	}
+	n.mu.Unlock()
+
+	if !n.startDial(addr) {
+		return PeerInfo{}, errDialing
+	}
+	defer n.endDial(addr)
+
+	c, err := n.dial(ctx, addr)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+
+	conn := n.wrapConn(c)
+	h, err := n.sendHello(conn)
+	if err != nil {
+		n.handshakeFailed()
+		conn.Close()
+		return PeerInfo{}, fmt.Errorf("handshake with peer %s err: %v", addr, err)
+	}
+
+	conn.software = h.Software
+	req := &connectRequest{Port: n.port}
+	req.sign(n.sk, conn.transcript)
+	err = conn.Write(packet{Data: req})
+	if err != nil {
+		conn.Close()
+		return PeerInfo{}, err
+	}
+
+	// the peer closes the connection without replying if the
+	// node is banned by it.
+	pk, _, err := readHandshake(ctx, conn)
+	if err != nil {
+		n.handshakeFailed()
+		conn.Close()
+		return PeerInfo{}, fmt.Errorf("handshake with peer %s err: %v", addr, err)
+	}
+
+	if bytes.Equal(pk, req.PK) {
+		conn.Close()
+		return PeerInfo{}, errors.New("can not add the node itself as a peer")
+	}
+
+	peer := unicastAddr{Addr: addr, PKStr: string(pk)}
+	n.setIdentity(conn, pk, hostOf(addr))
+	n.mu.Lock()
+	if n.isBannedPeer(conn) {
+		n.mu.Unlock()
+		conn.Close()
+		return PeerInfo{}, fmt.Errorf("peer %v is banned", pk.Addr())
+	}
+
+	if existing, ok := n.conns[peer]; ok {
+		info := n.peerInfo(peer, existing)
+		n.mu.Unlock()
+		conn.Close()
+		return info, nil
+	}
+
+	if !n.makeRoom(pk.Addr(), conn.observer, false) {
+		n.mu.Unlock()
+		conn.Close()
+		return PeerInfo{}, errTooManyPeers
+	}
+
+	n.addConn(peer, conn, false)
+	info := n.peerInfo(peer, conn)
+	n.mu.Unlock()
+
+	if n.onPeerConnect != nil {
+		go n.onPeerConnect(peer)
+	}
+	return info, nil
+}
+
+// DisconnectPeer closes the connections to the peer gracefully, and
+// stops redialing it. The peer can reconnect.
+func (n *network) DisconnectPeer(id Addr) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropPeer(id, true) == 0 {
+		return ErrPeerNotFound
+	}
+	return nil
+}
+
+// BanPeer closes the connections to the peer, and refuses to connect
+// with the peer for the duration. The peer does not need to be
+// connected.
+func (n *network) BanPeer(id Addr, d time.Duration) {
+	n.banPeer(id, d, "banned by the operator")
+}
+
+// banPeer bans the peer for the duration, the ban is saved to the ban
+// file.
+func (n *network) banPeer(id Addr, d time.Duration, reason string) {
+	err := n.AddBan(Ban{ID: id, Until: time.Now().Add(d), Reason: reason})
+	if err != nil {
+		log.Warn("error saving the ban", "id", id, "err", err)
+	}
+}
+
+// dropPeer closes the connections to the peer and returns the number
+// of them, the peer is not redialed or saved. The queued packets are
+// flushed if graceful is true. The caller must hold n.mu.
+func (n *network) dropPeer(id Addr, graceful bool) int {
+	for addr := range n.known {
+		if PK(addr.PKStr).Addr() == id {
+			delete(n.known, addr)
+		}
+	}
+
+	for addr := range n.seen {
+		if PK(addr.PKStr).Addr() == id {
+			delete(n.seen, addr)
+			delete(n.disconnects, addr)
+		}
+	}
+
+	dropped := 0
+	for addr, c := range n.conns {
+		if PK(addr.PKStr).Addr() != id {
+			continue
+		}
+
+		delete(n.conns, addr)
+		if graceful {
+			go c.closeGracefully(disconnectRequested, flushTimeout)
+		} else {
+			c.Close()
+		}
+		dropped++
+	}
+	return dropped
+}
+
+// isBanned returns true if the peer is banned, the caller must hold
+// n.mu.
+func (n *network) isBanned(id Addr) bool {
+	b, ok := n.banned[id]
+	if !ok {
+		return false
+	}
+
+	if b.expired(time.Now()) {
+		delete(n.banned, id)
+		return false
+	}
+	return true
+}
+
+// penalize decreases the reputation of the peer that sent an invalid
+// item.
+func (n *network) penalize(addr unicastAddr) {
+	n.mu.Lock()
+	n.reputation[PK(addr.PKStr).Addr()]--
+	n.mu.Unlock()
+}
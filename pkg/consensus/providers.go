@@ -0,0 +1,121 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// raceProviders is the number of the providers requested at the same
+// time by requestAny.
+const raceProviders = 2
+
+// errInvalidResponse is returned when the peer replied an item that
+// is not the requested one.
+var errInvalidResponse = errors.New("the peer replied an invalid item")
+
+// ProvidersOf returns the connected peers that sent or announced the
+// block, the block proposal or the txn of the hash, the best first:
+// the higher reputation first, and then the shorter round trip time.
+func (n *network) ProvidersOf(hash Hash) []unicastAddr {
+	var providers []unicastAddr
+	for _, t := range []itemType{blockItem, blockProposalItem, txnItem} {
+		providers = append(providers, n.gossip.peers(Item{T: t, Hash: hash})...)
+	}
+
+	return n.rankPeers(providers)
+}
+
+// rankPeers returns the connected peers of the addresses without the
+// duplicates, the higher reputation first, and then the shorter
+// round trip time.
+func (n *network) rankPeers(addrs []unicastAddr) []unicastAddr {
+	type ranked struct {
+		addr       unicastAddr
+		reputation int
+		rtt        time.Duration
+	}
+
+	n.mu.Lock()
+	peers := make([]ranked, 0, len(addrs))
+	seen := make(map[unicastAddr]bool)
+	for _, addr := range addrs {
+		c, ok := n.conns[addr]
+		if !ok || seen[addr] {
+			continue
+		}
+
+		seen[addr] = true
+		peers = append(peers, ranked{
+			addr:       addr,
+			reputation: n.reputation[PK(addr.PKStr).Addr()],
+			rtt:        c.RTT(),
+		})
+	}
+	n.mu.Unlock()
+
+	sort.SliceStable(peers, func(i, j int) bool {
+		if peers[i].reputation != peers[j].reputation {
+			return peers[i].reputation > peers[j].reputation
+		}
+		return fasterRTT(peers[i].rtt, peers[j].rtt)
+	})
+
+	r := make([]unicastAddr, len(peers))
+	for i := range peers {
+		r[i] = peers[i].addr
+	}
+	return r
+}
+
+// requestAny requests the item from the best raceProviders of addr
+// and the providers of the item at the same time, the first valid
+// response wins and the other requests are cancelled. The peers
+// replying invalid items are penalized.
+func (n *network) requestAny(ctx context.Context, addr unicastAddr, item Item, valid func(data interface{}) bool) (interface{}, error) {
+	providers := n.rankPeers(append(n.ProvidersOf(item.Hash), addr))
+	if len(providers) == 0 {
+		return nil, errors.New("no connected provider of the item")
+	}
+
+	if len(providers) > raceProviders {
+		providers = providers[:raceProviders]
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		data interface{}
+		err  error
+	}
+
+	ch := make(chan result, len(providers))
+	for _, p := range providers {
+		go func(p unicastAddr) {
+			data, err := n.Request(ctx, p, item)
+			if err == nil && !valid(data) {
+				n.penalize(p)
+				err = errInvalidResponse
+			}
+
+			if err != nil && ctx.Err() == nil {
+				log.Debug("request failed", "item", item, "addr", p.Addr, "err", err)
+			}
+			ch <- result{data: data, err: err}
+		}(p)
+	}
+
+	var err error
+	for range providers {
+		r := <-ch
+		if r.err == nil {
+			return r.data, nil
+		}
+		err = r.err
+	}
+	return nil, err
+}
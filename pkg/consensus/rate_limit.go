@@ -0,0 +1,186 @@
+package consensus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// rateViolationPenalty is the number of the messages from a peer
+// dropped over the rate limits for which the peer is penalized once.
+const rateViolationPenalty = 50
+
+// RateLimit limits the messages of a type from a peer by a token
+// bucket, refilled by Rate tokens per second up to Burst tokens. A
+// message takes a token, a batch takes a token per item. The Rate of
+// 0 is unlimited.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// defaultRateLimits are the default rate limits by the names of the
+// message types, see msgTypeNames. The other types are unlimited.
+var defaultRateLimits = map[string]RateLimit{
+	// the requests of the historical blocks and random beacon
+	// signatures are served from the disk, they are limited the
+	// tightest. The requests of the gossiped items follow their
+	// announcements.
+	"request":      {Rate: 20, Burst: 50},
+	"get_data":     {Rate: 2000, Burst: 4000},
+	"item_request": {Rate: 2000, Burst: 4000},
+	// the items of the getData ignored over the cap are
+	// requested again, see truncatedGetData.
+	"truncated_get_data": {Rate: 2000, Burst: 4000},
+	// the gossip
+	"txn":       {Rate: 2000, Burst: 4000},
+	"item":      {Rate: 4000, Burst: 8000},
+	"inventory": {Rate: 4000, Burst: 8000},
+	// the consensus messages are limited loosely, not to delay
+	// the rounds.
+	"block":                 {Rate: 100, Burst: 1000},
+	"block_proposal":        {Rate: 100, Burst: 1000},
+	"rand_beacon_sig":       {Rate: 100, Burst: 1000},
+	"nt_share":              {Rate: 1000, Burst: 10000},
+	"rand_beacon_sig_share": {Rate: 1000, Burst: 10000},
+}
+
+// ParseRateLimits parses the comma separated rate limits of the form
+// name=rate:burst, such as get_data=100:512, see msgTypeNames for
+// the names.
+func ParseRateLimits(s string) (map[string]RateLimit, error) {
+	names := make(map[string]bool, len(msgTypeNames))
+	for _, name := range msgTypeNames {
+		names[name] = true
+	}
+
+	limits := make(map[string]RateLimit)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		eq := strings.Index(item, "=")
+		colon := strings.LastIndex(item, ":")
+		if eq < 0 || colon < eq {
+			return nil, fmt.Errorf("invalid rate limit %q, want name=rate:burst", item)
+		}
+
+		name := item[:eq]
+		if !names[name] {
+			return nil, fmt.Errorf("unknown message type %q", name)
+		}
+
+		rate, err := strconv.ParseFloat(item[eq+1:colon], 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid rate of %q", item)
+		}
+
+		burst, err := strconv.Atoi(item[colon+1:])
+		if err != nil || burst < 0 {
+			return nil, fmt.Errorf("invalid burst of %q", item)
+		}
+
+		limits[name] = RateLimit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
+
+// setRateLimits overrides the default rate limits of the message
+// types, it applies to the peers connected afterwards.
+func (n *network) setRateLimits(limits map[string]RateLimit) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	merged := make(map[string]RateLimit, len(defaultRateLimits)+len(limits))
+	for name, l := range defaultRateLimits {
+		merged[name] = l
+	}
+
+	for name, l := range limits {
+		merged[name] = l
+	}
+	n.rateLimits = merged
+}
+
+// tokenBucket is the token bucket of a RateLimit.
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(cost int, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if max := float64(b.limit.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+
+	if b.tokens < float64(cost) {
+		return false
+	}
+
+	b.tokens -= float64(cost)
+	return true
+}
+
+// rateLimiter limits the messages from a peer by their types, it is
+// only used by the reader of the connection.
+type rateLimiter struct {
+	limits  map[string]RateLimit
+	buckets map[string]*tokenBucket
+	// the messages dropped over the limits
+	violations int
+}
+
+func newRateLimiter(limits map[string]RateLimit) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow returns true if the message is within the limit of its type.
+func (l *rateLimiter) allow(data interface{}) bool {
+	if l == nil {
+		return true
+	}
+
+	name := msgName(data)
+	limit, ok := l.limits[name]
+	if !ok || limit.Rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	b, ok := l.buckets[name]
+	if !ok {
+		b = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[name] = b
+	}
+
+	cost := 1
+	switch v := data.(type) {
+	case inventory:
+		cost = len(v)
+	case getData:
+		cost = len(v)
+	case truncatedGetData:
+		cost = len(v)
+	}
+	return b.take(cost, now)
+}
+
+// throttle drops the message from the peer over the rate limit, the
+// peer is penalized for every rateViolationPenalty dropped messages.
+func (n *network) throttle(addr unicastAddr, c *conn, data interface{}) {
+	atomic.AddUint64(&c.traffic.throttled, 1)
+	c.limiter.violations++
+	if c.limiter.violations%rateViolationPenalty == 0 {
+		log.Info("penalizing the peer over the rate limit", "addr", addr.Addr, "type", msgName(data), "dropped", c.limiter.violations)
+		n.penalize(addr)
+	}
+}
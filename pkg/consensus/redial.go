@@ -0,0 +1,131 @@
+package consensus
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// errDialing is returned when the address is being dialed by
+// another attempt.
+var errDialing = errors.New("the address is being dialed")
+
+// redialBackoff is the backoff of redialing the dropped outbound
+// peers.
+type redialBackoff struct {
+	// the delay before the first attempt, it doubles after every
+	// attempt up to max.
+	min time.Duration
+	max time.Duration
+	// the backoff is reset when the dropped connection lasted for
+	// at least minSession.
+	minSession time.Duration
+}
+
+var defaultRedialBackoff = redialBackoff{
+	min:        time.Second,
+	max:        5 * time.Minute,
+	minSession: time.Minute,
+}
+
+// redialState is the redial state of a known outbound peer.
+type redialState struct {
+	// the delay before the next attempt without the jitter
+	backoff   time.Duration
+	redialing bool
+}
+
+// nextDelay returns the delay before the next attempt, it is a
+// random duration in [backoff/2, backoff) so that the peers dropped
+// at the same time do not redial at the same time. The backoff is
+// doubled.
+func (r *redialState) nextDelay(b redialBackoff) time.Duration {
+	d := r.backoff/2 + time.Duration(rand.Int63n(int64(r.backoff/2)+1))
+	r.backoff *= 2
+	if r.backoff > b.max {
+		r.backoff = b.max
+	}
+	return d
+}
+
+// startDial marks the address as being dialed, it returns false if
+// it is being dialed already.
+func (n *network) startDial(addr string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dialing[addr] {
+		return false
+	}
+
+	n.dialing[addr] = true
+	return true
+}
+
+func (n *network) endDial(addr string) {
+	n.mu.Lock()
+	delete(n.dialing, addr)
+	n.mu.Unlock()
+}
+
+// addKnown remembers the outbound peer to redial it when its
+// connection drops, the caller must hold n.mu.
+func (n *network) addKnown(addr unicastAddr) {
+	if _, ok := n.known[addr]; !ok {
+		n.known[addr] = &redialState{backoff: n.backoff.min}
+	}
+}
+
+// scheduleRedial starts redialing the known outbound peer whose
+// connection dropped, the caller must hold n.mu.
+func (n *network) scheduleRedial(addr unicastAddr, c *conn) {
+	r, ok := n.known[addr]
+	if !ok || r.redialing {
+		return
+	}
+
+	if time.Since(c.connectedAt) >= n.backoff.minSession {
+		r.backoff = n.backoff.min
+	}
+
+	r.redialing = true
+	go n.redial(addr)
+}
+
+// redial connects to the peer until it is connected, or it is
+// banned or removed.
+func (n *network) redial(addr unicastAddr) {
+	pk := PK(addr.PKStr)
+	for {
+		n.mu.Lock()
+		r, ok := n.known[addr]
+		if !ok {
+			n.mu.Unlock()
+			return
+		}
+
+		if _, connected := n.conns[addr]; connected || n.isBanned(pk.Addr()) || n.ctx.Err() != nil {
+			r.redialing = false
+			n.mu.Unlock()
+			return
+		}
+
+		d := r.nextDelay(n.backoff)
+		n.mu.Unlock()
+
+		time.Sleep(d)
+		n.mu.Lock()
+		_, ok = n.known[addr]
+		n.mu.Unlock()
+		if !ok {
+			return
+		}
+
+		err := n.connect(addr, pk)
+		if err != nil {
+			log.Debug("redial peer failed", "addr", addr.Addr, "err", err)
+		}
+	}
+}
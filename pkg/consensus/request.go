@@ -0,0 +1,181 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+)
+
+// requestProtocolVersion is the protocol version from which the
+// requests carry the IDs correlating them with the responses. The
+// requests to the peers speaking the older versions are sent as
+// itemRequests, and answered by the requested items.
+const requestProtocolVersion = 3
+
+// errConnClosed is returned when the connection to the peer is
+// closed before the response is received.
+var errConnClosed = errors.New("the connection to the peer is closed")
+
+// errItemNotFound is returned when the peer does not have the
+// requested item.
+var errItemNotFound = errors.New("the peer does not have the requested item")
+
+// request is a request of an item, the peer replies with a response
+// of the same ID.
+type request struct {
+	ID   uint64
+	Item Item
+}
+
+// response is the reply of a request. The item is encoded as the
+// packet data of Type, Type is 0 if the peer does not have the
+// item.
+type response struct {
+	ID      uint64
+	Type    msgType
+	Payload []byte
+}
+
+// newResponse creates the response of the request, data is nil if
+// the item is not found.
+func newResponse(id uint64, data interface{}) (*response, error) {
+	r := &response{ID: id}
+	if data == nil {
+		return r, nil
+	}
+
+	t, b, err := encodeWire(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Type = t
+	r.Payload = b
+	return r, nil
+}
+
+// Request requests the item from the peer and waits for the
+// response. Many requests can be outstanding at the same time, they
+// complete in any order, and a request that is not answered before
+// ctx is done is dropped without affecting the others. The requests
+// fail at once when the connection is closed.
+func (p *conn) Request(ctx context.Context, item Item) (interface{}, error) {
+	if p.version < requestProtocolVersion {
+		return p.requestLegacy(ctx, item)
+	}
+
+	ch := make(chan *response, 1)
+	p.reqMu.Lock()
+	p.nextID++
+	id := p.nextID
+	p.pending[id] = ch
+	p.reqMu.Unlock()
+
+	defer func() {
+		p.reqMu.Lock()
+		delete(p.pending, id)
+		p.reqMu.Unlock()
+	}()
+
+	err := p.Write(packet{Data: &request{ID: id, Item: item}})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		if r.Type == 0 {
+			return nil, errItemNotFound
+		}
+
+		return decodeWire(r.Type, r.Payload)
+	case <-p.closed:
+		return nil, errConnClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// respond delivers the response to the request waiting for it, it
+// returns false if the request is gone, e.g., timed out.
+func (p *conn) respond(r *response) bool {
+	p.reqMu.Lock()
+	ch, ok := p.pending[r.ID]
+	delete(p.pending, r.ID)
+	p.reqMu.Unlock()
+
+	if ok {
+		ch <- r
+	}
+	return ok
+}
+
+func (p *conn) requestLegacy(ctx context.Context, item Item) (interface{}, error) {
+	ch := make(chan interface{}, 1)
+	p.reqMu.Lock()
+	p.legacy[item] = append(p.legacy[item], ch)
+	p.reqMu.Unlock()
+
+	defer func() {
+		p.reqMu.Lock()
+		chs := p.legacy[item]
+		for i, c := range chs {
+			if c == ch {
+				chs = append(chs[:i], chs[i+1:]...)
+				break
+			}
+		}
+
+		if len(chs) == 0 {
+			delete(p.legacy, item)
+		} else {
+			p.legacy[item] = chs
+		}
+		p.reqMu.Unlock()
+	}()
+
+	err := p.Write(packet{Data: itemRequest(item)})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case v := <-ch:
+		return v, nil
+	case <-p.closed:
+		return nil, errConnClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// respondLegacy delivers the item to the requests of the older
+// protocol versions waiting for it, it returns false if there is
+// none.
+func (p *conn) respondLegacy(item Item, data interface{}) bool {
+	p.reqMu.Lock()
+	chs := p.legacy[item]
+	delete(p.legacy, item)
+	p.reqMu.Unlock()
+
+	for _, ch := range chs {
+		ch <- data
+	}
+	return len(chs) > 0
+}
+
+// Request requests the item from the peer, see conn.Request. The
+// peer is penalized if the request times out.
+func (n *network) Request(ctx context.Context, addr unicastAddr, item Item) (interface{}, error) {
+	n.mu.Lock()
+	c, ok := n.conns[addr]
+	n.mu.Unlock()
+	if !ok {
+		return nil, errors.New("can not find the request address")
+	}
+
+	v, err := c.Request(ctx, item)
+	if err == context.DeadlineExceeded {
+		n.penalize(addr)
+	}
+	return v, err
+}
@@ -0,0 +1,160 @@
+package consensus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newRequestConns returns the connections of a requester and a peer,
+// the requester's responses are delivered by a read loop as
+// network.readConn does.
+func newRequestConns(t *testing.T, version uint32) (*conn, *conn) {
+	a, b := net.Pipe()
+	requester, peer := newConn(a), newConn(b)
+	requester.version = version
+	peer.version = version
+	go func() {
+		for {
+			pac, err := requester.Read()
+			if err != nil {
+				return
+			}
+
+			switch v := pac.Data.(type) {
+			case *response:
+				requester.respond(v)
+			default:
+				item, ok := itemOf(v)
+				assert.True(t, ok)
+				requester.respondLegacy(item, v)
+			}
+		}
+	}()
+	return requester, peer
+}
+
+func TestConnRequest(t *testing.T) {
+	requester, peer := newRequestConns(t, requestProtocolVersion)
+	defer requester.Close()
+	defer peer.Close()
+
+	blocks := make([]*Block, 4)
+	items := make([]Item, len(blocks))
+	served := make(map[Item]*Block)
+	for i := range blocks {
+		blocks[i] = &Block{Round: uint64(i)}
+		items[i] = Item{T: blockItem, Hash: blocks[i].Hash()}
+		if i < len(blocks)-1 {
+			served[items[i]] = blocks[i]
+		}
+	}
+
+	// the peer replies the requests in the reversed order, and
+	// drops the request of the last block.
+	go func() {
+		var reqs []*request
+		for len(reqs) < len(blocks) {
+			pac, err := peer.Read()
+			if err != nil {
+				return
+			}
+			reqs = append(reqs, pac.Data.(*request))
+		}
+
+		for i := len(reqs) - 1; i >= 0; i-- {
+			b, ok := served[reqs[i].Item]
+			if !ok {
+				continue
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			resp, err := newResponse(reqs[i].ID, b)
+			assert.Nil(t, err)
+			assert.Nil(t, peer.Write(packet{Data: resp}))
+		}
+
+		// the item that the peer does not have
+		pac, err := peer.Read()
+		if err != nil {
+			return
+		}
+		resp, err := newResponse(pac.Data.(*request).ID, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, peer.Write(packet{Data: resp}))
+	}()
+
+	type result struct {
+		i    int
+		data interface{}
+		err  error
+	}
+	ch := make(chan result, len(blocks))
+	for i := range blocks {
+		go func(i int) {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			data, err := requester.Request(ctx, items[i])
+			ch <- result{i: i, data: data, err: err}
+		}(i)
+	}
+
+	var order []int
+	for range blocks {
+		r := <-ch
+		order = append(order, r.i)
+		if r.i == len(blocks)-1 {
+			// the dropped response times out without
+			// affecting the others
+			assert.Equal(t, context.DeadlineExceeded, r.err)
+			continue
+		}
+
+		assert.Nil(t, r.err)
+		assert.Equal(t, blocks[r.i].Hash(), r.data.(*Block).Hash())
+	}
+	assert.Equal(t, len(blocks)-1, order[len(order)-1])
+
+	// the connection is still usable after the timeout
+	_, err := requester.Request(context.Background(), Item{T: blockItem, Hash: Hash{1}})
+	assert.Equal(t, errItemNotFound, err)
+	requester.reqMu.Lock()
+	assert.Equal(t, 0, len(requester.pending))
+	requester.reqMu.Unlock()
+}
+
+func TestConnRequestLegacy(t *testing.T) {
+	requester, peer := newRequestConns(t, rlpProtocolVersion)
+	defer requester.Close()
+	defer peer.Close()
+
+	b := &Block{Round: 1}
+	item := Item{T: blockItem, Hash: b.Hash()}
+	go func() {
+		pac, err := peer.Read()
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, itemRequest(item), pac.Data)
+		time.Sleep(10 * time.Millisecond)
+		assert.Nil(t, peer.Write(packet{Data: b}))
+	}()
+
+	data, err := requester.Request(context.Background(), item)
+	assert.Nil(t, err)
+	assert.Equal(t, item.Hash, data.(*Block).Hash())
+
+	// the request times out if the item is not sent
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go peer.Read()
+	_, err = requester.Request(ctx, Item{T: blockItem, Hash: Hash{1}})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	requester.reqMu.Lock()
+	assert.Equal(t, 0, len(requester.legacy))
+	requester.reqMu.Unlock()
+}
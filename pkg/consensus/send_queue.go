@@ -0,0 +1,310 @@
+package consensus
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+const (
+	// defaultSendQueueSize is the default number of the packets
+	// queued to a peer.
+	defaultSendQueueSize = 1024
+	// defaultSaturationTimeout is the default of how long the
+	// queue to a peer can stay full before the peer is
+	// disconnected.
+	defaultSaturationTimeout = 30 * time.Second
+)
+
+// errSaturated is returned when the queue to the peer stayed full for
+// the saturation timeout.
+var errSaturated = errors.New("the send queue to the peer stays full")
+
+// sendClass is the priority of a queued packet, the packets of the
+// lower classes are dropped first when the queue is full.
+type sendClass int
+
+// different classes of the queued packets
+const (
+	// the txns and their announcements and requests
+	gossipClass sendClass = iota
+	normalClass
+	// the blocks, the block proposals and the random beacon
+	// signatures, and their announcements and requests. They are
+	// written before the gossip, so that a txn flood does not
+	// delay the rounds.
+	consensusClass
+	// the notarization and the random beacon shares, they are
+	// never dropped.
+	criticalClass
+	numSendClasses
+)
+
+var sendClassNames = [numSendClasses]string{
+	gossipClass:    "gossip",
+	normalClass:    "normal",
+	consensusClass: "consensus",
+	criticalClass:  "critical",
+}
+
+func (c sendClass) String() string {
+	return sendClassNames[c]
+}
+
+// itemClass returns the class of the item's announcement or request.
+func itemClass(t itemType) sendClass {
+	switch t {
+	case txnItem, sysTxnItem:
+		return gossipClass
+	case ntShareItem, randBeaconSigShareItem:
+		return criticalClass
+	case blockItem, blockProposalItem, randBeaconSigItem:
+		return consensusClass
+	default:
+		return normalClass
+	}
+}
+
+// batchClass returns the highest class of the items.
+func batchClass(items []Item) sendClass {
+	c := gossipClass
+	for _, item := range items {
+		if ic := itemClass(item.T); ic > c {
+			c = ic
+		}
+	}
+	return c
+}
+
+func classOf(data interface{}) sendClass {
+	switch v := data.(type) {
+	case []byte:
+		return gossipClass
+	case *NtShare, *RandBeaconSigShare:
+		return criticalClass
+	case *Block, *BlockProposal, *RandBeaconSig:
+		return consensusClass
+	case Item:
+		return itemClass(v.T)
+	case itemRequest:
+		return itemClass(v.T)
+	case inventory:
+		return batchClass(v)
+	case getData:
+		return batchClass(v)
+	case truncatedGetData:
+		return batchClass(v)
+	default:
+		return normalClass
+	}
+}
+
+// sendQueue is the bounded queue of the packets to a peer, written by
+// the connection's writer so that a slow peer does not block the
+// senders. When the queue is full, the oldest packet of the lowest
+// class is dropped, the critical packets are queued anyway.
+type sendQueue struct {
+	// counts the dropped packets atomically
+	dropped *uint64
+
+	mu     sync.Mutex
+	queues [numSendClasses][]packet
+	size   int
+	max    int
+	// the time allowed to stay full, and since when the queue is
+	// full, zero if it is not.
+	saturation time.Duration
+	fullSince  time.Time
+
+	// set when the queue stops accepting the packets, the writer
+	// stops once the queued ones are written, see drain.
+	draining bool
+
+	ready chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newSendQueue(max int, saturation time.Duration, dropped *uint64) *sendQueue {
+	return &sendQueue{
+		dropped:    dropped,
+		max:        max,
+		saturation: saturation,
+		ready:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+// push queues the packet, it returns errSaturated if the queue stayed
+// full for the saturation timeout.
+func (q *sendQueue) push(p packet) error {
+	c := classOf(p.Data)
+	q.mu.Lock()
+	if q.draining {
+		q.mu.Unlock()
+		return errClosing
+	}
+
+	if q.size >= q.max {
+		if time.Since(q.fullSince) >= q.saturation {
+			q.mu.Unlock()
+			return errSaturated
+		}
+
+		if !q.dropLowest(c) && c != criticalClass {
+			q.mu.Unlock()
+			atomic.AddUint64(q.dropped, 1)
+			return nil
+		}
+	}
+
+	q.queues[c] = append(q.queues[c], p)
+	q.size++
+	if q.size >= q.max && q.fullSince.IsZero() {
+		q.fullSince = time.Now()
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dropLowest drops the oldest packet of the lowest class not higher
+// than c, it returns false if there is none. The caller must hold
+// q.mu.
+func (q *sendQueue) dropLowest(c sendClass) bool {
+	for i := gossipClass; i <= c && i < criticalClass; i++ {
+		if len(q.queues[i]) > 0 {
+			q.queues[i][0] = packet{}
+			q.queues[i] = q.queues[i][1:]
+			q.size--
+			atomic.AddUint64(q.dropped, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// pop returns the oldest packet of the highest class, it waits for
+// one if the queue is empty. It returns false once the queue is
+// closed, or drained.
+func (q *sendQueue) pop() (packet, bool) {
+	for {
+		select {
+		case <-q.done:
+			return packet{}, false
+		default:
+		}
+
+		q.mu.Lock()
+		for i := criticalClass; i >= gossipClass; i-- {
+			if len(q.queues[i]) > 0 {
+				p := q.queues[i][0]
+				q.queues[i][0] = packet{}
+				q.queues[i] = q.queues[i][1:]
+				q.size--
+				if q.size < q.max {
+					q.fullSince = time.Time{}
+				}
+				q.mu.Unlock()
+				return p, true
+			}
+		}
+		draining := q.draining
+		q.mu.Unlock()
+
+		if draining {
+			return packet{}, false
+		}
+
+		select {
+		case <-q.ready:
+		case <-q.done:
+			return packet{}, false
+		}
+	}
+}
+
+// depths returns the number of the queued packets by their classes.
+func (q *sendQueue) depths() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d := make(map[string]int, numSendClasses)
+	for c, packets := range q.queues {
+		d[sendClass(c).String()] = len(packets)
+	}
+	return d
+}
+
+// drain stops accepting the packets, the writer stops once the
+// queued ones are written.
+func (q *sendQueue) drain() {
+	q.mu.Lock()
+	q.draining = true
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the writer.
+func (q *sendQueue) close() {
+	q.once.Do(func() {
+		close(q.done)
+	})
+}
+
+// setSendQueue sets the number of the packets queued to a peer, and
+// how long the queue can stay full before the peer is disconnected,
+// 0 uses the defaults.
+func (n *network) setSendQueue(size int, saturation time.Duration) {
+	if size <= 0 {
+		size = defaultSendQueueSize
+	}
+
+	if saturation <= 0 {
+		saturation = defaultSaturationTimeout
+	}
+
+	n.mu.Lock()
+	n.sendQueueSize = size
+	n.saturationTimeout = saturation
+	n.mu.Unlock()
+}
+
+// writeLoop writes the queued packets to the peer until the
+// connection is closed, or the queue is drained.
+func (p *conn) writeLoop() {
+	defer close(p.writerDone)
+	for {
+		pac, ok := p.sendq.pop()
+		if !ok {
+			return
+		}
+
+		err := p.Write(pac)
+		if err != nil {
+			log.Warn("error writing to the peer, closing the connection", "err", err)
+			p.Close()
+			return
+		}
+	}
+}
+
+// send queues the packet to the registered peer, or writes it at
+// once before the peer is registered.
+func (p *conn) send(pac packet) error {
+	if p.sendq == nil {
+		return p.Write(pac)
+	}
+	return p.sendq.push(pac)
+}
@@ -0,0 +1,555 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// simLink is the condition of the link between two simulated hosts.
+type simLink struct {
+	// the one-way latency of a frame is the base latency plus a
+	// random jitter uniformly distributed in [0, jitter).
+	latency time.Duration
+	jitter  time.Duration
+	// the probability a frame is lost
+	loss float64
+	// the bytes per second sent in each direction, 0 is
+	// unlimited.
+	bandwidth int
+}
+
+func (l simLink) delay(size int) (transmit, latency time.Duration) {
+	if l.bandwidth > 0 {
+		transmit = time.Duration(size) * time.Second / time.Duration(l.bandwidth)
+	}
+
+	latency = l.latency
+	if l.jitter > 0 {
+		latency += time.Duration(rand.Int63n(int64(l.jitter)))
+	}
+	return
+}
+
+// simNet is an in-memory network of the hosts for the tests, it
+// replaces the TCP transport of the nodes, see transport. Each write
+// to a connection is sent as a frame, which is delayed and lost
+// according to the link between the hosts. The hosts can be
+// partitioned into groups, the frames between the groups are lost and
+// the dials time out, as if the cables were cut.
+type simNet struct {
+	mu        sync.Mutex
+	listeners map[string]*simListener
+	links     map[[2]string]simLink
+	link      simLink
+	// the partition group of each host, the hosts not in any group
+	// are in the same group. nil if the network is not
+	// partitioned.
+	groups   map[string]int
+	nextPort int
+}
+
+// newSimNet creates a network where all the links are link.
+func newSimNet(link simLink) *simNet {
+	return &simNet{
+		listeners: make(map[string]*simListener),
+		links:     make(map[[2]string]simLink),
+		link:      link,
+		nextPort:  40000,
+	}
+}
+
+func linkKey(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// setLink sets the link between the two hosts.
+func (s *simNet) setLink(a, b string, l simLink) {
+	s.mu.Lock()
+	s.links[linkKey(a, b)] = l
+	s.mu.Unlock()
+}
+
+func (s *simNet) linkOf(a, b string) simLink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.links[linkKey(a, b)]; ok {
+		return l
+	}
+	return s.link
+}
+
+// partition cuts the links between the groups of the hosts.
+func (s *simNet) partition(groups ...[]string) {
+	s.mu.Lock()
+	s.groups = make(map[string]int)
+	for i, g := range groups {
+		for _, host := range g {
+			s.groups[host] = i + 1
+		}
+	}
+	s.mu.Unlock()
+}
+
+// heal removes the partition.
+func (s *simNet) heal() {
+	s.mu.Lock()
+	s.groups = nil
+	s.mu.Unlock()
+}
+
+func (s *simNet) reachable(a, b string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.groups == nil || s.groups[a] == s.groups[b]
+}
+
+// transport returns the transport of the host.
+func (s *simNet) transport(host string) transport {
+	return &simTransport{net: s, host: host}
+}
+
+type simTransport struct {
+	net  *simNet
+	host string
+}
+
+func (t *simTransport) Listen(addr string) (net.Listener, error) {
+	s := t.net
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.listeners[addr]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "tcp", Addr: simAddr(addr), Err: errors.New("address already in use")}
+	}
+
+	l := &simListener{
+		net:   s,
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	s.listeners[addr] = l
+	return l, nil
+}
+
+func (t *simTransport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	s := t.net
+	s.mu.Lock()
+	l := s.listeners[addr]
+	local := net.JoinHostPort(t.host, strconv.Itoa(s.nextPort))
+	s.nextPort++
+	s.mu.Unlock()
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.reachable(t.host, host) {
+		// the SYN is lost, the dial times out
+		<-ctx.Done()
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: simAddr(addr), Err: ctx.Err()}
+	}
+
+	if l == nil {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: simAddr(addr), Err: errors.New("connection refused")}
+	}
+
+	// the TCP handshake takes a round trip
+	_, latency := s.linkOf(t.host, host).delay(0)
+	select {
+	case <-time.After(2 * latency):
+	case <-ctx.Done():
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: simAddr(addr), Err: ctx.Err()}
+	}
+
+	c, accepted := newSimConnPair(s, local, addr)
+	select {
+	case l.conns <- accepted:
+		return c, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: simAddr(addr), Err: errors.New("connection refused")}
+	case <-ctx.Done():
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: simAddr(addr), Err: ctx.Err()}
+	}
+}
+
+// simAddr is the address of a simulated host, in the form of ip:port.
+type simAddr string
+
+func (a simAddr) Network() string {
+	return "tcp"
+}
+
+func (a simAddr) String() string {
+	return string(a)
+}
+
+type simListener struct {
+	net   *simNet
+	addr  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: simAddr(l.addr), Err: net.ErrClosed}
+	}
+}
+
+func (l *simListener) Close() error {
+	l.once.Do(func() {
+		l.net.mu.Lock()
+		delete(l.net.listeners, l.addr)
+		l.net.mu.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *simListener) Addr() net.Addr {
+	return simAddr(l.addr)
+}
+
+// errConnReset is returned writing to a connection closed by the
+// peer.
+var errConnReset = errors.New("connection reset by peer")
+
+type simFrame struct {
+	b    []byte
+	at   time.Time
+	lost bool
+}
+
+// simPipe is one direction of a simulated connection, the frames are
+// delivered in order to the reader after their delay.
+type simPipe struct {
+	net      *simNet
+	from, to string
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// the frames in flight, and the bytes delivered
+	frames []simFrame
+	buf    bytes.Buffer
+	// when the last frame is sent and delivered, the frames are
+	// sent one at a time and delivered in order as in TCP.
+	sentAt      time.Time
+	deliveredAt time.Time
+	// the writer closed, the reader reads io.EOF after the frames
+	// in flight, and the reader closed.
+	wclosed bool
+	eof     bool
+	rclosed bool
+
+	deadline time.Time
+	timer    *time.Timer
+	// the writes not sent by the deadline fail
+	wdeadline time.Time
+}
+
+func newSimPipe(s *simNet, from, to string) *simPipe {
+	p := &simPipe{net: s, from: from, to: to}
+	p.cond = sync.NewCond(&p.mu)
+	go p.deliver()
+	return p
+}
+
+// write blocks until the frame is sent, so the writer is slowed
+// down by the bandwidth as by a full TCP send buffer.
+func (p *simPipe) write(b []byte) (int, error) {
+	link := p.net.linkOf(p.from, p.to)
+	transmit, latency := link.delay(len(b))
+	lost := rand.Float64() < link.loss
+
+	p.mu.Lock()
+	if p.wclosed {
+		p.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+
+	if p.rclosed {
+		p.mu.Unlock()
+		return 0, errConnReset
+	}
+
+	now := time.Now()
+	sentAt := p.sentAt
+	if sentAt.Before(now) {
+		sentAt = now
+	}
+	sentAt = sentAt.Add(transmit)
+	if deadline := p.wdeadline; !deadline.IsZero() && sentAt.After(deadline) {
+		p.mu.Unlock()
+		time.Sleep(time.Until(deadline))
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	p.sentAt = sentAt
+	at := sentAt.Add(latency)
+	if at.Before(p.deliveredAt) {
+		at = p.deliveredAt
+	}
+	p.deliveredAt = at
+
+	p.frames = append(p.frames, simFrame{b: append([]byte(nil), b...), at: at, lost: lost})
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	time.Sleep(time.Until(sentAt))
+	return len(b), nil
+}
+
+// deliver moves the frames to the reader after their delay, the
+// frames lost or sent across a partition are dropped.
+func (p *simPipe) deliver() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		for len(p.frames) == 0 && !p.wclosed && !p.rclosed {
+			p.cond.Wait()
+		}
+
+		if p.rclosed {
+			return
+		}
+
+		if len(p.frames) == 0 {
+			p.eof = true
+			p.cond.Broadcast()
+			return
+		}
+
+		f := p.frames[0]
+		p.frames = p.frames[1:]
+		p.mu.Unlock()
+		time.Sleep(time.Until(f.at))
+		lost := f.lost || !p.net.reachable(p.from, p.to)
+		p.mu.Lock()
+
+		if !lost {
+			p.buf.Write(f.b)
+			p.cond.Broadcast()
+		}
+	}
+}
+
+func (p *simPipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		switch {
+		case p.rclosed:
+			return 0, net.ErrClosed
+		case p.buf.Len() > 0:
+			return p.buf.Read(b)
+		case p.eof:
+			return 0, io.EOF
+		case !p.deadline.IsZero() && !time.Now().Before(p.deadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *simPipe) setDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.deadline = t
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+
+	if !t.IsZero() {
+		p.timer = time.AfterFunc(time.Until(t), func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+	}
+	p.cond.Broadcast()
+}
+
+func (p *simPipe) closeWrite() {
+	p.mu.Lock()
+	p.wclosed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *simPipe) closeRead() {
+	p.mu.Lock()
+	p.rclosed = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// simConn is a simulated connection, see simNet.
+type simConn struct {
+	local, remote string
+	r, w          *simPipe
+	once          sync.Once
+}
+
+// newSimConnPair returns the dialing and the accepted ends of a
+// connection from local to remote.
+func newSimConnPair(s *simNet, local, remote string) (*simConn, *simConn) {
+	lh, _, _ := net.SplitHostPort(local)
+	rh, _, _ := net.SplitHostPort(remote)
+	out := newSimPipe(s, lh, rh)
+	in := newSimPipe(s, rh, lh)
+	return &simConn{local: local, remote: remote, r: in, w: out},
+		&simConn{local: remote, remote: local, r: out, w: in}
+}
+
+func (c *simConn) Read(b []byte) (int, error) {
+	return c.r.read(b)
+}
+
+func (c *simConn) Write(b []byte) (int, error) {
+	return c.w.write(b)
+}
+
+func (c *simConn) Close() error {
+	c.once.Do(func() {
+		c.w.closeWrite()
+		c.r.closeRead()
+	})
+	return nil
+}
+
+func (c *simConn) LocalAddr() net.Addr {
+	return simAddr(c.local)
+}
+
+func (c *simConn) RemoteAddr() net.Addr {
+	return simAddr(c.remote)
+}
+
+func (c *simConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *simConn) SetReadDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	return nil
+}
+
+func (c *simConn) SetWriteDeadline(t time.Time) error {
+	c.w.mu.Lock()
+	c.w.wdeadline = t
+	c.w.mu.Unlock()
+	return nil
+}
+
+// makeSimNetwork returns a network on the host of the simulated
+// network.
+func makeSimNetwork(s *simNet, host string) *network {
+	n := makeNetwork()
+	n.transport = s.transport(host)
+	return n
+}
+
+func TestSimNet(t *testing.T) {
+	sim := newSimNet(simLink{latency: 20 * time.Millisecond})
+	ln, err := sim.transport("10.0.0.1").Listen("10.0.0.1:1")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		assert.Nil(t, err)
+		accepted <- c
+	}()
+
+	ctx := context.Background()
+	c, err := sim.transport("10.0.0.2").Dial(ctx, "10.0.0.1:1")
+	assert.Nil(t, err)
+	s := <-accepted
+	assert.Equal(t, c.LocalAddr().String(), s.RemoteAddr().String())
+
+	// the frames are delayed by the latency, and read in order
+	start := time.Now()
+	c.Write([]byte("a"))
+	c.Write([]byte("b"))
+	b := make([]byte, 2)
+	_, err = io.ReadFull(s, b)
+	assert.Nil(t, err)
+	assert.Equal(t, "ab", string(b))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	// the reads time out at the deadline
+	s.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = s.Read(b)
+	assert.True(t, isTimeout(err))
+	s.SetReadDeadline(time.Time{})
+
+	// the bandwidth delays the large frames
+	sim.setLink("10.0.0.1", "10.0.0.2", simLink{bandwidth: 100 << 10})
+	start = time.Now()
+	c.Write(make([]byte, 10<<10))
+	_, err = io.ReadFull(s, make([]byte, 10<<10))
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	// the lost frames are never delivered
+	sim.setLink("10.0.0.1", "10.0.0.2", simLink{loss: 1})
+	c.Write([]byte("lost"))
+	sim.setLink("10.0.0.1", "10.0.0.2", simLink{})
+	time.Sleep(10 * time.Millisecond)
+	c.Write([]byte("c"))
+	_, err = s.Read(b)
+	assert.Nil(t, err)
+	assert.Equal(t, byte('c'), b[0])
+
+	// the frames and the dials across a partition are lost
+	sim.partition([]string{"10.0.0.1"}, []string{"10.0.0.2"})
+	c.Write([]byte("lost"))
+	dctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	_, err = sim.transport("10.0.0.2").Dial(dctx, "10.0.0.1:1")
+	cancel()
+	assert.NotNil(t, err)
+
+	sim.heal()
+	time.Sleep(10 * time.Millisecond)
+	c.Write([]byte("d"))
+	_, err = s.Read(b)
+	assert.Nil(t, err)
+	assert.Equal(t, byte('d'), b[0])
+
+	// the peer reads io.EOF after the connection is closed
+	c.Close()
+	_, err = s.Read(b)
+	assert.Equal(t, io.EOF, err)
+	_, err = sim.transport("10.0.0.2").Dial(ctx, "10.0.0.3:1")
+	assert.NotNil(t, err)
+}
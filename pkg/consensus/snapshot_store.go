@@ -0,0 +1,322 @@
+package consensus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+// snapshotProtocolVersion is the protocol version from which the
+// peers serve the state snapshots in chunks, see SnapshotStore.
+const snapshotProtocolVersion = 7
+
+// defaultSnapshotChunkSize is the size of the chunks of a snapshot,
+// well below the cap of the response frames.
+const defaultSnapshotChunkSize = 1 << 20
+
+// errSnapshotNotFound is returned when the snapshot of a state root
+// does not exist.
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+// snapshotManifest describes a snapshot of the state: the encoded
+// TrieBlob is split into the chunks of ChunkSize bytes, the last
+// one can be shorter.
+type snapshotManifest struct {
+	Root      Hash
+	Size      uint64
+	ChunkSize uint32
+	// the SHA3 hashes of the chunks
+	Chunks []Hash
+}
+
+// valid returns true if the manifest is of the root, and the number
+// of its chunks matches its size.
+func (m *snapshotManifest) valid(root Hash) bool {
+	if m.Root != root || m.ChunkSize == 0 || m.Size == 0 {
+		return false
+	}
+
+	return uint64(len(m.Chunks)) == (m.Size+uint64(m.ChunkSize)-1)/uint64(m.ChunkSize)
+}
+
+// chunkLen returns the size of the chunk.
+func (m *snapshotManifest) chunkLen(index int) int {
+	if index == len(m.Chunks)-1 {
+		return int(m.Size - uint64(index)*uint64(m.ChunkSize))
+	}
+	return int(m.ChunkSize)
+}
+
+// snapshotChunk is a chunk of a snapshot.
+type snapshotChunk struct {
+	Root  Hash
+	Index uint32
+	Data  []byte
+}
+
+// trieEntry is an entry of a TrieBlob, a snapshot is the sequence
+// of the RLP encoded entries sorted by the keys.
+type trieEntry struct {
+	Key Hash
+	Val []byte
+}
+
+// snapshotSource serves the snapshots to the peers.
+type snapshotSource interface {
+	// manifest returns errSnapshotNotFound if the snapshot does
+	// not exist.
+	manifest(root Hash) (*snapshotManifest, error)
+	chunk(root Hash, index int) ([]byte, error)
+}
+
+// lookupSnapshot returns the manifest or the chunk of a snapshot
+// requested by the item, or nil if the source does not have it.
+func lookupSnapshot(src snapshotSource, item Item) interface{} {
+	if src == nil {
+		return nil
+	}
+
+	m, err := src.manifest(item.Hash)
+	if err != nil {
+		return nil
+	}
+
+	if item.T == snapshotManifestItem {
+		return m
+	}
+
+	if item.Round >= uint64(len(m.Chunks)) {
+		return nil
+	}
+
+	b, err := src.chunk(item.Hash, int(item.Round))
+	if err != nil {
+		return nil
+	}
+	return &snapshotChunk{Root: item.Hash, Index: uint32(item.Round), Data: b}
+}
+
+// SnapshotStore stores the state snapshots served to the peers in
+// the files of a directory. The chunks are read from the files when
+// they are requested, a snapshot is never held in memory as a whole.
+type SnapshotStore struct {
+	dir       string
+	chunkSize int
+
+	mu        sync.Mutex
+	manifests map[Hash]*snapshotManifest
+}
+
+// NewSnapshotStore creates the store of the snapshots in the
+// directory, 0 chunkSize uses the default of 1MB.
+func NewSnapshotStore(dir string, chunkSize int) (*SnapshotStore, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultSnapshotChunkSize
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotStore{dir: dir, chunkSize: chunkSize, manifests: make(map[Hash]*snapshotManifest)}, nil
+}
+
+func (s *SnapshotStore) dataPath(root Hash) string {
+	return filepath.Join(s.dir, root.Hex()+".snapshot")
+}
+
+func (s *SnapshotStore) manifestPath(root Hash) string {
+	return filepath.Join(s.dir, root.Hex()+".manifest")
+}
+
+// chunkHasher hashes the written bytes by the chunks.
+type chunkHasher struct {
+	size   int
+	n      int
+	total  uint64
+	h      hash.Hash
+	chunks []Hash
+}
+
+func (c *chunkHasher) Write(b []byte) (int, error) {
+	written := len(b)
+	for len(b) > 0 {
+		if c.h == nil {
+			c.h = sha3.New256()
+			c.n = 0
+		}
+
+		l := c.size - c.n
+		if l > len(b) {
+			l = len(b)
+		}
+
+		c.h.Write(b[:l])
+		c.n += l
+		c.total += uint64(l)
+		b = b[l:]
+		if c.n == c.size {
+			c.flush()
+		}
+	}
+	return written, nil
+}
+
+func (c *chunkHasher) flush() {
+	if c.h == nil {
+		return
+	}
+
+	var h Hash
+	copy(h[:], c.h.Sum(nil))
+	c.chunks = append(c.chunks, h)
+	c.h = nil
+}
+
+// Add saves the snapshot of the trie, it replaces the snapshot of
+// the same root.
+func (s *SnapshotStore) Add(blob TrieBlob) error {
+	keys := make([]Hash, 0, len(blob.Data))
+	for k := range blob.Data {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+
+	data := s.dataPath(blob.Root)
+	f, err := os.Create(data + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasher := &chunkHasher{size: s.chunkSize}
+	w := bufio.NewWriter(io.MultiWriter(f, hasher))
+	for _, k := range keys {
+		err = rlp.Encode(w, trieEntry{Key: k, Val: blob.Data[k]})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	hasher.flush()
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	m := &snapshotManifest{Root: blob.Root, Size: hasher.total, ChunkSize: uint32(s.chunkSize), Chunks: hasher.chunks}
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(m)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(s.manifestPath(blob.Root)+".tmp", buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+
+	// the manifest is renamed last, a snapshot without the
+	// manifest does not exist.
+	err = os.Rename(data+".tmp", data)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(s.manifestPath(blob.Root)+".tmp", s.manifestPath(blob.Root))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.manifests[blob.Root] = m
+	s.mu.Unlock()
+	return nil
+}
+
+// Remove removes the snapshot of the state root.
+func (s *SnapshotStore) Remove(root Hash) error {
+	s.mu.Lock()
+	delete(s.manifests, root)
+	s.mu.Unlock()
+
+	err := os.Remove(s.manifestPath(root))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Remove(s.dataPath(root))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *SnapshotStore) manifest(root Hash) (*snapshotManifest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m, ok := s.manifests[root]; ok {
+		return m, nil
+	}
+
+	b, err := ioutil.ReadFile(s.manifestPath(root))
+	if os.IsNotExist(err) {
+		return nil, errSnapshotNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m snapshotManifest
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	s.manifests[root] = &m
+	return &m, nil
+}
+
+func (s *SnapshotStore) chunk(root Hash, index int) ([]byte, error) {
+	m, err := s.manifest(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if index < 0 || index >= len(m.Chunks) {
+		return nil, fmt.Errorf("chunk %d out of range of the %d chunks", index, len(m.Chunks))
+	}
+
+	f, err := os.Open(s.dataPath(root))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := make([]byte, m.chunkLen(index))
+	_, err = f.ReadAt(b, int64(index)*int64(m.ChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,407 @@
+package consensus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	log "github.com/helinwang/log15"
+)
+
+// snapshotFetchers is the number of the chunks of a snapshot
+// requested at the same time.
+const snapshotFetchers = 4
+
+// errNoSnapshotPeer is returned when no connected peer serves the
+// snapshots.
+var errNoSnapshotPeer = errors.New("no connected peer serves the snapshots")
+
+// snapshotDownload is a snapshot being downloaded into a directory:
+// the manifest and the verified chunks are saved in their own
+// files, so that the download resumes from them after a failure or a
+// restart.
+type snapshotDownload struct {
+	root Hash
+	dir  string
+
+	mu       sync.Mutex
+	manifest *snapshotManifest
+	// the indices of the verified chunks
+	have map[int]bool
+}
+
+// openSnapshotDownload opens the download of the snapshot in the
+// directory, the chunks saved by the previous downloads are kept if
+// they are verified.
+func openSnapshotDownload(dir string, root Hash) (*snapshotDownload, error) {
+	d := &snapshotDownload{root: root, dir: filepath.Join(dir, root.Hex()+".download"), have: make(map[int]bool)}
+	err := os.MkdirAll(d.dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(d.manifestPath())
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m snapshotManifest
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&m)
+	if err != nil || !m.valid(root) {
+		log.Warn("dropping the invalid manifest of the snapshot download", "root", root, "err", err)
+		return d, d.reset()
+	}
+
+	d.manifest = &m
+	for i, h := range m.Chunks {
+		b, err := ioutil.ReadFile(d.chunkPath(i))
+		if err != nil {
+			continue
+		}
+
+		if len(b) == m.chunkLen(i) && SHA3(b) == h {
+			d.have[i] = true
+		}
+	}
+	return d, nil
+}
+
+func (d *snapshotDownload) manifestPath() string {
+	return filepath.Join(d.dir, "manifest")
+}
+
+func (d *snapshotDownload) chunkPath(index int) string {
+	return filepath.Join(d.dir, fmt.Sprintf("chunk-%d", index))
+}
+
+// reset drops the manifest and the chunks.
+func (d *snapshotDownload) reset() error {
+	d.manifest = nil
+	d.have = make(map[int]bool)
+	err := os.RemoveAll(d.dir)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(d.dir, 0700)
+}
+
+// writeFile replaces the file at once, so that a crash does not leave
+// a partially written file.
+func writeFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d *snapshotDownload) setManifest(m *snapshotManifest) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(m)
+	if err != nil {
+		return err
+	}
+
+	err = writeFile(d.manifestPath(), buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	d.manifest = m
+	return nil
+}
+
+// missing returns the indices of the chunks not downloaded yet.
+func (d *snapshotDownload) missing() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var r []int
+	for i := range d.manifest.Chunks {
+		if !d.have[i] {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+// verified returns the indices of the verified chunks.
+func (d *snapshotDownload) verified() map[int]bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := make(map[int]bool, len(d.have))
+	for i := range d.have {
+		r[i] = true
+	}
+	return r
+}
+
+// addChunk verifies the chunk against its hash in the manifest and
+// saves it.
+func (d *snapshotDownload) addChunk(c *snapshotChunk) error {
+	i := int(c.Index)
+	if c.Root != d.root || i >= len(d.manifest.Chunks) || len(c.Data) != d.manifest.chunkLen(i) || SHA3(c.Data) != d.manifest.Chunks[i] {
+		return errInvalidResponse
+	}
+
+	err := writeFile(d.chunkPath(i), c.Data)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.have[i] = true
+	d.mu.Unlock()
+	return nil
+}
+
+// chunkReader reads the chunks of a download in order, a chunk file
+// is opened at a time.
+type chunkReader struct {
+	d    *snapshotDownload
+	next int
+	f    *os.File
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	for {
+		if r.f == nil {
+			if r.next == len(r.d.manifest.Chunks) {
+				return 0, io.EOF
+			}
+
+			f, err := os.Open(r.d.chunkPath(r.next))
+			if err != nil {
+				return 0, err
+			}
+			r.f = f
+			r.next++
+		}
+
+		n, err := r.f.Read(b)
+		if err == io.EOF {
+			r.f.Close()
+			r.f = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() {
+	if r.f != nil {
+		r.f.Close()
+	}
+}
+
+// assemble decodes the TrieBlob from the downloaded chunks. The
+// manifest comes from a single peer, so each trie node is checked
+// against its key: the nodes are filled into the database by their
+// hashes, a forged one must not overwrite an existing node.
+func (d *snapshotDownload) assemble() (TrieBlob, error) {
+	r := &chunkReader{d: d}
+	defer r.Close()
+
+	blob := TrieBlob{Root: d.root, Data: make(map[Hash][]byte)}
+	s := rlp.NewStream(bufio.NewReader(r), 0)
+	for {
+		var e trieEntry
+		err := s.Decode(&e)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return TrieBlob{}, err
+		}
+
+		if Hash(crypto.Keccak256Hash(e.Val)) != e.Key {
+			return TrieBlob{}, fmt.Errorf("snapshot trie node %v does not match its hash", e.Key)
+		}
+
+		blob.Data[e.Key] = e.Val
+	}
+
+	if _, ok := blob.Data[d.root]; !ok {
+		return TrieBlob{}, fmt.Errorf("snapshot does not have the root node %v", d.root)
+	}
+	return blob, nil
+}
+
+// snapshotPeers returns the connected peers that serve the
+// snapshots, the best first, see rankPeers.
+func (n *network) snapshotPeers() []unicastAddr {
+	n.mu.Lock()
+	var addrs []unicastAddr
+	for addr, c := range n.conns {
+		if c.version >= snapshotProtocolVersion {
+			addrs = append(addrs, addr)
+		}
+	}
+	n.mu.Unlock()
+
+	return n.rankPeers(addrs)
+}
+
+// FetchSnapshot downloads the snapshot of the state root from the
+// peers into the directory: the manifest first, and then the chunks
+// from different peers at the same time, each verified against its
+// hash in the manifest. The verified chunks are kept when the
+// download fails, and are not downloaded again when it is retried.
+// The download is removed once the TrieBlob is assembled.
+func (n *network) FetchSnapshot(ctx context.Context, root Hash, dir string) (TrieBlob, error) {
+	d, err := openSnapshotDownload(dir, root)
+	if err != nil {
+		return TrieBlob{}, err
+	}
+
+	peers := n.snapshotPeers()
+	if len(peers) == 0 {
+		return TrieBlob{}, errNoSnapshotPeer
+	}
+
+	if d.manifest == nil {
+		err = n.fetchManifest(ctx, d, peers)
+		if err != nil {
+			return TrieBlob{}, err
+		}
+	}
+
+	err = n.fetchChunks(ctx, d, peers)
+	if err != nil {
+		return TrieBlob{}, err
+	}
+
+	blob, err := d.assemble()
+	if err != nil {
+		// the chunks match the manifest, so the manifest is
+		// invalid, the download starts over.
+		log.Warn("dropping the invalid snapshot download", "root", root, "err", err)
+		d.reset()
+		return TrieBlob{}, err
+	}
+
+	err = os.RemoveAll(d.dir)
+	if err != nil {
+		log.Warn("error removing the snapshot download", "dir", d.dir, "err", err)
+	}
+	return blob, nil
+}
+
+// fetchManifest requests the manifest from the peers in turn until
+// one replies a valid manifest.
+func (n *network) fetchManifest(ctx context.Context, d *snapshotDownload, peers []unicastAddr) error {
+	err := errSnapshotNotFound
+	for _, p := range peers {
+		var data interface{}
+		data, err = n.Request(ctx, p, Item{T: snapshotManifestItem, Hash: d.root})
+		if err == errItemNotFound {
+			err = errSnapshotNotFound
+			continue
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			continue
+		}
+
+		m, ok := data.(*snapshotManifest)
+		if !ok || !m.valid(d.root) {
+			n.penalize(p)
+			err = errInvalidResponse
+			continue
+		}
+		return d.setManifest(m)
+	}
+	return err
+}
+
+// fetchChunks downloads the missing chunks, snapshotFetchers at a
+// time. A chunk is requested from the peers in turn, starting from a
+// different peer for each chunk.
+func (n *network) fetchChunks(ctx context.Context, d *snapshotDownload, peers []unicastAddr) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	missing := d.missing()
+	ch := make(chan int, len(missing))
+	for _, i := range missing {
+		ch <- i
+	}
+	close(ch)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < snapshotFetchers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				err := n.fetchChunk(ctx, d, i, peers)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return fmt.Errorf("downloaded %d of the %d chunks of the snapshot: %v", len(d.verified()), len(d.manifest.Chunks), firstErr)
+	}
+	return nil
+}
+
+func (n *network) fetchChunk(ctx context.Context, d *snapshotDownload, index int, peers []unicastAddr) error {
+	var err error
+	for i := range peers {
+		p := peers[(index+i)%len(peers)]
+		var data interface{}
+		data, err = n.Request(ctx, p, Item{T: snapshotChunkItem, Round: uint64(index), Hash: d.root})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			log.Debug("snapshot chunk request failed", "root", d.root, "chunk", index, "addr", p.Addr, "err", err)
+			continue
+		}
+
+		c, ok := data.(*snapshotChunk)
+		if !ok {
+			n.penalize(p)
+			err = errInvalidResponse
+			continue
+		}
+
+		err = d.addChunk(c)
+		if err == errInvalidResponse {
+			n.penalize(p)
+			continue
+		}
+		return err
+	}
+	return err
+}
@@ -0,0 +1,293 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// the SOCKS5 protocol, see RFC 1928 and RFC 1929.
+const (
+	socksVersion       = 5
+	socksAuthVersion   = 1
+	socksNoAuth        = 0
+	socksUserPassAuth  = 2
+	socksNoAcceptable  = 0xff
+	socksConnect       = 1
+	socksIPv4          = 1
+	socksDomain        = 3
+	socksIPv6          = 4
+	socksSucceeded     = 0
+	socksAuthSucceeded = 0
+	// socksResolve is the Tor extension resolving a host name
+	// through the proxy.
+	socksResolve = 0xf0
+)
+
+var socksReplies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socksProxy is a SOCKS5 proxy, the password authentication is used
+// if the user is not empty.
+type socksProxy struct {
+	addr     string
+	user     string
+	password string
+}
+
+// proxyError is the error connecting through the proxy, so that it
+// is told apart from the errors dialing the peers directly.
+type proxyError struct {
+	proxy string
+	addr  string
+	err   error
+}
+
+func (e *proxyError) Error() string {
+	return fmt.Sprintf("dial %s via the SOCKS5 proxy %s: %v", e.addr, e.proxy, e.err)
+}
+
+// Timeout returns true if the proxy or the peer timed out.
+func (e *proxyError) Timeout() bool {
+	return isTimeout(e.err)
+}
+
+// Temporary is always false, it is required by net.Error.
+func (e *proxyError) Temporary() bool {
+	return false
+}
+
+// dial connects to the address through the proxy.
+func (p socksProxy) dial(ctx context.Context, addr string) (net.Conn, error) {
+	c, _, err := p.request(ctx, socksConnect, addr)
+	if err != nil {
+		return nil, &proxyError{proxy: p.addr, addr: addr, err: err}
+	}
+	return c, nil
+}
+
+// lookupHost resolves the host through the proxy, the proxy must
+// support the RESOLVE extension of Tor. Only one address is
+// returned.
+func (p socksProxy) lookupHost(ctx context.Context, host string) ([]string, error) {
+	c, bound, err := p.request(ctx, socksResolve, net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, &proxyError{proxy: p.addr, addr: host, err: err}
+	}
+
+	c.Close()
+	if bound.IP == nil {
+		return nil, &proxyError{proxy: p.addr, addr: host, err: errors.New("no address resolved")}
+	}
+	return []string{bound.IP.String()}, nil
+}
+
+// request negotiates with the proxy, and sends the command to the
+// address. It returns the connection to the proxy and the bound
+// address in the proxy's reply.
+func (p socksProxy) request(ctx context.Context, cmd byte, addr string) (net.Conn, *net.TCPAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid port %q", port)
+	}
+
+	d := net.Dialer{KeepAlive: tcpKeepAlive}
+	c, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	bound, err := p.negotiate(c, cmd, host, uint16(portNum))
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+
+	c.SetDeadline(time.Time{})
+	return c, bound, nil
+}
+
+func (p socksProxy) negotiate(c net.Conn, cmd byte, host string, port uint16) (*net.TCPAddr, error) {
+	method := byte(socksNoAuth)
+	if p.user != "" {
+		method = socksUserPassAuth
+	}
+
+	_, err := c.Write([]byte{socksVersion, 1, method})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply [2]byte
+	_, err = io.ReadFull(c, reply[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if reply[0] != socksVersion {
+		return nil, fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socksNoAuth:
+	case socksUserPassAuth:
+		err = p.authenticate(c)
+		if err != nil {
+			return nil, err
+		}
+	case socksNoAcceptable:
+		return nil, errors.New("no acceptable authentication method")
+	default:
+		return nil, fmt.Errorf("unexpected authentication method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, cmd, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host name too long: %q", host)
+		}
+		req = append(req, socksDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socksIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socksIPv6)
+		req = append(req, ip...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	_, err = c.Write(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return readSocksReply(c)
+}
+
+func (p socksProxy) authenticate(c net.Conn) error {
+	if len(p.user) > 255 || len(p.password) > 255 {
+		return errors.New("user or password too long")
+	}
+
+	req := []byte{socksAuthVersion, byte(len(p.user))}
+	req = append(req, p.user...)
+	req = append(req, byte(len(p.password)))
+	req = append(req, p.password...)
+	_, err := c.Write(req)
+	if err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	_, err = io.ReadFull(c, reply[:])
+	if err != nil {
+		return err
+	}
+
+	if reply[1] != socksAuthSucceeded {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
+
+// readSocksReply reads the reply to the request, it returns the bound
+// address.
+func readSocksReply(r io.Reader) (*net.TCPAddr, error) {
+	var h [4]byte
+	_, err := io.ReadFull(r, h[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if h[0] != socksVersion {
+		return nil, fmt.Errorf("unexpected SOCKS version %d", h[0])
+	}
+
+	if h[1] != socksSucceeded {
+		if msg, ok := socksReplies[h[1]]; ok {
+			return nil, errors.New(msg)
+		}
+		return nil, fmt.Errorf("unknown SOCKS reply %d", h[1])
+	}
+
+	var ip net.IP
+	switch h[3] {
+	case socksIPv4:
+		ip = make(net.IP, net.IPv4len)
+	case socksIPv6:
+		ip = make(net.IP, net.IPv6len)
+	case socksDomain:
+		// the bound address of a domain name is not used, it
+		// is read to complete the reply.
+		var l [1]byte
+		_, err = io.ReadFull(r, l[:])
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(r, make([]byte, int(l[0])+2))
+		return &net.TCPAddr{}, err
+	default:
+		return nil, fmt.Errorf("unknown address type %d", h[3])
+	}
+
+	var port [2]byte
+	_, err = io.ReadFull(r, ip)
+	if err == nil {
+		_, err = io.ReadFull(r, port[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port[0])<<8 | int(port[1])}, nil
+}
+
+// socksTransport dials the peers through the proxy, the connections
+// are listened for directly.
+type socksTransport struct {
+	tcpTransport
+	proxy socksProxy
+}
+
+func (t socksTransport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return t.proxy.dial(ctx, addr)
+}
+
+// setProxy sets the SOCKS5 proxy the peers are dialed through, an
+// empty address dials them directly. If dns is true, the DNS seeds
+// are resolved through the proxy too.
+func (n *network) setProxy(addr, user, password string, dns bool) {
+	if addr == "" {
+		return
+	}
+
+	p := socksProxy{addr: addr, user: user, password: password}
+	n.mu.Lock()
+	n.transport = socksTransport{proxy: p}
+	if dns {
+		n.lookupHost = p.lookupHost
+	}
+	n.mu.Unlock()
+}
@@ -0,0 +1,196 @@
+package consensus
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// socksServer is a minimal SOCKS5 server, it relays the CONNECT
+// requests, and resolves the host names in hosts for the RESOLVE
+// requests.
+type socksServer struct {
+	ln       net.Listener
+	user     string
+	password string
+	hosts    map[string]string
+
+	mu        sync.Mutex
+	connected []string
+}
+
+func newSocksServer(t *testing.T, user, password string, hosts map[string]string) *socksServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := &socksServer{ln: ln, user: user, password: password, hosts: hosts}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(c)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) handle(c net.Conn) {
+	defer c.Close()
+
+	var h [2]byte
+	if _, err := io.ReadFull(c, h[:]); err != nil {
+		return
+	}
+	methods := make([]byte, h[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return
+	}
+
+	want := byte(socksNoAuth)
+	if s.user != "" {
+		want = socksUserPassAuth
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		c.Write([]byte{socksVersion, socksNoAcceptable})
+		return
+	}
+	c.Write([]byte{socksVersion, want})
+
+	if want == socksUserPassAuth {
+		user, password := readSocksString(c, 1), readSocksString(c, 0)
+		if user != s.user || password != s.password {
+			c.Write([]byte{socksAuthVersion, 1})
+			return
+		}
+		c.Write([]byte{socksAuthVersion, socksAuthSucceeded})
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(c, req[:]); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case socksIPv4:
+		ip := make(net.IP, net.IPv4len)
+		io.ReadFull(c, ip)
+		host = ip.String()
+	case socksDomain:
+		host = readSocksString(c, 0)
+	default:
+		c.Write([]byte{socksVersion, 8, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	var port [2]byte
+	io.ReadFull(c, port[:])
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+
+	switch req[1] {
+	case socksResolve:
+		ip := net.ParseIP(s.hosts[host]).To4()
+		if ip == nil {
+			c.Write([]byte{socksVersion, 4, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		c.Write(append(append([]byte{socksVersion, socksSucceeded, 0, socksIPv4}, ip...), 0, 0))
+	case socksConnect:
+		remote, err := net.Dial("tcp", addr)
+		if err != nil {
+			c.Write([]byte{socksVersion, 5, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer remote.Close()
+
+		s.mu.Lock()
+		s.connected = append(s.connected, addr)
+		s.mu.Unlock()
+		c.Write([]byte{socksVersion, socksSucceeded, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		go io.Copy(remote, c)
+		io.Copy(c, remote)
+	default:
+		c.Write([]byte{socksVersion, 7, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+// readSocksString reads a string prefixed by its length, after
+// skipping the bytes.
+func readSocksString(r io.Reader, skip int) string {
+	b := make([]byte, skip+1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return ""
+	}
+	s := make([]byte, b[skip])
+	io.ReadFull(r, s)
+	return string(s)
+}
+
+func (s *socksServer) connections() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.connected...)
+}
+
+func TestNetworkProxy(t *testing.T) {
+	proxy := newSocksServer(t, "user", "password", nil)
+	defer proxy.ln.Close()
+
+	n0 := makeNetwork()
+	addr0, err := n0.Start("127.0.0.1", 11064)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// the peer is connected through the proxy
+	n1 := makeNetwork()
+	n1.setProxy(proxy.ln.Addr().String(), "user", "password", false)
+	_, err = n1.AddPeer(context.Background(), addr0.Addr)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{addr0.Addr}, proxy.connections())
+	waitUntil(t, func() bool {
+		return n0.PeerCount() == 1
+	})
+
+	// the errors through the proxy are told apart from the direct
+	// dial errors
+	n2 := makeNetwork()
+	n2.setProxy(proxy.ln.Addr().String(), "user", "wrong", false)
+	_, err = n2.AddPeer(context.Background(), addr0.Addr)
+	assert.IsType(t, &proxyError{}, err)
+	assert.Contains(t, err.Error(), "via the SOCKS5 proxy")
+	assert.Contains(t, err.Error(), "authentication failed")
+
+	n3 := makeNetwork()
+	n3.setProxy(proxy.ln.Addr().String(), "user", "password", false)
+	_, err = n3.AddPeer(context.Background(), "127.0.0.1:1")
+	assert.Contains(t, err.Error(), "connection refused")
+	assert.Contains(t, err.Error(), "via the SOCKS5 proxy")
+
+	_, err = makeNetwork().AddPeer(context.Background(), "127.0.0.1:1")
+	assert.NotContains(t, err.Error(), "proxy")
+}
+
+func TestNetworkProxyDNS(t *testing.T) {
+	proxy := newSocksServer(t, "", "", map[string]string{"seed.example": "10.1.2.3"})
+	defer proxy.ln.Close()
+
+	n := makeNetwork()
+	n.setProxy(proxy.ln.Addr().String(), "", "", true)
+	n.setDNSSeeds([]string{"seed.example:11064"})
+	addrs, err := n.resolveDNSSeeds()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.1.2.3:11064"}, addrs)
+
+	_, err = n.lookupHost(context.Background(), "unknown.example")
+	assert.IsType(t, &proxyError{}, err)
+	assert.Contains(t, err.Error(), "host unreachable")
+}
@@ -14,6 +14,11 @@ type State interface {
 
 var ErrTxnNonceTooBig = errors.New("txn's nonce is too big, but txn can be used for future")
 
+// MaxTxnBytes is the maximum size of a serialized txn. It is part of
+// the block validation: a block that includes a larger txn is
+// invalid.
+const MaxTxnBytes = 32 << 10
+
 // Transition is the transition from one State to another State.
 type Transition interface {
 	// Record records a transition to the state transition.
@@ -36,7 +41,14 @@ type Txn struct {
 	MinerFeeTxn bool
 	Owner       Addr
 	Nonce       uint64
-	Raw         []byte
+	// the fee paid to the block proposer
+	Fee uint64
+	// the owner's public key carried by the txn, empty if the txn
+	// does not carry it.
+	OwnerPK []byte
+	// the addresses of the keys that signed the txn
+	Signers []Addr
+	Raw     []byte
 }
 
 // TxnPool is the pool that stores the received transactions.
@@ -51,4 +63,15 @@ type TxnPool interface {
 	Txns() []*Txn
 	Remove(hash Hash)
 	Size() int
+	// AdvanceRound tells the pool that the given round is
+	// started, the pool should drop the txns that can not be
+	// included from this round on.
+	AdvanceRound(round uint64)
+	// Included tells the pool that the serialized txns are
+	// included in the block of the given round.
+	Included(round uint64, block Hash, txns []byte)
+	// Notify returns the channel that receives a value when the
+	// pool becomes non-empty. The notifications are coalesced, a
+	// receiver should check the pool size after receiving one.
+	Notify() <-chan struct{}
 }
@@ -23,21 +23,46 @@ type syncer struct {
 
 	mu               sync.Mutex
 	pendingSyncBlock map[Hash][]chan syncBlockResult
-	pendingSyncBP    map[Hash][]chan syncBPResult
-	pendingSyncRB    map[uint64][]chan syncRBResult
+	// the rounds of the blocks being synced
+	pendingBlockRound map[Hash]uint64
+	pendingSyncBP     map[Hash][]chan syncBPResult
+	pendingSyncRB     map[uint64][]chan syncRBResult
 }
 
 func newSyncer(chain *Chain, requester requester, store *storage) *syncer {
 	return &syncer{
-		chain:            chain,
-		store:            store,
-		requester:        requester,
-		pendingSyncBlock: make(map[Hash][]chan syncBlockResult),
-		pendingSyncBP:    make(map[Hash][]chan syncBPResult),
-		pendingSyncRB:    make(map[uint64][]chan syncRBResult),
+		chain:             chain,
+		store:             store,
+		requester:         requester,
+		pendingSyncBlock:  make(map[Hash][]chan syncBlockResult),
+		pendingBlockRound: make(map[Hash]uint64),
+		pendingSyncBP:     make(map[Hash][]chan syncBPResult),
+		pendingSyncRB:     make(map[uint64][]chan syncRBResult),
 	}
 }
 
+// Status returns if the blocks or the random beacon signatures are
+// being synced, and the highest round being synced.
+func (s *syncer) Status() (syncing bool, targetRound uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, round := range s.pendingBlockRound {
+		if round > targetRound {
+			targetRound = round
+		}
+	}
+
+	for round := range s.pendingSyncRB {
+		if round > targetRound {
+			targetRound = round
+		}
+	}
+
+	syncing = len(s.pendingBlockRound) > 0 || len(s.pendingSyncRB) > 0
+	return
+}
+
 type syncBlockResult struct {
 	b         *Block
 	broadcast bool
@@ -70,6 +95,7 @@ func (s *syncer) SyncBlock(addr unicastAddr, hash Hash, round uint64) (b *Block,
 	chs = append(chs, ch)
 	s.pendingSyncBlock[hash] = chs
 	if len(chs) == 1 {
+		s.pendingBlockRound[hash] = round
 		go func() {
 			b, broadcast, err := s.syncBlock(addr, hash, round)
 			result := syncBlockResult{b: b, broadcast: broadcast, err: err}
@@ -78,6 +104,7 @@ func (s *syncer) SyncBlock(addr unicastAddr, hash Hash, round uint64) (b *Block,
 				ch <- result
 			}
 			delete(s.pendingSyncBlock, hash)
+			delete(s.pendingBlockRound, hash)
 			s.mu.Unlock()
 		}()
 	}
@@ -154,6 +181,9 @@ func (s *syncer) syncBlock(addr unicastAddr, hash Hash, round uint64) (b *Block,
 		return
 	}
 
+	if broadcast {
+		go s.chain.txnPool.Included(b.Round, hash, bp.Txns)
+	}
 	return
 }
 
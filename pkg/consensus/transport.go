@@ -0,0 +1,27 @@
+package consensus
+
+import (
+	"context"
+	"net"
+)
+
+// transport listens for and dials the peer connections, the tests
+// replace it with a simulated network.
+type transport interface {
+	Listen(addr string) (net.Listener, error)
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// tcpTransport is the TCP transport, the connections send the TCP
+// keep-alives.
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: tcpKeepAlive}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+func (tcpTransport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	d := net.Dialer{KeepAlive: tcpKeepAlive}
+	return d.DialContext(ctx, "tcp", addr)
+}
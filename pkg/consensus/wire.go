@@ -0,0 +1,119 @@
+package consensus
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// rlpProtocolVersion is the protocol version from which the packets
+// are RLP encoded. The hellos are always gob encoded, so that the
+// nodes speaking the older versions can negotiate with the node.
+const rlpProtocolVersion = 2
+
+// msgType is the type of an RLP encoded packet, it is sent in the
+// frame header in place of the msgKind.
+type msgType byte
+
+// different types of the RLP encoded packets
+const (
+	txnType msgType = iota + 1
+	randBeaconSigType
+	randBeaconSigShareType
+	blockType
+	blockProposalType
+	ntShareType
+	itemMsgType
+	itemRequestType
+	connectRequestType
+	addrsType
+	ackType
+	pingType
+	pongType
+	peerRejectionType
+	helloType
+	requestType
+	responseType
+	inventoryType
+	getDataType
+	disconnectType
+	snapshotManifestType
+	snapshotChunkType
+	truncatedGetDataType
+)
+
+// wireTypes is the Go type of the packet data of each msgType.
+var wireTypes = map[msgType]reflect.Type{
+	txnType:                reflect.TypeOf([]byte(nil)),
+	randBeaconSigType:      reflect.TypeOf(&RandBeaconSig{}),
+	randBeaconSigShareType: reflect.TypeOf(&RandBeaconSigShare{}),
+	blockType:              reflect.TypeOf(&Block{}),
+	blockProposalType:      reflect.TypeOf(&BlockProposal{}),
+	ntShareType:            reflect.TypeOf(&NtShare{}),
+	itemMsgType:            reflect.TypeOf(Item{}),
+	itemRequestType:        reflect.TypeOf(itemRequest{}),
+	connectRequestType:     reflect.TypeOf(&connectRequest{}),
+	addrsType:              reflect.TypeOf([]unicastAddr(nil)),
+	ackType:                reflect.TypeOf(ack{}),
+	pingType:               reflect.TypeOf(ping{}),
+	pongType:               reflect.TypeOf(pong{}),
+	peerRejectionType:      reflect.TypeOf(&peerRejection{}),
+	helloType:              reflect.TypeOf(&hello{}),
+	requestType:            reflect.TypeOf(&request{}),
+	responseType:           reflect.TypeOf(&response{}),
+	inventoryType:          reflect.TypeOf(inventory(nil)),
+	getDataType:            reflect.TypeOf(getData(nil)),
+	disconnectType:         reflect.TypeOf(&disconnect{}),
+	snapshotManifestType:   reflect.TypeOf(&snapshotManifest{}),
+	snapshotChunkType:      reflect.TypeOf(&snapshotChunk{}),
+	truncatedGetDataType:   reflect.TypeOf(truncatedGetData(nil)),
+}
+
+var wireTypeOf = make(map[reflect.Type]msgType)
+
+func init() {
+	for t, rt := range wireTypes {
+		wireTypeOf[rt] = t
+	}
+}
+
+// encoder is implemented by the consensus types with their own RLP
+// encoding.
+type encoder interface {
+	Encode(withSig bool) []byte
+}
+
+// encodeWire returns the msgType and the RLP encoding of the packet
+// data.
+func encodeWire(data interface{}) (msgType, []byte, error) {
+	t, ok := wireTypeOf[reflect.TypeOf(data)]
+	if !ok {
+		return 0, nil, fmt.Errorf("unknown packet type %T", data)
+	}
+
+	if e, ok := data.(encoder); ok {
+		return t, e.Encode(true), nil
+	}
+
+	b, err := rlp.EncodeToBytes(data)
+	return t, b, err
+}
+
+// decodeWire decodes the RLP encoded packet data of the msgType.
+func decodeWire(t msgType, b []byte) (interface{}, error) {
+	rt, ok := wireTypes[t]
+	if !ok {
+		return nil, &frameError{reason: fmt.Sprintf("unknown message type %d", t)}
+	}
+
+	if rt.Kind() == reflect.Ptr {
+		v := reflect.New(rt.Elem())
+		err := rlp.DecodeBytes(b, v.Interface())
+		return v.Interface(), err
+	}
+
+	v := reflect.New(rt)
+	err := rlp.DecodeBytes(b, v.Interface())
+	return v.Elem().Interface(), err
+}
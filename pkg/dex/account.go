@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
 )
 
@@ -78,6 +79,20 @@ type PendingOrder struct {
 	Order
 }
 
+// DecodeRLP decodes the pending order, it is defined so that the
+// DecodeRLP of the embedded Order is not promoted.
+func (p *PendingOrder) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	if err := decodeFields(s, &p.ID, &p.Executed, &p.Order); err != nil {
+		return err
+	}
+
+	return s.ListEnd()
+}
+
 // Account is a cached proxy to the account data inside the state
 // trie.
 type Account struct {
@@ -150,7 +165,14 @@ func (a *Account) Balance(tokenID TokenID) Balance {
 	if a.balances == nil {
 		a.loadBalances()
 	}
-	return a.balances[tokenID]
+
+	b := a.balances[tokenID]
+	if len(b.Frozen) > 0 {
+		// copy the frozen list so that the caller's
+		// modification will not leak into the cached balance.
+		b.Frozen = append([]Frozen(nil), b.Frozen...)
+	}
+	return b
 }
 
 func (a *Account) loadBalances() {
@@ -0,0 +1,178 @@
+package dex
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// adminServicePrefix is the prefix of the net/rpc methods of the
+// AdminService.
+const adminServicePrefix = "AdminService."
+
+// defaultPeerBanDuration is how long a peer is banned when the
+// BanPeer call does not set the duration.
+const defaultPeerBanDuration = 24 * time.Hour
+
+// addPeerTimeout is how long an AddPeer call waits for the
+// connection and the handshake.
+const addPeerTimeout = 10 * time.Second
+
+// errAdminServiceNoAuth is returned by Handler when the AdminService
+// is enabled without the auth.
+var errAdminServiceNoAuth = errors.New("the admin service requires the auth token")
+
+// PeerManager manages the peers of the node, it is implemented by
+// consensus.Node.
+type PeerManager interface {
+	Peers() []consensus.PeerInfo
+	AddPeer(ctx context.Context, addr string) (consensus.PeerInfo, error)
+	DisconnectPeer(id consensus.Addr) error
+	BanPeer(id consensus.Addr, d time.Duration)
+	AddBan(b consensus.Ban) error
+	RemoveBan(b consensus.Ban) error
+	Bans() []consensus.Ban
+	NetworkMetrics() consensus.NetworkMetrics
+}
+
+// EnableAdminService serves the AdminService managing the node's
+// peers over net/rpc, on the same address as the WalletService. All
+// its methods require the bearer token set by SetAuth, so the auth
+// must be set as well. banDuration is the default duration of a ban,
+// 0 means 24 hours. It must be called before Start.
+func (r *RPCServer) EnableAdminService(m PeerManager, banDuration time.Duration) {
+	if banDuration == 0 {
+		banDuration = defaultPeerBanDuration
+	}
+
+	r.peerManager = m
+	r.peerBanDuration = banDuration
+}
+
+// isAdminMethod returns true if the method is a method of the
+// AdminService.
+func isAdminMethod(method string) bool {
+	return strings.HasPrefix(method, adminServicePrefix)
+}
+
+// AdminService is the net/rpc service managing the node.
+type AdminService struct {
+	s *RPCServer
+}
+
+// BanPeerRequest is the argument of the BanPeer call.
+type BanPeerRequest struct {
+	ID consensus.Addr
+	// 0 means the default duration of the server.
+	Duration time.Duration
+}
+
+// AddBanRequest is the argument of the AddBan call, exactly one of
+// ID and Net is set.
+type AddBanRequest struct {
+	ID consensus.Addr
+	// an IP, or a network in the CIDR notation such as
+	// 10.0.0.0/24.
+	Net string
+	// 0 means the default duration of the server.
+	Duration time.Duration
+	Reason   string
+}
+
+// RemoveBanRequest is the argument of the RemoveBan call.
+type RemoveBanRequest struct {
+	ID  consensus.Addr
+	Net string
+}
+
+// Peers returns the connected peers.
+func (s *AdminService) Peers(_ int, peers *[]consensus.PeerInfo) error {
+	*peers = s.s.peerManager.Peers()
+	return nil
+}
+
+// AddPeer connects to the peer accepting the connections on the
+// address.
+func (s *AdminService) AddPeer(addr string, info *consensus.PeerInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), addPeerTimeout)
+	defer cancel()
+
+	p, err := s.s.peerManager.AddPeer(ctx, addr)
+	if err != nil {
+		return newRPCError(CodeInvalidRequest, "can not add peer %s: %v", addr, err)
+	}
+
+	*info = p
+	return nil
+}
+
+// DisconnectPeer closes the connections to the peer.
+func (s *AdminService) DisconnectPeer(id consensus.Addr, _ *int) error {
+	err := s.s.peerManager.DisconnectPeer(id)
+	if err == consensus.ErrPeerNotFound {
+		return newRPCError(CodeNotFound, "peer %v is not connected", id)
+	}
+	return serviceError(err)
+}
+
+// BanPeer disconnects the peer and refuses its connections for the
+// duration.
+func (s *AdminService) BanPeer(req BanPeerRequest, _ *int) error {
+	if req.Duration < 0 {
+		return newRPCError(CodeInvalidRequest, "negative ban duration %v", req.Duration)
+	}
+
+	d := req.Duration
+	if d == 0 {
+		d = s.s.peerBanDuration
+	}
+
+	s.s.peerManager.BanPeer(req.ID, d)
+	return nil
+}
+
+// AddBan disconnects the peer or the hosts in the network, and
+// refuses their connections for the duration. The ban is kept across
+// the restarts of the node.
+func (s *AdminService) AddBan(req AddBanRequest, _ *int) error {
+	if req.Duration < 0 {
+		return newRPCError(CodeInvalidRequest, "negative ban duration %v", req.Duration)
+	}
+
+	d := req.Duration
+	if d == 0 {
+		d = s.s.peerBanDuration
+	}
+
+	b := consensus.Ban{ID: req.ID, Net: req.Net, Until: time.Now().Add(d), Reason: req.Reason}
+	err := s.s.peerManager.AddBan(b)
+	if err != nil {
+		return newRPCError(CodeInvalidRequest, "can not add the ban: %v", err)
+	}
+	return nil
+}
+
+// RemoveBan removes the ban of the peer or the network.
+func (s *AdminService) RemoveBan(req RemoveBanRequest, _ *int) error {
+	err := s.s.peerManager.RemoveBan(consensus.Ban{ID: req.ID, Net: req.Net})
+	if err == consensus.ErrBanNotFound {
+		return newRPCError(CodeNotFound, "no ban of %v %s", req.ID, req.Net)
+	}
+	return serviceError(err)
+}
+
+// Bans returns the bans not expired.
+func (s *AdminService) Bans(_ int, bans *[]consensus.Ban) error {
+	*bans = s.s.peerManager.Bans()
+	return nil
+}
+
+// NetworkMetrics returns the traffic of the node aggregated over the
+// peers.
+func (s *AdminService) NetworkMetrics(_ int, m *consensus.NetworkMetrics) error {
+	*m = s.s.peerManager.NetworkMetrics()
+	return nil
+}
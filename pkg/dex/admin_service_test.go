@@ -0,0 +1,150 @@
+package dex
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPeerManager struct {
+	peers  []consensus.PeerInfo
+	banned map[consensus.Addr]time.Duration
+	bans   []consensus.Ban
+	net    consensus.NetworkMetrics
+}
+
+func (m *testPeerManager) Peers() []consensus.PeerInfo {
+	return m.peers
+}
+
+func (m *testPeerManager) AddPeer(ctx context.Context, addr string) (consensus.PeerInfo, error) {
+	p := consensus.PeerInfo{ID: consensus.Addr{byte(len(m.peers) + 1)}, Addr: addr}
+	m.peers = append(m.peers, p)
+	return p, nil
+}
+
+func (m *testPeerManager) DisconnectPeer(id consensus.Addr) error {
+	for i, p := range m.peers {
+		if p.ID == id {
+			m.peers = append(m.peers[:i], m.peers[i+1:]...)
+			return nil
+		}
+	}
+	return consensus.ErrPeerNotFound
+}
+
+func (m *testPeerManager) BanPeer(id consensus.Addr, d time.Duration) {
+	m.DisconnectPeer(id)
+	m.banned[id] = d
+}
+
+func (m *testPeerManager) AddBan(b consensus.Ban) error {
+	m.bans = append(m.bans, b)
+	return nil
+}
+
+func (m *testPeerManager) RemoveBan(b consensus.Ban) error {
+	for i, ban := range m.bans {
+		if ban.ID == b.ID && ban.Net == b.Net {
+			m.bans = append(m.bans[:i], m.bans[i+1:]...)
+			return nil
+		}
+	}
+	return consensus.ErrBanNotFound
+}
+
+func (m *testPeerManager) Bans() []consensus.Ban {
+	return m.bans
+}
+
+func (m *testPeerManager) NetworkMetrics() consensus.NetworkMetrics {
+	return m.net
+}
+
+func TestAdminService(t *testing.T) {
+	const token = "s3cret-token"
+	m := &testPeerManager{banned: make(map[consensus.Addr]time.Duration)}
+	r := NewRPCServer()
+	r.EnableAdminService(m, 0)
+	_, err := r.Handler()
+	assert.Equal(t, errAdminServiceNoAuth, err)
+
+	// the admin methods require the token even if they are not
+	// listed
+	r.SetAuth(token, []string{"SendTxn"})
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	client, err := DialRPC(addr, nil, "wrong")
+	assert.Nil(t, err)
+	var peers []consensus.PeerInfo
+	err = client.Call("AdminService.Peers", 0, &peers)
+	assert.Equal(t, ErrUnauthorized.Error(), err.Error())
+	client.Close()
+
+	client, err = DialRPC(addr, nil, token)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var info consensus.PeerInfo
+	assert.Nil(t, client.Call("AdminService.AddPeer", "127.0.0.1:11000", &info))
+	assert.Nil(t, client.Call("AdminService.AddPeer", "127.0.0.1:11001", new(consensus.PeerInfo)))
+	assert.Nil(t, client.Call("AdminService.Peers", 0, &peers))
+	assert.Equal(t, 2, len(peers))
+	assert.Equal(t, info, peers[0])
+
+	assert.Nil(t, client.Call("AdminService.DisconnectPeer", info.ID, new(int)))
+	err = client.Call("AdminService.DisconnectPeer", info.ID, new(int))
+	rpcErr, ok := ParseRPCError(err.Error())
+	assert.True(t, ok)
+	assert.Equal(t, CodeNotFound, rpcErr.Code)
+
+	// the default duration is used when the ban does not set it
+	id := peers[1].ID
+	assert.Nil(t, client.Call("AdminService.BanPeer", BanPeerRequest{ID: id}, new(int)))
+	assert.Equal(t, defaultPeerBanDuration, m.banned[id])
+	assert.Nil(t, client.Call("AdminService.BanPeer", BanPeerRequest{ID: id, Duration: time.Minute}, new(int)))
+	assert.Equal(t, time.Minute, m.banned[id])
+	assert.Nil(t, client.Call("AdminService.Peers", 0, &peers))
+	assert.Empty(t, peers)
+
+	// the bans of the networks are listed and removed
+	start := time.Now()
+	assert.Nil(t, client.Call("AdminService.AddBan", AddBanRequest{Net: "10.0.0.0/24", Reason: "spam"}, new(int)))
+	var bans []consensus.Ban
+	assert.Nil(t, client.Call("AdminService.Bans", 0, &bans))
+	assert.Equal(t, 1, len(bans))
+	assert.Equal(t, "10.0.0.0/24", bans[0].Net)
+	assert.Equal(t, "spam", bans[0].Reason)
+	assert.True(t, !bans[0].Until.Before(start.Add(defaultPeerBanDuration)))
+	assert.Nil(t, client.Call("AdminService.RemoveBan", RemoveBanRequest{Net: "10.0.0.0/24"}, new(int)))
+
+	m.net = consensus.NetworkMetrics{Msgs: map[string]consensus.MsgTraffic{"txn": {MsgsIn: 2}}, Inbound: 1}
+	var metrics consensus.NetworkMetrics
+	assert.Nil(t, client.Call("AdminService.NetworkMetrics", 0, &metrics))
+	assert.Equal(t, m.net, metrics)
+	err = client.Call("AdminService.RemoveBan", RemoveBanRequest{Net: "10.0.0.0/24"}, new(int))
+	rpcErr, ok = ParseRPCError(err.Error())
+	assert.True(t, ok)
+	assert.Equal(t, CodeNotFound, rpcErr.Code)
+
+	// the service is not served unless enabled
+	r = NewRPCServer()
+	r.SetAuth(token, nil)
+	h, err = r.Handler()
+	assert.Nil(t, err)
+	disabled := httptest.NewServer(h)
+	defer disabled.Close()
+	c, err := DialRPC(strings.TrimPrefix(disabled.URL, "http://"), nil, token)
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.NotNil(t, c.Call("AdminService.Peers", 0, &peers))
+}
@@ -0,0 +1,199 @@
+package dex
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// ErrNotArchiveNode is returned by the historical queries when the
+// node does not retain the state snapshots.
+var ErrNotArchiveNode = &RPCError{Code: CodeUnsupported, Message: "not an archive node"}
+
+// defaultArchiveSnapshots is the default number of the state
+// snapshots retained by the archive.
+const defaultArchiveSnapshots = 1000
+
+// stateArchive retains the finalized states of every interval
+// rounds, the state of a round in between is derived by replaying the
+// finalized blocks on the nearest retained state before it.
+type stateArchive struct {
+	interval uint64
+	// the maximum number of the retained states, the oldest
+	// ones are dropped
+	max int
+	// the retained rounds in ascending order
+	rounds []uint64
+	states map[uint64]*State
+	// the last state derived by replaying the blocks, the
+	// queries of the same or a later round replay from it
+	replayed      *State
+	replayedRound uint64
+}
+
+func newStateArchive(interval uint64, max int) *stateArchive {
+	if max <= 0 {
+		max = defaultArchiveSnapshots
+	}
+	return &stateArchive{interval: interval, max: max, states: make(map[uint64]*State)}
+}
+
+// add retains the finalized state if the round is a snapshot round.
+// The first finalized state is retained, so the rounds since the
+// node started can be queried until the archive is full.
+func (a *stateArchive) add(round uint64, s *State) {
+	if len(a.rounds) > 0 && (round%a.interval != 0 || round <= a.rounds[len(a.rounds)-1]) {
+		return
+	}
+
+	a.rounds = append(a.rounds, round)
+	a.states[round] = s
+	for len(a.rounds) > a.max {
+		delete(a.states, a.rounds[0])
+		a.rounds = a.rounds[1:]
+	}
+
+	if a.replayed != nil && a.replayedRound < a.rounds[0] {
+		a.replayed = nil
+	}
+}
+
+// at returns the nearest retained state at or before the round, nil
+// if there is none.
+func (a *stateArchive) at(round uint64) (uint64, *State) {
+	i := sort.Search(len(a.rounds), func(i int) bool {
+		return a.rounds[i] > round
+	})
+	if i == 0 {
+		return 0, nil
+	}
+
+	r := a.rounds[i-1]
+	return r, a.states[r]
+}
+
+// replayedAt returns the replayed state if it is derived from the
+// snapshot of snapshotRound and is not after the round.
+func (a *stateArchive) replayedAt(snapshotRound, round uint64) (uint64, *State) {
+	if a.replayed == nil || a.replayedRound <= snapshotRound || a.replayedRound > round {
+		return 0, nil
+	}
+	return a.replayedRound, a.replayed
+}
+
+// SetArchiveInterval retains the finalized state of every interval
+// rounds for the historical queries such as BalanceAt, 0 disables
+// the archive. At most snapshots states are retained, 0 uses the
+// default. It must be called before Start.
+func (r *RPCServer) SetArchiveInterval(interval uint64, snapshots int) {
+	if interval == 0 {
+		r.archive = nil
+		return
+	}
+
+	r.archive = newStateArchive(interval, snapshots)
+}
+
+// BalanceAtRequest is the argument of the BalanceAt RPC.
+type BalanceAtRequest struct {
+	Addr  consensus.Addr
+	Token TokenID
+	Round uint64
+}
+
+// BalanceAtResult is the balance of an account at a finalized round.
+type BalanceAtResult struct {
+	Balance Balance
+	Round   uint64
+	// the round of the retained snapshot that the balance is
+	// derived from, the blocks after it are replayed up to Round.
+	SnapshotRound uint64
+}
+
+// stateAt returns the finalized state of the round, and the round of
+// the snapshot that it is derived from.
+func (r *RPCServer) stateAt(round uint64) (*State, uint64, error) {
+	r.mu.Lock()
+	archive := r.archive
+	finalized := r.finalized
+	finalizedRound := r.finalizedRound
+	var snapshot, replayed *State
+	var snapshotRound, replayedRound uint64
+	if archive != nil {
+		snapshotRound, snapshot = archive.at(round)
+		replayedRound, replayed = archive.replayedAt(snapshotRound, round)
+	}
+	r.mu.Unlock()
+
+	if archive == nil {
+		return nil, 0, ErrNotArchiveNode
+	}
+
+	if finalized == nil {
+		return nil, 0, ErrNotReady
+	}
+
+	if round > finalizedRound {
+		return nil, 0, unknownBlockError(fmt.Sprintf("finalized block of round %d does not exist", round))
+	}
+
+	if snapshot == nil {
+		return nil, 0, unknownBlockError(fmt.Sprintf("state snapshot at or before round %d does not exist", round))
+	}
+
+	s, from := snapshot, snapshotRound
+	if replayed != nil {
+		s, from = replayed, replayedRound
+	}
+
+	for i := from + 1; i <= round; i++ {
+		if r.chain == nil {
+			return nil, 0, ErrNotReady
+		}
+
+		b, ok := r.chain.BlockAtRound(i)
+		if b == nil || !ok {
+			return nil, 0, unknownBlockError(fmt.Sprintf("finalized block of round %d does not exist", i))
+		}
+
+		bp := r.chain.BlockProposal(b.BlockProposal)
+		if bp == nil {
+			return nil, 0, newRPCError(CodeNotFound, "can not replay from the snapshot of round %d, the block proposal of round %d is pruned", snapshotRound, i)
+		}
+
+		next, _, err := s.CommitTxns(bp.Txns, nil, i)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error replaying the block of round %d: %v", i, err)
+		}
+
+		if next.Hash() != b.StateRoot {
+			return nil, 0, fmt.Errorf("the replayed state root of round %d does not match the block's", i)
+		}
+		s = next.(*State)
+	}
+
+	if round > from {
+		r.mu.Lock()
+		if r.archive == archive {
+			archive.replayed, archive.replayedRound = s, round
+		}
+		r.mu.Unlock()
+	}
+	return s, snapshotRound, nil
+}
+
+func (r *RPCServer) balanceAt(req BalanceAtRequest, result *BalanceAtResult) error {
+	s, snapshotRound, err := r.stateAt(req.Round)
+	if err != nil {
+		return err
+	}
+
+	acc := s.Account(req.Addr)
+	if acc == nil {
+		return unknownAccountError(req.Addr)
+	}
+
+	*result = BalanceAtResult{Balance: acc.Balance(req.Token), Round: req.Round, SnapshotRound: snapshotRound}
+	return nil
+}
@@ -0,0 +1,127 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalanceAt(t *testing.T) {
+	market := MarketSymbol{Base: 1, Quote: 0}
+	s := NewState(ethdb.NewMemDatabase())
+	s.SetChainID(testChainID)
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: TokenInfo{Symbol: "XRP", Decimals: 8, TotalUnits: 2000000000}})
+	pkMaker, skMaker := RandKeyPair()
+	pkTaker, skTaker := RandKeyPair()
+	for _, pk := range []PK{pkMaker, pkTaker} {
+		acc := s.NewAccount(pk)
+		acc.UpdateBalance(0, Balance{Available: 1000000000})
+		acc.UpdateBalance(1, Balance{Available: 1000000000})
+	}
+	s.CommitCache()
+	c := &blockTestChain{proposals: make(map[consensus.Hash]*consensus.BlockProposal)}
+	c.blocks = append(c.blocks, &consensus.Block{StateRoot: s.Hash()})
+
+	r := NewRPCServer()
+	r.SetStater(c)
+	var result BalanceAtResult
+	req := BalanceAtRequest{Addr: pkMaker.Addr(), Token: 0, Round: 1}
+	assert.Equal(t, ErrNotArchiveNode, r.balanceAt(req, &result))
+
+	r.SetArchiveInterval(3, 0)
+	assert.Equal(t, ErrNotReady, r.balanceAt(req, &result))
+
+	// the maker and the taker trade in every round, the blocks
+	// are committed the same way as the notaries do
+	states := []*State{s}
+	for round := uint64(1); round <= 7; round++ {
+		body, err := rlp.EncodeToBytes([][]byte{
+			MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 100000000, Price: 100000000, Market: market}, round-1),
+			MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{SellSide: false, Quant: 50000000, Price: 100000000, Market: market}, round-1),
+		})
+		assert.Nil(t, err)
+		bp := &consensus.BlockProposal{Round: round, PrevBlock: c.blocks[round-1].Hash(), Txns: body}
+		next, _, err := s.CommitTxns(body, nil, round)
+		assert.Nil(t, err)
+		s = next.(*State)
+		states = append(states, s)
+		c.proposals[bp.Hash()] = bp
+		c.blocks = append(c.blocks, &consensus.Block{Round: round, StateRoot: s.Hash(), BlockProposal: bp.Hash(), PrevBlock: bp.PrevBlock})
+		c.finalized = len(c.blocks)
+		r.Update(s)
+		r.Finalized(round, s)
+	}
+
+	// the first finalized state and every third round are retained
+	snapshots := []uint64{1, 1, 3, 3, 3, 6, 6}
+	for round := uint64(1); round <= 7; round++ {
+		for _, pk := range []PK{pkMaker, pkTaker} {
+			for _, token := range []TokenID{0, 1} {
+				err := r.balanceAt(BalanceAtRequest{Addr: pk.Addr(), Token: token, Round: round}, &result)
+				assert.Nil(t, err)
+				assert.Equal(t, round, result.Round)
+				assert.Equal(t, snapshots[round-1], result.SnapshotRound)
+				assert.Equal(t, states[round].Account(pk.Addr()).Balance(token), result.Balance)
+			}
+		}
+	}
+
+	// the balances do change across the snapshot boundary
+	assert.Nil(t, r.balanceAt(BalanceAtRequest{Addr: pkMaker.Addr(), Token: 1, Round: 2}, &result))
+	assert.Equal(t, 800000000, int(result.Balance.Available))
+	assert.Equal(t, 100000000, int(result.Balance.Pending))
+	assert.Nil(t, r.balanceAt(BalanceAtRequest{Addr: pkMaker.Addr(), Token: 1, Round: 4}, &result))
+	assert.Equal(t, 600000000, int(result.Balance.Available))
+	assert.Equal(t, 200000000, int(result.Balance.Pending))
+
+	err := r.balanceAt(BalanceAtRequest{Addr: pkMaker.Addr(), Round: 0}, &result)
+	assert.IsType(t, unknownBlockError(""), err)
+	err = r.balanceAt(BalanceAtRequest{Addr: pkMaker.Addr(), Round: 8}, &result)
+	assert.IsType(t, unknownBlockError(""), err)
+	unknown, _ := RandKeyPair()
+	err = r.balanceAt(BalanceAtRequest{Addr: unknown.Addr(), Round: 2}, &result)
+	assert.IsType(t, unknownAccountError{}, err)
+
+	// replaying needs the block proposals after the snapshot
+	delete(c.proposals, c.blocks[5].BlockProposal)
+	assert.NotNil(t, r.balanceAt(BalanceAtRequest{Addr: pkMaker.Addr(), Round: 5}, &result))
+	assert.Nil(t, r.balanceAt(BalanceAtRequest{Addr: pkMaker.Addr(), Round: 4}, &result))
+
+	// the last replayed state is reused, the block proposal
+	// before it is no longer needed
+	assert.Equal(t, uint64(4), r.archive.replayedRound)
+	delete(c.proposals, c.blocks[4].BlockProposal)
+	assert.Nil(t, r.balanceAt(BalanceAtRequest{Addr: pkMaker.Addr(), Round: 4}, &result))
+	assert.Equal(t, states[4].Account(pkMaker.Addr()).Balance(0), result.Balance)
+	assert.Nil(t, r.balanceAt(BalanceAtRequest{Addr: pkMaker.Addr(), Round: 3}, &result))
+}
+
+func TestStateArchiveRetention(t *testing.T) {
+	a := newStateArchive(2, 3)
+	s := NewState(ethdb.NewMemDatabase())
+	for round := uint64(1); round <= 10; round++ {
+		a.add(round, s)
+	}
+
+	// the oldest states are dropped
+	assert.Equal(t, []uint64{6, 8, 10}, a.rounds)
+	assert.Equal(t, 3, len(a.states))
+	_, st := a.at(5)
+	assert.Nil(t, st)
+	r, st := a.at(7)
+	assert.Equal(t, uint64(6), r)
+	assert.NotNil(t, st)
+
+	// the replayed state of a dropped snapshot is dropped
+	a.replayed, a.replayedRound = s, 9
+	a.add(12, s)
+	assert.NotNil(t, a.replayed)
+	a.add(14, s)
+	assert.Nil(t, a.replayed)
+
+	assert.Equal(t, defaultArchiveSnapshots, newStateArchive(2, 0).max)
+}
@@ -0,0 +1,250 @@
+package dex
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/http"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// callCheck checks if the client of the HTTP request can call the
+// method, the method is the name of a WalletService method, or empty
+// for the REST and the WebSocket requests.
+type callCheck func(req *http.Request, method string) error
+
+// mutatingMethods are the methods that change the state of the node
+// or the chain, the others are read-only.
+var mutatingMethods = map[string]bool{
+	"SendTxn":     true,
+	"SendTxnV2":   true,
+	"SendTxnWait": true,
+}
+
+// isMutatingMethod returns true if the method changes the state, the
+// method can be prefixed by the service name.
+func isMutatingMethod(method string) bool {
+	return mutatingMethods[strings.TrimPrefix(method, "WalletService.")]
+}
+
+// checkAll returns the callCheck that passes if all the checks pass,
+// it returns nil if there is no check.
+func checkAll(checks []callCheck) callCheck {
+	if len(checks) == 0 {
+		return nil
+	}
+
+	return func(req *http.Request, method string) error {
+		for _, c := range checks {
+			if err := c(req, method); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// callCheck returns the checks of the calls: the rate limit, the
+// client certificate and the auth, it returns nil if none is set.
+func (r *RPCServer) callCheck() callCheck {
+	var checks []callCheck
+	if r.limiter != nil {
+		checks = append(checks, r.limiter.check)
+	}
+	if r.requireClientCert {
+		checks = append(checks, checkClientCert)
+	}
+	if r.auth != nil {
+		checks = append(checks, r.auth.check)
+	}
+	return checkAll(checks)
+}
+
+// CheckCall checks if the client can call the WalletService method
+// over another transport, the same way as the calls served by
+// Handler are checked. req carries the client's address, its TLS
+// connection state and the Authorization header.
+func (r *RPCServer) CheckCall(req *http.Request, method string) error {
+	check := r.callCheck()
+	if check == nil {
+		return nil
+	}
+	return check(req, method)
+}
+
+// middleware checks the requests before they reach the handler,
+// check can be nil. The net/rpc and the JSON-RPC calls are checked
+// one by one according to their methods, since a net/rpc connection
+// and a JSON-RPC batch carry many calls. The JSON-RPC handler checks
+// and observes its calls itself, the net/rpc calls are passed to
+// observe.
+func middleware(h http.Handler, rpcServer *rpc.Server, jsonRPCPath string, check callCheck, observe func(finishedCall)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == rpc.DefaultRPCPath && req.Method == http.MethodConnect:
+			serveRPCConn(w, req, rpcServer, check, observe)
+		case check == nil || jsonRPCPath != "" && req.URL.Path == jsonRPCPath:
+			h.ServeHTTP(w, req)
+		default:
+			if err := check(req, ""); err != nil {
+				if err == ErrRateLimited {
+					w.Header().Set("Retry-After", "1")
+				}
+				writeRESTError(w, restStatus(err), err)
+				return
+			}
+			h.ServeHTTP(w, req)
+		}
+	})
+}
+
+// serveRPCConn serves a net/rpc connection the same way as
+// rpc.Server.ServeHTTP does, with a codec that rejects the calls
+// that do not pass the check and observes the calls.
+func serveRPCConn(w http.ResponseWriter, req *http.Request, s *rpc.Server, check callCheck, observe func(finishedCall)) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "the connection can not be hijacked", http.StatusInternalServerError)
+		return
+	}
+
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		log.Error("error hijacking rpc connection", "err", err)
+		return
+	}
+
+	// the response expected by rpc.DialHTTP
+	_, err = io.WriteString(conn, "HTTP/1.0 "+rpcConnected+"\n\n")
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	written := &countingWriter{w: conn}
+	buf := bufio.NewWriter(written)
+	s.ServeCodec(&rpcServerCodec{
+		rwc:     conn,
+		dec:     gob.NewDecoder(conn),
+		enc:     gob.NewEncoder(buf),
+		encBuf:  buf,
+		written: written,
+		req:     req,
+		check:   check,
+		observe: observe,
+		started: make(map[uint64]time.Time),
+	})
+}
+
+// rpcServerCodec is the gob codec of net/rpc. When a call does not
+// pass the check, its body is discarded and ReadRequestBody returns
+// the error of the check, which rpc.Server sends back as the call's
+// error and keeps serving the connection.
+type rpcServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	// counts the bytes of the responses
+	written *countingWriter
+	closed  bool
+
+	// the CONNECT request of the connection
+	req   *http.Request
+	check callCheck
+	err   error
+
+	observe func(finishedCall)
+	// the start time of the pending calls by the sequence
+	// number, the responses are written concurrently with the
+	// requests being read.
+	mu      sync.Mutex
+	started map[uint64]time.Time
+}
+
+func (c *rpcServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.dec.Decode(r)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.started[r.Seq] = time.Now()
+	c.mu.Unlock()
+
+	if c.check != nil {
+		c.err = c.check(c.req, r.ServiceMethod)
+	}
+	return nil
+}
+
+func (c *rpcServerCodec) ReadRequestBody(body interface{}) error {
+	if c.err != nil {
+		err := c.err
+		c.err = nil
+		// the zero value discards the body
+		if derr := c.dec.DecodeValue(reflect.Value{}); derr != nil {
+			return derr
+		}
+		return err
+	}
+
+	return c.dec.Decode(body)
+}
+
+func (c *rpcServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mu.Lock()
+	start, ok := c.started[r.Seq]
+	delete(c.started, r.Seq)
+	c.mu.Unlock()
+	if ok && c.observe != nil {
+		call := finishedCall{protocol: "rpc", method: r.ServiceMethod, remote: c.req.RemoteAddr, duration: time.Since(start)}
+		if r.Error != "" {
+			call.err = errors.New(r.Error)
+		}
+		// the responses are written one at a time
+		before := c.written.n
+		defer func() {
+			call.size = c.written.n - before
+			c.observe(call)
+		}()
+	}
+
+	err := c.enc.Encode(r)
+	if err != nil {
+		if c.encBuf.Flush() == nil {
+			// the response can not be encoded, close the
+			// connection since the client is out of sync.
+			log.Error("error encoding rpc response", "err", err)
+			c.Close()
+		}
+		return err
+	}
+
+	err = c.enc.Encode(body)
+	if err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Error("error encoding rpc response body", "err", err)
+			c.Close()
+		}
+		return err
+	}
+
+	return c.encBuf.Flush()
+}
+
+func (c *rpcServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	return c.rwc.Close()
+}
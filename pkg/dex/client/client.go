@@ -0,0 +1,480 @@
+// Package client is the Go client of the node's wallet RPC service.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/helinwang/dex/pkg/dex"
+)
+
+// Config is the config of the connection to the node.
+type Config struct {
+	// TLS is the TLS config of the connection, nil connects in
+	// plain HTTP.
+	TLS *tls.Config
+	// Token is the bearer token of the authenticated methods,
+	// empty sends no token.
+	Token string
+	// Timeout is the timeout of a call whose context does not
+	// have a deadline, 0 means no timeout.
+	Timeout time.Duration
+}
+
+// The errors of the codes of the wallet RPC errors. The errors
+// returned by the server are *dex.RPCError, they match the error of
+// their code with errors.Is, e.g., errors.Is(err, ErrNotSynced).
+var (
+	ErrInternal        = &dex.RPCError{Code: dex.CodeInternal}
+	ErrNotSynced       = &dex.RPCError{Code: dex.CodeNotSynced}
+	ErrAccountNotFound = &dex.RPCError{Code: dex.CodeAccountNotFound}
+	ErrInvalidTxn      = &dex.RPCError{Code: dex.CodeInvalidTxn}
+	ErrRateLimited     = &dex.RPCError{Code: dex.CodeRateLimited}
+	ErrUnauthorized    = &dex.RPCError{Code: dex.CodeUnauthorized}
+	ErrNotFound        = &dex.RPCError{Code: dex.CodeNotFound}
+	ErrInvalidRequest  = &dex.RPCError{Code: dex.CodeInvalidRequest}
+	ErrUnsupported     = &dex.RPCError{Code: dex.CodeUnsupported}
+)
+
+// unwrap returns the *dex.RPCError of the error returned by the
+// server, the other errors, e.g., the connection errors, are returned
+// unchanged.
+func unwrap(err error) error {
+	e, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+
+	if re, ok := dex.ParseRPCError(string(e)); ok {
+		return re
+	}
+	return err
+}
+
+type conn struct {
+	net.Conn
+	c *rpc.Client
+}
+
+// Client is a client of the wallet RPC service, it is safe for
+// concurrent use. The client connects to the node on the first call,
+// a call that fails since the connection is broken is retried once
+// over a new connection. Retrying is safe since the wallet calls are
+// idempotent, a resent txn is a duplicate in the pool.
+//
+// net/rpc does not support cancelling a call, a call is cancelled
+// by expiring the connection when its context is done, which fails
+// the other pending calls of the connection as well.
+type Client struct {
+	addr string
+	cfg  Config
+
+	mu     sync.Mutex
+	conn   *conn
+	closed bool
+}
+
+// Dial connects to the wallet service at the address.
+func Dial(ctx context.Context, addr string, cfg Config) (*Client, error) {
+	c := New(addr, cfg)
+	_, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// New returns the client of the wallet service at the address, it
+// connects on the first call.
+func New(addr string, cfg Config) *Client {
+	return &Client{addr: addr, cfg: cfg}
+}
+
+func (c *Client) connect(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, rpc.ErrShutdown
+	}
+
+	if c.conn != nil {
+		return c.conn, nil
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshakes do not take a context
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+
+	if c.cfg.TLS != nil {
+		tc := tls.Client(nc, c.cfg.TLS)
+		err = tc.Handshake()
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+
+	rc, err := dex.NewRPCClient(nc, c.cfg.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	nc.SetDeadline(time.Time{})
+	c.conn = &conn{Conn: nc, c: rc}
+	return c.conn, nil
+}
+
+// drop closes the connection, the next call reconnects.
+func (c *Client) drop(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == cn {
+		c.conn = nil
+	}
+	cn.c.Close()
+}
+
+// Close closes the connection, the client can not be used after it
+// is closed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.c.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *Client) call(ctx context.Context, method string, args, reply interface{}) error {
+	return c.callService(ctx, "WalletService."+method, args, reply)
+}
+
+// callService calls the method of the service, the method is in the
+// form of "Service.Method".
+func (c *Client) callService(ctx context.Context, method string, args, reply interface{}) error {
+	if _, ok := ctx.Deadline(); !ok && c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	for retried := false; ; retried = true {
+		cn, err := c.connect(ctx)
+		if err != nil {
+			return err
+		}
+
+		call := cn.c.Go(method, args, reply, make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+		case <-ctx.Done():
+			// fail the call, and wait for it so that the reply
+			// is no longer written.
+			cn.SetDeadline(time.Now())
+			<-call.Done
+			c.drop(cn)
+			return ctx.Err()
+		}
+
+		switch call.Error.(type) {
+		case nil:
+			return nil
+		case rpc.ServerError:
+			return unwrap(call.Error)
+		}
+
+		// the connection is broken, the call is retried once
+		// over a new connection.
+		c.drop(cn)
+		if retried {
+			return call.Error
+		}
+	}
+}
+
+// WalletState returns the balances and the pending orders of the
+// account.
+func (c *Client) WalletState(ctx context.Context, addr consensus.Addr) (dex.WalletState, error) {
+	var w dex.WalletState
+	err := c.call(ctx, "WalletState", addr, &w)
+	return w, err
+}
+
+// Orders returns a page of the account's pending orders.
+func (c *Client) Orders(ctx context.Context, req dex.OrdersRequest) (dex.OrdersResponse, error) {
+	var resp dex.OrdersResponse
+	err := c.call(ctx, "Orders", req, &resp)
+	return resp, err
+}
+
+// Tokens returns the tokens of the chain.
+func (c *Client) Tokens(ctx context.Context) ([]dex.Token, error) {
+	var t dex.TokenState
+	err := c.call(ctx, "Tokens", 0, &t)
+	return t.Tokens, err
+}
+
+// TokenBySymbol returns the token of the symbol, the symbol is
+// case-insensitive.
+func (c *Client) TokenBySymbol(ctx context.Context, symbol string) (dex.Token, error) {
+	var t dex.Token
+	err := c.call(ctx, "TokenBySymbol", symbol, &t)
+	return t, err
+}
+
+// ProveBalance returns the proof of the account's balance of the
+// token.
+func (c *Client) ProveBalance(ctx context.Context, addr consensus.Addr, token dex.TokenID) (dex.Proof, error) {
+	var p dex.Proof
+	err := c.call(ctx, "ProveBalance", dex.BalanceProofArg{Addr: addr, Token: token}, &p)
+	return p, err
+}
+
+// ProveOrder returns the proof of the resting order.
+func (c *Client) ProveOrder(ctx context.Context, id dex.OrderID) (dex.Proof, error) {
+	var p dex.Proof
+	err := c.call(ctx, "ProveOrder", dex.OrderProofArg{ID: id}, &p)
+	return p, err
+}
+
+// OrderBook returns at most levels price levels of each side of the
+// market's order book, 0 means the cap of the server.
+func (c *Client) OrderBook(ctx context.Context, market dex.MarketSymbol, levels int) (dex.OrderBookResponse, error) {
+	var resp dex.OrderBookResponse
+	err := c.call(ctx, "OrderBook", dex.OrderBookRequest{Market: market, Levels: levels}, &resp)
+	return resp, err
+}
+
+// Markets returns the markets that ever had an order.
+func (c *Client) Markets(ctx context.Context) ([]dex.MarketInfo, error) {
+	var m []dex.MarketInfo
+	err := c.call(ctx, "Markets", 0, &m)
+	return m, err
+}
+
+// Order returns the status of the order of the ID in the market.
+func (c *Client) Order(ctx context.Context, market dex.MarketSymbol, id uint64) (dex.OrderResult, error) {
+	var r dex.OrderResult
+	err := c.call(ctx, "Order", dex.OrderRequest{Market: market, ID: id}, &r)
+	return r, err
+}
+
+// Trades returns the recent trades of the market, newest first.
+func (c *Client) Trades(ctx context.Context, req dex.TradesRequest) (dex.TradesResponse, error) {
+	var resp dex.TradesResponse
+	err := c.call(ctx, "Trades", req, &resp)
+	return resp, err
+}
+
+// DiffStates returns the differences between the states of the two
+// blocks.
+func (c *Client) DiffStates(ctx context.Context, arg dex.DiffStatesArg) ([]dex.KeyDiff, error) {
+	var diffs []dex.KeyDiff
+	err := c.call(ctx, "DiffStates", arg, &diffs)
+	return diffs, err
+}
+
+// SendTxn sends the signed txn. A txn rejected by the pool is not an
+// error, the result has the reason.
+func (c *Client) SendTxn(ctx context.Context, txn []byte) (dex.SendTxnResult, error) {
+	var r dex.SendTxnResult
+	err := c.call(ctx, "SendTxnV2", txn, &r)
+	return r, err
+}
+
+// SendTxnWait sends the signed txn, and if it is accepted, waits for
+// at most waitRounds rounds for it to be included in a block. The
+// result's Status tells if the txn is included or still pending.
+// The server waits at most a minute, the context should allow for
+// it.
+func (c *Client) SendTxnWait(ctx context.Context, txn []byte, waitRounds uint) (dex.SendTxnResult, error) {
+	var r dex.SendTxnResult
+	err := c.call(ctx, "SendTxnWait", dex.SendTxnRequest{Txn: txn, WaitRounds: waitRounds}, &r)
+	return r, err
+}
+
+// Nonce returns the next nonce of the account.
+func (c *Client) Nonce(ctx context.Context, addr consensus.Addr) (uint64, error) {
+	var n uint64
+	err := c.call(ctx, "Nonce", addr, &n)
+	return n, err
+}
+
+// NonceSlots returns count distinct nonces of the account for the
+// clients of the token that submit txns concurrently.
+func (c *Client) NonceSlots(ctx context.Context, addr consensus.Addr, count int, token string) ([]uint64, error) {
+	var slots []uint64
+	err := c.call(ctx, "NonceSlots", dex.NonceSlotsArg{Addr: addr, Count: count, Token: token}, &slots)
+	return slots, err
+}
+
+// TxnStatus returns the status of the txn with the given hash.
+func (c *Client) TxnStatus(ctx context.Context, hash consensus.Hash) (dex.TxnStatusResult, error) {
+	var r dex.TxnStatusResult
+	err := c.call(ctx, "TxnStatus", hash, &r)
+	return r, err
+}
+
+// PendingTxns returns the account's txns in the pool.
+func (c *Client) PendingTxns(ctx context.Context, addr consensus.Addr) ([]dex.PendingTxnInfo, error) {
+	var txns []dex.PendingTxnInfo
+	err := c.call(ctx, "PendingTxns", addr, &txns)
+	return txns, err
+}
+
+// Block returns the block of the hash or the round.
+func (c *Client) Block(ctx context.Context, req dex.BlockRequest) (dex.BlockResponse, error) {
+	var resp dex.BlockResponse
+	err := c.call(ctx, "Block", req, &resp)
+	return resp, err
+}
+
+// RawBlock returns the RLP encoded finalized block of the round, and
+// its encoded block proposal while the node retains it.
+func (c *Client) RawBlock(ctx context.Context, round uint64) (dex.RawBlockResponse, error) {
+	var resp dex.RawBlockResponse
+	err := c.call(ctx, "RawBlock", dex.RawBlockRequest{Round: round}, &resp)
+	return resp, err
+}
+
+// BalanceAt returns the balance of the account at the finalized
+// round, the node must retain the state snapshots.
+func (c *Client) BalanceAt(ctx context.Context, addr consensus.Addr, token dex.TokenID, round uint64) (dex.BalanceAtResult, error) {
+	var result dex.BalanceAtResult
+	err := c.call(ctx, "BalanceAt", dex.BalanceAtRequest{Addr: addr, Token: token, Round: round}, &result)
+	return result, err
+}
+
+// EstimateFill estimates the fills of the order against the current
+// order book without placing it.
+func (c *Client) EstimateFill(ctx context.Context, req dex.EstimateFillRequest) (dex.FillEstimate, error) {
+	var est dex.FillEstimate
+	err := c.call(ctx, "EstimateFill", req, &est)
+	return est, err
+}
+
+// BlockProposal returns the raw block proposal while the node
+// retains it.
+func (c *Client) BlockProposal(ctx context.Context, hash consensus.Hash) (consensus.BlockProposal, error) {
+	var bp consensus.BlockProposal
+	err := c.call(ctx, "BlockProposal", hash, &bp)
+	return bp, err
+}
+
+// Round returns the round of the node's state.
+func (c *Client) Round(ctx context.Context) (uint64, error) {
+	var r uint64
+	err := c.call(ctx, "Round", 0, &r)
+	return r, err
+}
+
+// ChainStatus returns the status of the node's chain.
+func (c *Client) ChainStatus(ctx context.Context) (consensus.ChainStatus, error) {
+	var s consensus.ChainStatus
+	err := c.call(ctx, "ChainStatus", 0, &s)
+	return s, err
+}
+
+// NodeInfo returns the chain status with the node's peers and
+// syncing status.
+func (c *Client) NodeInfo(ctx context.Context) (dex.NodeInfo, error) {
+	var info dex.NodeInfo
+	err := c.call(ctx, "NodeInfo", 0, &info)
+	return info, err
+}
+
+// Graphviz returns the chain visualization in the Graphviz format.
+func (c *Client) Graphviz(ctx context.Context, req dex.GraphvizRequest) (string, error) {
+	var g string
+	err := c.call(ctx, "Graphviz", req, &g)
+	return g, err
+}
+
+// TxnPoolSize returns the number of the txns in the pool.
+func (c *Client) TxnPoolSize(ctx context.Context) (int, error) {
+	var size int
+	err := c.call(ctx, "TxnPoolSize", 0, &size)
+	return size, err
+}
+
+// PoolStats returns the metrics of the txn pool.
+func (c *Client) PoolStats(ctx context.Context) (dex.PoolStats, error) {
+	var stats dex.PoolStats
+	err := c.call(ctx, "PoolStats", 0, &stats)
+	return stats, err
+}
+
+// Peers returns the connected peers of the node, it is a call of the
+// AdminService, which requires the auth token.
+func (c *Client) Peers(ctx context.Context) ([]consensus.PeerInfo, error) {
+	var peers []consensus.PeerInfo
+	err := c.callService(ctx, "AdminService.Peers", 0, &peers)
+	return peers, err
+}
+
+// AddPeer connects the node to the peer accepting the connections on
+// the address.
+func (c *Client) AddPeer(ctx context.Context, addr string) (consensus.PeerInfo, error) {
+	var info consensus.PeerInfo
+	err := c.callService(ctx, "AdminService.AddPeer", addr, &info)
+	return info, err
+}
+
+// DisconnectPeer closes the node's connections to the peer.
+func (c *Client) DisconnectPeer(ctx context.Context, id consensus.Addr) error {
+	return c.callService(ctx, "AdminService.DisconnectPeer", id, new(int))
+}
+
+// BanPeer disconnects the peer and refuses its connections for the
+// duration, 0 means the node's default duration.
+func (c *Client) BanPeer(ctx context.Context, id consensus.Addr, d time.Duration) error {
+	return c.callService(ctx, "AdminService.BanPeer", dex.BanPeerRequest{ID: id, Duration: d}, new(int))
+}
+
+// AddBan disconnects the peer or the hosts in the network, and
+// refuses their connections for the duration, 0 means the node's
+// default duration. The ban is kept across the restarts of the node.
+func (c *Client) AddBan(ctx context.Context, req dex.AddBanRequest) error {
+	return c.callService(ctx, "AdminService.AddBan", req, new(int))
+}
+
+// RemoveBan removes the ban of the peer or the network.
+func (c *Client) RemoveBan(ctx context.Context, req dex.RemoveBanRequest) error {
+	return c.callService(ctx, "AdminService.RemoveBan", req, new(int))
+}
+
+// Bans returns the bans of the node not expired.
+func (c *Client) Bans(ctx context.Context) ([]consensus.Ban, error) {
+	var bans []consensus.Ban
+	err := c.callService(ctx, "AdminService.Bans", 0, &bans)
+	return bans, err
+}
+
+// NetworkMetrics returns the traffic of the node aggregated over the
+// peers.
+func (c *Client) NetworkMetrics(ctx context.Context) (consensus.NetworkMetrics, error) {
+	var m consensus.NetworkMetrics
+	err := c.callService(ctx, "AdminService.NetworkMetrics", 0, &m)
+	return m, err
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/helinwang/dex/pkg/dex"
+	"github.com/stretchr/testify/assert"
+)
+
+var testChainID = consensus.Hash{1}
+
+type nopSender struct{}
+
+func (nopSender) SendTxn([]byte)      {}
+func (nopSender) BroadcastTxn([]byte) {}
+
+func newTestServer(t *testing.T) (*httptest.Server, *dex.RPCServer, dex.PK, dex.SK) {
+	s := dex.NewState(ethdb.NewMemDatabase())
+	s.SetChainID(testChainID)
+	s.UpdateToken(dex.Token{ID: 0, TokenInfo: dex.BNBInfo})
+	s.UpdateToken(dex.Token{ID: 1, TokenInfo: dex.TokenInfo{Symbol: "XRP", Decimals: 8, TotalUnits: 1000}})
+	pk, sk := dex.RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, dex.Balance{Available: 100})
+	acc.UpdateBalance(1, dex.Balance{Available: 100})
+	s.CommitCache()
+
+	pool := dex.NewTxnPool(s)
+	pool.Update(s)
+	r := dex.NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	return httptest.NewServer(h), r, pk, sk
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	srv, _, pk, sk := newTestServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c, err := Dial(ctx, strings.TrimPrefix(srv.URL, "http://"), Config{Timeout: time.Second})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	tokens, err := c.Tokens(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tokens))
+
+	token, err := c.TokenBySymbol(ctx, "xrp")
+	assert.Nil(t, err)
+	assert.Equal(t, dex.TokenID(1), token.ID)
+
+	w, err := c.WalletState(ctx, pk.Addr())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(w.Balances))
+
+	nonce, err := c.Nonce(ctx, pk.Addr())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), nonce)
+
+	market := dex.MarketSymbol{Base: 1, Quote: 0}
+	txn := dex.MakePlaceOrderTxn(sk, testChainID, pk.Addr(), dex.PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market}, nonce)
+	r, err := c.SendTxn(ctx, txn)
+	assert.Nil(t, err)
+	assert.True(t, r.Accepted, r.Reason)
+
+	status, err := c.TxnStatus(ctx, r.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, dex.TxnPending, status.Status)
+
+	book, err := c.OrderBook(ctx, market, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, book.Asks)
+}
+
+func TestClientErrors(t *testing.T) {
+	srv, _, _, _ := newTestServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := New(strings.TrimPrefix(srv.URL, "http://"), Config{})
+	defer c.Close()
+
+	unknown, _ := dex.RandKeyPair()
+	_, err := c.WalletState(ctx, unknown.Addr())
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+	assert.True(t, dex.IsNotFound(err))
+
+	_, err = c.Order(ctx, dex.MarketSymbol{Base: 1, Quote: 0}, 7)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = c.OrderBook(ctx, dex.MarketSymbol{Base: 1, Quote: 1}, 10)
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+
+	// a rejected txn is not an error, the reason is the message
+	sent, err := c.SendTxn(ctx, []byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.False(t, sent.Accepted)
+	assert.NotContains(t, sent.Reason, "InvalidTxn")
+
+	_, err = c.BalanceAt(ctx, unknown.Addr(), 0, 1)
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	assert.True(t, errors.Is(err, dex.ErrNotArchiveNode))
+
+	// the server has no state yet
+	h, err := dex.NewRPCServer().Handler()
+	assert.Nil(t, err)
+	notReady := httptest.NewServer(h)
+	defer notReady.Close()
+	c = New(strings.TrimPrefix(notReady.URL, "http://"), Config{})
+	defer c.Close()
+	_, err = c.WalletState(ctx, unknown.Addr())
+	assert.True(t, errors.Is(err, ErrNotSynced))
+	assert.True(t, errors.Is(err, dex.ErrNotReady))
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
+
+func TestClientReconnect(t *testing.T) {
+	srv, _, _, _ := newTestServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c, err := Dial(ctx, strings.TrimPrefix(srv.URL, "http://"), Config{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		srv.CloseClientConnections()
+		_, err = c.Tokens(ctx)
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, c.Close())
+	_, err = c.Tokens(ctx)
+	assert.NotNil(t, err)
+}
+
+// hangingServer completes the net/rpc handshake and never responds
+// to the calls.
+func hangingServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+
+				io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestClientTimeout(t *testing.T) {
+	l := hangingServer(t)
+	defer l.Close()
+
+	c, err := Dial(context.Background(), l.Addr().String(), Config{Timeout: 50 * time.Millisecond})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	start := time.Now()
+	_, err = c.Round(context.Background())
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err = c.ChainStatus(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,106 @@
+package dex
+
+import (
+	"errors"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSMaxAge is how long the browsers cache a preflight
+// response when CORSConfig.MaxAge is not set.
+const defaultCORSMaxAge = 10 * time.Minute
+
+// CORSConfig is the cross-origin access of the JSON-RPC and REST
+// endpoints from the browsers. The net/rpc endpoint is not affected.
+type CORSConfig struct {
+	// the origins allowed to call the endpoints, e.g.,
+	// "https://wallet.example.com". "*" allows any origin, it is
+	// not allowed when the auth is enabled.
+	AllowedOrigins []string
+	// how long the browsers cache a preflight response, 0 means
+	// the default.
+	MaxAge time.Duration
+}
+
+// corsHandler adds the CORS headers to the responses of the requests
+// from the allowed origins, and answers the preflight requests before
+// they reach the call checks, since a preflight carries neither the
+// Authorization header nor a call.
+type corsHandler struct {
+	h        http.Handler
+	origins  map[string]bool
+	wildcard bool
+	maxAge   string
+}
+
+func newCORSHandler(h http.Handler, cfg CORSConfig, auth bool) (*corsHandler, error) {
+	c := &corsHandler{h: h, origins: make(map[string]bool)}
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			if auth {
+				return nil, errors.New("the wildcard CORS origin can not be used when the auth is enabled")
+			}
+
+			c.wildcard = true
+			continue
+		}
+		c.origins[strings.TrimSuffix(o, "/")] = true
+	}
+
+	maxAge := cfg.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultCORSMaxAge
+	}
+	c.maxAge = strconv.Itoa(int(maxAge / time.Second))
+	return c, nil
+}
+
+func (c *corsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if origin == "" || req.URL.Path == rpc.DefaultRPCPath {
+		c.h.ServeHTTP(w, req)
+		return
+	}
+
+	preflight := req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if !c.wildcard && !c.origins[origin] {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// the response is served without the CORS headers,
+		// the browser does not pass it to the page.
+		c.h.ServeHTTP(w, req)
+		return
+	}
+
+	if c.wildcard {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", "Retry-After")
+		c.h.ServeHTTP(w, req)
+		return
+	}
+
+	h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST")
+	h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	h.Set("Access-Control-Max-Age", c.maxAge)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetCORS allows the browsers to call the JSON-RPC and REST endpoints
+// from the origins, it must be called before Start. By default no
+// cross-origin access is allowed.
+func (r *RPCServer) SetCORS(cfg CORSConfig) {
+	r.cors = &cfg
+}
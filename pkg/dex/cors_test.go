@@ -0,0 +1,115 @@
+package dex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testOrigin = "https://wallet.example.com"
+
+func corsRequest(t *testing.T, method, url, origin string, preflight bool) *http.Response {
+	var body *strings.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(`{"jsonrpc":"2.0","method":"Round","id":1}`)
+	} else {
+		body = strings.NewReader("")
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	assert.Nil(t, err)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORS(t *testing.T) {
+	r := NewRPCServer()
+	r.SetStater(&restTestChain{})
+	r.SetCORS(CORSConfig{AllowedOrigins: []string{testOrigin}})
+	r.SetAuth("secret", nil)
+	r.SetRateLimit(RateLimitConfig{Read: RateLimit{Rate: 1, Burst: 1}})
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	url := srv.URL + DefaultJSONRPCPath
+
+	// the preflights are answered before the rate limit and the
+	// auth checks
+	for i := 0; i < 3; i++ {
+		resp := corsRequest(t, http.MethodOptions, url, testOrigin, true)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, testOrigin, resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, resp.Header.Get("Access-Control-Allow-Methods"), http.MethodPost)
+		assert.Contains(t, resp.Header.Get("Access-Control-Allow-Headers"), "Authorization")
+		assert.Equal(t, "600", resp.Header.Get("Access-Control-Max-Age"))
+		assert.Equal(t, "Origin", resp.Header.Get("Vary"))
+	}
+
+	resp := corsRequest(t, http.MethodOptions, url, "https://evil.example.com", true)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	resp = corsRequest(t, http.MethodPost, url, testOrigin, false)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, testOrigin, resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Methods"))
+
+	// the disallowed origin is served, but without the headers
+	// the browser does not pass the response to the page
+	resp = corsRequest(t, http.MethodGet, srv.URL+"/round", "https://evil.example.com", false)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	// the rate limited response is readable by the allowed page
+	resp = corsRequest(t, http.MethodGet, srv.URL+"/round", testOrigin, false)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, testOrigin, resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Retry-After", resp.Header.Get("Access-Control-Expose-Headers"))
+
+	// the requests without an origin are not cross-origin
+	resp = corsRequest(t, http.MethodPost, url, "", false)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSWildcard(t *testing.T) {
+	r := NewRPCServer()
+	r.SetCORS(CORSConfig{AllowedOrigins: []string{"*"}})
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := corsRequest(t, http.MethodOptions, srv.URL+DefaultJSONRPCPath, testOrigin, true)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	// no wildcard when the auth is enabled
+	r.SetAuth("secret", nil)
+	_, err = r.Handler()
+	assert.NotNil(t, err)
+}
+
+func TestCORSDisabled(t *testing.T) {
+	r := NewRPCServer()
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := corsRequest(t, http.MethodOptions, srv.URL+DefaultJSONRPCPath, testOrigin, true)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,89 @@
+package dex
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// StateEvents are the changes made by the transition that produced
+// a state. They are kept in memory only, the subscribers are
+// notified from them rather than by re-reading the state.
+type StateEvents struct {
+	Accounts     []AccountEvent
+	Trades       []TradeEvent
+	ClosedOrders []ClosedOrderEvent
+}
+
+// AccountEvent is the change of an account in a round.
+type AccountEvent struct {
+	Addr consensus.Addr
+	// the balances of the account, only set when the balances
+	// are changed.
+	Balances []UserBalance
+	// the execution reports of the account's orders.
+	ExecutionReports []ExecutionReport
+}
+
+// TradeEvent is a trade of a market, the side is the taker's side.
+type TradeEvent struct {
+	Market   MarketSymbol
+	Price    uint64
+	Quant    uint64
+	SellSide bool
+	// the hash of the txn that placed the taker order, zero for
+	// the triggered stop orders.
+	TxnHash consensus.Hash
+}
+
+// ClosedOrderEvent is an order that is filled, cancelled or
+// expired, it is no longer in the owner's pending orders.
+type ClosedOrderEvent struct {
+	Order  PendingOrder
+	Status OrderStatus
+}
+
+// RoundEvent is a finalized round.
+type RoundEvent struct {
+	Round     uint64
+	StateRoot consensus.Hash
+}
+
+// events returns the events of the transition, it must be called
+// before the account cache is committed.
+func (t *Transition) events() *StateEvents {
+	t.state.mu.Lock()
+	accounts := t.state.cachedAccounts()
+	t.state.mu.Unlock()
+
+	var r StateEvents
+	for _, acc := range accounts {
+		reports := t.reports[acc.addr]
+		if !acc.balanceDirty && len(reports) == 0 {
+			continue
+		}
+
+		e := AccountEvent{Addr: acc.addr, ExecutionReports: reports}
+		if acc.balanceDirty {
+			for id, b := range acc.balances {
+				if b.Empty() {
+					continue
+				}
+
+				e.Balances = append(e.Balances, UserBalance{Token: id, Balance: b})
+			}
+			sort.Slice(e.Balances, func(i, j int) bool {
+				return e.Balances[i].Token < e.Balances[j].Token
+			})
+		}
+		r.Accounts = append(r.Accounts, e)
+	}
+
+	sort.Slice(r.Accounts, func(i, j int) bool {
+		return bytes.Compare(r.Accounts[i].Addr[:], r.Accounts[j].Addr[:]) < 0
+	})
+	r.Trades = t.trades
+	r.ClosedOrders = t.closed
+	return &r
+}
@@ -0,0 +1,111 @@
+package dex
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// EstimateFillRequest is the argument of the EstimateFill RPC.
+type EstimateFillRequest struct {
+	Market   MarketSymbol
+	SellSide bool
+	Quant    uint64
+	// Price is the limit price of the order, 0 estimates a market
+	// order that takes any price.
+	Price uint64
+}
+
+// LevelFill is the quantity filled at a price level.
+type LevelFill struct {
+	Price uint64
+	Quant uint64
+}
+
+// FillEstimate is the estimated fills of an order taking the
+// liquidity of the order book.
+type FillEstimate struct {
+	FilledQuant uint64
+	// AvgPrice is the average price of the fills weighted by
+	// their quantities, rounded down, 0 if nothing is filled.
+	AvgPrice uint64
+	// QuoteQuant is the quote quantity paid or received for the
+	// fills before the trading fee, it is computed for each fill
+	// the same way as the settlement does.
+	QuoteQuant uint64
+	// the filled price levels from the best price
+	Levels []LevelFill
+	// the round of the state the estimate is made on
+	Round uint64
+}
+
+// EstimateFill matches the order against a copy of the market's order
+// book, the state is not changed. The stop orders that the fills
+// would trigger are not taken into account.
+func (s *State) EstimateFill(req EstimateFillRequest) (FillEstimate, error) {
+	if !req.Market.Valid() {
+		return FillEstimate{}, newRPCError(CodeInvalidRequest, "invalid market %v", req.Market)
+	}
+
+	if req.Quant == 0 {
+		return FillEstimate{}, newRPCError(CodeInvalidRequest, "the quant of the order is 0")
+	}
+
+	base, ok := s.Token(req.Market.Base)
+	if !ok {
+		return FillEstimate{}, unknownTokenError(fmt.Sprint(req.Market.Base))
+	}
+
+	quote, ok := s.Token(req.Market.Quote)
+	if !ok {
+		return FillEstimate{}, unknownTokenError(fmt.Sprint(req.Market.Quote))
+	}
+
+	est := FillEstimate{Round: s.round}
+	// loadOrderBook decodes a new copy of the book
+	book := s.loadOrderBook(req.Market)
+	if book == nil {
+		return est, nil
+	}
+
+	price := req.Price
+	if price == 0 && !req.SellSide {
+		price = math.MaxUint64
+	}
+
+	_, executions := book.ImmediateOrCancel(Order{SellSide: req.SellSide, Quant: req.Quant, Price: price})
+	var total, p big.Int
+	for _, exec := range executions {
+		if !exec.Taker {
+			continue
+		}
+
+		est.FilledQuant += exec.Quant
+		est.QuoteQuant += calcQuoteQuant(exec.Quant, quote.Decimals, exec.Price, OrderPriceDecimals, base.Decimals)
+		p.SetUint64(exec.Price)
+		total.Add(&total, p.Mul(&p, new(big.Int).SetUint64(exec.Quant)))
+		if n := len(est.Levels); n > 0 && est.Levels[n-1].Price == exec.Price {
+			est.Levels[n-1].Quant += exec.Quant
+		} else {
+			est.Levels = append(est.Levels, LevelFill{Price: exec.Price, Quant: exec.Quant})
+		}
+	}
+
+	if est.FilledQuant > 0 {
+		est.AvgPrice = total.Div(&total, new(big.Int).SetUint64(est.FilledQuant)).Uint64()
+	}
+	return est, nil
+}
+
+func (r *RPCServer) estimateFill(req EstimateFillRequest, est *FillEstimate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.state(false)
+	if err != nil {
+		return err
+	}
+
+	*est, err = s.EstimateFill(req)
+	return err
+}
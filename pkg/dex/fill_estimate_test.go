@@ -0,0 +1,78 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateFill(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	cases := []struct {
+		quant uint64
+		price uint64
+	}{
+		// walks two levels and rests nothing
+		{quant: 20, price: 120000000},
+		// the limit price stops the walk
+		{quant: 30, price: 110000000},
+		// the market order takes all the liquidity
+		{quant: 40, price: 0},
+	}
+
+	for _, c := range cases {
+		s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+		trans := s.Transition(1, nil)
+		recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market}, 0))
+		recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 5, Price: 100000000, Market: market}, 1))
+		recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 120000000, Market: market}, 2))
+		s = trans.Commit().(*State)
+
+		bids, asks := s.OrderBookDepth(market, 10)
+		est, err := s.EstimateFill(EstimateFillRequest{Market: market, Quant: c.quant, Price: c.price})
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), est.Round)
+		// the estimate does not change the book
+		b, a := s.OrderBookDepth(market, 10)
+		assert.Equal(t, bids, b)
+		assert.Equal(t, asks, a)
+
+		// submit the same order on a new transition of the state
+		price := c.price
+		if price == 0 {
+			price = 120000000
+		}
+		trans = s.Transition(2, nil)
+		recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: c.quant, Price: price, Market: market, TIF: IOC}, 0))
+		after := trans.Commit().(*State)
+
+		taker := after.Account(pkTaker.Addr())
+		var filled, total uint64
+		var levels []LevelFill
+		for _, r := range taker.ExecutionReports() {
+			filled += r.Quant
+			total += r.TradePrice * r.Quant
+			if n := len(levels); n > 0 && levels[n-1].Price == r.TradePrice {
+				levels[n-1].Quant += r.Quant
+			} else {
+				levels = append(levels, LevelFill{Price: r.TradePrice, Quant: r.Quant})
+			}
+		}
+		assert.Equal(t, filled, est.FilledQuant)
+		assert.Equal(t, levels, est.Levels)
+		assert.Equal(t, total/filled, est.AvgPrice)
+		assert.Equal(t, 100+filled, taker.Balance(0).Available)
+		assert.Equal(t, 100-est.QuoteQuant, taker.Balance(1).Available)
+	}
+
+	s, _, _, _, _ := newTIFTestState()
+	est, err := s.EstimateFill(EstimateFillRequest{Market: market, SellSide: true, Quant: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), est.FilledQuant)
+	assert.Equal(t, 0, len(est.Levels))
+
+	_, err = s.EstimateFill(EstimateFillRequest{Market: market, Quant: 0})
+	assert.NotNil(t, err)
+	_, err = s.EstimateFill(EstimateFillRequest{Market: MarketSymbol{Base: 0, Quote: 2}, Quant: 10})
+	assert.True(t, IsNotFound(err))
+}
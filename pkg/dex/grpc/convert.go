@@ -0,0 +1,121 @@
+package grpc
+
+import (
+	"github.com/helinwang/dex/pkg/dex"
+)
+
+func marketMsg(m dex.MarketSymbol) *Market {
+	return &Market{Base: uint64(m.Base), Quote: uint64(m.Quote)}
+}
+
+func orderIDMsg(id dex.OrderID) *OrderID {
+	return &OrderID{Market: marketMsg(id.Market), Id: id.ID}
+}
+
+func balanceMsg(b dex.UserBalance) *Balance {
+	m := &Balance{Token: uint64(b.Token), Available: b.Available, Pending: b.Pending}
+	for _, f := range b.Frozen {
+		m.Frozen = append(m.Frozen, &Frozen{AvailableRound: f.AvailableRound, Quant: f.Quant})
+	}
+	return m
+}
+
+func pendingOrderMsg(o dex.PendingOrder) *PendingOrder {
+	return &PendingOrder{
+		Id:          orderIDMsg(o.ID),
+		Owner:       o.Owner[:],
+		SellSide:    o.SellSide,
+		Quant:       o.Quant,
+		Price:       o.Price,
+		ExpireRound: o.ExpireRound,
+		Executed:    o.Executed,
+	}
+}
+
+func executionReportMsg(r dex.ExecutionReport) *ExecutionReport {
+	return &ExecutionReport{
+		Round:      r.Round,
+		Id:         orderIDMsg(r.ID),
+		SellSide:   r.SellSide,
+		TradePrice: r.TradePrice,
+		Quant:      r.Quant,
+		Fee:        r.Fee,
+	}
+}
+
+func stateVersionMsg(v dex.StateVersion) *StateVersion {
+	return &StateVersion{Round: v.Round, Root: v.Root[:]}
+}
+
+func walletStateMsg(w dex.WalletState) *WalletStateResponse {
+	m := &WalletStateResponse{Round: w.Round, Truncated: w.Truncated, Cursor: orderIDMsg(w.Cursor), Version: stateVersionMsg(w.Version)}
+	for _, b := range w.Balances {
+		m.Balances = append(m.Balances, balanceMsg(b))
+	}
+
+	for _, o := range w.PendingOrders {
+		m.PendingOrders = append(m.PendingOrders, pendingOrderMsg(o))
+	}
+
+	for _, r := range w.ExecutionReports {
+		m.ExecutionReports = append(m.ExecutionReports, executionReportMsg(r))
+	}
+	return m
+}
+
+func tokenMsg(t dex.Token) *Token {
+	return &Token{
+		Id:         uint64(t.ID),
+		Symbol:     string(t.Symbol),
+		Decimals:   uint32(t.Decimals),
+		TotalUnits: t.TotalUnits,
+		MaxSupply:  t.MaxSupply,
+		Issuer:     t.Issuer[:],
+	}
+}
+
+func txnStatusMsg(r dex.TxnStatusResult) *TxnStatusResponse {
+	return &TxnStatusResponse{Status: int32(r.Status), Round: r.Round, Block: r.Block[:], Reason: r.Reason}
+}
+
+func priceLevelsMsg(levels []dex.Level) []*PriceLevel {
+	var m []*PriceLevel
+	for _, l := range levels {
+		m = append(m, &PriceLevel{Price: l.Price, Quant: l.Quant, Orders: int32(l.Orders)})
+	}
+	return m
+}
+
+func orderBookMsg(r dex.OrderBookResponse) *OrderBookResponse {
+	return &OrderBookResponse{
+		Bids:         priceLevelsMsg(r.Bids),
+		Asks:         priceLevelsMsg(r.Asks),
+		LastPrice:    r.LastPrice,
+		HasLastPrice: r.HasLastPrice,
+		Round:        r.Round,
+		Version:      stateVersionMsg(r.Version),
+	}
+}
+
+func tradeEventMsg(round uint64, t dex.TradeEvent) *TradeEvent {
+	return &TradeEvent{
+		Round:    round,
+		Market:   marketMsg(t.Market),
+		Price:    t.Price,
+		Quant:    t.Quant,
+		SellSide: t.SellSide,
+		TxnHash:  t.TxnHash[:],
+	}
+}
+
+func accountEventMsg(round uint64, a dex.AccountEvent) *AccountEvent {
+	m := &AccountEvent{Round: round, Addr: a.Addr[:]}
+	for _, b := range a.Balances {
+		m.Balances = append(m.Balances, balanceMsg(b))
+	}
+
+	for _, r := range a.ExecutionReports {
+		m.ExecutionReports = append(m.ExecutionReports, executionReportMsg(r))
+	}
+	return m
+}
@@ -0,0 +1,401 @@
+// Package grpc serves the wallet RPC over gRPC. The calls are
+// delegated to the same dex.WalletService as the net/rpc, JSON-RPC
+// and REST endpoints, and are checked by the same rate limit, client
+// certificate and auth checks.
+package grpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative wallet.proto
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/helinwang/dex/pkg/dex"
+	log "github.com/helinwang/log15"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// eventBufferSize is the number of the events buffered for a
+// subscription stream, the events are dropped when the buffer is
+// full.
+const eventBufferSize = 256
+
+// Server is the gRPC wallet service of an RPC server.
+type Server struct {
+	UnimplementedWalletServer
+
+	r *dex.RPCServer
+	s *dex.WalletService
+}
+
+// NewServer returns the gRPC wallet service of the RPC server.
+func NewServer(r *dex.RPCServer) *Server {
+	return &Server{r: r, s: dex.NewWalletService(r)}
+}
+
+// GRPCServer returns the gRPC server serving the wallet service,
+// over TLS if the RPC server has the TLS config set.
+func (s *Server) GRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.checkUnary),
+		grpc.StreamInterceptor(s.checkStream),
+	}
+	if cfg := s.r.TLSConfig(); cfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+
+	g := grpc.NewServer(opts...)
+	RegisterWalletServer(g, s)
+	return g
+}
+
+// Start serves the gRPC wallet service of the RPC server on the
+// address in the background.
+func Start(r *dex.RPCServer, addr string) (*grpc.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	g := NewServer(r).GRPCServer()
+	go func() {
+		err := g.Serve(l)
+		if err != nil {
+			log.Error("error serving gRPC server", "err", err)
+		}
+	}()
+	return g, nil
+}
+
+// check checks the call the same way as the net/rpc calls are
+// checked, the gRPC methods are named after the WalletService
+// methods.
+func (s *Server) check(ctx context.Context, fullMethod string) error {
+	req := &http.Request{Header: make(http.Header)}
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &info.State
+		}
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get("authorization") {
+			req.Header.Add("Authorization", v)
+		}
+	}
+
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	return toStatus(s.r.CheckCall(req, method))
+}
+
+func (s *Server) checkUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.check(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) checkStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.check(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// errorDomain is the domain of the ErrorInfo details of the status
+// errors, their reasons are the names of the dex.ErrorCode.
+const errorDomain = "dex"
+
+// toStatus returns the gRPC status error of the error returned by
+// the wallet service, the code of the dex.RPCError is carried as the
+// reason of the ErrorInfo detail.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	e, ok := err.(*dex.RPCError)
+	if !ok {
+		e = &dex.RPCError{Code: dex.CodeInternal, Message: err.Error()}
+	}
+
+	code := codes.Internal
+	switch e.Code {
+	case dex.CodeNotSynced:
+		code = codes.Unavailable
+	case dex.CodeAccountNotFound, dex.CodeNotFound:
+		code = codes.NotFound
+	case dex.CodeInvalidTxn:
+		code = codes.FailedPrecondition
+	case dex.CodeRateLimited:
+		code = codes.ResourceExhausted
+	case dex.CodeUnauthorized:
+		code = codes.Unauthenticated
+		if err == dex.ErrClientCertRequired {
+			code = codes.PermissionDenied
+		}
+	case dex.CodeInvalidRequest:
+		code = codes.InvalidArgument
+	case dex.CodeUnsupported:
+		code = codes.Unimplemented
+	}
+
+	st, derr := status.New(code, e.Message).WithDetails(&errdetails.ErrorInfo{Reason: e.Code.String(), Domain: errorDomain})
+	if derr != nil {
+		return status.Error(code, e.Message)
+	}
+	return st.Err()
+}
+
+// invalidArgument returns the status error of the invalid argument
+// of a call.
+func invalidArgument(format string, a ...interface{}) error {
+	return toStatus(&dex.RPCError{Code: dex.CodeInvalidRequest, Message: fmt.Sprintf(format, a...)})
+}
+
+func toAddr(b []byte) (consensus.Addr, error) {
+	var addr consensus.Addr
+	if len(b) != len(addr) {
+		return addr, invalidArgument("the address should be %d bytes, got %d bytes", len(addr), len(b))
+	}
+
+	copy(addr[:], b)
+	return addr, nil
+}
+
+func toHash(b []byte) (consensus.Hash, error) {
+	var h consensus.Hash
+	if len(b) != len(h) {
+		return h, invalidArgument("the hash should be %d bytes, got %d bytes", len(h), len(b))
+	}
+
+	copy(h[:], b)
+	return h, nil
+}
+
+func toMarket(m *Market) (dex.MarketSymbol, error) {
+	if m == nil {
+		return dex.MarketSymbol{}, invalidArgument("the market is not set")
+	}
+
+	market := dex.MarketSymbol{Base: dex.TokenID(m.Base), Quote: dex.TokenID(m.Quote)}
+	if !market.Valid() {
+		return market, invalidArgument("invalid market %v", market)
+	}
+	return market, nil
+}
+
+func (s *Server) WalletState(ctx context.Context, req *AddrRequest) (*WalletStateResponse, error) {
+	addr, err := toAddr(req.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var w dex.WalletState
+	err = s.s.WalletState(addr, &w)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return walletStateMsg(w), nil
+}
+
+func (s *Server) Tokens(ctx context.Context, req *TokensRequest) (*TokensResponse, error) {
+	var t dex.TokenState
+	err := s.s.Tokens(0, &t)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &TokensResponse{}
+	for _, token := range t.Tokens {
+		resp.Tokens = append(resp.Tokens, tokenMsg(token))
+	}
+	return resp, nil
+}
+
+func (s *Server) Nonce(ctx context.Context, req *AddrRequest) (*NonceResponse, error) {
+	addr, err := toAddr(req.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var n uint64
+	err = s.s.Nonce(addr, &n)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &NonceResponse{Nonce: n}, nil
+}
+
+func (s *Server) SendTxn(ctx context.Context, req *SendTxnRequest) (*SendTxnResponse, error) {
+	var r dex.SendTxnResult
+	err := s.s.SendTxnWait(dex.SendTxnRequest{Txn: req.Txn, WaitRounds: uint(req.WaitRounds)}, &r)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &SendTxnResponse{Hash: r.Hash[:], Accepted: r.Accepted, Result: int32(r.Result), Reason: r.Reason}
+	if r.Accepted {
+		resp.Status = txnStatusMsg(r.Status)
+	}
+	return resp, nil
+}
+
+func (s *Server) TxnStatus(ctx context.Context, req *TxnStatusRequest) (*TxnStatusResponse, error) {
+	hash, err := toHash(req.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var r dex.TxnStatusResult
+	err = s.s.TxnStatus(hash, &r)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return txnStatusMsg(r), nil
+}
+
+func (s *Server) OrderBook(ctx context.Context, req *OrderBookRequest) (*OrderBookResponse, error) {
+	market, err := toMarket(req.Market)
+	if err != nil {
+		return nil, err
+	}
+
+	var r dex.OrderBookResponse
+	err = s.s.OrderBook(dex.OrderBookRequest{Market: market, Levels: int(req.Levels)}, &r)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return orderBookMsg(r), nil
+}
+
+func (s *Server) ChainStatus(ctx context.Context, req *ChainStatusRequest) (*ChainStatusResponse, error) {
+	var st consensus.ChainStatus
+	err := s.s.ChainStatus(0, &st)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &ChainStatusResponse{ChainId: st.ChainID[:], Round: st.Round, RandBeaconDepth: st.RandBeaconDepth}
+	for _, m := range st.RoundMetrics {
+		resp.RoundMetrics = append(resp.RoundMetrics, &RoundMetric{Round: m.Round, BlockTimeNanos: int64(m.BlockTime), TxnCount: int32(m.TxnCount)})
+	}
+	return resp, nil
+}
+
+// SubscribeTrades streams the trades of the market. The header is
+// sent once the subscription is registered. When the client does not
+// keep up, the events are dropped and the next sent event carries
+// the number of the dropped events.
+func (s *Server) SubscribeTrades(req *SubscribeTradesRequest, stream Wallet_SubscribeTradesServer) error {
+	market, err := toMarket(req.Market)
+	if err != nil {
+		return err
+	}
+
+	events := make(chan *TradeEvent, eventBufferSize)
+	// the events are published one round at a time
+	var dropped uint64
+	cancel := s.r.SubscribeEvents(func(round uint64, root consensus.Hash, e *dex.StateEvents) {
+		if e == nil {
+			return
+		}
+
+		for _, t := range e.Trades {
+			if t.Market != market {
+				continue
+			}
+
+			m := tradeEventMsg(round, t)
+			m.Dropped = dropped
+			select {
+			case events <- m:
+				dropped = 0
+			default:
+				dropped++
+			}
+		}
+	})
+	defer cancel()
+
+	err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case m := <-events:
+			err := stream.Send(m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SubscribeAccount streams the changes of the account. The header is
+// sent once the subscription is registered. When the client does not
+// keep up, the events are dropped and the next sent event carries
+// the number of the dropped events.
+func (s *Server) SubscribeAccount(req *AddrRequest, stream Wallet_SubscribeAccountServer) error {
+	addr, err := toAddr(req.Addr)
+	if err != nil {
+		return err
+	}
+
+	events := make(chan *AccountEvent, eventBufferSize)
+	// the events are published one round at a time
+	var dropped uint64
+	cancel := s.r.SubscribeEvents(func(round uint64, root consensus.Hash, e *dex.StateEvents) {
+		if e == nil {
+			return
+		}
+
+		for _, a := range e.Accounts {
+			if a.Addr != addr {
+				continue
+			}
+
+			m := accountEventMsg(round, a)
+			m.Dropped = dropped
+			select {
+			case events <- m:
+				dropped = 0
+			default:
+				dropped++
+			}
+		}
+	})
+	defer cancel()
+
+	err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case m := <-events:
+			err := stream.Send(m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/helinwang/dex/pkg/dex"
+	"github.com/helinwang/dex/pkg/dex/client"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testChainID = consensus.Hash{1}
+
+type nopSender struct{}
+
+func (nopSender) SendTxn([]byte)      {}
+func (nopSender) BroadcastTxn([]byte) {}
+
+type testServer struct {
+	r    *dex.RPCServer
+	s    *dex.State
+	pool *dex.TxnPool
+	pk   dex.PK
+	sk   dex.SK
+
+	rpc *client.Client
+	c   WalletClient
+}
+
+// newTestServer serves the same state over net/rpc and gRPC.
+func newTestServer(t *testing.T) (*testServer, func()) {
+	s := dex.NewState(ethdb.NewMemDatabase())
+	s.SetChainID(testChainID)
+	s.UpdateToken(dex.Token{ID: 0, TokenInfo: dex.BNBInfo})
+	s.UpdateToken(dex.Token{ID: 1, TokenInfo: dex.TokenInfo{Symbol: "XRP", Decimals: 8, TotalUnits: 1000}})
+	pk, sk := dex.RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, dex.Balance{Available: 1000})
+	acc.UpdateBalance(1, dex.Balance{Available: 1000})
+	s.CommitCache()
+
+	pool := dex.NewTxnPool(s)
+	pool.Update(s)
+	r := dex.NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	g := NewServer(r).GRPCServer()
+	go g.Serve(l)
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+
+	ts := &testServer{
+		r:    r,
+		s:    s,
+		pool: pool,
+		pk:   pk,
+		sk:   sk,
+		rpc:  client.New(strings.TrimPrefix(srv.URL, "http://"), client.Config{Timeout: time.Second}),
+		c:    NewWalletClient(conn),
+	}
+	return ts, func() {
+		ts.rpc.Close()
+		conn.Close()
+		g.Stop()
+		srv.Close()
+	}
+}
+
+// commit records the txns in a new round and publishes the round.
+func (ts *testServer) commit(t *testing.T, round uint64, hashes ...[]byte) {
+	trans := ts.s.Transition(round, nil)
+	for _, b := range hashes {
+		var h consensus.Hash
+		copy(h[:], b)
+		txn := ts.pool.Get(h)
+		assert.NotNil(t, txn)
+		assert.Nil(t, trans.Record(txn))
+	}
+
+	ts.s = trans.Commit().(*dex.State)
+	ts.pool.Update(ts.s)
+	ts.r.Update(ts.s)
+	ts.r.Finalized(round, ts.s)
+}
+
+func TestConformance(t *testing.T) {
+	ts, done := newTestServer(t)
+	defer done()
+
+	ctx := context.Background()
+	addr := ts.pk.Addr()
+	market := dex.MarketSymbol{Base: 1, Quote: 0}
+
+	nonce, err := ts.rpc.Nonce(ctx, addr)
+	assert.Nil(t, err)
+	n, err := ts.c.Nonce(ctx, &AddrRequest{Addr: addr[:]})
+	assert.Nil(t, err)
+	assert.Equal(t, nonce, n.Nonce)
+
+	txn := dex.MakePlaceOrderTxn(ts.sk, testChainID, addr, dex.PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market}, nonce)
+	sent, err := ts.c.SendTxn(ctx, &SendTxnRequest{Txn: txn})
+	assert.Nil(t, err)
+	assert.True(t, sent.Accepted, sent.Reason)
+
+	// the resent txn is a duplicate on both transports
+	resent, err := ts.rpc.SendTxn(ctx, txn)
+	assert.Nil(t, err)
+	again, err := ts.c.SendTxn(ctx, &SendTxnRequest{Txn: txn})
+	assert.Nil(t, err)
+	assert.Equal(t, resent.Hash[:], again.Hash)
+	assert.Equal(t, resent.Accepted, again.Accepted)
+	assert.Equal(t, int32(resent.Result), again.Result)
+	assert.Equal(t, resent.Reason, again.Reason)
+
+	st, err := ts.rpc.TxnStatus(ctx, resent.Hash)
+	assert.Nil(t, err)
+	gst, err := ts.c.TxnStatus(ctx, &TxnStatusRequest{Hash: sent.Hash})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(st.Status), gst.Status)
+
+	ts.commit(t, 1, sent.Hash)
+
+	tokens, err := ts.rpc.Tokens(ctx)
+	assert.Nil(t, err)
+	gtokens, err := ts.c.Tokens(ctx, &TokensRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, len(tokens), len(gtokens.Tokens))
+	for i, token := range tokens {
+		assert.Equal(t, uint64(token.ID), gtokens.Tokens[i].Id)
+		assert.Equal(t, string(token.Symbol), gtokens.Tokens[i].Symbol)
+		assert.Equal(t, token.TotalUnits, gtokens.Tokens[i].TotalUnits)
+	}
+
+	w, err := ts.rpc.WalletState(ctx, addr)
+	assert.Nil(t, err)
+	gw, err := ts.c.WalletState(ctx, &AddrRequest{Addr: addr[:]})
+	assert.Nil(t, err)
+	assert.Equal(t, w.Round, gw.Round)
+	assert.Equal(t, w.Version.Round, gw.Version.Round)
+	assert.Equal(t, w.Version.Root[:], gw.Version.Root)
+	assert.Equal(t, len(w.Balances), len(gw.Balances))
+	for i, b := range w.Balances {
+		assert.Equal(t, uint64(b.Token), gw.Balances[i].Token)
+		assert.Equal(t, b.Available, gw.Balances[i].Available)
+		assert.Equal(t, b.Pending, gw.Balances[i].Pending)
+	}
+	assert.Equal(t, 1, len(w.PendingOrders))
+	assert.Equal(t, len(w.PendingOrders), len(gw.PendingOrders))
+	for i, o := range w.PendingOrders {
+		assert.Equal(t, o.ID.ID, gw.PendingOrders[i].Id.Id)
+		assert.Equal(t, o.Price, gw.PendingOrders[i].Price)
+		assert.Equal(t, o.Quant, gw.PendingOrders[i].Quant)
+	}
+
+	book, err := ts.rpc.OrderBook(ctx, market, 10)
+	assert.Nil(t, err)
+	gbook, err := ts.c.OrderBook(ctx, &OrderBookRequest{Market: marketMsg(market), Levels: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(book.Asks))
+	assert.Equal(t, len(book.Asks), len(gbook.Asks))
+	assert.Equal(t, book.Asks[0].Price, gbook.Asks[0].Price)
+	assert.Equal(t, book.Asks[0].Quant, gbook.Asks[0].Quant)
+	assert.Equal(t, book.Round, gbook.Round)
+	assert.Equal(t, book.Version.Round, gbook.Version.Round)
+	assert.Equal(t, book.Version.Root[:], gbook.Version.Root)
+}
+
+// errorCode returns the code carried by the ErrorInfo detail of the
+// status error.
+func errorCode(t *testing.T, err error) dex.ErrorCode {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			assert.Equal(t, errorDomain, info.Domain)
+			code, ok := dex.ParseErrorCode(info.Reason)
+			assert.True(t, ok)
+			return code
+		}
+	}
+
+	t.Errorf("no ErrorInfo in %v", err)
+	return dex.CodeInternal
+}
+
+func TestErrors(t *testing.T) {
+	ts, done := newTestServer(t)
+	defer done()
+
+	ctx := context.Background()
+	unknown, _ := dex.RandKeyPair()
+	addr := unknown.Addr()
+	_, err := ts.rpc.WalletState(ctx, addr)
+	assert.True(t, errors.Is(err, client.ErrAccountNotFound))
+	_, err = ts.c.WalletState(ctx, &AddrRequest{Addr: addr[:]})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, dex.CodeAccountNotFound, errorCode(t, err))
+
+	_, err = ts.c.WalletState(ctx, &AddrRequest{Addr: []byte{1, 2}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, dex.CodeInvalidRequest, errorCode(t, err))
+
+	_, err = ts.c.OrderBook(ctx, &OrderBookRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, dex.CodeInvalidRequest, errorCode(t, err))
+
+	ts.r.SetAuth("secret", dex.DefaultAuthMethods)
+	_, err = ts.c.SendTxn(ctx, &SendTxnRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, dex.CodeUnauthorized, errorCode(t, err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	_, err = ts.c.SendTxn(ctx, &SendTxnRequest{})
+	assert.NotEqual(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestSubscribeTrades(t *testing.T) {
+	ts, done := newTestServer(t)
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := ts.pk.Addr()
+	market := dex.MarketSymbol{Base: 1, Quote: 0}
+	trades, err := ts.c.SubscribeTrades(ctx, &SubscribeTradesRequest{Market: marketMsg(market)})
+	assert.Nil(t, err)
+	account, err := ts.c.SubscribeAccount(ctx, &AddrRequest{Addr: addr[:]})
+	assert.Nil(t, err)
+
+	// the header is sent once the subscription is registered
+	_, err = trades.Header()
+	assert.Nil(t, err)
+	_, err = account.Header()
+	assert.Nil(t, err)
+
+	sell := dex.MakePlaceOrderTxn(ts.sk, testChainID, addr, dex.PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market}, 0)
+	buy := dex.MakePlaceOrderTxn(ts.sk, testChainID, addr, dex.PlaceOrderTxn{SellSide: false, Quant: 10, Price: 100000000, Market: market}, 1)
+	var hashes [][]byte
+	for _, txn := range [][]byte{sell, buy} {
+		r, err := ts.c.SendTxn(ctx, &SendTxnRequest{Txn: txn})
+		assert.Nil(t, err)
+		assert.True(t, r.Accepted, r.Reason)
+		hashes = append(hashes, r.Hash)
+	}
+	ts.commit(t, 1, hashes...)
+
+	e, err := trades.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), e.Round)
+	assert.Equal(t, uint64(100000000), e.Price)
+	assert.Equal(t, uint64(10), e.Quant)
+	assert.Equal(t, uint64(0), e.Dropped)
+
+	a, err := account.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), a.Round)
+	assert.Equal(t, addr[:], a.Addr)
+}
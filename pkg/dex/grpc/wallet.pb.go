@@ -0,0 +1,1896 @@
+// The gRPC service of the wallet RPC. It mirrors the net/rpc
+// WalletService, the messages mirror the types of the dex package.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: wallet.proto
+
+package grpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Market struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the unit of the order's quantity
+	Base uint64 `protobuf:"varint,1,opt,name=base,proto3" json:"base,omitempty"`
+	// the unit of the order's price
+	Quote         uint64 `protobuf:"varint,2,opt,name=quote,proto3" json:"quote,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Market) Reset() {
+	*x = Market{}
+	mi := &file_wallet_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Market) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Market) ProtoMessage() {}
+
+func (x *Market) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Market.ProtoReflect.Descriptor instead.
+func (*Market) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Market) GetBase() uint64 {
+	if x != nil {
+		return x.Base
+	}
+	return 0
+}
+
+func (x *Market) GetQuote() uint64 {
+	if x != nil {
+		return x.Quote
+	}
+	return 0
+}
+
+type OrderID struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Market        *Market                `protobuf:"bytes,1,opt,name=market,proto3" json:"market,omitempty"`
+	Id            uint64                 `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OrderID) Reset() {
+	*x = OrderID{}
+	mi := &file_wallet_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OrderID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OrderID) ProtoMessage() {}
+
+func (x *OrderID) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OrderID.ProtoReflect.Descriptor instead.
+func (*OrderID) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *OrderID) GetMarket() *Market {
+	if x != nil {
+		return x.Market
+	}
+	return nil
+}
+
+func (x *OrderID) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type AddrRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the 20 bytes address of the account
+	Addr          []byte `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddrRequest) Reset() {
+	*x = AddrRequest{}
+	mi := &file_wallet_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddrRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddrRequest) ProtoMessage() {}
+
+func (x *AddrRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddrRequest.ProtoReflect.Descriptor instead.
+func (*AddrRequest) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AddrRequest) GetAddr() []byte {
+	if x != nil {
+		return x.Addr
+	}
+	return nil
+}
+
+type Frozen struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	AvailableRound uint64                 `protobuf:"varint,1,opt,name=available_round,json=availableRound,proto3" json:"available_round,omitempty"`
+	Quant          uint64                 `protobuf:"varint,2,opt,name=quant,proto3" json:"quant,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Frozen) Reset() {
+	*x = Frozen{}
+	mi := &file_wallet_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Frozen) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Frozen) ProtoMessage() {}
+
+func (x *Frozen) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Frozen.ProtoReflect.Descriptor instead.
+func (*Frozen) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Frozen) GetAvailableRound() uint64 {
+	if x != nil {
+		return x.AvailableRound
+	}
+	return 0
+}
+
+func (x *Frozen) GetQuant() uint64 {
+	if x != nil {
+		return x.Quant
+	}
+	return 0
+}
+
+type Balance struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         uint64                 `protobuf:"varint,1,opt,name=token,proto3" json:"token,omitempty"`
+	Available     uint64                 `protobuf:"varint,2,opt,name=available,proto3" json:"available,omitempty"`
+	Pending       uint64                 `protobuf:"varint,3,opt,name=pending,proto3" json:"pending,omitempty"`
+	Frozen        []*Frozen              `protobuf:"bytes,4,rep,name=frozen,proto3" json:"frozen,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Balance) Reset() {
+	*x = Balance{}
+	mi := &file_wallet_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Balance) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Balance) ProtoMessage() {}
+
+func (x *Balance) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Balance.ProtoReflect.Descriptor instead.
+func (*Balance) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Balance) GetToken() uint64 {
+	if x != nil {
+		return x.Token
+	}
+	return 0
+}
+
+func (x *Balance) GetAvailable() uint64 {
+	if x != nil {
+		return x.Available
+	}
+	return 0
+}
+
+func (x *Balance) GetPending() uint64 {
+	if x != nil {
+		return x.Pending
+	}
+	return 0
+}
+
+func (x *Balance) GetFrozen() []*Frozen {
+	if x != nil {
+		return x.Frozen
+	}
+	return nil
+}
+
+type PendingOrder struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            *OrderID               `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Owner         []byte                 `protobuf:"bytes,2,opt,name=owner,proto3" json:"owner,omitempty"`
+	SellSide      bool                   `protobuf:"varint,3,opt,name=sell_side,json=sellSide,proto3" json:"sell_side,omitempty"`
+	Quant         uint64                 `protobuf:"varint,4,opt,name=quant,proto3" json:"quant,omitempty"`
+	Price         uint64                 `protobuf:"varint,5,opt,name=price,proto3" json:"price,omitempty"`
+	ExpireRound   uint64                 `protobuf:"varint,6,opt,name=expire_round,json=expireRound,proto3" json:"expire_round,omitempty"`
+	Executed      uint64                 `protobuf:"varint,7,opt,name=executed,proto3" json:"executed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PendingOrder) Reset() {
+	*x = PendingOrder{}
+	mi := &file_wallet_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PendingOrder) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PendingOrder) ProtoMessage() {}
+
+func (x *PendingOrder) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PendingOrder.ProtoReflect.Descriptor instead.
+func (*PendingOrder) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PendingOrder) GetId() *OrderID {
+	if x != nil {
+		return x.Id
+	}
+	return nil
+}
+
+func (x *PendingOrder) GetOwner() []byte {
+	if x != nil {
+		return x.Owner
+	}
+	return nil
+}
+
+func (x *PendingOrder) GetSellSide() bool {
+	if x != nil {
+		return x.SellSide
+	}
+	return false
+}
+
+func (x *PendingOrder) GetQuant() uint64 {
+	if x != nil {
+		return x.Quant
+	}
+	return 0
+}
+
+func (x *PendingOrder) GetPrice() uint64 {
+	if x != nil {
+		return x.Price
+	}
+	return 0
+}
+
+func (x *PendingOrder) GetExpireRound() uint64 {
+	if x != nil {
+		return x.ExpireRound
+	}
+	return 0
+}
+
+func (x *PendingOrder) GetExecuted() uint64 {
+	if x != nil {
+		return x.Executed
+	}
+	return 0
+}
+
+type ExecutionReport struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Round         uint64                 `protobuf:"varint,1,opt,name=round,proto3" json:"round,omitempty"`
+	Id            *OrderID               `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	SellSide      bool                   `protobuf:"varint,3,opt,name=sell_side,json=sellSide,proto3" json:"sell_side,omitempty"`
+	TradePrice    uint64                 `protobuf:"varint,4,opt,name=trade_price,json=tradePrice,proto3" json:"trade_price,omitempty"`
+	Quant         uint64                 `protobuf:"varint,5,opt,name=quant,proto3" json:"quant,omitempty"`
+	Fee           uint64                 `protobuf:"varint,6,opt,name=fee,proto3" json:"fee,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecutionReport) Reset() {
+	*x = ExecutionReport{}
+	mi := &file_wallet_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecutionReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecutionReport) ProtoMessage() {}
+
+func (x *ExecutionReport) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecutionReport.ProtoReflect.Descriptor instead.
+func (*ExecutionReport) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ExecutionReport) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *ExecutionReport) GetId() *OrderID {
+	if x != nil {
+		return x.Id
+	}
+	return nil
+}
+
+func (x *ExecutionReport) GetSellSide() bool {
+	if x != nil {
+		return x.SellSide
+	}
+	return false
+}
+
+func (x *ExecutionReport) GetTradePrice() uint64 {
+	if x != nil {
+		return x.TradePrice
+	}
+	return 0
+}
+
+func (x *ExecutionReport) GetQuant() uint64 {
+	if x != nil {
+		return x.Quant
+	}
+	return 0
+}
+
+func (x *ExecutionReport) GetFee() uint64 {
+	if x != nil {
+		return x.Fee
+	}
+	return 0
+}
+
+// the version of the state that a response is read from
+type StateVersion struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the round of the transition that produced the state
+	Round         uint64 `protobuf:"varint,1,opt,name=round,proto3" json:"round,omitempty"`
+	Root          []byte `protobuf:"bytes,2,opt,name=root,proto3" json:"root,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StateVersion) Reset() {
+	*x = StateVersion{}
+	mi := &file_wallet_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StateVersion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StateVersion) ProtoMessage() {}
+
+func (x *StateVersion) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StateVersion.ProtoReflect.Descriptor instead.
+func (*StateVersion) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StateVersion) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *StateVersion) GetRoot() []byte {
+	if x != nil {
+		return x.Root
+	}
+	return nil
+}
+
+type WalletStateResponse struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Balances         []*Balance             `protobuf:"bytes,1,rep,name=balances,proto3" json:"balances,omitempty"`
+	PendingOrders    []*PendingOrder        `protobuf:"bytes,2,rep,name=pending_orders,json=pendingOrders,proto3" json:"pending_orders,omitempty"`
+	ExecutionReports []*ExecutionReport     `protobuf:"bytes,3,rep,name=execution_reports,json=executionReports,proto3" json:"execution_reports,omitempty"`
+	Round            uint64                 `protobuf:"varint,4,opt,name=round,proto3" json:"round,omitempty"`
+	// the pending orders are truncated, the rest are after cursor
+	Truncated     bool          `protobuf:"varint,5,opt,name=truncated,proto3" json:"truncated,omitempty"`
+	Cursor        *OrderID      `protobuf:"bytes,6,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	Version       *StateVersion `protobuf:"bytes,7,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WalletStateResponse) Reset() {
+	*x = WalletStateResponse{}
+	mi := &file_wallet_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WalletStateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WalletStateResponse) ProtoMessage() {}
+
+func (x *WalletStateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WalletStateResponse.ProtoReflect.Descriptor instead.
+func (*WalletStateResponse) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WalletStateResponse) GetBalances() []*Balance {
+	if x != nil {
+		return x.Balances
+	}
+	return nil
+}
+
+func (x *WalletStateResponse) GetPendingOrders() []*PendingOrder {
+	if x != nil {
+		return x.PendingOrders
+	}
+	return nil
+}
+
+func (x *WalletStateResponse) GetExecutionReports() []*ExecutionReport {
+	if x != nil {
+		return x.ExecutionReports
+	}
+	return nil
+}
+
+func (x *WalletStateResponse) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *WalletStateResponse) GetTruncated() bool {
+	if x != nil {
+		return x.Truncated
+	}
+	return false
+}
+
+func (x *WalletStateResponse) GetCursor() *OrderID {
+	if x != nil {
+		return x.Cursor
+	}
+	return nil
+}
+
+func (x *WalletStateResponse) GetVersion() *StateVersion {
+	if x != nil {
+		return x.Version
+	}
+	return nil
+}
+
+type TokensRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokensRequest) Reset() {
+	*x = TokensRequest{}
+	mi := &file_wallet_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokensRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokensRequest) ProtoMessage() {}
+
+func (x *TokensRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokensRequest.ProtoReflect.Descriptor instead.
+func (*TokensRequest) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{9}
+}
+
+type Token struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Symbol        string                 `protobuf:"bytes,2,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Decimals      uint32                 `protobuf:"varint,3,opt,name=decimals,proto3" json:"decimals,omitempty"`
+	TotalUnits    uint64                 `protobuf:"varint,4,opt,name=total_units,json=totalUnits,proto3" json:"total_units,omitempty"`
+	MaxSupply     uint64                 `protobuf:"varint,5,opt,name=max_supply,json=maxSupply,proto3" json:"max_supply,omitempty"`
+	Issuer        []byte                 `protobuf:"bytes,6,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Token) Reset() {
+	*x = Token{}
+	mi := &file_wallet_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Token) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Token) ProtoMessage() {}
+
+func (x *Token) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Token.ProtoReflect.Descriptor instead.
+func (*Token) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Token) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Token) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *Token) GetDecimals() uint32 {
+	if x != nil {
+		return x.Decimals
+	}
+	return 0
+}
+
+func (x *Token) GetTotalUnits() uint64 {
+	if x != nil {
+		return x.TotalUnits
+	}
+	return 0
+}
+
+func (x *Token) GetMaxSupply() uint64 {
+	if x != nil {
+		return x.MaxSupply
+	}
+	return 0
+}
+
+func (x *Token) GetIssuer() []byte {
+	if x != nil {
+		return x.Issuer
+	}
+	return nil
+}
+
+type TokensResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tokens        []*Token               `protobuf:"bytes,1,rep,name=tokens,proto3" json:"tokens,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokensResponse) Reset() {
+	*x = TokensResponse{}
+	mi := &file_wallet_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokensResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokensResponse) ProtoMessage() {}
+
+func (x *TokensResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokensResponse.ProtoReflect.Descriptor instead.
+func (*TokensResponse) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *TokensResponse) GetTokens() []*Token {
+	if x != nil {
+		return x.Tokens
+	}
+	return nil
+}
+
+type NonceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Nonce         uint64                 `protobuf:"varint,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NonceResponse) Reset() {
+	*x = NonceResponse{}
+	mi := &file_wallet_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NonceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NonceResponse) ProtoMessage() {}
+
+func (x *NonceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NonceResponse.ProtoReflect.Descriptor instead.
+func (*NonceResponse) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *NonceResponse) GetNonce() uint64 {
+	if x != nil {
+		return x.Nonce
+	}
+	return 0
+}
+
+type SendTxnRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Txn   []byte                 `protobuf:"bytes,1,opt,name=txn,proto3" json:"txn,omitempty"`
+	// the number of rounds to wait for the txn to be included, 0 does
+	// not wait
+	WaitRounds    uint32 `protobuf:"varint,2,opt,name=wait_rounds,json=waitRounds,proto3" json:"wait_rounds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendTxnRequest) Reset() {
+	*x = SendTxnRequest{}
+	mi := &file_wallet_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendTxnRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendTxnRequest) ProtoMessage() {}
+
+func (x *SendTxnRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendTxnRequest.ProtoReflect.Descriptor instead.
+func (*SendTxnRequest) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SendTxnRequest) GetTxn() []byte {
+	if x != nil {
+		return x.Txn
+	}
+	return nil
+}
+
+func (x *SendTxnRequest) GetWaitRounds() uint32 {
+	if x != nil {
+		return x.WaitRounds
+	}
+	return 0
+}
+
+type SendTxnResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Hash     []byte                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Accepted bool                   `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	// the AddResult of the pool
+	Result int32 `protobuf:"varint,3,opt,name=result,proto3" json:"result,omitempty"`
+	// the reason that the pool rejected the txn
+	Reason string `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	// the status of the accepted txn when the call returns
+	Status        *TxnStatusResponse `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendTxnResponse) Reset() {
+	*x = SendTxnResponse{}
+	mi := &file_wallet_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendTxnResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendTxnResponse) ProtoMessage() {}
+
+func (x *SendTxnResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendTxnResponse.ProtoReflect.Descriptor instead.
+func (*SendTxnResponse) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *SendTxnResponse) GetHash() []byte {
+	if x != nil {
+		return x.Hash
+	}
+	return nil
+}
+
+func (x *SendTxnResponse) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+func (x *SendTxnResponse) GetResult() int32 {
+	if x != nil {
+		return x.Result
+	}
+	return 0
+}
+
+func (x *SendTxnResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *SendTxnResponse) GetStatus() *TxnStatusResponse {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+type TxnStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hash          []byte                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TxnStatusRequest) Reset() {
+	*x = TxnStatusRequest{}
+	mi := &file_wallet_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TxnStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxnStatusRequest) ProtoMessage() {}
+
+func (x *TxnStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxnStatusRequest.ProtoReflect.Descriptor instead.
+func (*TxnStatusRequest) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *TxnStatusRequest) GetHash() []byte {
+	if x != nil {
+		return x.Hash
+	}
+	return nil
+}
+
+type TxnStatusResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the TxnStatus of the txn
+	Status        int32  `protobuf:"varint,1,opt,name=status,proto3" json:"status,omitempty"`
+	Round         uint64 `protobuf:"varint,2,opt,name=round,proto3" json:"round,omitempty"`
+	Block         []byte `protobuf:"bytes,3,opt,name=block,proto3" json:"block,omitempty"`
+	Reason        string `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TxnStatusResponse) Reset() {
+	*x = TxnStatusResponse{}
+	mi := &file_wallet_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TxnStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxnStatusResponse) ProtoMessage() {}
+
+func (x *TxnStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxnStatusResponse.ProtoReflect.Descriptor instead.
+func (*TxnStatusResponse) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *TxnStatusResponse) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *TxnStatusResponse) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *TxnStatusResponse) GetBlock() []byte {
+	if x != nil {
+		return x.Block
+	}
+	return nil
+}
+
+func (x *TxnStatusResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type OrderBookRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Market *Market                `protobuf:"bytes,1,opt,name=market,proto3" json:"market,omitempty"`
+	// the maximum number of the price levels of each side, 0 means
+	// the cap of the server
+	Levels        int32 `protobuf:"varint,2,opt,name=levels,proto3" json:"levels,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OrderBookRequest) Reset() {
+	*x = OrderBookRequest{}
+	mi := &file_wallet_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OrderBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OrderBookRequest) ProtoMessage() {}
+
+func (x *OrderBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OrderBookRequest.ProtoReflect.Descriptor instead.
+func (*OrderBookRequest) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *OrderBookRequest) GetMarket() *Market {
+	if x != nil {
+		return x.Market
+	}
+	return nil
+}
+
+func (x *OrderBookRequest) GetLevels() int32 {
+	if x != nil {
+		return x.Levels
+	}
+	return 0
+}
+
+type PriceLevel struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Price         uint64                 `protobuf:"varint,1,opt,name=price,proto3" json:"price,omitempty"`
+	Quant         uint64                 `protobuf:"varint,2,opt,name=quant,proto3" json:"quant,omitempty"`
+	Orders        int32                  `protobuf:"varint,3,opt,name=orders,proto3" json:"orders,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PriceLevel) Reset() {
+	*x = PriceLevel{}
+	mi := &file_wallet_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PriceLevel) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PriceLevel) ProtoMessage() {}
+
+func (x *PriceLevel) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PriceLevel.ProtoReflect.Descriptor instead.
+func (*PriceLevel) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *PriceLevel) GetPrice() uint64 {
+	if x != nil {
+		return x.Price
+	}
+	return 0
+}
+
+func (x *PriceLevel) GetQuant() uint64 {
+	if x != nil {
+		return x.Quant
+	}
+	return 0
+}
+
+func (x *PriceLevel) GetOrders() int32 {
+	if x != nil {
+		return x.Orders
+	}
+	return 0
+}
+
+type OrderBookResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Bids          []*PriceLevel          `protobuf:"bytes,1,rep,name=bids,proto3" json:"bids,omitempty"`
+	Asks          []*PriceLevel          `protobuf:"bytes,2,rep,name=asks,proto3" json:"asks,omitempty"`
+	LastPrice     uint64                 `protobuf:"varint,3,opt,name=last_price,json=lastPrice,proto3" json:"last_price,omitempty"`
+	HasLastPrice  bool                   `protobuf:"varint,4,opt,name=has_last_price,json=hasLastPrice,proto3" json:"has_last_price,omitempty"`
+	Round         uint64                 `protobuf:"varint,5,opt,name=round,proto3" json:"round,omitempty"`
+	Version       *StateVersion          `protobuf:"bytes,6,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OrderBookResponse) Reset() {
+	*x = OrderBookResponse{}
+	mi := &file_wallet_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OrderBookResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OrderBookResponse) ProtoMessage() {}
+
+func (x *OrderBookResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OrderBookResponse.ProtoReflect.Descriptor instead.
+func (*OrderBookResponse) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *OrderBookResponse) GetBids() []*PriceLevel {
+	if x != nil {
+		return x.Bids
+	}
+	return nil
+}
+
+func (x *OrderBookResponse) GetAsks() []*PriceLevel {
+	if x != nil {
+		return x.Asks
+	}
+	return nil
+}
+
+func (x *OrderBookResponse) GetLastPrice() uint64 {
+	if x != nil {
+		return x.LastPrice
+	}
+	return 0
+}
+
+func (x *OrderBookResponse) GetHasLastPrice() bool {
+	if x != nil {
+		return x.HasLastPrice
+	}
+	return false
+}
+
+func (x *OrderBookResponse) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *OrderBookResponse) GetVersion() *StateVersion {
+	if x != nil {
+		return x.Version
+	}
+	return nil
+}
+
+type ChainStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChainStatusRequest) Reset() {
+	*x = ChainStatusRequest{}
+	mi := &file_wallet_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChainStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChainStatusRequest) ProtoMessage() {}
+
+func (x *ChainStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChainStatusRequest.ProtoReflect.Descriptor instead.
+func (*ChainStatusRequest) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{20}
+}
+
+type RoundMetric struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Round          uint64                 `protobuf:"varint,1,opt,name=round,proto3" json:"round,omitempty"`
+	BlockTimeNanos int64                  `protobuf:"varint,2,opt,name=block_time_nanos,json=blockTimeNanos,proto3" json:"block_time_nanos,omitempty"`
+	TxnCount       int32                  `protobuf:"varint,3,opt,name=txn_count,json=txnCount,proto3" json:"txn_count,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *RoundMetric) Reset() {
+	*x = RoundMetric{}
+	mi := &file_wallet_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RoundMetric) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RoundMetric) ProtoMessage() {}
+
+func (x *RoundMetric) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RoundMetric.ProtoReflect.Descriptor instead.
+func (*RoundMetric) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *RoundMetric) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *RoundMetric) GetBlockTimeNanos() int64 {
+	if x != nil {
+		return x.BlockTimeNanos
+	}
+	return 0
+}
+
+func (x *RoundMetric) GetTxnCount() int32 {
+	if x != nil {
+		return x.TxnCount
+	}
+	return 0
+}
+
+type ChainStatusResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ChainId         []byte                 `protobuf:"bytes,1,opt,name=chain_id,json=chainId,proto3" json:"chain_id,omitempty"`
+	Round           uint64                 `protobuf:"varint,2,opt,name=round,proto3" json:"round,omitempty"`
+	RandBeaconDepth uint64                 `protobuf:"varint,3,opt,name=rand_beacon_depth,json=randBeaconDepth,proto3" json:"rand_beacon_depth,omitempty"`
+	RoundMetrics    []*RoundMetric         `protobuf:"bytes,4,rep,name=round_metrics,json=roundMetrics,proto3" json:"round_metrics,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ChainStatusResponse) Reset() {
+	*x = ChainStatusResponse{}
+	mi := &file_wallet_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChainStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChainStatusResponse) ProtoMessage() {}
+
+func (x *ChainStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChainStatusResponse.ProtoReflect.Descriptor instead.
+func (*ChainStatusResponse) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ChainStatusResponse) GetChainId() []byte {
+	if x != nil {
+		return x.ChainId
+	}
+	return nil
+}
+
+func (x *ChainStatusResponse) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *ChainStatusResponse) GetRandBeaconDepth() uint64 {
+	if x != nil {
+		return x.RandBeaconDepth
+	}
+	return 0
+}
+
+func (x *ChainStatusResponse) GetRoundMetrics() []*RoundMetric {
+	if x != nil {
+		return x.RoundMetrics
+	}
+	return nil
+}
+
+type SubscribeTradesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Market        *Market                `protobuf:"bytes,1,opt,name=market,proto3" json:"market,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeTradesRequest) Reset() {
+	*x = SubscribeTradesRequest{}
+	mi := &file_wallet_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeTradesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeTradesRequest) ProtoMessage() {}
+
+func (x *SubscribeTradesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeTradesRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeTradesRequest) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *SubscribeTradesRequest) GetMarket() *Market {
+	if x != nil {
+		return x.Market
+	}
+	return nil
+}
+
+type TradeEvent struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Round    uint64                 `protobuf:"varint,1,opt,name=round,proto3" json:"round,omitempty"`
+	Market   *Market                `protobuf:"bytes,2,opt,name=market,proto3" json:"market,omitempty"`
+	Price    uint64                 `protobuf:"varint,3,opt,name=price,proto3" json:"price,omitempty"`
+	Quant    uint64                 `protobuf:"varint,4,opt,name=quant,proto3" json:"quant,omitempty"`
+	SellSide bool                   `protobuf:"varint,5,opt,name=sell_side,json=sellSide,proto3" json:"sell_side,omitempty"`
+	TxnHash  []byte                 `protobuf:"bytes,6,opt,name=txn_hash,json=txnHash,proto3" json:"txn_hash,omitempty"`
+	// the number of the events dropped right before the event since
+	// the client did not keep up
+	Dropped       uint64 `protobuf:"varint,7,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TradeEvent) Reset() {
+	*x = TradeEvent{}
+	mi := &file_wallet_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TradeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TradeEvent) ProtoMessage() {}
+
+func (x *TradeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TradeEvent.ProtoReflect.Descriptor instead.
+func (*TradeEvent) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *TradeEvent) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *TradeEvent) GetMarket() *Market {
+	if x != nil {
+		return x.Market
+	}
+	return nil
+}
+
+func (x *TradeEvent) GetPrice() uint64 {
+	if x != nil {
+		return x.Price
+	}
+	return 0
+}
+
+func (x *TradeEvent) GetQuant() uint64 {
+	if x != nil {
+		return x.Quant
+	}
+	return 0
+}
+
+func (x *TradeEvent) GetSellSide() bool {
+	if x != nil {
+		return x.SellSide
+	}
+	return false
+}
+
+func (x *TradeEvent) GetTxnHash() []byte {
+	if x != nil {
+		return x.TxnHash
+	}
+	return nil
+}
+
+func (x *TradeEvent) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+type AccountEvent struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Round            uint64                 `protobuf:"varint,1,opt,name=round,proto3" json:"round,omitempty"`
+	Addr             []byte                 `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	Balances         []*Balance             `protobuf:"bytes,3,rep,name=balances,proto3" json:"balances,omitempty"`
+	ExecutionReports []*ExecutionReport     `protobuf:"bytes,4,rep,name=execution_reports,json=executionReports,proto3" json:"execution_reports,omitempty"`
+	// the number of the events dropped right before the event since
+	// the client did not keep up
+	Dropped       uint64 `protobuf:"varint,5,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AccountEvent) Reset() {
+	*x = AccountEvent{}
+	mi := &file_wallet_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AccountEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AccountEvent) ProtoMessage() {}
+
+func (x *AccountEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_wallet_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AccountEvent.ProtoReflect.Descriptor instead.
+func (*AccountEvent) Descriptor() ([]byte, []int) {
+	return file_wallet_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *AccountEvent) GetRound() uint64 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *AccountEvent) GetAddr() []byte {
+	if x != nil {
+		return x.Addr
+	}
+	return nil
+}
+
+func (x *AccountEvent) GetBalances() []*Balance {
+	if x != nil {
+		return x.Balances
+	}
+	return nil
+}
+
+func (x *AccountEvent) GetExecutionReports() []*ExecutionReport {
+	if x != nil {
+		return x.ExecutionReports
+	}
+	return nil
+}
+
+func (x *AccountEvent) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+var File_wallet_proto protoreflect.FileDescriptor
+
+const file_wallet_proto_rawDesc = "" +
+	"\n" +
+	"\fwallet.proto\x12\n" +
+	"dex.wallet\"2\n" +
+	"\x06Market\x12\x12\n" +
+	"\x04base\x18\x01 \x01(\x04R\x04base\x12\x14\n" +
+	"\x05quote\x18\x02 \x01(\x04R\x05quote\"E\n" +
+	"\aOrderID\x12*\n" +
+	"\x06market\x18\x01 \x01(\v2\x12.dex.wallet.MarketR\x06market\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\x04R\x02id\"!\n" +
+	"\vAddrRequest\x12\x12\n" +
+	"\x04addr\x18\x01 \x01(\fR\x04addr\"G\n" +
+	"\x06Frozen\x12'\n" +
+	"\x0favailable_round\x18\x01 \x01(\x04R\x0eavailableRound\x12\x14\n" +
+	"\x05quant\x18\x02 \x01(\x04R\x05quant\"\x83\x01\n" +
+	"\aBalance\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\x04R\x05token\x12\x1c\n" +
+	"\tavailable\x18\x02 \x01(\x04R\tavailable\x12\x18\n" +
+	"\apending\x18\x03 \x01(\x04R\apending\x12*\n" +
+	"\x06frozen\x18\x04 \x03(\v2\x12.dex.wallet.FrozenR\x06frozen\"\xd1\x01\n" +
+	"\fPendingOrder\x12#\n" +
+	"\x02id\x18\x01 \x01(\v2\x13.dex.wallet.OrderIDR\x02id\x12\x14\n" +
+	"\x05owner\x18\x02 \x01(\fR\x05owner\x12\x1b\n" +
+	"\tsell_side\x18\x03 \x01(\bR\bsellSide\x12\x14\n" +
+	"\x05quant\x18\x04 \x01(\x04R\x05quant\x12\x14\n" +
+	"\x05price\x18\x05 \x01(\x04R\x05price\x12!\n" +
+	"\fexpire_round\x18\x06 \x01(\x04R\vexpireRound\x12\x1a\n" +
+	"\bexecuted\x18\a \x01(\x04R\bexecuted\"\xb2\x01\n" +
+	"\x0fExecutionReport\x12\x14\n" +
+	"\x05round\x18\x01 \x01(\x04R\x05round\x12#\n" +
+	"\x02id\x18\x02 \x01(\v2\x13.dex.wallet.OrderIDR\x02id\x12\x1b\n" +
+	"\tsell_side\x18\x03 \x01(\bR\bsellSide\x12\x1f\n" +
+	"\vtrade_price\x18\x04 \x01(\x04R\n" +
+	"tradePrice\x12\x14\n" +
+	"\x05quant\x18\x05 \x01(\x04R\x05quant\x12\x10\n" +
+	"\x03fee\x18\x06 \x01(\x04R\x03fee\"8\n" +
+	"\fStateVersion\x12\x14\n" +
+	"\x05round\x18\x01 \x01(\x04R\x05round\x12\x12\n" +
+	"\x04root\x18\x02 \x01(\fR\x04root\"\xe6\x02\n" +
+	"\x13WalletStateResponse\x12/\n" +
+	"\bbalances\x18\x01 \x03(\v2\x13.dex.wallet.BalanceR\bbalances\x12?\n" +
+	"\x0epending_orders\x18\x02 \x03(\v2\x18.dex.wallet.PendingOrderR\rpendingOrders\x12H\n" +
+	"\x11execution_reports\x18\x03 \x03(\v2\x1b.dex.wallet.ExecutionReportR\x10executionReports\x12\x14\n" +
+	"\x05round\x18\x04 \x01(\x04R\x05round\x12\x1c\n" +
+	"\ttruncated\x18\x05 \x01(\bR\ttruncated\x12+\n" +
+	"\x06cursor\x18\x06 \x01(\v2\x13.dex.wallet.OrderIDR\x06cursor\x122\n" +
+	"\aversion\x18\a \x01(\v2\x18.dex.wallet.StateVersionR\aversion\"\x0f\n" +
+	"\rTokensRequest\"\xa3\x01\n" +
+	"\x05Token\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x16\n" +
+	"\x06symbol\x18\x02 \x01(\tR\x06symbol\x12\x1a\n" +
+	"\bdecimals\x18\x03 \x01(\rR\bdecimals\x12\x1f\n" +
+	"\vtotal_units\x18\x04 \x01(\x04R\n" +
+	"totalUnits\x12\x1d\n" +
+	"\n" +
+	"max_supply\x18\x05 \x01(\x04R\tmaxSupply\x12\x16\n" +
+	"\x06issuer\x18\x06 \x01(\fR\x06issuer\";\n" +
+	"\x0eTokensResponse\x12)\n" +
+	"\x06tokens\x18\x01 \x03(\v2\x11.dex.wallet.TokenR\x06tokens\"%\n" +
+	"\rNonceResponse\x12\x14\n" +
+	"\x05nonce\x18\x01 \x01(\x04R\x05nonce\"C\n" +
+	"\x0eSendTxnRequest\x12\x10\n" +
+	"\x03txn\x18\x01 \x01(\fR\x03txn\x12\x1f\n" +
+	"\vwait_rounds\x18\x02 \x01(\rR\n" +
+	"waitRounds\"\xa8\x01\n" +
+	"\x0fSendTxnResponse\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\fR\x04hash\x12\x1a\n" +
+	"\baccepted\x18\x02 \x01(\bR\baccepted\x12\x16\n" +
+	"\x06result\x18\x03 \x01(\x05R\x06result\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\x125\n" +
+	"\x06status\x18\x05 \x01(\v2\x1d.dex.wallet.TxnStatusResponseR\x06status\"&\n" +
+	"\x10TxnStatusRequest\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\fR\x04hash\"o\n" +
+	"\x11TxnStatusResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\x05R\x06status\x12\x14\n" +
+	"\x05round\x18\x02 \x01(\x04R\x05round\x12\x14\n" +
+	"\x05block\x18\x03 \x01(\fR\x05block\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\"V\n" +
+	"\x10OrderBookRequest\x12*\n" +
+	"\x06market\x18\x01 \x01(\v2\x12.dex.wallet.MarketR\x06market\x12\x16\n" +
+	"\x06levels\x18\x02 \x01(\x05R\x06levels\"P\n" +
+	"\n" +
+	"PriceLevel\x12\x14\n" +
+	"\x05price\x18\x01 \x01(\x04R\x05price\x12\x14\n" +
+	"\x05quant\x18\x02 \x01(\x04R\x05quant\x12\x16\n" +
+	"\x06orders\x18\x03 \x01(\x05R\x06orders\"\xfa\x01\n" +
+	"\x11OrderBookResponse\x12*\n" +
+	"\x04bids\x18\x01 \x03(\v2\x16.dex.wallet.PriceLevelR\x04bids\x12*\n" +
+	"\x04asks\x18\x02 \x03(\v2\x16.dex.wallet.PriceLevelR\x04asks\x12\x1d\n" +
+	"\n" +
+	"last_price\x18\x03 \x01(\x04R\tlastPrice\x12$\n" +
+	"\x0ehas_last_price\x18\x04 \x01(\bR\fhasLastPrice\x12\x14\n" +
+	"\x05round\x18\x05 \x01(\x04R\x05round\x122\n" +
+	"\aversion\x18\x06 \x01(\v2\x18.dex.wallet.StateVersionR\aversion\"\x14\n" +
+	"\x12ChainStatusRequest\"j\n" +
+	"\vRoundMetric\x12\x14\n" +
+	"\x05round\x18\x01 \x01(\x04R\x05round\x12(\n" +
+	"\x10block_time_nanos\x18\x02 \x01(\x03R\x0eblockTimeNanos\x12\x1b\n" +
+	"\ttxn_count\x18\x03 \x01(\x05R\btxnCount\"\xb0\x01\n" +
+	"\x13ChainStatusResponse\x12\x19\n" +
+	"\bchain_id\x18\x01 \x01(\fR\achainId\x12\x14\n" +
+	"\x05round\x18\x02 \x01(\x04R\x05round\x12*\n" +
+	"\x11rand_beacon_depth\x18\x03 \x01(\x04R\x0frandBeaconDepth\x12<\n" +
+	"\rround_metrics\x18\x04 \x03(\v2\x17.dex.wallet.RoundMetricR\froundMetrics\"D\n" +
+	"\x16SubscribeTradesRequest\x12*\n" +
+	"\x06market\x18\x01 \x01(\v2\x12.dex.wallet.MarketR\x06market\"\xcc\x01\n" +
+	"\n" +
+	"TradeEvent\x12\x14\n" +
+	"\x05round\x18\x01 \x01(\x04R\x05round\x12*\n" +
+	"\x06market\x18\x02 \x01(\v2\x12.dex.wallet.MarketR\x06market\x12\x14\n" +
+	"\x05price\x18\x03 \x01(\x04R\x05price\x12\x14\n" +
+	"\x05quant\x18\x04 \x01(\x04R\x05quant\x12\x1b\n" +
+	"\tsell_side\x18\x05 \x01(\bR\bsellSide\x12\x19\n" +
+	"\btxn_hash\x18\x06 \x01(\fR\atxnHash\x12\x18\n" +
+	"\adropped\x18\a \x01(\x04R\adropped\"\xcd\x01\n" +
+	"\fAccountEvent\x12\x14\n" +
+	"\x05round\x18\x01 \x01(\x04R\x05round\x12\x12\n" +
+	"\x04addr\x18\x02 \x01(\fR\x04addr\x12/\n" +
+	"\bbalances\x18\x03 \x03(\v2\x13.dex.wallet.BalanceR\bbalances\x12H\n" +
+	"\x11execution_reports\x18\x04 \x03(\v2\x1b.dex.wallet.ExecutionReportR\x10executionReports\x12\x18\n" +
+	"\adropped\x18\x05 \x01(\x04R\adropped2\x91\x05\n" +
+	"\x06Wallet\x12G\n" +
+	"\vWalletState\x12\x17.dex.wallet.AddrRequest\x1a\x1f.dex.wallet.WalletStateResponse\x12?\n" +
+	"\x06Tokens\x12\x19.dex.wallet.TokensRequest\x1a\x1a.dex.wallet.TokensResponse\x12;\n" +
+	"\x05Nonce\x12\x17.dex.wallet.AddrRequest\x1a\x19.dex.wallet.NonceResponse\x12B\n" +
+	"\aSendTxn\x12\x1a.dex.wallet.SendTxnRequest\x1a\x1b.dex.wallet.SendTxnResponse\x12H\n" +
+	"\tTxnStatus\x12\x1c.dex.wallet.TxnStatusRequest\x1a\x1d.dex.wallet.TxnStatusResponse\x12H\n" +
+	"\tOrderBook\x12\x1c.dex.wallet.OrderBookRequest\x1a\x1d.dex.wallet.OrderBookResponse\x12N\n" +
+	"\vChainStatus\x12\x1e.dex.wallet.ChainStatusRequest\x1a\x1f.dex.wallet.ChainStatusResponse\x12O\n" +
+	"\x0fSubscribeTrades\x12\".dex.wallet.SubscribeTradesRequest\x1a\x16.dex.wallet.TradeEvent0\x01\x12G\n" +
+	"\x10SubscribeAccount\x12\x17.dex.wallet.AddrRequest\x1a\x18.dex.wallet.AccountEvent0\x01B'Z%github.com/helinwang/dex/pkg/dex/grpcb\x06proto3"
+
+var (
+	file_wallet_proto_rawDescOnce sync.Once
+	file_wallet_proto_rawDescData []byte
+)
+
+func file_wallet_proto_rawDescGZIP() []byte {
+	file_wallet_proto_rawDescOnce.Do(func() {
+		file_wallet_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_wallet_proto_rawDesc), len(file_wallet_proto_rawDesc)))
+	})
+	return file_wallet_proto_rawDescData
+}
+
+var file_wallet_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
+var file_wallet_proto_goTypes = []any{
+	(*Market)(nil),                 // 0: dex.wallet.Market
+	(*OrderID)(nil),                // 1: dex.wallet.OrderID
+	(*AddrRequest)(nil),            // 2: dex.wallet.AddrRequest
+	(*Frozen)(nil),                 // 3: dex.wallet.Frozen
+	(*Balance)(nil),                // 4: dex.wallet.Balance
+	(*PendingOrder)(nil),           // 5: dex.wallet.PendingOrder
+	(*ExecutionReport)(nil),        // 6: dex.wallet.ExecutionReport
+	(*StateVersion)(nil),           // 7: dex.wallet.StateVersion
+	(*WalletStateResponse)(nil),    // 8: dex.wallet.WalletStateResponse
+	(*TokensRequest)(nil),          // 9: dex.wallet.TokensRequest
+	(*Token)(nil),                  // 10: dex.wallet.Token
+	(*TokensResponse)(nil),         // 11: dex.wallet.TokensResponse
+	(*NonceResponse)(nil),          // 12: dex.wallet.NonceResponse
+	(*SendTxnRequest)(nil),         // 13: dex.wallet.SendTxnRequest
+	(*SendTxnResponse)(nil),        // 14: dex.wallet.SendTxnResponse
+	(*TxnStatusRequest)(nil),       // 15: dex.wallet.TxnStatusRequest
+	(*TxnStatusResponse)(nil),      // 16: dex.wallet.TxnStatusResponse
+	(*OrderBookRequest)(nil),       // 17: dex.wallet.OrderBookRequest
+	(*PriceLevel)(nil),             // 18: dex.wallet.PriceLevel
+	(*OrderBookResponse)(nil),      // 19: dex.wallet.OrderBookResponse
+	(*ChainStatusRequest)(nil),     // 20: dex.wallet.ChainStatusRequest
+	(*RoundMetric)(nil),            // 21: dex.wallet.RoundMetric
+	(*ChainStatusResponse)(nil),    // 22: dex.wallet.ChainStatusResponse
+	(*SubscribeTradesRequest)(nil), // 23: dex.wallet.SubscribeTradesRequest
+	(*TradeEvent)(nil),             // 24: dex.wallet.TradeEvent
+	(*AccountEvent)(nil),           // 25: dex.wallet.AccountEvent
+}
+var file_wallet_proto_depIdxs = []int32{
+	0,  // 0: dex.wallet.OrderID.market:type_name -> dex.wallet.Market
+	3,  // 1: dex.wallet.Balance.frozen:type_name -> dex.wallet.Frozen
+	1,  // 2: dex.wallet.PendingOrder.id:type_name -> dex.wallet.OrderID
+	1,  // 3: dex.wallet.ExecutionReport.id:type_name -> dex.wallet.OrderID
+	4,  // 4: dex.wallet.WalletStateResponse.balances:type_name -> dex.wallet.Balance
+	5,  // 5: dex.wallet.WalletStateResponse.pending_orders:type_name -> dex.wallet.PendingOrder
+	6,  // 6: dex.wallet.WalletStateResponse.execution_reports:type_name -> dex.wallet.ExecutionReport
+	1,  // 7: dex.wallet.WalletStateResponse.cursor:type_name -> dex.wallet.OrderID
+	7,  // 8: dex.wallet.WalletStateResponse.version:type_name -> dex.wallet.StateVersion
+	10, // 9: dex.wallet.TokensResponse.tokens:type_name -> dex.wallet.Token
+	16, // 10: dex.wallet.SendTxnResponse.status:type_name -> dex.wallet.TxnStatusResponse
+	0,  // 11: dex.wallet.OrderBookRequest.market:type_name -> dex.wallet.Market
+	18, // 12: dex.wallet.OrderBookResponse.bids:type_name -> dex.wallet.PriceLevel
+	18, // 13: dex.wallet.OrderBookResponse.asks:type_name -> dex.wallet.PriceLevel
+	7,  // 14: dex.wallet.OrderBookResponse.version:type_name -> dex.wallet.StateVersion
+	21, // 15: dex.wallet.ChainStatusResponse.round_metrics:type_name -> dex.wallet.RoundMetric
+	0,  // 16: dex.wallet.SubscribeTradesRequest.market:type_name -> dex.wallet.Market
+	0,  // 17: dex.wallet.TradeEvent.market:type_name -> dex.wallet.Market
+	4,  // 18: dex.wallet.AccountEvent.balances:type_name -> dex.wallet.Balance
+	6,  // 19: dex.wallet.AccountEvent.execution_reports:type_name -> dex.wallet.ExecutionReport
+	2,  // 20: dex.wallet.Wallet.WalletState:input_type -> dex.wallet.AddrRequest
+	9,  // 21: dex.wallet.Wallet.Tokens:input_type -> dex.wallet.TokensRequest
+	2,  // 22: dex.wallet.Wallet.Nonce:input_type -> dex.wallet.AddrRequest
+	13, // 23: dex.wallet.Wallet.SendTxn:input_type -> dex.wallet.SendTxnRequest
+	15, // 24: dex.wallet.Wallet.TxnStatus:input_type -> dex.wallet.TxnStatusRequest
+	17, // 25: dex.wallet.Wallet.OrderBook:input_type -> dex.wallet.OrderBookRequest
+	20, // 26: dex.wallet.Wallet.ChainStatus:input_type -> dex.wallet.ChainStatusRequest
+	23, // 27: dex.wallet.Wallet.SubscribeTrades:input_type -> dex.wallet.SubscribeTradesRequest
+	2,  // 28: dex.wallet.Wallet.SubscribeAccount:input_type -> dex.wallet.AddrRequest
+	8,  // 29: dex.wallet.Wallet.WalletState:output_type -> dex.wallet.WalletStateResponse
+	11, // 30: dex.wallet.Wallet.Tokens:output_type -> dex.wallet.TokensResponse
+	12, // 31: dex.wallet.Wallet.Nonce:output_type -> dex.wallet.NonceResponse
+	14, // 32: dex.wallet.Wallet.SendTxn:output_type -> dex.wallet.SendTxnResponse
+	16, // 33: dex.wallet.Wallet.TxnStatus:output_type -> dex.wallet.TxnStatusResponse
+	19, // 34: dex.wallet.Wallet.OrderBook:output_type -> dex.wallet.OrderBookResponse
+	22, // 35: dex.wallet.Wallet.ChainStatus:output_type -> dex.wallet.ChainStatusResponse
+	24, // 36: dex.wallet.Wallet.SubscribeTrades:output_type -> dex.wallet.TradeEvent
+	25, // 37: dex.wallet.Wallet.SubscribeAccount:output_type -> dex.wallet.AccountEvent
+	29, // [29:38] is the sub-list for method output_type
+	20, // [20:29] is the sub-list for method input_type
+	20, // [20:20] is the sub-list for extension type_name
+	20, // [20:20] is the sub-list for extension extendee
+	0,  // [0:20] is the sub-list for field type_name
+}
+
+func init() { file_wallet_proto_init() }
+func file_wallet_proto_init() {
+	if File_wallet_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_wallet_proto_rawDesc), len(file_wallet_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   26,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_wallet_proto_goTypes,
+		DependencyIndexes: file_wallet_proto_depIdxs,
+		MessageInfos:      file_wallet_proto_msgTypes,
+	}.Build()
+	File_wallet_proto = out.File
+	file_wallet_proto_goTypes = nil
+	file_wallet_proto_depIdxs = nil
+}
@@ -0,0 +1,465 @@
+// The gRPC service of the wallet RPC. It mirrors the net/rpc
+// WalletService, the messages mirror the types of the dex package.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: wallet.proto
+
+package grpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Wallet_WalletState_FullMethodName      = "/dex.wallet.Wallet/WalletState"
+	Wallet_Tokens_FullMethodName           = "/dex.wallet.Wallet/Tokens"
+	Wallet_Nonce_FullMethodName            = "/dex.wallet.Wallet/Nonce"
+	Wallet_SendTxn_FullMethodName          = "/dex.wallet.Wallet/SendTxn"
+	Wallet_TxnStatus_FullMethodName        = "/dex.wallet.Wallet/TxnStatus"
+	Wallet_OrderBook_FullMethodName        = "/dex.wallet.Wallet/OrderBook"
+	Wallet_ChainStatus_FullMethodName      = "/dex.wallet.Wallet/ChainStatus"
+	Wallet_SubscribeTrades_FullMethodName  = "/dex.wallet.Wallet/SubscribeTrades"
+	Wallet_SubscribeAccount_FullMethodName = "/dex.wallet.Wallet/SubscribeAccount"
+)
+
+// WalletClient is the client API for Wallet service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WalletClient interface {
+	// WalletState returns the balances and the pending orders of the
+	// account.
+	WalletState(ctx context.Context, in *AddrRequest, opts ...grpc.CallOption) (*WalletStateResponse, error)
+	// Tokens returns the tokens of the chain.
+	Tokens(ctx context.Context, in *TokensRequest, opts ...grpc.CallOption) (*TokensResponse, error)
+	// Nonce returns the next nonce of the account.
+	Nonce(ctx context.Context, in *AddrRequest, opts ...grpc.CallOption) (*NonceResponse, error)
+	// SendTxn validates the signed txn against the pool and broadcasts
+	// it if it is accepted, a rejected txn is not an error. If
+	// wait_rounds is set, it waits for the accepted txn to be included
+	// in a block.
+	SendTxn(ctx context.Context, in *SendTxnRequest, opts ...grpc.CallOption) (*SendTxnResponse, error)
+	// TxnStatus returns the status of the txn with the given hash.
+	TxnStatus(ctx context.Context, in *TxnStatusRequest, opts ...grpc.CallOption) (*TxnStatusResponse, error)
+	// OrderBook returns the price levels of the market's order book.
+	OrderBook(ctx context.Context, in *OrderBookRequest, opts ...grpc.CallOption) (*OrderBookResponse, error)
+	// ChainStatus returns the status of the node's chain.
+	ChainStatus(ctx context.Context, in *ChainStatusRequest, opts ...grpc.CallOption) (*ChainStatusResponse, error)
+	// SubscribeTrades streams the trades of the market's finalized
+	// rounds.
+	SubscribeTrades(ctx context.Context, in *SubscribeTradesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TradeEvent], error)
+	// SubscribeAccount streams the balance and order changes of the
+	// account in the finalized rounds.
+	SubscribeAccount(ctx context.Context, in *AddrRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AccountEvent], error)
+}
+
+type walletClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWalletClient(cc grpc.ClientConnInterface) WalletClient {
+	return &walletClient{cc}
+}
+
+func (c *walletClient) WalletState(ctx context.Context, in *AddrRequest, opts ...grpc.CallOption) (*WalletStateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WalletStateResponse)
+	err := c.cc.Invoke(ctx, Wallet_WalletState_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) Tokens(ctx context.Context, in *TokensRequest, opts ...grpc.CallOption) (*TokensResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TokensResponse)
+	err := c.cc.Invoke(ctx, Wallet_Tokens_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) Nonce(ctx context.Context, in *AddrRequest, opts ...grpc.CallOption) (*NonceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NonceResponse)
+	err := c.cc.Invoke(ctx, Wallet_Nonce_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) SendTxn(ctx context.Context, in *SendTxnRequest, opts ...grpc.CallOption) (*SendTxnResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendTxnResponse)
+	err := c.cc.Invoke(ctx, Wallet_SendTxn_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) TxnStatus(ctx context.Context, in *TxnStatusRequest, opts ...grpc.CallOption) (*TxnStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TxnStatusResponse)
+	err := c.cc.Invoke(ctx, Wallet_TxnStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) OrderBook(ctx context.Context, in *OrderBookRequest, opts ...grpc.CallOption) (*OrderBookResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OrderBookResponse)
+	err := c.cc.Invoke(ctx, Wallet_OrderBook_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) ChainStatus(ctx context.Context, in *ChainStatusRequest, opts ...grpc.CallOption) (*ChainStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ChainStatusResponse)
+	err := c.cc.Invoke(ctx, Wallet_ChainStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walletClient) SubscribeTrades(ctx context.Context, in *SubscribeTradesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TradeEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Wallet_ServiceDesc.Streams[0], Wallet_SubscribeTrades_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeTradesRequest, TradeEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Wallet_SubscribeTradesClient = grpc.ServerStreamingClient[TradeEvent]
+
+func (c *walletClient) SubscribeAccount(ctx context.Context, in *AddrRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AccountEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Wallet_ServiceDesc.Streams[1], Wallet_SubscribeAccount_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AddrRequest, AccountEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Wallet_SubscribeAccountClient = grpc.ServerStreamingClient[AccountEvent]
+
+// WalletServer is the server API for Wallet service.
+// All implementations must embed UnimplementedWalletServer
+// for forward compatibility.
+type WalletServer interface {
+	// WalletState returns the balances and the pending orders of the
+	// account.
+	WalletState(context.Context, *AddrRequest) (*WalletStateResponse, error)
+	// Tokens returns the tokens of the chain.
+	Tokens(context.Context, *TokensRequest) (*TokensResponse, error)
+	// Nonce returns the next nonce of the account.
+	Nonce(context.Context, *AddrRequest) (*NonceResponse, error)
+	// SendTxn validates the signed txn against the pool and broadcasts
+	// it if it is accepted, a rejected txn is not an error. If
+	// wait_rounds is set, it waits for the accepted txn to be included
+	// in a block.
+	SendTxn(context.Context, *SendTxnRequest) (*SendTxnResponse, error)
+	// TxnStatus returns the status of the txn with the given hash.
+	TxnStatus(context.Context, *TxnStatusRequest) (*TxnStatusResponse, error)
+	// OrderBook returns the price levels of the market's order book.
+	OrderBook(context.Context, *OrderBookRequest) (*OrderBookResponse, error)
+	// ChainStatus returns the status of the node's chain.
+	ChainStatus(context.Context, *ChainStatusRequest) (*ChainStatusResponse, error)
+	// SubscribeTrades streams the trades of the market's finalized
+	// rounds.
+	SubscribeTrades(*SubscribeTradesRequest, grpc.ServerStreamingServer[TradeEvent]) error
+	// SubscribeAccount streams the balance and order changes of the
+	// account in the finalized rounds.
+	SubscribeAccount(*AddrRequest, grpc.ServerStreamingServer[AccountEvent]) error
+	mustEmbedUnimplementedWalletServer()
+}
+
+// UnimplementedWalletServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedWalletServer struct{}
+
+func (UnimplementedWalletServer) WalletState(context.Context, *AddrRequest) (*WalletStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WalletState not implemented")
+}
+func (UnimplementedWalletServer) Tokens(context.Context, *TokensRequest) (*TokensResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Tokens not implemented")
+}
+func (UnimplementedWalletServer) Nonce(context.Context, *AddrRequest) (*NonceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Nonce not implemented")
+}
+func (UnimplementedWalletServer) SendTxn(context.Context, *SendTxnRequest) (*SendTxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendTxn not implemented")
+}
+func (UnimplementedWalletServer) TxnStatus(context.Context, *TxnStatusRequest) (*TxnStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TxnStatus not implemented")
+}
+func (UnimplementedWalletServer) OrderBook(context.Context, *OrderBookRequest) (*OrderBookResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method OrderBook not implemented")
+}
+func (UnimplementedWalletServer) ChainStatus(context.Context, *ChainStatusRequest) (*ChainStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChainStatus not implemented")
+}
+func (UnimplementedWalletServer) SubscribeTrades(*SubscribeTradesRequest, grpc.ServerStreamingServer[TradeEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeTrades not implemented")
+}
+func (UnimplementedWalletServer) SubscribeAccount(*AddrRequest, grpc.ServerStreamingServer[AccountEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeAccount not implemented")
+}
+func (UnimplementedWalletServer) mustEmbedUnimplementedWalletServer() {}
+func (UnimplementedWalletServer) testEmbeddedByValue()                {}
+
+// UnsafeWalletServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WalletServer will
+// result in compilation errors.
+type UnsafeWalletServer interface {
+	mustEmbedUnimplementedWalletServer()
+}
+
+func RegisterWalletServer(s grpc.ServiceRegistrar, srv WalletServer) {
+	// If the following call pancis, it indicates UnimplementedWalletServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Wallet_ServiceDesc, srv)
+}
+
+func _Wallet_WalletState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).WalletState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_WalletState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).WalletState(ctx, req.(*AddrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_Tokens_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokensRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).Tokens(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_Tokens_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).Tokens(ctx, req.(*TokensRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_Nonce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).Nonce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_Nonce_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).Nonce(ctx, req.(*AddrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_SendTxn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendTxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).SendTxn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_SendTxn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).SendTxn(ctx, req.(*SendTxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_TxnStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TxnStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).TxnStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_TxnStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).TxnStatus(ctx, req.(*TxnStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_OrderBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OrderBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).OrderBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_OrderBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).OrderBook(ctx, req.(*OrderBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_ChainStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChainStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).ChainStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Wallet_ChainStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).ChainStatus(ctx, req.(*ChainStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Wallet_SubscribeTrades_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeTradesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WalletServer).SubscribeTrades(m, &grpc.GenericServerStream[SubscribeTradesRequest, TradeEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Wallet_SubscribeTradesServer = grpc.ServerStreamingServer[TradeEvent]
+
+func _Wallet_SubscribeAccount_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AddrRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WalletServer).SubscribeAccount(m, &grpc.GenericServerStream[AddrRequest, AccountEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Wallet_SubscribeAccountServer = grpc.ServerStreamingServer[AccountEvent]
+
+// Wallet_ServiceDesc is the grpc.ServiceDesc for Wallet service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Wallet_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dex.wallet.Wallet",
+	HandlerType: (*WalletServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "WalletState",
+			Handler:    _Wallet_WalletState_Handler,
+		},
+		{
+			MethodName: "Tokens",
+			Handler:    _Wallet_Tokens_Handler,
+		},
+		{
+			MethodName: "Nonce",
+			Handler:    _Wallet_Nonce_Handler,
+		},
+		{
+			MethodName: "SendTxn",
+			Handler:    _Wallet_SendTxn_Handler,
+		},
+		{
+			MethodName: "TxnStatus",
+			Handler:    _Wallet_TxnStatus_Handler,
+		},
+		{
+			MethodName: "OrderBook",
+			Handler:    _Wallet_OrderBook_Handler,
+		},
+		{
+			MethodName: "ChainStatus",
+			Handler:    _Wallet_ChainStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeTrades",
+			Handler:       _Wallet_SubscribeTrades_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeAccount",
+			Handler:       _Wallet_SubscribeAccount_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "wallet.proto",
+}
@@ -0,0 +1,108 @@
+package dex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/helinwang/log15"
+)
+
+// The HTTP paths of the health endpoints used by the load balancers.
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// PeerCounter reports the number of the connected peers of the node.
+type PeerCounter interface {
+	PeerCount() int
+}
+
+// ReadinessConfig is the condition for the node to be ready to serve
+// the wallet traffic.
+type ReadinessConfig struct {
+	// MaxRoundsBehind is how many rounds the chain can be behind
+	// the random beacon.
+	MaxRoundsBehind uint64
+	// MinPeers is the minimum number of the connected peers, 0
+	// does not check the peers.
+	MinPeers int
+}
+
+// SetReadiness sets the condition checked by the readiness endpoint.
+func (r *RPCServer) SetReadiness(cfg ReadinessConfig) {
+	r.readiness = cfg
+}
+
+// SetPeerCounter sets the peer counter checked by the readiness
+// endpoint.
+func (r *RPCServer) SetPeerCounter(p PeerCounter) {
+	r.peers = p
+}
+
+// healthStatus is the response body of the health endpoints.
+type healthStatus struct {
+	Status string `json:"status"`
+	// the failed conditions, empty if the node is ready
+	Failing []string `json:"failing,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, failing []string) {
+	code := http.StatusOK
+	status := healthStatus{Status: "ok", Failing: failing}
+	if len(failing) > 0 {
+		code = http.StatusServiceUnavailable
+		status.Status = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Error("error writing health response", "err", err)
+	}
+}
+
+// healthz reports that the process is alive.
+func (r *RPCServer) healthz(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, nil)
+}
+
+// readyz reports whether the node is ready to serve the wallet
+// traffic: the state is set, the chain is in sync and the node has
+// enough peers.
+func (r *RPCServer) readyz(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, r.notReady())
+}
+
+// notReady returns the readiness conditions that are not met.
+func (r *RPCServer) notReady() []string {
+	var failing []string
+	r.mu.Lock()
+	s := r.s
+	chain := r.chain
+	r.mu.Unlock()
+
+	if s == nil {
+		failing = append(failing, "the state is not ready")
+	}
+
+	if chain == nil {
+		failing = append(failing, "the chain status is unknown")
+	} else {
+		status := chain.ChainStatus()
+		if status.RandBeaconDepth > status.Round+r.readiness.MaxRoundsBehind {
+			failing = append(failing, fmt.Sprintf("the chain is at round %d, %d rounds behind the random beacon", status.Round, status.RandBeaconDepth-status.Round))
+		}
+	}
+
+	if min := r.readiness.MinPeers; min > 0 {
+		if r.peers == nil {
+			failing = append(failing, "the peer count is unknown")
+		} else if n := r.peers.PeerCount(); n < min {
+			failing = append(failing, fmt.Sprintf("%d peers connected, %d required", n, min))
+		}
+	}
+	return failing
+}
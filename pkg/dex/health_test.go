@@ -0,0 +1,81 @@
+package dex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPeerCounter int
+
+func (p testPeerCounter) PeerCount() int {
+	return int(p)
+}
+
+type syncingTestChain struct {
+	restTestChain
+}
+
+func (syncingTestChain) ChainStatus() consensus.ChainStatus {
+	return consensus.ChainStatus{Round: 3, RandBeaconDepth: 10}
+}
+
+func getHealth(t *testing.T, url string) (int, healthStatus) {
+	resp, err := http.Get(url)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var status healthStatus
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+	return resp.StatusCode, status
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	r := NewRPCServer()
+	r.SetStater(restTestChain{})
+	r.SetReadiness(ReadinessConfig{MaxRoundsBehind: 1, MinPeers: 2})
+	r.SetRateLimit(RateLimitConfig{Read: RateLimit{Rate: 1, Burst: 1}})
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// the state is not synced yet
+	code, status := getHealth(t, srv.URL+ReadyzPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", status.Status)
+	assert.Equal(t, []string{"the state is not ready", "the peer count is unknown"}, status.Failing)
+
+	// the process is alive, the health endpoints are not rate
+	// limited
+	for i := 0; i < 3; i++ {
+		code, status = getHealth(t, srv.URL+HealthzPath)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ok", status.Status)
+	}
+
+	s, _, _, _, _ := newTIFTestState()
+	s.CommitCache()
+	r.Update(s)
+	r.SetPeerCounter(testPeerCounter(1))
+	code, status = getHealth(t, srv.URL+ReadyzPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"1 peers connected, 2 required"}, status.Failing)
+
+	r.SetPeerCounter(testPeerCounter(2))
+	code, status = getHealth(t, srv.URL+ReadyzPath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", status.Status)
+	assert.Empty(t, status.Failing)
+
+	r.SetStater(syncingTestChain{})
+	code, status = getHealth(t, srv.URL+ReadyzPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"the chain is at round 3, 7 rounds behind the random beacon"}, status.Failing)
+}
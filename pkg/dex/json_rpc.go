@@ -0,0 +1,957 @@
+package dex
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	log "github.com/helinwang/log15"
+)
+
+// The JSON-RPC error codes. The codes from -32700 to -32600 are
+// defined by the JSON-RPC 2.0 spec, the others are the server errors
+// of the wallet service. The codes are stable, clients can depend on
+// them.
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	// JSONRPCNotReady means the node has not reached consensus
+	// yet, the client should retry later.
+	JSONRPCNotReady = -32000
+	// JSONRPCTxnRejected means the txn is rejected by the txn
+	// pool.
+	JSONRPCTxnRejected = -32001
+	// JSONRPCServerError means the call failed for the other
+	// reasons, e.g., the account does not exist.
+	JSONRPCServerError = -32002
+	// JSONRPCRateLimited means the client exceeded its rate
+	// limit, the client should retry later.
+	JSONRPCRateLimited = -32003
+	// JSONRPCClientCertRequired means the call changes the state
+	// and requires a verified client certificate.
+	JSONRPCClientCertRequired = -32004
+	// JSONRPCUnauthorized means the call requires the bearer
+	// token in the Authorization header.
+	JSONRPCUnauthorized = -32005
+	// JSONRPCNotArchiveNode means the historical query requires
+	// a node retaining the state snapshots.
+	JSONRPCNotArchiveNode = -32006
+)
+
+// maxJSONRPCRequestSize is the maximum body size of a JSON-RPC
+// request.
+const maxJSONRPCRequestSize = 1 << 20
+
+// JSONRPCError is the error object of a JSON-RPC response.
+type JSONRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Data carries the code of the RPCError, it is set for all
+	// the errors returned by the server.
+	Data *JSONRPCErrorData `json:"data,omitempty"`
+}
+
+// JSONRPCErrorData is the data of a JSON-RPC error.
+type JSONRPCErrorData struct {
+	Code ErrorCode `json:"code"`
+}
+
+func newJSONRPCError(code int, msg string) *JSONRPCError {
+	return &JSONRPCError{Code: code, Message: msg, Data: &JSONRPCErrorData{Code: jsonRPCErrorCode(code)}}
+}
+
+// jsonRPCErrorCode returns the RPCError code of the JSON-RPC error
+// code.
+func jsonRPCErrorCode(code int) ErrorCode {
+	switch code {
+	case JSONRPCParseError, JSONRPCInvalidRequest, JSONRPCMethodNotFound, JSONRPCInvalidParams:
+		return CodeInvalidRequest
+	case JSONRPCNotReady:
+		return CodeNotSynced
+	case JSONRPCTxnRejected:
+		return CodeInvalidTxn
+	case JSONRPCRateLimited:
+		return CodeRateLimited
+	case JSONRPCClientCertRequired, JSONRPCUnauthorized:
+		return CodeUnauthorized
+	case JSONRPCNotArchiveNode:
+		return CodeUnsupported
+	}
+	return CodeInternal
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+}
+
+type jsonRPCRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonRPCResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonRPCParams is the params of a call indexed by name, the params
+// passed by position are named according to the method.
+type jsonRPCParams map[string]json.RawMessage
+
+func invalidParams(name string, err error) error {
+	return newJSONRPCError(JSONRPCInvalidParams, fmt.Sprintf("param %s: %v", name, err))
+}
+
+func (p jsonRPCParams) str(name string) (string, error) {
+	raw, ok := p[name]
+	if !ok {
+		return "", invalidParams(name, errors.New("missing"))
+	}
+
+	var s string
+	err := json.Unmarshal(raw, &s)
+	if err != nil {
+		return "", invalidParams(name, errors.New("should be a string"))
+	}
+	return s, nil
+}
+
+func decodeHexString(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
+
+func (p jsonRPCParams) addr(name string) (consensus.Addr, error) {
+	s, err := p.str(name)
+	if err != nil {
+		return consensus.Addr{}, err
+	}
+
+	return parseAddrParam(name, s)
+}
+
+func parseAddrParam(name, s string) (consensus.Addr, error) {
+	var addr consensus.Addr
+	b, err := decodeHexString(s)
+	if err != nil || len(b) != len(addr) {
+		return addr, invalidParams(name, fmt.Errorf("should be a %d bytes hex string", len(addr)))
+	}
+
+	copy(addr[:], b)
+	return addr, nil
+}
+
+func (p jsonRPCParams) hash(name string) (consensus.Hash, error) {
+	var h consensus.Hash
+	s, err := p.str(name)
+	if err != nil {
+		return h, err
+	}
+
+	b, err := decodeHexString(s)
+	if err != nil || len(b) != len(h) {
+		return h, invalidParams(name, fmt.Errorf("should be a %d bytes hex string", len(h)))
+	}
+
+	copy(h[:], b)
+	return h, nil
+}
+
+// bytes decodes a "0x" prefixed hex string, or a standard base64
+// string.
+func (p jsonRPCParams) bytes(name string) ([]byte, error) {
+	s, err := p.str(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	if strings.HasPrefix(s, "0x") {
+		b, err = hex.DecodeString(s[2:])
+	} else {
+		b, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, invalidParams(name, errors.New("should be a 0x prefixed hex string or a base64 string"))
+	}
+	return b, nil
+}
+
+func (p jsonRPCParams) tokenID(name string) (TokenID, error) {
+	s, err := p.str(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseTokenIDParam(name, s)
+}
+
+func parseTokenIDParam(name, s string) (TokenID, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return 0, invalidParams(name, errors.New("should be a hex string"))
+	}
+	return TokenID(id), nil
+}
+
+// uint returns the param as an unsigned integer, the param is
+// optional, 0 is returned if it is missing.
+func (p jsonRPCParams) uint(name string) (uint64, error) {
+	raw, ok := p[name]
+	if !ok {
+		return 0, nil
+	}
+
+	var v uint64
+	err := json.Unmarshal(raw, &v)
+	if err != nil {
+		return 0, invalidParams(name, errors.New("should be an unsigned integer"))
+	}
+	return v, nil
+}
+
+// bool returns the param as a boolean, the param is optional, false
+// is returned if it is missing.
+func (p jsonRPCParams) bool(name string) (bool, error) {
+	raw, ok := p[name]
+	if !ok {
+		return false, nil
+	}
+
+	var v bool
+	err := json.Unmarshal(raw, &v)
+	if err != nil {
+		return false, invalidParams(name, errors.New("should be a boolean"))
+	}
+	return v, nil
+}
+
+func (p jsonRPCParams) market() (MarketSymbol, error) {
+	base, err := p.tokenID("base")
+	if err != nil {
+		return MarketSymbol{}, err
+	}
+
+	quote, err := p.tokenID("quote")
+	if err != nil {
+		return MarketSymbol{}, err
+	}
+
+	return MarketSymbol{Base: base, Quote: quote}, nil
+}
+
+type jsonRPCMethod struct {
+	// the names of the params passed by position
+	params []string
+	call   func(s *RPCServer, p jsonRPCParams) (interface{}, error)
+}
+
+func addrMethod(call func(s *RPCServer, addr consensus.Addr) (interface{}, error)) jsonRPCMethod {
+	return jsonRPCMethod{
+		params: []string{"addr"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			addr, err := p.addr("addr")
+			if err != nil {
+				return nil, err
+			}
+			return call(s, addr)
+		},
+	}
+}
+
+// jsonRPCMethods are the methods of the JSON-RPC endpoint, they are
+// the same as the methods of WalletService.
+var jsonRPCMethods = map[string]jsonRPCMethod{
+	"WalletState": addrMethod(func(s *RPCServer, addr consensus.Addr) (interface{}, error) {
+		var w WalletState
+		err := s.walletState(addr, &w)
+		return w, err
+	}),
+	"Orders": {
+		params: []string{"addr", "afterBase", "afterQuote", "afterID", "limit"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			addr, err := p.addr("addr")
+			if err != nil {
+				return nil, err
+			}
+
+			req := OrdersRequest{Addr: addr}
+			if _, ok := p["afterBase"]; ok {
+				req.After.Market.Base, err = p.tokenID("afterBase")
+				if err != nil {
+					return nil, err
+				}
+
+				req.After.Market.Quote, err = p.tokenID("afterQuote")
+				if err != nil {
+					return nil, err
+				}
+
+				req.After.ID, err = p.uint("afterID")
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			limit, err := p.uint("limit")
+			if err != nil {
+				return nil, err
+			}
+			req.Limit = int(limit)
+
+			var r OrdersResponse
+			err = s.orders(req, &r)
+			return r, err
+		},
+	},
+	"Tokens": {call: func(s *RPCServer, _ jsonRPCParams) (interface{}, error) {
+		var t TokenState
+		err := s.tokens(0, &t)
+		return t, err
+	}},
+	"TokenBySymbol": {
+		params: []string{"symbol"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			symbol, err := p.str("symbol")
+			if err != nil {
+				return nil, err
+			}
+
+			var t Token
+			err = s.tokenBySymbol(symbol, &t)
+			return t, err
+		},
+	},
+	"ProveBalance": {
+		params: []string{"addr", "token"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			addr, err := p.addr("addr")
+			if err != nil {
+				return nil, err
+			}
+
+			token, err := p.tokenID("token")
+			if err != nil {
+				return nil, err
+			}
+
+			var proof Proof
+			err = s.proveBalance(BalanceProofArg{Addr: addr, Token: token}, &proof)
+			return proof, err
+		},
+	},
+	"ProveOrder": {
+		params: []string{"base", "quote", "id"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			m, err := p.market()
+			if err != nil {
+				return nil, err
+			}
+
+			id, err := p.uint("id")
+			if err != nil {
+				return nil, err
+			}
+
+			var proof Proof
+			err = s.proveOrder(OrderProofArg{ID: OrderID{ID: id, Market: m}}, &proof)
+			return proof, err
+		},
+	},
+	"DiffStates": {
+		params: []string{"a", "b", "limit"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			a, err := p.hash("a")
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := p.hash("b")
+			if err != nil {
+				return nil, err
+			}
+
+			limit, err := p.uint("limit")
+			if err != nil {
+				return nil, err
+			}
+
+			var diffs []KeyDiff
+			err = s.diffStates(DiffStatesArg{A: a, B: b, Limit: int(limit)}, &diffs)
+			return diffs, err
+		},
+	},
+	"SendTxn": {
+		params: []string{"txn"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			b, err := p.bytes("txn")
+			if err != nil {
+				return nil, err
+			}
+
+			var r AddResult
+			err = s.sendTxn(b, &r)
+			if err != nil {
+				return nil, newJSONRPCError(JSONRPCTxnRejected, toRPCError(err).Message)
+			}
+			return r, nil
+		},
+	},
+	"SendTxnV2": {
+		params: []string{"txn"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			b, err := p.bytes("txn")
+			if err != nil {
+				return nil, err
+			}
+
+			var r SendTxnResult
+			err = s.sendTxnV2(b, &r)
+			return r, err
+		},
+	},
+	"SendTxnWait": {
+		params: []string{"txn", "waitRounds"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			b, err := p.bytes("txn")
+			if err != nil {
+				return nil, err
+			}
+
+			rounds, err := p.uint("waitRounds")
+			if err != nil {
+				return nil, err
+			}
+
+			var r SendTxnResult
+			err = s.sendTxnWait(SendTxnRequest{Txn: b, WaitRounds: uint(rounds)}, &r)
+			return r, err
+		},
+	},
+	"Nonce": addrMethod(func(s *RPCServer, addr consensus.Addr) (interface{}, error) {
+		var n uint64
+		err := s.nonce(addr, &n)
+		return n, err
+	}),
+	"NonceSlots": {
+		params: []string{"addr", "count", "token"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			addr, err := p.addr("addr")
+			if err != nil {
+				return nil, err
+			}
+
+			count, err := p.uint("count")
+			if err != nil {
+				return nil, err
+			}
+
+			token, err := p.str("token")
+			if err != nil {
+				return nil, err
+			}
+
+			var slots []uint64
+			err = s.nonceSlots(NonceSlotsArg{Addr: addr, Count: int(count), Token: token}, &slots)
+			return slots, err
+		},
+	},
+	"TxnStatus": {
+		params: []string{"hash"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			h, err := p.hash("hash")
+			if err != nil {
+				return nil, err
+			}
+
+			var r TxnStatusResult
+			err = s.txnStatus(h, &r)
+			return r, err
+		},
+	},
+	"PendingTxns": addrMethod(func(s *RPCServer, addr consensus.Addr) (interface{}, error) {
+		var txns []PendingTxnInfo
+		err := s.pendingTxns(addr, &txns)
+		return txns, err
+	}),
+	"Block": {
+		params: []string{"round", "hash"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			var req BlockRequest
+			if raw, ok := p["hash"]; ok && string(raw) != "null" {
+				h, err := p.hash("hash")
+				if err != nil {
+					return nil, err
+				}
+				req.Hash = &h
+			} else {
+				round, err := p.uint("round")
+				if err != nil {
+					return nil, err
+				}
+				req.Round = &round
+			}
+
+			var r BlockResponse
+			err := s.block(req, &r)
+			return r, err
+		},
+	},
+	"BlockProposal": {
+		params: []string{"hash"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			h, err := p.hash("hash")
+			if err != nil {
+				return nil, err
+			}
+
+			var bp consensus.BlockProposal
+			err = s.blockProposal(h, &bp)
+			return bp, err
+		},
+	},
+	"RawBlock": {
+		params: []string{"round"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			round, err := p.uint("round")
+			if err != nil {
+				return nil, err
+			}
+
+			var resp RawBlockResponse
+			err = s.rawBlock(RawBlockRequest{Round: round}, &resp)
+			return resp, err
+		},
+	},
+	"BalanceAt": {
+		params: []string{"addr", "token", "round"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			addr, err := p.addr("addr")
+			if err != nil {
+				return nil, err
+			}
+
+			token, err := p.tokenID("token")
+			if err != nil {
+				return nil, err
+			}
+
+			round, err := p.uint("round")
+			if err != nil {
+				return nil, err
+			}
+
+			var result BalanceAtResult
+			err = s.balanceAt(BalanceAtRequest{Addr: addr, Token: token, Round: round}, &result)
+			return result, err
+		},
+	},
+	"EstimateFill": {
+		params: []string{"base", "quote", "sellSide", "quant", "price"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			m, err := p.market()
+			if err != nil {
+				return nil, err
+			}
+
+			sellSide, err := p.bool("sellSide")
+			if err != nil {
+				return nil, err
+			}
+
+			quant, err := p.uint("quant")
+			if err != nil {
+				return nil, err
+			}
+
+			price, err := p.uint("price")
+			if err != nil {
+				return nil, err
+			}
+
+			var est FillEstimate
+			err = s.estimateFill(EstimateFillRequest{Market: m, SellSide: sellSide, Quant: quant, Price: price}, &est)
+			return est, err
+		},
+	},
+	"OrderBook": {
+		params: []string{"base", "quote", "levels"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			m, err := p.market()
+			if err != nil {
+				return nil, err
+			}
+
+			levels, err := p.uint("levels")
+			if err != nil {
+				return nil, err
+			}
+
+			var d OrderBookResponse
+			err = s.orderBook(OrderBookRequest{Market: m, Levels: int(levels)}, &d)
+			return d, err
+		},
+	},
+	"Markets": {call: func(s *RPCServer, _ jsonRPCParams) (interface{}, error) {
+		var markets []MarketInfo
+		err := s.markets(&markets)
+		return markets, err
+	}},
+	"Order": {
+		params: []string{"base", "quote", "id"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			m, err := p.market()
+			if err != nil {
+				return nil, err
+			}
+
+			id, err := p.uint("id")
+			if err != nil {
+				return nil, err
+			}
+
+			var r OrderResult
+			err = s.order(OrderRequest{Market: m, ID: id}, &r)
+			return r, err
+		},
+	},
+	"Trades": {
+		params: []string{"base", "quote", "limit", "sinceRound"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			m, err := p.market()
+			if err != nil {
+				return nil, err
+			}
+
+			limit, err := p.uint("limit")
+			if err != nil {
+				return nil, err
+			}
+
+			since, err := p.uint("sinceRound")
+			if err != nil {
+				return nil, err
+			}
+
+			var r TradesResponse
+			err = s.recentTrades(TradesRequest{Market: m, Limit: int(limit), SinceRound: since}, &r)
+			return r, err
+		},
+	},
+	"Round": {call: func(s *RPCServer, _ jsonRPCParams) (interface{}, error) {
+		var r uint64
+		err := s.round(&r)
+		return r, err
+	}},
+	"ChainStatus": {call: func(s *RPCServer, _ jsonRPCParams) (interface{}, error) {
+		var status consensus.ChainStatus
+		err := s.chainStatus(&status)
+		return status, err
+	}},
+	"NodeInfo": {call: func(s *RPCServer, _ jsonRPCParams) (interface{}, error) {
+		var info NodeInfo
+		err := s.nodeInfo(&info)
+		return info, err
+	}},
+	"Graphviz": {
+		params: []string{"maxFinalized", "showWeights"},
+		call: func(s *RPCServer, p jsonRPCParams) (interface{}, error) {
+			max, err := p.uint("maxFinalized")
+			if err != nil {
+				return nil, err
+			}
+
+			weights, err := p.bool("showWeights")
+			if err != nil {
+				return nil, err
+			}
+
+			var str string
+			err = s.graphviz(GraphvizRequest{MaxFinalized: int(max), ShowWeights: weights}, &str)
+			return str, err
+		},
+	},
+	"TxnPoolSize": {call: func(s *RPCServer, _ jsonRPCParams) (interface{}, error) {
+		return s.txnPoolSize(), nil
+	}},
+	"PoolStats": {call: func(s *RPCServer, _ jsonRPCParams) (interface{}, error) {
+		var stats PoolStats
+		err := s.poolStats(&stats)
+		return stats, err
+	}},
+}
+
+var marshalType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// jsonValue converts the result of a call to the JSON value, the
+// addresses, the hashes and the token IDs are converted to hex
+// strings, and the byte slices are converted to 0x prefixed hex
+// strings. The consensus types do not implement
+// encoding.TextMarshaler since gob would use it on the wire.
+func jsonValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch v := v.Interface().(type) {
+	case consensus.Addr:
+		return v.Hex()
+	case consensus.Hash:
+		return v.Hex()
+	case TokenID:
+		return strconv.FormatUint(uint64(v), 16)
+	}
+
+	if v.Type().Implements(marshalType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return jsonValue(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return "0x" + hex.EncodeToString(v.Bytes())
+		}
+		fallthrough
+	case reflect.Array:
+		r := make([]interface{}, v.Len())
+		for i := range r {
+			r[i] = jsonValue(v.Index(i))
+		}
+		return r
+	case reflect.Map:
+		r := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			r[fmt.Sprint(jsonValue(k))] = jsonValue(v.MapIndex(k))
+		}
+		return r
+	case reflect.Struct:
+		r := make(map[string]interface{})
+		jsonFields(v, r)
+		return r
+	default:
+		return v.Interface()
+	}
+}
+
+// jsonFields adds the exported fields of the struct to r, the fields
+// of the embedded structs are promoted.
+func jsonFields(v reflect.Value, r map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			jsonFields(v.Field(i), r)
+			continue
+		}
+
+		if f.PkgPath != "" || !v.Field(i).CanInterface() {
+			// unexported
+			continue
+		}
+
+		r[f.Name] = jsonValue(v.Field(i))
+	}
+}
+
+// jsonRPCHandler serves the wallet service over JSON-RPC 2.0, for
+// the clients that can not speak gob.
+//
+// The params can be passed by name or by position. The addresses,
+// the hashes and the token IDs are hex strings, the txn bytes of
+// SendTxn are a 0x prefixed hex string or a base64 string.
+type jsonRPCHandler struct {
+	s *RPCServer
+	// the check of each call, nil means no check
+	check callCheck
+}
+
+func (h *jsonRPCHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxJSONRPCRequestSize))
+	if err != nil {
+		writeJSON(w, jsonRPCResponse{Version: "2.0", Error: newJSONRPCError(JSONRPCParseError, err.Error()), ID: json.RawMessage("null")})
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []json.RawMessage
+		err := json.Unmarshal(body, &reqs)
+		if err != nil {
+			writeJSON(w, jsonRPCResponse{Version: "2.0", Error: newJSONRPCError(JSONRPCParseError, err.Error()), ID: json.RawMessage("null")})
+			return
+		}
+
+		if len(reqs) == 0 {
+			writeJSON(w, jsonRPCResponse{Version: "2.0", Error: newJSONRPCError(JSONRPCInvalidRequest, "empty batch"), ID: json.RawMessage("null")})
+			return
+		}
+
+		var resps []jsonRPCResponse
+		for _, r := range reqs {
+			resp, ok := h.serve(req, r)
+			if ok {
+				resps = append(resps, resp)
+			}
+		}
+
+		if len(resps) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, resps)
+		return
+	}
+
+	resp, ok := h.serve(req, body)
+	if !ok {
+		// notification
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// serve serves a single request of the HTTP request, it returns
+// false if the request is a notification, which does not have a
+// response.
+func (h *jsonRPCHandler) serve(httpReq *http.Request, b []byte) (jsonRPCResponse, bool) {
+	resp := jsonRPCResponse{Version: "2.0", ID: json.RawMessage("null")}
+	var req jsonRPCRequest
+	err := json.Unmarshal(b, &req)
+	if err != nil {
+		resp.Error = newJSONRPCError(JSONRPCParseError, err.Error())
+		return resp, true
+	}
+
+	if req.ID != nil {
+		resp.ID = req.ID
+	}
+
+	if req.Version != "2.0" || req.Method == "" {
+		resp.Error = newJSONRPCError(JSONRPCInvalidRequest, `jsonrpc should be "2.0" and method should be set`)
+		return resp, true
+	}
+
+	start := time.Now()
+	result, err := h.call(httpReq, req)
+	if req.ID == nil {
+		h.s.observeCall(finishedCall{protocol: "jsonrpc", method: req.Method, remote: httpReq.RemoteAddr, duration: time.Since(start), err: err})
+		return resp, false
+	}
+
+	if err == nil {
+		resp.Result, err = json.Marshal(jsonValue(reflect.ValueOf(result)))
+		if err != nil {
+			err = newJSONRPCError(JSONRPCInternalError, err.Error())
+		}
+	}
+
+	if err != nil {
+		resp.Error = toJSONRPCError(err)
+	}
+	h.s.observeCall(finishedCall{protocol: "jsonrpc", method: req.Method, remote: httpReq.RemoteAddr, duration: time.Since(start), err: err, size: len(resp.Result)})
+	return resp, true
+}
+
+func (h *jsonRPCHandler) call(httpReq *http.Request, req jsonRPCRequest) (interface{}, error) {
+	m, ok := jsonRPCMethods[req.Method]
+	if !ok {
+		return nil, newJSONRPCError(JSONRPCMethodNotFound, fmt.Sprintf("method %s not found", req.Method))
+	}
+
+	if h.check != nil {
+		if err := h.check(httpReq, req.Method); err != nil {
+			return nil, err
+		}
+	}
+
+	params := make(jsonRPCParams)
+	raw := bytes.TrimSpace(req.Params)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+	case raw[0] == '[':
+		var values []json.RawMessage
+		err := json.Unmarshal(raw, &values)
+		if err != nil {
+			return nil, newJSONRPCError(JSONRPCInvalidParams, err.Error())
+		}
+
+		if len(values) > len(m.params) {
+			return nil, newJSONRPCError(JSONRPCInvalidParams, fmt.Sprintf("too many params, method %s takes %d", req.Method, len(m.params)))
+		}
+
+		for i, v := range values {
+			params[m.params[i]] = v
+		}
+	default:
+		err := json.Unmarshal(raw, &params)
+		if err != nil {
+			return nil, newJSONRPCError(JSONRPCInvalidParams, err.Error())
+		}
+	}
+
+	return m.call(h.s, params)
+}
+
+func toJSONRPCError(err error) *JSONRPCError {
+	if e, ok := err.(*JSONRPCError); ok {
+		return e
+	}
+
+	e := toRPCError(err)
+	code := JSONRPCServerError
+	switch {
+	case err == ErrClientCertRequired:
+		code = JSONRPCClientCertRequired
+	case e.Code == CodeNotSynced:
+		code = JSONRPCNotReady
+	case e.Code == CodeInvalidTxn:
+		code = JSONRPCTxnRejected
+	case e.Code == CodeRateLimited:
+		code = JSONRPCRateLimited
+	case e.Code == CodeUnauthorized:
+		code = JSONRPCUnauthorized
+	case err == ErrNotArchiveNode:
+		code = JSONRPCNotArchiveNode
+	}
+	return &JSONRPCError{Code: code, Message: e.Message, Data: &JSONRPCErrorData{Code: e.Code}}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error("error writing JSON-RPC response", "err", err)
+	}
+}
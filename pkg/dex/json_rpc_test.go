@@ -0,0 +1,127 @@
+package dex
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+type nopSender struct{}
+
+func (nopSender) SendTxn([]byte)      {}
+func (nopSender) BroadcastTxn([]byte) {}
+
+type jsonRPCTestResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *JSONRPCError   `json:"error"`
+	ID      int             `json:"id"`
+}
+
+func postJSONRPC(t *testing.T, url, method string, params interface{}) jsonRPCTestResponse {
+	req := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+		"id":      7,
+	}
+	b, err := json.Marshal(req)
+	assert.Nil(t, err)
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var r jsonRPCTestResponse
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&r))
+	assert.Equal(t, "2.0", r.Version)
+	assert.Equal(t, 7, r.ID)
+	return r
+}
+
+func TestJSONRPCSendTxn(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	url := srv.URL + DefaultJSONRPCPath
+
+	txn := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 1)
+	resp := postJSONRPC(t, url, "SendTxn", map[string]string{"txn": base64.StdEncoding.EncodeToString(txn)})
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, "0", string(resp.Result))
+
+	// hex txn bytes, passed by position
+	resp = postJSONRPC(t, url, "SendTxn", []string{"0x" + hex.EncodeToString(txn)})
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, "2", string(resp.Result))
+
+	resp = postJSONRPC(t, url, "PendingTxns", []string{pk.Addr().Hex()})
+	assert.Nil(t, resp.Error)
+	var pending []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(resp.Result, &pending))
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, consensus.SHA3(txn).Hex(), pending[0]["Hash"])
+	assert.Equal(t, float64(1), pending[0]["Nonce"])
+
+	resp = postJSONRPC(t, url, "WalletState", map[string]string{"addr": "0x" + pk.Addr().Hex()})
+	assert.Nil(t, resp.Error)
+	var w struct {
+		Balances []struct {
+			Token     string
+			Available uint64
+		}
+	}
+	assert.Nil(t, json.Unmarshal(resp.Result, &w))
+	assert.NotEqual(t, 0, len(w.Balances))
+
+	resp = postJSONRPC(t, url, "SendTxn", map[string]string{"txn": "0x00"})
+	assert.Equal(t, JSONRPCTxnRejected, resp.Error.Code)
+	assert.Nil(t, resp.Result)
+
+	resp = postJSONRPC(t, url, "SendTxn", map[string]string{"txn": "not base64"})
+	assert.Equal(t, JSONRPCInvalidParams, resp.Error.Code)
+
+	resp = postJSONRPC(t, url, "Nonce", map[string]string{"addr": "abcd"})
+	assert.Equal(t, JSONRPCInvalidParams, resp.Error.Code)
+
+	resp = postJSONRPC(t, url, "NoSuchMethod", nil)
+	assert.Equal(t, JSONRPCMethodNotFound, resp.Error.Code)
+}
+
+func TestJSONRPCNotReady(t *testing.T) {
+	pk, _ := RandKeyPair()
+	r := NewRPCServer()
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := postJSONRPC(t, srv.URL+DefaultJSONRPCPath, "Nonce", []string{pk.Addr().Hex()})
+	assert.Equal(t, JSONRPCNotReady, resp.Error.Code)
+
+	httpResp, err := http.Post(srv.URL+DefaultJSONRPCPath, "application/json", bytes.NewReader([]byte("{")))
+	assert.Nil(t, err)
+	var parseErr jsonRPCTestResponse
+	assert.Nil(t, json.NewDecoder(httpResp.Body).Decode(&parseErr))
+	httpResp.Body.Close()
+	assert.Equal(t, JSONRPCParseError, parseErr.Error.Code)
+}
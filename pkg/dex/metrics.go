@@ -0,0 +1,587 @@
+package dex
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	log "github.com/helinwang/log15"
+)
+
+// MetricsPath is the HTTP path of the metrics endpoint.
+const MetricsPath = "/metrics"
+
+// Metric is a metric family, it writes its samples in the Prometheus
+// text exposition format.
+type Metric interface {
+	WriteText(w io.Writer) error
+}
+
+// Registry is a set of metrics served in the Prometheus text
+// exposition format, it does not depend on the Prometheus client.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the metric to the registry.
+func (r *Registry) Register(m Metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// WriteText writes the metrics in the registration order.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := make([]Metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		if err := m.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	err := r.WriteText(w)
+	if err != nil {
+		log.Error("error writing metrics", "err", err)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help, typ string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	return err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeSample writes a sample, labels are the label name and value
+// pairs.
+func writeSample(w io.Writer, name string, v float64, labels ...string) error {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// counterVec is a counter partitioned by a label.
+type counterVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(labelValue string) {
+	c.mu.Lock()
+	c.values[labelValue]++
+	c.mu.Unlock()
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *counterVec) WriteText(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := writeMetricHeader(w, c.name, c.help, "counter"); err != nil {
+		return err
+	}
+
+	for _, k := range sortedKeys(c.values) {
+		if err := writeSample(w, c.name, c.values[k], c.label, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type histogram struct {
+	// the counts of the observations in each bucket,
+	// non-cumulative
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a histogram partitioned by a label.
+type histogramVec struct {
+	name, help, label string
+	// the upper bounds of the buckets in increasing order, the
+	// +Inf bucket is implicit
+	buckets []float64
+
+	mu         sync.Mutex
+	histograms map[string]*histogram
+}
+
+func newHistogramVec(name, help, label string, buckets []float64) *histogramVec {
+	return &histogramVec{name: name, help: help, label: label, buckets: buckets, histograms: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(labelValue string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.histograms[labelValue]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.histograms[labelValue] = hist
+	}
+
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) WriteText(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := writeMetricHeader(w, h.name, h.help, "histogram"); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(h.histograms))
+	for k := range h.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		hist := h.histograms[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hist.counts[i]
+			err := writeSample(w, h.name+"_bucket", float64(cumulative), h.label, k, "le", strconv.FormatFloat(le, 'g', -1, 64))
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := writeSample(w, h.name+"_bucket", float64(hist.count), h.label, k, "le", "+Inf"); err != nil {
+			return err
+		}
+		if err := writeSample(w, h.name+"_sum", hist.sum, h.label, k); err != nil {
+			return err
+		}
+		if err := writeSample(w, h.name+"_count", float64(hist.count), h.label, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rpcLatencyBuckets are the buckets of the RPC latency histogram in
+// seconds.
+var rpcLatencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// walletMethods are the names of the WalletService methods, the
+// other method names sent by the clients are counted as "unknown",
+// so that they do not grow the label values unboundedly.
+var walletMethods = func() map[string]bool {
+	m := make(map[string]bool)
+	t := reflect.TypeOf(&WalletService{})
+	for i := 0; i < t.NumMethod(); i++ {
+		m[t.Method(i).Name] = true
+	}
+	return m
+}()
+
+// rpcMetrics are the metrics of the RPC calls of both net/rpc and
+// JSON-RPC.
+type rpcMetrics struct {
+	requests *counterVec
+	errors   *counterVec
+	latency  *histogramVec
+}
+
+func newRPCMetrics() *rpcMetrics {
+	return &rpcMetrics{
+		requests: newCounterVec("dex_rpc_requests_total", "The number of the RPC calls.", "method"),
+		errors:   newCounterVec("dex_rpc_errors_total", "The number of the failed RPC calls.", "method"),
+		latency:  newHistogramVec("dex_rpc_request_duration_seconds", "The latency of the RPC calls.", "method", rpcLatencyBuckets),
+	}
+}
+
+// metricMethod returns the label value of the method, the method
+// can be prefixed by the service name.
+func metricMethod(method string) string {
+	method = strings.TrimPrefix(method, "WalletService.")
+	if !walletMethods[method] {
+		return "unknown"
+	}
+	return method
+}
+
+func (m *rpcMetrics) observe(method string, d time.Duration, failed bool) {
+	method = metricMethod(method)
+	m.requests.inc(method)
+	if failed {
+		m.errors.inc(method)
+	}
+	m.latency.observe(method, d.Seconds())
+}
+
+// statusMetrics are the gauges of the txn pool and the chain read at
+// the scrape time.
+type statusMetrics struct {
+	s *RPCServer
+}
+
+type gauge struct {
+	name, help string
+	v          float64
+}
+
+func writeGauges(w io.Writer, gauges []gauge) error {
+	for _, g := range gauges {
+		if err := writeMetricHeader(w, g.name, g.help, "gauge"); err != nil {
+			return err
+		}
+		if err := writeSample(w, g.name, g.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m statusMetrics) WriteText(w io.Writer) error {
+	if m.s.pool != nil {
+		if err := writePoolMetrics(w, m.s.pool.Stats()); err != nil {
+			return err
+		}
+	}
+
+	if m.s.chain != nil {
+		status := m.s.chain.ChainStatus()
+		inSync := 0.0
+		if status.InSync() {
+			inSync = 1
+		}
+
+		gauges := []gauge{
+			{"dex_chain_round", "The current round of the chain.", float64(status.Round)},
+			{"dex_chain_rand_beacon_depth", "The depth of the random beacon.", float64(status.RandBeaconDepth)},
+			{"dex_chain_in_sync", "1 if the node is in sync with the network, 0 otherwise.", inSync},
+		}
+		if n := len(status.RoundMetrics); n > 0 {
+			last := status.RoundMetrics[n-1]
+			gauges = append(gauges,
+				gauge{"dex_chain_last_round_block_time_seconds", "The block time of the last round.", last.BlockTime.Seconds()},
+				gauge{"dex_chain_last_round_txns", "The number of the txns of the last round.", float64(last.TxnCount)},
+			)
+		}
+		if err := writeGauges(w, gauges); err != nil {
+			return err
+		}
+	}
+
+	if m.s.node != nil {
+		if err := writePeerMetrics(w, m.s.node.NodeStatus()); err != nil {
+			return err
+		}
+	}
+
+	m.s.mu.Lock()
+	finalized := m.s.finalized != nil
+	round := m.s.finalizedRound
+	m.s.mu.Unlock()
+	if !finalized {
+		return nil
+	}
+
+	return writeGauges(w, []gauge{{"dex_chain_finalized_round", "The last finalized round.", float64(round)}})
+}
+
+func writePeerMetrics(w io.Writer, status consensus.NodeStatus) error {
+	err := writeGauges(w, []gauge{{"dex_peers", "The number of the connected peers.", float64(status.Peers)}})
+	if err != nil {
+		return err
+	}
+
+	const rtt = "dex_peer_rtt_seconds"
+	if err := writeMetricHeader(w, rtt, "The smoothed round trip time of the connected peers, the peers without a pong are omitted.", "gauge"); err != nil {
+		return err
+	}
+	rtts := make(map[string]float64, len(status.PeerRTTs))
+	for id, d := range status.PeerRTTs {
+		if d > 0 {
+			rtts[id.Hex()] = d.Seconds()
+		}
+	}
+	for _, id := range sortedKeys(rtts) {
+		if err := writeSample(w, rtt, rtts[id], "peer", id); err != nil {
+			return err
+		}
+	}
+
+	if err := writePeerTraffic(w, status.PeerTraffic); err != nil {
+		return err
+	}
+	return writeNetworkMetrics(w, status.Network)
+}
+
+func writePeerTraffic(w io.Writer, traffic map[consensus.Addr]consensus.PeerTraffic) error {
+	peers := make(map[string]consensus.PeerTraffic, len(traffic))
+	for id, t := range traffic {
+		peers[id.Hex()] = t
+	}
+	ids := make([]string, 0, len(peers))
+	for id := range peers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	const (
+		bytes     = "dex_peer_bytes_total"
+		msgs      = "dex_peer_messages_total"
+		rate      = "dex_peer_bytes_per_second"
+		deferred  = "dex_peer_deferred_responses_total"
+		dropped   = "dex_peer_dropped_messages_total"
+		throttled = "dex_peer_throttled_messages_total"
+	)
+	samples := []struct {
+		name, help, typ string
+		write           func(id string, t consensus.PeerTraffic) error
+	}{
+		{bytes, "The bytes of the frames exchanged with the peers by the message types.", "counter", func(id string, t consensus.PeerTraffic) error {
+			return writeMsgTraffic(w, bytes, id, t, func(m consensus.MsgTraffic) (uint64, uint64) { return m.BytesIn, m.BytesOut })
+		}},
+		{msgs, "The messages exchanged with the peers by the message types.", "counter", func(id string, t consensus.PeerTraffic) error {
+			return writeMsgTraffic(w, msgs, id, t, func(m consensus.MsgTraffic) (uint64, uint64) { return m.MsgsIn, m.MsgsOut })
+		}},
+		{rate, "The bytes per second exchanged with the peers over the last bandwidth window.", "gauge", func(id string, t consensus.PeerTraffic) error {
+			if err := writeSample(w, rate, t.RateIn, "peer", id, "direction", "in"); err != nil {
+				return err
+			}
+			return writeSample(w, rate, t.RateOut, "peer", id, "direction", "out")
+		}},
+		{deferred, "The low-priority responses deferred since the peers exceeded their outbound budget.", "counter", func(id string, t consensus.PeerTraffic) error {
+			return writeSample(w, deferred, float64(t.Deferred), "peer", id)
+		}},
+		{dropped, "The messages dropped from the full send queues to the peers.", "counter", func(id string, t consensus.PeerTraffic) error {
+			return writeSample(w, dropped, float64(t.Dropped), "peer", id)
+		}},
+		{throttled, "The messages from the peers dropped over the rate limits of their types.", "counter", func(id string, t consensus.PeerTraffic) error {
+			return writeSample(w, throttled, float64(t.Throttled), "peer", id)
+		}},
+	}
+	for _, s := range samples {
+		if err := writeMetricHeader(w, s.name, s.help, s.typ); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := s.write(id, peers[id]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sendQueueDepthBuckets are the buckets of the send queue depth
+// histogram.
+var sendQueueDepthBuckets = []float64{0, 1, 10, 100, 1000}
+
+// writeNetworkMetrics writes the traffic aggregated over the peers.
+func writeNetworkMetrics(w io.Writer, m consensus.NetworkMetrics) error {
+	const (
+		bytes = "dex_net_bytes_total"
+		msgs  = "dex_net_messages_total"
+		conns = "dex_net_connections"
+	)
+	types := make([]string, 0, len(m.Msgs))
+	for typ := range m.Msgs {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	for _, s := range []struct {
+		name, help string
+		v          func(consensus.MsgTraffic) (uint64, uint64)
+	}{
+		{bytes, "The bytes of the frames exchanged with all the peers by the message types.", func(t consensus.MsgTraffic) (uint64, uint64) { return t.BytesIn, t.BytesOut }},
+		{msgs, "The messages exchanged with all the peers by the message types.", func(t consensus.MsgTraffic) (uint64, uint64) { return t.MsgsIn, t.MsgsOut }},
+	} {
+		if err := writeMetricHeader(w, s.name, s.help, "counter"); err != nil {
+			return err
+		}
+		for _, typ := range types {
+			in, out := s.v(m.Msgs[typ])
+			if err := writeSample(w, s.name, float64(in), "type", typ, "direction", "in"); err != nil {
+				return err
+			}
+			if err := writeSample(w, s.name, float64(out), "type", typ, "direction", "out"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeMetricHeader(w, conns, "The number of the connected peers by the direction.", "gauge"); err != nil {
+		return err
+	}
+	if err := writeSample(w, conns, float64(m.Inbound), "direction", "in"); err != nil {
+		return err
+	}
+	if err := writeSample(w, conns, float64(m.Outbound), "direction", "out"); err != nil {
+		return err
+	}
+
+	counters := []struct {
+		name, help string
+		v          uint64
+	}{
+		{"dex_net_handshake_failures_total", "The number of the peer connections failed in the handshake.", m.HandshakeFailures},
+		{"dex_net_decode_errors_total", "The number of the frames from the peers failed to decode.", m.DecodeErrors},
+		{"dex_net_gossip_received_total", "The number of the gossiped items received.", m.GossipReceived},
+		{"dex_net_gossip_duplicates_total", "The number of the received gossiped items dropped as the duplicates.", m.GossipDuplicates},
+	}
+	for _, c := range counters {
+		if err := writeMetricHeader(w, c.name, c.help, "counter"); err != nil {
+			return err
+		}
+		if err := writeSample(w, c.name, float64(c.v)); err != nil {
+			return err
+		}
+	}
+
+	err := writeGauges(w, []gauge{{"dex_net_gossip_dedup_hit_ratio", "The fraction of the received gossiped items dropped as the duplicates.", m.DedupHitRate()}})
+	if err != nil {
+		return err
+	}
+
+	depths := newHistogramVec("dex_net_send_queue_depth", "The number of the packets waiting in the send queues to the peers by the send classes.", "class", sendQueueDepthBuckets)
+	for class, ds := range m.QueueDepths {
+		for _, d := range ds {
+			depths.observe(class, float64(d))
+		}
+	}
+	return depths.WriteText(w)
+}
+
+// writeMsgTraffic writes the in and out values of each message type
+// of the peer.
+func writeMsgTraffic(w io.Writer, name, id string, t consensus.PeerTraffic, v func(consensus.MsgTraffic) (uint64, uint64)) error {
+	types := make([]string, 0, len(t.Msgs))
+	for typ := range t.Msgs {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	for _, typ := range types {
+		in, out := v(t.Msgs[typ])
+		if err := writeSample(w, name, float64(in), "peer", id, "type", typ, "direction", "in"); err != nil {
+			return err
+		}
+		if err := writeSample(w, name, float64(out), "peer", id, "type", typ, "direction", "out"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePoolMetrics(w io.Writer, stats PoolStats) error {
+	err := writeGauges(w, []gauge{
+		{"dex_txn_pool_txns", "The number of the txns in the pool.", float64(stats.Count)},
+		{"dex_txn_pool_bytes", "The size of the txns in the pool.", float64(stats.Bytes)},
+		{"dex_txn_pool_admission_rate", "The admitted txns per second.", stats.AdmissionRate},
+	})
+	if err != nil {
+		return err
+	}
+
+	counters := []struct {
+		name, help string
+		v          uint64
+	}{
+		{"dex_txn_pool_admitted_total", "The number of the admitted txns.", stats.Admitted},
+		{"dex_txn_pool_replaced_total", "The number of the admitted txns that replaced a pending txn.", stats.Replaced},
+		{"dex_txn_pool_evicted_total", "The number of the txns evicted from the full pool.", stats.Evicted},
+		{"dex_txn_pool_expired_total", "The number of the expired order txns dropped.", stats.Expired},
+	}
+	for _, c := range counters {
+		if err := writeMetricHeader(w, c.name, c.help, "counter"); err != nil {
+			return err
+		}
+		if err := writeSample(w, c.name, float64(c.v)); err != nil {
+			return err
+		}
+	}
+
+	const rejected = "dex_txn_pool_rejected_total"
+	if err := writeMetricHeader(w, rejected, "The number of the rejected txns.", "counter"); err != nil {
+		return err
+	}
+	reasons := make(map[string]float64, len(stats.Rejected))
+	for k, v := range stats.Rejected {
+		reasons[k] = float64(v)
+	}
+	for _, k := range sortedKeys(reasons) {
+		if err := writeSample(w, rejected, reasons[k], "reason", k); err != nil {
+			return err
+		}
+	}
+
+	const inclusion = "dex_txn_pool_inclusion_seconds"
+	if err := writeMetricHeader(w, inclusion, "The percentiles of the time from the admission to the inclusion of the txns.", "gauge"); err != nil {
+		return err
+	}
+	for _, q := range []struct {
+		quantile string
+		d        time.Duration
+	}{{"0.5", stats.InclusionP50}, {"0.9", stats.InclusionP90}, {"0.99", stats.InclusionP99}} {
+		if err := writeSample(w, inclusion, q.d.Seconds(), "quantile", q.quantile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package dex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	s, _, _, _, _ := newTIFTestState()
+	s.CommitCache()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetTxnPool(pool)
+	r.SetStater(restTestChain{})
+	r.Update(s)
+	r.SetNodeStater(testNodeStater{Peers: 2, PeerRTTs: map[consensus.Addr]time.Duration{
+		{1}: 20 * time.Millisecond,
+		{2}: 0,
+	}, PeerTraffic: map[consensus.Addr]consensus.PeerTraffic{
+		{1}: {
+			Msgs:      map[string]consensus.MsgTraffic{"block": {MsgsIn: 1, MsgsOut: 2, BytesIn: 100, BytesOut: 200}},
+			RateIn:    1.5,
+			Deferred:  3,
+			Dropped:   4,
+			Throttled: 5,
+		},
+	}, Network: consensus.NetworkMetrics{
+		Msgs:              map[string]consensus.MsgTraffic{"txn": {MsgsIn: 3, MsgsOut: 1, BytesIn: 300, BytesOut: 100}},
+		Inbound:           1,
+		Outbound:          1,
+		HandshakeFailures: 2,
+		DecodeErrors:      1,
+		GossipReceived:    4,
+		GossipDuplicates:  1,
+		QueueDepths:       map[string][]int{"gossip": {0, 20}},
+	}})
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	client, err := rpc.DialHTTP("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var tokens TokenState
+	assert.Nil(t, client.Call("WalletService.Tokens", 0, &tokens))
+	assert.Nil(t, client.Call("WalletService.Tokens", 0, &tokens))
+	unknown, _ := RandKeyPair()
+	var w WalletState
+	assert.NotNil(t, client.Call("WalletService.WalletState", unknown.Addr(), &w))
+	assert.NotNil(t, client.Call("WalletService.NoSuchMethod", 0, &w))
+	postJSONRPC(t, srv.URL+DefaultJSONRPCPath, "Tokens", nil)
+
+	resp, err := http.Get(srv.URL + MetricsPath)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	text := string(b)
+
+	for _, line := range []string{
+		"# TYPE dex_rpc_requests_total counter",
+		`dex_rpc_requests_total{method="Tokens"} 3`,
+		`dex_rpc_requests_total{method="WalletState"} 1`,
+		`dex_rpc_errors_total{method="WalletState"} 1`,
+		`dex_rpc_errors_total{method="unknown"} 1`,
+		"# TYPE dex_rpc_request_duration_seconds histogram",
+		`dex_rpc_request_duration_seconds_bucket{method="Tokens",le="+Inf"} 3`,
+		`dex_rpc_request_duration_seconds_count{method="Tokens"} 3`,
+		"dex_txn_pool_txns 0",
+		"dex_chain_round 3",
+		"dex_chain_in_sync 0",
+		"dex_peers 2",
+		fmt.Sprintf("dex_peer_rtt_seconds{peer=%q} 0.02", consensus.Addr{1}.Hex()),
+		fmt.Sprintf(`dex_peer_bytes_total{peer=%q,type="block",direction="in"} 100`, consensus.Addr{1}.Hex()),
+		fmt.Sprintf(`dex_peer_bytes_total{peer=%q,type="block",direction="out"} 200`, consensus.Addr{1}.Hex()),
+		fmt.Sprintf(`dex_peer_messages_total{peer=%q,type="block",direction="out"} 2`, consensus.Addr{1}.Hex()),
+		fmt.Sprintf(`dex_peer_bytes_per_second{peer=%q,direction="in"} 1.5`, consensus.Addr{1}.Hex()),
+		fmt.Sprintf(`dex_peer_deferred_responses_total{peer=%q} 3`, consensus.Addr{1}.Hex()),
+		fmt.Sprintf(`dex_peer_dropped_messages_total{peer=%q} 4`, consensus.Addr{1}.Hex()),
+		fmt.Sprintf(`dex_peer_throttled_messages_total{peer=%q} 5`, consensus.Addr{1}.Hex()),
+		`dex_net_messages_total{type="txn",direction="in"} 3`,
+		`dex_net_bytes_total{type="txn",direction="out"} 100`,
+		`dex_net_connections{direction="in"} 1`,
+		`dex_net_connections{direction="out"} 1`,
+		"dex_net_handshake_failures_total 2",
+		"dex_net_decode_errors_total 1",
+		"dex_net_gossip_received_total 4",
+		"dex_net_gossip_duplicates_total 1",
+		"dex_net_gossip_dedup_hit_ratio 0.25",
+		`dex_net_send_queue_depth_bucket{class="gossip",le="0"} 1`,
+		`dex_net_send_queue_depth_bucket{class="gossip",le="10"} 1`,
+		`dex_net_send_queue_depth_bucket{class="gossip",le="100"} 2`,
+		`dex_net_send_queue_depth_count{class="gossip"} 2`,
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+	assert.NotContains(t, text, `dex_rpc_errors_total{method="Tokens"}`)
+	assert.NotContains(t, text, "NoSuchMethod")
+	assert.NotContains(t, text, consensus.Addr{2}.Hex())
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogramVec("h", "help", "l", []float64{1, 2})
+	h.observe("a", 0.5)
+	h.observe("a", 1)
+	h.observe("a", 1.5)
+	h.observe("a", 3)
+
+	var b strings.Builder
+	assert.Nil(t, h.WriteText(&b))
+	assert.Equal(t, `# HELP h help
+# TYPE h histogram
+h_bucket{l="a",le="1"} 2
+h_bucket{l="a",le="2"} 3
+h_bucket{l="a",le="+Inf"} 4
+h_sum{l="a"} 6
+h_count{l="a"} 4
+`, b.String())
+}
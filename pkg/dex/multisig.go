@@ -0,0 +1,138 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// maxPolicyOwners is the maximum number of owners of a multisig
+// account.
+const maxPolicyOwners = 16
+
+// AccountPolicy requires the txns of an account to be signed by at
+// least Threshold of the Owners.
+type AccountPolicy struct {
+	Owners    []PK
+	Threshold uint32
+}
+
+// Validate checks if the policy can be set to an account.
+func (p *AccountPolicy) Validate() error {
+	if len(p.Owners) == 0 {
+		return errors.New("account policy has no owner")
+	}
+
+	if len(p.Owners) > maxPolicyOwners {
+		return fmt.Errorf("account policy has %d owners, at most %d are allowed", len(p.Owners), maxPolicyOwners)
+	}
+
+	if p.Threshold == 0 || int(p.Threshold) > len(p.Owners) {
+		return fmt.Errorf("account policy threshold %d is not in [1, %d]", p.Threshold, len(p.Owners))
+	}
+
+	seen := make(map[consensus.Addr]bool)
+	for _, pk := range p.Owners {
+		if len(pk) == 0 {
+			return errors.New("account policy has an empty owner PK")
+		}
+
+		addr := pk.Addr()
+		if seen[addr] {
+			return fmt.Errorf("account policy has duplicate owner %v", addr)
+		}
+		seen[addr] = true
+	}
+
+	return nil
+}
+
+// signers returns the addresses of the owners who signed the msg,
+// each owner is counted at most once.
+func (p *AccountPolicy) signers(msg []byte, sigs []Sig) []consensus.Addr {
+	signed := make([]bool, len(p.Owners))
+	var r []consensus.Addr
+	for _, sig := range sigs {
+		if len(sig) < 64 {
+			continue
+		}
+
+		for i, pk := range p.Owners {
+			if signed[i] || !sig.Verify(msg, pk) {
+				continue
+			}
+
+			signed[i] = true
+			r = append(r, pk.Addr())
+			break
+		}
+	}
+	return r
+}
+
+// authorized returns true if the signers meet the threshold.
+func (p *AccountPolicy) authorized(signers []consensus.Addr) bool {
+	signed := make(map[consensus.Addr]bool, len(signers))
+	for _, addr := range signers {
+		signed[addr] = true
+	}
+
+	var n uint32
+	for _, pk := range p.Owners {
+		if signed[pk.Addr()] {
+			n++
+		}
+	}
+	return n >= p.Threshold
+}
+
+// UpdateAccountPolicyTxn makes the account a multisig account, the
+// later txns of the account must be signed according to the policy.
+type UpdateAccountPolicyTxn struct {
+	Policy AccountPolicy
+}
+
+// BuildUpdateAccountPolicyTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildUpdateAccountPolicyTxn(chainID consensus.Hash, owner consensus.Addr, t UpdateAccountPolicyTxn, nonce uint64) ([]byte, consensus.Hash) {
+	txn := &Txn{
+		T:       UpdateAccountPolicy,
+		Data:    encodePayload(t),
+		Nonce:   nonce,
+		Owner:   owner,
+		version: TxnVersion,
+	}
+
+	return buildTxn(chainID, txn)
+}
+
+func MakeUpdateAccountPolicyTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, t UpdateAccountPolicyTxn, nonce uint64) []byte {
+	unsigned, h := BuildUpdateAccountPolicyTxn(chainID, owner, t, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
+}
+
+// AddSig returns the txn with one more signature, it is used by the
+// owners of a multisig account to co-sign a txn.
+func AddSig(b []byte, sk SK, chainID consensus.Hash) ([]byte, error) {
+	txn, err := decodeTxn(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if txn.version < TxnVersion {
+		return nil, fmt.Errorf("txn version %d does not support multiple signatures", txn.version)
+	}
+
+	txn.Sigs = append(txn.Sigs, sk.Sign(txn.signingBytes(chainID)))
+	return txn.Encode(true), nil
+}
+
+func (t *Transition) updateAccountPolicy(acc *Account, txn *UpdateAccountPolicyTxn) error {
+	if err := txn.Policy.Validate(); err != nil {
+		return err
+	}
+
+	t.state.UpdateAccountPolicy(acc.addr, txn.Policy)
+	return nil
+}
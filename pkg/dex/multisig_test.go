@@ -0,0 +1,161 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMultisigTestState(t *testing.T) (*State, PK, []PK, []SK) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100})
+	s.CommitCache()
+
+	var pks []PK
+	var sks []SK
+	for i := 0; i < 3; i++ {
+		p, k := RandKeyPair()
+		pks = append(pks, p)
+		sks = append(sks, k)
+	}
+
+	b := MakeUpdateAccountPolicyTxn(sk, testChainID, pk.Addr(), UpdateAccountPolicyTxn{Policy: AccountPolicy{Owners: pks, Threshold: 2}}, 0)
+	pt, err := parseTxn(b, s)
+	assert.Nil(t, err)
+	trans := s.Transition(1, nil)
+	assert.Nil(t, trans.Record(pt))
+	s = trans.Commit().(*State)
+	assert.Equal(t, uint32(2), s.AccountPolicy(pk.Addr()).Threshold)
+	return s, pk, pks, sks
+}
+
+func TestMultisigSendToken(t *testing.T) {
+	s, pk, _, sks := newMultisigTestState(t)
+	to, _ := RandKeyPair()
+	b := MakeSendTokenTxn(sks[0], testChainID, pk.Addr(), to, 0, 20, 1)
+
+	// one signature is not enough
+	_, err := parseTxn(b, s)
+	assert.NotNil(t, err)
+
+	// the same owner signing twice is counted once
+	twice, err := AddSig(b, sks[0], testChainID)
+	assert.Nil(t, err)
+	_, err = parseTxn(twice, s)
+	assert.NotNil(t, err)
+
+	b, err = AddSig(b, sks[2], testChainID)
+	assert.Nil(t, err)
+	pt, err := parseTxn(b, s)
+	assert.Nil(t, err)
+
+	trans := s.Transition(2, nil)
+	assert.Nil(t, trans.Record(pt))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 80, int(s.Account(pk.Addr()).Balance(0).Available))
+	assert.Equal(t, 20, int(s.Account(to.Addr()).Balance(0).Available))
+}
+
+func TestMultisigForgedSig(t *testing.T) {
+	s, pk, _, sks := newMultisigTestState(t)
+	to, _ := RandKeyPair()
+	_, other := RandKeyPair()
+	b := MakeSendTokenTxn(sks[1], testChainID, pk.Addr(), to, 0, 20, 1)
+
+	// a signature by a key that is not an owner
+	forged, err := AddSig(b, other, testChainID)
+	assert.Nil(t, err)
+	_, err = parseTxn(forged, s)
+	assert.NotNil(t, err)
+
+	// a signature of an owner over another txn
+	another := MakeSendTokenTxn(sks[2], testChainID, pk.Addr(), to, 0, 99, 1)
+	txn, err := decodeTxn(b)
+	assert.Nil(t, err)
+	anotherTxn, err := decodeTxn(another)
+	assert.Nil(t, err)
+	txn.Sigs = []Sig{anotherTxn.Sig}
+	_, err = parseTxn(txn.Bytes(), s)
+	assert.NotNil(t, err)
+}
+
+func TestMultisigPolicyChangedAfterVerify(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100})
+	s.CommitCache()
+
+	owner, _ := RandKeyPair()
+	to, _ := RandKeyPair()
+	policy := MakeUpdateAccountPolicyTxn(sk, testChainID, pk.Addr(), UpdateAccountPolicyTxn{Policy: AccountPolicy{Owners: []PK{owner}, Threshold: 1}}, 0)
+	send := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 20, 1)
+	p0, err := parseTxn(policy, s)
+	assert.Nil(t, err)
+	p1, err := parseTxn(send, s)
+	assert.Nil(t, err)
+
+	trans := s.Transition(1, nil)
+	assert.Nil(t, trans.Record(p0))
+	assert.NotNil(t, trans.Record(p1))
+}
+
+func TestMultisigPoolTxnOnStateWithoutPolicy(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100 * flatFee})
+	s.CommitCache()
+
+	var pks []PK
+	var sks []SK
+	for i := 0; i < 2; i++ {
+		p, k := RandKeyPair()
+		pks = append(pks, p)
+		sks = append(sks, k)
+	}
+
+	to, _ := RandKeyPair()
+	policy, err := parseTxn(MakeUpdateAccountPolicyTxn(sk, testChainID, pk.Addr(), UpdateAccountPolicyTxn{Policy: AccountPolicy{Owners: pks, Threshold: 2}}, 0), s)
+	assert.Nil(t, err)
+	send, err := parseTxn(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0), s)
+	assert.Nil(t, err)
+
+	// the leader state has the policy, the fork at the same
+	// nonce does not.
+	trans := s.Transition(1, nil)
+	assert.Nil(t, trans.Record(policy))
+	leader := trans.Commit().(*State)
+	trans = s.Transition(1, nil)
+	assert.Nil(t, trans.Record(send))
+	fork := trans.Commit().(*State)
+
+	b := MakeSendTokenTxn(sks[0], testChainID, pk.Addr(), to, 0, 20, 1)
+	b, err = AddSig(b, sks[1], testChainID)
+	assert.Nil(t, err)
+	pool := NewTxnPool(leader)
+	txn, broadcast := pool.Add(b)
+	assert.True(t, broadcast)
+	assert.NotNil(t, txn)
+
+	blob, err := rlp.EncodeToBytes([][]byte{b})
+	assert.Nil(t, err)
+	_, err = fork.Transition(2, nil).(*Transition).RecordSerialized(blob, pool)
+	assert.NotNil(t, err)
+	assert.NotNil(t, fork.Transition(2, nil).Record(txn))
+
+	// the leader state accepts it.
+	_, err = leader.Transition(2, nil).(*Transition).RecordSerialized(blob, pool)
+	assert.Nil(t, err)
+}
+
+func TestAccountPolicyValidate(t *testing.T) {
+	a, _ := RandKeyPair()
+	b, _ := RandKeyPair()
+	assert.Nil(t, (&AccountPolicy{Owners: []PK{a, b}, Threshold: 2}).Validate())
+	assert.NotNil(t, (&AccountPolicy{}).Validate())
+	assert.NotNil(t, (&AccountPolicy{Owners: []PK{a, b}, Threshold: 0}).Validate())
+	assert.NotNil(t, (&AccountPolicy{Owners: []PK{a, b}, Threshold: 3}).Validate())
+	assert.NotNil(t, (&AccountPolicy{Owners: []PK{a, a}, Threshold: 1}).Validate())
+}
@@ -7,22 +7,257 @@ import (
 	"github.com/helinwang/dex/pkg/consensus"
 )
 
+// skipLanes is the maximum number of the lanes of the skip list of
+// the price levels, a lane skips about 4 levels of the lane below
+// it.
+const skipLanes = 12
+
 type pricePoint struct {
 	Price     uint64
 	ListHead  *orderBookEntry
 	ListTail  *orderBookEntry
 	NextPoint *pricePoint
+	// skip is the next price levels in the upper lanes of the
+	// skip list, NextPoint is the lowest lane.
+	skip []*pricePoint
+	bid  bool
+	// the total quantity and the number of the resting orders,
+	// updated as the orders are inserted, filled or cancelled.
+	quant  uint64
+	orders int
+}
+
+// next returns the reference to the next price level in the lane.
+func (p *pricePoint) next(lane int) **pricePoint {
+	if lane == 0 {
+		return &p.NextPoint
+	}
+	return &p.skip[lane-1]
+}
+
+// insert inserts the entry by its priority. The priorities are
+// allocated in order, the entry is usually the last, except a
+// triggered stop order whose ID is allocated when the stop order is
+// placed.
+func (p *pricePoint) insert(e *orderBookEntry) {
+	e.point = p
+	p.quant += e.Quant
+	p.orders++
+	if p.ListHead == nil {
+		e.prev, e.Next = nil, nil
+		p.ListHead = e
+		p.ListTail = e
+		return
+	}
+
+	if p.ListTail.Priority < e.Priority {
+		e.prev, e.Next = p.ListTail, nil
+		p.ListTail.Next = e
+		p.ListTail = e
+		return
+	}
+
+	next := p.ListHead
+	for next.Priority < e.Priority {
+		next = next.Next
+	}
+
+	e.prev, e.Next = next.prev, next
+	if next.prev == nil {
+		p.ListHead = e
+	} else {
+		next.prev.Next = e
+	}
+	next.prev = e
+}
+
+// remove unlinks the entry from the orders of the price level, the
+// aggregates are updated by the caller.
+func (p *pricePoint) remove(e *orderBookEntry) {
+	if e.prev == nil {
+		p.ListHead = e.Next
+	} else {
+		e.prev.Next = e.Next
+	}
+
+	if e.Next == nil {
+		p.ListTail = e.prev
+	} else {
+		e.Next.prev = e.prev
+	}
+	e.prev, e.Next = nil, nil
+}
+
+// height returns the number of the lanes the price level is in.
+func (p *pricePoint) height() int {
+	return len(p.skip) + 1
+}
+
+// bookSide is the price levels of a side of the order book, from
+// the best price, in a skip list: finding the level of a price takes
+// O(log n), and removing the best level takes O(1).
+type bookSide struct {
+	// the bids are ordered by the higher price first, and the
+	// asks by the lower price first.
+	bid   bool
+	heads [skipLanes]*pricePoint
+	// the state of the random number generator of the heights
+	// of the price levels. The heights only affect the speed,
+	// the order book is the same regardless of them.
+	seed uint64
+}
+
+// best returns the price level of the best price, nil if the side is
+// empty.
+func (s *bookSide) best() *pricePoint {
+	return s.heads[0]
+}
+
+func (s *bookSide) before(a, b uint64) bool {
+	if s.bid {
+		return a > b
+	}
+	return a < b
+}
+
+// ref returns the reference to the price level after p in the lane,
+// nil p is the head of the side.
+func (s *bookSide) ref(p *pricePoint, lane int) **pricePoint {
+	if p == nil {
+		return &s.heads[lane]
+	}
+	return p.next(lane)
+}
+
+// randHeight returns a random height of a new price level, a level
+// is in each lane above the lowest with the probability of 1/4.
+func (s *bookSide) randHeight() int {
+	if s.seed == 0 {
+		s.seed = 0x9e3779b97f4a7c15
+	}
+	// xorshift64
+	s.seed ^= s.seed << 13
+	s.seed ^= s.seed >> 7
+	s.seed ^= s.seed << 17
+
+	h := 1
+	for r := s.seed; h < skipLanes && r&3 == 0; r >>= 2 {
+		h++
+	}
+	return h
+}
+
+// newPoint creates a price level with a random height.
+func (s *bookSide) newPoint(price uint64) *pricePoint {
+	p := &pricePoint{Price: price, bid: s.bid}
+	if h := s.randHeight(); h > 1 {
+		p.skip = make([]*pricePoint, h-1)
+	}
+	return p
+}
+
+// search returns the last price level before the price in each
+// lane, nil if there is none.
+func (s *bookSide) search(price uint64) (prev [skipLanes]*pricePoint) {
+	var p *pricePoint
+	for lane := skipLanes - 1; lane >= 0; lane-- {
+		for next := *s.ref(p, lane); next != nil && s.before(next.Price, price); next = *s.ref(p, lane) {
+			p = next
+		}
+		prev[lane] = p
+	}
+	return
+}
+
+// point returns the price level of the price, the level is inserted
+// if it does not exist.
+func (s *bookSide) point(price uint64) *pricePoint {
+	prev := s.search(price)
+	if next := *s.ref(prev[0], 0); next != nil && next.Price == price {
+		return next
+	}
+
+	n := s.newPoint(price)
+	for lane := 0; lane < n.height(); lane++ {
+		ref := s.ref(prev[lane], lane)
+		*n.next(lane) = *ref
+		*ref = n
+	}
+	return n
+}
+
+// removeBest removes the price level of the best price.
+func (s *bookSide) removeBest() {
+	p := s.heads[0]
+	for lane := 0; lane < p.height(); lane++ {
+		s.heads[lane] = *p.next(lane)
+	}
+}
+
+// remove removes the price level.
+func (s *bookSide) remove(p *pricePoint) {
+	prev := s.search(p.Price)
+	for lane := 0; lane < p.height(); lane++ {
+		*s.ref(prev[lane], lane) = *p.next(lane)
+	}
+}
+
+// reset removes all the price levels, and inserts the levels ordered
+// from the best price.
+func (s *bookSide) reset(points []*pricePoint) {
+	s.heads = [skipLanes]*pricePoint{}
+	var tails [skipLanes]*pricePoint
+	for _, p := range points {
+		for lane := 0; lane < p.height(); lane++ {
+			*s.ref(tails[lane], lane) = p
+			tails[lane] = p
+		}
+	}
 }
 
 type orderBookEntryData struct {
 	ID    uint64
 	Owner consensus.Addr
+	// the displayed quantity
 	Quant uint64
+	// the time priority within the price level, the ID, or a new
+	// ID when the slice of an iceberg order is refreshed.
+	Priority uint64
+	// the slice size and the quantity not displayed yet of an
+	// iceberg order, 0 for the other orders.
+	Display uint64
+	Hidden  uint64
+}
+
+// DecodeRLP decodes the entry, the entries stored before the iceberg
+// orders were added take their ID as the priority and are not
+// icebergs.
+func (e *orderBookEntryData) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	if err := decodeFields(s, &e.ID, &e.Owner, &e.Quant); err != nil {
+		return err
+	}
+
+	e.Priority = e.ID
+	e.Display = 0
+	e.Hidden = 0
+	for _, val := range []interface{}{&e.Priority, &e.Display, &e.Hidden} {
+		if err := decodeOptional(s, val); err != nil {
+			return err
+		}
+	}
+
+	return s.ListEnd()
 }
 
 type orderBookEntry struct {
 	orderBookEntryData
-	Next *orderBookEntry
+	Next  *orderBookEntry
+	prev  *orderBookEntry
+	point *pricePoint
 }
 
 // orderBook is the order book which performs the order matching.
@@ -32,8 +267,8 @@ type orderBookEntry struct {
 // https://gist.github.com/helinwang/935ab9558195a6ea8c16567caef5911b
 type orderBook struct {
 	nextOrderID uint64
-	bidMax      *pricePoint
-	askMin      *pricePoint
+	bids        bookSide
+	asks        bookSide
 	idToEntry   map[uint64]*orderBookEntry
 }
 
@@ -54,25 +289,103 @@ type Order struct {
 	Quant uint64
 	// price tick size is 10^-8, e.g,. price = Price * 10^-8
 	Price uint64
-	// the order is expired when ExpireRound >= block height
+	// the order is expired from round ExpireRound on, 0 means
+	// never expires
 	ExpireRound uint64
+	// Display is the quantity of an iceberg order displayed in
+	// the order book at a time, 0 means not an iceberg order.
+	Display uint64
+}
+
+// DecodeRLP decodes the order, the orders stored before Display was
+// added decode as non-iceberg orders. The structs embedding Order
+// must define their own DecodeRLP, or this one is promoted to them.
+func (o *Order) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	if err := decodeFields(s, &o.Owner, &o.SellSide, &o.Quant, &o.Price, &o.ExpireRound); err != nil {
+		return err
+	}
+
+	o.Display = 0
+	if err := decodeOptional(s, &o.Display); err != nil {
+		return err
+	}
+
+	return s.ListEnd()
 }
 
 func newOrderBook() *orderBook {
 	return &orderBook{
-		// don't need to actively remove the entries that are
-		// cancelled or matched, they will be "garbage
-		// collected" each block, during the order book
-		// serialization.
+		bids: bookSide{bid: true},
+		// the filled and cancelled entries are unlinked from
+		// their price levels right away. Cancel also removes
+		// the entry from the index, a filled entry stays with
+		// zero quantity until the order book is serialized.
 		idToEntry: make(map[uint64]*orderBookEntry),
 	}
 }
 
-func (o *orderBook) Cancel(id uint64) {
+// Cancel removes the resting order of the ID from the order book,
+// and returns its unfilled part, including the hidden quantity of an
+// iceberg order. The price level is removed once it has no resting
+// orders. It returns false if the order does not rest on the order
+// book.
+func (o *orderBook) Cancel(id uint64) (Order, bool) {
+	order, ok := o.Resting(id)
+	if !ok {
+		return Order{}, false
+	}
+
 	entry := o.idToEntry[id]
-	if entry != nil {
-		entry.Quant = 0
+	delete(o.idToEntry, id)
+	p := entry.point
+	p.remove(entry)
+	p.quant -= entry.Quant
+	p.orders--
+	entry.Quant = 0
+	entry.Hidden = 0
+	if p.orders == 0 {
+		if p.bid {
+			o.bids.remove(p)
+		} else {
+			o.asks.remove(p)
+		}
 	}
+	return order, true
+}
+
+// Resting returns the unfilled part of the resting order of the ID,
+// including the hidden quantity of an iceberg order. It returns false
+// if the order does not rest on the order book.
+func (o *orderBook) Resting(id uint64) (Order, bool) {
+	entry := o.idToEntry[id]
+	if entry == nil || entry.Quant == 0 {
+		return Order{}, false
+	}
+
+	return Order{
+		Owner:    entry.Owner,
+		SellSide: !entry.point.bid,
+		Quant:    entry.Quant + entry.Hidden,
+		Price:    entry.point.Price,
+		Display:  entry.Display,
+	}, true
+}
+
+// entry returns the resting order with the given ID, it is nil-safe.
+func (o *orderBook) entry(id uint64) (orderBookEntryData, bool) {
+	if o == nil {
+		return orderBookEntryData{}, false
+	}
+
+	e := o.idToEntry[id]
+	if e == nil || e.Quant == 0 {
+		return orderBookEntryData{}, false
+	}
+	return e.orderBookEntryData, true
 }
 
 func (o *orderBook) getEntry(data orderBookEntryData) *orderBookEntry {
@@ -81,221 +394,226 @@ func (o *orderBook) getEntry(data orderBookEntryData) *orderBookEntry {
 	return e
 }
 
+// NewID allocates a new order ID.
+func (o *orderBook) NewID() uint64 {
+	id := o.nextOrderID
+	o.nextOrderID++
+	return id
+}
+
 // Limit processes a incoming limit order.
 func (o *orderBook) Limit(order Order) (id uint64, executions []orderExecution) {
-	id = o.nextOrderID
-	o.nextOrderID++
+	id = o.NewID()
+	executions = o.limit(id, order, true)
+	return
+}
+
+// LimitWithID processes a limit order whose ID is allocated by NewID
+// beforehand, e.g., a triggered stop order.
+func (o *orderBook) LimitWithID(id uint64, order Order) []orderExecution {
+	return o.limit(id, order, true)
+}
 
+// ImmediateOrCancel processes a incoming order which matches the
+// resting orders, the unfilled part is not inserted into the order
+// book.
+func (o *orderBook) ImmediateOrCancel(order Order) (id uint64, executions []orderExecution) {
+	id = o.NewID()
+	executions = o.limit(id, order, false)
+	return
+}
+
+// Matchable returns the quantity of the resting orders that the
+// order could match, including the hidden quantity of the iceberg
+// orders, the counting stops once the order's quantity is reached.
+func (o *orderBook) Matchable(order Order) uint64 {
+	var quant uint64
 	if !order.SellSide {
-		// match the incoming buy order
-		for o.askMin != nil && order.Price >= o.askMin.Price {
-			entry := o.askMin.ListHead
-			for entry != nil {
-				if entry.Quant >= order.Quant {
-					// order is filled
-					execA := orderExecution{
-						Owner:    order.Owner,
-						ID:       id,
-						SellSide: false,
-						Quant:    order.Quant,
-						Price:    o.askMin.Price,
-						Taker:    true,
-					}
-
-					execB := orderExecution{
-						Owner:    entry.Owner,
-						ID:       entry.ID,
-						SellSide: true,
-						Quant:    order.Quant,
-						Price:    o.askMin.Price,
-						Taker:    false,
-					}
-
-					executions = append(executions, execA, execB)
-					entry.Quant -= order.Quant
-					if entry.Quant == 0 {
-						if entry.Next != nil {
-							o.askMin.ListHead = entry.Next
-						} else {
-							o.askMin = o.askMin.NextPoint
-						}
-					}
-					return
+		for p := o.asks.best(); p != nil && order.Price >= p.Price; p = p.NextPoint {
+			for e := p.ListHead; e != nil; e = e.Next {
+				quant += e.Quant + e.Hidden
+				if quant >= order.Quant {
+					return quant
 				}
-
-				if entry.Quant > 0 {
-					order.Quant -= entry.Quant
-					execA := orderExecution{
-						Owner:    order.Owner,
-						ID:       id,
-						SellSide: false,
-						Quant:    entry.Quant,
-						Price:    o.askMin.Price,
-						Taker:    true,
-					}
-
-					execB := orderExecution{
-						Owner:    entry.Owner,
-						ID:       entry.ID,
-						SellSide: true,
-						Quant:    entry.Quant,
-						Price:    o.askMin.Price,
-						Taker:    false,
-					}
-					executions = append(executions, execA, execB)
-					entry.Quant = 0
+			}
+		}
+	} else {
+		for p := o.bids.best(); p != nil && order.Price <= p.Price; p = p.NextPoint {
+			for e := p.ListHead; e != nil; e = e.Next {
+				quant += e.Quant + e.Hidden
+				if quant >= order.Quant {
+					return quant
 				}
-				entry = entry.Next
 			}
-
-			// all the orders in the current price point
-			// is filled, move to next price point.
-			o.askMin = o.askMin.NextPoint
 		}
+	}
+	return quant
+}
 
-		// TODO: if a IOC order, do not need to insert
-		// no more matching orders, add to the order book
-		entry := o.getEntry(orderBookEntryData{
-			ID:    id,
-			Owner: order.Owner,
-			Quant: order.Quant,
-		})
+// Side is a side of the order book.
+type Side int
+
+const (
+	Bid Side = iota
+	Ask
+)
+
+// Level is the total quantity and the number of the resting
+// orders at a price.
+type Level struct {
+	Price  uint64
+	Quant  uint64
+	Orders int
+}
+
+func depth(p *pricePoint, levels int) []Level {
+	var r []Level
+	for ; p != nil && (levels <= 0 || len(r) < levels); p = p.NextPoint {
+		r = append(r, Level{Price: p.Price, Quant: p.quant, Orders: p.orders})
+	}
+	return r
+}
+
+// Depth returns the aggregated price levels of the side of the
+// order book, from the best price. At most levels levels are
+// returned, levels <= 0 means no limit. The price levels keep their
+// total quantity and number of orders, the orders are not walked.
+func (o *orderBook) Depth(side Side, levels int) []Level {
+	if side == Bid {
+		return depth(o.bids.best(), levels)
+	}
+	return depth(o.asks.best(), levels)
+}
+
+// Execution is a match of an incoming order, the taker, with a
+// resting order, the maker, at the maker's price.
+type Execution struct {
+	MakerID uint64
+	TakerID uint64
+	Price   uint64
+	Quant   uint64
+}
+
+// Match matches the incoming order against the resting orders, the
+// unfilled remainder is returned instead of resting in the order
+// book. The order's ID is allocated by NewID.
+func (o *orderBook) Match(order Order) (executions []Execution, remaining Order) {
+	return o.match(o.NewID(), order)
+}
+
+// match matches the order of the ID against the resting orders of
+// the other side: by the best price first, and by the order IDs
+// within a price. It fills the resting orders partially if the
+// order is smaller, and returns the executions and the unfilled
+// remainder of the order.
+func (o *orderBook) match(id uint64, order Order) (executions []Execution, remaining Order) {
+	side := &o.asks
+	crosses := func(price uint64) bool { return order.Price >= price }
+	if order.SellSide {
+		side = &o.bids
+		crosses = func(price uint64) bool { return order.Price <= price }
+	}
 
-		if o.bidMax == nil || order.Price > o.bidMax.Price {
-			o.bidMax = &pricePoint{
-				Price:     order.Price,
-				NextPoint: o.bidMax,
-				ListHead:  entry,
-				ListTail:  entry,
+	for order.Quant > 0 && side.best() != nil && crosses(side.best().Price) {
+		p := side.best()
+		for p.ListHead != nil && order.Quant > 0 {
+			e := p.ListHead
+			quant := e.Quant
+			if quant > order.Quant {
+				quant = order.Quant
 			}
-		} else if order.Price == o.bidMax.Price {
-			o.bidMax.ListTail.Next = entry
-			o.bidMax.ListTail = entry
-		} else {
-			prev := o.bidMax
-			cur := o.bidMax.NextPoint
-			for ; ; prev, cur = cur, cur.NextPoint {
-				if cur == nil || cur.Price < order.Price {
-					point := &pricePoint{
-						Price:     order.Price,
-						NextPoint: cur,
-						ListHead:  entry,
-						ListTail:  entry,
-					}
-					prev.NextPoint = point
-					break
-				} else if cur.Price == order.Price {
-					cur.ListTail.Next = entry
-					cur.ListTail = entry
-					break
-				}
+
+			executions = append(executions, Execution{MakerID: e.ID, TakerID: id, Price: p.Price, Quant: quant})
+			e.Quant -= quant
+			order.Quant -= quant
+			p.quant -= quant
+			if e.Quant > 0 {
+				continue
 			}
-		}
-	} else {
-		// match the incoming sell order
-		for o.bidMax != nil && order.Price <= o.bidMax.Price {
-			entry := o.bidMax.ListHead
-			for entry != nil {
-				if entry.Quant >= order.Quant {
-					// order is filled
-					execA := orderExecution{
-						Owner:    order.Owner,
-						ID:       id,
-						SellSide: true,
-						Quant:    order.Quant,
-						Price:    o.bidMax.Price,
-						Taker:    true,
-					}
-
-					execB := orderExecution{
-						Owner:    entry.Owner,
-						ID:       entry.ID,
-						SellSide: false,
-						Quant:    order.Quant,
-						Price:    o.bidMax.Price,
-						Taker:    false,
-					}
-
-					executions = append(executions, execA, execB)
-					entry.Quant -= order.Quant
-					if entry.Quant == 0 {
-						if entry.Next != nil {
-							o.bidMax.ListHead = entry.Next
-						} else {
-							o.bidMax = o.bidMax.NextPoint
-						}
-					}
-					return
-				}
 
-				if entry.Quant > 0 {
-					order.Quant -= entry.Quant
-					execA := orderExecution{
-						Owner:    order.Owner,
-						ID:       id,
-						SellSide: true,
-						Quant:    entry.Quant,
-						Price:    o.bidMax.Price,
-						Taker:    true,
-					}
-
-					execB := orderExecution{
-						Owner:    entry.Owner,
-						ID:       entry.ID,
-						SellSide: false,
-						Quant:    entry.Quant,
-						Price:    o.bidMax.Price,
-						Taker:    false,
-					}
-					executions = append(executions, execA, execB)
-					entry.Quant = 0
-				}
-				entry = entry.Next
+			p.orders--
+			p.remove(e)
+			if e.Hidden > 0 {
+				o.refresh(e)
 			}
+		}
 
-			o.bidMax = o.bidMax.NextPoint
+		if p.orders == 0 {
+			// all the orders at the price are filled
+			side.removeBest()
 		}
+	}
 
-		// TODO: if a IOC order, do not need to insert
-		entry := o.getEntry(orderBookEntryData{
-			ID:    id,
-			Owner: order.Owner,
-			Quant: order.Quant,
-		})
+	return executions, order
+}
 
-		if o.askMin == nil || order.Price < o.askMin.Price {
-			o.askMin = &pricePoint{
-				Price:     order.Price,
-				NextPoint: o.askMin,
-				ListHead:  entry,
-				ListTail:  entry,
-			}
-		} else if order.Price == o.askMin.Price {
-			o.askMin.ListTail.Next = entry
-			o.askMin.ListTail = entry
-		} else {
-			prev := o.askMin
-			cur := o.askMin.NextPoint
-			for ; ; prev, cur = cur, cur.NextPoint {
-				if cur == nil || cur.Price > order.Price {
-					point := &pricePoint{
-						Price:     order.Price,
-						NextPoint: cur,
-						ListHead:  entry,
-						ListTail:  entry,
-					}
-					prev.NextPoint = point
-					break
-				} else if cur.Price == order.Price {
-					cur.ListTail.Next = entry
-					cur.ListTail = entry
-					break
-				}
-			}
-		}
+// refresh displays the next slice of the iceberg order whose
+// displayed slice is filled, the slice is at the back of the price
+// level.
+func (o *orderBook) refresh(e *orderBookEntry) {
+	e.Quant = e.Display
+	if e.Quant > e.Hidden {
+		e.Quant = e.Hidden
 	}
+	e.Hidden -= e.Quant
+	e.Priority = o.NewID()
+	e.point.insert(e)
+}
 
-	return
+// rest inserts the order of the ID into the order book, the orders
+// of a price are ordered by their priorities. Only the display
+// quantity of an iceberg order is displayed, the rest is displayed
+// in slices as the displayed slice is filled.
+func (o *orderBook) rest(id uint64, order Order) {
+	data := orderBookEntryData{
+		ID:       id,
+		Owner:    order.Owner,
+		Quant:    order.Quant,
+		Priority: id,
+	}
+	if order.Display > 0 && order.Display < order.Quant {
+		data.Quant = order.Display
+		data.Display = order.Display
+		data.Hidden = order.Quant - order.Display
+	}
+	entry := o.getEntry(data)
+
+	side := &o.bids
+	if order.SellSide {
+		side = &o.asks
+	}
+
+	side.point(order.Price).insert(entry)
+}
+
+// limit matches the order of the ID, and inserts the remainder into
+// the order book if rest is true. Each execution is reported to both
+// the taker and the maker.
+func (o *orderBook) limit(id uint64, order Order, rest bool) []orderExecution {
+	matched, remaining := o.match(id, order)
+	var executions []orderExecution
+	for _, m := range matched {
+		executions = append(executions, orderExecution{
+			Owner:    order.Owner,
+			ID:       id,
+			SellSide: order.SellSide,
+			Quant:    m.Quant,
+			Price:    m.Price,
+			Taker:    true,
+		}, orderExecution{
+			Owner:    o.idToEntry[m.MakerID].Owner,
+			ID:       m.MakerID,
+			SellSide: !order.SellSide,
+			Quant:    m.Quant,
+			Price:    m.Price,
+			Taker:    false,
+		})
+	}
+
+	if rest && remaining.Quant > 0 {
+		o.rest(id, remaining)
+	}
+	return executions
 }
 
 type orderBookPointToMarshal struct {
@@ -303,20 +621,17 @@ type orderBookPointToMarshal struct {
 	Entries []orderBookEntryData
 }
 
+// flatten returns the price levels, the cancelled and filled orders
+// are removed from them, so the encoded order book only depends on
+// the resting orders.
 func flatten(p *pricePoint) []orderBookPointToMarshal {
 	var r []orderBookPointToMarshal
 	for ; p != nil; p = p.NextPoint {
 		var entries []orderBookEntryData
-		e := p.ListHead
-		for ; e != nil; e = e.Next {
-			if e.Quant == 0 {
-				// 0 quant entries are cancelled
-				// entries, skip.
-				continue
-			}
-
+		for e := p.ListHead; e != nil; e = e.Next {
 			entries = append(entries, e.orderBookEntryData)
 		}
+
 		r = append(r, orderBookPointToMarshal{
 			Price:   p.Price,
 			Entries: entries,
@@ -326,50 +641,49 @@ func flatten(p *pricePoint) []orderBookPointToMarshal {
 	return r
 }
 
-func (o *orderBook) unflattenPoint(point orderBookPointToMarshal) *pricePoint {
-	if len(point.Entries) == 0 {
-		return nil
-	}
+func (o *orderBook) unflattenPoint(side *bookSide, point orderBookPointToMarshal) *pricePoint {
+	p := side.newPoint(point.Price)
+	for _, data := range point.Entries {
+		if data.Quant == 0 {
+			continue
+		}
 
-	p := &pricePoint{
-		Price: point.Price,
+		// the entries are ordered, each is appended
+		e := o.getEntry(data)
+		e.point = p
+		e.prev = p.ListTail
+		if p.ListTail == nil {
+			p.ListHead = e
+		} else {
+			p.ListTail.Next = e
+		}
+		p.ListTail = e
+		p.quant += e.Quant
+		p.orders++
 	}
 
-	entries := make([]*orderBookEntry, len(point.Entries))
-	var last *orderBookEntry
-	for i := len(entries) - 1; i >= 0; i-- {
-		entries[i] = o.getEntry(point.Entries[i])
-		entries[i].Next = last
-		last = entries[i]
+	if p.orders == 0 {
+		return nil
 	}
-
-	p.ListHead = entries[0]
-	p.ListTail = entries[len(entries)-1]
 	return p
 }
 
-func (o *orderBook) unflatten(points []orderBookPointToMarshal) *pricePoint {
-	var root *pricePoint
-	var prev *pricePoint
+func (o *orderBook) unflatten(side *bookSide, points []orderBookPointToMarshal) {
+	var r []*pricePoint
 	for _, p := range points {
-		cur := o.unflattenPoint(p)
+		cur := o.unflattenPoint(side, p)
 		if cur == nil {
 			continue
 		}
 
-		if root == nil {
-			root = cur
-		} else {
-			prev.NextPoint = cur
-		}
-		prev = cur
+		r = append(r, cur)
 	}
-	return root
+	side.reset(r)
 }
 
 func (o *orderBook) EncodeRLP(w io.Writer) error {
-	askPoints := flatten(o.askMin)
-	bidPoints := flatten(o.bidMax)
+	askPoints := flatten(o.asks.best())
+	bidPoints := flatten(o.bids.best())
 	err := rlp.Encode(w, askPoints)
 	if err != nil {
 		return err
@@ -414,7 +728,8 @@ func (o *orderBook) DecodeRLP(s *rlp.Stream) error {
 	}
 
 	o.nextOrderID = nextOrderID
-	o.askMin = o.unflatten(askPoints)
-	o.bidMax = o.unflatten(bidPoints)
+	o.bids.bid = true
+	o.unflatten(&o.asks, askPoints)
+	o.unflatten(&o.bids, bidPoints)
 	return nil
 }
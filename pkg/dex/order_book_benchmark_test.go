@@ -0,0 +1,120 @@
+package dex
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// ordersPerLevel is the number of the resting orders at each price
+// of the order books of the benchmarks.
+const ordersPerLevel = 4
+
+var restingOrders = []int{1000, 10000, 100000}
+
+// restingBook returns the order book of n resting asks of quant 1,
+// ordersPerLevel at each price from 1.
+func restingBook(n int) *orderBook {
+	book := newOrderBook()
+	for i := 0; i < n; i++ {
+		book.Limit(Order{SellSide: true, Quant: 1, Price: uint64(1 + i/ordersPerLevel)})
+	}
+	return book
+}
+
+func BenchmarkOrderBookInsert(b *testing.B) {
+	for _, n := range restingOrders {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			book := restingBook(n)
+			levels := n / ordersPerLevel
+			r := rand.New(rand.NewSource(1))
+			prices := make([]uint64, 1024)
+			for i := range prices {
+				prices[i] = uint64(1 + r.Intn(levels))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				book.Limit(Order{SellSide: true, Quant: 1, Price: prices[i%len(prices)]})
+			}
+		})
+	}
+}
+
+func BenchmarkOrderBookMatch5Levels(b *testing.B) {
+	for _, n := range restingOrders {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			book := restingBook(n)
+			next := uint64(1 + n/ordersPerLevel)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, executions := book.Limit(Order{Quant: 5 * ordersPerLevel, Price: next})
+				if len(executions) != 2*5*ordersPerLevel {
+					b.Fatalf("got %d executions", len(executions))
+				}
+
+				// keep the number of the resting orders
+				b.StopTimer()
+				for j := 0; j < 5*ordersPerLevel; j++ {
+					book.Limit(Order{SellSide: true, Quant: 1, Price: next + uint64(j/ordersPerLevel)})
+				}
+				next += 5
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkOrderBookCancel(b *testing.B) {
+	for _, n := range restingOrders {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			r := rand.New(rand.NewSource(1))
+			var book *orderBook
+			var ids []int
+			for i := 0; i < b.N; i++ {
+				if i%n == 0 {
+					// all the orders are cancelled
+					b.StopTimer()
+					book = restingBook(n)
+					ids = r.Perm(n)
+					b.StartTimer()
+				}
+
+				if _, ok := book.Cancel(uint64(ids[i%n])); !ok {
+					b.Fatalf("order %d is not cancelled", ids[i%n])
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkOrderBookMarketMaker places the orders around the best
+// price, and cancels 90% of them, like a market maker updating its
+// quotes.
+func BenchmarkOrderBookMarketMaker(b *testing.B) {
+	for _, n := range restingOrders {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			book := restingBook(n)
+			r := rand.New(rand.NewSource(1))
+			live := make([]uint64, n)
+			for i := range live {
+				live[i] = uint64(i)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id, _ := book.Limit(Order{SellSide: true, Quant: 1, Price: uint64(1 + r.Intn(10))})
+				live = append(live, id)
+				if i%10 == 0 {
+					continue
+				}
+
+				k := r.Intn(len(live))
+				book.Cancel(live[k])
+				live[k] = live[len(live)-1]
+				live = live[:len(live)-1]
+			}
+		})
+	}
+}
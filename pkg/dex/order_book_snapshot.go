@@ -0,0 +1,110 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// orderBookSnapshot is the snapshot of an order book, the orders of
+// each price level are in their arrival order.
+type orderBookSnapshot struct {
+	Asks        []orderBookPointToMarshal
+	Bids        []orderBookPointToMarshal
+	NextOrderID uint64
+	AskSummary  sideSummary
+	BidSummary  sideSummary
+}
+
+// sideSummary is the number and the total quantity, including the
+// hidden quantity of the iceberg orders, of the orders of a side, it
+// checks the integrity of the restored order book.
+type sideSummary struct {
+	Orders uint64
+	Quant  uint64
+}
+
+// summarize returns the summary of the points, and checks that the
+// prices are ordered from the best.
+func summarize(points []orderBookPointToMarshal, bid bool) (sideSummary, error) {
+	var s sideSummary
+	for i, p := range points {
+		if i > 0 {
+			prev := points[i-1].Price
+			if (bid && prev <= p.Price) || (!bid && prev >= p.Price) {
+				return sideSummary{}, fmt.Errorf("price %d is out of order after %d", p.Price, prev)
+			}
+		}
+
+		for _, e := range p.Entries {
+			if e.Quant == 0 {
+				return sideSummary{}, fmt.Errorf("order %d has 0 quant", e.ID)
+			}
+
+			s.Orders++
+			s.Quant += e.Quant + e.Hidden
+		}
+	}
+	return s, nil
+}
+
+// Snapshot serializes the order book, the restored order book
+// matches the incoming orders the same as the order book, see
+// restoreOrderBook.
+func (o *orderBook) Snapshot() ([]byte, error) {
+	s := orderBookSnapshot{
+		Asks:        flatten(o.asks.best()),
+		Bids:        flatten(o.bids.best()),
+		NextOrderID: o.nextOrderID,
+	}
+
+	var err error
+	s.AskSummary, err = summarize(s.Asks, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s.BidSummary, err = summarize(s.Bids, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return rlp.EncodeToBytes(s)
+}
+
+// errSnapshotMismatch is returned when the orders of a snapshot do
+// not match its summary.
+var errSnapshotMismatch = errors.New("the orders of the order book snapshot do not match its summary")
+
+// restoreOrderBook restores the order book from its snapshot.
+func restoreOrderBook(b []byte) (*orderBook, error) {
+	var s orderBookSnapshot
+	err := rlp.DecodeBytes(b, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	asks, err := summarize(s.Asks, false)
+	if err != nil {
+		return nil, err
+	}
+
+	bids, err := summarize(s.Bids, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if asks != s.AskSummary || bids != s.BidSummary {
+		return nil, errSnapshotMismatch
+	}
+
+	o := newOrderBook()
+	o.nextOrderID = s.NextOrderID
+	o.unflatten(&o.asks, s.Asks)
+	o.unflatten(&o.bids, s.Bids)
+	if len(o.idToEntry) != int(asks.Orders+bids.Orders) {
+		return nil, errors.New("the order book snapshot has duplicate order IDs")
+	}
+	return o, nil
+}
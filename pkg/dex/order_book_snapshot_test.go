@@ -0,0 +1,112 @@
+package dex
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderBookSnapshotFIFO(t *testing.T) {
+	book := newOrderBook()
+	first, _ := book.Limit(Order{SellSide: true, Quant: 3, Price: 10})
+	second, _ := book.Limit(Order{SellSide: true, Quant: 5, Price: 10})
+	// partially filled, the order keeps its position
+	book.Limit(Order{Quant: 1, Price: 10})
+
+	b, err := book.Snapshot()
+	assert.Nil(t, err)
+	restored, err := restoreOrderBook(b)
+	assert.Nil(t, err)
+	assert.Equal(t, book.nextOrderID, restored.nextOrderID)
+
+	_, executions := restored.Limit(Order{Quant: 4, Price: 10})
+	assert.Equal(t, 4, len(executions))
+	assert.Equal(t, first, executions[1].ID)
+	assert.Equal(t, uint64(2), executions[1].Quant)
+	assert.Equal(t, second, executions[3].ID)
+	assert.Equal(t, uint64(2), executions[3].Quant)
+}
+
+func TestOrderBookSnapshotRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for round := 0; round < 20; round++ {
+		orders, cancels := randomOrders(r, 300)
+		book := newOrderBook()
+		for i, order := range orders {
+			book.Limit(order)
+			if c, ok := cancels[i]; ok {
+				book.Cancel(uint64(c))
+			}
+		}
+
+		b, err := book.Snapshot()
+		assert.Nil(t, err)
+		restored, err := restoreOrderBook(b)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		b1, err := restored.Snapshot()
+		assert.Nil(t, err)
+		assert.Equal(t, b, b1)
+
+		// the same incoming orders get the same executions
+		incoming, _ := randomOrders(r, 50)
+		for _, order := range incoming {
+			_, e := book.Limit(order)
+			_, e1 := restored.Limit(order)
+			if !assert.Equal(t, e, e1) {
+				return
+			}
+		}
+
+		b, err = book.Snapshot()
+		assert.Nil(t, err)
+		b1, err = restored.Snapshot()
+		assert.Nil(t, err)
+		assert.Equal(t, b, b1)
+	}
+}
+
+func TestOrderBookSnapshotIntegrity(t *testing.T) {
+	book := newOrderBook()
+	book.Limit(Order{Quant: 3, Price: 10})
+	book.Limit(Order{Quant: 5, Price: 9})
+	book.Limit(Order{SellSide: true, Quant: 5, Price: 12})
+	b, err := book.Snapshot()
+	assert.Nil(t, err)
+
+	var s orderBookSnapshot
+	err = rlp.DecodeBytes(b, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, sideSummary{Orders: 2, Quant: 8}, s.BidSummary)
+	assert.Equal(t, sideSummary{Orders: 1, Quant: 5}, s.AskSummary)
+
+	tamper := func(f func(s *orderBookSnapshot)) error {
+		var s orderBookSnapshot
+		err := rlp.DecodeBytes(b, &s)
+		assert.Nil(t, err)
+		f(&s)
+		tampered, err := rlp.EncodeToBytes(s)
+		assert.Nil(t, err)
+		_, err = restoreOrderBook(tampered)
+		return err
+	}
+
+	err = tamper(func(s *orderBookSnapshot) { s.Bids[0].Entries[0].Quant++ })
+	assert.Equal(t, errSnapshotMismatch, err)
+
+	err = tamper(func(s *orderBookSnapshot) { s.Asks = nil })
+	assert.Equal(t, errSnapshotMismatch, err)
+
+	err = tamper(func(s *orderBookSnapshot) { s.Bids[0], s.Bids[1] = s.Bids[1], s.Bids[0] })
+	assert.NotNil(t, err)
+
+	err = tamper(func(s *orderBookSnapshot) { s.Bids[1].Entries[0].ID = s.Bids[0].Entries[0].ID })
+	assert.NotNil(t, err)
+
+	_, err = restoreOrderBook(b[:len(b)-1])
+	assert.NotNil(t, err)
+}
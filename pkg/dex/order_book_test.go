@@ -1,9 +1,14 @@
 package dex
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,11 +23,12 @@ func TestOrderBookBid(t *testing.T) {
 			Quant: 10,
 		},
 	}
-	assert.Equal(t, book.bidMax, &pricePoint{
-		Price:    1,
-		ListHead: e,
-		ListTail: e,
-	})
+	p := book.bids.best()
+	e.point = p
+	assert.Equal(t, uint64(1), p.Price)
+	assert.Equal(t, e, p.ListHead)
+	assert.Equal(t, e, p.ListTail)
+	assert.Nil(t, p.NextPoint)
 
 	book.Limit(Order{
 		Quant: 12,
@@ -30,16 +36,19 @@ func TestOrderBookBid(t *testing.T) {
 	})
 	e1 := &orderBookEntry{
 		orderBookEntryData: orderBookEntryData{
-			ID:    1,
-			Quant: 12,
+			ID:       1,
+			Quant:    12,
+			Priority: 1,
 		},
 	}
 	e.Next = e1
-	assert.Equal(t, book.bidMax, &pricePoint{
-		Price:    1,
-		ListHead: e,
-		ListTail: e1,
-	})
+	e1.prev = e
+	e1.point = p
+	p = book.bids.best()
+	assert.Equal(t, uint64(1), p.Price)
+	assert.Equal(t, e, p.ListHead)
+	assert.Equal(t, e1, p.ListTail)
+	assert.Nil(t, p.NextPoint)
 }
 
 func TestOrderBookSell(t *testing.T) {
@@ -54,11 +63,12 @@ func TestOrderBookSell(t *testing.T) {
 			Quant: 10,
 		},
 	}
-	assert.Equal(t, book.askMin, &pricePoint{
-		Price:    1,
-		ListHead: e,
-		ListTail: e,
-	})
+	p := book.asks.best()
+	e.point = p
+	assert.Equal(t, uint64(1), p.Price)
+	assert.Equal(t, e, p.ListHead)
+	assert.Equal(t, e, p.ListTail)
+	assert.Nil(t, p.NextPoint)
 
 	book.Limit(Order{
 		Quant:    12,
@@ -67,16 +77,19 @@ func TestOrderBookSell(t *testing.T) {
 	})
 	e1 := &orderBookEntry{
 		orderBookEntryData: orderBookEntryData{
-			ID:    1,
-			Quant: 12,
+			ID:       1,
+			Quant:    12,
+			Priority: 1,
 		},
 	}
 	e.Next = e1
-	assert.Equal(t, book.askMin, &pricePoint{
-		Price:    1,
-		ListHead: e,
-		ListTail: e1,
-	})
+	e1.prev = e
+	e1.point = p
+	p = book.asks.best()
+	assert.Equal(t, uint64(1), p.Price)
+	assert.Equal(t, e, p.ListHead)
+	assert.Equal(t, e1, p.ListTail)
+	assert.Nil(t, p.NextPoint)
 }
 
 func TestOrderBookMatching(t *testing.T) {
@@ -99,19 +112,19 @@ func TestOrderBookMatching(t *testing.T) {
 	for _, o := range orders {
 		book.Limit(o)
 	}
-	assert.Equal(t, 2, int(book.askMin.Price))
-	assert.Equal(t, 1, int(book.askMin.ListHead.Quant))
-	assert.Equal(t, 1, int(book.bidMax.Price))
-	assert.Equal(t, 10, int(book.bidMax.ListHead.Quant))
+	assert.Equal(t, 2, int(book.asks.best().Price))
+	assert.Equal(t, 1, int(book.asks.best().ListHead.Quant))
+	assert.Equal(t, 1, int(book.bids.best().Price))
+	assert.Equal(t, 10, int(book.bids.best().ListHead.Quant))
 
 	book.Limit(Order{
 		Quant:    100,
 		Price:    1,
 		SellSide: true,
 	})
-	assert.Nil(t, book.bidMax)
-	assert.Equal(t, 1, int(book.askMin.Price))
-	assert.Equal(t, 90, int(book.askMin.ListHead.Quant))
+	assert.Nil(t, book.bids.best())
+	assert.Equal(t, 1, int(book.asks.best().Price))
+	assert.Equal(t, 90, int(book.asks.best().ListHead.Quant))
 	assert.Equal(t, 4, int(book.nextOrderID))
 }
 
@@ -185,7 +198,8 @@ func TestOrderBookEncodeDecode(t *testing.T) {
 		panic(err)
 	}
 
-	assert.Equal(t, book.bidMax, book1.bidMax)
+	assert.Equal(t, flatten(book.bids.best()), flatten(book1.bids.best()))
+	assert.Equal(t, flatten(book.asks.best()), flatten(book1.asks.best()))
 }
 
 func TestCancelOrder(t *testing.T) {
@@ -194,12 +208,801 @@ func TestCancelOrder(t *testing.T) {
 		Price: 1,
 		Quant: 10,
 	})
-	assert.NotNil(t, book.bidMax)
-	assert.Equal(t, 1, int(book.bidMax.Price))
-	assert.Equal(t, 10, int(book.bidMax.ListHead.Quant))
+	assert.NotNil(t, book.bids.best())
+	assert.Equal(t, 1, int(book.bids.best().Price))
+	assert.Equal(t, 10, int(book.bids.best().ListHead.Quant))
+
+	order, ok := book.Cancel(id)
+	assert.True(t, ok)
+	assert.Equal(t, Order{Price: 1, Quant: 10}, order)
+	// the price level is removed once it has no orders
+	assert.Nil(t, book.bids.best())
+
+	_, ok = book.Cancel(id)
+	assert.False(t, ok)
+}
+
+func TestOrderBookCancelPartiallyFilled(t *testing.T) {
+	owner := consensus.Addr{1}
+	book := newOrderBook()
+	first, _ := book.Limit(Order{Owner: owner, SellSide: true, Quant: 10, Price: 5})
+	second, _ := book.Limit(Order{SellSide: true, Quant: 4, Price: 5})
+	third, _ := book.Limit(Order{SellSide: true, Quant: 2, Price: 5})
+	book.Limit(Order{Quant: 3, Price: 5})
+
+	order, ok := book.Cancel(first)
+	assert.True(t, ok)
+	assert.Equal(t, Order{Owner: owner, SellSide: true, Quant: 7, Price: 5}, order)
+	_, ok = book.Cancel(first)
+	assert.False(t, ok)
+	asks := book.Depth(Ask, 0)
+	assert.Equal(t, []Level{{Price: 5, Quant: 6, Orders: 2}}, asks)
+	assert.Nil(t, checkOrderBook(book))
+
+	// the orders around the cancelled one keep their order
+	fourth, _ := book.Limit(Order{SellSide: true, Quant: 1, Price: 5})
+	_, ok = book.Cancel(third)
+	assert.True(t, ok)
+	assert.Nil(t, checkOrderBook(book))
+	_, executions := book.Limit(Order{Quant: 5, Price: 5})
+	assert.Equal(t, [][2]uint64{{second, 4}, {fourth, 1}}, makerFills(executions))
+	assert.Nil(t, book.asks.best())
+
+	// a filled order is not cancelled
+	_, ok = book.Cancel(second)
+	assert.False(t, ok)
+
+	// the hidden quantity of an iceberg order is returned
+	iceberg, _ := book.Limit(Order{SellSide: true, Quant: 10, Price: 6, Display: 3})
+	book.Limit(Order{Quant: 4, Price: 6})
+	order, ok = book.Cancel(iceberg)
+	assert.True(t, ok)
+	assert.Equal(t, Order{SellSide: true, Quant: 6, Price: 6, Display: 3}, order)
+	assert.Nil(t, book.asks.best())
+	assert.Nil(t, checkOrderBook(book))
+}
+
+func TestOrderBookCancelRestored(t *testing.T) {
+	book := newOrderBook()
+	iceberg, _ := book.Limit(Order{Quant: 10, Price: 6, Display: 3})
+	partial, _ := book.Limit(Order{Quant: 5, Price: 6})
+	book.Limit(Order{Quant: 5, Price: 5})
+	book.Limit(Order{SellSide: true, Quant: 4, Price: 6})
+
+	b, err := book.Snapshot()
+	assert.Nil(t, err)
+	restored, err := restoreOrderBook(b)
+	assert.Nil(t, err)
+
+	// the iceberg's refreshed slice is behind the partially
+	// filled order
+	order, ok := restored.Cancel(partial)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(4), order.Quant)
+	order, ok = restored.Cancel(iceberg)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(7), order.Quant)
+	bids := restored.Depth(Bid, 0)
+	assert.Equal(t, []Level{{Price: 5, Quant: 5, Orders: 1}}, bids)
+	assert.Nil(t, checkOrderBook(restored))
+}
 
+func TestOrderBookImmediateOrCancel(t *testing.T) {
+	book := newOrderBook()
+	book.Limit(Order{Quant: 5, Price: 10, SellSide: true})
+	_, executions := book.ImmediateOrCancel(Order{Quant: 8, Price: 10})
+	assert.Equal(t, 2, len(executions))
+	assert.Equal(t, uint64(5), executions[0].Quant)
+	assert.True(t, executions[0].Taker)
+	assert.Nil(t, book.asks.best())
+	assert.Nil(t, book.bids.best())
+}
+
+func TestOrderBookMatchable(t *testing.T) {
+	book := newOrderBook()
+	book.Limit(Order{Quant: 5, Price: 10, SellSide: true})
+	book.Limit(Order{Quant: 3, Price: 11, SellSide: true})
+	book.Limit(Order{Quant: 4, Price: 12, SellSide: true})
+	book.Limit(Order{Quant: 6, Price: 8})
+	assert.Equal(t, uint64(0), book.Matchable(Order{Quant: 5, Price: 9}))
+	assert.Equal(t, uint64(8), book.Matchable(Order{Quant: 10, Price: 11}))
+	assert.Equal(t, uint64(5), book.Matchable(Order{Quant: 2, Price: 11}))
+	assert.Equal(t, uint64(6), book.Matchable(Order{Quant: 10, Price: 8, SellSide: true}))
+	assert.Equal(t, uint64(0), book.Matchable(Order{Quant: 10, Price: 9, SellSide: true}))
+}
+
+func TestOrderBookDepth(t *testing.T) {
+	book := newOrderBook()
+	book.Limit(Order{Quant: 10, Price: 1})
+	book.Limit(Order{Quant: 5, Price: 1})
+	id, _ := book.Limit(Order{Quant: 3, Price: 2})
+	book.Limit(Order{Quant: 4, Price: 3})
+	book.Limit(Order{SellSide: true, Quant: 7, Price: 5})
+	book.Limit(Order{SellSide: true, Quant: 8, Price: 6})
 	book.Cancel(id)
-	assert.NotNil(t, book.bidMax)
-	assert.Equal(t, 1, int(book.bidMax.Price))
-	assert.Equal(t, 0, int(book.bidMax.ListHead.Quant))
+
+	bids, asks := book.Depth(Bid, 0), book.Depth(Ask, 0)
+	assert.Equal(t, []Level{{Price: 3, Quant: 4, Orders: 1}, {Price: 1, Quant: 15, Orders: 2}}, bids)
+	assert.Equal(t, []Level{{Price: 5, Quant: 7, Orders: 1}, {Price: 6, Quant: 8, Orders: 1}}, asks)
+
+	bids, asks = book.Depth(Bid, 1), book.Depth(Ask, 1)
+	assert.Equal(t, []Level{{Price: 3, Quant: 4, Orders: 1}}, bids)
+	assert.Equal(t, []Level{{Price: 5, Quant: 7, Orders: 1}}, asks)
+}
+
+func TestOrderBookMatch(t *testing.T) {
+	book := newOrderBook()
+	a, _ := book.Limit(Order{SellSide: true, Quant: 5, Price: 10})
+	b, _ := book.Limit(Order{SellSide: true, Quant: 3, Price: 9})
+	c, _ := book.Limit(Order{SellSide: true, Quant: 4, Price: 10})
+	book.Limit(Order{SellSide: true, Quant: 1, Price: 12})
+
+	// the better price first, and then the earlier order at the
+	// same price, the last maker is filled partially
+	executions, remaining := book.Match(Order{Quant: 10, Price: 11})
+	id := book.nextOrderID - 1
+	assert.Equal(t, []Execution{
+		{MakerID: b, TakerID: id, Price: 9, Quant: 3},
+		{MakerID: a, TakerID: id, Price: 10, Quant: 5},
+		{MakerID: c, TakerID: id, Price: 10, Quant: 2},
+	}, executions)
+	assert.Equal(t, uint64(0), remaining.Quant)
+	asks := book.Depth(Ask, 0)
+	assert.Equal(t, []Level{{Price: 10, Quant: 2, Orders: 1}, {Price: 12, Quant: 1, Orders: 1}}, asks)
+
+	// the taker is filled partially, the remainder does not rest
+	executions, remaining = book.Match(Order{Quant: 4, Price: 10, Owner: consensus.Addr{1}})
+	assert.Equal(t, 1, len(executions))
+	assert.Equal(t, Order{Quant: 2, Price: 10, Owner: consensus.Addr{1}}, remaining)
+	bids, asks := book.Depth(Bid, 0), book.Depth(Ask, 0)
+	assert.Empty(t, bids)
+	assert.Equal(t, []Level{{Price: 12, Quant: 1, Orders: 1}}, asks)
+
+	// nothing crosses
+	executions, remaining = book.Match(Order{Quant: 4, Price: 11})
+	assert.Empty(t, executions)
+	assert.Equal(t, uint64(4), remaining.Quant)
+}
+
+func sideQuant(levels []Level) uint64 {
+	var q uint64
+	for _, l := range levels {
+		q += l.Quant
+	}
+	return q
+}
+
+// randomOrders returns the orders with the prices around 100, and
+// the IDs of the orders to cancel after them.
+func randomOrders(r *rand.Rand, n int) ([]Order, map[int]int) {
+	orders := make([]Order, n)
+	cancels := make(map[int]int)
+	for i := range orders {
+		orders[i] = Order{
+			Owner:    consensus.Addr{byte(r.Intn(4))},
+			SellSide: r.Intn(2) == 0,
+			Quant:    uint64(r.Intn(20)),
+			Price:    uint64(90 + r.Intn(21)),
+		}
+
+		if i > 0 && r.Intn(10) == 0 {
+			cancels[i] = r.Intn(i)
+		}
+	}
+	return orders, cancels
+}
+
+func TestOrderBookMatchProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		orders, cancels := randomOrders(r, 200)
+		book := newOrderBook()
+		for i, order := range orders {
+			bids, asks := book.Depth(Bid, 0), book.Depth(Ask, 0)
+			same, opposite := sideQuant(bids), sideQuant(asks)
+			if order.SellSide {
+				same, opposite = opposite, same
+			}
+
+			id := book.NewID()
+			executions, remaining := book.match(id, order)
+			var filled uint64
+			for j, e := range executions {
+				assert.Equal(t, id, e.TakerID)
+				assert.True(t, e.Quant > 0)
+				filled += e.Quant
+
+				// no execution is at a worse price than
+				// the taker's, or a worse price than the
+				// executions before it
+				if order.SellSide {
+					assert.True(t, e.Price >= order.Price)
+				} else {
+					assert.True(t, e.Price <= order.Price)
+				}
+
+				if j == 0 {
+					continue
+				}
+
+				prev := executions[j-1]
+				if order.SellSide {
+					assert.True(t, e.Price <= prev.Price)
+				} else {
+					assert.True(t, e.Price >= prev.Price)
+				}
+
+				// the earlier orders first within a price
+				if e.Price == prev.Price {
+					assert.True(t, e.MakerID > prev.MakerID)
+				}
+			}
+
+			// the quantities are conserved
+			assert.Equal(t, order.Quant, filled+remaining.Quant)
+			bids, asks = book.Depth(Bid, 0), book.Depth(Ask, 0)
+			if order.SellSide {
+				assert.Equal(t, opposite-filled, sideQuant(bids))
+			} else {
+				assert.Equal(t, opposite-filled, sideQuant(asks))
+			}
+
+			// the remainder does not cross the book, and no
+			// better price is left unfilled
+			if remaining.Quant > 0 {
+				if order.SellSide && len(bids) > 0 {
+					assert.True(t, bids[0].Price < order.Price)
+				} else if !order.SellSide && len(asks) > 0 {
+					assert.True(t, asks[0].Price > order.Price)
+				}
+
+				book.rest(id, remaining)
+				bids, asks = book.Depth(Bid, 0), book.Depth(Ask, 0)
+				if order.SellSide {
+					assert.Equal(t, same+remaining.Quant, sideQuant(asks))
+				} else {
+					assert.Equal(t, same+remaining.Quant, sideQuant(bids))
+				}
+			} else if len(executions) > 0 {
+				last := executions[len(executions)-1].Price
+				if order.SellSide && len(bids) > 0 {
+					assert.True(t, bids[0].Price <= last)
+				} else if !order.SellSide && len(asks) > 0 {
+					assert.True(t, asks[0].Price >= last)
+				}
+			}
+
+			if c, ok := cancels[i]; ok {
+				book.Cancel(uint64(c))
+			}
+		}
+	}
+}
+
+func TestOrderBookReplay(t *testing.T) {
+	orders, cancels := randomOrders(rand.New(rand.NewSource(2)), 1000)
+	var encoded [][]byte
+	var reports [][]orderExecution
+	for k := 0; k < 2; k++ {
+		book := newOrderBook()
+		var executions []orderExecution
+		for i, order := range orders {
+			_, e := book.Limit(order)
+			executions = append(executions, e...)
+			if c, ok := cancels[i]; ok {
+				book.Cancel(uint64(c))
+			}
+		}
+
+		b, err := rlp.EncodeToBytes(book)
+		assert.Nil(t, err)
+		encoded = append(encoded, b)
+		reports = append(reports, executions)
+	}
+
+	assert.Equal(t, encoded[0], encoded[1])
+	assert.Equal(t, reports[0], reports[1])
+	assert.NotEmpty(t, reports[0])
+}
+
+// naiveOrderBook is the reference order book which keeps the price
+// levels in the sorted slices, and finds a price level by scanning
+// them from the best price.
+type naiveOrderBook struct {
+	nextOrderID uint64
+	// from the best price
+	bids      []*naiveLevel
+	asks      []*naiveLevel
+	idToEntry map[uint64]*orderBookEntryData
+}
+
+type naiveLevel struct {
+	price   uint64
+	entries []*orderBookEntryData
+}
+
+func (o *naiveOrderBook) limit(order Order, rest bool) []orderExecution {
+	id := o.nextOrderID
+	o.nextOrderID++
+
+	levels := &o.asks
+	crosses := func(price uint64) bool { return order.Price >= price }
+	if order.SellSide {
+		levels = &o.bids
+		crosses = func(price uint64) bool { return order.Price <= price }
+	}
+
+	var executions []orderExecution
+	for order.Quant > 0 && len(*levels) > 0 && crosses((*levels)[0].price) {
+		l := (*levels)[0]
+		for len(l.entries) > 0 && order.Quant > 0 {
+			e := l.entries[0]
+			quant := e.Quant
+			if quant > order.Quant {
+				quant = order.Quant
+			}
+
+			if quant > 0 {
+				executions = append(executions,
+					orderExecution{Owner: order.Owner, ID: id, SellSide: order.SellSide, Quant: quant, Price: l.price, Taker: true},
+					orderExecution{Owner: e.Owner, ID: e.ID, SellSide: !order.SellSide, Quant: quant, Price: l.price})
+				e.Quant -= quant
+				order.Quant -= quant
+			}
+
+			if e.Quant == 0 {
+				l.entries = l.entries[1:]
+			}
+		}
+
+		if len(l.entries) == 0 {
+			*levels = (*levels)[1:]
+		}
+	}
+
+	if !rest || order.Quant == 0 {
+		return executions
+	}
+
+	levels = &o.bids
+	better := func(price uint64) bool { return price > order.Price }
+	if order.SellSide {
+		levels = &o.asks
+		better = func(price uint64) bool { return price < order.Price }
+	}
+
+	e := &orderBookEntryData{ID: id, Owner: order.Owner, Quant: order.Quant, Priority: id}
+	o.idToEntry[id] = e
+	i := 0
+	for i < len(*levels) && better((*levels)[i].price) {
+		i++
+	}
+
+	if i < len(*levels) && (*levels)[i].price == order.Price {
+		(*levels)[i].entries = append((*levels)[i].entries, e)
+		return executions
+	}
+
+	*levels = append(*levels, nil)
+	copy((*levels)[i+1:], (*levels)[i:])
+	(*levels)[i] = &naiveLevel{price: order.Price, entries: []*orderBookEntryData{e}}
+	return executions
+}
+
+func (o *naiveOrderBook) cancel(id uint64) {
+	if e := o.idToEntry[id]; e != nil {
+		e.Quant = 0
+	}
+}
+
+func naiveFlatten(levels []*naiveLevel) []orderBookPointToMarshal {
+	var r []orderBookPointToMarshal
+	for _, l := range levels {
+		var entries []orderBookEntryData
+		for _, e := range l.entries {
+			if e.Quant > 0 {
+				entries = append(entries, *e)
+			}
+		}
+
+		if len(entries) == 0 {
+			continue
+		}
+
+		r = append(r, orderBookPointToMarshal{Price: l.price, Entries: entries})
+	}
+	return r
+}
+
+func (o *naiveOrderBook) encode() []byte {
+	var buf bytes.Buffer
+	for _, v := range []interface{}{naiveFlatten(o.asks), naiveFlatten(o.bids), o.nextOrderID} {
+		err := rlp.Encode(&buf, v)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// compact drops the cancelled orders and the empty price levels,
+// like the encoding and decoding of the order book does.
+func (o *naiveOrderBook) compact() {
+	compact := func(levels []*naiveLevel) []*naiveLevel {
+		var r []*naiveLevel
+		for _, l := range levels {
+			var entries []*orderBookEntryData
+			for _, e := range l.entries {
+				if e.Quant > 0 {
+					entries = append(entries, e)
+				}
+			}
+
+			if len(entries) > 0 {
+				r = append(r, &naiveLevel{price: l.price, entries: entries})
+			}
+		}
+		return r
+	}
+
+	o.bids = compact(o.bids)
+	o.asks = compact(o.asks)
+}
+
+func naiveDepth(levels []*naiveLevel) []Level {
+	var r []Level
+	for _, l := range levels {
+		level := Level{Price: l.price}
+		for _, e := range l.entries {
+			if e.Quant > 0 {
+				level.Quant += e.Quant
+				level.Orders++
+			}
+		}
+
+		if level.Orders > 0 {
+			r = append(r, level)
+		}
+	}
+	return r
+}
+
+// checkOrderBook checks the invariants of the order book: the price
+// levels are ordered in each lane of the skip lists, only the resting
+// orders are linked in the levels and indexed by their IDs, and the
+// total quantity and the number of orders of each level match its
+// resting orders.
+func checkOrderBook(o *orderBook) error {
+	resting := 0
+	for _, side := range []*bookSide{&o.bids, &o.asks} {
+		levels := make(map[*pricePoint]bool)
+		for p := side.best(); p != nil; p = p.NextPoint {
+			levels[p] = true
+			if p.bid != side.bid {
+				return fmt.Errorf("level %d is on the wrong side", p.Price)
+			}
+
+			var quant uint64
+			var orders int
+			var prev *orderBookEntry
+			for e := p.ListHead; e != nil; e = e.Next {
+				if e.Quant == 0 {
+					return fmt.Errorf("order %d of 0 quant is in level %d", e.ID, p.Price)
+				}
+
+				if e.prev != prev {
+					return fmt.Errorf("order %d is not linked to the order before it", e.ID)
+				}
+				prev = e
+
+				if e.point != p || o.idToEntry[e.ID] != e {
+					return fmt.Errorf("order %d is not indexed", e.ID)
+				}
+
+				if e.Next != nil && e.Next.Priority <= e.Priority {
+					return fmt.Errorf("order %d is after order %d", e.Next.ID, e.ID)
+				}
+
+				if e.Hidden > 0 && (e.Display == 0 || e.Quant > e.Display) {
+					return fmt.Errorf("iceberg order %d displays %d of slice %d", e.ID, e.Quant, e.Display)
+				}
+
+				quant += e.Quant
+				orders++
+			}
+
+			if orders == 0 {
+				return fmt.Errorf("level %d has no orders", p.Price)
+			}
+
+			if p.ListTail != prev {
+				return fmt.Errorf("level %d does not end with its last order", p.Price)
+			}
+
+			if quant != p.quant || orders != p.orders {
+				return fmt.Errorf("level %d has %d orders of %d quant, cached %d orders of %d quant", p.Price, orders, quant, p.orders, p.quant)
+			}
+			resting += orders
+		}
+
+		for lane := 0; lane < skipLanes; lane++ {
+			var prev *pricePoint
+			for p := side.heads[lane]; p != nil; p = *p.next(lane) {
+				if !levels[p] || lane >= p.height() {
+					return fmt.Errorf("level %d is not in lane 0 or lane %d", p.Price, lane)
+				}
+
+				if prev != nil && !side.before(prev.Price, p.Price) {
+					return fmt.Errorf("level %d is after level %d in lane %d", p.Price, prev.Price, lane)
+				}
+				prev = p
+			}
+		}
+	}
+
+	for _, e := range o.idToEntry {
+		if e.Quant > 0 {
+			resting--
+		}
+	}
+
+	if resting != 0 {
+		return errors.New("the resting orders are not all in the price levels")
+	}
+	return nil
+}
+
+func TestOrderBookDifferential(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for round := 0; round < 10; round++ {
+		book := newOrderBook()
+		naive := &naiveOrderBook{idToEntry: make(map[uint64]*orderBookEntryData)}
+		for i := 0; i < 1000; i++ {
+			order := Order{
+				Owner:    consensus.Addr{byte(r.Intn(4))},
+				SellSide: r.Intn(2) == 0,
+				Quant:    uint64(1 + r.Intn(50)),
+				Price:    uint64(1000 + r.Intn(300)),
+			}
+
+			var executions []orderExecution
+			rest := r.Intn(10) > 0
+			if rest {
+				_, executions = book.Limit(order)
+			} else {
+				_, executions = book.ImmediateOrCancel(order)
+			}
+			if !assert.Equal(t, naive.limit(order, rest), executions) {
+				return
+			}
+
+			if r.Intn(5) == 0 {
+				id := uint64(r.Intn(i + 1))
+				book.Cancel(id)
+				naive.cancel(id)
+			}
+
+			if !assert.Nil(t, checkOrderBook(book)) {
+				return
+			}
+
+			bids, asks := book.Depth(Bid, 0), book.Depth(Ask, 0)
+			if !assert.Equal(t, naiveDepth(naive.bids), bids) || !assert.Equal(t, naiveDepth(naive.asks), asks) {
+				return
+			}
+
+			if i%20 != 0 {
+				continue
+			}
+
+			b, err := rlp.EncodeToBytes(book)
+			assert.Nil(t, err)
+			if !assert.Equal(t, naive.encode(), b) {
+				return
+			}
+
+			// a block is serialized and deserialized from
+			// time to time
+			if r.Intn(5) == 0 {
+				book = &orderBook{}
+				err = rlp.DecodeBytes(b, book)
+				assert.Nil(t, err)
+				naive.compact()
+			}
+		}
+	}
+}
+
+func TestOrderBookStopOrderPriority(t *testing.T) {
+	book := newOrderBook()
+	stop := book.NewID()
+	book.Limit(Order{SellSide: true, Quant: 1, Price: 10})
+	book.LimitWithID(stop, Order{SellSide: true, Quant: 1, Price: 10})
+
+	// the stop order is ahead of the order placed after it
+	_, executions := book.Limit(Order{Quant: 2, Price: 10})
+	assert.Equal(t, 4, len(executions))
+	assert.Equal(t, stop, executions[1].ID)
+	assert.Equal(t, stop+1, executions[3].ID)
+}
+
+// shuffledBook returns an empty order book whose internal state
+// differs from newOrderBook's.
+func shuffledBook(r *rand.Rand) *orderBook {
+	book := newOrderBook()
+	book.bids.seed = r.Uint64() | 1
+	book.asks.seed = r.Uint64() | 1
+	return book
+}
+
+func TestOrderBookDeterminism(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	books := []*orderBook{newOrderBook(), shuffledBook(r)}
+	type stop struct {
+		id    uint64
+		order Order
+	}
+	var stops []stop
+	for i := 0; i < 5000; i++ {
+		order := Order{
+			Owner:    consensus.Addr{byte(r.Intn(4))},
+			SellSide: r.Intn(2) == 0,
+			Quant:    uint64(1 + r.Intn(50)),
+			Price:    uint64(1000 + r.Intn(100)),
+		}
+
+		if r.Intn(5) == 0 {
+			order.Display = uint64(1 + r.Intn(10))
+		}
+
+		var logs [2][]interface{}
+		op := r.Intn(10)
+		cancel := r.Intn(i + 1)
+		trigger := -1
+		if op == 8 && len(stops) > 0 && r.Intn(2) == 0 {
+			trigger = r.Intn(len(stops))
+		}
+
+		for k, book := range books {
+			switch {
+			case op < 6:
+				id, e := book.Limit(order)
+				logs[k] = append(logs[k], id, e)
+			case op == 6:
+				id, e := book.ImmediateOrCancel(order)
+				logs[k] = append(logs[k], id, e)
+			case op == 7:
+				e, remaining := book.Match(order)
+				logs[k] = append(logs[k], e, remaining)
+			case op == 8 && trigger >= 0:
+				s := stops[trigger]
+				logs[k] = append(logs[k], book.LimitWithID(s.id, s.order))
+			case op == 8:
+				logs[k] = append(logs[k], book.NewID())
+			default:
+				order, ok := book.Cancel(uint64(cancel))
+				logs[k] = append(logs[k], order, ok)
+			}
+		}
+
+		if !assert.Equal(t, logs[0], logs[1]) {
+			return
+		}
+
+		for _, book := range books {
+			if !assert.Nil(t, checkOrderBook(book)) {
+				return
+			}
+		}
+
+		if trigger >= 0 {
+			stops = append(stops[:trigger], stops[trigger+1:]...)
+		} else if op == 8 {
+			stops = append(stops, stop{id: logs[0][0].(uint64), order: order})
+		}
+
+		if i%50 != 0 {
+			continue
+		}
+
+		var encoded [2][]byte
+		for k, book := range books {
+			b, err := rlp.EncodeToBytes(book)
+			assert.Nil(t, err)
+			encoded[k] = b
+
+			// the encoding is canonical
+			var decoded orderBook
+			err = rlp.DecodeBytes(b, &decoded)
+			assert.Nil(t, err)
+			b1, err := rlp.EncodeToBytes(&decoded)
+			assert.Nil(t, err)
+			assert.Equal(t, b, b1)
+		}
+		if !assert.Equal(t, encoded[0], encoded[1]) {
+			return
+		}
+
+		snapshot, err := books[1].Snapshot()
+		assert.Nil(t, err)
+		s0, err := books[0].Snapshot()
+		assert.Nil(t, err)
+		assert.Equal(t, s0, snapshot)
+
+		// the books are rebuilt from time to time
+		switch r.Intn(4) {
+		case 0:
+			restored, err := restoreOrderBook(snapshot)
+			assert.Nil(t, err)
+			restored.bids.seed = r.Uint64() | 1
+			books[1] = restored
+		case 1:
+			var decoded orderBook
+			err = rlp.DecodeBytes(encoded[0], &decoded)
+			assert.Nil(t, err)
+			books[0] = &decoded
+		}
+	}
+}
+
+// makerFills returns the IDs and the quantities of the makers of the
+// executions.
+func makerFills(executions []orderExecution) [][2]uint64 {
+	var r [][2]uint64
+	for _, e := range executions {
+		if !e.Taker {
+			r = append(r, [2]uint64{e.ID, e.Quant})
+		}
+	}
+	return r
+}
+
+func TestOrderBookIceberg(t *testing.T) {
+	book := newOrderBook()
+	iceberg, _ := book.Limit(Order{SellSide: true, Quant: 10, Price: 10, Display: 4})
+	other, _ := book.Limit(Order{SellSide: true, Quant: 5, Price: 10})
+	asks := book.Depth(Ask, 0)
+	assert.Equal(t, []Level{{Price: 10, Quant: 9, Orders: 2}}, asks)
+	assert.Equal(t, uint64(15), book.Matchable(Order{Quant: 20, Price: 10}))
+
+	// the refreshed slice is behind the other order
+	_, executions := book.Limit(Order{Quant: 6, Price: 10})
+	assert.Equal(t, [][2]uint64{{iceberg, 4}, {other, 2}}, makerFills(executions))
+	asks = book.Depth(Ask, 0)
+	assert.Equal(t, []Level{{Price: 10, Quant: 7, Orders: 2}}, asks)
+
+	_, executions = book.Limit(Order{Quant: 5, Price: 10})
+	assert.Equal(t, [][2]uint64{{other, 3}, {iceberg, 2}}, makerFills(executions))
+	asks = book.Depth(Ask, 0)
+	assert.Equal(t, []Level{{Price: 10, Quant: 2, Orders: 1}}, asks)
+
+	// the later order is behind the displayed slice, and ahead
+	// of the next one
+	late, _ := book.Limit(Order{SellSide: true, Quant: 1, Price: 10})
+	_, executions = book.Limit(Order{Quant: 4, Price: 10})
+	assert.Equal(t, [][2]uint64{{iceberg, 2}, {late, 1}, {iceberg, 1}}, makerFills(executions))
+	asks = book.Depth(Ask, 0)
+	assert.Equal(t, []Level{{Price: 10, Quant: 1, Orders: 1}}, asks)
+	assert.Nil(t, checkOrderBook(book))
+
+	// the hidden quantity is cancelled with the displayed slice
+	iceberg, _ = book.Limit(Order{SellSide: true, Quant: 10, Price: 11, Display: 2})
+	book.Cancel(iceberg)
+	asks = book.Depth(Ask, 0)
+	assert.Equal(t, []Level{{Price: 10, Quant: 1, Orders: 1}}, asks)
+	assert.Equal(t, uint64(1), book.Matchable(Order{Quant: 5, Price: 11}))
+	_, executions = book.ImmediateOrCancel(Order{Quant: 5, Price: 11})
+	assert.Equal(t, 2, len(executions))
+	assert.Nil(t, checkOrderBook(book))
+
+	// the slices survive the encoding
+	iceberg, _ = book.Limit(Order{SellSide: true, Quant: 10, Price: 11, Display: 3})
+	b, err := rlp.EncodeToBytes(book)
+	assert.Nil(t, err)
+	var decoded orderBook
+	err = rlp.DecodeBytes(b, &decoded)
+	assert.Nil(t, err)
+	_, executions = decoded.Limit(Order{Quant: 10, Price: 11})
+	assert.Equal(t, [][2]uint64{{iceberg, 3}, {iceberg, 3}, {iceberg, 3}, {iceberg, 1}}, makerFills(executions))
+	assert.Nil(t, decoded.asks.best())
 }
@@ -0,0 +1,165 @@
+package dex
+
+import (
+	"fmt"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// defaultClosedOrders is the default number of the recently closed
+// orders remembered by the Order RPC.
+const defaultClosedOrders = 65536
+
+// OrderStatus is the status of an order.
+type OrderStatus int
+
+const (
+	// OrderOpen means the order rests on the order book without
+	// any fill, or is an untriggered stop order.
+	OrderOpen OrderStatus = iota
+	// OrderPartiallyFilled means the order rests on the order
+	// book with a part of it filled.
+	OrderPartiallyFilled
+	// OrderFilled means the order is fully filled.
+	OrderFilled
+	// OrderCancelled means the order is cancelled by the owner,
+	// or its unfilled part is cancelled since it is an
+	// immediate-or-cancel order.
+	OrderCancelled
+	// OrderExpired means the order is expired at its expire
+	// round.
+	OrderExpired
+)
+
+func (s OrderStatus) String() string {
+	switch s {
+	case OrderOpen:
+		return "open"
+	case OrderPartiallyFilled:
+		return "partially filled"
+	case OrderFilled:
+		return "filled"
+	case OrderCancelled:
+		return "cancelled"
+	case OrderExpired:
+		return "expired"
+	default:
+		return fmt.Sprintf("unknown status %d", int(s))
+	}
+}
+
+// OrderRequest is the argument of the Order RPC.
+type OrderRequest struct {
+	Market MarketSymbol
+	// the ID of the order in the market
+	ID uint64
+}
+
+// OrderResult is the status of an order.
+type OrderResult struct {
+	Status OrderStatus
+	// the order, Executed is the filled quantity
+	Order PendingOrder
+	// the unfilled quantity that rests on the order book, 0 once
+	// the order is closed.
+	Remaining uint64
+	// the round of the state the open order is read from, or the
+	// round that the order is closed in.
+	Round uint64
+}
+
+// unknownOrderError is returned when the order is not open, and is
+// not a recently closed order.
+type unknownOrderError OrderID
+
+func (e unknownOrderError) Error() string {
+	id := OrderID(e)
+	return fmt.Sprintf("order %s does not exist", id.Encode())
+}
+
+type closedOrder struct {
+	round uint64
+	event ClosedOrderEvent
+}
+
+// closedOrderIndex remembers the recently closed orders, the least
+// recently closed order is forgotten first.
+type closedOrderIndex struct {
+	orders *lru.Cache
+}
+
+func newClosedOrderIndex(size int) *closedOrderIndex {
+	orders, err := lru.New(size)
+	if err != nil {
+		panic(err)
+	}
+
+	return &closedOrderIndex{orders: orders}
+}
+
+func (c *closedOrderIndex) add(round uint64, events []ClosedOrderEvent) {
+	for _, e := range events {
+		c.orders.Add(e.Order.ID, closedOrder{round: round, event: e})
+	}
+}
+
+func (c *closedOrderIndex) get(id OrderID) (closedOrder, bool) {
+	v, ok := c.orders.Get(id)
+	if !ok {
+		return closedOrder{}, false
+	}
+	return v.(closedOrder), true
+}
+
+// openOrderResult returns the status of the order read from the
+// state, or false if the order is not open in the state.
+func openOrderResult(s *State, id OrderID) (OrderResult, bool) {
+	o, ok := s.OpenOrder(id)
+	if !ok {
+		return OrderResult{}, false
+	}
+
+	status := OrderOpen
+	if o.Executed > 0 {
+		status = OrderPartiallyFilled
+	}
+	return OrderResult{Status: status, Order: o, Remaining: o.Quant - o.Executed, Round: s.round}, true
+}
+
+// order returns the status of the order. The open orders are read
+// from the leader state, the order closed in the leader state but
+// not yet in a finalized state is read from the last finalized
+// state.
+func (r *RPCServer) order(req OrderRequest, result *OrderResult) error {
+	if !req.Market.Valid() {
+		return newRPCError(CodeInvalidRequest, "invalid market %v", req.Market)
+	}
+
+	id := OrderID{ID: req.ID, Market: req.Market}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.state(false)
+	if err != nil {
+		return err
+	}
+
+	if o, ok := openOrderResult(s, id); ok {
+		*result = o
+		return nil
+	}
+
+	if c, ok := r.closed.get(id); ok {
+		*result = OrderResult{Status: c.event.Status, Order: c.event.Order, Round: c.round}
+		return nil
+	}
+
+	if r.finalized != nil {
+		if o, ok := openOrderResult(r.finalized, id); ok {
+			*result = o
+			return nil
+		}
+	}
+
+	return unknownOrderError(id)
+}
@@ -0,0 +1,72 @@
+package dex
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderStatus(t *testing.T) {
+	s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+	market := MarketSymbol{Base: 0, Quote: 1}
+	req := OrderRequest{Market: market, ID: 0}
+	r := NewRPCServer()
+
+	var result OrderResult
+	assert.Equal(t, ErrNotReady, r.order(req, &result))
+
+	commit := func(round uint64, pk PK, txn []byte) {
+		trans := s.Transition(round, nil)
+		recordTxn(t, trans, pk, txn)
+		s = trans.Commit().(*State)
+		r.Update(s)
+		r.Finalized(round, s)
+	}
+
+	// open
+	commit(1, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market}, 0))
+	assert.Nil(t, r.order(req, &result))
+	assert.Equal(t, OrderOpen, result.Status)
+	assert.Equal(t, pkMaker.Addr(), result.Order.Owner)
+	assert.Equal(t, uint64(10), result.Remaining)
+	assert.Equal(t, uint64(0), result.Order.Executed)
+	assert.Equal(t, uint64(1), result.Round)
+
+	// partially filled by the taker, whose order is filled
+	commit(2, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 4, Price: 100000000, Market: market}, 0))
+	assert.Nil(t, r.order(req, &result))
+	assert.Equal(t, OrderPartiallyFilled, result.Status)
+	assert.Equal(t, uint64(4), result.Order.Executed)
+	assert.Equal(t, uint64(6), result.Remaining)
+
+	var taker OrderResult
+	assert.Nil(t, r.order(OrderRequest{Market: market, ID: 1}, &taker))
+	assert.Equal(t, OrderFilled, taker.Status)
+	assert.Equal(t, pkTaker.Addr(), taker.Order.Owner)
+	assert.Equal(t, uint64(4), taker.Order.Executed)
+	assert.Equal(t, uint64(2), taker.Round)
+
+	// cancelled
+	commit(3, pkMaker, MakeCancelOrderTxn(skMaker, testChainID, pkMaker.Addr(), OrderID{ID: 0, Market: market}, 1))
+	assert.Nil(t, r.order(req, &result))
+	assert.Equal(t, OrderCancelled, result.Status)
+	assert.Equal(t, uint64(4), result.Order.Executed)
+	assert.Equal(t, uint64(0), result.Remaining)
+	assert.Equal(t, uint64(3), result.Round)
+
+	unknown := OrderRequest{Market: market, ID: 99}
+	assert.Equal(t, unknownOrderError(OrderID{ID: 99, Market: market}), r.order(unknown, &result))
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := postJSONRPC(t, srv.URL+DefaultJSONRPCPath, "Order", map[string]interface{}{"base": "0", "quote": "1", "id": 0})
+	assert.Nil(t, resp.Error)
+	var v struct{ Status OrderStatus }
+	assert.Nil(t, json.Unmarshal(resp.Result, &v))
+	assert.Equal(t, OrderCancelled, v.Status)
+}
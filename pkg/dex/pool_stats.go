@@ -0,0 +1,227 @@
+package dex
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// the number of the latest included txns whose time to
+	// inclusion is kept for the percentiles.
+	inclusionSamples = 1024
+	// the admission rate is averaged over the last
+	// admissionWindowSecs seconds.
+	admissionWindowSecs = 60
+)
+
+// RejectReason is the reason that the pool rejects a txn.
+type RejectReason int
+
+const (
+	// RejectInvalid means the txn is malformed, or invalid
+	// against the leader state.
+	RejectInvalid RejectReason = iota
+	// RejectBadSig means the signature verification failed.
+	RejectBadSig
+	// RejectDuplicate means the txn is already seen.
+	RejectDuplicate
+	// RejectOversized means the txn exceeds the max txn size or
+	// the pool size.
+	RejectOversized
+	// RejectExpired means the order txn is already expired.
+	RejectExpired
+	// RejectUnknownOwner means the owner is not on chain.
+	RejectUnknownOwner
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectInvalid:
+		return "invalid"
+	case RejectBadSig:
+		return "bad signature"
+	case RejectDuplicate:
+		return "duplicate"
+	case RejectOversized:
+		return "oversized"
+	case RejectExpired:
+		return "expired"
+	case RejectUnknownOwner:
+		return "unknown owner"
+	default:
+		return "unknown reason"
+	}
+}
+
+// badSigError is returned when the txn signature verification
+// fails.
+type badSigError string
+
+func (e badSigError) Error() string {
+	return string(e)
+}
+
+// PoolStats is a snapshot of the txn pool metrics.
+//
+// Count and Bytes are the current size of the pool. The counters
+// (Admitted, Replaced, Rejected, Evicted and Expired) accumulate
+// since the pool is created and are never reset. AdmissionRate is
+// the number of txns admitted per second, averaged over the last
+// minute. The inclusion percentiles are measured from the admission
+// to the pool to the first block including the txn, over the latest
+// 1024 included txns that are admitted by this pool.
+type PoolStats struct {
+	Count    int
+	Bytes    int
+	Admitted uint64
+	// the admitted txns that replace a pending txn of the same
+	// nonce, they are also counted in Admitted.
+	Replaced uint64
+	// the rejected txns by RejectReason.String()
+	Rejected map[string]uint64
+	// the txns evicted from the full pool
+	Evicted uint64
+	// the pending order txns dropped since they are expired
+	Expired       uint64
+	AdmissionRate float64
+	InclusionP50  time.Duration
+	InclusionP90  time.Duration
+	InclusionP99  time.Duration
+}
+
+type poolStats struct {
+	// the admission time of the pending txns
+	admittedAt *lru.Cache
+
+	mu       sync.Mutex
+	admitted uint64
+	replaced uint64
+	rejected map[RejectReason]uint64
+	evicted  uint64
+	expired  uint64
+	// the admissions of each second in the window, indexed by
+	// the unix second modulo the window size.
+	perSec    [admissionWindowSecs]uint64
+	perSecAt  [admissionWindowSecs]int64
+	latencies []time.Duration
+	next      int
+}
+
+func newPoolStats() *poolStats {
+	admittedAt, err := lru.New(defaultMaxPoolTxns)
+	if err != nil {
+		panic(err)
+	}
+
+	return &poolStats{
+		admittedAt: admittedAt,
+		rejected:   make(map[RejectReason]uint64),
+	}
+}
+
+func (s *poolStats) admit(hash consensus.Hash, replaced bool, now time.Time) {
+	s.admittedAt.Add(hash, now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.admitted++
+	if replaced {
+		s.replaced++
+	}
+
+	sec := now.Unix()
+	i := sec % admissionWindowSecs
+	if s.perSecAt[i] != sec {
+		s.perSecAt[i] = sec
+		s.perSec[i] = 0
+	}
+	s.perSec[i]++
+}
+
+func (s *poolStats) reject(r RejectReason) {
+	s.mu.Lock()
+	s.rejected[r]++
+	s.mu.Unlock()
+}
+
+func (s *poolStats) evict(n int) {
+	s.mu.Lock()
+	s.evicted += uint64(n)
+	s.mu.Unlock()
+}
+
+func (s *poolStats) expire(n int) {
+	s.mu.Lock()
+	s.expired += uint64(n)
+	s.mu.Unlock()
+}
+
+// include records the time to inclusion of the txn, it is only
+// recorded for the first block that includes the txn.
+func (s *poolStats) include(hash consensus.Hash, now time.Time) {
+	v, ok := s.admittedAt.Get(hash)
+	if !ok {
+		return
+	}
+	s.admittedAt.Remove(hash)
+
+	d := now.Sub(v.(time.Time))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.latencies) < inclusionSamples {
+		s.latencies = append(s.latencies, d)
+		return
+	}
+
+	s.latencies[s.next] = d
+	s.next = (s.next + 1) % inclusionSamples
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+func (s *poolStats) snapshot(now time.Time) PoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := PoolStats{
+		Admitted: s.admitted,
+		Replaced: s.replaced,
+		Rejected: make(map[string]uint64, len(s.rejected)),
+		Evicted:  s.evicted,
+		Expired:  s.expired,
+	}
+
+	for reason, n := range s.rejected {
+		r.Rejected[reason.String()] = n
+	}
+
+	var admitted uint64
+	sec := now.Unix()
+	for i, at := range s.perSecAt {
+		if at > sec-admissionWindowSecs && at <= sec {
+			admitted += s.perSec[i]
+		}
+	}
+	r.AdmissionRate = float64(admitted) / admissionWindowSecs
+
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	r.InclusionP50 = percentile(sorted, 50)
+	r.InclusionP90 = percentile(sorted, 90)
+	r.InclusionP99 = percentile(sorted, 99)
+	return r
+}
@@ -0,0 +1,157 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// proofList collects the trie nodes of a Merkle proof.
+type proofList [][]byte
+
+func (p *proofList) Put(key []byte, value []byte) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// proofReader serves the nodes of a Merkle proof by their hashes,
+// so that the proof can be verified without a state.
+type proofReader map[string][]byte
+
+func newProofReader(proof [][]byte) proofReader {
+	r := make(proofReader, len(proof))
+	for _, n := range proof {
+		r[string(crypto.Keccak256(n))] = n
+	}
+	return r
+}
+
+func (p proofReader) Get(key []byte) ([]byte, error) {
+	n, ok := p[string(key)]
+	if !ok {
+		return nil, errors.New("proof node not found")
+	}
+	return n, nil
+}
+
+func (p proofReader) Has(key []byte) (bool, error) {
+	_, ok := p[string(key)]
+	return ok, nil
+}
+
+// prove returns the Merkle proof of the key and the state root that
+// it is anchored at, both are read under the same lock.
+func (s *State) prove(key []byte) (consensus.Hash, [][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var proof proofList
+	err := s.trie.Prove(key, 0, &proof)
+	if err != nil {
+		return consensus.Hash{}, nil, err
+	}
+
+	return consensus.Hash(s.trie.Hash()), proof, nil
+}
+
+func verifyProof(root consensus.Hash, key []byte, proof [][]byte) ([]byte, error) {
+	v, _, err := trie.VerifyProof(common.Hash(root), key, newProofReader(proof))
+	return v, err
+}
+
+// ProveBalance returns the Merkle proof of the account's balance of
+// the token anchored at the state root. The balances of an account
+// are stored in a single trie entry, so the proof covers every token
+// of the account, including the tokens the account does not have.
+func (s *State) ProveBalance(addr consensus.Addr, token TokenID) (proof [][]byte, err error) {
+	_, proof, err = s.balanceProof(addr, token)
+	return
+}
+
+func (s *State) balanceProof(addr consensus.Addr, token TokenID) (consensus.Hash, [][]byte, error) {
+	if _, ok := s.Token(token); !ok {
+		return consensus.Hash{}, nil, unknownTokenError(fmt.Sprint(token))
+	}
+
+	return s.prove(addrBalancePath(addr))
+}
+
+// ProveOrder returns the Merkle proof of the market's order book
+// anchored at the state root. The order book of a market is stored
+// in a single trie entry, so the proof covers every resting order of
+// the market. When the order does not rest on the order book the
+// proof proves its absence.
+func (s *State) ProveOrder(m MarketSymbol, id OrderID) (proof [][]byte, err error) {
+	_, proof, err = s.orderProof(m, id)
+	return
+}
+
+func (s *State) orderProof(m MarketSymbol, id OrderID) (consensus.Hash, [][]byte, error) {
+	if id.Market != m {
+		return consensus.Hash{}, nil, fmt.Errorf("order %v is not in market %v", id, m)
+	}
+
+	return s.prove(marketPath(m.Encode()))
+}
+
+// VerifyBalanceProof verifies the proof returned by ProveBalance
+// against the state root, and returns the proven balance of the
+// token. A zero balance is returned if the account does not have the
+// token.
+func VerifyBalanceProof(root consensus.Hash, addr consensus.Addr, token TokenID, proof [][]byte) (Balance, error) {
+	b, err := verifyProof(root, addrBalancePath(addr), proof)
+	if err != nil {
+		return Balance{}, err
+	}
+
+	if len(b) == 0 {
+		return Balance{}, nil
+	}
+
+	var v balanceIDs
+	err = rlp.DecodeBytes(b, &v)
+	if err != nil {
+		return Balance{}, err
+	}
+
+	for i, id := range v.I {
+		if id == token {
+			return v.B[i], nil
+		}
+	}
+
+	return Balance{}, nil
+}
+
+// VerifyOrderProof verifies the proof returned by ProveOrder against
+// the state root. It returns the unfilled part of the proven resting
+// order, or false if the proof proves the order does not rest on the
+// order book.
+func VerifyOrderProof(root consensus.Hash, m MarketSymbol, id OrderID, proof [][]byte) (Order, bool, error) {
+	if id.Market != m {
+		return Order{}, false, fmt.Errorf("order %v is not in market %v", id, m)
+	}
+
+	b, err := verifyProof(root, marketPath(m.Encode()), proof)
+	if err != nil {
+		return Order{}, false, err
+	}
+
+	if len(b) == 0 {
+		return Order{}, false, nil
+	}
+
+	var book orderBook
+	err = rlp.DecodeBytes(b, &book)
+	if err != nil {
+		return Order{}, false, err
+	}
+
+	order, ok := book.Resting(id.ID)
+	return order, ok, nil
+}
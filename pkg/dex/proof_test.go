@@ -0,0 +1,128 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newProofTestState() (*State, PK, OrderID) {
+	s, pk, sk, _, _ := newTIFTestState()
+	market := MarketSymbol{Base: 0, Quote: 1}
+	trans := s.Transition(1, nil)
+	pt, err := parseTxn(MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market}, 0), &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}})
+	if err != nil {
+		panic(err)
+	}
+
+	err = trans.Record(pt)
+	if err != nil {
+		panic(err)
+	}
+
+	s = trans.Commit().(*State)
+	return s, pk, s.Account(pk.Addr()).PendingOrders()[0].ID
+}
+
+func TestBalanceProof(t *testing.T) {
+	s, pk, _ := newProofTestState()
+	root := s.Hash()
+	proof, err := s.ProveBalance(pk.Addr(), 0)
+	assert.Nil(t, err)
+
+	b, err := VerifyBalanceProof(root, pk.Addr(), 0, proof)
+	assert.Nil(t, err)
+	assert.Equal(t, 90, int(b.Available))
+	assert.Equal(t, 10, int(b.Pending))
+
+	// exclusion of a token the account does not have
+	b, err = VerifyBalanceProof(root, pk.Addr(), 5, proof)
+	assert.Nil(t, err)
+	assert.True(t, b.Empty())
+
+	// exclusion of an account that does not exist
+	other, _ := RandKeyPair()
+	proof, err = s.ProveBalance(other.Addr(), 0)
+	assert.Nil(t, err)
+	b, err = VerifyBalanceProof(root, other.Addr(), 0, proof)
+	assert.Nil(t, err)
+	assert.True(t, b.Empty())
+
+	_, err = s.ProveBalance(pk.Addr(), 5)
+	assert.NotNil(t, err)
+}
+
+func TestOrderProof(t *testing.T) {
+	s, pk, id := newProofTestState()
+	root := s.Hash()
+	proof, err := s.ProveOrder(id.Market, id)
+	assert.Nil(t, err)
+
+	order, ok, err := VerifyOrderProof(root, id.Market, id, proof)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Order{Owner: pk.Addr(), SellSide: true, Quant: 10, Price: 100000000}, order)
+
+	missing := id
+	missing.ID++
+	proof, err = s.ProveOrder(missing.Market, missing)
+	assert.Nil(t, err)
+	_, ok, err = VerifyOrderProof(root, missing.Market, missing, proof)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// exclusion of a market without an order book
+	other := OrderID{ID: id.ID, Market: MarketSymbol{Base: 1, Quote: 0}}
+	proof, err = s.ProveOrder(other.Market, other)
+	assert.Nil(t, err)
+	_, ok, err = VerifyOrderProof(root, other.Market, other, proof)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = s.ProveOrder(other.Market, id)
+	assert.NotNil(t, err)
+}
+
+func TestTamperedProof(t *testing.T) {
+	s, _, id := newProofTestState()
+	root := s.Hash()
+	proof, err := s.ProveOrder(id.Market, id)
+	assert.Nil(t, err)
+
+	tampered := make([][]byte, len(proof))
+	for i := range proof {
+		tampered[i] = append([]byte(nil), proof[i]...)
+	}
+	last := tampered[len(tampered)-1]
+	last[len(last)-1] ^= 1
+
+	_, _, err = VerifyOrderProof(root, id.Market, id, tampered)
+	assert.NotNil(t, err)
+
+	// the proof does not verify against a different root
+	var otherRoot consensus.Hash
+	otherRoot[0] = 1
+	_, _, err = VerifyOrderProof(otherRoot, id.Market, id, proof)
+	assert.NotNil(t, err)
+}
+
+func TestProofRPC(t *testing.T) {
+	s, pk, id := newProofTestState()
+	r := NewRPCServer()
+	var p Proof
+	assert.Equal(t, ErrNotReady, r.proveOrder(OrderProofArg{ID: id}, &p))
+
+	r.Update(s)
+	assert.Nil(t, r.proveOrder(OrderProofArg{ID: id}, &p))
+	assert.Equal(t, s.Hash(), p.Root)
+	_, ok, err := VerifyOrderProof(p.Root, id.Market, id, p.Nodes)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	assert.Nil(t, r.proveBalance(BalanceProofArg{Addr: pk.Addr(), Token: 0}, &p))
+	assert.Equal(t, s.Hash(), p.Root)
+	b, err := VerifyBalanceProof(p.Root, pk.Addr(), 0, p.Nodes)
+	assert.Nil(t, err)
+	assert.Equal(t, 90, int(b.Available))
+}
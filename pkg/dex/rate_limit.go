@@ -0,0 +1,220 @@
+package dex
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a client exceeds its rate limit,
+// the client should retry later. The net/rpc clients receive it as
+// an rpc.ServerError, use IsRateLimited to check for it.
+var ErrRateLimited = &RPCError{Code: CodeRateLimited, Message: "rate limited, retry later"}
+
+// IsRateLimited returns true if the error returned by a wallet
+// service call is ErrRateLimited.
+func IsRateLimited(err error) bool {
+	return err != nil && err.Error() == ErrRateLimited.Error()
+}
+
+// rateLimitSweepInterval is how often the clients whose buckets
+// are refilled are forgotten.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit is a token bucket limit: a client can make Burst
+// requests at once, and Rate requests per second after that. Rate 0
+// means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig is the rate limit of the RPC server's clients,
+// the clients are identified by their IP address.
+type RateLimitConfig struct {
+	// the limit of the SendTxn, SendTxnV2 and SendTxnWait calls
+	SendTxn RateLimit
+	// the limit of the RawBlock calls, whose responses are large
+	RawBlock RateLimit
+	// the limit of the BalanceAt calls, which replay the blocks
+	// after the retained state snapshot
+	Archive RateLimit
+	// the limit of the other calls, the REST requests and the
+	// WebSocket handshakes
+	Read RateLimit
+	// the clients connecting from the loopback address are not
+	// limited if ExemptLocalhost is true
+	ExemptLocalhost bool
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	b.refill(limit, now)
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// limitClass is the class of the calls sharing a bucket.
+type limitClass int
+
+const (
+	readLimit limitClass = iota
+	sendTxnLimit
+	rawBlockLimit
+	archiveLimit
+	numLimitClasses
+)
+
+// methodLimitClass returns the limit class of the WalletService
+// method, the method can be prefixed by the service name.
+func methodLimitClass(method string) limitClass {
+	if isMutatingMethod(method) {
+		return sendTxnLimit
+	}
+
+	switch strings.TrimPrefix(method, "WalletService.") {
+	case "RawBlock":
+		return rawBlockLimit
+	case "BalanceAt":
+		return archiveLimit
+	}
+	return readLimit
+}
+
+type clientBuckets [numLimitClasses]tokenBucket
+
+type rateLimiter struct {
+	cfg RateLimitConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*clientBuckets
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	for _, l := range []*RateLimit{&cfg.Read, &cfg.SendTxn, &cfg.RawBlock, &cfg.Archive} {
+		if l.Rate > 0 && l.Burst < 1 {
+			l.Burst = 1
+		}
+	}
+
+	return &rateLimiter{
+		cfg:       cfg,
+		now:       time.Now,
+		clients:   make(map[string]*clientBuckets),
+		lastSweep: time.Now(),
+	}
+}
+
+// client returns the key of the client of the remote address, empty
+// if the client is exempted.
+func (l *rateLimiter) client(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	if l.cfg.ExemptLocalhost {
+		ip := net.ParseIP(host)
+		if ip != nil && ip.IsLoopback() {
+			return ""
+		}
+	}
+
+	return host
+}
+
+// limit returns the limit of the class.
+func (l *rateLimiter) limit(class limitClass) RateLimit {
+	switch class {
+	case sendTxnLimit:
+		return l.cfg.SendTxn
+	case rawBlockLimit:
+		return l.cfg.RawBlock
+	case archiveLimit:
+		return l.cfg.Archive
+	default:
+		return l.cfg.Read
+	}
+}
+
+// allow takes a token from the client's bucket of the class, it
+// returns false if the bucket is empty. It is safe to call on a nil
+// limiter.
+func (l *rateLimiter) allow(client string, class limitClass) bool {
+	if l == nil || client == "" {
+		return true
+	}
+
+	limit := l.limit(class)
+	if limit.Rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientBuckets{}
+		for i := range c {
+			c[i] = tokenBucket{tokens: float64(l.limit(limitClass(i)).Burst), last: now}
+		}
+		l.clients[client] = c
+	}
+
+	return c[class].take(limit, now)
+}
+
+// sweep forgets the clients whose buckets are full, they are the
+// same as the new clients.
+func (l *rateLimiter) sweep(now time.Time) {
+	for k, c := range l.clients {
+		full := true
+		for i := range c {
+			limit := l.limit(limitClass(i))
+			c[i].refill(limit, now)
+			if c[i].tokens < float64(limit.Burst) {
+				full = false
+			}
+		}
+
+		if full {
+			delete(l.clients, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// check is the callCheck of the limiter.
+func (l *rateLimiter) check(req *http.Request, method string) error {
+	if !l.allow(l.client(req.RemoteAddr), methodLimitClass(method)) {
+		return ErrRateLimited
+	}
+	return nil
+}
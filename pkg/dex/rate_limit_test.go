@@ -0,0 +1,130 @@
+package dex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+	r.SetRateLimit(RateLimitConfig{
+		Read:    RateLimit{Rate: 2, Burst: 3},
+		SendTxn: RateLimit{Rate: 1, Burst: 1},
+	})
+	now := time.Now()
+	r.limiter.now = func() time.Time { return now }
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	client, err := rpc.DialHTTP("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	ok := 0
+	for i := 0; i < 10; i++ {
+		var tokens TokenState
+		err := client.Call("WalletService.Tokens", 0, &tokens)
+		if err == nil {
+			ok++
+			continue
+		}
+		assert.True(t, IsRateLimited(err))
+	}
+	assert.Equal(t, 3, ok)
+
+	// the SendTxn calls have their own bucket
+	var res SendTxnResult
+	assert.Nil(t, client.Call("WalletService.SendTxnV2", MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0), &res))
+	assert.True(t, res.Accepted)
+	err = client.Call("WalletService.SendTxnV2", MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 1), &res)
+	assert.True(t, IsRateLimited(err))
+
+	// the JSON-RPC and the REST requests share the read bucket
+	resp := postJSONRPC(t, srv.URL+DefaultJSONRPCPath, "Tokens", nil)
+	assert.Equal(t, JSONRPCRateLimited, resp.Error.Code)
+	assert.Equal(t, CodeRateLimited, resp.Error.Data.Code)
+	var e restErrorResponse
+	getREST(t, srv.URL+"/tokens", http.StatusTooManyRequests, &e)
+	assert.Equal(t, ErrRateLimited.Message, e.Error)
+	assert.Equal(t, CodeRateLimited, e.Code)
+
+	// the buckets are refilled over time
+	now = now.Add(time.Second)
+	ok = 0
+	for i := 0; i < 10; i++ {
+		var tokens TokenState
+		if client.Call("WalletService.Tokens", 0, &tokens) == nil {
+			ok++
+		}
+	}
+	assert.Equal(t, 2, ok)
+
+	now = now.Add(time.Minute)
+	resp = postJSONRPC(t, srv.URL+DefaultJSONRPCPath, "Tokens", nil)
+	assert.Nil(t, resp.Error)
+	var tokens struct{ Tokens []json.RawMessage }
+	assert.Nil(t, json.Unmarshal(resp.Result, &tokens))
+	assert.Equal(t, 2, len(tokens.Tokens))
+	assert.Nil(t, client.Call("WalletService.SendTxnV2", MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 1), &res))
+	assert.True(t, res.Accepted)
+}
+
+func TestRateLimitArchive(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{Read: RateLimit{Rate: 1, Burst: 5}, Archive: RateLimit{Rate: 1, Burst: 1}})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	// the BalanceAt calls have their own bucket
+	assert.Equal(t, archiveLimit, methodLimitClass("WalletService.BalanceAt"))
+	assert.Equal(t, archiveLimit, methodLimitClass("BalanceAt"))
+	assert.True(t, l.allow("10.0.0.1", archiveLimit))
+	assert.False(t, l.allow("10.0.0.1", archiveLimit))
+	assert.True(t, l.allow("10.0.0.1", readLimit))
+
+	now = now.Add(time.Second)
+	assert.True(t, l.allow("10.0.0.1", archiveLimit))
+}
+
+func TestRateLimitExemptLocalhost(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{Read: RateLimit{Rate: 1}, ExemptLocalhost: true})
+	assert.Equal(t, 1, l.cfg.Read.Burst)
+	assert.Equal(t, "", l.client("127.0.0.1:1234"))
+	assert.Equal(t, "", l.client("[::1]:1234"))
+	assert.Equal(t, "10.0.0.1", l.client("10.0.0.1:1234"))
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.allow(l.client("127.0.0.1:1234"), readLimit))
+	}
+	assert.True(t, l.allow("10.0.0.1", readLimit))
+	assert.False(t, l.allow("10.0.0.1", readLimit))
+	assert.True(t, l.allow("10.0.0.2", readLimit))
+	// no limit is set for SendTxn
+	assert.True(t, l.allow("10.0.0.1", sendTxnLimit))
+
+	var nilLimiter *rateLimiter
+	assert.True(t, nilLimiter.allow("10.0.0.1", readLimit))
+
+	// the idle clients are forgotten
+	now := time.Now().Add(2 * rateLimitSweepInterval)
+	l.now = func() time.Time { return now }
+	assert.True(t, l.allow("10.0.0.3", readLimit))
+	assert.Equal(t, 1, len(l.clients))
+}
@@ -0,0 +1,157 @@
+package dex
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// finishedCall is a served RPC call or REST request.
+type finishedCall struct {
+	// rpc, jsonrpc or rest
+	protocol string
+	// the WalletService method, or the path of the REST request
+	method   string
+	remote   string
+	duration time.Duration
+	err      error
+	// the size of the response in bytes, the size of the result
+	// for JSON-RPC
+	size int
+}
+
+// requestLogger logs a sample of the served calls. Only the method
+// and the outcome of a call are logged, never its params, which may
+// carry the signed txns.
+type requestLogger struct {
+	rate float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newRequestLogger(rate float64) *requestLogger {
+	return &requestLogger{rate: rate, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (l *requestLogger) sampled() bool {
+	if l.rate >= 1 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rand.Float64() < l.rate
+}
+
+func (l *requestLogger) log(c finishedCall) {
+	if !l.sampled() {
+		return
+	}
+
+	ctx := []interface{}{
+		"protocol", c.protocol,
+		"method", c.method,
+		"remote", c.remote,
+		"duration", c.duration,
+		"size", c.size,
+	}
+	if c.err != nil {
+		ctx = append(ctx, "err_class", errorClass(c.err))
+	}
+	log.Info("rpc request", ctx...)
+}
+
+// errorClass returns the kind of the error of a call. The net/rpc
+// calls only carry the messages of the errors, their codes are parsed
+// from the messages.
+func errorClass(err error) string {
+	switch e := err.(type) {
+	case *JSONRPCError:
+		switch e.Code {
+		case JSONRPCParseError, JSONRPCInvalidRequest:
+			return "invalid_request"
+		case JSONRPCMethodNotFound:
+			return "method_not_found"
+		case JSONRPCInvalidParams:
+			return "invalid_params"
+		case JSONRPCTxnRejected:
+			return "txn_rejected"
+		}
+	case *restError:
+		return "invalid_request"
+	}
+
+	if err.Error() == ErrClientCertRequired.Error() {
+		return "client_cert_required"
+	}
+
+	switch toRPCError(err).Code {
+	case CodeNotSynced:
+		return "not_ready"
+	case CodeRateLimited:
+		return "rate_limited"
+	case CodeUnauthorized:
+		return "unauthorized"
+	case CodeAccountNotFound, CodeNotFound:
+		return "not_found"
+	case CodeInvalidTxn:
+		return "txn_rejected"
+	case CodeInvalidRequest:
+		return "invalid_request"
+	}
+	return "error"
+}
+
+// observeCall feeds the served call to the metrics and the request
+// log, the REST requests are only logged.
+func (r *RPCServer) observeCall(c finishedCall) {
+	if c.protocol != "rest" {
+		c.method = metricMethod(c.method)
+		r.rpcMetrics.observe(c.method, c.duration, c.err != nil)
+	}
+
+	if r.reqLog != nil {
+		r.reqLog.log(c)
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
+
+// sizeResponseWriter counts the bytes of a REST response body.
+type sizeResponseWriter struct {
+	http.ResponseWriter
+	size int
+}
+
+func (w *sizeResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// SetRequestLog logs the given fraction of the served calls with
+// their method, client address, latency, error class and response
+// size, 0 disables the log. It must be called before Start.
+func (r *RPCServer) SetRequestLog(sampleRate float64) {
+	if sampleRate <= 0 {
+		r.reqLog = nil
+		return
+	}
+
+	r.reqLog = newRequestLogger(sampleRate)
+}
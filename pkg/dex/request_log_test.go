@@ -0,0 +1,107 @@
+package dex
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/helinwang/log15"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLog(t *testing.T) {
+	var mu sync.Mutex
+	var lines []map[string]string
+	var all strings.Builder
+	h := log.Root().GetHandler()
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintln(&all, r.Msg, r.Ctx)
+		if r.Msg != "rpc request" {
+			return nil
+		}
+
+		fields := make(map[string]string)
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			fields[fmt.Sprint(r.Ctx[i])] = fmt.Sprint(r.Ctx[i+1])
+		}
+		lines = append(lines, fields)
+		return nil
+	}))
+	defer log.Root().SetHandler(h)
+
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.SetStater(restTestChain{})
+	r.Update(s)
+	r.SetRequestLog(1)
+
+	handler, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client, err := rpc.DialHTTP("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	txn := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)
+	var res SendTxnResult
+	assert.Nil(t, client.Call("WalletService.SendTxnV2", txn, &res))
+	unknown, _ := RandKeyPair()
+	var w WalletState
+	assert.NotNil(t, client.Call("WalletService.WalletState", unknown.Addr(), &w))
+	postJSONRPC(t, srv.URL+DefaultJSONRPCPath, "SendTxn", []string{"0x" + hex.EncodeToString(txn)})
+	postJSONRPC(t, srv.URL+DefaultJSONRPCPath, "WalletState", []string{"0x00"})
+	resp, err := http.Get(srv.URL + "/round")
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !assert.Len(t, lines, 5) {
+		return
+	}
+
+	for _, l := range lines {
+		assert.Contains(t, l["remote"], "127.0.0.1")
+		assert.NotEmpty(t, l["duration"])
+		if _, ok := l["err_class"]; !ok {
+			assert.NotEqual(t, "0", l["size"])
+		}
+	}
+
+	assert.Equal(t, "rpc", lines[0]["protocol"])
+	assert.Equal(t, "SendTxnV2", lines[0]["method"])
+	assert.NotContains(t, lines[0], "err_class")
+
+	assert.Equal(t, "WalletState", lines[1]["method"])
+	// net/rpc carries the code of the error in its message
+	assert.Equal(t, "not_found", lines[1]["err_class"])
+
+	assert.Equal(t, "jsonrpc", lines[2]["protocol"])
+	assert.Equal(t, "SendTxn", lines[2]["method"])
+	assert.NotContains(t, lines[2], "err_class")
+
+	assert.Equal(t, "WalletState", lines[3]["method"])
+	assert.Equal(t, "invalid_params", lines[3]["err_class"])
+
+	assert.Equal(t, "rest", lines[4]["protocol"])
+	assert.Equal(t, "/round", lines[4]["method"])
+
+	// the signed txn is never logged
+	assert.NotContains(t, all.String(), hex.EncodeToString(txn))
+	assert.NotContains(t, all.String(), fmt.Sprint(txn))
+}
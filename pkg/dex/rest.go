@@ -0,0 +1,268 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDepthLevels is the number of the price levels returned by
+// the depth endpoint when the levels query is not set.
+const defaultDepthLevels = 20
+
+// restError is an error with the HTTP status code of the response.
+type restError struct {
+	code int
+	err  error
+}
+
+func (e *restError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &restError{code: http.StatusBadRequest, err: err}
+}
+
+// restHandler serves the read-only REST gateway:
+//
+//	GET /account/{addr}
+//	GET /tokens
+//	GET /market/{base}/{quote}/depth?levels=20
+//	GET /round
+//	GET /chain/graphviz
+//
+// The responses are JSON, the addresses, the hashes and the token
+// IDs are hex strings as in the JSON-RPC endpoint. The account,
+// tokens, depth and round endpoints answer from the last finalized
+// state when the query has finalized=true, otherwise from the leader
+// state.
+type restHandler struct {
+	s *RPCServer
+}
+
+// register registers the routes on the mux.
+func (h *restHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("/account/", h.serve(h.account))
+	mux.HandleFunc("/tokens", h.serve(h.tokens))
+	mux.HandleFunc("/market/", h.serve(h.depth))
+	mux.HandleFunc("/round", h.serve(h.round))
+	mux.HandleFunc("/chain/graphviz", h.serve(h.graphviz))
+}
+
+func (h *restHandler) serve(f func(req *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		w := &sizeResponseWriter{ResponseWriter: rw}
+		var err error
+		defer func() {
+			h.s.observeCall(finishedCall{protocol: "rest", method: req.URL.Path, remote: req.RemoteAddr, duration: time.Since(start), err: err, size: w.size})
+		}()
+
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			err = &restError{code: http.StatusMethodNotAllowed, err: errors.New("the REST gateway is read-only")}
+			writeRESTError(w, http.StatusMethodNotAllowed, err)
+			return
+		}
+
+		v, err := f(req)
+		if err != nil {
+			writeRESTError(w, restStatus(err), err)
+			return
+		}
+
+		writeJSON(w, jsonValue(reflect.ValueOf(v)))
+	}
+}
+
+// restStatus returns the HTTP status code of the error returned by a
+// REST handler.
+func restStatus(err error) int {
+	if e, ok := err.(*restError); ok {
+		return e.code
+	}
+
+	switch toRPCError(err).Code {
+	case CodeNotSynced:
+		return http.StatusServiceUnavailable
+	case CodeAccountNotFound, CodeNotFound:
+		return http.StatusNotFound
+	case CodeInvalidTxn, CodeInvalidRequest:
+		return http.StatusBadRequest
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeUnauthorized:
+		if err == ErrClientCertRequired {
+			return http.StatusForbidden
+		}
+		return http.StatusUnauthorized
+	case CodeUnsupported:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+// restErrorResponse is the body of a failed REST request.
+type restErrorResponse struct {
+	Error string    `json:"error"`
+	Code  ErrorCode `json:"code"`
+}
+
+func writeRESTError(w http.ResponseWriter, code int, err error) {
+	e := toRPCError(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	writeJSON(w, restErrorResponse{Error: e.Message, Code: e.Code})
+}
+
+func finalizedQuery(req *http.Request) (bool, error) {
+	v := req.URL.Query().Get("finalized")
+	if v == "" {
+		return false, nil
+	}
+
+	finalized, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, badRequest(fmt.Errorf("invalid finalized query %q", v))
+	}
+	return finalized, nil
+}
+
+func (h *restHandler) account(req *http.Request) (interface{}, error) {
+	finalized, err := finalizedQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := parseAddrParam("addr", strings.TrimPrefix(req.URL.Path, "/account/"))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+
+	s, err := h.s.state(finalized)
+	if err != nil {
+		return nil, err
+	}
+
+	var w WalletState
+	err = walletStateAt(s, addr, h.s.walletStateOrders, &w)
+	return w, err
+}
+
+func (h *restHandler) tokens(req *http.Request) (interface{}, error) {
+	finalized, err := finalizedQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+
+	s, err := h.s.state(finalized)
+	if err != nil {
+		return nil, err
+	}
+
+	return TokenState{Tokens: s.Tokens()}, nil
+}
+
+func (h *restHandler) depth(req *http.Request) (interface{}, error) {
+	finalized, err := finalizedQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// /market/{base}/{quote}/depth
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/market/"), "/")
+	if len(parts) != 3 || parts[2] != "depth" {
+		return nil, &restError{code: http.StatusNotFound, err: fmt.Errorf("unknown path %s", req.URL.Path)}
+	}
+
+	base, err := parseTokenIDParam("base", parts[0])
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	quote, err := parseTokenIDParam("quote", parts[1])
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	arg := OrderBookRequest{Market: MarketSymbol{Base: base, Quote: quote}, Levels: defaultDepthLevels}
+	if !arg.Market.Valid() {
+		return nil, badRequest(fmt.Errorf("invalid market %v", arg.Market))
+	}
+
+	if v := req.URL.Query().Get("levels"); v != "" {
+		levels, err := strconv.Atoi(v)
+		if err != nil || levels < 0 {
+			return nil, badRequest(fmt.Errorf("invalid levels query %q", v))
+		}
+		arg.Levels = levels
+	}
+
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+
+	s, err := h.s.state(finalized)
+	if err != nil {
+		return nil, err
+	}
+
+	var d OrderBookResponse
+	orderBookAt(s, arg, &d)
+	return d, nil
+}
+
+// RoundResult is the response of the round endpoint.
+type RoundResult struct {
+	Round uint64
+}
+
+func (h *restHandler) round(req *http.Request) (interface{}, error) {
+	finalized, err := finalizedQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if finalized {
+		h.s.mu.Lock()
+		defer h.s.mu.Unlock()
+
+		if h.s.finalized == nil {
+			return nil, ErrNotReady
+		}
+		return RoundResult{Round: h.s.finalizedRound}, nil
+	}
+
+	if h.s.chain == nil {
+		return nil, ErrNotReady
+	}
+
+	var r RoundResult
+	err = h.s.round(&r.Round)
+	return r, err
+}
+
+// GraphvizResult is the response of the chain graphviz endpoint.
+type GraphvizResult struct {
+	Graphviz string
+}
+
+func (h *restHandler) graphviz(req *http.Request) (interface{}, error) {
+	if h.s.chain == nil {
+		return nil, ErrNotReady
+	}
+
+	var r GraphvizResult
+	err := h.s.graphviz(GraphvizRequest{}, &r.Graphviz)
+	return r, err
+}
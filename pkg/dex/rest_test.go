@@ -0,0 +1,155 @@
+package dex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+type restTestChain struct{}
+
+func (restTestChain) ChainStatus() consensus.ChainStatus {
+	return consensus.ChainStatus{Round: 3}
+}
+
+func (restTestChain) Graphviz(consensus.GraphvizOptions) string {
+	return "digraph chain {}"
+}
+
+func (restTestChain) TxnPoolSize() int {
+	return 0
+}
+
+func (restTestChain) Block(consensus.Hash) (*consensus.Block, bool) {
+	return nil, false
+}
+
+func (restTestChain) BlockAtRound(uint64) (*consensus.Block, bool) {
+	return nil, false
+}
+
+func (restTestChain) BlockProposal(consensus.Hash) *consensus.BlockProposal {
+	return nil
+}
+
+func getREST(t *testing.T, url string, code int, v interface{}) {
+	resp, err := http.Get(url)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, code, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func TestRESTGateway(t *testing.T) {
+	finalized, pk, sk, _, _ := newTIFTestState()
+	finalized.CommitCache()
+	trans := finalized.Transition(2, nil)
+	recordTxn(t, trans, pk, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: MarketSymbol{Base: 0, Quote: 1}}, 0))
+	leader := trans.Commit().(*State)
+
+	r := NewRPCServer()
+	r.SetStater(restTestChain{})
+	r.Update(leader)
+	r.Finalized(1, finalized)
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	type balance struct {
+		Token     string
+		Available uint64
+		Pending   uint64
+	}
+	var w struct {
+		Balances      []balance
+		PendingOrders []struct {
+			ID struct {
+				ID     uint64
+				Market struct{ Base, Quote string }
+			}
+			Owner string
+			Quant uint64
+		}
+	}
+	getREST(t, srv.URL+"/account/"+pk.Addr().Hex(), http.StatusOK, &w)
+	assert.Equal(t, []balance{{"0", 90, 10}, {"1", 100, 0}}, w.Balances)
+	assert.Equal(t, 1, len(w.PendingOrders))
+	assert.Equal(t, "1", w.PendingOrders[0].ID.Market.Quote)
+	assert.Equal(t, pk.Addr().Hex(), w.PendingOrders[0].Owner)
+	assert.Equal(t, uint64(10), w.PendingOrders[0].Quant)
+
+	w.PendingOrders = nil
+	getREST(t, srv.URL+"/account/"+pk.Addr().Hex()+"?finalized=true", http.StatusOK, &w)
+	assert.Equal(t, []balance{{"0", 100, 0}, {"1", 100, 0}}, w.Balances)
+	assert.Equal(t, 0, len(w.PendingOrders))
+
+	var e struct{ Error string }
+	unknown, _ := RandKeyPair()
+	getREST(t, srv.URL+"/account/"+unknown.Addr().Hex(), http.StatusNotFound, &e)
+	assert.NotEqual(t, "", e.Error)
+	getREST(t, srv.URL+"/account/xyz", http.StatusBadRequest, &e)
+	getREST(t, srv.URL+"/account/"+pk.Addr().Hex()+"?finalized=maybe", http.StatusBadRequest, &e)
+
+	var tokens struct {
+		Tokens []struct {
+			ID     string
+			Symbol string
+		}
+	}
+	getREST(t, srv.URL+"/tokens", http.StatusOK, &tokens)
+	assert.Equal(t, 2, len(tokens.Tokens))
+	assert.Equal(t, "1", tokens.Tokens[1].ID)
+	assert.Equal(t, "BNB", tokens.Tokens[1].Symbol)
+
+	var d struct {
+		Bids []Level
+		Asks []Level
+	}
+	getREST(t, srv.URL+"/market/0/1/depth?levels=20", http.StatusOK, &d)
+	assert.Nil(t, d.Bids)
+	assert.Equal(t, []Level{{Price: 100000000, Quant: 10, Orders: 1}}, d.Asks)
+
+	d.Asks = nil
+	getREST(t, srv.URL+"/market/0/1/depth?finalized=true", http.StatusOK, &d)
+	assert.Nil(t, d.Asks)
+	getREST(t, srv.URL+"/market/1/1/depth", http.StatusBadRequest, &e)
+	getREST(t, srv.URL+"/market/0/1/trades", http.StatusNotFound, &e)
+
+	var round RoundResult
+	getREST(t, srv.URL+"/round", http.StatusOK, &round)
+	assert.Equal(t, uint64(3), round.Round)
+	getREST(t, srv.URL+"/round?finalized=true", http.StatusOK, &round)
+	assert.Equal(t, uint64(1), round.Round)
+
+	var g GraphvizResult
+	getREST(t, srv.URL+"/chain/graphviz", http.StatusOK, &g)
+	assert.Equal(t, "digraph chain {}", g.Graphviz)
+
+	resp, err := http.Post(srv.URL+"/tokens", "application/json", strings.NewReader("{}"))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestRESTGatewayNotReady(t *testing.T) {
+	r := NewRPCServer()
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	var e restErrorResponse
+	getREST(t, srv.URL+"/tokens", http.StatusServiceUnavailable, &e)
+	assert.Equal(t, ErrNotReady.Message, e.Error)
+	assert.Equal(t, CodeNotSynced, e.Code)
+	getREST(t, srv.URL+"/round?finalized=true", http.StatusServiceUnavailable, &e)
+}
@@ -0,0 +1,63 @@
+package dex
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthorized is returned when a call that requires
+// authentication is made without the correct bearer token.
+var ErrUnauthorized = &RPCError{Code: CodeUnauthorized, Message: "unauthorized"}
+
+// DefaultAuthMethods are the methods that require authentication
+// when the auth token is set and no methods are given: the calls
+// that change the state and the admin calls.
+var DefaultAuthMethods = []string{"SendTxn", "SendTxnV2", "SendTxnWait", "DiffStates"}
+
+// authCheck requires the bearer token for the methods. The token is
+// never logged or included in the errors.
+type authCheck struct {
+	token   []byte
+	methods map[string]bool
+}
+
+func newAuthCheck(token string, methods []string) *authCheck {
+	if methods == nil {
+		methods = DefaultAuthMethods
+	}
+
+	a := &authCheck{token: []byte(token), methods: make(map[string]bool)}
+	for _, m := range methods {
+		a.methods[m] = true
+	}
+	return a
+}
+
+// check is the callCheck of the auth. A net/rpc connection carries
+// the token in the header of its CONNECT request. The AdminService
+// methods always require the token.
+func (a *authCheck) check(req *http.Request, method string) error {
+	if !a.methods[strings.TrimPrefix(method, "WalletService.")] && !isAdminMethod(method) {
+		return nil
+	}
+
+	const prefix = "Bearer "
+	h := req.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return ErrUnauthorized
+	}
+
+	if subtle.ConstantTimeCompare([]byte(h[len(prefix):]), a.token) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// SetAuth requires the bearer token for the methods, the methods are
+// the names of the WalletService methods, nil means
+// DefaultAuthMethods. The other methods stay open. It must be called
+// before Start.
+func (r *RPCServer) SetAuth(token string, methods []string) {
+	r.auth = newAuthCheck(token, methods)
+}
@@ -0,0 +1,99 @@
+package dex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/helinwang/log15"
+	"github.com/stretchr/testify/assert"
+)
+
+func postAuthJSONRPC(t *testing.T, url, token, method string) jsonRPCTestResponse {
+	b, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": []string{"0x00"}, "id": 7})
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	assert.Nil(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	var r jsonRPCTestResponse
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&r))
+	return r
+}
+
+func TestRPCAuth(t *testing.T) {
+	const token = "s3cret-token"
+	var mu sync.Mutex
+	var logs bytes.Buffer
+	h := log.Root().GetHandler()
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		mu.Lock()
+		fmt.Fprintln(&logs, r.Msg, r.Ctx)
+		mu.Unlock()
+		return nil
+	}))
+	defer log.Root().SetHandler(h)
+
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+	r.SetAuth(token, nil)
+
+	handler, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	txn := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)
+	for _, tk := range []string{"", "wrong"} {
+		client, err := DialRPC(addr, nil, tk)
+		assert.Nil(t, err)
+
+		// the read-only calls stay open
+		var w WalletState
+		assert.Nil(t, client.Call("WalletService.WalletState", pk.Addr(), &w))
+
+		var res SendTxnResult
+		err = client.Call("WalletService.SendTxnV2", txn, &res)
+		assert.Equal(t, ErrUnauthorized.Error(), err.Error())
+		client.Close()
+
+		resp := postAuthJSONRPC(t, srv.URL+DefaultJSONRPCPath, tk, "SendTxn")
+		assert.Equal(t, JSONRPCUnauthorized, resp.Error.Code)
+	}
+	assert.Equal(t, 0, pool.Size())
+
+	client, err := DialRPC(addr, nil, token)
+	assert.Nil(t, err)
+	defer client.Close()
+	var res SendTxnResult
+	assert.Nil(t, client.Call("WalletService.SendTxnV2", txn, &res))
+	assert.True(t, res.Accepted)
+
+	// the txn is rejected by the pool rather than the auth
+	resp := postAuthJSONRPC(t, srv.URL+DefaultJSONRPCPath, token, "SendTxn")
+	assert.Equal(t, JSONRPCTxnRejected, resp.Error.Code)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotContains(t, logs.String(), token)
+}
@@ -0,0 +1,66 @@
+package dex
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+)
+
+// rpcConnected is the status of the response to the net/rpc CONNECT
+// request.
+const rpcConnected = "200 Connected to Go RPC"
+
+// DialRPC connects to the wallet service at the address, over TLS
+// if cfg is not nil. The token is sent as the bearer token of the
+// connection if it is not empty.
+func DialRPC(addr string, cfg *tls.Config, token string) (*rpc.Client, error) {
+	if cfg == nil && token == "" {
+		return rpc.DialHTTP("tcp", addr)
+	}
+
+	var conn net.Conn
+	var err error
+	if cfg != nil {
+		conn, err = tls.Dial("tcp", addr, cfg)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRPCClient(conn, token)
+}
+
+// NewRPCClient makes the net/rpc handshake over the connection to the
+// wallet service, which is closed if the handshake fails. The token
+// is sent as the bearer token if it is not empty.
+func NewRPCClient(conn net.Conn, token string) (*rpc.Client, error) {
+	req := "CONNECT " + rpc.DefaultRPCPath + " HTTP/1.0\n"
+	if token != "" {
+		req += "Authorization: Bearer " + token + "\n"
+	}
+
+	_, err := io.WriteString(conn, req+"\n")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if resp.Status != rpcConnected {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected HTTP response: %s", resp.Status)
+	}
+
+	return rpc.NewClient(conn), nil
+}
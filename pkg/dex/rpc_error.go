@@ -0,0 +1,170 @@
+package dex
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is the class of the failure of a wallet RPC call. The
+// clients decide whether to retry by the code rather than by the
+// message, which is only meant for humans.
+type ErrorCode int
+
+const (
+	// CodeInternal is a failure of the node, or a failure that
+	// is not classified by the other codes.
+	CodeInternal ErrorCode = iota
+	// CodeNotSynced means the node has not reached consensus
+	// yet, the client should retry later.
+	CodeNotSynced
+	// CodeAccountNotFound means the account does not exist.
+	CodeAccountNotFound
+	// CodeInvalidTxn means the txn is rejected, e.g., bad
+	// signature, stale nonce or insufficient balance.
+	CodeInvalidTxn
+	// CodeRateLimited means the client exceeded its rate limit,
+	// the client should retry later.
+	CodeRateLimited
+	// CodeUnauthorized means the call requires the bearer token
+	// or a verified client certificate.
+	CodeUnauthorized
+	// CodeNotFound means the token, order, block or state asked
+	// for does not exist.
+	CodeNotFound
+	// CodeInvalidRequest means the arguments of the call are
+	// invalid.
+	CodeInvalidRequest
+	// CodeUnsupported means the node is not configured to serve
+	// the call, e.g., the historical queries on a node that is not
+	// an archive node.
+	CodeUnsupported
+)
+
+var errorCodeNames = []string{
+	CodeInternal:        "Internal",
+	CodeNotSynced:       "NotSynced",
+	CodeAccountNotFound: "AccountNotFound",
+	CodeInvalidTxn:      "InvalidTxn",
+	CodeRateLimited:     "RateLimited",
+	CodeUnauthorized:    "Unauthorized",
+	CodeNotFound:        "NotFound",
+	CodeInvalidRequest:  "InvalidRequest",
+	CodeUnsupported:     "Unsupported",
+}
+
+func (c ErrorCode) String() string {
+	if c < 0 || int(c) >= len(errorCodeNames) {
+		return fmt.Sprintf("ErrorCode(%d)", int(c))
+	}
+	return errorCodeNames[c]
+}
+
+// ParseErrorCode returns the code of the name, ok is false if the name
+// is unknown.
+func ParseErrorCode(name string) (code ErrorCode, ok bool) {
+	for i, n := range errorCodeNames {
+		if n == name {
+			return ErrorCode(i), true
+		}
+	}
+	return CodeInternal, false
+}
+
+// MarshalText encodes the code by its name in the JSON responses.
+func (c ErrorCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *ErrorCode) UnmarshalText(b []byte) error {
+	code, ok := ParseErrorCode(string(b))
+	if !ok {
+		return fmt.Errorf("unknown error code %q", b)
+	}
+
+	*c = code
+	return nil
+}
+
+// RPCError is the error of a wallet RPC call. It is carried as the
+// message of the error over net/rpc, as the data of the error over
+// JSON-RPC and REST, and as the ErrorInfo detail of the status over
+// gRPC.
+type RPCError struct {
+	Code    ErrorCode
+	Message string
+}
+
+// Error returns the message prefixed by the name of the code, it is
+// the form carried over net/rpc, which only carries the messages of
+// the errors. ParseRPCError parses it back.
+func (e *RPCError) Error() string {
+	return e.Code.String() + ": " + e.Message
+}
+
+// Is returns true if the target is an RPCError of the same code, and
+// of the same message unless the target's message is empty. An
+// RPCError with only the code set matches all the errors of the code.
+func (e *RPCError) Is(target error) bool {
+	t, ok := target.(*RPCError)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+// ParseRPCError parses the message of an RPCError, ok is false if the
+// message is not in the form returned by RPCError.Error.
+func ParseRPCError(msg string) (e *RPCError, ok bool) {
+	i := strings.Index(msg, ": ")
+	if i < 0 {
+		return nil, false
+	}
+
+	code, ok := ParseErrorCode(msg[:i])
+	if !ok {
+		return nil, false
+	}
+	return &RPCError{Code: code, Message: msg[i+2:]}, true
+}
+
+func newRPCError(code ErrorCode, format string, a ...interface{}) *RPCError {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// toRPCError returns the RPCError of the error returned by a call,
+// the errors that are not classified are internal errors.
+func toRPCError(err error) *RPCError {
+	switch e := err.(type) {
+	case *RPCError:
+		return e
+	case unknownAccountError:
+		return &RPCError{Code: CodeAccountNotFound, Message: e.Error()}
+	case unknownTokenError, unknownOrderError, unknownBlockError:
+		return &RPCError{Code: CodeNotFound, Message: e.Error()}
+	case *restError:
+		if e.code == http.StatusNotFound {
+			return &RPCError{Code: CodeNotFound, Message: e.Error()}
+		}
+		return &RPCError{Code: CodeInvalidRequest, Message: e.Error()}
+	case *JSONRPCError:
+		if e.Data != nil {
+			return &RPCError{Code: e.Data.Code, Message: e.Message}
+		}
+		return &RPCError{Code: jsonRPCErrorCode(e.Code), Message: e.Message}
+	}
+
+	if e, ok := ParseRPCError(err.Error()); ok {
+		return e
+	}
+	return &RPCError{Code: CodeInternal, Message: err.Error()}
+}
+
+// serviceError returns the error of a WalletService call as an
+// RPCError, so that its code is carried over net/rpc.
+func serviceError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return toRPCError(err)
+}
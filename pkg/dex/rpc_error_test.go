@@ -0,0 +1,109 @@
+package dex
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRPCErrorCodes(t *testing.T) {
+	s, pk, _, _, _ := newTIFTestState()
+	s.CommitCache()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+	r.SetAuth("secret", []string{"Orders"})
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	client, err := rpc.DialHTTP("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	assert.Nil(t, err)
+	defer client.Close()
+
+	empty := NewRPCServer()
+	eh, err := empty.Handler()
+	assert.Nil(t, err)
+	esrv := httptest.NewServer(eh)
+	defer esrv.Close()
+	eclient, err := rpc.DialHTTP("tcp", strings.TrimPrefix(esrv.URL, "http://"))
+	assert.Nil(t, err)
+	defer eclient.Close()
+
+	unknown, _ := RandKeyPair()
+	invalid := MarketSymbol{Base: 1, Quote: 1}
+	cases := []struct {
+		code       ErrorCode
+		client     *rpc.Client
+		url        string
+		method     string
+		arg        interface{}
+		reply      interface{}
+		jsonParams interface{}
+	}{
+		{CodeNotSynced, eclient, esrv.URL, "WalletState", pk.Addr(), &WalletState{}, []string{pk.Addr().Hex()}},
+		{CodeAccountNotFound, client, srv.URL, "WalletState", unknown.Addr(), &WalletState{}, []string{unknown.Addr().Hex()}},
+		{CodeInvalidTxn, client, srv.URL, "SendTxn", []byte{1, 2, 3}, new(AddResult), []string{"0x010203"}},
+		{CodeUnauthorized, client, srv.URL, "Orders", OrdersRequest{Addr: pk.Addr()}, &OrdersResponse{}, nil},
+		{CodeNotFound, client, srv.URL, "Order", OrderRequest{Market: MarketSymbol{Base: 0, Quote: 1}, ID: 7}, &OrderResult{}, []interface{}{"0", "1", 7}},
+		{CodeNotFound, client, srv.URL, "TokenBySymbol", "NOPE", &Token{}, []string{"NOPE"}},
+		{CodeInvalidRequest, client, srv.URL, "OrderBook", OrderBookRequest{Market: invalid}, &OrderBookResponse{}, []interface{}{"1", "1", 10}},
+		{CodeUnsupported, client, srv.URL, "BalanceAt", BalanceAtRequest{Addr: pk.Addr(), Round: 1}, &BalanceAtResult{}, []interface{}{pk.Addr().Hex(), "0", 1}},
+	}
+
+	for _, c := range cases {
+		err := c.client.Call("WalletService."+c.method, c.arg, c.reply)
+		assert.NotNil(t, err, c.method)
+		e, ok := ParseRPCError(err.Error())
+		if assert.True(t, ok, err.Error()) {
+			assert.Equal(t, c.code, e.Code, c.method)
+			assert.False(t, strings.HasPrefix(e.Message, c.code.String()))
+		}
+
+		resp := postJSONRPC(t, c.url+DefaultJSONRPCPath, c.method, c.jsonParams)
+		if assert.NotNil(t, resp.Error, c.method) && assert.NotNil(t, resp.Error.Data) {
+			assert.Equal(t, c.code, resp.Error.Data.Code, c.method)
+		}
+	}
+
+	// the JSON-RPC protocol errors are invalid requests
+	resp := postJSONRPC(t, srv.URL+DefaultJSONRPCPath, "NoSuchMethod", nil)
+	assert.Equal(t, JSONRPCMethodNotFound, resp.Error.Code)
+	assert.Equal(t, CodeInvalidRequest, resp.Error.Data.Code)
+}
+
+func TestRPCError(t *testing.T) {
+	e, ok := ParseRPCError(ErrNotReady.Error())
+	assert.True(t, ok)
+	assert.Equal(t, ErrNotReady, e)
+	assert.True(t, errors.Is(e, ErrNotReady))
+	assert.True(t, errors.Is(e, &RPCError{Code: CodeNotSynced}))
+	assert.False(t, errors.Is(e, &RPCError{Code: CodeNotSynced, Message: "other"}))
+	assert.False(t, errors.Is(e, ErrRateLimited))
+
+	_, ok = ParseRPCError("account 0x01 does not exist")
+	assert.False(t, ok)
+	_, ok = ParseRPCError("Unknown: message")
+	assert.False(t, ok)
+
+	assert.Equal(t, CodeInternal, toRPCError(errors.New("boom")).Code)
+	assert.Equal(t, CodeAccountNotFound, toRPCError(unknownAccountError(consensus.Addr{})).Code)
+	assert.Equal(t, CodeNotFound, toRPCError(unknownBlockError("block does not exist")).Code)
+
+	for code := CodeInternal; code <= CodeUnsupported; code++ {
+		b, err := code.MarshalText()
+		assert.Nil(t, err)
+		var c ErrorCode
+		assert.Nil(t, c.UnmarshalText(b))
+		assert.Equal(t, code, c)
+	}
+}
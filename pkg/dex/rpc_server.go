@@ -1,37 +1,210 @@
 package dex
 
 import (
-	"errors"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"net/rpc"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
 	log "github.com/helinwang/log15"
 )
 
 type TxnSender interface {
 	SendTxn([]byte)
+	BroadcastTxn([]byte)
 }
 
 type ChainStater interface {
 	ChainStatus() consensus.ChainStatus
-	Graphviz(int) string
+	Graphviz(consensus.GraphvizOptions) string
 	TxnPoolSize() int
+	Block(h consensus.Hash) (*consensus.Block, bool)
+	BlockAtRound(round uint64) (*consensus.Block, bool)
+	BlockProposal(h consensus.Hash) *consensus.BlockProposal
+}
+
+// NodeStater reports the status of the node's networking and
+// syncing.
+type NodeStater interface {
+	NodeStatus() consensus.NodeStatus
+}
+
+// TxnPooler is the txn pool used by the RPC server.
+type TxnPooler interface {
+	AddTxn(b []byte) (*consensus.Txn, AddResult, error)
+	PendingForAddr(addr consensus.Addr) []PendingTxnInfo
+	TxnStatus(hash consensus.Hash) TxnStatusResult
+	WaitIncluded(ctx context.Context, hash consensus.Hash, rounds uint64) TxnStatusResult
+	Stats() PoolStats
 }
 
 type RPCServer struct {
 	sender TxnSender
+	pool   TxnPooler
+
+	// the HTTP paths of the JSON-RPC and the WebSocket
+	// endpoints, empty means disabled.
+	jsonRPCPath string
+	wsPath      string
+	hub         *eventHub
+	trades      *tradeTape
+	closed      *closedOrderIndex
+	// the maximum number of the pending orders in WalletState
+	walletStateOrders int
+	// how long a SendTxnWait call waits at most
+	sendTxnWaitTimeout time.Duration
+	// the maximum size of a RawBlock response
+	maxRawBlockSize int
+	// nil if the clients are not rate limited
+	limiter *rateLimiter
+	// nil if the server is served in plain HTTP
+	tls               *tls.Config
+	requireClientCert bool
+	// nil if no method requires authentication
+	auth *authCheck
+	// nil if no cross-origin access is allowed
+	cors *CORSConfig
+	// the metrics are served on metricsAddr, or on the RPC
+	// address if it is empty.
+	metrics     *Registry
+	rpcMetrics  *rpcMetrics
+	metricsAddr string
+	// nil if the requests are not logged
+	reqLog *requestLogger
+	// the condition checked by the readiness endpoint, peers is
+	// nil if the peer count is unknown
+	readiness ReadinessConfig
+	peers     PeerCounter
+
+	// nil if the node status is unknown
+	node NodeStater
+	// nil if the node does not retain the state snapshots
+	archive *stateArchive
+	// nil if the AdminService is disabled
+	peerManager     PeerManager
+	peerBanDuration time.Duration
 
 	mu    sync.Mutex
 	chain ChainStater
 	s     *State
+	// the last finalized state and its round
+	finalized      *State
+	finalizedRound uint64
+	admin          bool
+	// the nonces handed out by NonceSlots that are not yet seen
+	// in the pool, indexed by the account and the client token.
+	reserved map[nonceClient]map[uint64]time.Time
 }
 
+// nonceClient is the clients of an account that share the nonce
+// reservations, the clients of the same token get distinct nonces.
+type nonceClient struct {
+	addr  consensus.Addr
+	token string
+}
+
+// nonceReservation is how long a nonce handed out by NonceSlots is
+// not handed out again, the client should submit the txn within the
+// duration.
+const nonceReservation = 30 * time.Second
+
+// maxNonceSlots is the maximum number of nonces allocated by a
+// NonceSlots call.
+const maxNonceSlots = 1000
+
+// maxNonceTokenLen is the maximum length of the NonceSlots token.
+const maxNonceTokenLen = 64
+
+const (
+	// maxSendTxnWaitRounds caps the rounds a SendTxnWait call
+	// waits for the txn to be included.
+	maxSendTxnWaitRounds = 60
+	// defaultSendTxnWaitTimeout is how long a SendTxnWait call
+	// waits at most, whatever the rounds are.
+	defaultSendTxnWaitTimeout = time.Minute
+)
+
+// ErrNotReady is returned when the RPC server has not received a
+// finalized state.
+var ErrNotReady = &RPCError{Code: CodeNotSynced, Message: "waiting for reaching consensus"}
+
+// DefaultJSONRPCPath is the default HTTP path of the JSON-RPC
+// endpoint.
+const DefaultJSONRPCPath = "/jsonrpc"
+
 func NewRPCServer() *RPCServer {
-	return &RPCServer{}
+	r := &RPCServer{
+		jsonRPCPath:        DefaultJSONRPCPath,
+		wsPath:             DefaultWebSocketPath,
+		hub:                newEventHub(),
+		trades:             newTradeTape(defaultTradesPerMarket),
+		walletStateOrders:  defaultWalletStateOrders,
+		sendTxnWaitTimeout: defaultSendTxnWaitTimeout,
+		maxRawBlockSize:    defaultMaxRawBlockSize,
+		closed:             newClosedOrderIndex(defaultClosedOrders),
+		metrics:            NewRegistry(),
+		rpcMetrics:         newRPCMetrics(),
+		reserved:           make(map[nonceClient]map[uint64]time.Time),
+	}
+	r.metrics.Register(r.rpcMetrics.requests)
+	r.metrics.Register(r.rpcMetrics.errors)
+	r.metrics.Register(r.rpcMetrics.latency)
+	r.metrics.Register(statusMetrics{s: r})
+	return r
+}
+
+// Metrics returns the metrics registry of the RPC server, the
+// metrics registered to it are served with the server's metrics.
+func (r *RPCServer) Metrics() *Registry {
+	return r.metrics
+}
+
+// SetMetricsAddr serves the metrics on a separate address rather than
+// the RPC address, it must be called before Start.
+func (r *RPCServer) SetMetricsAddr(addr string) {
+	r.metricsAddr = addr
+}
+
+// SetJSONRPCPath sets the HTTP path of the JSON-RPC endpoint, empty
+// path disables the endpoint. It must be called before Start.
+func (r *RPCServer) SetJSONRPCPath(path string) {
+	r.jsonRPCPath = path
+}
+
+// defaultWalletStateOrders is the default number of the pending
+// orders included in WalletState.
+const defaultWalletStateOrders = 1000
+
+// SetWalletStateOrders sets the maximum number of the pending orders
+// included in WalletState, the rest are returned by the Orders RPC.
+func (r *RPCServer) SetWalletStateOrders(n int) {
+	r.walletStateOrders = n
+}
+
+// SetTradesPerMarket sets the number of the recent trades kept for
+// each market, it must be called before Start.
+func (r *RPCServer) SetTradesPerMarket(n int) {
+	r.trades = newTradeTape(n)
+}
+
+// SetWebSocketPath sets the HTTP path of the WebSocket subscription
+// endpoint, empty path disables the endpoint. It must be called
+// before Start.
+func (r *RPCServer) SetWebSocketPath(path string) {
+	r.wsPath = path
+}
+
+// SetRateLimit limits the rate of the requests of each client, it
+// must be called before Start.
+func (r *RPCServer) SetRateLimit(cfg RateLimitConfig) {
+	r.limiter = newRateLimiter(cfg)
 }
 
 // SetSender sets the transaction sender, it must be called before
@@ -40,37 +213,172 @@ func (r *RPCServer) SetSender(sender TxnSender) {
 	r.sender = sender
 }
 
+// EnableAdmin enables the admin RPC calls used for debugging, it
+// must be called before Start.
+func (r *RPCServer) EnableAdmin() {
+	r.admin = true
+}
+
+// SetTxnPool sets the txn pool, it must be called before Start.
+func (r *RPCServer) SetTxnPool(p TxnPooler) {
+	r.pool = p
+}
+
 // SetStater sets the chain stater, it must be called before Start.
 func (r *RPCServer) SetStater(c ChainStater) {
 	r.chain = c
 }
 
+// SetNodeStater sets the node stater of the NodeInfo RPC, it must be
+// called before Start.
+func (r *RPCServer) SetNodeStater(n NodeStater) {
+	r.node = n
+}
+
+// Update sets the leader state that the queries are answered from.
+// The queries are answered from a snapshot of the state, since the
+// state is modified by the transitions derived from it.
 func (r *RPCServer) Update(state consensus.State) {
-	s := state.(*State)
+	s := state.(*State).Snapshot()
 	r.mu.Lock()
 	r.s = s
 	r.mu.Unlock()
 }
 
-func (r *RPCServer) Start(addr string) error {
-	w := &WalletService{s: r}
+// Finalized records the finalized state, its trades and closed
+// orders, and publishes its events to the WebSocket subscribers.
+func (r *RPCServer) Finalized(round uint64, state consensus.State) {
+	s := state.(*State).Snapshot()
+	r.mu.Lock()
+	r.finalized = s
+	r.finalizedRound = round
+	if r.archive != nil {
+		r.archive.add(round, s)
+	}
+	r.mu.Unlock()
+
+	if s.events != nil {
+		r.trades.add(round, s.events.Trades)
+		r.closed.add(round, s.events.ClosedOrders)
+	}
+	r.hub.publish(round, s.Hash(), s.events)
+}
+
+// state returns the leader state, or the last finalized state if
+// finalized is true. The caller must hold r.mu.
+func (r *RPCServer) state(finalized bool) (*State, error) {
+	s := r.s
+	if finalized {
+		s = r.finalized
+	}
+
+	if s == nil {
+		return nil, ErrNotReady
+	}
+	return s, nil
+}
+
+// Handler returns the HTTP handler serving the wallet service, it is
+// what Start serves, and can be served on the caller's listener. The
+// service is registered on its own rpc.Server rather than the global
+// one, so that multiple RPC servers can run in the same process. The
+// same methods are served over JSON-RPC for the non-Go clients, the
+// events are served over WebSocket, and the common queries are
+// served by the read-only REST gateway, all of them behind the rate
+// limiter, the client certificate check and the auth if they are
+// set. The AdminService is served over net/rpc if it is enabled. The
+// metrics are served on the same address unless a separate one is
+// set.
+func (r *RPCServer) Handler() (http.Handler, error) {
+	s := rpc.NewServer()
+	err := s.Register(NewWalletService(r))
+	if err != nil {
+		return nil, err
+	}
 
-	err := rpc.Register(w)
+	if r.peerManager != nil {
+		if r.auth == nil {
+			return nil, errAdminServiceNoAuth
+		}
+
+		err = s.Register(&AdminService{s: r})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	check := r.callCheck()
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, s)
+	if r.jsonRPCPath != "" {
+		mux.Handle(r.jsonRPCPath, &jsonRPCHandler{s: r, check: check})
+	}
+	if r.wsPath != "" {
+		mux.Handle(r.wsPath, newWSHandler(r.hub))
+	}
+	(&restHandler{s: r}).register(mux)
+	if r.metricsAddr == "" {
+		mux.Handle(MetricsPath, r.metrics)
+	}
+
+	// the health endpoints are not rate limited or authenticated,
+	// the load balancers probe them frequently.
+	root := http.NewServeMux()
+	root.HandleFunc(HealthzPath, r.healthz)
+	root.HandleFunc(ReadyzPath, r.readyz)
+	var h http.Handler = middleware(mux, s, r.jsonRPCPath, check, r.observeCall)
+	if r.cors != nil {
+		h, err = newCORSHandler(h, *r.cors, r.auth != nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	root.Handle("/", h)
+	return root, nil
+}
+
+func (r *RPCServer) Start(addr string) error {
+	h, err := r.Handler()
 	if err != nil {
 		return err
 	}
 
-	rpc.HandleHTTP()
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
+
+	if r.tls != nil {
+		l = tls.NewListener(l, r.tls)
+	} else if host, _, err := net.SplitHostPort(addr); err == nil {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			log.Warn("RPC server is served in plain HTTP on a non-localhost address, consider enabling TLS", "addr", addr)
+		}
+	}
 	go func() {
-		err = http.Serve(l, nil)
+		err := http.Serve(l, h)
 		if err != nil {
 			log.Error("error serving RPC server", "err", err)
 		}
 	}()
+
+	if r.metricsAddr != "" {
+		ml, err := net.Listen("tcp", r.metricsAddr)
+		if err != nil {
+			l.Close()
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(MetricsPath, r.metrics)
+		go func() {
+			err := http.Serve(ml, mux)
+			if err != nil {
+				log.Error("error serving metrics", "err", err)
+			}
+		}()
+	}
 	return nil
 }
 
@@ -83,23 +391,64 @@ type UserBalance struct {
 	Balance
 }
 
+// WalletState is the state of an account. The frozen balances are
+// in Balances with the rounds that they become available, Round is
+// the round of the state, so that the client can tell how long the
+// frozen balances are locked.
+//
+// PendingOrders are sorted by the market and the order ID, at most
+// the server's limit of them are included. If Truncated is true, the
+// rest are returned by the Orders RPC after Cursor.
 type WalletState struct {
 	Balances         []UserBalance
 	PendingOrders    []PendingOrder
 	ExecutionReports []ExecutionReport
+	Round            uint64
+	Truncated        bool
+	Cursor           OrderID
+	// the version of the state that answered the query
+	Version StateVersion
+}
+
+// IsNotFound returns true if the error is returned since the
+// account, token, order or block asked for does not exist.
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch toRPCError(err).Code {
+	case CodeAccountNotFound, CodeNotFound:
+		return true
+	}
+	return false
+}
+
+// unknownAccountError is returned when the account does not exist.
+type unknownAccountError consensus.Addr
+
+func (e unknownAccountError) Error() string {
+	return fmt.Sprintf("account %v does not exist", consensus.Addr(e))
 }
 
 func (r *RPCServer) walletState(addr consensus.Addr, w *WalletState) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if r.s == nil {
-		return errors.New("waiting for reaching consensus")
+	s, err := r.state(false)
+	if err != nil {
+		return err
 	}
 
-	acc := r.s.Account(addr)
+	return walletStateAt(s, addr, r.walletStateOrders, w)
+}
+
+// walletStateAt reads the account's state from s, at most
+// orderLimit pending orders are included.
+func walletStateAt(s *State, addr consensus.Addr, orderLimit int, w *WalletState) error {
+	acc := s.Account(addr)
 	if acc == nil {
-		return fmt.Errorf("account %v does not exist", addr)
+		return unknownAccountError(addr)
 	}
 
 	acc.loadBalances()
@@ -109,6 +458,9 @@ func (r *RPCServer) walletState(addr consensus.Addr, w *WalletState) error {
 		keys[i] = k
 		i++
 	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
 
 	bs := make([]UserBalance, len(keys))
 	for i := range bs {
@@ -116,9 +468,103 @@ func (r *RPCServer) walletState(addr consensus.Addr, w *WalletState) error {
 		bs[i].Balance = acc.Balance(keys[i])
 	}
 
-	w.PendingOrders = acc.PendingOrders()
+	w.PendingOrders, w.Truncated = pendingOrdersPage(acc, OrderID{}, orderLimit)
+	if w.Truncated {
+		w.Cursor = w.PendingOrders[len(w.PendingOrders)-1].ID
+	}
 	w.ExecutionReports = acc.ExecutionReports()
 	w.Balances = bs
+	w.Round = s.round
+	w.Version = s.Version()
+	return nil
+}
+
+// orderIDLess orders the order IDs by the market and then the ID,
+// the zero OrderID is before all the orders since its market is
+// invalid.
+func orderIDLess(a, b OrderID) bool {
+	if a.Market.Base != b.Market.Base {
+		return a.Market.Base < b.Market.Base
+	}
+
+	if a.Market.Quote != b.Market.Quote {
+		return a.Market.Quote < b.Market.Quote
+	}
+
+	return a.ID < b.ID
+}
+
+// pendingOrdersPage returns at most limit pending orders of the
+// account after the given order ID in the order of orderIDLess, and
+// whether there are more orders after them.
+func pendingOrdersPage(acc *Account, after OrderID, limit int) ([]PendingOrder, bool) {
+	all := acc.PendingOrders()
+	orders := all[:0]
+	for _, o := range all {
+		if orderIDLess(after, o.ID) {
+			orders = append(orders, o)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orderIDLess(orders[i].ID, orders[j].ID)
+	})
+
+	if len(orders) <= limit {
+		return orders, false
+	}
+	return orders[:limit], true
+}
+
+// maxOrdersLimit is the maximum number of the orders returned by
+// the Orders RPC.
+const maxOrdersLimit = 1000
+
+// OrdersRequest is the argument of the Orders RPC.
+type OrdersRequest struct {
+	Addr consensus.Addr
+	// only the orders after After in the order of the market and
+	// the order ID are returned, the zero value starts from the
+	// first order.
+	After OrderID
+	// the maximum number of the returned orders, it is capped by
+	// the server, 0 means the cap.
+	Limit int
+}
+
+// OrdersResponse is a page of the account's pending orders. If
+// Truncated is true, the next page is returned after Cursor.
+type OrdersResponse struct {
+	Orders    []PendingOrder
+	Truncated bool
+	Cursor    OrderID
+	// the version of the state that answered the query
+	Version StateVersion
+}
+
+func (r *RPCServer) orders(req OrdersRequest, resp *OrdersResponse) error {
+	if req.Limit <= 0 || req.Limit > maxOrdersLimit {
+		req.Limit = maxOrdersLimit
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.state(false)
+	if err != nil {
+		return err
+	}
+
+	acc := s.Account(req.Addr)
+	if acc == nil {
+		return unknownAccountError(req.Addr)
+	}
+
+	resp.Orders, resp.Truncated = pendingOrdersPage(acc, req.After, req.Limit)
+	if resp.Truncated {
+		resp.Cursor = resp.Orders[len(resp.Orders)-1].ID
+	}
+	resp.Version = s.Version()
 	return nil
 }
 
@@ -127,15 +573,468 @@ func (r *RPCServer) tokens(_ int, t *TokenState) error {
 	defer r.mu.Unlock()
 
 	if r.s == nil {
-		return errors.New("waiting for reaching consensus")
+		return ErrNotReady
 	}
 
 	t.Tokens = r.s.Tokens()
 	return nil
 }
 
-func (r *RPCServer) sendTxn(t []byte, _ *int) error {
-	go r.sender.SendTxn(t)
+// unknownTokenError is returned when no token has the symbol.
+type unknownTokenError TokenSymbol
+
+func (e unknownTokenError) Error() string {
+	return fmt.Sprintf("token %s does not exist", string(e))
+}
+
+func (r *RPCServer) tokenBySymbol(symbol string, token *Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.state(false)
+	if err != nil {
+		return err
+	}
+
+	// the symbol index is keyed by the normalized symbol
+	t, ok := s.TokenBySymbol(TokenSymbol(symbol))
+	if !ok {
+		return unknownTokenError(symbol)
+	}
+
+	*token = t
+	return nil
+}
+
+// Proof is a Merkle proof anchored at the state root.
+type Proof struct {
+	Root  consensus.Hash
+	Nodes [][]byte
+}
+
+type BalanceProofArg struct {
+	Addr  consensus.Addr
+	Token TokenID
+}
+
+type OrderProofArg struct {
+	ID OrderID
+}
+
+func (r *RPCServer) proveBalance(arg BalanceProofArg, p *Proof) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.state(false)
+	if err != nil {
+		return err
+	}
+
+	// the root and the proof are read from the same snapshot
+	p.Root, p.Nodes, err = s.balanceProof(arg.Addr, arg.Token)
+	return err
+}
+
+func (r *RPCServer) proveOrder(arg OrderProofArg, p *Proof) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.state(false)
+	if err != nil {
+		return err
+	}
+
+	p.Root, p.Nodes, err = s.orderProof(arg.ID.Market, arg.ID)
+	return err
+}
+
+// maxOrderBookLevels is the maximum number of the price levels of
+// each side returned by the OrderBook RPC.
+const maxOrderBookLevels = 500
+
+// OrderBookRequest is the argument of the OrderBook RPC.
+type OrderBookRequest struct {
+	Market MarketSymbol
+	// the maximum number of the price levels of each side, it is
+	// capped by the server, 0 means the cap.
+	Levels int
+}
+
+// OrderBookResponse is the aggregated order book of a market.
+type OrderBookResponse struct {
+	Bids []Level
+	Asks []Level
+	// the last traded price, only valid if HasLastPrice is true
+	LastPrice    uint64
+	HasLastPrice bool
+	// the round of the state that the snapshot is taken from
+	Round uint64
+	// the version of the state that answered the query
+	Version StateVersion
+}
+
+func (r *RPCServer) orderBook(req OrderBookRequest, resp *OrderBookResponse) error {
+	if !req.Market.Valid() {
+		return newRPCError(CodeInvalidRequest, "invalid market %v", req.Market)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.state(false)
+	if err != nil {
+		return err
+	}
+
+	orderBookAt(s, req, resp)
+	return nil
+}
+
+// orderBookAt snapshots the order book from the state, the book of
+// an unknown market is empty.
+func orderBookAt(s *State, req OrderBookRequest, resp *OrderBookResponse) {
+	levels := req.Levels
+	if levels <= 0 || levels > maxOrderBookLevels {
+		levels = maxOrderBookLevels
+	}
+
+	resp.Bids, resp.Asks = s.OrderBookDepth(req.Market, levels)
+	resp.LastPrice, resp.HasLastPrice = s.LastPrice(req.Market)
+	resp.Round = s.round
+	resp.Version = s.Version()
+}
+
+// MarketInfo is a market that ever had an order.
+type MarketInfo struct {
+	Market      MarketSymbol
+	BaseSymbol  TokenSymbol
+	QuoteSymbol TokenSymbol
+	Params      MarketParams
+	// the last traded price, only valid if HasLastPrice is true
+	LastPrice    uint64
+	HasLastPrice bool
+	// true if the order book has a resting order
+	HasOrders bool
+}
+
+func (r *RPCServer) markets(resp *[]MarketInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.state(false)
+	if err != nil {
+		return err
+	}
+
+	tokens := s.tokens()
+	markets := s.Markets()
+	infos := make([]MarketInfo, len(markets))
+	for i, m := range markets {
+		info := MarketInfo{
+			Market:      m,
+			BaseSymbol:  tokens.Info(m.Base).Symbol,
+			QuoteSymbol: tokens.Info(m.Quote).Symbol,
+			Params:      s.MarketParams(m),
+			HasOrders:   s.HasOrders(m),
+		}
+		info.LastPrice, info.HasLastPrice = s.LastPrice(m)
+		infos[i] = info
+	}
+
+	*resp = infos
+	return nil
+}
+
+// maxTradesLimit is the maximum number of the trades returned by
+// the Trades RPC.
+const maxTradesLimit = 1000
+
+func (r *RPCServer) recentTrades(req TradesRequest, resp *TradesResponse) error {
+	if !req.Market.Valid() {
+		return newRPCError(CodeInvalidRequest, "invalid market %v", req.Market)
+	}
+
+	if req.Limit <= 0 || req.Limit > maxTradesLimit {
+		req.Limit = maxTradesLimit
+	}
+
+	resp.Trades = r.trades.trades(req)
+	return nil
+}
+
+type DiffStatesArg struct {
+	A consensus.Hash
+	B consensus.Hash
+	// the maximum number of the returned differences, 0 means
+	// no limit
+	Limit int
+}
+
+func (r *RPCServer) diffStates(arg DiffStatesArg, diffs *[]KeyDiff) error {
+	if !r.admin {
+		return newRPCError(CodeUnsupported, "admin RPC is not enabled")
+	}
+
+	r.mu.Lock()
+	s := r.s
+	r.mu.Unlock()
+
+	if s == nil {
+		return ErrNotReady
+	}
+
+	a, err := s.StateAt(arg.A)
+	if err != nil {
+		return fmt.Errorf("error loading state %v: %v", arg.A, err)
+	}
+
+	b, err := s.StateAt(arg.B)
+	if err != nil {
+		return fmt.Errorf("error loading state %v: %v", arg.B, err)
+	}
+
+	return WalkStateDiff(a, b, func(d KeyDiff) bool {
+		*diffs = append(*diffs, d)
+		return arg.Limit == 0 || len(*diffs) < arg.Limit
+	})
+}
+
+func (r *RPCServer) sendTxn(t []byte, result *AddResult) error {
+	if r.pool == nil {
+		go r.sender.SendTxn(t)
+		return nil
+	}
+
+	_, res, err := r.pool.AddTxn(t)
+	if err != nil {
+		return newRPCError(CodeInvalidTxn, "%v", err)
+	}
+
+	*result = res
+	if res != TxnDuplicate {
+		go r.sender.BroadcastTxn(t)
+	}
+	return nil
+}
+
+// SendTxnResult is the result of the SendTxnV2 RPC.
+type SendTxnResult struct {
+	// the SHA3 hash of the txn, used to look up its status
+	Hash consensus.Hash
+	// Accepted is true if the txn is added to the pool or is
+	// already in the pool, Result tells which.
+	Accepted bool
+	Result   AddResult
+	// the reason that the pool rejected the txn, e.g., bad
+	// signature, unknown owner, oversized or stale nonce.
+	Reason string
+	// the status of the txn when SendTxnWait returns, it is only
+	// set by SendTxnWait for an accepted txn.
+	Status TxnStatusResult
+}
+
+func (r *RPCServer) sendTxnV2(t []byte, result *SendTxnResult) error {
+	result.Hash = consensus.SHA3(t)
+	err := r.sendTxn(t, &result.Result)
+	if err != nil {
+		result.Reason = toRPCError(err).Message
+		return nil
+	}
+
+	result.Accepted = true
+	return nil
+}
+
+// SendTxnRequest is the argument of the SendTxnWait RPC.
+type SendTxnRequest struct {
+	Txn []byte
+	// WaitRounds is the number of rounds to wait for the txn to
+	// be included, it is capped by the server. 0 returns once
+	// the pool accepts the txn, like SendTxnV2.
+	WaitRounds uint
+}
+
+// sendTxnWait sends the txn, and waits for the txn to be included
+// if it is accepted. The wait does not hold r.mu, the waiters of
+// different txns do not block each other.
+func (r *RPCServer) sendTxnWait(req SendTxnRequest, result *SendTxnResult) error {
+	err := r.sendTxnV2(req.Txn, result)
+	if err != nil || !result.Accepted || r.pool == nil {
+		return err
+	}
+
+	if req.WaitRounds == 0 {
+		result.Status = r.pool.TxnStatus(result.Hash)
+		return nil
+	}
+
+	rounds := uint64(req.WaitRounds)
+	if rounds > maxSendTxnWaitRounds {
+		rounds = maxSendTxnWaitRounds
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.sendTxnWaitTimeout)
+	defer cancel()
+	result.Status = r.pool.WaitIncluded(ctx, result.Hash, rounds)
+	return nil
+}
+
+// BlockRequest is the argument of the Block RPC, the block is looked
+// up by Hash if it is set, otherwise by Round. Gob does not send a
+// pointer to the zero value, so a request with neither set asks for
+// the genesis block.
+type BlockRequest struct {
+	Round *uint64
+	Hash  *consensus.Hash
+}
+
+// BlockResponse is a block and the txns included by its proposal.
+type BlockResponse struct {
+	Hash          consensus.Hash
+	Owner         consensus.Addr
+	Round         uint64
+	StateRoot     consensus.Hash
+	BlockProposal consensus.Hash
+	PrevBlock     consensus.Hash
+	Finalized     bool
+	// ProposalRetained is false if the block proposal is no
+	// longer kept by the node, TxnHashes is unknown in this case.
+	ProposalRetained bool
+	TxnHashes        []consensus.Hash
+}
+
+// unknownBlockError is returned when the block or the block
+// proposal is unknown, or is no longer retained.
+type unknownBlockError string
+
+func (e unknownBlockError) Error() string {
+	return string(e)
+}
+
+func (r *RPCServer) block(req BlockRequest, resp *BlockResponse) error {
+	if r.chain == nil {
+		return ErrNotReady
+	}
+
+	var b *consensus.Block
+	var finalized bool
+	if req.Hash != nil {
+		b, finalized = r.chain.Block(*req.Hash)
+		if b == nil {
+			return unknownBlockError(fmt.Sprintf("block %v does not exist", *req.Hash))
+		}
+	} else {
+		var round uint64
+		if req.Round != nil {
+			round = *req.Round
+		}
+
+		b, finalized = r.chain.BlockAtRound(round)
+		if b == nil {
+			return unknownBlockError(fmt.Sprintf("block of round %d does not exist", round))
+		}
+	}
+
+	*resp = BlockResponse{
+		Hash:          b.Hash(),
+		Owner:         b.Owner,
+		Round:         b.Round,
+		StateRoot:     b.StateRoot,
+		BlockProposal: b.BlockProposal,
+		PrevBlock:     b.PrevBlock,
+		Finalized:     finalized,
+	}
+
+	if b.Round == 0 {
+		// the genesis block has no proposal
+		resp.ProposalRetained = true
+		return nil
+	}
+
+	bp := r.chain.BlockProposal(b.BlockProposal)
+	if bp == nil {
+		return nil
+	}
+
+	resp.ProposalRetained = true
+	if len(bp.Txns) == 0 {
+		return nil
+	}
+
+	var txns [][]byte
+	err := rlp.DecodeBytes(bp.Txns, &txns)
+	if err != nil {
+		return fmt.Errorf("error decoding the txns of block proposal %v: %v", b.BlockProposal, err)
+	}
+
+	resp.TxnHashes = make([]consensus.Hash, len(txns))
+	for i, txn := range txns {
+		resp.TxnHashes[i] = consensus.SHA3(txn)
+	}
+	return nil
+}
+
+func (r *RPCServer) blockProposal(h consensus.Hash, bp *consensus.BlockProposal) error {
+	if r.chain == nil {
+		return ErrNotReady
+	}
+
+	p := r.chain.BlockProposal(h)
+	if p == nil {
+		return unknownBlockError(fmt.Sprintf("block proposal %v does not exist", h))
+	}
+
+	*bp = *p
+	return nil
+}
+
+// defaultMaxRawBlockSize caps the size of a RawBlock response.
+const defaultMaxRawBlockSize = 16 << 20
+
+// RawBlockRequest is the argument of the RawBlock RPC.
+type RawBlockRequest struct {
+	Round uint64
+}
+
+// RawBlockResponse is the RLP encoded finalized block of a round.
+type RawBlockResponse struct {
+	// the encoded block with its notarization, its SHA3 hash is
+	// the block hash
+	BlockRLP []byte
+	// the encoded block proposal with its signature, its txns
+	// can be replayed on the state of the previous block. It is
+	// nil for the genesis block or if ProposalPruned is true.
+	ProposalRLP []byte
+	// ProposalPruned is true if the node no longer retains the
+	// block proposal.
+	ProposalPruned bool
+}
+
+func (r *RPCServer) rawBlock(req RawBlockRequest, resp *RawBlockResponse) error {
+	if r.chain == nil {
+		return ErrNotReady
+	}
+
+	b, finalized := r.chain.BlockAtRound(req.Round)
+	if b == nil || !finalized {
+		return unknownBlockError(fmt.Sprintf("finalized block of round %d does not exist", req.Round))
+	}
+
+	*resp = RawBlockResponse{BlockRLP: b.Encode(true)}
+	if b.Round > 0 {
+		bp := r.chain.BlockProposal(b.BlockProposal)
+		if bp == nil {
+			resp.ProposalPruned = true
+		} else {
+			resp.ProposalRLP = bp.Encode(true)
+		}
+	}
+
+	size := len(resp.BlockRLP) + len(resp.ProposalRLP)
+	if size > r.maxRawBlockSize {
+		*resp = RawBlockResponse{}
+		return newRPCError(CodeUnsupported, "the block of round %d is %d bytes, over the cap of %d bytes", req.Round, size, r.maxRawBlockSize)
+	}
 	return nil
 }
 
@@ -145,9 +1044,34 @@ func (r *RPCServer) round(round *uint64) error {
 	return nil
 }
 
-func (r *RPCServer) graphviz(str *string) error {
-	const maxFinalizeBlockPrint = 6
-	*str = r.chain.Graphviz(maxFinalizeBlockPrint)
+const (
+	// defaultGraphvizFinalized is the number of the finalized
+	// blocks shown by the Graphviz RPC when it is not set.
+	defaultGraphvizFinalized = 6
+	// maxGraphvizFinalized caps the finalized blocks shown by
+	// the Graphviz RPC, the chain is locked while the graph is
+	// built.
+	maxGraphvizFinalized = 200
+)
+
+// GraphvizRequest is the argument of the Graphviz RPC.
+type GraphvizRequest struct {
+	// the number of the finalized blocks shown, it is capped by
+	// the server, 0 means the default.
+	MaxFinalized int
+	// label the not finalized blocks with their weights
+	ShowWeights bool
+}
+
+func (r *RPCServer) graphviz(req GraphvizRequest, str *string) error {
+	max := req.MaxFinalized
+	if max <= 0 {
+		max = defaultGraphvizFinalized
+	} else if max > maxGraphvizFinalized {
+		max = maxGraphvizFinalized
+	}
+
+	*str = r.chain.Graphviz(consensus.GraphvizOptions{MaxFinalized: max, ShowWeights: req.ShowWeights})
 	return nil
 }
 
@@ -160,24 +1084,178 @@ func (r *RPCServer) chainStatus(state *consensus.ChainStatus) error {
 	return nil
 }
 
+// NodeInfo is the result of the NodeInfo RPC.
+type NodeInfo struct {
+	ChainID         consensus.Hash
+	Round           uint64
+	FinalizedRound  uint64
+	RandBeaconDepth uint64
+	// InSync is true if the chain caught up with the random
+	// beacon.
+	InSync bool
+	// the fields below are zero if the node status is unknown
+	ListenAddr      string
+	Peers           int
+	Syncing         bool
+	SyncTargetRound uint64
+	DuplicateItems  uint64
+}
+
+func (r *RPCServer) nodeInfo(info *NodeInfo) error {
+	if r.chain == nil {
+		return ErrNotReady
+	}
+
+	status := r.chain.ChainStatus()
+	*info = NodeInfo{
+		ChainID:         status.ChainID,
+		Round:           status.Round,
+		FinalizedRound:  status.FinalizedRound,
+		RandBeaconDepth: status.RandBeaconDepth,
+		InSync:          status.InSync(),
+	}
+
+	if r.node != nil {
+		node := r.node.NodeStatus()
+		info.ListenAddr = node.ListenAddr
+		info.Peers = node.Peers
+		info.Syncing = node.Syncing
+		info.SyncTargetRound = node.SyncTargetRound
+		info.DuplicateItems = node.DuplicateItems
+	}
+	return nil
+}
+
 func (r *RPCServer) nonce(addr consensus.Addr, nonce *uint64) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// TODO: returns a nonce that does not collide with the ones
-	// in the pending txns.
-
 	if r.s == nil {
-		return errors.New("waiting for reaching consensus")
+		return ErrNotReady
 	}
 
 	acc := r.s.Account(addr)
 	if acc == nil {
-		return fmt.Errorf("account %v does not exist", addr)
+		return unknownAccountError(addr)
+	}
+
+	// returns a nonce that does not collide with the ones in the
+	// pending txns, the reservations of NonceSlots are not
+	// considered: anyone can reserve the nonces of an account.
+	*nonce = r.allocNonces(nonceClient{addr: addr}, acc.Nonce(), 1)[0]
+	return nil
+}
+
+// NonceSlotsArg is the argument of the NonceSlots RPC.
+type NonceSlotsArg struct {
+	Addr  consensus.Addr
+	Count int
+	// Token is chosen by the clients of the account, the nonces
+	// reserved for a token are skipped only by the NonceSlots
+	// calls of the same token.
+	Token string
+}
+
+func (r *RPCServer) nonceSlots(arg NonceSlotsArg, slots *[]uint64) error {
+	if arg.Count <= 0 || arg.Count > maxNonceSlots {
+		return newRPCError(CodeInvalidRequest, "slot count should be in [1, %d], count: %d", maxNonceSlots, arg.Count)
+	}
+
+	if arg.Token == "" || len(arg.Token) > maxNonceTokenLen {
+		return newRPCError(CodeInvalidRequest, "slot token length should be in [1, %d], length: %d", maxNonceTokenLen, len(arg.Token))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.s == nil {
+		return ErrNotReady
+	}
+
+	acc := r.s.Account(arg.Addr)
+	if acc == nil {
+		return unknownAccountError(arg.Addr)
+	}
+
+	*slots = r.allocNonces(nonceClient{addr: arg.Addr, token: arg.Token}, acc.Nonce(), arg.Count)
+	return nil
+}
+
+// allocNonces returns the smallest count nonces not smaller than the
+// account's nonce that are not used by the pending txns or reserved
+// for the other clients of the token. The returned nonces are
+// reserved for the token if it is not empty. The caller must hold
+// r.mu.
+func (r *RPCServer) allocNonces(c nonceClient, nonce uint64, count int) []uint64 {
+	var pending []PendingTxnInfo
+	if r.pool != nil {
+		pending = r.pool.PendingForAddr(c.addr)
+	}
+
+	used := make(map[uint64]bool, len(pending))
+	for _, p := range pending {
+		used[p.Nonce] = true
+	}
+
+	now := time.Now()
+	reserved := r.reserved[c]
+	for n, t := range reserved {
+		if n < nonce || used[n] || now.Sub(t) > nonceReservation {
+			// the reserved nonce is used, or the
+			// reservation is expired.
+			delete(reserved, n)
+			continue
+		}
+		used[n] = true
+	}
+
+	nonces := make([]uint64, 0, count)
+	for ; len(nonces) < count; nonce++ {
+		if !used[nonce] {
+			nonces = append(nonces, nonce)
+		}
+	}
+
+	if c.token != "" {
+		if reserved == nil {
+			reserved = make(map[uint64]time.Time)
+			r.reserved[c] = reserved
+		}
+		for _, n := range nonces {
+			reserved[n] = now
+		}
+	}
+
+	if len(reserved) == 0 {
+		delete(r.reserved, c)
+	}
+	return nonces
+}
+
+func (r *RPCServer) txnStatus(hash consensus.Hash, result *TxnStatusResult) error {
+	if r.pool == nil {
+		return newRPCError(CodeUnsupported, "txn status is not available")
+	}
+
+	*result = r.pool.TxnStatus(hash)
+	return nil
+}
+
+func (r *RPCServer) poolStats(stats *PoolStats) error {
+	if r.pool == nil {
+		return newRPCError(CodeUnsupported, "txn pool stats are not available")
 	}
 
-	n := acc.Nonce()
-	*nonce = n
+	*stats = r.pool.Stats()
+	return nil
+}
+
+func (r *RPCServer) pendingTxns(addr consensus.Addr, txns *[]PendingTxnInfo) error {
+	if r.pool == nil {
+		return newRPCError(CodeUnsupported, "pending txns are not available")
+	}
+
+	*txns = r.pool.PendingForAddr(addr)
 	return nil
 }
 
@@ -186,35 +1264,170 @@ type WalletService struct {
 	s *RPCServer
 }
 
+// NewWalletService returns the wallet service of the RPC server, for
+// serving it over another transport.
+func NewWalletService(r *RPCServer) *WalletService {
+	return &WalletService{s: r}
+}
+
 func (s *WalletService) WalletState(addr consensus.Addr, w *WalletState) error {
-	return s.s.walletState(addr, w)
+	return serviceError(s.s.walletState(addr, w))
 }
 
 func (s *WalletService) Tokens(d int, t *TokenState) error {
-	return s.s.tokens(d, t)
+	return serviceError(s.s.tokens(d, t))
+}
+
+// TokenBySymbol returns the token of the symbol, the symbol is
+// case-insensitive.
+func (s *WalletService) TokenBySymbol(symbol string, token *Token) error {
+	return serviceError(s.s.tokenBySymbol(symbol, token))
+}
+
+func (s *WalletService) ProveBalance(arg BalanceProofArg, p *Proof) error {
+	return serviceError(s.s.proveBalance(arg, p))
+}
+
+func (s *WalletService) ProveOrder(arg OrderProofArg, p *Proof) error {
+	return serviceError(s.s.proveOrder(arg, p))
+}
+
+// OrderBook returns the price levels of the market's order book.
+func (s *WalletService) OrderBook(req OrderBookRequest, resp *OrderBookResponse) error {
+	return serviceError(s.s.orderBook(req, resp))
+}
+
+// Orders returns a page of the account's pending orders.
+func (s *WalletService) Orders(req OrdersRequest, resp *OrdersResponse) error {
+	return serviceError(s.s.orders(req, resp))
+}
+
+// Markets returns the markets that ever had an order.
+func (s *WalletService) Markets(_ int, resp *[]MarketInfo) error {
+	return serviceError(s.s.markets(resp))
+}
+
+// Order returns the status of the order, the closed orders are only
+// remembered for a while.
+func (s *WalletService) Order(req OrderRequest, result *OrderResult) error {
+	return serviceError(s.s.order(req, result))
+}
+
+// Trades returns the recent trades of the finalized rounds of the
+// market, newest first.
+func (s *WalletService) Trades(req TradesRequest, resp *TradesResponse) error {
+	return serviceError(s.s.recentTrades(req, resp))
 }
 
-func (s *WalletService) SendTxn(t []byte, d *int) error {
-	return s.s.sendTxn(t, d)
+func (s *WalletService) DiffStates(arg DiffStatesArg, diffs *[]KeyDiff) error {
+	return serviceError(s.s.diffStates(arg, diffs))
+}
+
+// SendTxn sends the txn, the result tells if the txn is added to
+// the pool or replaced the pending txn of the same nonce.
+func (s *WalletService) SendTxn(t []byte, result *AddResult) error {
+	return serviceError(s.s.sendTxn(t, result))
+}
+
+// SendTxnV2 validates the txn against the pool synchronously and
+// broadcasts it if it is accepted. Unlike SendTxn, a rejected txn is
+// not an error, the result has the reason, and the txn hash is
+// returned to look up its status later.
+func (s *WalletService) SendTxnV2(t []byte, result *SendTxnResult) error {
+	return serviceError(s.s.sendTxnV2(t, result))
+}
+
+// SendTxnWait sends the txn like SendTxnV2, and if the txn is
+// accepted, waits for it to be included in a block for at most
+// WaitRounds rounds. The result's Status is TxnIncluded with the
+// including round and block, or TxnPending if the wait timed out.
+func (s *WalletService) SendTxnWait(req SendTxnRequest, result *SendTxnResult) error {
+	return serviceError(s.s.sendTxnWait(req, result))
 }
 
 func (s *WalletService) Nonce(addr consensus.Addr, n *uint64) error {
-	return s.s.nonce(addr, n)
+	return serviceError(s.s.nonce(addr, n))
+}
+
+// NonceSlots returns Count distinct nonces of the account for the
+// clients that submit txns concurrently. An account has a single
+// sequential nonce: the txns are included in the order of their
+// nonces, a txn waits in the pool until the txns of the smaller
+// nonces are included. The returned nonces skip the ones used by the
+// pending txns and the ones handed out to the clients of the same
+// Token in the last 30 seconds, so the txns of different clients do
+// not replace each other. The reservations do not change the nonce
+// returned by Nonce or by NonceSlots of the other tokens.
+func (s *WalletService) NonceSlots(arg NonceSlotsArg, slots *[]uint64) error {
+	return serviceError(s.s.nonceSlots(arg, slots))
+}
+
+// TxnStatus returns the status of the txn with the given hash.
+func (s *WalletService) TxnStatus(hash consensus.Hash, r *TxnStatusResult) error {
+	return serviceError(s.s.txnStatus(hash, r))
+}
+
+func (s *WalletService) PendingTxns(addr consensus.Addr, txns *[]PendingTxnInfo) error {
+	return serviceError(s.s.pendingTxns(addr, txns))
+}
+
+// Block returns the block of the hash or the round. An unfinalized
+// round returns the block on the heaviest fork.
+func (s *WalletService) Block(req BlockRequest, resp *BlockResponse) error {
+	return serviceError(s.s.block(req, resp))
+}
+
+// BalanceAt returns the balance of the account at the finalized
+// round, derived from the nearest retained state snapshot. It returns
+// ErrNotArchiveNode if the node does not retain the snapshots.
+func (s *WalletService) BalanceAt(req BalanceAtRequest, result *BalanceAtResult) error {
+	return serviceError(s.s.balanceAt(req, result))
+}
+
+// EstimateFill estimates the fills of the order by matching it
+// against a copy of the market's order book, no state is changed and
+// no balance is reserved.
+func (s *WalletService) EstimateFill(req EstimateFillRequest, est *FillEstimate) error {
+	return serviceError(s.s.estimateFill(req, est))
+}
+
+// RawBlock returns the RLP encoded finalized block of the round, and
+// its encoded block proposal while the node retains it.
+func (s *WalletService) RawBlock(req RawBlockRequest, resp *RawBlockResponse) error {
+	return serviceError(s.s.rawBlock(req, resp))
+}
+
+// BlockProposal returns the raw block proposal while the node
+// retains it.
+func (s *WalletService) BlockProposal(h consensus.Hash, bp *consensus.BlockProposal) error {
+	return serviceError(s.s.blockProposal(h, bp))
 }
 
 func (s *WalletService) Round(_ int, r *uint64) error {
-	return s.s.round(r)
+	return serviceError(s.s.round(r))
 }
 
 func (s *WalletService) ChainStatus(_ int, state *consensus.ChainStatus) error {
-	return s.s.chainStatus(state)
+	return serviceError(s.s.chainStatus(state))
+}
+
+// NodeInfo returns the chain status with the node's peers and
+// syncing status.
+func (s *WalletService) NodeInfo(_ int, info *NodeInfo) error {
+	return serviceError(s.s.nodeInfo(info))
 }
 
-func (s *WalletService) Graphviz(_ int, str *string) error {
-	return s.s.graphviz(str)
+// Graphviz returns the chain visualization in the Graphviz format.
+func (s *WalletService) Graphviz(req GraphvizRequest, str *string) error {
+	return serviceError(s.s.graphviz(req, str))
 }
 
 func (s *WalletService) TxnPoolSize(_ int, size *int) error {
 	*size = s.s.txnPoolSize()
 	return nil
 }
+
+// PoolStats returns the metrics of the txn pool.
+func (s *WalletService) PoolStats(_ int, stats *PoolStats) error {
+	return serviceError(s.s.poolStats(stats))
+}
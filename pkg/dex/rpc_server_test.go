@@ -0,0 +1,696 @@
+package dex
+
+import (
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNonceSlotsConcurrentClients(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	// a pending txn takes nonce 1
+	_, res, err := pool.AddTxn(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, TxnAdded, res)
+
+	const clients = 2
+	const count = 5
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[uint64]bool)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var slots []uint64
+			err := r.nonceSlots(NonceSlotsArg{Addr: pk.Addr(), Count: count, Token: "client"}, &slots)
+			assert.Nil(t, err)
+			assert.Equal(t, count, len(slots))
+
+			for _, n := range slots {
+				_, res, err := pool.AddTxn(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, n))
+				assert.Nil(t, err)
+				assert.Equal(t, TxnAdded, res)
+
+				mu.Lock()
+				assert.False(t, seen[n])
+				seen[n] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, clients*count, len(seen))
+	assert.False(t, seen[1])
+	assert.Equal(t, clients*count+1, pool.Size())
+
+	var n uint64
+	assert.Nil(t, r.nonce(pk.Addr(), &n))
+	assert.Equal(t, clients*count+1, int(n))
+
+	var slots []uint64
+	assert.NotNil(t, r.nonceSlots(NonceSlotsArg{Addr: pk.Addr(), Count: 0, Token: "client"}, &slots))
+	assert.NotNil(t, r.nonceSlots(NonceSlotsArg{Addr: pk.Addr(), Count: 1}, &slots))
+}
+
+func TestNonceSlotsOtherToken(t *testing.T) {
+	s, pk, _, _, _ := newTIFTestState()
+	s.CommitCache()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	var n uint64
+	assert.Nil(t, r.nonce(pk.Addr(), &n))
+	nonce := n
+
+	var mine []uint64
+	assert.Nil(t, r.nonceSlots(NonceSlotsArg{Addr: pk.Addr(), Count: 2, Token: "mine"}, &mine))
+	assert.Equal(t, []uint64{nonce, nonce + 1}, mine)
+
+	// a third party reserves the account's nonces under its own
+	// token.
+	var theirs []uint64
+	assert.Nil(t, r.nonceSlots(NonceSlotsArg{Addr: pk.Addr(), Count: maxNonceSlots, Token: "theirs"}, &theirs))
+	assert.Equal(t, nonce, theirs[0])
+
+	assert.Nil(t, r.nonce(pk.Addr(), &n))
+	assert.Equal(t, nonce, n)
+
+	assert.Nil(t, r.nonceSlots(NonceSlotsArg{Addr: pk.Addr(), Count: 1, Token: "mine"}, &mine))
+	assert.Equal(t, []uint64{nonce + 2}, mine)
+}
+
+func TestRPCServersIsolated(t *testing.T) {
+	var clients []*rpc.Client
+	for _, symbol := range []TokenSymbol{"AAA", "BBB"} {
+		s := NewState(ethdb.NewMemDatabase())
+		s.UpdateToken(Token{ID: 0, TokenInfo: TokenInfo{Symbol: symbol, Decimals: 8, TotalUnits: 1}})
+		r := NewRPCServer()
+		r.Update(s)
+
+		h, err := r.Handler()
+		assert.Nil(t, err)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		defer l.Close()
+		go http.Serve(l, h)
+
+		c, err := rpc.DialHTTP("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	for i, symbol := range []TokenSymbol{"AAA", "BBB"} {
+		var tokens TokenState
+		err := clients[i].Call("WalletService.Tokens", 0, &tokens)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(tokens.Tokens))
+		assert.Equal(t, symbol, tokens.Tokens[0].Symbol)
+	}
+}
+
+func TestSendTxnV2(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	s.UpdateNonce(pk.Addr(), 1)
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	txn := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 1)
+	var res SendTxnResult
+	assert.Nil(t, r.sendTxnV2(txn, &res))
+	assert.True(t, res.Accepted)
+	assert.Equal(t, TxnAdded, res.Result)
+	assert.Equal(t, consensus.SHA3(txn), res.Hash)
+	assert.Equal(t, "", res.Reason)
+
+	res = SendTxnResult{}
+	assert.Nil(t, r.sendTxnV2(txn, &res))
+	assert.True(t, res.Accepted)
+	assert.Equal(t, TxnDuplicate, res.Result)
+
+	stale := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)
+	res = SendTxnResult{}
+	assert.Nil(t, r.sendTxnV2(stale, &res))
+	assert.False(t, res.Accepted)
+	assert.Equal(t, consensus.SHA3(stale), res.Hash)
+	assert.Contains(t, res.Reason, "nonce")
+
+	_, unknownSK := RandKeyPair()
+	unknown := MakeSendTokenTxn(unknownSK, testChainID, to.Addr(), pk, 0, 1, 0)
+	res = SendTxnResult{}
+	assert.Nil(t, r.sendTxnV2(unknown, &res))
+	assert.False(t, res.Accepted)
+	assert.Equal(t, errUnknownOwner.Error(), res.Reason)
+
+	res = SendTxnResult{}
+	assert.Nil(t, r.sendTxnV2([]byte{1, 2, 3}, &res))
+	assert.False(t, res.Accepted)
+	assert.NotEqual(t, "", res.Reason)
+	assert.Equal(t, 1, pool.Size())
+}
+
+func TestSendTxnWait(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	// the txn is included after two rounds
+	txn := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)
+	done := make(chan SendTxnResult)
+	go func() {
+		var res SendTxnResult
+		assert.Nil(t, r.sendTxnWait(SendTxnRequest{Txn: txn, WaitRounds: 3}, &res))
+		done <- res
+	}()
+
+	for pool.TxnStatus(consensus.SHA3(txn)).Status != TxnPending {
+		time.Sleep(time.Millisecond)
+	}
+	body, err := rlp.EncodeToBytes([][]byte{txn})
+	assert.Nil(t, err)
+	pool.Included(1, consensus.Hash{1}, nil)
+	pool.Included(2, consensus.Hash{2}, body)
+
+	res := <-done
+	assert.True(t, res.Accepted)
+	assert.Equal(t, TxnStatusResult{Status: TxnIncluded, Round: 2, Block: consensus.Hash{2}}, res.Status)
+
+	// the txn is never included, the wait times out
+	r.sendTxnWaitTimeout = 50 * time.Millisecond
+	pending := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 1)
+	start := time.Now()
+	res = SendTxnResult{}
+	assert.Nil(t, r.sendTxnWait(SendTxnRequest{Txn: pending, WaitRounds: 10}, &res))
+	assert.True(t, res.Accepted)
+	assert.Equal(t, TxnPending, res.Status.Status)
+	assert.True(t, time.Since(start) >= r.sendTxnWaitTimeout)
+
+	// the rounds pass without the txn
+	r.sendTxnWaitTimeout = time.Minute
+	go func() {
+		var res SendTxnResult
+		assert.Nil(t, r.sendTxnWait(SendTxnRequest{Txn: pending, WaitRounds: 2}, &res))
+		done <- res
+	}()
+	// the waiter counts the rounds from the latest included
+	// block when it starts waiting
+	for round := uint64(3); ; round++ {
+		pool.Included(round, consensus.Hash{byte(round)}, nil)
+		select {
+		case res = <-done:
+		case <-time.After(10 * time.Millisecond):
+			continue
+		}
+		break
+	}
+	assert.Equal(t, TxnDuplicate, res.Result)
+	assert.Equal(t, TxnPending, res.Status.Status)
+
+	// a rejected txn does not wait
+	res = SendTxnResult{}
+	assert.Nil(t, r.sendTxnWait(SendTxnRequest{Txn: []byte{1, 2, 3}, WaitRounds: 10}, &res))
+	assert.False(t, res.Accepted)
+	assert.Equal(t, TxnUnknown, res.Status.Status)
+}
+
+func TestOrderBookRPC(t *testing.T) {
+	s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+	pk, sk := RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 100})
+	acc.UpdateBalance(1, Balance{Available: 100})
+	s.CommitCache()
+
+	market := MarketSymbol{Base: 0, Quote: 1}
+	trans := s.Transition(1, nil)
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 200000000, Market: market}, 0))
+	recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 5, Price: 200000000, Market: market}, 0))
+	recordTxn(t, trans, pk, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 3, Price: 300000000, Market: market}, 0))
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{Quant: 4, Price: 100000000, Market: market}, 1))
+	// partially fills the maker's sell order
+	recordTxn(t, trans, pk, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 2, Price: 200000000, Market: market}, 1))
+	s = trans.Commit().(*State)
+
+	r := NewRPCServer()
+	r.Update(s)
+
+	var resp OrderBookResponse
+	assert.Nil(t, r.orderBook(OrderBookRequest{Market: market}, &resp))
+	assert.Equal(t, []Level{{Price: 100000000, Quant: 4, Orders: 1}}, resp.Bids)
+	assert.Equal(t, []Level{{Price: 200000000, Quant: 13, Orders: 2}, {Price: 300000000, Quant: 3, Orders: 1}}, resp.Asks)
+	assert.True(t, resp.HasLastPrice)
+	assert.Equal(t, uint64(200000000), resp.LastPrice)
+	assert.Equal(t, uint64(1), resp.Round)
+	assert.Equal(t, s.Version(), resp.Version)
+
+	resp = OrderBookResponse{}
+	assert.Nil(t, r.orderBook(OrderBookRequest{Market: market, Levels: 1}, &resp))
+	assert.Equal(t, 1, len(resp.Asks))
+	assert.Equal(t, 1, len(resp.Bids))
+
+	// unknown market returns an empty book
+	resp = OrderBookResponse{}
+	assert.Nil(t, r.orderBook(OrderBookRequest{Market: MarketSymbol{Base: 0, Quote: 5}, Levels: 1 << 20}, &resp))
+	assert.Nil(t, resp.Bids)
+	assert.Nil(t, resp.Asks)
+	assert.False(t, resp.HasLastPrice)
+
+	assert.NotNil(t, r.orderBook(OrderBookRequest{Market: MarketSymbol{Base: 1, Quote: 1}}, &resp))
+}
+
+type blockTestChain struct {
+	restTestChain
+	blocks    []*consensus.Block
+	finalized int
+	proposals map[consensus.Hash]*consensus.BlockProposal
+}
+
+func (c *blockTestChain) Block(h consensus.Hash) (*consensus.Block, bool) {
+	for i, b := range c.blocks {
+		if b.Hash() == h {
+			return b, i < c.finalized
+		}
+	}
+	return nil, false
+}
+
+func (c *blockTestChain) BlockAtRound(round uint64) (*consensus.Block, bool) {
+	if round >= uint64(len(c.blocks)) {
+		return nil, false
+	}
+	return c.blocks[round], int(round) < c.finalized
+}
+
+func (c *blockTestChain) BlockProposal(h consensus.Hash) *consensus.BlockProposal {
+	return c.proposals[h]
+}
+
+func TestBlockRPC(t *testing.T) {
+	c := &blockTestChain{finalized: 3, proposals: make(map[consensus.Hash]*consensus.BlockProposal)}
+	c.blocks = append(c.blocks, &consensus.Block{})
+	txns := [][]byte{{1}, {2, 3}}
+	body, err := rlp.EncodeToBytes(txns)
+	assert.Nil(t, err)
+	for round, b := range [][]byte{body, nil, nil} {
+		bp := &consensus.BlockProposal{Round: uint64(round + 1), PrevBlock: c.blocks[round].Hash(), Txns: b}
+		if round != 1 {
+			// the proposal of round 2 is pruned
+			c.proposals[bp.Hash()] = bp
+		}
+		c.blocks = append(c.blocks, &consensus.Block{Round: bp.Round, BlockProposal: bp.Hash(), PrevBlock: bp.PrevBlock})
+	}
+
+	r := NewRPCServer()
+	r.SetStater(c)
+
+	var resp BlockResponse
+	one := uint64(1)
+	assert.Nil(t, r.block(BlockRequest{Round: &one}, &resp))
+	assert.Equal(t, c.blocks[1].Hash(), resp.Hash)
+	assert.True(t, resp.Finalized)
+	assert.True(t, resp.ProposalRetained)
+	assert.Equal(t, []consensus.Hash{consensus.SHA3(txns[0]), consensus.SHA3(txns[1])}, resp.TxnHashes)
+
+	h := c.blocks[2].Hash()
+	resp = BlockResponse{}
+	assert.Nil(t, r.block(BlockRequest{Hash: &h}, &resp))
+	assert.Equal(t, uint64(2), resp.Round)
+	assert.True(t, resp.Finalized)
+	assert.False(t, resp.ProposalRetained)
+	assert.Nil(t, resp.TxnHashes)
+
+	three := uint64(3)
+	resp = BlockResponse{}
+	assert.Nil(t, r.block(BlockRequest{Round: &three}, &resp))
+	assert.False(t, resp.Finalized)
+	assert.True(t, resp.ProposalRetained)
+	assert.Equal(t, 0, len(resp.TxnHashes))
+
+	four := uint64(4)
+	err = r.block(BlockRequest{Round: &four}, &resp)
+	assert.IsType(t, unknownBlockError(""), err)
+	unknown := consensus.Hash{1}
+	err = r.block(BlockRequest{Hash: &unknown}, &resp)
+	assert.IsType(t, unknownBlockError(""), err)
+
+	var bp consensus.BlockProposal
+	assert.Nil(t, r.blockProposal(c.blocks[1].BlockProposal, &bp))
+	assert.Equal(t, body, bp.Txns)
+	err = r.blockProposal(c.blocks[2].BlockProposal, &bp)
+	assert.IsType(t, unknownBlockError(""), err)
+
+	// over net/rpc a request with neither the round nor the hash
+	// asks for the genesis block
+	h0, err := r.Handler()
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go http.Serve(l, h0)
+
+	client, err := rpc.DialHTTP("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	zero := uint64(0)
+	resp = BlockResponse{}
+	assert.Nil(t, client.Call("WalletService.Block", BlockRequest{Round: &zero}, &resp))
+	assert.Equal(t, c.blocks[0].Hash(), resp.Hash)
+	assert.True(t, resp.Finalized)
+}
+
+func TestRawBlockRPC(t *testing.T) {
+	c := &blockTestChain{finalized: 3, proposals: make(map[consensus.Hash]*consensus.BlockProposal)}
+	c.blocks = append(c.blocks, &consensus.Block{})
+	body, err := rlp.EncodeToBytes([][]byte{{1}, {2, 3}})
+	assert.Nil(t, err)
+	for round := 0; round < 3; round++ {
+		bp := &consensus.BlockProposal{Round: uint64(round + 1), PrevBlock: c.blocks[round].Hash(), Txns: body}
+		if round != 1 {
+			// the proposal of round 2 is pruned
+			c.proposals[bp.Hash()] = bp
+		}
+		c.blocks = append(c.blocks, &consensus.Block{Round: bp.Round, BlockProposal: bp.Hash(), PrevBlock: bp.PrevBlock})
+	}
+
+	r := NewRPCServer()
+	var resp RawBlockResponse
+	assert.Equal(t, ErrNotReady, r.rawBlock(RawBlockRequest{Round: 1}, &resp))
+	r.SetStater(c)
+
+	assert.Nil(t, r.rawBlock(RawBlockRequest{Round: 1}, &resp))
+	assert.Equal(t, c.blocks[1].Hash(), consensus.SHA3(resp.BlockRLP))
+	assert.False(t, resp.ProposalPruned)
+	var bp consensus.BlockProposal
+	assert.Nil(t, rlp.DecodeBytes(resp.ProposalRLP, &bp))
+	assert.Equal(t, c.blocks[1].BlockProposal, bp.Hash())
+	assert.Equal(t, body, bp.Txns)
+
+	// the pruned proposal is not an error
+	assert.Nil(t, r.rawBlock(RawBlockRequest{Round: 2}, &resp))
+	assert.Equal(t, c.blocks[2].Hash(), consensus.SHA3(resp.BlockRLP))
+	assert.True(t, resp.ProposalPruned)
+	assert.Nil(t, resp.ProposalRLP)
+
+	// the genesis block has no proposal
+	assert.Nil(t, r.rawBlock(RawBlockRequest{Round: 0}, &resp))
+	assert.False(t, resp.ProposalPruned)
+	assert.Nil(t, resp.ProposalRLP)
+
+	// the block of round 3 is not finalized
+	err = r.rawBlock(RawBlockRequest{Round: 3}, &resp)
+	assert.IsType(t, unknownBlockError(""), err)
+	err = r.rawBlock(RawBlockRequest{Round: 4}, &resp)
+	assert.IsType(t, unknownBlockError(""), err)
+
+	r.maxRawBlockSize = len(c.blocks[1].Encode(true))
+	err = r.rawBlock(RawBlockRequest{Round: 1}, &resp)
+	assert.NotNil(t, err)
+	assert.Nil(t, resp.BlockRLP)
+	assert.Nil(t, r.rawBlock(RawBlockRequest{Round: 2}, &resp))
+
+	// the RawBlock calls have their own bucket
+	r.SetRateLimit(RateLimitConfig{Read: RateLimit{Rate: 1, Burst: 5}, RawBlock: RateLimit{Rate: 1, Burst: 1}})
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go http.Serve(l, h)
+
+	client, err := rpc.DialHTTP("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	r.maxRawBlockSize = defaultMaxRawBlockSize
+	assert.Nil(t, client.Call("WalletService.RawBlock", RawBlockRequest{Round: 1}, &resp))
+	err = client.Call("WalletService.RawBlock", RawBlockRequest{Round: 1}, &resp)
+	assert.True(t, IsRateLimited(err))
+	var round uint64
+	assert.Nil(t, client.Call("WalletService.Round", 0, &round))
+}
+
+func TestTokenBySymbol(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	issuer, _ := RandKeyPair()
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	xyz := Token{ID: 1, TokenInfo: TokenInfo{Symbol: "XYZ", Decimals: 8, TotalUnits: 1000, MaxSupply: 2000}, Issuer: issuer.Addr()}
+	s.UpdateToken(xyz)
+	r := NewRPCServer()
+
+	var token Token
+	assert.Equal(t, ErrNotReady, r.tokenBySymbol("XYZ", &token))
+
+	r.Update(s)
+	assert.Nil(t, r.tokenBySymbol("XYZ", &token))
+	assert.Equal(t, xyz, token)
+
+	token = Token{}
+	assert.Nil(t, r.tokenBySymbol("xYz", &token))
+	assert.Equal(t, xyz, token)
+
+	assert.Nil(t, r.tokenBySymbol("bnb", &token))
+	assert.Equal(t, TokenID(0), token.ID)
+
+	err := r.tokenBySymbol("ABC", &token)
+	assert.Equal(t, unknownTokenError("ABC"), err)
+}
+
+type graphvizTestChain struct {
+	restTestChain
+	opts consensus.GraphvizOptions
+}
+
+func (c *graphvizTestChain) Graphviz(opts consensus.GraphvizOptions) string {
+	c.opts = opts
+	return "digraph chain {}"
+}
+
+func TestGraphvizRPC(t *testing.T) {
+	chain := &graphvizTestChain{}
+	r := NewRPCServer()
+	r.SetStater(chain)
+
+	var str string
+	assert.Nil(t, r.graphviz(GraphvizRequest{}, &str))
+	assert.Equal(t, "digraph chain {}", str)
+	assert.Equal(t, consensus.GraphvizOptions{MaxFinalized: defaultGraphvizFinalized}, chain.opts)
+
+	assert.Nil(t, r.graphviz(GraphvizRequest{MaxFinalized: 20, ShowWeights: true}, &str))
+	assert.Equal(t, consensus.GraphvizOptions{MaxFinalized: 20, ShowWeights: true}, chain.opts)
+
+	assert.Nil(t, r.graphviz(GraphvizRequest{MaxFinalized: 1 << 30}, &str))
+	assert.Equal(t, consensus.GraphvizOptions{MaxFinalized: maxGraphvizFinalized}, chain.opts)
+}
+
+type nodeInfoTestChain struct {
+	restTestChain
+}
+
+func (nodeInfoTestChain) ChainStatus() consensus.ChainStatus {
+	return consensus.ChainStatus{ChainID: consensus.Hash{1}, Round: 11, FinalizedRound: 9, RandBeaconDepth: 11}
+}
+
+type testNodeStater consensus.NodeStatus
+
+func (n testNodeStater) NodeStatus() consensus.NodeStatus {
+	return consensus.NodeStatus(n)
+}
+
+func TestNodeInfoRPC(t *testing.T) {
+	r := NewRPCServer()
+	var info NodeInfo
+	assert.Equal(t, ErrNotReady, r.nodeInfo(&info))
+
+	r.SetStater(nodeInfoTestChain{})
+	assert.Nil(t, r.nodeInfo(&info))
+	assert.Equal(t, NodeInfo{ChainID: consensus.Hash{1}, Round: 11, FinalizedRound: 9, RandBeaconDepth: 11, InSync: true}, info)
+
+	r.SetNodeStater(testNodeStater{ListenAddr: "127.0.0.1:11001", Peers: 3, Syncing: true, SyncTargetRound: 12, DuplicateItems: 5})
+	assert.Nil(t, r.nodeInfo(&info))
+	assert.Equal(t, NodeInfo{
+		ChainID:         consensus.Hash{1},
+		Round:           11,
+		FinalizedRound:  9,
+		RandBeaconDepth: 11,
+		InSync:          true,
+		ListenAddr:      "127.0.0.1:11001",
+		Peers:           3,
+		Syncing:         true,
+		SyncTargetRound: 12,
+		DuplicateItems:  5,
+	}, info)
+
+	r.SetStater(syncingTestChain{})
+	assert.Nil(t, r.nodeInfo(&info))
+	assert.False(t, info.InSync)
+}
+
+func TestMarketsRPC(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: TokenInfo{Symbol: "XYZ", Decimals: 8, TotalUnits: 1000}})
+	s.UpdateToken(Token{ID: 2, TokenInfo: TokenInfo{Symbol: "ABC", Decimals: 8, TotalUnits: 1000}})
+	params := MarketParams{TickSize: 100, LotSize: 2, MinNotional: 1}
+	xyz := MarketSymbol{Base: 1, Quote: 0}
+	abc := MarketSymbol{Base: 2, Quote: 0}
+	s.UpdateMarketParams(abc, params)
+	pkMaker, skMaker := RandKeyPair()
+	pkTaker, skTaker := RandKeyPair()
+	maker := s.NewAccount(pkMaker)
+	maker.UpdateBalance(1, Balance{Available: 100})
+	maker.UpdateBalance(2, Balance{Available: 100})
+	taker := s.NewAccount(pkTaker)
+	taker.UpdateBalance(0, Balance{Available: 100})
+	r := NewRPCServer()
+
+	var markets []MarketInfo
+	assert.Equal(t, ErrNotReady, r.markets(&markets))
+
+	trans := s.Transition(1, nil)
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: xyz}, 0))
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: abc}, 1))
+	recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 10, Price: 100000000, Market: abc}, 0))
+	s = trans.Commit().(*State)
+	r.Update(s)
+
+	assert.Nil(t, r.markets(&markets))
+	assert.Equal(t, 2, len(markets))
+	byBase := make(map[TokenID]MarketInfo)
+	for _, m := range markets {
+		byBase[m.Market.Base] = m
+	}
+
+	assert.Equal(t, MarketInfo{
+		Market:      xyz,
+		BaseSymbol:  "XYZ",
+		QuoteSymbol: "BNB",
+		Params:      DefaultMarketParams,
+		HasOrders:   true,
+	}, byBase[1])
+	assert.Equal(t, MarketInfo{
+		Market:       abc,
+		BaseSymbol:   "ABC",
+		QuoteSymbol:  "BNB",
+		Params:       params,
+		LastPrice:    100000000,
+		HasLastPrice: true,
+	}, byBase[2])
+}
+
+func TestWalletStateFrozen(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	trans := s.Transition(1, nil)
+	recordTxn(t, trans, pk, MakeFreezeTokenTxn(sk, testChainID, pk.Addr(), FreezeTokenTxn{TokenID: 0, AvailableRound: 5, Quant: 10}, 0))
+	recordTxn(t, trans, pk, MakeFreezeTokenTxn(sk, testChainID, pk.Addr(), FreezeTokenTxn{TokenID: 0, AvailableRound: 3, Quant: 20}, 1))
+	s = trans.Commit().(*State)
+	r := NewRPCServer()
+	r.Update(s)
+
+	var w WalletState
+	assert.Nil(t, r.walletState(pk.Addr(), &w))
+	assert.Equal(t, uint64(1), w.Round)
+	assert.Equal(t, TokenID(0), w.Balances[0].Token)
+	assert.Equal(t, uint64(70), w.Balances[0].Available)
+	assert.Equal(t, []Frozen{{AvailableRound: 5, Quant: 10}, {AvailableRound: 3, Quant: 20}}, w.Balances[0].Frozen)
+
+	// the tokens available at round 3 are released by the
+	// transition of round 2
+	s = s.Transition(2, nil).Commit().(*State)
+	r.Update(s)
+	w = WalletState{}
+	assert.Nil(t, r.walletState(pk.Addr(), &w))
+	assert.Equal(t, uint64(2), w.Round)
+	assert.Equal(t, uint64(90), w.Balances[0].Available)
+	assert.Equal(t, []Frozen{{AvailableRound: 5, Quant: 10}}, w.Balances[0].Frozen)
+}
+
+func TestWalletStateTruncated(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	trans := s.Transition(1, nil)
+	var nonce uint64
+	for _, m := range []MarketSymbol{{Base: 1, Quote: 0}, {Base: 0, Quote: 1}} {
+		n := 2
+		if m.Base == 0 {
+			n = 3
+		}
+
+		for i := 0; i < n; i++ {
+			recordTxn(t, trans, pk, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: uint64(i+1) * 100000000, Market: m}, nonce))
+			nonce++
+		}
+	}
+	s = trans.Commit().(*State)
+	r := NewRPCServer()
+	r.SetWalletStateOrders(2)
+	r.Update(s)
+
+	ids := func(orders []PendingOrder) []OrderID {
+		r := make([]OrderID, len(orders))
+		for i, o := range orders {
+			r[i] = o.ID
+		}
+		return r
+	}
+	m01 := MarketSymbol{Base: 0, Quote: 1}
+	m10 := MarketSymbol{Base: 1, Quote: 0}
+
+	var w WalletState
+	assert.Nil(t, r.walletState(pk.Addr(), &w))
+	assert.Equal(t, 2, len(w.Balances))
+	assert.Equal(t, []OrderID{{ID: 0, Market: m01}, {ID: 1, Market: m01}}, ids(w.PendingOrders))
+	assert.True(t, w.Truncated)
+	assert.Equal(t, OrderID{ID: 1, Market: m01}, w.Cursor)
+
+	var resp OrdersResponse
+	assert.Nil(t, r.orders(OrdersRequest{Addr: pk.Addr(), After: w.Cursor, Limit: 2}, &resp))
+	assert.Equal(t, []OrderID{{ID: 2, Market: m01}, {ID: 0, Market: m10}}, ids(resp.Orders))
+	assert.True(t, resp.Truncated)
+
+	cursor := resp.Cursor
+	resp = OrdersResponse{}
+	assert.Nil(t, r.orders(OrdersRequest{Addr: pk.Addr(), After: cursor, Limit: 2}, &resp))
+	assert.Equal(t, []OrderID{{ID: 1, Market: m10}}, ids(resp.Orders))
+	assert.False(t, resp.Truncated)
+
+	resp = OrdersResponse{}
+	assert.Nil(t, r.orders(OrdersRequest{Addr: pk.Addr()}, &resp))
+	assert.Equal(t, 5, len(resp.Orders))
+	assert.False(t, resp.Truncated)
+
+	unknown, _ := RandKeyPair()
+	assert.Equal(t, unknownAccountError(unknown.Addr()), r.orders(OrdersRequest{Addr: unknown.Addr()}, &resp))
+}
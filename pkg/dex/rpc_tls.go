@@ -0,0 +1,80 @@
+package dex
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// ErrClientCertRequired is returned when a call that changes the
+// state is made without a verified client certificate, while the
+// server requires one.
+var ErrClientCertRequired = &RPCError{Code: CodeUnauthorized, Message: "a verified client certificate is required"}
+
+// LoadTLSConfig loads the TLS config of the RPC server from the PEM
+// encoded certificate and key files. If clientCAFile is not empty,
+// the client certificates are verified against the CAs in it.
+func LoadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// SetTLS serves the RPC server over TLS. If requireClientCert is
+// true, the calls that change the state need a client certificate
+// verified against cfg.ClientCAs, the read-only calls do not. The
+// server is served in plain HTTP if SetTLS is not called, which
+// should only be done when the server listens on localhost. It must
+// be called before Start.
+func (r *RPCServer) SetTLS(cfg *tls.Config, requireClientCert bool) {
+	if requireClientCert && cfg.ClientAuth == tls.NoClientCert {
+		cfg = cfg.Clone()
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	r.tls = cfg
+	r.requireClientCert = requireClientCert
+}
+
+// TLSConfig returns the TLS config set by SetTLS, nil if the server
+// is served in plain HTTP.
+func (r *RPCServer) TLSConfig() *tls.Config {
+	return r.tls
+}
+
+// checkClientCert is the callCheck that requires a verified client
+// certificate for the calls that change the state.
+func checkClientCert(req *http.Request, method string) error {
+	if isMutatingMethod(method) && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+		return ErrClientCertRequired
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package dex
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCert creates a certificate for 127.0.0.1 signed by the
+// parent, or a self-signed CA certificate if parent is nil.
+func newTestCert(t *testing.T, serial int64, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "dex test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer = parent.Leaf
+		signerKey = parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestRPCOverTLS(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetSender(nopSender{})
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	ca := newTestCert(t, 1, nil)
+	clientCert := newTestCert(t, 2, &ca)
+	certPool := x509.NewCertPool()
+	certPool.AddCert(ca.Leaf)
+	r.SetTLS(&tls.Config{Certificates: []tls.Certificate{ca}, ClientCAs: certPool}, true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	assert.Nil(t, r.Start(addr))
+
+	client, err := DialRPC(addr, &tls.Config{RootCAs: certPool}, "")
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var w WalletState
+	assert.Nil(t, client.Call("WalletService.WalletState", pk.Addr(), &w))
+	assert.Equal(t, 2, len(w.Balances))
+
+	// the txn needs a client certificate
+	txn := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)
+	var res SendTxnResult
+	err = client.Call("WalletService.SendTxnV2", txn, &res)
+	assert.Equal(t, ErrClientCertRequired.Error(), err.Error())
+
+	certClient, err := DialRPC(addr, &tls.Config{RootCAs: certPool, Certificates: []tls.Certificate{clientCert}}, "")
+	assert.Nil(t, err)
+	defer certClient.Close()
+	assert.Nil(t, certClient.Call("WalletService.SendTxnV2", txn, &res))
+	assert.True(t, res.Accepted)
+
+	// the plain HTTP client is rejected
+	plain, err := rpc.DialHTTP("tcp", addr)
+	if err == nil {
+		err = plain.Call("WalletService.WalletState", pk.Addr(), &w)
+		plain.Close()
+	}
+	assert.NotNil(t, err)
+}
@@ -26,6 +26,44 @@ func serializeTrie(t *trie.Trie, db *trie.Database, getter getter) (blob consens
 		return
 	}
 
+	blob, err = collectNodes(iter, getter)
+	if err != nil {
+		return
+	}
+
+	blob.Root = consensus.Hash(root)
+	return
+}
+
+// serializeTrieDiff serializes the nodes of t that are not reachable
+// from the old trie.
+func serializeTrieDiff(t, old *trie.Trie, db *trie.Database, getter getter) (blob consensus.TrieBlob, err error) {
+	root, err := t.Commit(nil)
+	if err != nil {
+		return
+	}
+
+	iter, _ := trie.NewDifferenceIterator(old.NodeIterator([]byte{}), t.NodeIterator([]byte{}))
+	if iter.Error() != nil {
+		err = iter.Error()
+		return
+	}
+
+	err = db.Commit(root, false)
+	if err != nil {
+		return
+	}
+
+	blob, err = collectNodes(iter, getter)
+	if err != nil {
+		return
+	}
+
+	blob.Root = consensus.Hash(root)
+	return
+}
+
+func collectNodes(iter trie.NodeIterator, getter getter) (blob consensus.TrieBlob, err error) {
 	blob = consensus.TrieBlob{Data: make(map[consensus.Hash][]byte)}
 	hasNext := true
 	for ; hasNext; hasNext = iter.Next(true) {
@@ -35,7 +73,6 @@ func serializeTrie(t *trie.Trie, db *trie.Database, getter getter) (blob consens
 		}
 
 		h := consensus.Hash(iter.Hash())
-		var d []byte
 		d, err := getter.Get(h[:])
 		if err != nil {
 			continue
@@ -43,7 +80,5 @@ func serializeTrie(t *trie.Trie, db *trie.Database, getter getter) (blob consens
 
 		blob.Data[h] = d
 	}
-
-	blob.Root = consensus.Hash(root)
 	return
 }
@@ -0,0 +1,88 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	benchTrieSize  = 10000
+	benchBlockSize = 100
+)
+
+func genBenchTrie() (*trie.Trie, *trie.Database, getter) {
+	getter := ethdb.NewMemDatabase()
+	db := trie.NewDatabase(getter)
+	t, err := trie.New(common.Hash{}, db)
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < benchTrieSize; i++ {
+		key := consensus.SHA3([]byte{byte(i >> 16), byte(i >> 8), byte(i)})
+		val := consensus.SHA3(key[:])
+		t.Update(key[:], val[:])
+	}
+
+	return t, db, getter
+}
+
+// updateBenchTrie simulates the changes of one block.
+func updateBenchTrie(t *trie.Trie, n int) {
+	for i := 0; i < benchBlockSize; i++ {
+		key := consensus.SHA3([]byte{byte(i >> 16), byte(i >> 8), byte(i)})
+		val := consensus.SHA3(key[:], []byte{byte(n >> 8), byte(n)})
+		t.Update(key[:], val[:])
+	}
+}
+
+func blobSize(b consensus.TrieBlob) int {
+	size := 0
+	for _, v := range b.Data {
+		size += len(v)
+	}
+	return size
+}
+
+func BenchmarkSerializeTrie(b *testing.B) {
+	t, db, getter := genBenchTrie()
+	b.ResetTimer()
+	var blob consensus.TrieBlob
+	for i := 0; i < b.N; i++ {
+		updateBenchTrie(t, i)
+		var err error
+		blob, err = serializeTrie(t, db, getter)
+		if err != nil {
+			panic(err)
+		}
+	}
+	b.Logf("full blob: %d nodes, %d bytes", len(blob.Data), blobSize(blob))
+}
+
+func BenchmarkSerializeTrieDiff(b *testing.B) {
+	t, db, getter := genBenchTrie()
+	_, err := serializeTrie(t, db, getter)
+	if err != nil {
+		panic(err)
+	}
+
+	b.ResetTimer()
+	var blob consensus.TrieBlob
+	for i := 0; i < b.N; i++ {
+		old, err := trie.New(t.Hash(), db)
+		if err != nil {
+			panic(err)
+		}
+
+		updateBenchTrie(t, i)
+		blob, err = serializeTrieDiff(t, old, db, getter)
+		if err != nil {
+			panic(err)
+		}
+	}
+	b.Logf("diff blob: %d nodes, %d bytes", len(blob.Data), blobSize(blob))
+}
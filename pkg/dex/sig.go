@@ -33,8 +33,13 @@ func (p PK) Addr() consensus.Addr {
 }
 
 func (s SK) Sign(msg []byte) Sig {
-	in := consensus.SHA3(msg)
-	sig, err := secp256k1.Sign(in[:], s)
+	return s.SignHash(consensus.SHA3(msg))
+}
+
+// SignHash signs the digest, it is used to sign the digest returned
+// by the Build*Txn helpers.
+func (s SK) SignHash(h consensus.Hash) Sig {
+	sig, err := secp256k1.Sign(h[:], s)
 	if err != nil {
 		panic(err)
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -41,14 +42,59 @@ func (m *MarketSymbol) Decode(b []byte) (int, error) {
 	return n0 + n1, nil
 }
 
+// MarketParams are the trading rules of a market.
+type MarketParams struct {
+	// the order price must be a multiple of TickSize
+	TickSize uint64
+	// the order quantity must be a multiple of LotSize
+	LotSize uint64
+	// the minimal order value in the quote token's units
+	MinNotional uint64
+}
+
+// DefaultMarketParams applies to the markets without their own
+// parameters.
+var DefaultMarketParams = MarketParams{TickSize: 1, LotSize: 1, MinNotional: 1}
+
+// Valid checks if the market parameters are valid.
+func (p MarketParams) Valid() bool {
+	return p.TickSize > 0 && p.LotSize > 0
+}
+
+// TradingFee is the fee charged on each order execution, the fee is
+// in the quote token for both sides of the execution: it is deducted
+// from the seller's proceeds and added to the buyer's cost.
+type TradingFee struct {
+	// fee rates in basis points
+	MakerBps uint64
+	TakerBps uint64
+	// the account that collects the fee
+	Pool PK
+}
+
+// Valid checks if the trading fee configuration is valid.
+func (f TradingFee) Valid() bool {
+	return f.MakerBps <= 10000 && f.TakerBps <= 10000 && len(f.Pool) > 0
+}
+
 // State is the state of the DEX.
 type State struct {
 	db     *trie.Database
 	diskDB ethdb.Database
+	// the ID of the chain that the txns are signed for, it is
+	// inherited by the derived states.
+	chainID consensus.Hash
 
 	mu           sync.Mutex
 	trie         *trie.Trie
 	accountCache map[consensus.Addr]*Account
+	// built lazily from the trie, nil means not built yet
+	tokenCache *TokenCache
+	// the events of the transition that produced the state, nil
+	// if the state is not produced by a transition.
+	events *StateEvents
+	// the round of the transition that produced the state
+	round uint64
 }
 
 var BNBInfo = TokenInfo{
@@ -57,7 +103,33 @@ var BNBInfo = TokenInfo{
 	TotalUnits: 200000000 * 100000000,
 }
 
+// GenesisParams are the chain parameters set in the genesis state.
+type GenesisParams struct {
+	// no trading fee is charged if nil
+	TradingFee *TradingFee
+	// the minimal fee of a txn in the smallest unit of BNB
+	MinTxnFee uint64
+	// the trading rules of the markets, the other markets use
+	// DefaultMarketParams
+	Markets []GenesisMarket
+}
+
+// GenesisMarket is the trading rules of a market in the genesis
+// state.
+type GenesisMarket struct {
+	Market MarketSymbol
+	Params MarketParams
+}
+
+// CreateGenesisState creates the genesis state with the default
+// chain parameters.
 func CreateGenesisState(recipients []PK, additionalTokens []TokenInfo) *State {
+	return CreateGenesisStateWithParams(recipients, additionalTokens, GenesisParams{})
+}
+
+// CreateGenesisStateWithParams creates the genesis state, BNB and
+// the additional tokens are evenly distributed to the recipients.
+func CreateGenesisStateWithParams(recipients []PK, additionalTokens []TokenInfo, params GenesisParams) *State {
 	memDB := ethdb.NewMemDatabase()
 	s := NewState(memDB)
 	tokens := make([]Token, len(additionalTokens)+1)
@@ -76,18 +148,76 @@ func CreateGenesisState(recipients []PK, additionalTokens []TokenInfo) *State {
 		s.UpdateToken(t)
 	}
 
-	for _, pk := range recipients {
-		account := s.NewAccount(pk)
-		for _, t := range tokens {
-			avg := t.TotalUnits / uint64(len(recipients))
-			account.UpdateBalance(t.ID, Balance{Available: avg})
+	// distribute the remainder to the first recipients ordered
+	// by address, so that the genesis state is deterministic.
+	sorted := make([]PK, len(recipients))
+	copy(sorted, recipients)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].Addr(), sorted[j].Addr()
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+
+	accounts := make([]*Account, len(sorted))
+	for i, pk := range sorted {
+		accounts[i] = s.NewAccount(pk)
+	}
+
+	for _, t := range tokens {
+		for i, quant := range genesisAllocations(t.TotalUnits, len(accounts)) {
+			accounts[i].UpdateBalance(t.ID, Balance{Available: quant})
+		}
+	}
+
+	if params.TradingFee != nil {
+		s.UpdateTradingFee(*params.TradingFee)
+	}
+
+	if params.MinTxnFee > 0 {
+		s.UpdateMinTxnFee(params.MinTxnFee)
+	}
+
+	for _, m := range params.Markets {
+		if !m.Market.Valid() || int(m.Market.Base) >= len(tokens) || int(m.Market.Quote) >= len(tokens) {
+			panic(fmt.Errorf("invalid genesis market: %v", m.Market))
+		}
+
+		if !m.Params.Valid() {
+			panic(fmt.Errorf("invalid genesis market params: %v", m.Params))
 		}
+
+		s.UpdateMarketParams(m.Market, m.Params)
 	}
 
 	s.CommitCache()
 	return s
 }
 
+// genesisAllocations splits total into n allocations, the first
+// total % n allocations get one extra unit.
+func genesisAllocations(total uint64, n int) []uint64 {
+	if n == 0 {
+		return nil
+	}
+
+	avg := total / uint64(n)
+	remainder := total % uint64(n)
+	r := make([]uint64, n)
+	var sum uint64
+	for i := range r {
+		r[i] = avg
+		if uint64(i) < remainder {
+			r[i]++
+		}
+		sum += r[i]
+	}
+
+	if sum != total {
+		panic(fmt.Errorf("genesis allocations sum to %d, total units: %d", sum, total))
+	}
+
+	return r
+}
+
 func newState(state *trie.Trie, db *trie.Database, diskDB ethdb.Database) *State {
 	return &State{
 		diskDB:       diskDB,
@@ -97,6 +227,17 @@ func newState(state *trie.Trie, db *trie.Database, diskDB ethdb.Database) *State
 	}
 }
 
+// SetChainID sets the ID of the chain, it must be called before
+// deriving the states from the state.
+func (s *State) SetChainID(id consensus.Hash) {
+	s.chainID = id
+}
+
+// ChainID returns the ID of the chain that the txns are signed for.
+func (s *State) ChainID() consensus.Hash {
+	return s.chainID
+}
+
 func NewState(diskDB ethdb.Database) *State {
 	db := trie.NewDatabase(diskDB)
 	t, err := trie.New(common.Hash{}, db)
@@ -118,6 +259,15 @@ var (
 	pendingOrdersPrefix    = []byte{7}
 	executionReportsPrefix = []byte{8}
 	reportIdxPrefix        = []byte{9}
+	tokenSymbolPrefix      = []byte{10}
+	receiptPrefix          = []byte{11}
+	lastPricePrefix        = []byte{12}
+	stopOrderPrefix        = []byte{13}
+	marketParamsPrefix     = []byte{14}
+	tradingFeePath         = []byte{15}
+	minTxnFeePath          = []byte{16}
+	accountPolicyPrefix    = []byte{17}
+	marketsPrefix          = []byte{18}
 )
 
 func addrReportIdxPath(addr consensus.Addr) []byte {
@@ -134,6 +284,10 @@ func addrPKPath(addr consensus.Addr) []byte {
 	return append(pkPrefix, addr[:]...)
 }
 
+func addrPolicyPath(addr consensus.Addr) []byte {
+	return append(accountPolicyPrefix, addr[:]...)
+}
+
 func addrNoncePath(addr consensus.Addr) []byte {
 	return append(noncePrefix, addr[:]...)
 }
@@ -179,6 +333,37 @@ func tokenPath(tokenID TokenID) []byte {
 	return append(tokenPrefix, path...)
 }
 
+func tokenSymbolPath(symbol TokenSymbol) []byte {
+	return append(tokenSymbolPrefix, []byte(symbol.Normalize())...)
+}
+
+func receiptPath(txn consensus.Hash) []byte {
+	return append(receiptPrefix, txn[:]...)
+}
+
+func marketParamsPath(m MarketSymbol) []byte {
+	return append(marketParamsPrefix, m.Encode()...)
+}
+
+func lastPricePath(m MarketSymbol) []byte {
+	return append(lastPricePrefix, m.Encode()...)
+}
+
+func marketsPath(m MarketSymbol) []byte {
+	return append(marketsPrefix, m.Encode()...)
+}
+
+func stopOrdersPath(m MarketSymbol) []byte {
+	return append(stopOrderPrefix, m.Encode()...)
+}
+
+func stopOrderPath(m MarketSymbol, id uint64) []byte {
+	// big endian to iterate the stop orders in the order of ID
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return append(stopOrdersPath(m), b...)
+}
+
 func marketPath(path []byte) []byte {
 	return append(marketPrefix, path...)
 }
@@ -237,6 +422,14 @@ func (s *State) NewAccount(pk PK) *Account {
 	return account
 }
 
+// removeAccountCache drops the cached account that is not yet
+// committed.
+func (s *State) removeAccountCache(addr consensus.Addr) {
+	s.mu.Lock()
+	delete(s.accountCache, addr)
+	s.mu.Unlock()
+}
+
 func (s *State) pk(addr consensus.Addr) (PK, bool) {
 	b := s.trie.Get(addrPKPath(addr))
 	if len(b) == 0 {
@@ -246,13 +439,15 @@ func (s *State) pk(addr consensus.Addr) (PK, bool) {
 	return PK(b), true
 }
 
+// PK returns the public key of the account, nil is returned if the
+// account does not exist.
 func (s *State) PK(addr consensus.Addr) PK {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	pk, ok := s.pk(addr)
 	if !ok {
-		panic(fmt.Errorf("account for address %v does not exist", addr))
+		return nil
 	}
 	return pk
 }
@@ -414,6 +609,36 @@ func (s *State) AddExecutionReport(addr consensus.Addr, e ExecutionReport, idx u
 	s.mu.Unlock()
 }
 
+func (s *State) UpdateReceipt(txn consensus.Hash, r Receipt) {
+	b, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(receiptPath(txn), b)
+	s.mu.Unlock()
+}
+
+// Receipt returns the receipt of the txn with the given hash.
+func (s *State) Receipt(txn consensus.Hash) (Receipt, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(receiptPath(txn))
+	if len(b) == 0 {
+		return Receipt{}, false
+	}
+
+	var r Receipt
+	err := rlp.DecodeBytes(b, &r)
+	if err != nil {
+		panic(err)
+	}
+
+	return r, true
+}
+
 func (s *State) ExecutionReports(addr consensus.Addr) []ExecutionReport {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -469,6 +694,88 @@ func (s *State) UpdateToken(token Token) {
 	}
 
 	s.trie.Update(path, b)
+
+	id, err := rlp.EncodeToBytes(uint64(token.ID))
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(tokenSymbolPath(token.Symbol), id)
+	s.tokenCache = nil
+}
+
+// tokens returns the token cache of the state, the returned cache
+// should not be modified, fork it to make changes.
+func (s *State) tokens() *TokenCache {
+	s.mu.Lock()
+	c := s.tokenCache
+	s.mu.Unlock()
+
+	if c != nil {
+		return c
+	}
+
+	c = newTokenCache(s)
+	s.mu.Lock()
+	s.tokenCache = c
+	s.mu.Unlock()
+	return c
+}
+
+func (s *State) setTokenCache(c *TokenCache) {
+	s.mu.Lock()
+	s.tokenCache = c
+	s.mu.Unlock()
+}
+
+// Token returns the token of the given ID.
+func (s *State) Token(id TokenID) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(tokenPath(id))
+	if len(b) == 0 {
+		return Token{}, false
+	}
+
+	var token Token
+	err := rlp.DecodeBytes(b, &token)
+	if err != nil {
+		panic(err)
+	}
+
+	return token, true
+}
+
+// TokenBySymbol returns the token of the given symbol, the symbol
+// is case-insensitive.
+func (s *State) TokenBySymbol(symbol TokenSymbol) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(tokenSymbolPath(symbol))
+	if len(b) == 0 {
+		return Token{}, false
+	}
+
+	var id uint64
+	err := rlp.DecodeBytes(b, &id)
+	if err != nil {
+		panic(err)
+	}
+
+	b = s.trie.Get(tokenPath(TokenID(id)))
+	if len(b) == 0 {
+		return Token{}, false
+	}
+
+	var token Token
+	err = rlp.DecodeBytes(b, &token)
+	if err != nil {
+		panic(err)
+	}
+
+	return token, true
 }
 
 func (s *State) Account(addr consensus.Addr) *Account {
@@ -514,6 +821,51 @@ func (s *State) loadOrderBook(m MarketSymbol) *orderBook {
 	return &book
 }
 
+// OrderBookDepth returns the aggregated price levels of the market's
+// order book, at most levels levels for each side, levels <= 0 means
+// no limit. The book of an unknown market is empty.
+func (s *State) OrderBookDepth(m MarketSymbol, levels int) (bids, asks []Level) {
+	book := s.loadOrderBook(m)
+	if book == nil {
+		return nil, nil
+	}
+
+	return book.Depth(Bid, levels), book.Depth(Ask, levels)
+}
+
+// OpenOrder returns the order that rests on the order book, or the
+// untriggered stop order, with the given ID.
+func (s *State) OpenOrder(id OrderID) (PendingOrder, bool) {
+	var owner consensus.Addr
+	book := s.loadOrderBook(id.Market)
+	if e, ok := book.entry(id.ID); ok {
+		owner = e.Owner
+	} else {
+		s.mu.Lock()
+		b := s.trie.Get(stopOrderPath(id.Market, id.ID))
+		s.mu.Unlock()
+		if len(b) == 0 {
+			return PendingOrder{}, false
+		}
+
+		var o stopOrder
+		err := rlp.DecodeBytes(b, &o)
+		if err != nil {
+			panic(err)
+		}
+		owner = o.Owner
+	}
+
+	return s.PendingOrder(owner, id)
+}
+
+// HasOrders returns true if the market's order book has a resting
+// order.
+func (s *State) HasOrders(m MarketSymbol) bool {
+	book := s.loadOrderBook(m)
+	return book != nil && (book.bids.best() != nil || book.asks.best() != nil)
+}
+
 func (s *State) saveOrderBook(m MarketSymbol, book *orderBook) {
 	b, err := rlp.EncodeToBytes(book)
 	if err != nil {
@@ -574,6 +926,19 @@ func (s *State) Serialize() (consensus.TrieBlob, error) {
 	return serializeTrie(s.trie, s.db, s.db.DiskDB())
 }
 
+// SerializeDiff serializes only the trie nodes that are not
+// reachable from the state root sinceRoot. It falls back to a full
+// serialization when sinceRoot is unknown to the state's database.
+func (s *State) SerializeDiff(sinceRoot consensus.Hash) (consensus.TrieBlob, error) {
+	s.CommitCache()
+	old, err := trie.New(common.Hash(sinceRoot), s.db)
+	if err != nil {
+		return serializeTrie(s.trie, s.db, s.db.DiskDB())
+	}
+
+	return serializeTrieDiff(s.trie, old, s.db, s.db.DiskDB())
+}
+
 func (s *State) Deserialize(b consensus.TrieBlob) error {
 	err := b.Fill(s.diskDB)
 	if err != nil {
@@ -586,8 +951,25 @@ func (s *State) Deserialize(b consensus.TrieBlob) error {
 		return err
 	}
 
+	s.mu.Lock()
 	s.trie = t
 	s.db = db
+	s.tokenCache = nil
+	s.mu.Unlock()
+	return nil
+}
+
+// ApplyDiff applies the blob produced by SerializeDiff on top of the
+// nodes already stored in the state's database.
+func (s *State) ApplyDiff(b consensus.TrieBlob) error {
+	err := s.Deserialize(b)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.accountCache = make(map[consensus.Addr]*Account)
+	s.mu.Unlock()
 	return nil
 }
 
@@ -600,14 +982,23 @@ func (s *State) Hash() consensus.Hash {
 }
 
 // Transition returns the state change transition.
+//
+// The transition works on a copy of the state trie with its own
+// account cache, accounts are loaded into the transition's cache on
+// read and the parent state is never modified. The changes become
+// visible only through the state returned by the transition's Commit.
+// The token cache is forked from the parent's in the same way.
 func (s *State) Transition(round uint64, proposer []byte) consensus.Transition {
 	s.CommitCache()
+	tokens := s.tokens()
 
 	s.mu.Lock()
 	newTrie := *s.trie
 	s.mu.Unlock()
 
 	state := newState(&newTrie, s.db, s.diskDB)
+	state.chainID = s.chainID
+	state.tokenCache = tokens
 	return newTransition(state, round, PK(proposer))
 }
 
@@ -627,6 +1018,318 @@ func (s *State) CommitTxns(txns []byte, pool consensus.TxnPool, round uint64) (c
 	return trans.Commit(), count, nil
 }
 
+// stopOrder is a order that is not in the order book until the
+// last traded price crosses the stop price.
+type stopOrder struct {
+	ID        uint64
+	StopPrice uint64
+	Order
+}
+
+// DecodeRLP decodes the stop order, it is defined so that the
+// DecodeRLP of the embedded Order is not promoted.
+func (o *stopOrder) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	if err := decodeFields(s, &o.ID, &o.StopPrice, &o.Order); err != nil {
+		return err
+	}
+
+	return s.ListEnd()
+}
+
+func (s *State) AddStopOrder(m MarketSymbol, o stopOrder) {
+	b, err := rlp.EncodeToBytes(o)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(stopOrderPath(m, o.ID), b)
+	s.mu.Unlock()
+}
+
+func (s *State) RemoveStopOrder(m MarketSymbol, id uint64) {
+	s.mu.Lock()
+	s.trie.Delete(stopOrderPath(m, id))
+	s.mu.Unlock()
+}
+
+// StopOrders returns the untriggered stop orders of the market
+// ordered by ID.
+func (s *State) StopOrders(m MarketSymbol) []stopOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := encodePath(stopOrdersPath(m))
+	iter := s.trie.NodeIterator(prefix)
+
+	var r []stopOrder
+	hasNext := true
+	foundPrefix := false
+
+	for ; hasNext; hasNext = iter.Next(true) {
+		if err := iter.Error(); err != nil {
+			log.Error("error iterating state trie's stop orders", "err", err)
+			break
+		}
+
+		if !iter.Leaf() {
+			continue
+		}
+
+		path := iter.Path()
+		if !bytes.HasPrefix(path, prefix) {
+			if foundPrefix {
+				break
+			}
+
+			continue
+		}
+		foundPrefix = true
+
+		var o stopOrder
+		err := rlp.DecodeBytes(iter.LeafBlob(), &o)
+		if err != nil {
+			panic(err)
+		}
+
+		r = append(r, o)
+	}
+	return r
+}
+
+// AccountPolicy returns the multisig policy of the account, nil is
+// returned if the account is not a multisig account.
+func (s *State) AccountPolicy(addr consensus.Addr) *AccountPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(addrPolicyPath(addr))
+	if len(b) == 0 {
+		return nil
+	}
+
+	var p AccountPolicy
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return &p
+}
+
+// UpdateAccountPolicy sets the multisig policy of the account.
+func (s *State) UpdateAccountPolicy(addr consensus.Addr, p AccountPolicy) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(addrPolicyPath(addr), b)
+	s.mu.Unlock()
+}
+
+// MarketParams returns the trading rules of the market.
+func (s *State) MarketParams(m MarketSymbol) MarketParams {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(marketParamsPath(m))
+	if len(b) == 0 {
+		return DefaultMarketParams
+	}
+
+	var p MarketParams
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+// UpdateMarketParams sets the trading rules of the market, it is
+// called at token issuance or when building the genesis state.
+func (s *State) UpdateMarketParams(m MarketSymbol, p MarketParams) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(marketParamsPath(m), b)
+	s.mu.Unlock()
+}
+
+// HasMarket returns true if the market is in the market list.
+func (s *State) HasMarket(m MarketSymbol) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.trie.Get(marketsPath(m))) > 0
+}
+
+// AddMarket adds the market to the market list, it is called when
+// the first order of the market is placed.
+func (s *State) AddMarket(m MarketSymbol) {
+	b, err := rlp.EncodeToBytes(m)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(marketsPath(m), b)
+	s.mu.Unlock()
+}
+
+// Markets returns the markets that ever had an order, ordered by
+// the encoded market symbols.
+func (s *State) Markets() []MarketSymbol {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := encodePath(marketsPrefix)
+	iter := s.trie.NodeIterator(prefix)
+
+	var r []MarketSymbol
+	hasNext := true
+	foundPrefix := false
+
+	for ; hasNext; hasNext = iter.Next(true) {
+		if err := iter.Error(); err != nil {
+			log.Error("error iterating state trie's markets", "err", err)
+			break
+		}
+
+		if !iter.Leaf() {
+			continue
+		}
+
+		path := iter.Path()
+		if !bytes.HasPrefix(path, prefix) {
+			if foundPrefix {
+				break
+			}
+
+			continue
+		}
+		foundPrefix = true
+
+		var m MarketSymbol
+		err := rlp.DecodeBytes(iter.LeafBlob(), &m)
+		if err != nil {
+			panic(err)
+		}
+
+		r = append(r, m)
+	}
+	return r
+}
+
+// TradingFee returns the trading fee configuration, no trading fee
+// is charged if it does not exist.
+func (s *State) TradingFee() (TradingFee, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(tradingFeePath)
+	if len(b) == 0 {
+		return TradingFee{}, false
+	}
+
+	var f TradingFee
+	err := rlp.DecodeBytes(b, &f)
+	if err != nil {
+		panic(err)
+	}
+
+	return f, true
+}
+
+// UpdateTradingFee sets the trading fee configuration, it is called
+// when building the genesis state.
+func (s *State) UpdateTradingFee(f TradingFee) {
+	if !f.Valid() {
+		panic(fmt.Errorf("invalid trading fee: %v", f))
+	}
+
+	b, err := rlp.EncodeToBytes(f)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(tradingFeePath, b)
+	s.mu.Unlock()
+}
+
+// MinTxnFee returns the minimal fee of a txn in the smallest unit of
+// BNB, it is 0 if not set.
+func (s *State) MinTxnFee() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(minTxnFeePath)
+	if len(b) == 0 {
+		return 0
+	}
+
+	var fee uint64
+	err := rlp.DecodeBytes(b, &fee)
+	if err != nil {
+		panic(err)
+	}
+
+	return fee
+}
+
+// UpdateMinTxnFee sets the minimal fee of a txn, it is called when
+// building the genesis state.
+func (s *State) UpdateMinTxnFee(fee uint64) {
+	b, err := rlp.EncodeToBytes(fee)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(minTxnFeePath, b)
+	s.mu.Unlock()
+}
+
+// LastPrice returns the last traded price of the market.
+func (s *State) LastPrice(m MarketSymbol) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(lastPricePath(m))
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	var p uint64
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+func (s *State) UpdateLastPrice(m MarketSymbol, p uint64) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(lastPricePath(m), b)
+	s.mu.Unlock()
+}
+
 type orderExpiration struct {
 	ID    OrderID
 	Owner consensus.Addr
@@ -679,14 +1382,81 @@ func (s *State) RemoveOrderExpirations(round uint64, ids map[OrderID]bool) {
 		}
 	}
 
+	path := expirationToPath(round)
+	if len(newExps) == 0 {
+		s.trie.Delete(path)
+		return
+	}
+
 	b, err := rlp.EncodeToBytes(newExps)
 	if err != nil {
 		panic(err)
 	}
-	path := expirationToPath(round)
 	s.trie.Update(path, b)
 }
 
+// DeleteOrderExpirations deletes the order expirations of the round.
+func (s *State) DeleteOrderExpirations(round uint64) {
+	s.mu.Lock()
+	s.trie.Delete(expirationToPath(round))
+	s.mu.Unlock()
+}
+
+// OrderExpirationRounds returns the rounds not later than the given
+// round that have order expirations, in ascending order.
+func (s *State) OrderExpirationRounds(round uint64) []uint64 {
+	return s.roundsWithEntries(orderExpirationPrefix, round)
+}
+
+// FreezeTokenRounds returns the rounds not later than the given round
+// that have frozen tokens to release, in ascending order.
+func (s *State) FreezeTokenRounds(round uint64) []uint64 {
+	return s.roundsWithEntries(freezeAtRoundPrefix, round)
+}
+
+func (s *State) roundsWithEntries(prefix []byte, round uint64) []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := encodePath(prefix)
+	iter := s.trie.NodeIterator(path)
+
+	var r []uint64
+	hasNext := true
+	foundPrefix := false
+
+	for ; hasNext; hasNext = iter.Next(true) {
+		if err := iter.Error(); err != nil {
+			log.Error("error iterating state trie's round entries", "err", err)
+			break
+		}
+
+		if !iter.Leaf() {
+			continue
+		}
+
+		if !bytes.HasPrefix(iter.Path(), path) {
+			if foundPrefix {
+				break
+			}
+
+			continue
+		}
+		foundPrefix = true
+
+		key := iter.LeafKey()
+		entryRound := binary.LittleEndian.Uint64(key[len(prefix):])
+		if entryRound <= round {
+			r = append(r, entryRound)
+		}
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i] < r[j]
+	})
+	return r
+}
+
 func (s *State) UpdateReportIdx(addr consensus.Addr, idx uint32) {
 	b, err := rlp.EncodeToBytes(idx)
 	if err != nil {
@@ -759,3 +1529,11 @@ func (s *State) FreezeToken(round uint64, f freezeToken) {
 	path := freezeAtRoundToPath(round)
 	s.trie.Update(path, b)
 }
+
+// DeleteFreezeTokens deletes the frozen tokens to release at the
+// round.
+func (s *State) DeleteFreezeTokens(round uint64) {
+	s.mu.Lock()
+	s.trie.Delete(freezeAtRoundToPath(round))
+	s.mu.Unlock()
+}
@@ -0,0 +1,211 @@
+package dex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// KeyDiff is a state trie key whose value differs between two
+// states. A is nil if the key only exists in the second state, B is
+// nil if the key only exists in the first state.
+type KeyDiff struct {
+	Key  []byte
+	Desc string
+	A    []byte
+	B    []byte
+}
+
+func (k KeyDiff) String() string {
+	switch {
+	case k.A == nil:
+		return "only in b: " + k.Desc
+	case k.B == nil:
+		return "only in a: " + k.Desc
+	default:
+		return "different: " + k.Desc
+	}
+}
+
+// DiffStates returns the keys that are different between the two
+// states, in the order of the keys.
+func DiffStates(a, b *State) ([]KeyDiff, error) {
+	var r []KeyDiff
+	err := WalkStateDiff(a, b, func(d KeyDiff) bool {
+		r = append(r, d)
+		return true
+	})
+	return r, err
+}
+
+// WalkStateDiff calls fn with each key that is different between the
+// two states in the order of the keys, without holding all the
+// differences in memory. The walk stops when fn returns false.
+//
+// The subtries that are the same in both states are skipped, so the
+// cost is proportional to the number of the changed keys rather than
+// the size of the states.
+func WalkStateDiff(a, b *State, fn func(KeyDiff) bool) error {
+	at := a.snapshotTrie()
+	bt := b.snapshotTrie()
+	// ai visits the leaves of a that are changed or removed in
+	// b, bi visits the leaves of b that are changed or added.
+	ai, _ := trie.NewDifferenceIterator(bt.NodeIterator(nil), at.NodeIterator(nil))
+	bi, _ := trie.NewDifferenceIterator(at.NodeIterator(nil), bt.NodeIterator(nil))
+	aOK := nextLeaf(ai)
+	bOK := nextLeaf(bi)
+
+	for aOK || bOK {
+		cmp := 0
+		switch {
+		case !aOK:
+			cmp = 1
+		case !bOK:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(ai.LeafKey(), bi.LeafKey())
+		}
+
+		var key []byte
+		if cmp <= 0 {
+			key = append([]byte(nil), ai.LeafKey()...)
+			aOK = nextLeaf(ai)
+		}
+
+		if cmp >= 0 {
+			key = append([]byte(nil), bi.LeafKey()...)
+			bOK = nextLeaf(bi)
+		}
+
+		// a leaf may be visited only because its trie node
+		// moved, the values are compared by the key.
+		d := KeyDiff{Key: key, A: at.Get(key), B: bt.Get(key)}
+		if bytes.Equal(d.A, d.B) {
+			continue
+		}
+
+		d.Desc = describeStateKey(d.Key)
+		if !fn(d) {
+			return nil
+		}
+	}
+
+	if err := ai.Error(); err != nil {
+		return err
+	}
+
+	return bi.Error()
+}
+
+func nextLeaf(iter trie.NodeIterator) bool {
+	for iter.Next(true) {
+		if iter.Leaf() {
+			return true
+		}
+	}
+	return false
+}
+
+// StateAt returns the state of the given root, the trie nodes of the
+// root must be in the state's database.
+func (s *State) StateAt(root consensus.Hash) (*State, error) {
+	t, err := trie.New(common.Hash(root), s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	state := newState(t, s.db, s.diskDB)
+	state.chainID = s.chainID
+	return state, nil
+}
+
+// snapshotTrie returns a copy of the state trie that is not affected
+// by the later changes to the state.
+func (s *State) snapshotTrie() *trie.Trie {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := *s.trie
+	return &t
+}
+
+// describeStateKey returns the human readable description of the
+// state trie key.
+func describeStateKey(key []byte) string {
+	if len(key) == 0 {
+		return "empty key"
+	}
+
+	rest := key[1:]
+	addrDesc := func(what string) string {
+		if len(rest) < len(consensus.Addr{}) {
+			return fmt.Sprintf("%s of malformed address %x", what, rest)
+		}
+
+		var addr consensus.Addr
+		copy(addr[:], rest)
+		return fmt.Sprintf("%s of account %v", what, addr)
+	}
+
+	roundDesc := func(what string) string {
+		if len(rest) < 8 {
+			return fmt.Sprintf("%s of malformed round %x", what, rest)
+		}
+
+		return fmt.Sprintf("%s of round %d", what, binary.LittleEndian.Uint64(rest))
+	}
+
+	marketDesc := func(what string) string {
+		var m MarketSymbol
+		m.Decode(rest)
+		return fmt.Sprintf("%s of market %d_%d", what, m.Base, m.Quote)
+	}
+
+	switch key[0] {
+	case marketPrefix[0]:
+		return marketDesc("order book")
+	case tokenPrefix[0]:
+		if len(rest) < 8 {
+			return fmt.Sprintf("malformed token %x", rest)
+		}
+		return fmt.Sprintf("token %d", binary.LittleEndian.Uint64(rest))
+	case orderExpirationPrefix[0]:
+		return roundDesc("order expirations")
+	case freezeAtRoundPrefix[0]:
+		return roundDesc("frozen tokens to release")
+	case pkPrefix[0]:
+		return addrDesc("public key")
+	case noncePrefix[0]:
+		return addrDesc("nonce")
+	case balancePrefix[0]:
+		return addrDesc("balances")
+	case pendingOrdersPrefix[0]:
+		return addrDesc("pending order")
+	case executionReportsPrefix[0]:
+		return addrDesc("execution report")
+	case reportIdxPrefix[0]:
+		return addrDesc("execution report index")
+	case tokenSymbolPrefix[0]:
+		return fmt.Sprintf("token symbol %s", rest)
+	case receiptPrefix[0]:
+		return fmt.Sprintf("receipt of txn %x", rest)
+	case lastPricePrefix[0]:
+		return marketDesc("last price")
+	case stopOrderPrefix[0]:
+		return marketDesc("stop order")
+	case marketParamsPrefix[0]:
+		return marketDesc("params")
+	case tradingFeePath[0]:
+		return "trading fee"
+	case minTxnFeePath[0]:
+		return "min txn fee"
+	case accountPolicyPrefix[0]:
+		return addrDesc("account policy")
+	default:
+		return fmt.Sprintf("unknown key %x", key)
+	}
+}
@@ -0,0 +1,89 @@
+package dex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffStates(t *testing.T) {
+	pk0, _ := RandKeyPair()
+	pk1, _ := RandKeyPair()
+	s := CreateGenesisState([]PK{pk0, pk1}, nil)
+
+	diffs, err := DiffStates(s, s)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(diffs))
+
+	trans := s.Transition(1, nil).(*Transition)
+	acc := trans.state.Account(pk1.Addr())
+	b := acc.Balance(0)
+	b.Available--
+	acc.UpdateBalance(0, b)
+	s1 := trans.Commit().(*State)
+
+	diffs, err = DiffStates(s, s1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(diffs))
+	assert.True(t, strings.Contains(diffs[0].Desc, pk1.Addr().String()))
+	assert.NotNil(t, diffs[0].A)
+	assert.NotNil(t, diffs[0].B)
+}
+
+func TestDiffStatesMissingKey(t *testing.T) {
+	pk, _ := RandKeyPair()
+	s := CreateGenesisState([]PK{pk}, nil)
+	trans := s.Transition(1, nil).(*Transition)
+	trans.state.UpdateLastPrice(MarketSymbol{Base: 1, Quote: 0}, 100)
+	s1 := trans.Commit().(*State)
+
+	diffs, err := DiffStates(s1, s)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(diffs))
+	assert.Nil(t, diffs[0].B)
+	assert.Equal(t, "last price of market 1_0", diffs[0].Desc)
+}
+
+func TestDiffStatesChangedOnly(t *testing.T) {
+	pks := make([]PK, 50)
+	for i := range pks {
+		pks[i], _ = RandKeyPair()
+	}
+	s := CreateGenesisState(pks, nil)
+
+	trans := s.Transition(1, nil).(*Transition)
+	for _, pk := range pks[10:13] {
+		acc := trans.state.Account(pk.Addr())
+		b := acc.Balance(0)
+		b.Available--
+		acc.UpdateBalance(0, b)
+	}
+	trans.state.UpdateLastPrice(MarketSymbol{Base: 1, Quote: 0}, 100)
+	s1 := trans.Commit().(*State)
+
+	diffs, err := DiffStates(s, s1)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(diffs))
+	for i := 1; i < len(diffs); i++ {
+		assert.True(t, bytes.Compare(diffs[i-1].Key, diffs[i].Key) < 0)
+	}
+
+	var onlyInB int
+	for _, d := range diffs {
+		if d.A == nil {
+			onlyInB++
+			assert.Equal(t, "last price of market 1_0", d.Desc)
+		}
+	}
+	assert.Equal(t, 1, onlyInB)
+
+	reversed, err := DiffStates(s1, s)
+	assert.Nil(t, err)
+	assert.Equal(t, len(diffs), len(reversed))
+	for i := range diffs {
+		assert.Equal(t, diffs[i].Key, reversed[i].Key)
+		assert.Equal(t, diffs[i].A, reversed[i].B)
+	}
+}
@@ -0,0 +1,41 @@
+package dex
+
+import "github.com/helinwang/dex/pkg/consensus"
+
+// StateVersion identifies the state that answered a query, the
+// responses answered by the same state have the same version.
+type StateVersion struct {
+	// the round of the transition that produced the state
+	Round uint64
+	Root  consensus.Hash
+}
+
+// Version returns the version of the state.
+func (s *State) Version() StateVersion {
+	return StateVersion{Round: s.round, Root: s.Hash()}
+}
+
+// Snapshot returns a read-only view of the state: a copy of the
+// committed trie handle with its own empty account cache. The state
+// itself is not frozen, the Update methods and CommitCache write its
+// account cache into its trie, and Deserialize and ApplyDiff replace
+// its trie and cache. The view is not affected by them, so its
+// readers never see half-applied changes. The view must not be
+// modified.
+func (s *State) Snapshot() *State {
+	s.CommitCache()
+	tokens := s.tokens()
+
+	s.mu.Lock()
+	t := *s.trie
+	events := s.events
+	round := s.round
+	s.mu.Unlock()
+
+	snapshot := newState(&t, s.db, s.diskDB)
+	snapshot.chainID = s.chainID
+	snapshot.tokenCache = tokens
+	snapshot.events = events
+	snapshot.round = round
+	return snapshot
+}
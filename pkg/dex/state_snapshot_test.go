@@ -0,0 +1,88 @@
+package dex
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRPCServerSnapshot(t *testing.T) {
+	const rounds = 30
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	market := MarketSymbol{Base: 0, Quote: 1}
+	r := NewRPCServer()
+	r.Update(s)
+
+	// the expected balance and state root of each round
+	var mu sync.Mutex
+	balances := map[uint64]Balance{0: s.Account(pk.Addr()).Balance(0)}
+	roots := map[uint64]StateVersion{0: s.Version()}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				var w WalletState
+				assert.Nil(t, r.walletState(pk.Addr(), &w))
+				round := w.Version.Round
+				assert.Equal(t, w.Round, round)
+				// a sell order is placed in each round
+				assert.Equal(t, int(round), len(w.PendingOrders))
+
+				mu.Lock()
+				b := balances[round]
+				v := roots[round]
+				mu.Unlock()
+				assert.Equal(t, v, w.Version)
+				// the decoded balance may have an empty
+				// instead of nil frozen list, the fields are
+				// compared one by one.
+				got := w.Balances[0].Balance
+				assert.Equal(t, b.Available, got.Available)
+				assert.Equal(t, b.Pending, got.Pending)
+				assert.Equal(t, len(b.Frozen), len(got.Frozen))
+			}
+		}()
+	}
+
+	for round := uint64(1); round <= rounds; round++ {
+		trans := s.Transition(round, nil)
+		recordTxn(t, trans, pk, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 1, Price: 100000000, Market: market}, round-1))
+		next := trans.Commit().(*State)
+
+		mu.Lock()
+		balances[round] = next.Account(pk.Addr()).Balance(0)
+		roots[round] = next.Version()
+		mu.Unlock()
+		r.Update(next)
+
+		// the state is read and the transitions are derived
+		// from it while it is being served, as the txn pool and
+		// the proposer do.
+		acc := next.Account(pk.Addr())
+		acc.PendingOrders()
+		acc.Balance(0)
+		other := next.Transition(round+1, nil)
+		recordTxn(t, other, pk, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 1, Price: 100000000, Market: market}, round))
+		s = next
+	}
+
+	close(done)
+	wg.Wait()
+
+	var w WalletState
+	assert.Nil(t, r.walletState(pk.Addr(), &w))
+	assert.Equal(t, uint64(rounds), w.Version.Round)
+	assert.Equal(t, s.Hash(), w.Version.Root)
+}
@@ -5,6 +5,7 @@ import (
 	"unsafe"
 
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/stretchr/testify/assert"
 )
@@ -42,6 +43,175 @@ func TestStateTokens(t *testing.T) {
 	assert.Equal(t, []Token{token0, token1}, s.Tokens())
 }
 
+func TestTokenDecodeLegacy(t *testing.T) {
+	// the token encoding before MaxSupply and Issuer were added
+	type legacyTokenInfo struct {
+		Symbol     TokenSymbol
+		Decimals   uint8
+		TotalUnits uint64
+	}
+	type legacyToken struct {
+		ID        TokenID
+		TokenInfo legacyTokenInfo
+	}
+
+	b, err := rlp.EncodeToBytes(legacyToken{ID: 3, TokenInfo: legacyTokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100}})
+	assert.Nil(t, err)
+	s := NewState(ethdb.NewMemDatabase())
+	s.trie.Update(tokenPath(3), b)
+	token, ok := s.Token(3)
+	assert.True(t, ok)
+	assert.Equal(t, Token{ID: 3, TokenInfo: TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100}}, token)
+
+	var issuer consensus.Addr
+	issuer[0] = 1
+	minted := Token{ID: 4, TokenInfo: TokenInfo{Symbol: "ETH", Decimals: 8, TotalUnits: 100, MaxSupply: 1000}, Issuer: issuer}
+	b, err = rlp.EncodeToBytes(minted)
+	assert.Nil(t, err)
+	var decoded Token
+	assert.Nil(t, rlp.DecodeBytes(b, &decoded))
+	assert.Equal(t, minted, decoded)
+
+	b, err = rlp.EncodeToBytes([]interface{}{uint64(5), legacyTokenInfo{Symbol: "XYZ"}, issuer, uint64(1)})
+	assert.Nil(t, err)
+	assert.NotNil(t, rlp.DecodeBytes(b, &decoded))
+}
+
+func TestOrderDecodeLegacy(t *testing.T) {
+	// the encodings before the trading fee and the iceberg
+	// orders were added
+	type legacyOrder struct {
+		Owner       consensus.Addr
+		SellSide    bool
+		Quant       uint64
+		Price       uint64
+		ExpireRound uint64
+	}
+	type legacyPendingOrder struct {
+		ID       OrderID
+		Executed uint64
+		Order    legacyOrder
+	}
+	type legacyExecutionReport struct {
+		Round      uint64
+		ID         OrderID
+		SellSide   bool
+		TradePrice uint64
+		Quant      uint64
+	}
+	type legacyEntry struct {
+		ID    uint64
+		Owner consensus.Addr
+		Quant uint64
+	}
+	type legacyPoint struct {
+		Price   uint64
+		Entries []legacyEntry
+	}
+
+	var owner consensus.Addr
+	owner[0] = 1
+	market := MarketSymbol{Base: 1, Quote: 0}
+	id := OrderID{ID: 7, Market: market}
+	order := legacyOrder{Owner: owner, SellSide: true, Quant: 100, Price: 200, ExpireRound: 9}
+	b, err := rlp.EncodeToBytes(legacyPendingOrder{ID: id, Executed: 10, Order: order})
+	assert.Nil(t, err)
+	var pending PendingOrder
+	assert.Nil(t, rlp.DecodeBytes(b, &pending))
+	assert.Equal(t, PendingOrder{ID: id, Executed: 10, Order: Order{Owner: owner, SellSide: true, Quant: 100, Price: 200, ExpireRound: 9}}, pending)
+
+	b, err = rlp.EncodeToBytes(legacyExecutionReport{Round: 3, ID: id, SellSide: true, TradePrice: 200, Quant: 50})
+	assert.Nil(t, err)
+	var report ExecutionReport
+	assert.Nil(t, rlp.DecodeBytes(b, &report))
+	assert.Equal(t, ExecutionReport{Round: 3, ID: id, SellSide: true, TradePrice: 200, Quant: 50}, report)
+
+	var book []byte
+	for _, v := range []interface{}{
+		[]legacyPoint{{Price: 200, Entries: []legacyEntry{{ID: 7, Owner: owner, Quant: 90}}}},
+		[]legacyPoint{{Price: 100, Entries: []legacyEntry{{ID: 5, Owner: owner, Quant: 30}, {ID: 6, Owner: owner, Quant: 40}}}},
+		uint64(8),
+	} {
+		e, err := rlp.EncodeToBytes(v)
+		assert.Nil(t, err)
+		book = append(book, e...)
+	}
+	s := NewState(ethdb.NewMemDatabase())
+	s.trie.Update(marketPath(market.Encode()), book)
+	loaded := s.loadOrderBook(market)
+	if assert.NotNil(t, loaded) {
+		o, ok := loaded.Resting(7)
+		assert.True(t, ok)
+		assert.Equal(t, Order{Owner: owner, SellSide: true, Quant: 90, Price: 200}, o)
+		e, ok := loaded.entry(6)
+		assert.True(t, ok)
+		assert.Equal(t, orderBookEntryData{ID: 6, Owner: owner, Quant: 40, Priority: 6}, e)
+	}
+
+	// the current encodings decode as they are
+	iceberg := PendingOrder{ID: id, Executed: 1, Order: Order{Owner: owner, Quant: 100, Price: 200, Display: 10}}
+	b, err = rlp.EncodeToBytes(iceberg)
+	assert.Nil(t, err)
+	pending = PendingOrder{}
+	assert.Nil(t, rlp.DecodeBytes(b, &pending))
+	assert.Equal(t, iceberg, pending)
+
+	stop := stopOrder{ID: 3, StopPrice: 150, Order: Order{Owner: owner, Quant: 100, Price: 200, Display: 10}}
+	b, err = rlp.EncodeToBytes(stop)
+	assert.Nil(t, err)
+	var decodedStop stopOrder
+	assert.Nil(t, rlp.DecodeBytes(b, &decodedStop))
+	assert.Equal(t, stop, decodedStop)
+
+	report = ExecutionReport{Round: 3, ID: id, TradePrice: 200, Quant: 50, Fee: 1}
+	b, err = rlp.EncodeToBytes(report)
+	assert.Nil(t, err)
+	var decodedReport ExecutionReport
+	assert.Nil(t, rlp.DecodeBytes(b, &decodedReport))
+	assert.Equal(t, report, decodedReport)
+}
+
+func TestGenesisTokenSymbolIndex(t *testing.T) {
+	owner, _ := RandKeyPair()
+	btcInfo := TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 10000000000}
+	s := CreateGenesisState([]PK{owner}, []TokenInfo{btcInfo})
+
+	token, ok := s.TokenBySymbol("bnb")
+	assert.True(t, ok)
+	assert.Equal(t, Token{ID: 0, TokenInfo: BNBInfo}, token)
+
+	token, ok = s.TokenBySymbol("BTC")
+	assert.True(t, ok)
+	assert.Equal(t, Token{ID: 1, TokenInfo: btcInfo}, token)
+
+	_, ok = s.TokenBySymbol("ETH")
+	assert.False(t, ok)
+}
+
+func TestGenesisDistributionConservation(t *testing.T) {
+	recipients := make([]PK, 7)
+	for i := range recipients {
+		recipients[i], _ = RandKeyPair()
+	}
+
+	btcInfo := TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 21000000*100000000 + 5}
+	s := CreateGenesisState(recipients, []TokenInfo{btcInfo})
+	for _, token := range s.Tokens() {
+		var sum uint64
+		for _, pk := range recipients {
+			sum += s.Account(pk.Addr()).Balance(token.ID).Available
+		}
+		assert.Equal(t, token.TotalUnits, sum)
+	}
+}
+
+func TestGenesisAllocations(t *testing.T) {
+	assert.Equal(t, []uint64{34, 33, 33}, genesisAllocations(100, 3))
+	assert.Equal(t, []uint64{1, 1, 0, 0}, genesisAllocations(2, 4))
+	assert.Equal(t, []uint64{5}, genesisAllocations(5, 1))
+	assert.Nil(t, genesisAllocations(5, 0))
+}
+
 func TestStateSerialize(t *testing.T) {
 	owner, _ := RandKeyPair()
 	token0 := Token{ID: 1, TokenInfo: TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 10000000000}}
@@ -76,6 +246,113 @@ func TestStateSerialize(t *testing.T) {
 	assert.Equal(t, token1.TotalUnits, b1.Available)
 }
 
+func TestStateSerializeDiff(t *testing.T) {
+	owner, _ := RandKeyPair()
+	token0 := Token{ID: 1, TokenInfo: TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 10000000000}}
+	token1 := Token{ID: 2, TokenInfo: TokenInfo{Symbol: "ETH", Decimals: 8, TotalUnits: 1000000000}}
+	s := CreateGenesisState([]PK{owner}, []TokenInfo{token0.TokenInfo})
+	b, err := s.Serialize()
+	if err != nil {
+		panic(err)
+	}
+
+	s0 := NewState(ethdb.NewMemDatabase())
+	err = s0.Deserialize(b)
+	if err != nil {
+		panic(err)
+	}
+
+	s.UpdateToken(token1)
+	diff, err := s.SerializeDiff(b.Root)
+	if err != nil {
+		panic(err)
+	}
+
+	err = s0.ApplyDiff(diff)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, s.Hash(), s0.Hash())
+	assert.Equal(t, s.Tokens(), s0.Tokens())
+	b0, ids0 := s0.Balances(owner.Addr())
+	b1, ids1 := s.Balances(owner.Addr())
+	assert.Equal(t, b1, b0)
+	assert.Equal(t, ids1, ids0)
+}
+
+func TestStateSerializeDiffOneAccount(t *testing.T) {
+	const numAccounts = 64
+	pks := make([]PK, numAccounts)
+	for i := range pks {
+		pks[i], _ = RandKeyPair()
+	}
+
+	token0 := TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 10000000000}
+	s := CreateGenesisState(pks, []TokenInfo{token0})
+	b, err := s.Serialize()
+	if err != nil {
+		panic(err)
+	}
+
+	s0 := NewState(ethdb.NewMemDatabase())
+	err = s0.Deserialize(b)
+	if err != nil {
+		panic(err)
+	}
+
+	addr := pks[0].Addr()
+	balance := s.Account(addr).Balance(1)
+	s.Account(addr).UpdateBalance(1, Balance{Available: balance.Available - 1, Pending: 1})
+	diff, err := s.SerializeDiff(b.Root)
+	if err != nil {
+		panic(err)
+	}
+
+	// only the path from the root to the changed balance leaf
+	// is new, no matter how many accounts the state holds.
+	assert.True(t, len(diff.Data) <= 8, "diff has %d nodes", len(diff.Data))
+	assert.NotEqual(t, b.Root, diff.Root)
+
+	err = s0.ApplyDiff(diff)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, s.Hash(), s0.Hash())
+	assert.Equal(t, diff.Root, s0.Hash())
+	for _, pk := range pks {
+		a := pk.Addr()
+		assert.Equal(t, s.PK(a), s0.PK(a))
+		assert.Equal(t, s.Nonce(a), s0.Nonce(a))
+		b0, ids0 := s0.Balances(a)
+		b1, ids1 := s.Balances(a)
+		assert.Equal(t, b1, b0)
+		assert.Equal(t, ids1, ids0)
+	}
+	changed := s0.Account(addr).Balance(1)
+	assert.Equal(t, balance.Available-1, changed.Available)
+	assert.Equal(t, uint64(1), changed.Pending)
+}
+
+func TestStateSerializeDiffUnknownRoot(t *testing.T) {
+	owner, _ := RandKeyPair()
+	token0 := Token{ID: 1, TokenInfo: TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 10000000000}}
+	s := CreateGenesisState([]PK{owner}, []TokenInfo{token0.TokenInfo})
+	diff, err := s.SerializeDiff(consensus.SHA3([]byte("unknown root")))
+	if err != nil {
+		panic(err)
+	}
+
+	s0 := NewState(ethdb.NewMemDatabase())
+	err = s0.ApplyDiff(diff)
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, s.Hash(), s0.Hash())
+}
+
 func TestStateNonce(t *testing.T) {
 	s := NewState(ethdb.NewMemDatabase())
 	addr := consensus.RandSK().MustPK().Addr()
@@ -1,16 +1,93 @@
 package dex
 
 import (
+	"errors"
+	"fmt"
 	"sort"
 	"strings"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	maxTokenSymbolLen = 16
+	maxTokenDecimals  = 18
 )
 
 type TokenSymbol string
 
+// Normalize returns the upper case form of the symbol, token symbols
+// are case-insensitive.
+func (t TokenSymbol) Normalize() TokenSymbol {
+	return TokenSymbol(strings.ToUpper(string(t)))
+}
+
 type TokenInfo struct {
 	Symbol     TokenSymbol
 	Decimals   uint8
 	TotalUnits uint64 // TotalUnits = totalSupply * 10^Decimals
+	// the cap of TotalUnits that the issuer can mint up to, 0
+	// means the token can not be minted.
+	MaxSupply uint64
+}
+
+// DecodeRLP decodes the token info, the token infos encoded before
+// MaxSupply was added decode with a zero MaxSupply.
+func (t *TokenInfo) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	if err := s.Decode(&t.Symbol); err != nil {
+		return err
+	}
+
+	if err := s.Decode(&t.Decimals); err != nil {
+		return err
+	}
+
+	if err := s.Decode(&t.TotalUnits); err != nil {
+		return err
+	}
+
+	t.MaxSupply = 0
+	if err := decodeOptional(s, &t.MaxSupply); err != nil {
+		return err
+	}
+
+	return s.ListEnd()
+}
+
+// Validate checks if the token info is valid for issuing a token.
+func (t TokenInfo) Validate() error {
+	if len(t.Symbol) == 0 {
+		return errors.New("token symbol is empty")
+	}
+
+	if len(t.Symbol) > maxTokenSymbolLen {
+		return fmt.Errorf("token symbol too long, length: %d, max: %d", len(t.Symbol), maxTokenSymbolLen)
+	}
+
+	for _, c := range t.Symbol {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("token symbol %q contains invalid character %q", t.Symbol, c)
+		}
+	}
+
+	if t.Decimals > maxTokenDecimals {
+		return fmt.Errorf("token decimals too large, decimals: %d, max: %d", t.Decimals, maxTokenDecimals)
+	}
+
+	if t.TotalUnits == 0 {
+		return errors.New("token total units is 0")
+	}
+
+	if t.MaxSupply > 0 && t.MaxSupply < t.TotalUnits {
+		return fmt.Errorf("token max supply %d is smaller than total units %d", t.MaxSupply, t.TotalUnits)
+	}
+
+	return nil
 }
 
 type TokenID uint64
@@ -18,11 +95,64 @@ type TokenID uint64
 type Token struct {
 	ID TokenID
 	TokenInfo
+	// the zero address for the tokens created in genesis
+	Issuer consensus.Addr
 }
 
+// DecodeRLP decodes the token, the tokens stored before Issuer was
+// added decode with the zero address as the issuer.
+func (t *Token) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	if err := s.Decode(&t.ID); err != nil {
+		return err
+	}
+
+	if err := s.Decode(&t.TokenInfo); err != nil {
+		return err
+	}
+
+	t.Issuer = consensus.Addr{}
+	if err := decodeOptional(s, &t.Issuer); err != nil {
+		return err
+	}
+
+	return s.ListEnd()
+}
+
+// decodeFields decodes the next elements of the list into vals in
+// order.
+func decodeFields(s *rlp.Stream, vals ...interface{}) error {
+	for _, val := range vals {
+		if err := s.Decode(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeOptional decodes the next element of the list into val, val
+// is unchanged if the list has ended, so that the encodings without
+// the trailing fields added later still decode.
+func decodeOptional(s *rlp.Stream, val interface{}) error {
+	err := s.Decode(val)
+	if err == rlp.EOL {
+		return nil
+	}
+	return err
+}
+
+// TokenCache caches the token infos of a state. A cache forked from
+// a parent cache records the changes locally and leaves the parent
+// untouched, so the transitions forked from the same state do not
+// see each other's tokens.
 type TokenCache struct {
+	parent   *TokenCache
 	idToInfo map[TokenID]TokenInfo
 	exists   map[TokenSymbol]bool
+	size     int
 }
 
 func newTokenCache(s *State) *TokenCache {
@@ -34,34 +164,104 @@ func newTokenCache(s *State) *TokenCache {
 	tokens := s.Tokens()
 	for _, t := range tokens {
 		c.idToInfo[t.ID] = t.TokenInfo
-		c.exists[t.Symbol] = true
+		c.exists[t.Symbol.Normalize()] = true
 	}
+	c.size = len(c.idToInfo)
 	return c
 }
 
+func (t *TokenCache) fork() *TokenCache {
+	return &TokenCache{
+		parent:   t,
+		idToInfo: make(map[TokenID]TokenInfo),
+		exists:   make(map[TokenSymbol]bool),
+		size:     t.size,
+	}
+}
+
+// flatten returns a cache without parent that contains the tokens of
+// the cache and all its ancestors.
+func (t *TokenCache) flatten() *TokenCache {
+	if t.parent == nil {
+		return t
+	}
+
+	if len(t.idToInfo) == 0 {
+		return t.parent.flatten()
+	}
+
+	c := &TokenCache{
+		idToInfo: t.all(),
+		exists:   make(map[TokenSymbol]bool),
+	}
+
+	for _, info := range c.idToInfo {
+		c.exists[info.Symbol.Normalize()] = true
+	}
+	c.size = len(c.idToInfo)
+	return c
+}
+
+func (t *TokenCache) all() map[TokenID]TokenInfo {
+	var m map[TokenID]TokenInfo
+	if t.parent != nil {
+		m = t.parent.all()
+	} else {
+		m = make(map[TokenID]TokenInfo, len(t.idToInfo))
+	}
+
+	for k, v := range t.idToInfo {
+		m[k] = v
+	}
+	return m
+}
+
 func (t *TokenCache) Exists(s TokenSymbol) bool {
-	return t.exists[s]
+	s = s.Normalize()
+	if t.exists[s] {
+		return true
+	}
+
+	return t.parent != nil && t.parent.Exists(s)
 }
 
 var zeroInfo TokenInfo
 
+func (t *TokenCache) info(id TokenID) (TokenInfo, bool) {
+	if info, ok := t.idToInfo[id]; ok {
+		return info, true
+	}
+
+	if t.parent != nil {
+		return t.parent.info(id)
+	}
+
+	return zeroInfo, false
+}
+
 func (t *TokenCache) Info(id TokenID) TokenInfo {
-	return t.idToInfo[id]
+	info, _ := t.info(id)
+	return info
 }
 
 func (t *TokenCache) Update(id TokenID, info TokenInfo) {
+	if _, ok := t.info(id); !ok {
+		t.size++
+	}
+
 	t.idToInfo[id] = info
-	t.exists[TokenSymbol(strings.ToUpper(string(info.Symbol)))] = true
+	t.exists[info.Symbol.Normalize()] = true
 }
 
 func (t *TokenCache) Size() int {
-	return len(t.idToInfo)
+	return t.size
 }
 
 func (t *TokenCache) Tokens() []Token {
-	keys := make([]TokenID, len(t.idToInfo))
+	all := t.all()
+	keys := make([]TokenID, len(all))
 	i := 0
-	for k := range t.idToInfo {
+	for k := range all {
 		keys[i] = k
 		i++
 	}
@@ -73,7 +273,7 @@ func (t *TokenCache) Tokens() []Token {
 	i = 0
 	tokens := make([]Token, len(keys))
 	for _, k := range keys {
-		tokens[i] = Token{ID: k, TokenInfo: t.idToInfo[k]}
+		tokens[i] = Token{ID: k, TokenInfo: all[k]}
 		i++
 	}
 
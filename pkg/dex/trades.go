@@ -0,0 +1,142 @@
+package dex
+
+import (
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// defaultTradesPerMarket is the default number of the recent
+	// trades kept for each market.
+	defaultTradesPerMarket = 1000
+	// maxTradeMarkets is the maximum number of the markets whose
+	// recent trades are kept, the least recently traded market
+	// is dropped first. Together with the per market size it
+	// bounds the memory of the trade tape.
+	maxTradeMarkets = 256
+)
+
+// Trade is a trade of a market, the side is the taker's side.
+type Trade struct {
+	Price    uint64
+	Quant    uint64
+	SellSide bool
+	Round    uint64
+	// the hash of the txn that placed the taker order, zero for
+	// the triggered stop orders.
+	TxnHash consensus.Hash
+}
+
+// TradesRequest is the argument of the Trades RPC.
+type TradesRequest struct {
+	Market MarketSymbol
+	// the maximum number of the returned trades, it is capped
+	// by the server, 0 means the cap.
+	Limit int
+	// only the trades of the rounds after SinceRound are
+	// returned.
+	SinceRound uint64
+}
+
+// TradesResponse is the recent trades of a market, newest first.
+type TradesResponse struct {
+	Trades []Trade
+}
+
+// tradeRing is a ring buffer of the recent trades of a market.
+type tradeRing struct {
+	trades []Trade
+	// the index of the next trade to write
+	next int
+	full bool
+}
+
+func (r *tradeRing) add(t Trade) {
+	r.trades[r.next] = t
+	r.next++
+	if r.next == len(r.trades) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// newest returns at most limit trades of the rounds after
+// sinceRound, newest first.
+func (r *tradeRing) newest(limit int, sinceRound uint64) []Trade {
+	n := r.next
+	if r.full {
+		n = len(r.trades)
+	}
+
+	var result []Trade
+	for i := 0; i < n && (limit <= 0 || len(result) < limit); i++ {
+		idx := (r.next - 1 - i + len(r.trades)) % len(r.trades)
+		t := r.trades[idx]
+		if t.Round <= sinceRound {
+			// the trades are in the round order
+			break
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// tradeTape keeps the recent trades of the markets in memory, no
+// trade is kept if perMarket <= 0.
+type tradeTape struct {
+	mu        sync.Mutex
+	perMarket int
+	markets   *lru.Cache
+}
+
+func newTradeTape(perMarket int) *tradeTape {
+	markets, err := lru.New(maxTradeMarkets)
+	if err != nil {
+		panic(err)
+	}
+
+	return &tradeTape{perMarket: perMarket, markets: markets}
+}
+
+// add records the trades of the round, the rounds must be added in
+// order.
+func (t *tradeTape) add(round uint64, trades []TradeEvent) {
+	if t.perMarket <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, e := range trades {
+		var r *tradeRing
+		if v, ok := t.markets.Get(e.Market); ok {
+			r = v.(*tradeRing)
+		} else {
+			r = &tradeRing{trades: make([]Trade, t.perMarket)}
+			t.markets.Add(e.Market, r)
+		}
+
+		r.add(Trade{
+			Price:    e.Price,
+			Quant:    e.Quant,
+			SellSide: e.SellSide,
+			Round:    round,
+			TxnHash:  e.TxnHash,
+		})
+	}
+}
+
+func (t *tradeTape) trades(req TradesRequest) []Trade {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.markets.Peek(req.Market)
+	if !ok {
+		return nil
+	}
+
+	return v.(*tradeRing).newest(req.Limit, req.SinceRound)
+}
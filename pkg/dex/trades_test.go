@@ -0,0 +1,52 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentTrades(t *testing.T) {
+	s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+	s.CommitCache()
+	market := MarketSymbol{Base: 0, Quote: 1}
+
+	r := NewRPCServer()
+	r.SetTradesPerMarket(3)
+	var hashes []consensus.Hash
+	for round := uint64(1); round <= 4; round++ {
+		price := round * 100000000
+		trans := s.Transition(round, nil)
+		recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 2, Price: price, Market: market}, round-1))
+		b := MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 2, Price: price, Market: market}, round-1)
+		recordTxn(t, trans, pkTaker, b)
+		hashes = append(hashes, consensus.SHA3(b))
+		s = trans.Commit().(*State)
+		r.Finalized(round, s)
+	}
+
+	var resp TradesResponse
+	assert.Nil(t, r.recentTrades(TradesRequest{Market: market}, &resp))
+	// truncated to the newest 3 trades
+	assert.Equal(t, 3, len(resp.Trades))
+	for i, trade := range resp.Trades {
+		round := uint64(4 - i)
+		assert.Equal(t, Trade{Price: round * 100000000, Quant: 2, Round: round, TxnHash: hashes[round-1]}, trade)
+	}
+
+	assert.Nil(t, r.recentTrades(TradesRequest{Market: market, SinceRound: 2}, &resp))
+	assert.Equal(t, 2, len(resp.Trades))
+	assert.Equal(t, uint64(4), resp.Trades[0].Round)
+	assert.Equal(t, uint64(3), resp.Trades[1].Round)
+
+	assert.Nil(t, r.recentTrades(TradesRequest{Market: market, Limit: 1}, &resp))
+	assert.Equal(t, 1, len(resp.Trades))
+	assert.Equal(t, uint64(4), resp.Trades[0].Round)
+
+	assert.Nil(t, r.recentTrades(TradesRequest{Market: market, SinceRound: 4}, &resp))
+	assert.Equal(t, 0, len(resp.Trades))
+
+	assert.Nil(t, r.recentTrades(TradesRequest{Market: MarketSymbol{Base: 1, Quote: 0}}, &resp))
+	assert.Equal(t, 0, len(resp.Trades))
+}
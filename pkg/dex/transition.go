@@ -1,11 +1,12 @@
 package dex
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math"
 	"math/big"
-	"strings"
+	"sort"
 
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
@@ -14,6 +15,12 @@ import (
 
 var flatFee = uint64(0.0001 * math.Pow10(int(BNBInfo.Decimals)))
 
+var (
+	ErrPriceTickSize = errors.New("order price is not a multiple of the market's tick size")
+	ErrQuantLotSize  = errors.New("order quantity is not a multiple of the market's lot size")
+	ErrMinNotional   = errors.New("order value is smaller than the market's minimal notional")
+)
+
 type Transition struct {
 	round uint64
 	fee   uint64
@@ -22,7 +29,6 @@ type Transition struct {
 	// b. in unit test
 	proposer        PK
 	finalized       bool
-	tokenCreations  []Token
 	txns            [][]byte
 	expirations     map[uint64][]orderExpiration
 	filledOrders    []PendingOrder
@@ -30,6 +36,17 @@ type Transition struct {
 	orderBooks      map[MarketSymbol]*orderBook
 	dirtyOrderBooks map[MarketSymbol]bool
 	tokenCache      *TokenCache
+	// the markets that may have stop orders to trigger
+	stopMarkets map[MarketSymbol]bool
+	// the markets that are known to be in the market list
+	markets    map[MarketSymbol]bool
+	lastPrices map[MarketSymbol]uint64
+	// the execution reports, the trades and the closed orders of
+	// the transition, they are published as the events of the
+	// resulting state.
+	reports map[consensus.Addr][]ExecutionReport
+	trades  []TradeEvent
+	closed  []ClosedOrderEvent
 }
 
 func newTransition(s *State, round uint64, proposer PK) *Transition {
@@ -40,11 +57,18 @@ func newTransition(s *State, round uint64, proposer PK) *Transition {
 		expirations:     make(map[uint64][]orderExpiration),
 		orderBooks:      make(map[MarketSymbol]*orderBook),
 		dirtyOrderBooks: make(map[MarketSymbol]bool),
-		tokenCache:      newTokenCache(s),
+		tokenCache:      s.tokens().fork(),
+		stopMarkets:     make(map[MarketSymbol]bool),
+		markets:         make(map[MarketSymbol]bool),
+		lastPrices:      make(map[MarketSymbol]uint64),
+		reports:         make(map[consensus.Addr][]ExecutionReport),
 		filledOrders:    make([]PendingOrder, 0, 1000), // optimization: preallocate buffer
 	}
 }
 
+// RecordSerialized records the serialized txns of a block, the txns
+// already parsed by the pool are taken from it and removed from it.
+// The pool can be nil when a finalized block is replayed.
 func (t *Transition) RecordSerialized(blob []byte, pool consensus.TxnPool) (int, error) {
 	var txns [][]byte
 	err := rlp.DecodeBytes(blob, &txns)
@@ -54,13 +78,40 @@ func (t *Transition) RecordSerialized(blob []byte, pool consensus.TxnPool) (int,
 
 	for _, b := range txns {
 		hash := consensus.SHA3(b)
-		txn := pool.Get(hash)
+		if len(b) > consensus.MaxTxnBytes {
+			return 0, fmt.Errorf("txn %v in block exceeds the max txn size, size: %d", hash, len(b))
+		}
+
+		var txn *consensus.Txn
+		if pool != nil {
+			txn = pool.Get(hash)
+		}
+
+		if txn != nil && !ownerSigned(txn, t.state) {
+			// the pool derived the signers against
+			// its leader state, the account's policy
+			// can differ in the state of the block.
+			txn = nil
+		}
+
 		if txn == nil {
-			txn, _ = pool.Add(b)
+			// the txn is validated against the state of
+			// the block rather than the pool's leader
+			// state.
+			txn, err = parseTxn(b, t.state)
+			if err != nil {
+				return 0, fmt.Errorf("invalid txn %v in block: %v", hash, err)
+			}
 		}
 
 		if txn.MinerFeeTxn {
-			t.giveMinerFee(*txn.Decoded.(*MinerFeeTxn))
+			minerFee := txn.Decoded.(*MinerFeeTxn)
+			if minerFee.Fee != t.fee {
+				return 0, fmt.Errorf("miner fee %d does not match the collected fee %d", minerFee.Fee, t.fee)
+			}
+
+			t.fee = 0
+			t.giveMinerFee(*minerFee)
 			continue
 		}
 
@@ -68,7 +119,10 @@ func (t *Transition) RecordSerialized(blob []byte, pool consensus.TxnPool) (int,
 		if err != nil {
 			return 0, err
 		}
-		pool.Remove(hash)
+
+		if pool != nil {
+			pool.Remove(hash)
+		}
 	}
 
 	return len(txns), nil
@@ -83,12 +137,42 @@ func (t *Transition) RecordImpl(txn *consensus.Txn, forceFee bool) (err error) {
 	if t.finalized {
 		panic("record should never be called after finalized")
 	}
+
+	if len(txn.Raw) > consensus.MaxTxnBytes {
+		return fmt.Errorf("txn exceeds the max txn size, size: %d", len(txn.Raw))
+	}
+
 	acc := t.state.Account(txn.Owner)
 	if acc == nil {
-		return errors.New("txn owner not found")
+		if len(txn.OwnerPK) == 0 {
+			return errors.New("txn owner not found")
+		}
+
+		pk := PK(txn.OwnerPK)
+		if pk.Addr() != txn.Owner {
+			return errors.New("txn owner PK does not match the owner")
+		}
+
+		// the txn introduces the owner's PK, it is saved
+		// only if the txn is recorded.
+		acc = t.state.NewAccount(pk)
+		defer func() {
+			if err != nil {
+				t.state.removeAccountCache(txn.Owner)
+			}
+		}()
 	}
 
 	if !txn.MinerFeeTxn {
+		// the policy may be set after the txn is verified.
+		if policy := t.state.AccountPolicy(txn.Owner); policy != nil {
+			if !policy.authorized(txn.Signers) {
+				return errors.New("txn is not signed by enough owners of the multisig account")
+			}
+		} else if len(txn.Signers) != 1 || txn.Signers[0] != txn.Owner {
+			return errors.New("txn is not signed by the owner of the single key account")
+		}
+
 		if nonce := acc.Nonce(); txn.Nonce < nonce {
 			return errors.New("nonce not valid")
 		} else if txn.Nonce > nonce {
@@ -96,24 +180,33 @@ func (t *Transition) RecordImpl(txn *consensus.Txn, forceFee bool) (err error) {
 		}
 	}
 
+	if minFee := t.state.MinTxnFee(); txn.Fee < minFee {
+		return fmt.Errorf("txn fee %d is less than the min txn fee %d", txn.Fee, minFee)
+	}
+
+	if txn.Fee > math.MaxUint64-flatFee {
+		return fmt.Errorf("txn fee %d is too large", txn.Fee)
+	}
+
 	payFee := forceFee || t.proposer != nil
+	fee := flatFee + txn.Fee
 
 	if payFee {
 		nativeCoin := acc.Balance(0)
-		if nativeCoin.Available < flatFee {
+		if nativeCoin.Available < fee {
 			return errors.New("account don't have sufficient balance to pay fee")
 		}
 
-		nativeCoin.Available -= flatFee
+		nativeCoin.Available -= fee
 		acc.UpdateBalance(0, nativeCoin)
-		t.fee += flatFee
+		t.fee += fee
 	}
 	defer func() {
 		if payFee && err != nil {
 			nativeCoin := acc.Balance(0)
-			nativeCoin.Available += flatFee
+			nativeCoin.Available += fee
 			acc.UpdateBalance(0, nativeCoin)
-			t.fee -= flatFee
+			t.fee -= fee
 		}
 
 		if !txn.MinerFeeTxn && err == nil {
@@ -121,15 +214,20 @@ func (t *Transition) RecordImpl(txn *consensus.Txn, forceFee bool) (err error) {
 		}
 	}()
 
+	hash := consensus.SHA3(txn.Raw)
 	switch tx := txn.Decoded.(type) {
 	case *PlaceOrderTxn:
-		if err := t.placeOrder(acc, tx, t.round); err != nil {
+		if err := t.placeOrder(acc, tx, t.round, hash); err != nil {
 			return err
 		}
 	case *CancelOrderTxn:
 		if err := t.cancelOrder(acc, tx); err != nil {
 			return err
 		}
+	case *CancelAllOrdersTxn:
+		if err := t.cancelAllOrders(acc, tx, hash); err != nil {
+			return err
+		}
 	case *IssueTokenTxn:
 		if err := t.issueToken(acc, tx); err != nil {
 			return err
@@ -146,6 +244,14 @@ func (t *Transition) RecordImpl(txn *consensus.Txn, forceFee bool) (err error) {
 		if err := t.burnToken(acc, tx); err != nil {
 			return err
 		}
+	case *MintTokenTxn:
+		if err := t.mintToken(acc, tx); err != nil {
+			return err
+		}
+	case *UpdateAccountPolicyTxn:
+		if err := t.updateAccountPolicy(acc, tx); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unknown txn type: %T", txn.Decoded)
 	}
@@ -173,10 +279,39 @@ func (t *Transition) burnToken(acc *Account, txn *BurnTokenTxn) error {
 		return fmt.Errorf("not enough total supply to burn, want: %d, have: %d", txn.Quant, info.TotalUnits)
 	}
 
+	token, _ := t.state.Token(txn.ID)
 	balance.Available -= txn.Quant
-	info.TotalUnits -= txn.Quant
+	token.TotalUnits = info.TotalUnits - txn.Quant
+	acc.UpdateBalance(txn.ID, balance)
+	t.state.UpdateToken(token)
+	t.tokenCache.Update(txn.ID, token.TokenInfo)
+	return nil
+}
+
+func (t *Transition) mintToken(acc *Account, txn *MintTokenTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("mint token quantity should not be 0")
+	}
+
+	token, ok := t.state.Token(txn.ID)
+	if !ok {
+		return fmt.Errorf("trying to mint non-existent token: %d", txn.ID)
+	}
+
+	if token.Issuer != acc.PK().Addr() {
+		return fmt.Errorf("only the issuer can mint token %d", txn.ID)
+	}
+
+	if token.TotalUnits+txn.Quant < token.TotalUnits || token.TotalUnits+txn.Quant > token.MaxSupply {
+		return fmt.Errorf("mint exceeds max supply, want: %d, total: %d, max: %d", txn.Quant, token.TotalUnits, token.MaxSupply)
+	}
+
+	balance := acc.Balance(txn.ID)
+	balance.Available += txn.Quant
+	token.TotalUnits += txn.Quant
 	acc.UpdateBalance(txn.ID, balance)
-	t.state.UpdateToken(Token{ID: txn.ID, TokenInfo: info})
+	t.state.UpdateToken(token)
+	t.tokenCache.Update(txn.ID, token.TokenInfo)
 	return nil
 }
 
@@ -220,12 +355,156 @@ func (t *Transition) cancelOrder(owner *Account, txn *CancelOrderTxn) error {
 
 	book := t.getOrderBook(txn.ID.Market)
 	book.Cancel(txn.ID.ID)
+	t.state.RemoveStopOrder(txn.ID.Market, txn.ID.ID)
 	t.dirtyOrderBooks[txn.ID.Market] = true
 	owner.RemovePendingOrder(txn.ID)
 	t.refundAfterCancel(owner, cancel, txn.ID.Market)
+	t.closed = append(t.closed, ClosedOrderEvent{Order: cancel, Status: OrderCancelled})
 	return nil
 }
 
+func (t *Transition) checkMarketParams(txn *PlaceOrderTxn, baseInfo, quoteInfo TokenInfo) error {
+	params := t.state.MarketParams(txn.Market)
+	// the price of a market order is the slippage bound, it's
+	// not required to be on the tick.
+	if !txn.MarketOrder && txn.Price%params.TickSize != 0 {
+		return ErrPriceTickSize
+	}
+
+	if txn.Quant%params.LotSize != 0 {
+		return ErrQuantLotSize
+	}
+
+	if calcQuoteQuant(txn.Quant, quoteInfo.Decimals, txn.Price, OrderPriceDecimals, baseInfo.Decimals) < params.MinNotional {
+		return ErrMinNotional
+	}
+
+	return nil
+}
+
+// triggerStopOrders converts the stop orders whose stop price is
+// crossed by the last traded price into limit orders. The markets
+// are processed in order, and the stop orders of a market in the
+// order of their IDs. It repeats until no market has new trades,
+// since the triggered orders may trade as well.
+func (t *Transition) triggerStopOrders() {
+	for len(t.stopMarkets) > 0 {
+		markets := make([]MarketSymbol, 0, len(t.stopMarkets))
+		for m := range t.stopMarkets {
+			markets = append(markets, m)
+		}
+
+		sort.Slice(markets, func(i, j int) bool {
+			return bytes.Compare(markets[i].Encode(), markets[j].Encode()) < 0
+		})
+
+		t.stopMarkets = make(map[MarketSymbol]bool)
+		for _, m := range markets {
+			t.triggerMarketStopOrders(m)
+		}
+	}
+}
+
+func (t *Transition) triggerMarketStopOrders(m MarketSymbol) {
+	price, ok := t.lastPrice(m)
+	if !ok {
+		return
+	}
+
+	baseInfo := t.tokenCache.Info(m.Base)
+	quoteInfo := t.tokenCache.Info(m.Quote)
+	for _, o := range t.state.StopOrders(m) {
+		if o.SellSide && price > o.StopPrice || !o.SellSide && price < o.StopPrice {
+			continue
+		}
+
+		t.state.RemoveStopOrder(m, o.ID)
+		executions := t.getOrderBook(m).LimitWithID(o.ID, o.Order)
+		t.dirtyOrderBooks[m] = true
+		// the txn that placed the stop order is not
+		// recorded, the trades have no txn hash.
+		t.applyExecutions(m, executions, t.round, consensus.Hash{}, baseInfo, quoteInfo)
+	}
+}
+
+func (t *Transition) lastPrice(m MarketSymbol) (uint64, bool) {
+	if p, ok := t.lastPrices[m]; ok {
+		return p, true
+	}
+
+	return t.state.LastPrice(m)
+}
+
+func (t *Transition) saveLastPrices() {
+	for m, p := range t.lastPrices {
+		t.state.UpdateLastPrice(m, p)
+	}
+}
+
+func (t *Transition) cancelAllOrders(owner *Account, txn *CancelAllOrdersTxn, hash consensus.Hash) error {
+	if !txn.Market.Valid() {
+		return fmt.Errorf("market is invalid: %v", txn.Market)
+	}
+
+	var cancelled []OrderID
+	expirations := make(map[uint64]map[OrderID]bool)
+	book := t.getOrderBook(txn.Market)
+	for _, o := range owner.PendingOrders() {
+		if len(cancelled) >= MaxCancelAllOrders {
+			break
+		}
+
+		if o.ID.Market != txn.Market {
+			continue
+		}
+
+		if txn.FilterSide && o.SellSide != txn.SellSide {
+			continue
+		}
+
+		book.Cancel(o.ID.ID)
+		t.state.RemoveStopOrder(txn.Market, o.ID.ID)
+		owner.RemovePendingOrder(o.ID)
+		t.refundAfterCancel(owner, o, txn.Market)
+		t.closed = append(t.closed, ClosedOrderEvent{Order: o, Status: OrderCancelled})
+		if o.ExpireRound > 0 {
+			if expirations[o.ExpireRound] == nil {
+				expirations[o.ExpireRound] = make(map[OrderID]bool)
+			}
+			expirations[o.ExpireRound][o.ID] = true
+		}
+		cancelled = append(cancelled, o.ID)
+	}
+
+	if len(cancelled) > 0 {
+		t.dirtyOrderBooks[txn.Market] = true
+	}
+
+	for round, ids := range expirations {
+		t.removeExpirations(round, ids)
+	}
+
+	t.state.UpdateReceipt(hash, Receipt{Cancelled: cancelled})
+	return nil
+}
+
+// removeExpirations removes the order expirations of the given round,
+// both the ones recorded in the current transition and the ones saved
+// in the state.
+func (t *Transition) removeExpirations(round uint64, ids map[OrderID]bool) {
+	if exps, ok := t.expirations[round]; ok {
+		newExps := make([]orderExpiration, 0, len(exps))
+		for _, exp := range exps {
+			if !ids[exp.ID] {
+				newExps = append(newExps, exp)
+			}
+		}
+		t.expirations[round] = newExps
+	}
+
+	t.state.RemoveOrderExpirations(round, ids)
+}
+
 func (t *Transition) refundAfterCancel(owner *Account, cancel PendingOrder, market MarketSymbol) {
 	if cancel.Quant <= cancel.Executed {
 		panic(fmt.Errorf("pending order remain amount should be greater than 0, total: %d, executed: %d", cancel.Quant, cancel.Executed))
@@ -244,9 +523,9 @@ func (t *Transition) refundAfterCancel(owner *Account, cancel PendingOrder, mark
 		owner.UpdateBalance(market.Base, baseBalance)
 	} else {
 		quoteBalance := owner.Balance(market.Quote)
-		quoteInfo := t.tokenCache.idToInfo[market.Quote]
-		baseInfo := t.tokenCache.idToInfo[market.Base]
-		pendingQuant := calcQuoteQuant(refund, quoteInfo.Decimals, cancel.Price, OrderPriceDecimals, baseInfo.Decimals)
+		quoteInfo := t.tokenCache.Info(market.Quote)
+		baseInfo := t.tokenCache.Info(market.Base)
+		pendingQuant := t.buyReserve(refund, cancel.Price, baseInfo, quoteInfo)
 
 		if quoteBalance.Pending < pendingQuant {
 			panic(fmt.Errorf("pending balance smaller than refund, pending: %d, refund: %d", quoteBalance.Pending, pendingQuant))
@@ -258,6 +537,15 @@ func (t *Transition) refundAfterCancel(owner *Account, cancel PendingOrder, mark
 	}
 }
 
+// Receipt records the result of a txn that is not reflected by the
+// account balances.
+type Receipt struct {
+	Cancelled []OrderID
+	// the fill-or-kill order is killed since it can not be
+	// fully filled.
+	Killed bool
+}
+
 type ExecutionReport struct {
 	Round      uint64
 	ID         OrderID
@@ -267,11 +555,51 @@ type ExecutionReport struct {
 	Fee        uint64
 }
 
-func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64) error {
+// DecodeRLP decodes the execution report, the reports stored before
+// Fee was added decode with a zero Fee.
+func (e *ExecutionReport) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	if err := decodeFields(s, &e.Round, &e.ID, &e.SellSide, &e.TradePrice, &e.Quant); err != nil {
+		return err
+	}
+
+	e.Fee = 0
+	if err := decodeOptional(s, &e.Fee); err != nil {
+		return err
+	}
+
+	return s.ListEnd()
+}
+
+func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64, hash consensus.Hash) error {
+	if txn.MarketOrder {
+		if txn.Price != 0 {
+			return fmt.Errorf("market order's price should be 0, price: %d", txn.Price)
+		}
+
+		if txn.MaxSlippageQuote == 0 {
+			return errors.New("market order's max slippage is not specified")
+		}
+
+		// a market order is an immediate-or-cancel order
+		// priced at the slippage bound, the buy side balance is
+		// reserved by the bound, and the unused part is
+		// refunded after matching.
+		o := *txn
+		o.Price = o.MaxSlippageQuote
+		if o.TIF == GTC {
+			o.TIF = IOC
+		}
+		txn = &o
+	}
+
 	if !txn.Market.Valid() {
 		return fmt.Errorf("order's market is invalid: %v", txn.Market)
 	}
-	if txn.ExpireRound > 0 && round >= txn.ExpireRound {
+	if expiredAt(txn.ExpireRound, round) {
 		return fmt.Errorf("order already expired, order expire round: %d, cur round: %d", txn.ExpireRound, round)
 	}
 
@@ -285,6 +613,36 @@ func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64
 		return fmt.Errorf("trying to place order on nonexistent token: %d", txn.Market.Quote)
 	}
 
+	if err := t.checkMarketParams(txn, baseInfo, quoteInfo); err != nil {
+		return err
+	}
+
+	if txn.TIF > FOK {
+		return fmt.Errorf("unknown time in force: %d", txn.TIF)
+	}
+
+	if txn.StopPrice > 0 && (txn.MarketOrder || txn.TIF != GTC) {
+		return errors.New("stop order must be a GTC limit order")
+	}
+
+	if txn.DisplayQuant > 0 {
+		if txn.MarketOrder || txn.TIF != GTC {
+			return errors.New("iceberg order must be a GTC limit order")
+		}
+
+		if txn.DisplayQuant >= txn.Quant {
+			return fmt.Errorf("iceberg order's display quant %d should be less than its quant %d", txn.DisplayQuant, txn.Quant)
+		}
+	}
+
+	if txn.TIF == FOK {
+		o := Order{SellSide: txn.SellSide, Quant: txn.Quant, Price: txn.Price}
+		if t.getOrderBook(txn.Market).Matchable(o) < txn.Quant {
+			t.state.UpdateReceipt(hash, Receipt{Killed: true})
+			return nil
+		}
+	}
+
 	if txn.SellSide {
 		if txn.Quant == 0 {
 			return errors.New("sell: can not sell 0 quantity")
@@ -303,7 +661,7 @@ func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64
 			return errors.New("buy failed: can not buy 0 quantity")
 		}
 
-		pendingQuant := calcQuoteQuant(txn.Quant, quoteInfo.Decimals, txn.Price, OrderPriceDecimals, baseInfo.Decimals)
+		pendingQuant := t.buyReserve(txn.Quant, txn.Price, baseInfo, quoteInfo)
 		if pendingQuant == 0 {
 			return errors.New("buy failed: converted quote quant is 0")
 		}
@@ -318,16 +676,38 @@ func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64
 		owner.UpdateBalance(txn.Market.Quote, quoteBalance)
 	}
 
+	t.addMarket(txn.Market)
 	order := Order{
 		Owner:       owner.PK().Addr(),
 		SellSide:    txn.SellSide,
 		Quant:       txn.Quant,
 		Price:       txn.Price,
 		ExpireRound: txn.ExpireRound,
+		Display:     txn.DisplayQuant,
 	}
 
 	book := t.getOrderBook(txn.Market)
-	orderID, executions := book.Limit(order)
+	if txn.StopPrice > 0 {
+		// the stop order is not visible in the order book
+		// until triggered.
+		id := OrderID{ID: book.NewID(), Market: txn.Market}
+		t.dirtyOrderBooks[txn.Market] = true
+		owner.UpdatePendingOrder(PendingOrder{ID: id, Order: order})
+		if order.ExpireRound > 0 {
+			t.expirations[order.ExpireRound] = append(t.expirations[order.ExpireRound], orderExpiration{ID: id, Owner: owner.PK().Addr()})
+		}
+		t.state.AddStopOrder(txn.Market, stopOrder{ID: id.ID, StopPrice: txn.StopPrice, Order: order})
+		t.stopMarkets[txn.Market] = true
+		return nil
+	}
+
+	var orderID uint64
+	var executions []orderExecution
+	if txn.TIF == GTC {
+		orderID, executions = book.Limit(order)
+	} else {
+		orderID, executions = book.ImmediateOrCancel(order)
+	}
 	t.dirtyOrderBooks[txn.Market] = true
 	id := OrderID{ID: orderID, Market: txn.Market}
 	pendingOrder := PendingOrder{
@@ -335,83 +715,240 @@ func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64
 		Order: order,
 	}
 	owner.UpdatePendingOrder(pendingOrder)
-	if order.ExpireRound > 0 {
+	if order.ExpireRound > 0 && txn.TIF == GTC {
 		t.expirations[order.ExpireRound] = append(t.expirations[order.ExpireRound], orderExpiration{ID: id, Owner: owner.PK().Addr()})
 	}
 
-	if len(executions) > 0 {
-		for _, exec := range executions {
-			acc := t.state.Account(exec.Owner)
-			orderID := OrderID{ID: exec.ID, Market: txn.Market}
-			report := ExecutionReport{
-				Round:      round,
-				ID:         orderID,
-				SellSide:   exec.SellSide,
-				TradePrice: exec.Price,
-				Quant:      exec.Quant,
-			}
-			acc.AddExecutionReport(report)
-			executedOrder, ok := acc.PendingOrder(orderID)
-			if !ok {
-				panic(fmt.Errorf("impossible: can not find matched order %d, market: %v, executed order: %v", exec.ID, txn.Market, exec))
-			}
+	t.applyExecutions(txn.Market, executions, round, hash, baseInfo, quoteInfo)
+
+	if txn.TIF != GTC {
+		t.releaseUnfilled(owner, id, order, executions, baseInfo, quoteInfo)
+	}
+	return nil
+}
+
+// addMarket adds the market to the state's market list if it is not
+// listed yet.
+func (t *Transition) addMarket(m MarketSymbol) {
+	if t.markets[m] {
+		return
+	}
+
+	t.markets[m] = true
+	if !t.state.HasMarket(m) {
+		t.state.AddMarket(m)
+	}
+}
+
+// applyExecutions updates the accounts of the matched orders, hash
+// is the hash of the txn that placed the taker order.
+func (t *Transition) applyExecutions(market MarketSymbol, executions []orderExecution, round uint64, hash consensus.Hash, baseInfo, quoteInfo TokenInfo) {
+	if len(executions) == 0 {
+		return
+	}
+
+	t.lastPrices[market] = executions[len(executions)-1].Price
+	t.stopMarkets[market] = true
+	tradingFee, chargeFee := t.state.TradingFee()
+	for _, exec := range executions {
+		acc := t.state.Account(exec.Owner)
+		orderID := OrderID{ID: exec.ID, Market: market}
+		var fee uint64
+		if chargeFee {
+			fee = t.chargeTradingFee(tradingFee, market, exec, baseInfo, quoteInfo)
+		}
+
+		report := ExecutionReport{
+			Round:      round,
+			ID:         orderID,
+			SellSide:   exec.SellSide,
+			TradePrice: exec.Price,
+			Quant:      exec.Quant,
+			Fee:        fee,
+		}
+		acc.AddExecutionReport(report)
+		t.reports[exec.Owner] = append(t.reports[exec.Owner], report)
+		if exec.Taker {
+			t.trades = append(t.trades, TradeEvent{
+				Market:   market,
+				Price:    exec.Price,
+				Quant:    exec.Quant,
+				SellSide: exec.SellSide,
+				TxnHash:  hash,
+			})
+		}
+		executedOrder, ok := acc.PendingOrder(orderID)
+		if !ok {
+			panic(fmt.Errorf("impossible: can not find matched order %d, market: %v, executed order: %v", exec.ID, market, exec))
+		}
 
-			executedOrder.Executed += exec.Quant
-			if executedOrder.Executed == executedOrder.Quant {
-				acc.RemovePendingOrder(orderID)
-				t.filledOrders = append(t.filledOrders, executedOrder)
-			} else {
-				acc.UpdatePendingOrder(executedOrder)
+		executedOrder.Executed += exec.Quant
+		if executedOrder.Executed == executedOrder.Quant {
+			acc.RemovePendingOrder(orderID)
+			t.filledOrders = append(t.filledOrders, executedOrder)
+			t.closed = append(t.closed, ClosedOrderEvent{Order: executedOrder, Status: OrderFilled})
+		} else {
+			acc.UpdatePendingOrder(executedOrder)
+		}
+
+		baseBalance := acc.Balance(market.Base)
+		quoteBalance := acc.Balance(market.Quote)
+		if exec.SellSide {
+			if baseBalance.Pending < exec.Quant {
+				panic(fmt.Errorf("insufficient pending balance, owner: %v, pending %d, executed: %d, sell side, taker: %t", exec.Owner, baseBalance.Pending, exec.Quant, exec.Taker))
 			}
 
-			baseBalance := acc.Balance(txn.Market.Base)
-			quoteBalance := acc.Balance(txn.Market.Quote)
-			if exec.SellSide {
-				if baseBalance.Pending < exec.Quant {
-					panic(fmt.Errorf("insufficient pending balance, owner: %v, pending %d, executed: %d, sell side, taker: %t", exec.Owner, baseBalance.Pending, exec.Quant, exec.Taker))
-				}
-
-				baseBalance.Pending -= exec.Quant
-				recvQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
-				quoteBalance.Available += recvQuant
-				acc.UpdateBalance(txn.Market.Base, baseBalance)
-				acc.UpdateBalance(txn.Market.Quote, quoteBalance)
-			} else {
-				recvQuant := exec.Quant
-				pendingQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, executedOrder.Price, OrderPriceDecimals, baseInfo.Decimals)
-				givenQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
-
-				if quoteBalance.Pending < pendingQuant {
-					panic(fmt.Errorf("insufficient pending balance, owner: %v, pending %d, executed: %d, buy side, taker: %t", exec.Owner, quoteBalance.Pending, exec.Quant, exec.Taker))
-				}
-
-				quoteBalance.Pending -= pendingQuant
-				quoteBalance.Available += pendingQuant
-				quoteBalance.Available -= givenQuant
-				baseBalance.Available += recvQuant
-				acc.UpdateBalance(txn.Market.Base, baseBalance)
-				acc.UpdateBalance(txn.Market.Quote, quoteBalance)
+			baseBalance.Pending -= exec.Quant
+			recvQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
+			quoteBalance.Available += recvQuant - fee
+			acc.UpdateBalance(market.Base, baseBalance)
+			acc.UpdateBalance(market.Quote, quoteBalance)
+		} else {
+			recvQuant := exec.Quant
+			pendingQuant := t.buyReserve(exec.Quant, executedOrder.Price, baseInfo, quoteInfo)
+			givenQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals) + fee
+
+			if quoteBalance.Pending < pendingQuant {
+				panic(fmt.Errorf("insufficient pending balance, owner: %v, pending %d, executed: %d, buy side, taker: %t", exec.Owner, quoteBalance.Pending, exec.Quant, exec.Taker))
 			}
+
+			quoteBalance.Pending -= pendingQuant
+			quoteBalance.Available += pendingQuant
+			quoteBalance.Available -= givenQuant
+			baseBalance.Available += recvQuant
+			acc.UpdateBalance(market.Base, baseBalance)
+			acc.UpdateBalance(market.Quote, quoteBalance)
 		}
 	}
-	return nil
+}
+
+// chargeTradingFee credits the fee of the execution to the fee
+// pool and returns the fee, which is in the quote token for both
+// sides.
+func (t *Transition) chargeTradingFee(f TradingFee, market MarketSymbol, exec orderExecution, baseInfo, quoteInfo TokenInfo) uint64 {
+	rate := f.MakerBps
+	if exec.Taker {
+		rate = f.TakerBps
+	}
+
+	value := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
+	fee := calcFee(value, rate)
+	if fee == 0 {
+		return 0
+	}
+
+	pool := t.state.Account(f.Pool.Addr())
+	if pool == nil {
+		pool = t.state.NewAccount(f.Pool)
+	}
+
+	b := pool.Balance(market.Quote)
+	b.Available += fee
+	pool.UpdateBalance(market.Quote, b)
+	return fee
+}
+
+// buyReserve returns the quote quantity reserved for buying the base
+// quantity at the price: the cost and the highest trading fee of it,
+// so that the fee is always covered when the order executes.
+func (t *Transition) buyReserve(quant, price uint64, baseInfo, quoteInfo TokenInfo) uint64 {
+	cost := calcQuoteQuant(quant, quoteInfo.Decimals, price, OrderPriceDecimals, baseInfo.Decimals)
+	f, ok := t.state.TradingFee()
+	if !ok {
+		return cost
+	}
+
+	rate := f.MakerBps
+	if f.TakerBps > rate {
+		rate = f.TakerBps
+	}
+	return cost + calcFee(cost, rate)
+}
+
+// calcFee returns the fee of the quantity in basis points, rounded
+// down as the pricing calculation.
+func calcFee(quant, bps uint64) uint64 {
+	var result big.Int
+	var rate big.Int
+	result.SetUint64(quant)
+	rate.SetUint64(bps)
+	result.Mul(&result, &rate)
+	result.Div(&result, big.NewInt(10000))
+	return result.Uint64()
+}
+
+// releaseUnfilled removes the order that must not rest on the order
+// book and releases the pending balance reserved for its unfilled
+// part.
+func (t *Transition) releaseUnfilled(owner *Account, id OrderID, order Order, executions []orderExecution, baseInfo, quoteInfo TokenInfo) {
+	if p, ok := owner.PendingOrder(id); ok {
+		owner.RemovePendingOrder(id)
+		t.closed = append(t.closed, ClosedOrderEvent{Order: p, Status: OrderCancelled})
+	}
+
+	var executed, released uint64
+	for _, exec := range executions {
+		if exec.Taker {
+			executed += exec.Quant
+			released += t.buyReserve(exec.Quant, order.Price, baseInfo, quoteInfo)
+		}
+	}
+
+	if order.SellSide {
+		refund := order.Quant - executed
+		if refund == 0 {
+			return
+		}
+
+		baseBalance := owner.Balance(id.Market.Base)
+		baseBalance.Pending -= refund
+		baseBalance.Available += refund
+		owner.UpdateBalance(id.Market.Base, baseBalance)
+	} else {
+		// calculate the refund from the total reserved quantity
+		// to avoid leaving the rounding errors in the pending
+		// balance.
+		refund := t.buyReserve(order.Quant, order.Price, baseInfo, quoteInfo) - released
+		if refund == 0 {
+			return
+		}
+
+		quoteBalance := owner.Balance(id.Market.Quote)
+		quoteBalance.Pending -= refund
+		quoteBalance.Available += refund
+		owner.UpdateBalance(id.Market.Quote, quoteBalance)
+	}
 }
 
 func (t *Transition) issueToken(owner *Account, txn *IssueTokenTxn) error {
-	if t.tokenCache.Exists(txn.Info.Symbol) {
+	if err := txn.Info.Validate(); err != nil {
+		return err
+	}
+
+	// the symbol index also contains the tokens issued in the
+	// current transition.
+	if _, ok := t.state.TokenBySymbol(txn.Info.Symbol); ok {
 		return fmt.Errorf("token symbol %v already exists", txn.Info.Symbol)
 	}
 
-	for _, v := range t.tokenCreations {
-		if strings.ToUpper(string(txn.Info.Symbol)) == strings.ToUpper(string(v.Symbol)) {
-			return fmt.Errorf("token symbol %v already exists in the current transition", txn.Info.Symbol)
+	id := TokenID(t.tokenCache.Size())
+	for _, m := range txn.Markets {
+		if t.tokenCache.Info(m.Quote) == zeroInfo {
+			return fmt.Errorf("market quote token %d does not exist", m.Quote)
+		}
+
+		if !m.Params.Valid() {
+			return fmt.Errorf("invalid market params: %v", m.Params)
 		}
 	}
 
-	id := TokenID(t.tokenCache.Size() + len(t.tokenCreations))
-	token := Token{ID: id, TokenInfo: txn.Info}
-	t.tokenCreations = append(t.tokenCreations, token)
+	for _, m := range txn.Markets {
+		t.state.UpdateMarketParams(MarketSymbol{Base: id, Quote: m.Quote}, m.Params)
+	}
+
+	token := Token{ID: id, TokenInfo: txn.Info, Issuer: owner.PK().Addr()}
 	t.state.UpdateToken(token)
+	t.tokenCache.Update(id, token.TokenInfo)
 	owner.UpdateBalance(id, Balance{Available: txn.Info.TotalUnits})
 	return nil
 }
@@ -478,17 +1015,13 @@ func (t *Transition) appendFeeTxn() {
 			Fee:   t.fee,
 		}
 		txn := Txn{
-			T:    MinerFee,
-			Data: gobEncode(feeTxn),
-		}
-
-		b, err := rlp.EncodeToBytes(txn)
-		if err != nil {
-			panic(err)
+			T:       MinerFee,
+			Data:    encodePayload(feeTxn),
+			version: TxnVersion,
 		}
 
 		t.fee = 0
-		t.txns = append(t.txns, b)
+		t.txns = append(t.txns, txn.Bytes())
 		t.giveMinerFee(feeTxn)
 	}
 }
@@ -496,6 +1029,11 @@ func (t *Transition) appendFeeTxn() {
 func (t *Transition) finalizeState() {
 	if !t.finalized {
 		t.appendFeeTxn()
+		// must be called before
+		// t.removeFilledOrderFromExpiration, since the
+		// triggered orders could fill orders.
+		t.triggerStopOrders()
+		t.saveLastPrices()
 		t.removeFilledOrderFromExpiration()
 		// must be called after
 		// t.removeFilledOrderFromExpiration
@@ -508,6 +1046,10 @@ func (t *Transition) finalizeState() {
 		// make order book dirty.
 		t.saveDirtyOrderBooks()
 		t.releaseTokens()
+		// must be called before t.state.CommitCache, which
+		// clears the dirty flags of the accounts.
+		t.state.events = t.events()
+		t.state.round = t.round
 		t.state.CommitCache()
 		t.finalized = true
 	}
@@ -562,8 +1104,18 @@ func (t *Transition) removeFilledOrderFromExpiration() {
 }
 
 func (t *Transition) releaseTokens() {
-	// release the tokens that will be released next round
-	tokens := t.state.GetFreezeTokens(t.round + 1)
+	// release the tokens that will be released next round, and
+	// the ones of the earlier rounds that were never processed
+	// (e.g., the rounds without a block). The consumed entries
+	// are pruned from the state.
+	for _, round := range t.state.FreezeTokenRounds(t.round + 1) {
+		t.releaseTokensAt(round)
+		t.state.DeleteFreezeTokens(round)
+	}
+}
+
+func (t *Transition) releaseTokensAt(round uint64) {
+	tokens := t.state.GetFreezeTokens(round)
 	addrToAcc := make(map[consensus.Addr]*Account)
 	for _, token := range tokens {
 		acc, ok := addrToAcc[token.Addr]
@@ -588,11 +1140,21 @@ func (t *Transition) releaseTokens() {
 }
 
 func (t *Transition) expireOrders() {
-	// expire orders whose expiration is the next round
-	orders := t.state.GetOrderExpirations(t.round + 1)
+	// expire orders whose expiration is the next round, and the
+	// ones of the earlier rounds that were never processed. The
+	// consumed entries are pruned from the state.
+	for _, round := range t.state.OrderExpirationRounds(t.round + 1) {
+		t.expireOrdersAt(round)
+		t.state.DeleteOrderExpirations(round)
+	}
+}
+
+func (t *Transition) expireOrdersAt(round uint64) {
+	orders := t.state.GetOrderExpirations(round)
 	addrToAcc := make(map[consensus.Addr]*Account)
 	for _, o := range orders {
 		t.getOrderBook(o.ID.Market).Cancel(o.ID.ID)
+		t.state.RemoveStopOrder(o.ID.Market, o.ID.ID)
 		t.dirtyOrderBooks[o.ID.Market] = true
 
 		acc, ok := addrToAcc[o.Owner]
@@ -609,6 +1171,7 @@ func (t *Transition) expireOrders() {
 
 		acc.RemovePendingOrder(o.ID)
 		t.refundAfterCancel(acc, order, o.ID.Market)
+		t.closed = append(t.closed, ClosedOrderEvent{Order: order, Status: OrderExpired})
 	}
 }
 
@@ -645,9 +1208,6 @@ func (t *Transition) StateHash() consensus.Hash {
 
 func (t *Transition) Commit() consensus.State {
 	t.finalizeState()
-	for _, v := range t.tokenCreations {
-		t.tokenCache.Update(v.ID, v.TokenInfo)
-	}
-
+	t.state.setTokenCache(t.tokenCache.flatten())
 	return t.state
 }
@@ -9,13 +9,22 @@ import (
 )
 
 type myPKer struct {
-	m map[consensus.Addr]PK
+	m       map[consensus.Addr]PK
+	chainID consensus.Hash
 }
 
 func (m *myPKer) PK(addr consensus.Addr) PK {
 	return m.m[addr]
 }
 
+func (m *myPKer) AccountPolicy(consensus.Addr) *AccountPolicy {
+	return nil
+}
+
+func (m *myPKer) ChainID() consensus.Hash {
+	return m.chainID
+}
+
 func genStateTxns(p *myPKer) (consensus.State, []byte) {
 	const (
 		accountCount = 10000
@@ -48,7 +57,7 @@ func genStateTxns(p *myPKer) (consensus.State, []byte) {
 			Price:    uint64(rand.Intn(10) + 1000),
 			Market:   MarketSymbol{Base: 0, Quote: 1},
 		}
-		txns = append(txns, MakePlaceOrderTxn(sk, pk.Addr(), t, 0))
+		txns = append(txns, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), t, 0))
 	}
 
 	body, err := rlp.EncodeToBytes(txns)
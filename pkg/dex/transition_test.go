@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/stretchr/testify/assert"
 )
@@ -33,7 +34,7 @@ func TestSendToken(t *testing.T) {
 	acc.UpdateBalance(0, Balance{Available: 100})
 
 	pkTo, _ := RandKeyPair()
-	txn := MakeSendTokenTxn(sk, addr, pkTo, 0, 20, 0)
+	txn := MakeSendTokenTxn(sk, testChainID, addr, pkTo, 0, 20, 0)
 	trans := s.Transition(1, nil)
 	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
@@ -52,6 +53,63 @@ func TestSendToken(t *testing.T) {
 	assert.Equal(t, 20, int(recv.Balance(0).Available))
 }
 
+func TestDroppedTransitionNotMutateParent(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 100, Frozen: []Frozen{{AvailableRound: 5, Quant: 10}}})
+	s.CommitCache()
+	hash := s.Hash()
+
+	pkTo, _ := RandKeyPair()
+	txn := MakeSendTokenTxn(sk, testChainID, addr, pkTo, 0, 20, 0)
+	trans := s.Transition(1, nil)
+	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
+		addr: pk,
+	}})
+	if err != nil {
+		panic(err)
+	}
+
+	err = trans.Record(pt)
+	assert.Nil(t, err)
+	freeze := MakeFreezeTokenTxn(sk, testChainID, addr, FreezeTokenTxn{TokenID: 0, AvailableRound: 3, Quant: 50}, 1)
+	pt, err = parseTxn(freeze, &myPKer{m: map[consensus.Addr]PK{
+		addr: pk,
+	}})
+	if err != nil {
+		panic(err)
+	}
+
+	err = trans.Record(pt)
+	assert.Nil(t, err)
+	// drop the transition without committing it.
+	trans.StateHash()
+
+	assert.Equal(t, hash, s.Hash())
+	assert.Equal(t, 100, int(acc.Balance(0).Available))
+	assert.Equal(t, 1, len(acc.Balance(0).Frozen))
+	assert.Equal(t, 0, int(acc.Nonce()))
+	assert.Equal(t, 100, int(s.Account(addr).Balance(0).Available))
+	assert.Nil(t, s.Account(pkTo.Addr()))
+
+	s0 := NewState(s.diskDB)
+	b, err := s.Serialize()
+	if err != nil {
+		panic(err)
+	}
+
+	err = s0.Deserialize(b)
+	if err != nil {
+		panic(err)
+	}
+
+	acc0 := s0.Account(addr)
+	assert.Equal(t, 100, int(acc0.Balance(0).Available))
+	assert.Equal(t, 0, int(acc0.Nonce()))
+}
+
 func TestFreezeToken(t *testing.T) {
 	s := NewState(ethdb.NewMemDatabase())
 	pk, sk := RandKeyPair()
@@ -59,7 +117,7 @@ func TestFreezeToken(t *testing.T) {
 	acc.UpdateBalance(0, Balance{Available: 100})
 
 	addr := pk.Addr()
-	txn := MakeFreezeTokenTxn(sk, addr, FreezeTokenTxn{TokenID: 0, AvailableRound: 3, Quant: 50}, 0)
+	txn := MakeFreezeTokenTxn(sk, testChainID, addr, FreezeTokenTxn{TokenID: 0, AvailableRound: 3, Quant: 50}, 0)
 
 	trans := s.Transition(1, nil)
 	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
@@ -84,6 +142,43 @@ func TestFreezeToken(t *testing.T) {
 	assert.Equal(t, 0, len(acc.Balance(0).Frozen))
 }
 
+func TestPruneRoundEntries(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	market := MarketSymbol{Base: 0, Quote: 1}
+	const rounds = 50
+	for round := uint64(1); round <= rounds; round++ {
+		trans := s.Transition(round, nil)
+		nonce := (round - 1) * 2
+		recordTxn(t, trans, pk, MakeFreezeTokenTxn(sk, testChainID, pk.Addr(), FreezeTokenTxn{TokenID: 1, AvailableRound: round + 2, Quant: 1}, nonce))
+		recordTxn(t, trans, pk, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 1, Price: 100000000, Market: market, ExpireRound: round + 2}, nonce+1))
+		s = trans.Commit().(*State)
+
+		assert.True(t, len(s.OrderExpirationRounds(math.MaxUint64)) <= 2)
+		assert.True(t, len(s.FreezeTokenRounds(math.MaxUint64)) <= 2)
+	}
+
+	acc := s.Account(pk.Addr())
+	assert.Equal(t, 1, len(acc.PendingOrders()))
+	assert.Equal(t, 99, int(acc.Balance(0).Available))
+	assert.Equal(t, 99, int(acc.Balance(1).Available))
+}
+
+func TestReleaseStaleRoundEntries(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, _ := RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 50, Frozen: []Frozen{{AvailableRound: 3, Quant: 50}}})
+	s.FreezeToken(3, freezeToken{Addr: pk.Addr(), TokenID: 0, Quant: 50})
+
+	// the frozen tokens should be released at round 2, which
+	// never happened, they are released at round 5 instead.
+	s = s.Transition(5, nil).Commit().(*State)
+	acc = s.Account(pk.Addr())
+	assert.Equal(t, 100, int(acc.Balance(0).Available))
+	assert.Equal(t, 0, len(acc.Balance(0).Frozen))
+	assert.Equal(t, 0, len(s.FreezeTokenRounds(math.MaxUint64)))
+}
+
 func TestIssueToken(t *testing.T) {
 	var btcInfo = TokenInfo{
 		Symbol:     "BTC",
@@ -97,7 +192,7 @@ func TestIssueToken(t *testing.T) {
 	acc := s.NewAccount(pk)
 	trans := s.Transition(1, nil)
 	addr := pk.Addr()
-	txn := MakeIssueTokenTxn(sk, addr, btcInfo, 0)
+	txn := MakeIssueTokenTxn(sk, testChainID, addr, btcInfo, 0)
 	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
@@ -119,6 +214,185 @@ func TestIssueToken(t *testing.T) {
 	assert.Equal(t, 0, len(acc.Balance(1).Frozen))
 }
 
+func issueToken(s *State, pk PK, sk SK, info TokenInfo, nonce uint64) (*State, error) {
+	addr := pk.Addr()
+	trans := s.Transition(1, nil)
+	txn := MakeIssueTokenTxn(sk, testChainID, addr, info, nonce)
+	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
+		addr: pk,
+	}})
+	if err != nil {
+		panic(err)
+	}
+
+	err = trans.Record(pt)
+	return trans.Commit().(*State), err
+}
+
+func TestIssueTokenSymbolUnique(t *testing.T) {
+	pk, sk := RandKeyPair()
+	s := CreateGenesisState([]PK{pk}, nil)
+
+	s, err := issueToken(s, pk, sk, TokenInfo{Symbol: "BNB", Decimals: 8, TotalUnits: 100}, 0)
+	assert.NotNil(t, err)
+
+	s, err = issueToken(s, pk, sk, TokenInfo{Symbol: "bnb", Decimals: 8, TotalUnits: 100}, 0)
+	assert.NotNil(t, err)
+
+	btcInfo := TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 21000000 * 100000000}
+	s, err = issueToken(s, pk, sk, btcInfo, 0)
+	assert.Nil(t, err)
+
+	s, err = issueToken(s, pk, sk, TokenInfo{Symbol: "Btc", Decimals: 8, TotalUnits: 100}, 1)
+	assert.NotNil(t, err)
+
+	token, ok := s.TokenBySymbol("btc")
+	assert.True(t, ok)
+	assert.Equal(t, Token{ID: 1, TokenInfo: btcInfo, Issuer: pk.Addr()}, token)
+	assert.Equal(t, 2, len(s.Tokens()))
+}
+
+func mintToken(s *State, pk PK, sk SK, txn MintTokenTxn, nonce uint64) (*State, error) {
+	addr := pk.Addr()
+	trans := s.Transition(1, nil)
+	pt, err := parseTxn(MakeMintTokenTxn(sk, testChainID, addr, txn, nonce), &myPKer{m: map[consensus.Addr]PK{
+		addr: pk,
+	}})
+	if err != nil {
+		panic(err)
+	}
+
+	err = trans.Record(pt)
+	return trans.Commit().(*State), err
+}
+
+func TestMintToken(t *testing.T) {
+	pk, sk := RandKeyPair()
+	pk1, sk1 := RandKeyPair()
+	s := CreateGenesisState([]PK{pk, pk1}, nil)
+
+	info := TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100, MaxSupply: 150}
+	s, err := issueToken(s, pk, sk, info, 0)
+	assert.Nil(t, err)
+	token, ok := s.TokenBySymbol("BTC")
+	assert.True(t, ok)
+
+	// only the issuer can mint
+	s, err = mintToken(s, pk1, sk1, MintTokenTxn{ID: token.ID, Quant: 10}, 0)
+	assert.NotNil(t, err)
+
+	// can not mint beyond the max supply
+	s, err = mintToken(s, pk, sk, MintTokenTxn{ID: token.ID, Quant: 51}, 1)
+	assert.NotNil(t, err)
+
+	s, err = mintToken(s, pk, sk, MintTokenTxn{ID: token.ID, Quant: 50}, 1)
+	assert.Nil(t, err)
+
+	token, ok = s.Token(token.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 150, int(token.TotalUnits))
+	assert.Equal(t, pk.Addr(), token.Issuer)
+	assert.Equal(t, 150, int(s.Account(pk.Addr()).Balance(token.ID).Available))
+	assert.Equal(t, 0, int(s.Account(pk1.Addr()).Balance(token.ID).Available))
+
+	s, err = mintToken(s, pk, sk, MintTokenTxn{ID: token.ID, Quant: 1}, 2)
+	assert.NotNil(t, err)
+}
+
+func TestMintTokenFixedSupply(t *testing.T) {
+	pk, sk := RandKeyPair()
+	s := CreateGenesisState([]PK{pk}, nil)
+
+	s, err := issueToken(s, pk, sk, TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100}, 0)
+	assert.Nil(t, err)
+	token, _ := s.TokenBySymbol("BTC")
+
+	_, err = mintToken(s, pk, sk, MintTokenTxn{ID: token.ID, Quant: 1}, 1)
+	assert.NotNil(t, err)
+}
+
+func TestIssueTokenSameSymbolInOneTransition(t *testing.T) {
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	s := CreateGenesisState([]PK{pk}, nil)
+	trans := s.Transition(1, nil)
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	pt, err := parseTxn(MakeIssueTokenTxn(sk, testChainID, addr, TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100}, 0), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, trans.Record(pt))
+
+	pt, err = parseTxn(MakeIssueTokenTxn(sk, testChainID, addr, TokenInfo{Symbol: "btc", Decimals: 8, TotalUnits: 100}, 1), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.NotNil(t, trans.Record(pt))
+}
+
+func TestIssueTokenAndPlaceOrderInOneTransition(t *testing.T) {
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	s := CreateGenesisState([]PK{pk}, nil)
+	trans := s.Transition(1, nil)
+
+	btcInfo := TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 1000, MaxSupply: 2000}
+	recordTxn(t, trans, pk, MakeIssueTokenTxn(sk, testChainID, addr, btcInfo, 0))
+	recordTxn(t, trans, pk, MakeMintTokenTxn(sk, testChainID, addr, MintTokenTxn{ID: 1, Quant: 500}, 1))
+	market := MarketSymbol{Base: 1, Quote: 0}
+	recordTxn(t, trans, pk, MakePlaceOrderTxn(sk, testChainID, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: 100000000, Market: market}, 2))
+	s = trans.Commit().(*State)
+
+	acc := s.Account(addr)
+	assert.Equal(t, 1, len(acc.PendingOrders()))
+	assert.Equal(t, 1400, int(acc.Balance(1).Available))
+	assert.Equal(t, 100, int(acc.Balance(1).Pending))
+	assert.Equal(t, 1500, int(s.tokens().Info(1).TotalUnits))
+}
+
+func TestTokenCacheFork(t *testing.T) {
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	s := CreateGenesisState([]PK{pk}, nil)
+
+	ethInfo := TokenInfo{Symbol: "ETH", Decimals: 8, TotalUnits: 100}
+	trans0 := s.Transition(1, nil)
+	recordTxn(t, trans0, pk, MakeIssueTokenTxn(sk, testChainID, addr, ethInfo, 0))
+
+	xrpInfo := TokenInfo{Symbol: "XRP", Decimals: 6, TotalUnits: 200}
+	trans1 := s.Transition(1, nil)
+	recordTxn(t, trans1, pk, MakeIssueTokenTxn(sk, testChainID, addr, xrpInfo, 0))
+
+	s0 := trans0.Commit().(*State)
+	s1 := trans1.Commit().(*State)
+
+	assert.Equal(t, 1, s.tokens().Size())
+	assert.False(t, s.tokens().Exists("ETH"))
+	assert.False(t, s.tokens().Exists("XRP"))
+
+	assert.Equal(t, ethInfo, s0.tokens().Info(1))
+	assert.False(t, s0.tokens().Exists("XRP"))
+	assert.Equal(t, xrpInfo, s1.tokens().Info(1))
+	assert.False(t, s1.tokens().Exists("ETH"))
+
+	// the cache of the committed state is consistent with its
+	// trie.
+	assert.Equal(t, newTokenCache(s0).Tokens(), s0.tokens().Tokens())
+	assert.Equal(t, newTokenCache(s1).Tokens(), s1.tokens().Tokens())
+}
+
+func TestTokenInfoValidate(t *testing.T) {
+	assert.Nil(t, TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100}.Validate())
+	assert.Nil(t, TokenInfo{Symbol: "usdt2", Decimals: 18, TotalUnits: 1}.Validate())
+	assert.NotNil(t, TokenInfo{Symbol: "", Decimals: 8, TotalUnits: 100}.Validate())
+	assert.NotNil(t, TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100, MaxSupply: 99}.Validate())
+	assert.NotNil(t, TokenInfo{Symbol: "B TC", Decimals: 8, TotalUnits: 100}.Validate())
+	assert.NotNil(t, TokenInfo{Symbol: "ABCDEFGHIJKLMNOPQ", Decimals: 8, TotalUnits: 100}.Validate())
+	assert.NotNil(t, TokenInfo{Symbol: "BTC", Decimals: 40, TotalUnits: 100}.Validate())
+	assert.NotNil(t, TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 0}.Validate())
+}
+
 func TestOrderAlreadyExpired(t *testing.T) {
 	s := NewState(ethdb.NewMemDatabase())
 	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
@@ -134,7 +408,7 @@ func TestOrderAlreadyExpired(t *testing.T) {
 	}
 
 	trans := s.Transition(1, nil)
-	pt, err := parseTxn(MakePlaceOrderTxn(sk, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
+	pt, err := parseTxn(MakePlaceOrderTxn(sk, testChainID, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
 	if err != nil {
@@ -165,7 +439,7 @@ func TestBuyOrderExpire(t *testing.T) {
 		Market:      MarketSymbol{Quote: 1, Base: 0},
 	}
 	trans := s.Transition(1, nil)
-	pt, err := parseTxn(MakePlaceOrderTxn(sk, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
+	pt, err := parseTxn(MakePlaceOrderTxn(sk, testChainID, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
 	if err != nil {
@@ -206,7 +480,7 @@ func TestSellOrderExpire(t *testing.T) {
 		Market:      MarketSymbol{Quote: 1, Base: 0},
 	}
 	trans := s.Transition(1, nil)
-	pt, err := parseTxn(MakePlaceOrderTxn(sk, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
+	pt, err := parseTxn(MakePlaceOrderTxn(sk, testChainID, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
 	if err != nil {
@@ -239,7 +513,7 @@ func TestNonce(t *testing.T) {
 	trans := s.Transition(1, nil)
 
 	to, _ := RandKeyPair()
-	txn := MakeSendTokenTxn(sk, addr, to, 0, 20, 0)
+	txn := MakeSendTokenTxn(sk, testChainID, addr, to, 0, 20, 0)
 	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
@@ -255,7 +529,7 @@ func TestNonce(t *testing.T) {
 	err = trans.Record(pt)
 	assert.Contains(t, err.Error(), "nonce not valid")
 
-	txn = MakeSendTokenTxn(sk, addr, to, 0, 20, 1)
+	txn = MakeSendTokenTxn(sk, testChainID, addr, to, 0, 20, 1)
 	pt, err = parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
@@ -275,7 +549,7 @@ func TestMinerFee(t *testing.T) {
 	acc.UpdateBalance(0, Balance{Available: uint64(100 * math.Pow10(int(BNBInfo.Decimals)))})
 
 	pkTo, _ := RandKeyPair()
-	txn := MakeSendTokenTxn(sk, addr, pkTo, 0, 20, 0)
+	txn := MakeSendTokenTxn(sk, testChainID, addr, pkTo, 0, 20, 0)
 	trans := s.Transition(1, miner)
 	pker := &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
@@ -300,6 +574,122 @@ func TestMinerFee(t *testing.T) {
 	assert.Equal(t, root, newState0.Hash())
 }
 
+func TestTxnFee(t *testing.T) {
+	const (
+		senders = 10
+		txns    = 5
+		fee     = 1000
+	)
+	bnb := uint64(100 * math.Pow10(int(BNBInfo.Decimals)))
+	miner, _ := RandKeyPair()
+	s := NewState(ethdb.NewMemDatabase())
+	pker := &myPKer{m: make(map[consensus.Addr]PK)}
+	var pks []PK
+	var sks []SK
+	for i := 0; i < senders; i++ {
+		pk, sk := RandKeyPair()
+		s.NewAccount(pk).UpdateBalance(0, Balance{Available: bnb})
+		pker.m[pk.Addr()] = pk
+		pks = append(pks, pk)
+		sks = append(sks, sk)
+	}
+	s.CommitCache()
+
+	to, _ := RandKeyPair()
+	trans := s.Transition(1, miner)
+	for i, sk := range sks {
+		owner := pks[i].Addr()
+		for j := 0; j < txns; j++ {
+			b, err := WithFee(MakeSendTokenTxn(sk, testChainID, owner, to, 0, 20, uint64(j)), sk, testChainID, uint64(fee*(i+1)))
+			assert.Nil(t, err)
+			pt, err := parseTxn(b, pker)
+			assert.Nil(t, err)
+			assert.Nil(t, trans.Record(pt))
+		}
+	}
+	newState := trans.Commit().(*State)
+
+	var fees uint64
+	for i := range sks {
+		fees += txns * (flatFee + uint64(fee*(i+1)))
+	}
+	assert.Equal(t, fees, newState.Account(miner.Addr()).Balance(0).Available)
+
+	total := newState.Account(miner.Addr()).Balance(0).Available + newState.Account(to.Addr()).Balance(0).Available
+	for addr := range pker.m {
+		total += newState.Account(addr).Balance(0).Available
+	}
+	assert.Equal(t, bnb*senders, total)
+
+	newState0, _, err := s.CommitTxns(trans.Txns(), NewTxnPool(pker), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, newState.Hash(), newState0.Hash())
+}
+
+func TestTxnFeeInsufficientBalance(t *testing.T) {
+	miner, _ := RandKeyPair()
+	s := NewState(ethdb.NewMemDatabase())
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: flatFee + 100})
+	s.CommitCache()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+
+	to, _ := RandKeyPair()
+	trans := s.Transition(1, miner)
+	b, err := WithFee(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0), sk, testChainID, 101)
+	assert.Nil(t, err)
+	pt, err := parseTxn(b, pker)
+	assert.Nil(t, err)
+	assert.NotNil(t, trans.Record(pt))
+
+	newState := trans.Commit().(*State)
+	assert.Equal(t, flatFee+100, newState.Account(pk.Addr()).Balance(0).Available)
+	assert.Nil(t, newState.Account(miner.Addr()))
+}
+
+func TestMinTxnFee(t *testing.T) {
+	pk, sk := RandKeyPair()
+	s := CreateGenesisStateWithParams([]PK{pk}, nil, GenesisParams{MinTxnFee: 10})
+	assert.Equal(t, uint64(10), s.MinTxnFee())
+	assert.Equal(t, uint64(0), CreateGenesisState([]PK{pk}, nil).MinTxnFee())
+
+	to, _ := RandKeyPair()
+	trans := s.Transition(1, nil)
+	b := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)
+	pt, err := parseTxn(b, &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}})
+	assert.Nil(t, err)
+	assert.NotNil(t, trans.Record(pt))
+}
+
+func TestTxnOwnerPK(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, sk := RandKeyPair()
+	s.UpdateBalances(pk.Addr(), []Balance{{Available: 100}}, []TokenID{0})
+	assert.Nil(t, s.Account(pk.Addr()))
+
+	to, _ := RandKeyPair()
+	b, err := WithOwnerPK(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 200, 0), sk, testChainID, pk)
+	assert.Nil(t, err)
+	pt, err := parseTxn(b, s)
+	assert.Nil(t, err)
+
+	// the failed txn does not save the PK
+	trans := s.Transition(1, nil)
+	assert.NotNil(t, trans.Record(pt))
+	s0 := trans.Commit().(*State)
+	assert.Nil(t, s0.PK(pk.Addr()))
+
+	b, err = WithOwnerPK(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 20, 0), sk, testChainID, pk)
+	assert.Nil(t, err)
+	pt, err = parseTxn(b, s)
+	assert.Nil(t, err)
+	trans = s.Transition(1, nil)
+	assert.Nil(t, trans.Record(pt))
+	s = trans.Commit().(*State)
+	assert.Equal(t, pk, s.PK(pk.Addr()))
+	assert.Equal(t, 80, int(s.Account(pk.Addr()).Balance(0).Available))
+}
+
 func TestBurnToken(t *testing.T) {
 	const burn = 1000
 	s := NewState(ethdb.NewMemDatabase())
@@ -318,7 +708,7 @@ func TestBurnToken(t *testing.T) {
 	pk, sk := RandKeyPair()
 	acc := s.NewAccount(pk)
 	acc.UpdateBalance(0, Balance{Available: burn + 100})
-	txn := MakeBurnTokenTxn(sk, pk.Addr(), BurnTokenTxn{ID: 0, Quant: burn}, 0)
+	txn := MakeBurnTokenTxn(sk, testChainID, pk.Addr(), BurnTokenTxn{ID: 0, Quant: burn}, 0)
 
 	pker := &myPKer{m: map[consensus.Addr]PK{
 		pk.Addr(): pk,
@@ -364,7 +754,7 @@ func TestPlaceOrder(t *testing.T) {
 		Price:  2 * uint64(math.Pow10(OrderPriceDecimals)),
 		Market: MarketSymbol{Quote: 1, Base: 0},
 	}
-	pt, err := parseTxn(MakePlaceOrderTxn(skBuy, pkBuy.Addr(), order, 0), pker)
+	pt, err := parseTxn(MakePlaceOrderTxn(skBuy, testChainID, pkBuy.Addr(), order, 0), pker)
 	if err != nil {
 		panic(err)
 	}
@@ -387,7 +777,7 @@ func TestPlaceOrder(t *testing.T) {
 		Price:  3 * uint64(math.Pow10(OrderPriceDecimals)),
 		Market: MarketSymbol{Quote: 1, Base: 0},
 	}
-	pt, err = parseTxn(MakePlaceOrderTxn(skBuy, pkBuy.Addr(), order, 1), pker)
+	pt, err = parseTxn(MakePlaceOrderTxn(skBuy, testChainID, pkBuy.Addr(), order, 1), pker)
 	if err != nil {
 		panic(err)
 	}
@@ -401,7 +791,7 @@ func TestPlaceOrder(t *testing.T) {
 		Price:  2 * uint64(math.Pow10(OrderPriceDecimals)),
 		Market: MarketSymbol{Quote: 1, Base: 0},
 	}
-	pt, err = parseTxn(MakePlaceOrderTxn(skSell, pkSell.Addr(), order, 0), pker)
+	pt, err = parseTxn(MakePlaceOrderTxn(skSell, testChainID, pkSell.Addr(), order, 0), pker)
 	if err != nil {
 		panic(err)
 	}
@@ -432,3 +822,542 @@ func TestPlaceOrder(t *testing.T) {
 func TestCalcQuoteQuant(t *testing.T) {
 	assert.Equal(t, 40, int(calcQuoteQuant(40, 8, uint64(math.Pow10(OrderPriceDecimals)), 8, 8)))
 }
+
+func TestCancelAllOrders(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkOther, skOther := RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 100})
+	acc.UpdateBalance(1, Balance{Available: 100})
+	other := s.NewAccount(pkOther)
+	other.UpdateBalance(0, Balance{Available: 100})
+
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pk.Addr():      pk,
+		pkOther.Addr(): pkOther,
+	}}
+	record := func(trans consensus.Transition, b []byte) {
+		pt, err := parseTxn(b, pker)
+		if err != nil {
+			panic(err)
+		}
+
+		err = trans.Record(pt)
+		assert.Nil(t, err)
+	}
+
+	market := MarketSymbol{Base: 0, Quote: 1}
+	trans := s.Transition(1, nil)
+	record(trans, MakePlaceOrderTxn(skOther, testChainID, pkOther.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 300000000, ExpireRound: 10, Market: market}, 0))
+	record(trans, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 200000000, ExpireRound: 10, Market: market}, 0))
+	s = trans.Commit().(*State)
+
+	trans = s.Transition(2, nil)
+	record(trans, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: false, Quant: 10, Price: 50000000, ExpireRound: 10, Market: market}, 1))
+	cancel := MakeCancelAllOrdersTxn(sk, testChainID, pk.Addr(), CancelAllOrdersTxn{Market: market}, 2)
+	record(trans, cancel)
+	s = trans.Commit().(*State)
+
+	acc = s.Account(pk.Addr())
+	assert.Equal(t, 0, len(acc.PendingOrders()))
+	assert.Equal(t, 100, int(acc.Balance(0).Available))
+	assert.Equal(t, 0, int(acc.Balance(0).Pending))
+	assert.Equal(t, 100, int(acc.Balance(1).Available))
+	assert.Equal(t, 0, int(acc.Balance(1).Pending))
+
+	r, ok := s.Receipt(consensus.SHA3(cancel))
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(r.Cancelled))
+
+	other = s.Account(pkOther.Addr())
+	assert.Equal(t, 1, len(other.PendingOrders()))
+	assert.Equal(t, 90, int(other.Balance(0).Available))
+	assert.Equal(t, 10, int(other.Balance(0).Pending))
+	exps := s.GetOrderExpirations(10)
+	assert.Equal(t, 1, len(exps))
+	assert.Equal(t, pkOther.Addr(), exps[0].Owner)
+}
+
+func TestCancelAllOrdersMax(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 2 * MaxCancelAllOrders})
+
+	market := MarketSymbol{Base: 0, Quote: 1}
+	trans := s.Transition(1, nil)
+	for i := 0; i <= MaxCancelAllOrders; i++ {
+		recordTxn(t, trans, pk, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 1, Price: 100000000, Market: market}, uint64(i)))
+	}
+	recordTxn(t, trans, pk, MakeCancelAllOrdersTxn(sk, testChainID, pk.Addr(), CancelAllOrdersTxn{Market: market}, MaxCancelAllOrders+1))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 1, len(s.Account(pk.Addr()).PendingOrders()))
+}
+
+func TestRecordMaxTxnBytes(t *testing.T) {
+	s, pk, _, _, _ := newTIFTestState()
+	to, _ := RandKeyPair()
+	trans := s.Transition(1, nil)
+	txn := &consensus.Txn{
+		Raw:     make([]byte, consensus.MaxTxnBytes+1),
+		Owner:   pk.Addr(),
+		Decoded: &SendTokenTxn{To: to, Quant: 1},
+	}
+	assert.NotNil(t, trans.Record(txn))
+
+	blob, err := rlp.EncodeToBytes([][]byte{make([]byte, consensus.MaxTxnBytes+1)})
+	assert.Nil(t, err)
+	_, err = trans.(*Transition).RecordSerialized(blob, NewTxnPool(s))
+	assert.NotNil(t, err)
+}
+
+func TestCancelAllOrdersSideFilter(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 100})
+	acc.UpdateBalance(1, Balance{Available: 100})
+
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pk.Addr(): pk,
+	}}
+	record := func(trans consensus.Transition, b []byte) {
+		pt, err := parseTxn(b, pker)
+		if err != nil {
+			panic(err)
+		}
+
+		err = trans.Record(pt)
+		assert.Nil(t, err)
+	}
+
+	market := MarketSymbol{Base: 0, Quote: 1}
+	trans := s.Transition(1, nil)
+	record(trans, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 200000000, Market: market}, 0))
+	record(trans, MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: false, Quant: 10, Price: 50000000, Market: market}, 1))
+	record(trans, MakeCancelAllOrdersTxn(sk, testChainID, pk.Addr(), CancelAllOrdersTxn{Market: market, FilterSide: true, SellSide: true}, 2))
+	s = trans.Commit().(*State)
+
+	acc = s.Account(pk.Addr())
+	orders := acc.PendingOrders()
+	assert.Equal(t, 1, len(orders))
+	assert.False(t, orders[0].SellSide)
+	assert.Equal(t, 100, int(acc.Balance(0).Available))
+	assert.Equal(t, 0, int(acc.Balance(0).Pending))
+	assert.Equal(t, 95, int(acc.Balance(1).Available))
+	assert.Equal(t, 5, int(acc.Balance(1).Pending))
+}
+
+func newTIFTestState() (*State, PK, SK, PK, SK) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pkMaker, skMaker := RandKeyPair()
+	pkTaker, skTaker := RandKeyPair()
+	maker := s.NewAccount(pkMaker)
+	maker.UpdateBalance(0, Balance{Available: 100})
+	maker.UpdateBalance(1, Balance{Available: 100})
+	taker := s.NewAccount(pkTaker)
+	taker.UpdateBalance(0, Balance{Available: 100})
+	taker.UpdateBalance(1, Balance{Available: 100})
+	return s, pkMaker, skMaker, pkTaker, skTaker
+}
+
+func recordTxn(t *testing.T, trans consensus.Transition, pk PK, b []byte) {
+	pt, err := parseTxn(b, &myPKer{m: map[consensus.Addr]PK{
+		pk.Addr(): pk,
+	}})
+	if err != nil {
+		panic(err)
+	}
+
+	err = trans.Record(pt)
+	assert.Nil(t, err)
+}
+
+func TestPlaceOrderIOC(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	for _, sell := range []bool{false, true} {
+		s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+		trans := s.Transition(1, nil)
+		recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: !sell, Quant: 20, Price: 100000000, Market: market}, 0))
+		recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{SellSide: sell, Quant: 30, Price: 100000000, Market: market, TIF: IOC}, 0))
+		s = trans.Commit().(*State)
+
+		taker := s.Account(pkTaker.Addr())
+		assert.Equal(t, 0, len(taker.PendingOrders()))
+		assert.Equal(t, 0, int(taker.Balance(0).Pending))
+		assert.Equal(t, 0, int(taker.Balance(1).Pending))
+		if sell {
+			assert.Equal(t, 80, int(taker.Balance(0).Available))
+			assert.Equal(t, 120, int(taker.Balance(1).Available))
+		} else {
+			assert.Equal(t, 120, int(taker.Balance(0).Available))
+			assert.Equal(t, 80, int(taker.Balance(1).Available))
+		}
+
+		// the unfilled part is not resting on the order book.
+		book := s.loadOrderBook(market)
+		assert.Nil(t, book.asks.best())
+		assert.Nil(t, book.bids.best())
+	}
+}
+
+func TestPlaceOrderFOK(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	for _, sell := range []bool{false, true} {
+		s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+		trans := s.Transition(1, nil)
+		recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: !sell, Quant: 20, Price: 100000000, Market: market}, 0))
+		kill := MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{SellSide: sell, Quant: 30, Price: 100000000, Market: market, TIF: FOK}, 0)
+		recordTxn(t, trans, pkTaker, kill)
+		s = trans.Commit().(*State)
+
+		r, ok := s.Receipt(consensus.SHA3(kill))
+		assert.True(t, ok)
+		assert.True(t, r.Killed)
+		taker := s.Account(pkTaker.Addr())
+		assert.Equal(t, 1, int(taker.Nonce()))
+		assert.Equal(t, 0, len(taker.PendingOrders()))
+		assert.Equal(t, 100, int(taker.Balance(0).Available))
+		assert.Equal(t, 100, int(taker.Balance(1).Available))
+		maker := s.Account(pkMaker.Addr())
+		assert.Equal(t, 1, len(maker.PendingOrders()))
+
+		trans = s.Transition(2, nil)
+		fill := MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{SellSide: sell, Quant: 20, Price: 100000000, Market: market, TIF: FOK}, 1)
+		recordTxn(t, trans, pkTaker, fill)
+		s = trans.Commit().(*State)
+
+		_, ok = s.Receipt(consensus.SHA3(fill))
+		assert.False(t, ok)
+		taker = s.Account(pkTaker.Addr())
+		assert.Equal(t, 0, len(taker.PendingOrders()))
+		assert.Equal(t, 0, int(taker.Balance(0).Pending))
+		assert.Equal(t, 0, int(taker.Balance(1).Pending))
+		if sell {
+			assert.Equal(t, 80, int(taker.Balance(0).Available))
+			assert.Equal(t, 120, int(taker.Balance(1).Available))
+		} else {
+			assert.Equal(t, 120, int(taker.Balance(0).Available))
+			assert.Equal(t, 80, int(taker.Balance(1).Available))
+		}
+		maker = s.Account(pkMaker.Addr())
+		assert.Equal(t, 0, len(maker.PendingOrders()))
+	}
+}
+
+func TestPlaceMarketOrder(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	cases := []struct {
+		quant       uint64
+		maxSlippage uint64
+		recv        int
+		paid        int
+		resting     int
+	}{
+		// thin book: liquidity runs out
+		{quant: 30, maxSlippage: 200000000, recv: 20, paid: 22, resting: 0},
+		// exact fill
+		{quant: 20, maxSlippage: 120000000, recv: 20, paid: 22, resting: 0},
+		// slippage bound hit in the middle of the walk
+		{quant: 20, maxSlippage: 110000000, recv: 10, paid: 10, resting: 1},
+	}
+
+	for _, c := range cases {
+		s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+		trans := s.Transition(1, nil)
+		recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market}, 0))
+		recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 120000000, Market: market}, 1))
+		recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: c.quant, Market: market, MarketOrder: true, MaxSlippageQuote: c.maxSlippage}, 0))
+		s = trans.Commit().(*State)
+
+		taker := s.Account(pkTaker.Addr())
+		assert.Equal(t, 0, len(taker.PendingOrders()))
+		assert.Equal(t, 100+c.recv, int(taker.Balance(0).Available))
+		assert.Equal(t, 100-c.paid, int(taker.Balance(1).Available))
+		assert.Equal(t, 0, int(taker.Balance(1).Pending))
+		maker := s.Account(pkMaker.Addr())
+		assert.Equal(t, c.resting, len(maker.PendingOrders()))
+		assert.Equal(t, 100+c.paid, int(maker.Balance(1).Available))
+		book := s.loadOrderBook(market)
+		assert.Nil(t, book.bids.best())
+	}
+}
+
+func TestPlaceMarketOrderInvalid(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	s, _, _, pkTaker, skTaker := newTIFTestState()
+	pker := &myPKer{m: map[consensus.Addr]PK{pkTaker.Addr(): pkTaker}}
+	trans := s.Transition(1, nil)
+
+	pt, err := parseTxn(MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 10, Market: market, MarketOrder: true}, 0), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.NotNil(t, trans.Record(pt))
+
+	pt, err = parseTxn(MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 10, Price: 100, Market: market, MarketOrder: true, MaxSlippageQuote: 100}, 0), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.NotNil(t, trans.Record(pt))
+}
+
+func TestStopOrder(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	newStopTestState := func() (*State, PK, SK, PK, SK, PK, SK) {
+		s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+		pkStop, skStop := RandKeyPair()
+		acc := s.NewAccount(pkStop)
+		acc.UpdateBalance(1, Balance{Available: 100})
+		return s, pkMaker, skMaker, pkTaker, skTaker, pkStop, skStop
+	}
+
+	stop := PlaceOrderTxn{Quant: 10, Price: 150000000, StopPrice: 120000000, Market: market}
+
+	// triggered by a trade
+	s, pkMaker, skMaker, pkTaker, skTaker, pkStop, skStop := newStopTestState()
+	trans := s.Transition(1, nil)
+	recordTxn(t, trans, pkStop, MakePlaceOrderTxn(skStop, testChainID, pkStop.Addr(), stop, 0))
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 120000000, Market: market}, 0))
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 130000000, Market: market}, 1))
+	recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 10, Price: 120000000, Market: market}, 0))
+	s = trans.Commit().(*State)
+
+	acc := s.Account(pkStop.Addr())
+	assert.Equal(t, 0, len(acc.PendingOrders()))
+	assert.Equal(t, 10, int(acc.Balance(0).Available))
+	assert.Equal(t, 87, int(acc.Balance(1).Available))
+	assert.Equal(t, 0, int(acc.Balance(1).Pending))
+	assert.Equal(t, 0, len(s.StopOrders(market)))
+	price, ok := s.LastPrice(market)
+	assert.True(t, ok)
+	assert.Equal(t, 130000000, int(price))
+
+	// not triggered when the price does not cross the stop price
+	s, pkMaker, skMaker, pkTaker, skTaker, pkStop, skStop = newStopTestState()
+	trans = s.Transition(1, nil)
+	recordTxn(t, trans, pkStop, MakePlaceOrderTxn(skStop, testChainID, pkStop.Addr(), stop, 0))
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 110000000, Market: market}, 0))
+	recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 10, Price: 110000000, Market: market}, 0))
+	s = trans.Commit().(*State)
+
+	acc = s.Account(pkStop.Addr())
+	assert.Equal(t, 1, len(acc.PendingOrders()))
+	assert.Equal(t, 85, int(acc.Balance(1).Available))
+	assert.Equal(t, 15, int(acc.Balance(1).Pending))
+	assert.Equal(t, 1, len(s.StopOrders(market)))
+	book := s.loadOrderBook(market)
+	assert.Nil(t, book.bids.best())
+
+	// cancel the untriggered stop order
+	trans = s.Transition(2, nil)
+	recordTxn(t, trans, pkStop, MakeCancelOrderTxn(skStop, testChainID, pkStop.Addr(), acc.PendingOrders()[0].ID, 1))
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 130000000, Market: market}, 1))
+	recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 10, Price: 130000000, Market: market}, 1))
+	s = trans.Commit().(*State)
+
+	acc = s.Account(pkStop.Addr())
+	assert.Equal(t, 0, len(acc.PendingOrders()))
+	assert.Equal(t, 100, int(acc.Balance(1).Available))
+	assert.Equal(t, 0, int(acc.Balance(1).Pending))
+	assert.Equal(t, 0, len(s.StopOrders(market)))
+}
+
+func TestPlaceOrderMarketParams(t *testing.T) {
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+	s := CreateGenesisState([]PK{pk}, nil)
+	params := MarketParams{TickSize: 100, LotSize: 10, MinNotional: 50}
+	issue := IssueTokenTxn{
+		Info:    TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 21000000 * 100000000},
+		Markets: []QuoteMarketParams{{Quote: 0, Params: params}},
+	}
+	trans := s.Transition(1, nil)
+	recordTxn(t, trans, pk, MakeIssueTokenWithMarketsTxn(sk, testChainID, addr, issue, 0))
+	s = trans.Commit().(*State)
+
+	market := MarketSymbol{Base: 1, Quote: 0}
+	assert.Equal(t, params, s.MarketParams(market))
+	assert.Equal(t, DefaultMarketParams, s.MarketParams(MarketSymbol{Base: 0, Quote: 1}))
+
+	cases := []struct {
+		order PlaceOrderTxn
+		err   error
+	}{
+		{order: PlaceOrderTxn{SellSide: true, Quant: 100, Price: 100000050, Market: market}, err: ErrPriceTickSize},
+		{order: PlaceOrderTxn{SellSide: true, Quant: 105, Price: 100000000, Market: market}, err: ErrQuantLotSize},
+		{order: PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market}, err: ErrMinNotional},
+		{order: PlaceOrderTxn{SellSide: true, Quant: 100, Price: 100000000, Market: market}, err: nil},
+	}
+
+	trans = s.Transition(2, nil)
+	nonce := uint64(1)
+	for _, c := range cases {
+		pt, err := parseTxn(MakePlaceOrderTxn(sk, testChainID, addr, c.order, nonce), pker)
+		if err != nil {
+			panic(err)
+		}
+
+		err = trans.Record(pt)
+		assert.Equal(t, c.err, err)
+		if err == nil {
+			nonce++
+		}
+	}
+}
+
+func TestGenesisMarketParams(t *testing.T) {
+	pk, sk := RandKeyPair()
+	market := MarketSymbol{Base: 1, Quote: 0}
+	params := MarketParams{TickSize: 100, LotSize: 10, MinNotional: 50}
+	s := CreateGenesisStateWithParams([]PK{pk}, []TokenInfo{{Symbol: "BTC", Decimals: 8, TotalUnits: 100000}}, GenesisParams{
+		Markets: []GenesisMarket{{Market: market, Params: params}},
+	})
+	assert.Equal(t, params, s.MarketParams(market))
+	assert.Equal(t, DefaultMarketParams, s.MarketParams(MarketSymbol{Base: 0, Quote: 1}))
+
+	trans := s.Transition(1, nil)
+	b := MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 105, Price: 100000000, Market: market}, 0)
+	pt, err := parseTxn(b, s)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrQuantLotSize, trans.Record(pt))
+
+	assert.Panics(t, func() {
+		CreateGenesisStateWithParams([]PK{pk}, nil, GenesisParams{
+			Markets: []GenesisMarket{{Market: market, Params: params}},
+		})
+	})
+}
+
+func TestTradingFeeConservation(t *testing.T) {
+	pks := make([]PK, 3)
+	sks := make([]SK, 3)
+	for i := range pks {
+		pks[i], sks[i] = RandKeyPair()
+	}
+	poolPK, _ := RandKeyPair()
+	quoteInfo := TokenInfo{Symbol: "USD", Decimals: 8, TotalUnits: 3000000000}
+	s := CreateGenesisStateWithParams(pks, []TokenInfo{quoteInfo}, GenesisParams{
+		TradingFee: &TradingFee{MakerBps: 10, TakerBps: 25, Pool: poolPK},
+	})
+
+	market := MarketSymbol{Base: 0, Quote: 1}
+	orders := []struct {
+		idx   int
+		order PlaceOrderTxn
+	}{
+		{0, PlaceOrderTxn{SellSide: true, Quant: 3000000, Price: 110000000, Market: market}},
+		{1, PlaceOrderTxn{SellSide: true, Quant: 5000000, Price: 120000000, Market: market}},
+		{2, PlaceOrderTxn{Quant: 6000000, Price: 125000000, Market: market}},
+		{0, PlaceOrderTxn{Quant: 1000000, Price: 90000000, Market: market}},
+		{1, PlaceOrderTxn{SellSide: true, Quant: 4000000, Price: 80000000, Market: market}},
+		{2, PlaceOrderTxn{Quant: 1000000, Price: 50000000, Market: market}},
+	}
+
+	nonces := make([]uint64, len(pks))
+	trans := s.Transition(1, nil)
+	for _, o := range orders {
+		recordTxn(t, trans, pks[o.idx], MakePlaceOrderTxn(sks[o.idx], testChainID, pks[o.idx].Addr(), o.order, nonces[o.idx]))
+		nonces[o.idx]++
+	}
+	s = trans.Commit().(*State)
+
+	// both sides pay the fee in the quote token
+	pool := s.Account(poolPK.Addr())
+	assert.NotNil(t, pool)
+	assert.Equal(t, uint64(0), pool.Balance(0).Available)
+	assert.True(t, pool.Balance(1).Available > 0)
+	for _, token := range []TokenID{0, 1} {
+		sum := pool.Balance(token).Available
+		for _, pk := range pks {
+			b := s.Account(pk.Addr()).Balance(token)
+			sum += b.Available + b.Pending
+		}
+		assert.Equal(t, s.Tokens()[token].TotalUnits, sum)
+	}
+
+	var fee uint64
+	for _, pk := range pks {
+		for _, r := range s.Account(pk.Addr()).ExecutionReports() {
+			fee += r.Fee
+		}
+	}
+	assert.Equal(t, pool.Balance(1).Available, fee)
+
+	// the fee reserved for the resting buy order is released
+	// when the order is cancelled.
+	buyer := s.Account(pks[2].Addr())
+	assert.Equal(t, 1, len(buyer.PendingOrders()))
+	assert.Equal(t, uint64(500000+1250), buyer.Balance(1).Pending)
+	trans = s.Transition(2, nil)
+	recordTxn(t, trans, pks[2], MakeCancelOrderTxn(sks[2], testChainID, pks[2].Addr(), buyer.PendingOrders()[0].ID, nonces[2]))
+	s = trans.Commit().(*State)
+	buyer = s.Account(pks[2].Addr())
+	assert.Equal(t, uint64(0), buyer.Balance(1).Pending)
+	assert.Equal(t, 0, len(buyer.PendingOrders()))
+}
+
+func TestCalcFee(t *testing.T) {
+	assert.Equal(t, uint64(0), calcFee(399, 25))
+	assert.Equal(t, uint64(1), calcFee(400, 25))
+	assert.Equal(t, uint64(2500000000), calcFee(1000000000000, 25))
+}
+
+func TestIcebergOrder(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	s, pkMaker, skMaker, pkTaker, skTaker := newTIFTestState()
+	trans := s.Transition(1, nil)
+	recordTxn(t, trans, pkMaker, MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market, DisplayQuant: 3}, 0))
+	s = trans.Commit().(*State)
+
+	// only the displayed slice is in the depth, the owner sees
+	// the whole order
+	_, asks := s.OrderBookDepth(market, 0)
+	assert.Equal(t, []Level{{Price: 100000000, Quant: 3, Orders: 1}}, asks)
+	maker := s.Account(pkMaker.Addr())
+	assert.Equal(t, 1, len(maker.PendingOrders()))
+	assert.Equal(t, 10, int(maker.PendingOrders()[0].Quant))
+	assert.Equal(t, 10, int(maker.Balance(0).Pending))
+
+	// the taker fills the slice and a part of the next one
+	trans = s.Transition(2, nil)
+	recordTxn(t, trans, pkTaker, MakePlaceOrderTxn(skTaker, testChainID, pkTaker.Addr(), PlaceOrderTxn{Quant: 5, Price: 100000000, Market: market}, 0))
+	s = trans.Commit().(*State)
+
+	_, asks = s.OrderBookDepth(market, 0)
+	assert.Equal(t, []Level{{Price: 100000000, Quant: 1, Orders: 1}}, asks)
+	maker = s.Account(pkMaker.Addr())
+	assert.Equal(t, 5, int(maker.PendingOrders()[0].Executed))
+	assert.Equal(t, 5, int(s.Account(pkTaker.Addr()).Balance(0).Available-100))
+
+	// the hidden quantity is released when the order is
+	// cancelled
+	trans = s.Transition(3, nil)
+	recordTxn(t, trans, pkMaker, MakeCancelOrderTxn(skMaker, testChainID, pkMaker.Addr(), maker.PendingOrders()[0].ID, 1))
+	s = trans.Commit().(*State)
+
+	_, asks = s.OrderBookDepth(market, 0)
+	assert.Empty(t, asks)
+	maker = s.Account(pkMaker.Addr())
+	assert.Equal(t, 0, len(maker.PendingOrders()))
+	assert.Equal(t, 95, int(maker.Balance(0).Available))
+	assert.Equal(t, 0, int(maker.Balance(0).Pending))
+
+	// the display quant must be less than the quant
+	trans = s.Transition(4, nil)
+	pt, err := parseTxn(MakePlaceOrderTxn(skMaker, testChainID, pkMaker.Addr(), PlaceOrderTxn{SellSide: true, Quant: 10, Price: 100000000, Market: market, DisplayQuant: 10}, 2), &myPKer{m: map[consensus.Addr]PK{pkMaker.Addr(): pkMaker}})
+	if err != nil {
+		panic(err)
+	}
+	assert.NotNil(t, trans.Record(pt))
+}
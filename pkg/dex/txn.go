@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/rlp"
@@ -24,6 +25,38 @@ const (
 	FreezeToken
 	BurnToken
 	MinerFee
+	CancelAllOrders
+	MintToken
+	UpdateAccountPolicy
+)
+
+const (
+	// TxnVersion is the version of the txn envelope made by the
+	// Make*Txn helpers, it can carry the co-signatures of a
+	// multisig account.
+	TxnVersion = 5
+	// txnVersionNoSigs is the envelope with the owner PK field but
+	// without the co-signatures.
+	txnVersionNoSigs = 4
+	// txnVersionNoOwnerPK is the envelope with the fee field but
+	// without the owner PK field.
+	txnVersionNoOwnerPK = 3
+	// txnVersionNoFee is the envelope without the fee field, its
+	// signature covers the chain ID so that the txn can not be
+	// replayed on another chain. It is accepted as a txn of zero
+	// fee.
+	txnVersionNoFee = 2
+	// txnVersionLegacy is the envelope without the leading
+	// version byte.
+	txnVersionLegacy = 0
+	// txnVersionNoChainID is the envelope whose signature does not
+	// cover the chain ID. The legacy and this version are still
+	// decoded, but no longer accepted.
+	txnVersionNoChainID = 1
+	// rlpListPrefix is the smallest first byte of a RLP encoded
+	// list, the legacy envelope always starts with a byte not
+	// smaller than it.
+	rlpListPrefix = 0xc0
 )
 
 type Txn struct {
@@ -31,27 +64,230 @@ type Txn struct {
 	Data  []byte
 	Nonce uint64
 	Owner consensus.Addr
+	// the fee in the smallest unit of BNB paid to the block
+	// proposer, on top of the flat fee.
+	Fee uint64
+	// OwnerPK is the public key of the owner, it is only required
+	// when the owner's PK is not yet on chain.
+	OwnerPK PK
+	Sig     Sig
+	// Sigs are the co-signatures of the owners of a multisig
+	// account, signed over the same bytes as Sig.
+	Sigs []Sig
+
+	// the version of the envelope, it is encoded as the leading
+	// byte rather than as a RLP field.
+	version uint8
+}
+
+// txnNoFee is the layout of the envelopes before the fee field is
+// added.
+type txnNoFee struct {
+	T     TxnType
+	Data  []byte
+	Nonce uint64
+	Owner consensus.Addr
+	Sig   Sig
+}
+
+// txnNoOwnerPK is the layout of the envelopes before the owner PK
+// field is added.
+type txnNoOwnerPK struct {
+	T     TxnType
+	Data  []byte
+	Nonce uint64
+	Owner consensus.Addr
+	Fee   uint64
 	Sig   Sig
 }
 
+// txnNoSigs is the layout of the envelopes before the co-signatures
+// are added.
+type txnNoSigs struct {
+	T       TxnType
+	Data    []byte
+	Nonce   uint64
+	Owner   consensus.Addr
+	Fee     uint64
+	OwnerPK PK
+	Sig     Sig
+}
+
 func (b *Txn) Encode(withSig bool) []byte {
 	en := *b
 	if !withSig {
 		en.Sig = nil
+		en.Sigs = nil
+	}
+
+	var v interface{} = en
+	switch {
+	case b.version < txnVersionNoOwnerPK:
+		v = txnNoFee{T: en.T, Data: en.Data, Nonce: en.Nonce, Owner: en.Owner, Sig: en.Sig}
+	case b.version == txnVersionNoOwnerPK:
+		v = txnNoOwnerPK{T: en.T, Data: en.Data, Nonce: en.Nonce, Owner: en.Owner, Fee: en.Fee, Sig: en.Sig}
+	case b.version == txnVersionNoSigs:
+		v = txnNoSigs{T: en.T, Data: en.Data, Nonce: en.Nonce, Owner: en.Owner, Fee: en.Fee, OwnerPK: en.OwnerPK, Sig: en.Sig}
+	}
+
+	d, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		panic(err)
+	}
+
+	if b.version == txnVersionLegacy {
+		return d
+	}
+
+	return append([]byte{b.version}, d...)
+}
+
+// signingBytes returns the bytes signed by the txn owner, the chain
+// ID is prepended to separate the signatures of different chains.
+func (b *Txn) signingBytes(chainID consensus.Hash) []byte {
+	return append(chainID[:], b.Encode(false)...)
+}
+
+// decodeTxn decodes the txn envelope according to its version.
+func decodeTxn(b []byte) (Txn, error) {
+	if len(b) == 0 {
+		return Txn{}, errors.New("empty txn")
+	}
+
+	var txn Txn
+	switch v := b[0]; {
+	case v >= rlpListPrefix:
+		return decodeTxnNoFee(b, txnVersionLegacy)
+	case v == txnVersionNoChainID || v == txnVersionNoFee:
+		return decodeTxnNoFee(b[1:], v)
+	case v == txnVersionNoOwnerPK:
+		var t txnNoOwnerPK
+		err := rlp.DecodeBytes(b[1:], &t)
+		if err != nil {
+			return Txn{}, err
+		}
+		txn = Txn{T: t.T, Data: t.Data, Nonce: t.Nonce, Owner: t.Owner, Fee: t.Fee, Sig: t.Sig, version: v}
+	case v == txnVersionNoSigs:
+		var t txnNoSigs
+		err := rlp.DecodeBytes(b[1:], &t)
+		if err != nil {
+			return Txn{}, err
+		}
+		txn = Txn{T: t.T, Data: t.Data, Nonce: t.Nonce, Owner: t.Owner, Fee: t.Fee, OwnerPK: t.OwnerPK, Sig: t.Sig, version: v}
+	case v == TxnVersion:
+		err := rlp.DecodeBytes(b[1:], &txn)
+		if err != nil {
+			return Txn{}, err
+		}
+		txn.version = v
+	default:
+		return Txn{}, fmt.Errorf("unsupported txn version: %d", v)
+	}
+
+	return txn, nil
+}
+
+func decodeTxnNoFee(b []byte, version uint8) (Txn, error) {
+	var t txnNoFee
+	err := rlp.DecodeBytes(b, &t)
+	if err != nil {
+		return Txn{}, err
+	}
+
+	return Txn{T: t.T, Data: t.Data, Nonce: t.Nonce, Owner: t.Owner, Sig: t.Sig, version: version}, nil
+}
+
+// buildTxn returns the unsigned encoding of the txn and the digest
+// to sign.
+func buildTxn(chainID consensus.Hash, txn *Txn) ([]byte, consensus.Hash) {
+	return txn.Encode(false), consensus.SHA3(txn.signingBytes(chainID))
+}
+
+// AttachSignature attaches the signature over the digest returned by
+// a Build*Txn helper to the unsigned txn, the result is the txn to
+// send. The signature is verified against pk before attaching. The
+// later signatures of a multisig account are attached as the
+// co-signatures.
+func AttachSignature(chainID consensus.Hash, unsigned []byte, sig Sig, pk PK) ([]byte, error) {
+	txn, err := decodeTxn(unsigned)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sig) < 64 || !sig.Verify(txn.signingBytes(chainID), pk) {
+		return nil, errors.New("signature verification failed")
+	}
+
+	return attach(txn, sig), nil
+}
+
+func attach(txn Txn, sig Sig) []byte {
+	if len(txn.Sig) == 0 {
+		txn.Sig = sig
+	} else {
+		txn.Sigs = append(txn.Sigs, sig)
 	}
+	return txn.Encode(true)
+}
 
-	d, err := rlp.EncodeToBytes(en)
+// mustAttach attaches the signature without verifying it, it is used
+// by the Make*Txn helpers that sign with the SK.
+func mustAttach(unsigned []byte, sig Sig) []byte {
+	txn, err := decodeTxn(unsigned)
 	if err != nil {
 		panic(err)
 	}
 
-	return d
+	return attach(txn, sig)
+}
+
+// WithFee returns the txn with the fee set, signed again by the
+// owner.
+func WithFee(b []byte, sk SK, chainID consensus.Hash, fee uint64) ([]byte, error) {
+	txn, err := decodeTxn(b)
+	if err != nil {
+		return nil, err
+	}
+
+	txn.Fee = fee
+	txn.version = TxnVersion
+	txn.Sig = sk.Sign(txn.signingBytes(chainID))
+	return txn.Encode(true), nil
+}
+
+// WithOwnerPK returns the txn carrying the owner's PK, signed again
+// by the owner. It is used when the owner's PK is not yet on chain.
+func WithOwnerPK(b []byte, sk SK, chainID consensus.Hash, pk PK) ([]byte, error) {
+	txn, err := decodeTxn(b)
+	if err != nil {
+		return nil, err
+	}
+
+	txn.OwnerPK = pk
+	txn.version = TxnVersion
+	txn.Sig = sk.Sign(txn.signingBytes(chainID))
+	return txn.Encode(true), nil
 }
 
 func (b *Txn) Bytes() []byte {
 	return b.Encode(true)
 }
 
+// TimeInForce specifies how long an order remains active.
+type TimeInForce uint8
+
+const (
+	// GTC (good till cancel) orders rest on the order book until
+	// filled, cancelled or expired.
+	GTC TimeInForce = iota
+	// IOC (immediate or cancel) orders match whatever they can
+	// when placed, the unfilled part is cancelled.
+	IOC
+	// FOK (fill or kill) orders are executed only if the full
+	// quantity can be matched when placed.
+	FOK
+)
+
 type PlaceOrderTxn struct {
 	SellSide bool
 	// quant step size is the decimals of the token, specific when
@@ -62,8 +298,34 @@ type PlaceOrderTxn struct {
 	// the order is expired when ExpireRound >= block height
 	ExpireRound uint64
 	Market      MarketSymbol
+	TIF         TimeInForce
+	// market orders execute at the best available price, Price
+	// must be 0. They never rest on the order book.
+	MarketOrder bool
+	// the worst price that a market order accepts: the highest
+	// price for a buy order and the lowest price for a sell
+	// order, required for market orders.
+	MaxSlippageQuote uint64
+	// the stop order is placed as a limit order when the last
+	// traded price of the market crosses StopPrice: rises to or
+	// above it for a buy order, falls to or below it for a sell
+	// order. 0 means not a stop order.
+	StopPrice uint64
+	// the quantity of an iceberg order displayed in the order
+	// book at a time, the next slice is displayed when it is
+	// filled. 0 means not an iceberg order.
+	DisplayQuant uint64
 }
 
+const (
+	flagSellSide    = 1
+	flagMarketOrder = 1 << 3
+	flagStopOrder   = 1 << 4
+	flagIceberg     = 1 << 5
+	tifShift        = 1
+	tifMask         = 3
+)
+
 func (p *PlaceOrderTxn) Encode() []byte {
 	var buf bytes.Buffer
 	b := make([]byte, 64)
@@ -74,8 +336,36 @@ func (p *PlaceOrderTxn) Encode() []byte {
 	n = binary.PutUvarint(b, p.ExpireRound)
 	buf.Write(b[:n])
 	buf.Write(p.Market.Encode())
+	// the flags byte is omitted for GTC buy limit orders, for
+	// backward compatibility with the payloads without the TIF
+	// field.
+	flags := byte(p.TIF) << tifShift
 	if p.SellSide {
-		buf.Write([]byte{1})
+		flags |= flagSellSide
+	}
+	if p.MarketOrder {
+		flags |= flagMarketOrder
+	}
+	if p.StopPrice > 0 {
+		flags |= flagStopOrder
+	}
+	if p.DisplayQuant > 0 {
+		flags |= flagIceberg
+	}
+	if flags != 0 {
+		buf.Write([]byte{flags})
+	}
+	if p.MarketOrder {
+		n = binary.PutUvarint(b, p.MaxSlippageQuote)
+		buf.Write(b[:n])
+	}
+	if p.StopPrice > 0 {
+		n = binary.PutUvarint(b, p.StopPrice)
+		buf.Write(b[:n])
+	}
+	if p.DisplayQuant > 0 {
+		n = binary.PutUvarint(b, p.DisplayQuant)
+		buf.Write(b[:n])
 	}
 	return buf.Bytes()
 }
@@ -83,14 +373,23 @@ func (p *PlaceOrderTxn) Encode() []byte {
 func (p *PlaceOrderTxn) Decode(b []byte) error {
 	var t PlaceOrderTxn
 	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return errors.New("error decoding quant of the order")
+	}
 	t.Quant = v
 	b = b[n:]
 
 	v, n = binary.Uvarint(b)
+	if n <= 0 {
+		return errors.New("error decoding price of the order")
+	}
 	t.Price = v
 	b = b[n:]
 
 	v, n = binary.Uvarint(b)
+	if n <= 0 {
+		return errors.New("error decoding expire round of the order")
+	}
 	t.ExpireRound = v
 	b = b[n:]
 
@@ -100,9 +399,45 @@ func (p *PlaceOrderTxn) Decode(b []byte) error {
 	}
 
 	b = b[n:]
-	if len(b) == 1 {
-		t.SellSide = true
-	} else if len(b) > 1 {
+	if len(b) > 0 {
+		flags := b[0]
+		b = b[1:]
+		t.SellSide = flags&flagSellSide != 0
+		t.MarketOrder = flags&flagMarketOrder != 0
+		t.TIF = TimeInForce((flags >> tifShift) & tifMask)
+		if t.TIF > FOK {
+			return fmt.Errorf("unknown time in force: %d", t.TIF)
+		}
+
+		if t.MarketOrder {
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("error decoding max slippage of the market order")
+			}
+			t.MaxSlippageQuote = v
+			b = b[n:]
+		}
+
+		if flags&flagStopOrder != 0 {
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("error decoding stop price of the stop order")
+			}
+			t.StopPrice = v
+			b = b[n:]
+		}
+
+		if flags&flagIceberg != 0 {
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("error decoding display quant of the iceberg order")
+			}
+			t.DisplayQuant = v
+			b = b[n:]
+		}
+	}
+
+	if len(b) > 0 {
 		return fmt.Errorf("unexpected bytes remaining, count: %d", len(b))
 	}
 
@@ -114,23 +449,66 @@ type CancelOrderTxn struct {
 	ID OrderID
 }
 
-func MakeCancelOrderTxn(sk SK, owner consensus.Addr, id OrderID, nonce uint64) []byte {
+// BuildCancelOrderTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildCancelOrderTxn(chainID consensus.Hash, owner consensus.Addr, id OrderID, nonce uint64) ([]byte, consensus.Hash) {
 	t := CancelOrderTxn{
 		ID: id,
 	}
 
 	txn := &Txn{
-		T:     CancelOrder,
-		Owner: owner,
-		Nonce: nonce,
-		Data:  gobEncode(t),
+		T:       CancelOrder,
+		Owner:   owner,
+		Nonce:   nonce,
+		Data:    encodePayload(t),
+		version: TxnVersion,
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
-	return txn.Encode(true)
+	return buildTxn(chainID, txn)
 }
 
-func MakeSendTokenTxn(from SK, owner consensus.Addr, to PK, tokenID TokenID, quant uint64, nonce uint64) []byte {
+func MakeCancelOrderTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, id OrderID, nonce uint64) []byte {
+	unsigned, h := BuildCancelOrderTxn(chainID, owner, id, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
+}
+
+// MaxCancelAllOrders is the maximum number of orders cancelled by a
+// CancelAllOrdersTxn, the remaining orders can be cancelled by
+// another txn.
+const MaxCancelAllOrders = 1000
+
+// CancelAllOrdersTxn cancels all the owner's orders in the market, at
+// most MaxCancelAllOrders orders are cancelled.
+type CancelAllOrdersTxn struct {
+	Market MarketSymbol
+	// only cancel the orders of the side specified by SellSide
+	// when FilterSide is true.
+	FilterSide bool
+	SellSide   bool
+}
+
+// BuildCancelAllOrdersTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildCancelAllOrdersTxn(chainID consensus.Hash, owner consensus.Addr, t CancelAllOrdersTxn, nonce uint64) ([]byte, consensus.Hash) {
+	txn := &Txn{
+		T:       CancelAllOrders,
+		Owner:   owner,
+		Nonce:   nonce,
+		Data:    encodePayload(t),
+		version: TxnVersion,
+	}
+
+	return buildTxn(chainID, txn)
+}
+
+func MakeCancelAllOrdersTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, t CancelAllOrdersTxn, nonce uint64) []byte {
+	unsigned, h := BuildCancelAllOrdersTxn(chainID, owner, t, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
+}
+
+// BuildSendTokenTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildSendTokenTxn(chainID consensus.Hash, owner consensus.Addr, to PK, tokenID TokenID, quant uint64, nonce uint64) ([]byte, consensus.Hash) {
 	send := SendTokenTxn{
 		TokenID: tokenID,
 		To:      to,
@@ -138,63 +516,125 @@ func MakeSendTokenTxn(from SK, owner consensus.Addr, to PK, tokenID TokenID, qua
 	}
 
 	txn := &Txn{
-		T:     SendToken,
-		Owner: owner,
-		Nonce: nonce,
-		Data:  gobEncode(send),
+		T:       SendToken,
+		Owner:   owner,
+		Nonce:   nonce,
+		Data:    encodePayload(send),
+		version: TxnVersion,
 	}
 
-	txn.Sig = from.Sign(txn.Encode(false))
-	return txn.Encode(true)
+	return buildTxn(chainID, txn)
+}
+
+func MakeSendTokenTxn(from SK, chainID consensus.Hash, owner consensus.Addr, to PK, tokenID TokenID, quant uint64, nonce uint64) []byte {
+	unsigned, h := BuildSendTokenTxn(chainID, owner, to, tokenID, quant, nonce)
+	return mustAttach(unsigned, from.SignHash(h))
 }
 
-func MakePlaceOrderTxn(sk SK, owner consensus.Addr, t PlaceOrderTxn, nonce uint64) []byte {
+// BuildPlaceOrderTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildPlaceOrderTxn(chainID consensus.Hash, owner consensus.Addr, t PlaceOrderTxn, nonce uint64) ([]byte, consensus.Hash) {
 	txn := &Txn{
-		T:     PlaceOrder,
-		Owner: owner,
-		Nonce: nonce,
-		Data:  t.Encode(),
+		T:       PlaceOrder,
+		Owner:   owner,
+		Nonce:   nonce,
+		Data:    t.Encode(),
+		version: TxnVersion,
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
-	return txn.Encode(true)
+	return buildTxn(chainID, txn)
+}
+
+func MakePlaceOrderTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, t PlaceOrderTxn, nonce uint64) []byte {
+	unsigned, h := BuildPlaceOrderTxn(chainID, owner, t, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
 }
 
-func MakeIssueTokenTxn(sk SK, owner consensus.Addr, info TokenInfo, nonce uint64) []byte {
-	t := IssueTokenTxn{Info: info}
+// BuildIssueTokenTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildIssueTokenTxn(chainID consensus.Hash, owner consensus.Addr, info TokenInfo, nonce uint64) ([]byte, consensus.Hash) {
+	return BuildIssueTokenWithMarketsTxn(chainID, owner, IssueTokenTxn{Info: info}, nonce)
+}
+
+func MakeIssueTokenTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, info TokenInfo, nonce uint64) []byte {
+	unsigned, h := BuildIssueTokenTxn(chainID, owner, info, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
+}
+
+// BuildIssueTokenWithMarketsTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildIssueTokenWithMarketsTxn(chainID consensus.Hash, owner consensus.Addr, t IssueTokenTxn, nonce uint64) ([]byte, consensus.Hash) {
 	txn := &Txn{
-		T:     IssueToken,
-		Data:  gobEncode(t),
-		Nonce: nonce,
-		Owner: owner,
+		T:       IssueToken,
+		Data:    encodePayload(t),
+		Nonce:   nonce,
+		Owner:   owner,
+		version: TxnVersion,
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
-	return txn.Encode(true)
+	return buildTxn(chainID, txn)
 }
 
-func MakeFreezeTokenTxn(sk SK, owner consensus.Addr, t FreezeTokenTxn, nonce uint64) []byte {
+func MakeIssueTokenWithMarketsTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, t IssueTokenTxn, nonce uint64) []byte {
+	unsigned, h := BuildIssueTokenWithMarketsTxn(chainID, owner, t, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
+}
+
+// BuildFreezeTokenTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildFreezeTokenTxn(chainID consensus.Hash, owner consensus.Addr, t FreezeTokenTxn, nonce uint64) ([]byte, consensus.Hash) {
 	txn := &Txn{
-		T:     FreezeToken,
-		Data:  gobEncode(t),
-		Nonce: nonce,
-		Owner: owner,
+		T:       FreezeToken,
+		Data:    encodePayload(t),
+		Nonce:   nonce,
+		Owner:   owner,
+		version: TxnVersion,
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
-	return txn.Encode(true)
+	return buildTxn(chainID, txn)
 }
 
-func MakeBurnTokenTxn(sk SK, owner consensus.Addr, t BurnTokenTxn, nonce uint64) []byte {
+func MakeFreezeTokenTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, t FreezeTokenTxn, nonce uint64) []byte {
+	unsigned, h := BuildFreezeTokenTxn(chainID, owner, t, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
+}
+
+// BuildBurnTokenTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildBurnTokenTxn(chainID consensus.Hash, owner consensus.Addr, t BurnTokenTxn, nonce uint64) ([]byte, consensus.Hash) {
 	txn := &Txn{
-		T:     BurnToken,
-		Data:  gobEncode(t),
-		Nonce: nonce,
-		Owner: owner,
+		T:       BurnToken,
+		Data:    encodePayload(t),
+		Nonce:   nonce,
+		Owner:   owner,
+		version: TxnVersion,
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
-	return txn.Encode(true)
+	return buildTxn(chainID, txn)
+}
+
+func MakeBurnTokenTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, t BurnTokenTxn, nonce uint64) []byte {
+	unsigned, h := BuildBurnTokenTxn(chainID, owner, t, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
+}
+
+// BuildMintTokenTxn returns the unsigned txn and the digest to be
+// signed by the owner.
+func BuildMintTokenTxn(chainID consensus.Hash, owner consensus.Addr, t MintTokenTxn, nonce uint64) ([]byte, consensus.Hash) {
+	txn := &Txn{
+		T:       MintToken,
+		Data:    encodePayload(t),
+		Nonce:   nonce,
+		Owner:   owner,
+		version: TxnVersion,
+	}
+
+	return buildTxn(chainID, txn)
+}
+
+func MakeMintTokenTxn(sk SK, chainID consensus.Hash, owner consensus.Addr, t MintTokenTxn, nonce uint64) []byte {
+	unsigned, h := BuildMintTokenTxn(chainID, owner, t, nonce)
+	return mustAttach(unsigned, sk.SignHash(h))
 }
 
 type MinerFeeTxn struct {
@@ -202,6 +642,11 @@ type MinerFeeTxn struct {
 	Fee   uint64
 }
 
+type MintTokenTxn struct {
+	ID    TokenID
+	Quant uint64
+}
+
 type BurnTokenTxn struct {
 	ID    TokenID
 	Quant uint64
@@ -209,6 +654,14 @@ type BurnTokenTxn struct {
 
 type IssueTokenTxn struct {
 	Info TokenInfo
+	// the trading rules of the markets whose base token is the
+	// issued token.
+	Markets []QuoteMarketParams
+}
+
+type QuoteMarketParams struct {
+	Quote  TokenID
+	Params MarketParams
 }
 
 type SendTokenTxn struct {
@@ -223,13 +676,30 @@ type FreezeTokenTxn struct {
 	Quant          uint64
 }
 
-func gobEncode(v interface{}) []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(v)
+// payloadRLP is the format version byte that prefixes the RLP
+// encoded txn payloads. The legacy payloads are gob encoded, a gob
+// stream never starts with a byte in [0x80, 0xf7], so the version
+// byte tells the two formats apart. PlaceOrderTxn has its own
+// canonical encoding and is not prefixed.
+const payloadRLP = 0x80
+
+func encodePayload(v interface{}) []byte {
+	b, err := rlp.EncodeToBytes(v)
 	if err != nil {
 		// should not happen
 		panic(err)
 	}
-	return buf.Bytes()
+
+	return append([]byte{payloadRLP}, b...)
+}
+
+// decodePayload decodes the RLP encoded payload, the legacy gob
+// encoded payload is still accepted so the txns made by the older
+// wallets remain valid during the migration.
+func decodePayload(b []byte, v interface{}) error {
+	if len(b) > 0 && b[0] == payloadRLP {
+		return rlp.DecodeBytes(b[1:], v)
+	}
+
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
 }
@@ -1,9 +1,12 @@
 package dex
 
 import (
-	"bytes"
-	"encoding/gob"
+	"container/heap"
+	"container/list"
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,45 +18,184 @@ import (
 
 type pker interface {
 	PK(addr consensus.Addr) PK
+	// AccountPolicy returns the multisig policy of the account,
+	// nil if the account is not a multisig account.
+	AccountPolicy(addr consensus.Addr) *AccountPolicy
+	// ChainID returns the ID of the chain that the txns are
+	// signed for.
+	ChainID() consensus.Hash
 }
 
+const (
+	defaultMaxPoolTxns  = 50000
+	defaultMaxPoolBytes = 64 << 20
+	// the number of recently rejected txn hashes remembered by
+	// the pool.
+	rejectedCacheSize = 4096
+)
+
+// PoolOrder is the order of the txns returned by TxnPool.Txns, the
+// block proposer records the txns in this order.
+type PoolOrder int
+
+const (
+	// FIFO returns the oldest txns first.
+	FIFO PoolOrder = iota
+	// OwnerFair returns the txns of different owners in turn, so
+	// that one owner can not monopolize the blocks. The txns of
+	// the same owner are ordered by nonce.
+	OwnerFair
+	// FeePriority returns the txns paying higher fees first, the
+	// txns of the same owner are still ordered by nonce, and the
+	// txns paying the same fee are ordered by arrival.
+	FeePriority
+)
+
 type txnItem struct {
 	txn  *consensus.Txn
+	hash consensus.Hash
 	time time.Time
+	// the round that the order txn is expired, 0 means never
+	expireRound uint64
+}
+
+type nonceSlot struct {
+	Owner consensus.Addr
+	Nonce uint64
+}
+
+// PendingTxnInfo is the information of a txn in the pool.
+type PendingTxnInfo struct {
+	Hash  consensus.Hash
+	Type  TxnType
+	Nonce uint64
+	Age   time.Duration
 }
 
+func decodedTxnType(decoded interface{}) TxnType {
+	switch decoded.(type) {
+	case *PlaceOrderTxn:
+		return PlaceOrder
+	case *CancelOrderTxn:
+		return CancelOrder
+	case *IssueTokenTxn:
+		return IssueToken
+	case *SendTokenTxn:
+		return SendToken
+	case *FreezeTokenTxn:
+		return FreezeToken
+	case *BurnTokenTxn:
+		return BurnToken
+	case *CancelAllOrdersTxn:
+		return CancelAllOrders
+	case *MintTokenTxn:
+		return MintToken
+	case *UpdateAccountPolicyTxn:
+		return UpdateAccountPolicy
+	case *MinerFeeTxn:
+		return MinerFee
+	default:
+		panic(fmt.Errorf("unknown txn type: %T", decoded))
+	}
+}
+
+// NextNonce returns the smallest nonce not smaller than the account's
+// nonce that is not used by the pending txns.
+func NextNonce(nonce uint64, pending []PendingTxnInfo) uint64 {
+	used := make(map[uint64]bool, len(pending))
+	for _, p := range pending {
+		used[p.Nonce] = true
+	}
+
+	for used[nonce] {
+		nonce++
+	}
+	return nonce
+}
+
+// TxnPool stores the received transactions that are not yet
+// included in a block. The pool is bounded by the number of txns
+// and their total size, the oldest txns are evicted when the pool
+// is full.
 type TxnPool struct {
-	pker pker
+	pker     pker
+	maxCount int
+	maxBytes int
+	order    PoolOrder
 
-	mu    sync.Mutex
-	txns  map[consensus.Hash]*consensus.Txn
-	cache *lru.Cache
+	mu      sync.Mutex
+	txns    map[consensus.Hash]*list.Element
+	arrival *list.List
+	byOwner map[consensus.Addr]map[consensus.Hash]*list.Element
+	bySlot  map[nonceSlot]*list.Element
+	// the order txns indexed by expire round
+	byExpire map[uint64]map[consensus.Hash]*list.Element
+	round    uint64
+	bytes    int
+	dropped  uint64
+	cache    *lru.Cache
+	// the recently rejected txns, repeated gossip of the same
+	// invalid txn is rejected without decoding it again.
+	rejected *lru.Cache
+	// the leader state that the txns are validated against, nil
+	// before the first update.
+	state  *State
+	status *txnStatusIndex
+	// notified when the pool becomes non-empty
+	notify chan struct{}
+	stats  *poolStats
 }
 
 func NewTxnPool(pker pker) *TxnPool {
-	cache, err := lru.New(50000)
+	return NewBoundedTxnPool(pker, defaultMaxPoolTxns, defaultMaxPoolBytes)
+}
+
+// NewBoundedTxnPool creates a txn pool that holds at most maxCount
+// txns and maxBytes bytes of raw txns.
+func NewBoundedTxnPool(pker pker, maxCount, maxBytes int) *TxnPool {
+	cache, err := lru.New(defaultMaxPoolTxns)
+	if err != nil {
+		panic(err)
+	}
+
+	rejected, err := lru.New(rejectedCacheSize)
 	if err != nil {
 		panic(err)
 	}
 
 	return &TxnPool{
-		pker:  pker,
-		txns:  make(map[consensus.Hash]*consensus.Txn),
-		cache: cache,
+		pker:     pker,
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+		txns:     make(map[consensus.Hash]*list.Element),
+		arrival:  list.New(),
+		byOwner:  make(map[consensus.Addr]map[consensus.Hash]*list.Element),
+		byExpire: make(map[uint64]map[consensus.Hash]*list.Element),
+		bySlot:   make(map[nonceSlot]*list.Element),
+		cache:    cache,
+		rejected: rejected,
+		status:   newTxnStatusIndex(),
+		notify:   make(chan struct{}, 1),
+		stats:    newPoolStats(),
 	}
 }
 
 func parseTxn(b []byte, pker pker) (*consensus.Txn, error) {
-	var txn Txn
-	err := rlp.DecodeBytes(b, &txn)
+	txn, err := decodeTxn(b)
 	if err != nil {
 		return nil, fmt.Errorf("error decode txn: %v", err)
 	}
 
+	if txn.version < txnVersionNoFee {
+		return nil, fmt.Errorf("txn version %d is not signed for the chain, it is no longer accepted", txn.version)
+	}
+
 	ret := &consensus.Txn{
-		Raw:   b,
-		Owner: txn.Owner,
-		Nonce: txn.Nonce,
+		Raw:     b,
+		Owner:   txn.Owner,
+		Nonce:   txn.Nonce,
+		Fee:     txn.Fee,
+		OwnerPK: txn.OwnerPK,
 	}
 
 	switch txn.T {
@@ -65,98 +207,454 @@ func parseTxn(b []byte, pker pker) (*consensus.Txn, error) {
 		}
 		ret.Decoded = &t
 	case CancelOrder:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn CancelOrderTxn
-		err := dec.Decode(&txn)
+		var t CancelOrderTxn
+		err := decodePayload(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("CancelOrderTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 	case IssueToken:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn IssueTokenTxn
-		err := dec.Decode(&txn)
+		var t IssueTokenTxn
+		err := decodePayload(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("IssueTokenTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 	case SendToken:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn SendTokenTxn
-		err := dec.Decode(&txn)
+		var t SendTokenTxn
+		err := decodePayload(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("SendTokenTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 	case FreezeToken:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn FreezeTokenTxn
-		err := dec.Decode(&txn)
+		var t FreezeTokenTxn
+		err := decodePayload(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("FreezeTokenTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 	case BurnToken:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn BurnTokenTxn
-		err := dec.Decode(&txn)
+		var t BurnTokenTxn
+		err := decodePayload(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("BurnTokenTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
+	case CancelAllOrders:
+		var t CancelAllOrdersTxn
+		err := decodePayload(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("CancelAllOrdersTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case MintToken:
+		var t MintTokenTxn
+		err := decodePayload(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("MintTokenTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case UpdateAccountPolicy:
+		var t UpdateAccountPolicyTxn
+		err := decodePayload(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("UpdateAccountPolicyTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
 	case MinerFee:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn MinerFeeTxn
-		err := dec.Decode(&txn)
+		var t MinerFeeTxn
+		err := decodePayload(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("MinerFeeTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 		ret.MinerFeeTxn = true
 	default:
 		return nil, fmt.Errorf("unknown txn type: %v", txn.T)
 	}
 
-	if !ret.MinerFeeTxn && !txn.Sig.Verify(txn.Encode(false), pker.PK(txn.Owner)) {
-		return nil, fmt.Errorf("txn signature verification failed")
+	if !ret.MinerFeeTxn {
+		pk := pker.PK(txn.Owner)
+		if len(txn.OwnerPK) > 0 {
+			if txn.OwnerPK.Addr() != txn.Owner {
+				return nil, fmt.Errorf("txn owner PK does not match the owner %v", txn.Owner)
+			}
+			pk = txn.OwnerPK
+		}
+
+		if len(pk) == 0 {
+			return nil, errUnknownOwner
+		}
+
+		msg := txn.signingBytes(pker.ChainID())
+		if policy := pker.AccountPolicy(txn.Owner); policy != nil {
+			ret.Signers = policy.signers(msg, append([]Sig{txn.Sig}, txn.Sigs...))
+			if !policy.authorized(ret.Signers) {
+				return nil, badSigError(fmt.Sprintf("txn has %d valid signatures of the multisig account, %d required", len(ret.Signers), policy.Threshold))
+			}
+		} else {
+			if len(txn.Sigs) > 0 {
+				return nil, errors.New("txn of a single key account has co-signatures")
+			}
+
+			if len(txn.Sig) < 64 || !txn.Sig.Verify(msg, pk) {
+				return nil, badSigError("txn signature verification failed")
+			}
+			ret.Signers = []consensus.Addr{txn.Owner}
+		}
 	}
 
 	return ret, nil
 }
 
+// ownerSigned returns true if the parsed txn is signed only by its
+// owner and the owner is not a multisig account of the state, the
+// signers of such a txn are the same whichever state parsed it.
+func ownerSigned(txn *consensus.Txn, pker pker) bool {
+	if txn.MinerFeeTxn {
+		return true
+	}
+
+	if pker.AccountPolicy(txn.Owner) != nil || len(txn.Signers) != 1 || txn.Signers[0] != txn.Owner {
+		return false
+	}
+
+	dec, err := decodeTxn(txn.Raw)
+	return err == nil && len(dec.Sigs) == 0
+}
+
+// validatePayload checks the decoded txn payload without the state,
+// the txns that can never be valid are rejected before entering the
+// pool.
+func validatePayload(decoded interface{}) error {
+	switch t := decoded.(type) {
+	case *PlaceOrderTxn:
+		if t.Quant == 0 {
+			return errors.New("order quantity is 0")
+		}
+
+		if !t.MarketOrder && t.Price == 0 {
+			return errors.New("limit order price is 0")
+		}
+
+		if !t.Market.Valid() {
+			return fmt.Errorf("order's market is invalid: %v", t.Market)
+		}
+	case *IssueTokenTxn:
+		return t.Info.Validate()
+	case *SendTokenTxn:
+		if t.Quant == 0 {
+			return errors.New("send token quantity is 0")
+		}
+	case *FreezeTokenTxn:
+		if t.Quant == 0 {
+			return errors.New("freeze token quantity is 0")
+		}
+	case *BurnTokenTxn:
+		if t.Quant == 0 {
+			return errors.New("burn token quantity is 0")
+		}
+	case *MintTokenTxn:
+		if t.Quant == 0 {
+			return errors.New("mint token quantity is 0")
+		}
+	case *UpdateAccountPolicyTxn:
+		return t.Policy.Validate()
+	}
+
+	return nil
+}
+
+// AddResult is the result of adding a txn to the pool.
+type AddResult int
+
+const (
+	// TxnAdded means the txn is added to the pool.
+	TxnAdded AddResult = iota
+	// TxnReplaced means the txn is added to the pool, replacing
+	// the pending txn of the same owner and nonce.
+	TxnReplaced
+	// TxnDuplicate means the txn is already seen by the pool.
+	TxnDuplicate
+)
+
+func (r AddResult) String() string {
+	switch r {
+	case TxnAdded:
+		return "added"
+	case TxnReplaced:
+		return "replaced"
+	case TxnDuplicate:
+		return "duplicate"
+	default:
+		return fmt.Sprintf("unknown result %d", int(r))
+	}
+}
+
+var (
+	errMinerFeeTxn  = errors.New("miner fee txn is not added to the pool")
+	errUnknownOwner = errors.New("txn owner not found")
+)
+
+// recentlyRejected is returned for a txn that is rejected before.
+type recentlyRejected struct {
+	err error
+}
+
+func (r recentlyRejected) Error() string {
+	return fmt.Sprintf("txn is rejected recently: %v", r.err)
+}
+
+// Update updates the pool with the leader state, the txns are
+// validated against the accounts of the state.
+func (t *TxnPool) Update(s consensus.State) {
+	t.mu.Lock()
+	t.state = s.(*State)
+	t.mu.Unlock()
+}
+
 func (t *TxnPool) Add(b []byte) (*consensus.Txn, bool) {
+	txn, r, err := t.AddTxn(b)
+	if err != nil {
+		if _, ok := err.(recentlyRejected); !ok && err != errMinerFeeTxn {
+			log.Warn("error add txn to pool", "err", err)
+		}
+		return txn, false
+	}
+
+	return txn, r != TxnDuplicate
+}
+
+// AddTxn validates the txn against the leader state and adds it to
+// the pool, the returned error tells why the txn is rejected. A txn
+// replaces the pending txn of the same owner and nonce: only one of
+// them can be included, the latest submitted one wins. The decoded
+// txn is returned even if it is not added to the pool.
+func (t *TxnPool) AddTxn(b []byte) (*consensus.Txn, AddResult, error) {
+	txn, r, reason, err := t.addTxn(b)
+	if err != nil {
+		t.stats.reject(reason)
+	} else if r == TxnDuplicate {
+		t.stats.reject(RejectDuplicate)
+	} else {
+		t.stats.admit(consensus.SHA3(b), r == TxnReplaced, time.Now())
+	}
+	return txn, r, err
+}
+
+// addTxn adds the txn to the pool, the reject reason is returned
+// with the error.
+func (t *TxnPool) addTxn(b []byte) (*consensus.Txn, AddResult, RejectReason, error) {
 	hash := consensus.SHA3(b)
+	if err, ok := t.rejected.Get(hash); ok {
+		return nil, 0, rejectReason(err.(error)), recentlyRejected{err: err.(error)}
+	}
+
 	v, inCache := t.cache.Get(hash)
 	t.mu.Lock()
-	if r, ok := t.txns[hash]; ok {
+	if e, ok := t.txns[hash]; ok {
 		t.mu.Unlock()
-		return r, false
+		return e.Value.(*txnItem).txn, TxnDuplicate, 0, nil
 	}
 
 	if inCache {
+		// the txn is seen before, add it back without
+		// reporting it as new. It does not replace the txn of
+		// the same slot, otherwise a replaced txn gossiped
+		// again would take the slot back.
 		r := v.(*consensus.Txn)
-		t.txns[hash] = r
+		if _, ok := t.bySlot[nonceSlot{Owner: r.Owner, Nonce: r.Nonce}]; !ok {
+			t.insert(hash, r)
+		}
+		t.mu.Unlock()
+		return r, TxnDuplicate, 0, nil
+	}
+	t.mu.Unlock()
+
+	if len(b) > consensus.MaxTxnBytes {
+		return nil, 0, RejectOversized, fmt.Errorf("txn size %d exceeds the max txn size %d", len(b), consensus.MaxTxnBytes)
+	}
+
+	if len(b) > t.maxBytes {
+		t.mu.Lock()
+		t.dropped++
 		t.mu.Unlock()
-		return r, false
+		return nil, 0, RejectOversized, fmt.Errorf("txn size %d exceeds the txn pool size", len(b))
 	}
+
+	t.mu.Lock()
+	state := t.state
 	t.mu.Unlock()
 
-	ret, err := parseTxn(b, t.pker)
+	var pker pker = t.pker
+	if state != nil {
+		pker = state
+	}
+
+	ret, err := parseTxn(b, pker)
 	if err != nil {
-		log.Error("error add txn to pool", "err", err)
-		return nil, false
+		if err == errUnknownOwner {
+			// the owner may be created later, the txn
+			// is not remembered as rejected.
+			return nil, 0, RejectUnknownOwner, err
+		}
+
+		t.rejected.Add(hash, err)
+		return nil, 0, rejectReason(err), err
 	}
 
 	if ret.MinerFeeTxn {
-		return ret, false
+		return ret, 0, RejectInvalid, errMinerFeeTxn
+	}
+
+	err = validatePayload(ret.Decoded)
+	if err != nil {
+		t.rejected.Add(hash, err)
+		return ret, 0, RejectInvalid, err
+	}
+
+	if state != nil {
+		acc := state.Account(ret.Owner)
+		if acc == nil {
+			// the txn carries the PK of the owner that is
+			// not yet on chain, the account is read
+			// without being added to the leader state's
+			// cache.
+			acc = &Account{addr: ret.Owner, state: state}
+		}
+
+		if nonce := acc.Nonce(); ret.Nonce < nonce {
+			// the nonce never decreases, the txn can
+			// never be valid.
+			err = fmt.Errorf("txn nonce %d is already used, account nonce: %d", ret.Nonce, nonce)
+			t.rejected.Add(hash, err)
+			return ret, 0, RejectInvalid, err
+		}
+
+		// the fee checks depend on the state, the txn may
+		// become valid later, it is not remembered as
+		// rejected.
+		if minFee := state.MinTxnFee(); ret.Fee < minFee {
+			return ret, 0, RejectInvalid, fmt.Errorf("txn fee %d is less than the min txn fee %d", ret.Fee, minFee)
+		}
+
+		if b := acc.Balance(0); ret.Fee > b.Available {
+			return ret, 0, RejectInvalid, fmt.Errorf("txn fee %d exceeds the available balance %d", ret.Fee, b.Available)
+		}
 	}
 
 	t.cache.Add(hash, ret)
 
 	t.mu.Lock()
-	t.txns[hash] = ret
-	t.mu.Unlock()
-	return ret, true
+	defer t.mu.Unlock()
+
+	if _, ok := t.txns[hash]; ok {
+		// added concurrently
+		return ret, TxnDuplicate, 0, nil
+	}
+
+	if r := txnExpireRound(ret); expiredAt(r, t.round) {
+		return ret, 0, RejectExpired, fmt.Errorf("order txn expired at round %d, current round: %d", r, t.round)
+	}
+
+	if t.insert(hash, ret) {
+		return ret, TxnReplaced, 0, nil
+	}
+
+	return ret, TxnAdded, 0, nil
+}
+
+// rejectReason returns the reason of the txn rejected with err.
+func rejectReason(err error) RejectReason {
+	if _, ok := err.(badSigError); ok {
+		return RejectBadSig
+	}
+
+	return RejectInvalid
+}
+
+// insert adds the txn to the pool, replacing the txn of the same
+// owner and nonce, and evicts the oldest txns if the pool is full.
+// It returns true if a txn is replaced, the caller must hold t.mu.
+func (t *TxnPool) insert(hash consensus.Hash, txn *consensus.Txn) (replaced bool) {
+	if t.arrival.Len() == 0 {
+		defer t.notifyNonEmpty()
+	}
+
+	slot := nonceSlot{Owner: txn.Owner, Nonce: txn.Nonce}
+	if old, ok := t.bySlot[slot]; ok {
+		t.remove(old)
+		t.status.drop(old.Value.(*txnItem).hash, fmt.Sprintf("replaced by txn %v of the same nonce", hash))
+		replaced = true
+	}
+
+	item := &txnItem{txn: txn, hash: hash, time: time.Now(), expireRound: txnExpireRound(txn)}
+	e := t.arrival.PushBack(item)
+	t.txns[hash] = e
+	t.bySlot[slot] = e
+	if item.expireRound > 0 {
+		expiring := t.byExpire[item.expireRound]
+		if expiring == nil {
+			expiring = make(map[consensus.Hash]*list.Element)
+			t.byExpire[item.expireRound] = expiring
+		}
+		expiring[hash] = e
+	}
+
+	owned := t.byOwner[txn.Owner]
+	if owned == nil {
+		owned = make(map[consensus.Hash]*list.Element)
+		t.byOwner[txn.Owner] = owned
+	}
+	owned[hash] = e
+	t.bytes += len(txn.Raw)
+
+	for t.arrival.Len() > t.maxCount || t.bytes > t.maxBytes {
+		e := t.arrival.Front()
+		t.remove(e)
+		t.status.drop(e.Value.(*txnItem).hash, "evicted from the full txn pool")
+		t.dropped++
+		t.stats.evict(1)
+	}
+	return
+}
+
+// notifyNonEmpty notifies the subscriber that the pool becomes
+// non-empty. It never blocks, the notifications not yet received are
+// coalesced into one.
+func (t *TxnPool) notifyNonEmpty() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Notify returns the channel that receives a value when the pool
+// becomes non-empty.
+func (t *TxnPool) Notify() <-chan struct{} {
+	return t.notify
+}
+
+// remove removes the txn from the pool, the caller must hold t.mu.
+func (t *TxnPool) remove(e *list.Element) {
+	item := t.arrival.Remove(e).(*txnItem)
+	delete(t.txns, item.hash)
+	delete(t.bySlot, nonceSlot{Owner: item.txn.Owner, Nonce: item.txn.Nonce})
+	owned := t.byOwner[item.txn.Owner]
+	delete(owned, item.hash)
+	if len(owned) == 0 {
+		delete(t.byOwner, item.txn.Owner)
+	}
+
+	if item.expireRound > 0 {
+		expiring := t.byExpire[item.expireRound]
+		delete(expiring, item.hash)
+		if len(expiring) == 0 {
+			delete(t.byExpire, item.expireRound)
+		}
+	}
+	t.bytes -= len(item.txn.Raw)
 }
 
 func (t *TxnPool) NotSeen(h consensus.Hash) bool {
@@ -172,9 +670,16 @@ func (t *TxnPool) Get(h consensus.Hash) *consensus.Txn {
 	if ok {
 		return v.(*consensus.Txn)
 	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	return t.txns[h]
+
+	e, ok := t.txns[h]
+	if !ok {
+		return nil
+	}
+
+	return e.Value.(*txnItem).txn
 }
 
 func (t *TxnPool) Size() int {
@@ -183,24 +688,291 @@ func (t *TxnPool) Size() int {
 	return len(t.txns)
 }
 
+// Bytes returns the total size of the raw txns in the pool.
+func (t *TxnPool) Bytes() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bytes
+}
+
+// Dropped returns the number of txns dropped because the pool is
+// full.
+func (t *TxnPool) Dropped() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped
+}
+
+// txnExpireRound returns the round that the order txn is expired,
+// or 0 if the txn never expires.
+func txnExpireRound(txn *consensus.Txn) uint64 {
+	if o, ok := txn.Decoded.(*PlaceOrderTxn); ok {
+		return o.ExpireRound
+	}
+	return 0
+}
+
+// expiredAt returns true if the order expiring at expireRound can
+// not be placed in the given round, 0 means never expires. It is the
+// same rule as the transition's.
+func expiredAt(expireRound, round uint64) bool {
+	return expireRound > 0 && round >= expireRound
+}
+
+// AdvanceRound removes the order txns that are expired at the given
+// round. An order txn expiring at round R can only be included in
+// the blocks before round R, it is removed when round R starts.
+func (t *TxnPool) AdvanceRound(round uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if round <= t.round {
+		return
+	}
+	t.round = round
+
+	for r, expiring := range t.byExpire {
+		if !expiredAt(r, round) {
+			continue
+		}
+
+		// t.remove deletes the txn from expiring
+		t.stats.expire(len(expiring))
+		for _, e := range expiring {
+			t.remove(e)
+			t.status.drop(e.Value.(*txnItem).hash, fmt.Sprintf("order expired at round %d", r))
+		}
+	}
+}
+
+// Included tells the pool that the txns are included in the block of
+// the given round, so that their status can be looked up.
+func (t *TxnPool) Included(round uint64, block consensus.Hash, txns []byte) {
+	var ts [][]byte
+	if len(txns) > 0 {
+		err := rlp.DecodeBytes(txns, &ts)
+		if err != nil {
+			log.Error("error decode included txns", "err", err)
+			return
+		}
+	}
+
+	now := time.Now()
+	hashes := make([]consensus.Hash, len(ts))
+	for i, b := range ts {
+		hashes[i] = consensus.SHA3(b)
+		t.stats.include(hashes[i], now)
+	}
+	t.status.include(round, block, hashes)
+}
+
+// Stats returns the metrics of the pool.
+func (t *TxnPool) Stats() PoolStats {
+	r := t.stats.snapshot(time.Now())
+	t.mu.Lock()
+	r.Count = t.arrival.Len()
+	r.Bytes = t.bytes
+	t.mu.Unlock()
+	return r
+}
+
+// SetStatusRetention sets the number of recent rounds whose included
+// txns can be looked up by TxnStatus.
+func (t *TxnPool) SetStatusRetention(rounds uint64) {
+	t.status.setRetention(rounds)
+}
+
+// TxnStatus returns the status of the txn.
+func (t *TxnPool) TxnStatus(hash consensus.Hash) TxnStatusResult {
+	if i, ok := t.status.includedAt(hash); ok {
+		return TxnStatusResult{Status: TxnIncluded, Round: i.round, Block: i.block}
+	}
+
+	t.mu.Lock()
+	_, pending := t.txns[hash]
+	t.mu.Unlock()
+	if pending {
+		return TxnStatusResult{Status: TxnPending}
+	}
+
+	if err, ok := t.rejected.Get(hash); ok {
+		return TxnStatusResult{Status: TxnRejected, Reason: err.(error).Error()}
+	}
+
+	if reason, ok := t.status.dropped.Get(hash); ok {
+		return TxnStatusResult{Status: TxnRejected, Reason: reason.(string)}
+	}
+
+	return TxnStatusResult{Status: TxnUnknown}
+}
+
+// WaitIncluded waits until the txn is included in a block, at most
+// for the given number of rounds or until the context is done, and
+// returns the status of the txn.
+func (t *TxnPool) WaitIncluded(ctx context.Context, hash consensus.Hash, rounds uint64) TxnStatusResult {
+	t.status.waitIncluded(ctx, hash, rounds)
+	return t.TxnStatus(hash)
+}
+
+// PendingForAddr returns the txns of the owner in the pool, ordered
+// by nonce.
+func (t *TxnPool) PendingForAddr(addr consensus.Addr) []PendingTxnInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	owned := t.byOwner[addr]
+	r := make([]PendingTxnInfo, 0, len(owned))
+	for _, e := range owned {
+		item := e.Value.(*txnItem)
+		r = append(r, PendingTxnInfo{
+			Hash:  item.hash,
+			Type:  decodedTxnType(item.txn.Decoded),
+			Nonce: item.txn.Nonce,
+			Age:   now.Sub(item.time),
+		})
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Nonce != r[j].Nonce {
+			return r[i].Nonce < r[j].Nonce
+		}
+		return r[i].Age > r[j].Age
+	})
+	return r
+}
+
+// SetOrder sets the order of the txns returned by Txns, it must be
+// called before the pool is used.
+func (t *TxnPool) SetOrder(o PoolOrder) {
+	t.order = o
+}
+
+// Txns returns the txns in the pool in the order of priority.
 func (t *TxnPool) Txns() []*consensus.Txn {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	i := 0
-	txns := make([]*consensus.Txn, len(t.txns))
-	for _, v := range t.txns {
-		txns[i] = v
+	txns := make([]*consensus.Txn, t.arrival.Len())
+	for e := t.arrival.Front(); e != nil; e = e.Next() {
+		txns[i] = e.Value.(*txnItem).txn
 		i++
 	}
+
+	switch t.order {
+	case OwnerFair:
+		return ownerFair(txns)
+	case FeePriority:
+		return feePriority(txns)
+	}
+
 	return txns
 }
 
+type feeHead struct {
+	txns []*consensus.Txn
+	// the arrival index of txns[0]
+	arrival int
+}
+
+// feeHeap is a max heap of the next txn of each owner by fee.
+type feeHeap []feeHead
+
+func (h feeHeap) Len() int { return len(h) }
+
+func (h feeHeap) Less(i, j int) bool {
+	if h[i].txns[0].Fee != h[j].txns[0].Fee {
+		return h[i].txns[0].Fee > h[j].txns[0].Fee
+	}
+
+	return h[i].arrival < h[j].arrival
+}
+
+func (h feeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *feeHeap) Push(x interface{}) { *h = append(*h, x.(feeHead)) }
+
+func (h *feeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// feePriority reorders the arrival ordered txns by fee. A txn can
+// only be proposed after the txns of the same owner with a lower
+// nonce, so the next txn of each owner competes by its fee.
+func feePriority(txns []*consensus.Txn) []*consensus.Txn {
+	arrival := make(map[*consensus.Txn]int, len(txns))
+	byOwner := make(map[consensus.Addr][]*consensus.Txn)
+	for i, txn := range txns {
+		arrival[txn] = i
+		byOwner[txn.Owner] = append(byOwner[txn.Owner], txn)
+	}
+
+	h := make(feeHeap, 0, len(byOwner))
+	for _, ts := range byOwner {
+		sort.SliceStable(ts, func(i, j int) bool {
+			return ts[i].Nonce < ts[j].Nonce
+		})
+		h = append(h, feeHead{txns: ts, arrival: arrival[ts[0]]})
+	}
+	heap.Init(&h)
+
+	r := make([]*consensus.Txn, 0, len(txns))
+	for h.Len() > 0 {
+		head := &h[0]
+		r = append(r, head.txns[0])
+		head.txns = head.txns[1:]
+		if len(head.txns) == 0 {
+			heap.Pop(&h)
+			continue
+		}
+
+		head.arrival = arrival[head.txns[0]]
+		heap.Fix(&h, 0)
+	}
+	return r
+}
+
+// ownerFair reorders the txns so that the owners take turns, the
+// owners are ordered by their oldest txn.
+func ownerFair(txns []*consensus.Txn) []*consensus.Txn {
+	var owners []consensus.Addr
+	byOwner := make(map[consensus.Addr][]*consensus.Txn)
+	for _, txn := range txns {
+		if _, ok := byOwner[txn.Owner]; !ok {
+			owners = append(owners, txn.Owner)
+		}
+		byOwner[txn.Owner] = append(byOwner[txn.Owner], txn)
+	}
+
+	for _, ts := range byOwner {
+		sort.SliceStable(ts, func(i, j int) bool {
+			return ts[i].Nonce < ts[j].Nonce
+		})
+	}
+
+	r := make([]*consensus.Txn, 0, len(txns))
+	for i := 0; len(r) < len(txns); i++ {
+		for _, owner := range owners {
+			ts := byOwner[owner]
+			if i < len(ts) {
+				r = append(r, ts[i])
+			}
+		}
+	}
+	return r
+}
+
 func (t *TxnPool) Remove(hash consensus.Hash) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	delete(t.txns, hash)
+	if e, ok := t.txns[hash]; ok {
+		t.remove(e)
+	}
 }
 
 func (t *TxnPool) RemoveTxns(b []byte) int {
@@ -214,7 +986,9 @@ func (t *TxnPool) RemoveTxns(b []byte) int {
 	t.mu.Lock()
 	for _, txn := range txns {
 		h := consensus.SHA3(txn)
-		delete(t.txns, h)
+		if e, ok := t.txns[h]; ok {
+			t.remove(e)
+		}
 	}
 	t.mu.Unlock()
 	return len(txns)
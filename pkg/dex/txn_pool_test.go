@@ -0,0 +1,585 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func makePoolTestTxns(n int) (*myPKer, [][]byte) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	txns := make([][]byte, n)
+	for i := range txns {
+		txns[i] = MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, uint64(i))
+	}
+	return pker, txns
+}
+
+func TestTxnPoolEvictOldest(t *testing.T) {
+	pker, txns := makePoolTestTxns(10)
+	pool := NewBoundedTxnPool(pker, 4, 1<<20)
+	for _, b := range txns {
+		_, broadcast := pool.Add(b)
+		assert.True(t, broadcast)
+	}
+
+	assert.Equal(t, 4, pool.Size())
+	assert.Equal(t, 6, int(pool.Dropped()))
+	remaining := pool.Txns()
+	for i, txn := range remaining {
+		assert.Equal(t, txns[6+i], txn.Raw)
+	}
+}
+
+func TestTxnPoolMaxBytes(t *testing.T) {
+	pker, txns := makePoolTestTxns(10)
+	size := len(txns[0])
+	pool := NewBoundedTxnPool(pker, 100, size*3)
+	for _, b := range txns {
+		pool.Add(b)
+	}
+
+	assert.Equal(t, 3, pool.Size())
+	assert.True(t, pool.Bytes() <= size*3)
+
+	small := NewBoundedTxnPool(pker, 100, size-1)
+	txn, broadcast := small.Add(txns[0])
+	assert.Nil(t, txn)
+	assert.False(t, broadcast)
+	assert.Equal(t, 0, small.Size())
+	assert.Equal(t, 1, int(small.Dropped()))
+}
+
+func TestTxnPoolDuplicate(t *testing.T) {
+	pker, txns := makePoolTestTxns(1)
+	pool := NewBoundedTxnPool(pker, 4, 1<<20)
+	_, broadcast := pool.Add(txns[0])
+	assert.True(t, broadcast)
+
+	for i := 0; i < 50; i++ {
+		_, broadcast = pool.Add(txns[0])
+		assert.False(t, broadcast)
+	}
+
+	assert.Equal(t, 1, pool.Size())
+	assert.Equal(t, len(txns[0]), pool.Bytes())
+	assert.Equal(t, 0, int(pool.Dropped()))
+}
+
+func TestTxnPoolRemoveAfterEviction(t *testing.T) {
+	pker, txns := makePoolTestTxns(20)
+	pool := NewBoundedTxnPool(pker, 5, 1<<20)
+	for _, b := range txns {
+		pool.Add(b)
+	}
+
+	// removing evicted txns is a no-op
+	for _, b := range txns[:15] {
+		pool.Remove(consensus.SHA3(b))
+	}
+	assert.Equal(t, 5, pool.Size())
+
+	pool.Remove(consensus.SHA3(txns[17]))
+	assert.Equal(t, 4, pool.Size())
+	assert.True(t, pool.NotSeen(consensus.SHA3(txns[17])))
+	assert.False(t, pool.NotSeen(consensus.SHA3(txns[18])))
+
+	for _, b := range txns[15:] {
+		pool.Remove(consensus.SHA3(b))
+	}
+	assert.Equal(t, 0, pool.Size())
+	assert.Equal(t, 0, pool.Bytes())
+}
+
+func TestTxnPoolOwnerFair(t *testing.T) {
+	pker, spam := makePoolTestTxns(1000)
+	pool := NewBoundedTxnPool(pker, 2000, 1<<30)
+	pool.SetOrder(OwnerFair)
+	// add in reverse nonce order, the txns of the same owner
+	// should still be ordered by nonce.
+	for i := len(spam) - 1; i >= 0; i-- {
+		pool.Add(spam[i])
+	}
+
+	to, _ := RandKeyPair()
+	var others []consensus.Addr
+	for i := 0; i < 3; i++ {
+		pk, sk := RandKeyPair()
+		pker.m[pk.Addr()] = pk
+		pool.Add(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0))
+		others = append(others, pk.Addr())
+	}
+
+	txns := pool.Txns()
+	assert.Equal(t, 1003, len(txns))
+	// the other owners are not starved by the spammy owner
+	for i, addr := range others {
+		assert.Equal(t, addr, txns[i+1].Owner)
+	}
+
+	var nonces []uint64
+	for _, txn := range txns {
+		if txn.Owner == txns[0].Owner {
+			nonces = append(nonces, txn.Nonce)
+		}
+	}
+	assert.Equal(t, 1000, len(nonces))
+	for i, n := range nonces {
+		assert.Equal(t, uint64(i), n)
+	}
+}
+
+func TestTxnPoolFIFO(t *testing.T) {
+	pker, txns := makePoolTestTxns(10)
+	pool := NewBoundedTxnPool(pker, 100, 1<<20)
+	for i := len(txns) - 1; i >= 0; i-- {
+		pool.Add(txns[i])
+	}
+
+	for i, txn := range pool.Txns() {
+		assert.Equal(t, txns[len(txns)-1-i], txn.Raw)
+	}
+}
+
+func TestTxnPoolPendingForAddr(t *testing.T) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	pool := NewTxnPool(pker)
+	pool.Add(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 3))
+	pool.Add(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 4))
+	pool.Add(MakeFreezeTokenTxn(sk, testChainID, pk.Addr(), FreezeTokenTxn{TokenID: 0, AvailableRound: 10, Quant: 1}, 6))
+	cancel := MakeCancelOrderTxn(sk, testChainID, pk.Addr(), OrderID{ID: 1}, 1)
+	pool.Add(cancel)
+
+	pending := pool.PendingForAddr(pk.Addr())
+	assert.Equal(t, 4, len(pending))
+	assert.Equal(t, []uint64{1, 3, 4, 6}, []uint64{pending[0].Nonce, pending[1].Nonce, pending[2].Nonce, pending[3].Nonce})
+	assert.Equal(t, CancelOrder, pending[0].Type)
+	assert.Equal(t, consensus.SHA3(cancel), pending[0].Hash)
+	assert.Equal(t, FreezeToken, pending[3].Type)
+	assert.Equal(t, 0, len(pool.PendingForAddr(to.Addr())))
+
+	assert.Equal(t, 0, int(NextNonce(0, pending)))
+	assert.Equal(t, 2, int(NextNonce(1, pending)))
+	assert.Equal(t, 5, int(NextNonce(3, pending)))
+	assert.Equal(t, 7, int(NextNonce(6, pending)))
+
+	pool.Remove(consensus.SHA3(cancel))
+	pending = pool.PendingForAddr(pk.Addr())
+	assert.Equal(t, 3, len(pending))
+	assert.Equal(t, 1, int(NextNonce(1, pending)))
+}
+
+func TestTxnPoolReplaceByNonce(t *testing.T) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	pool := NewTxnPool(pker)
+	old := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 4)
+	_, r, err := pool.AddTxn(old)
+	assert.Nil(t, err)
+	assert.Equal(t, TxnAdded, r)
+
+	replacement := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 2, 4)
+	_, r, err = pool.AddTxn(replacement)
+	assert.Nil(t, err)
+	assert.Equal(t, TxnReplaced, r)
+	assert.Equal(t, 1, pool.Size())
+	assert.True(t, pool.NotSeen(consensus.SHA3(old)))
+	assert.NotNil(t, pool.Get(consensus.SHA3(replacement)))
+
+	// the replaced txn gossiped again does not take the slot
+	// back.
+	_, r, err = pool.AddTxn(old)
+	assert.Nil(t, err)
+	assert.Equal(t, TxnDuplicate, r)
+	assert.Equal(t, 1, pool.Size())
+	assert.NotNil(t, pool.Get(consensus.SHA3(replacement)))
+
+	_, r, err = pool.AddTxn(replacement)
+	assert.Nil(t, err)
+	assert.Equal(t, TxnDuplicate, r)
+	assert.Equal(t, 1, pool.Size())
+	assert.Equal(t, len(replacement), pool.Bytes())
+}
+
+func TestTxnPoolReplaceIndependentSlots(t *testing.T) {
+	pk, sk := RandKeyPair()
+	other, otherSK := RandKeyPair()
+	to, _ := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk, other.Addr(): other}}
+	pool := NewTxnPool(pker)
+	_, r, _ := pool.AddTxn(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 4))
+	assert.Equal(t, TxnAdded, r)
+	_, r, _ = pool.AddTxn(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 5))
+	assert.Equal(t, TxnAdded, r)
+	_, r, _ = pool.AddTxn(MakeSendTokenTxn(otherSK, testChainID, other.Addr(), to, 0, 1, 4))
+	assert.Equal(t, TxnAdded, r)
+	assert.Equal(t, 3, pool.Size())
+}
+
+func TestTxnPoolAdvanceRound(t *testing.T) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	pool := NewTxnPool(pker)
+	market := MarketSymbol{Base: 0, Quote: 1}
+	order := MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 1, Price: 1, Market: market, ExpireRound: 10}, 0)
+	noExpire := MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 1, Price: 1, Market: market}, 1)
+	send := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 2)
+	pool.Add(order)
+	pool.Add(noExpire)
+	pool.Add(send)
+
+	pool.AdvanceRound(9)
+	assert.Equal(t, 3, len(pool.Txns()))
+
+	// the order expires at exactly round 10
+	pool.AdvanceRound(10)
+	txns := pool.Txns()
+	assert.Equal(t, 2, len(txns))
+	assert.Equal(t, noExpire, txns[0].Raw)
+	assert.Equal(t, send, txns[1].Raw)
+	assert.True(t, pool.NotSeen(consensus.SHA3(order)))
+
+	// an already expired order is not added
+	expired := MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 1, Price: 1, Market: market, ExpireRound: 10}, 3)
+	_, broadcast := pool.Add(expired)
+	assert.False(t, broadcast)
+	assert.Equal(t, 2, pool.Size())
+	pool.AdvanceRound(11)
+	assert.Equal(t, 2, pool.Size())
+}
+
+func TestTxnPoolExpireMatchesTransition(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	market := MarketSymbol{Base: 0, Quote: 1}
+	b := MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{SellSide: true, Quant: 1, Price: 100000000, Market: market, ExpireRound: 10}, 0)
+	pt, err := parseTxn(b, s)
+	assert.Nil(t, err)
+
+	// the order can be placed in round 9 but not in round 10,
+	// the pool keeps it until round 10 starts.
+	assert.Nil(t, s.Transition(9, nil).Record(pt))
+	assert.NotNil(t, s.Transition(10, nil).Record(pt))
+
+	pool := NewTxnPool(&myPKer{m: map[consensus.Addr]PK{}})
+	pool.Update(s)
+	pool.Add(b)
+	pool.AdvanceRound(9)
+	assert.Equal(t, 1, pool.Size())
+	pool.AdvanceRound(10)
+	assert.Equal(t, 0, pool.Size())
+}
+
+func TestTxnPoolAdmission(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.Account(pk.Addr()).IncrementNonce()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	unknown, unknownSK := RandKeyPair()
+	pool := NewTxnPool(&myPKer{m: map[consensus.Addr]PK{}})
+	pool.Update(s)
+
+	market := MarketSymbol{Base: 0, Quote: 1}
+	valid := MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 1, Price: 1, Market: market}, 1)
+	_, r, err := pool.AddTxn(valid)
+	assert.Nil(t, err)
+	assert.Equal(t, TxnAdded, r)
+
+	otherSigner := Txn{T: SendToken, Owner: pk.Addr(), Nonce: 2, Data: encodePayload(SendTokenTxn{To: to, Quant: 1}), version: TxnVersion}
+	otherSigner.Sig = unknownSK.Sign(otherSigner.signingBytes(testChainID))
+
+	cases := []struct {
+		name string
+		b    []byte
+	}{
+		{"malformed", []byte{1, 2, 3}},
+		{"unknown type", (&Txn{T: 100, Owner: pk.Addr(), version: TxnVersion}).Bytes()},
+		{"malformed payload", (&Txn{T: SendToken, Owner: pk.Addr(), Data: []byte{payloadRLP, 1}, version: TxnVersion}).Bytes()},
+		{"no chain ID", (&Txn{T: SendToken, Owner: pk.Addr(), Nonce: 2, Data: encodePayload(SendTokenTxn{To: to, Quant: 1})}).Bytes()},
+		{"bad signature", otherSigner.Bytes()},
+		{"unknown owner", MakeSendTokenTxn(unknownSK, testChainID, unknown.Addr(), to, 0, 1, 0)},
+		{"zero quantity", MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 0, 2)},
+		{"zero price", MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 1, Market: market}, 2)},
+		{"used nonce", MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)},
+	}
+
+	for _, c := range cases {
+		_, _, err := pool.AddTxn(c.b)
+		assert.NotNil(t, err, c.name)
+		assert.True(t, pool.NotSeen(consensus.SHA3(c.b)), c.name)
+	}
+	assert.Equal(t, 1, pool.Size())
+}
+
+func TestTxnPoolRejectedCache(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	unknown, unknownSK := RandKeyPair()
+	pool := NewTxnPool(&myPKer{m: map[consensus.Addr]PK{}})
+	pool.Update(s)
+
+	bad := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 0, 0)
+	_, _, err := pool.AddTxn(bad)
+	assert.NotNil(t, err)
+	_, ok := err.(recentlyRejected)
+	assert.False(t, ok)
+
+	_, _, err = pool.AddTxn(bad)
+	_, ok = err.(recentlyRejected)
+	assert.True(t, ok)
+
+	// the txn of an unknown owner is not remembered, it is
+	// accepted once the owner exists.
+	b := MakeSendTokenTxn(unknownSK, testChainID, unknown.Addr(), to, 0, 1, 0)
+	_, _, err = pool.AddTxn(b)
+	assert.Equal(t, errUnknownOwner, err)
+	s.NewAccount(unknown)
+	s.CommitCache()
+	_, r, err := pool.AddTxn(b)
+	assert.Nil(t, err)
+	assert.Equal(t, TxnAdded, r)
+}
+
+func TestTxnPoolMaxTxnBytes(t *testing.T) {
+	pk, sk := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	pool := NewTxnPool(pker)
+	txn := &Txn{T: IssueToken, Owner: pk.Addr(), Data: make([]byte, consensus.MaxTxnBytes)}
+	txn.Sig = sk.Sign(txn.Encode(false))
+	_, _, err := pool.AddTxn(txn.Bytes())
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, pool.Size())
+	assert.Equal(t, 0, int(pool.Dropped()))
+}
+
+func TestTxnPoolTxnStatus(t *testing.T) {
+	pker, txns := makePoolTestTxns(2)
+	pool := NewTxnPool(pker)
+	pool.SetStatusRetention(2)
+	h := consensus.SHA3(txns[0])
+	assert.Equal(t, TxnUnknown, pool.TxnStatus(h).Status)
+
+	pool.Add(txns[0])
+	assert.Equal(t, TxnPending, pool.TxnStatus(h).Status)
+
+	block := consensus.Hash{1}
+	blob, err := rlp.EncodeToBytes([][]byte{txns[0]})
+	assert.Nil(t, err)
+	pool.RemoveTxns(blob)
+	pool.Included(3, block, blob)
+	assert.Equal(t, TxnStatusResult{Status: TxnIncluded, Round: 3, Block: block}, pool.TxnStatus(h))
+
+	// the included txns are forgotten after the retained rounds
+	pool.Included(4, consensus.Hash{2}, nil)
+	assert.Equal(t, TxnIncluded, pool.TxnStatus(h).Status)
+	pool.Included(5, consensus.Hash{3}, nil)
+	assert.Equal(t, TxnUnknown, pool.TxnStatus(h).Status)
+
+	bad := (&Txn{T: 100}).Bytes()
+	_, _, err = pool.AddTxn(bad)
+	assert.NotNil(t, err)
+	r := pool.TxnStatus(consensus.SHA3(bad))
+	assert.Equal(t, TxnRejected, r.Status)
+	assert.Equal(t, err.Error(), r.Reason)
+}
+
+func TestTxnPoolTxnStatusDropped(t *testing.T) {
+	pker, txns := makePoolTestTxns(3)
+	pool := NewBoundedTxnPool(pker, 2, 1<<20)
+	for _, b := range txns {
+		pool.Add(b)
+	}
+
+	r := pool.TxnStatus(consensus.SHA3(txns[0]))
+	assert.Equal(t, TxnRejected, r.Status)
+	assert.NotEqual(t, "", r.Reason)
+	assert.Equal(t, TxnPending, pool.TxnStatus(consensus.SHA3(txns[2])).Status)
+}
+
+func TestTxnPoolFee(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.UpdateMinTxnFee(10)
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(&myPKer{m: map[consensus.Addr]PK{}})
+	pool.Update(s)
+
+	withFee := func(fee uint64, nonce uint64) []byte {
+		b, err := WithFee(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, nonce), sk, testChainID, fee)
+		assert.Nil(t, err)
+		return b
+	}
+
+	_, _, err := pool.AddTxn(withFee(9, 0))
+	assert.NotNil(t, err)
+	// the available balance is 100
+	b := withFee(101, 0)
+	_, _, err = pool.AddTxn(b)
+	assert.NotNil(t, err)
+	_, r, err := pool.AddTxn(withFee(10, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, TxnAdded, r)
+
+	// the fee rejections depend on the state, the txn is
+	// accepted once the owner has enough balance.
+	assert.NotEqual(t, TxnRejected, pool.TxnStatus(consensus.SHA3(b)).Status)
+	s.Account(pk.Addr()).UpdateBalance(0, Balance{Available: 200})
+	s.CommitCache()
+	pool.Update(s)
+	_, r, err = pool.AddTxn(b)
+	assert.Nil(t, err)
+	assert.Equal(t, TxnReplaced, r)
+}
+
+func TestTxnPoolFeePriority(t *testing.T) {
+	pker := &myPKer{m: make(map[consensus.Addr]PK)}
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(pker)
+	pool.SetOrder(FeePriority)
+
+	add := func(sk SK, owner consensus.Addr, nonce, fee uint64) {
+		b, err := WithFee(MakeSendTokenTxn(sk, testChainID, owner, to, 0, 1, nonce), sk, testChainID, fee)
+		assert.Nil(t, err)
+		_, broadcast := pool.Add(b)
+		assert.True(t, broadcast)
+	}
+
+	a, aSK := RandKeyPair()
+	b, bSK := RandKeyPair()
+	c, cSK := RandKeyPair()
+	for _, pk := range []PK{a, b, c} {
+		pker.m[pk.Addr()] = pk
+	}
+
+	add(aSK, a.Addr(), 0, 0)
+	add(bSK, b.Addr(), 1, 100)
+	add(bSK, b.Addr(), 0, 1)
+	add(cSK, c.Addr(), 0, 0)
+	add(cSK, c.Addr(), 1, 50)
+
+	type slot struct {
+		owner consensus.Addr
+		nonce uint64
+	}
+	var got []slot
+	for _, txn := range pool.Txns() {
+		got = append(got, slot{owner: txn.Owner, nonce: txn.Nonce})
+	}
+
+	// b's fee 100 txn waits for its nonce 0 txn, the zero fee
+	// txns are ordered by arrival.
+	assert.Equal(t, []slot{
+		{b.Addr(), 0},
+		{b.Addr(), 1},
+		{a.Addr(), 0},
+		{c.Addr(), 0},
+		{c.Addr(), 1},
+	}, got)
+}
+
+func TestTxnPoolNotify(t *testing.T) {
+	pker, txns := makePoolTestTxns(3)
+	pool := NewTxnPool(pker)
+	ch := pool.Notify()
+	select {
+	case <-ch:
+		t.Fatal("notified before the pool becomes non-empty")
+	default:
+	}
+
+	// the notifications are coalesced
+	for _, b := range txns {
+		pool.Add(b)
+	}
+	<-ch
+	select {
+	case <-ch:
+		t.Fatal("notified more than once")
+	default:
+	}
+
+	for _, b := range txns {
+		pool.Remove(consensus.SHA3(b))
+	}
+	assert.Equal(t, 0, pool.Size())
+	pool.Add(txns[0])
+	<-ch
+}
+
+func TestTxnPoolStats(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	unknown, unknownSK := RandKeyPair()
+	pool := NewBoundedTxnPool(&myPKer{m: map[consensus.Addr]PK{}}, 4, 1<<20)
+	pool.Update(s)
+
+	var txns [][]byte
+	for i := 0; i < 3; i++ {
+		b := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, uint64(i))
+		txns = append(txns, b)
+		_, _, err := pool.AddTxn(b)
+		assert.Nil(t, err)
+	}
+
+	_, r, err := pool.AddTxn(txns[0])
+	assert.Nil(t, err)
+	assert.Equal(t, TxnDuplicate, r)
+	_, r, err = pool.AddTxn(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 2, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, TxnReplaced, r)
+
+	badSig := Txn{T: SendToken, Owner: pk.Addr(), Nonce: 3, Data: encodePayload(SendTokenTxn{To: to, Quant: 1}), version: TxnVersion}
+	badSig.Sig = unknownSK.Sign(badSig.signingBytes(testChainID))
+	market := MarketSymbol{Base: 0, Quote: 1}
+	pool.AdvanceRound(5)
+	for _, b := range [][]byte{
+		badSig.Bytes(),
+		MakeSendTokenTxn(unknownSK, testChainID, unknown.Addr(), to, 0, 1, 0),
+		make([]byte, consensus.MaxTxnBytes+1),
+		MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 0, 3),
+		MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 1, Price: 1, Market: market, ExpireRound: 3}, 3),
+	} {
+		_, _, err := pool.AddTxn(b)
+		assert.NotNil(t, err)
+	}
+
+	// the oldest txn is evicted by the 5th txn, and the order
+	// expires at round 10.
+	_, _, err = pool.AddTxn(MakePlaceOrderTxn(sk, testChainID, pk.Addr(), PlaceOrderTxn{Quant: 1, Price: 1, Market: market, ExpireRound: 10}, 3))
+	assert.Nil(t, err)
+	_, _, err = pool.AddTxn(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 4))
+	assert.Nil(t, err)
+	pool.AdvanceRound(10)
+
+	included, err := rlp.EncodeToBytes([][]byte{txns[2]})
+	assert.Nil(t, err)
+	pool.Included(10, consensus.Hash{1}, included)
+
+	stats := pool.Stats()
+	assert.Equal(t, 3, stats.Count)
+	assert.Equal(t, uint64(6), stats.Admitted)
+	assert.Equal(t, uint64(1), stats.Replaced)
+	assert.Equal(t, uint64(1), stats.Evicted)
+	assert.Equal(t, uint64(1), stats.Expired)
+	assert.Equal(t, map[string]uint64{
+		"duplicate":     1,
+		"bad signature": 1,
+		"unknown owner": 1,
+		"oversized":     1,
+		"invalid":       1,
+		"expired":       1,
+	}, stats.Rejected)
+	assert.Equal(t, 6.0/60, stats.AdmissionRate)
+	assert.True(t, stats.InclusionP50 > 0)
+	assert.Equal(t, stats.InclusionP50, stats.InclusionP99)
+}
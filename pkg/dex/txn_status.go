@@ -0,0 +1,186 @@
+package dex
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// the number of recent rounds whose included txns are
+	// remembered.
+	defaultIncludedRetention = 1000
+	// the number of txns dropped from the pool that are
+	// remembered.
+	droppedCacheSize = 4096
+)
+
+// TxnStatus is the status of a submitted txn.
+type TxnStatus int
+
+const (
+	// TxnUnknown means the txn is not seen, or its status is no
+	// longer retained.
+	TxnUnknown TxnStatus = iota
+	// TxnPending means the txn is in the txn pool, waiting to be
+	// included in a block.
+	TxnPending
+	// TxnIncluded means the txn is included in a block.
+	TxnIncluded
+	// TxnRejected means the txn is rejected or dropped by the
+	// txn pool.
+	TxnRejected
+)
+
+func (s TxnStatus) String() string {
+	switch s {
+	case TxnUnknown:
+		return "unknown"
+	case TxnPending:
+		return "pending"
+	case TxnIncluded:
+		return "included"
+	case TxnRejected:
+		return "rejected"
+	default:
+		return fmt.Sprintf("unknown status %d", int(s))
+	}
+}
+
+// TxnStatusResult is the status of a txn.
+type TxnStatusResult struct {
+	Status TxnStatus
+	// the round and the block that include the txn, only set
+	// when the txn is included.
+	Round uint64
+	Block consensus.Hash
+	// the reason that the txn is rejected.
+	Reason string
+}
+
+type includedTxn struct {
+	round uint64
+	block consensus.Hash
+}
+
+// txnStatusIndex remembers the txns included in the recent rounds
+// and the txns dropped from the pool.
+type txnStatusIndex struct {
+	dropped *lru.Cache
+
+	mu        sync.Mutex
+	retention uint64
+	latest    uint64
+	included  map[consensus.Hash]includedTxn
+	byRound   map[uint64][]consensus.Hash
+	// changed is closed and replaced when a block is included,
+	// waking up the waiters of the txns.
+	changed chan struct{}
+}
+
+func newTxnStatusIndex() *txnStatusIndex {
+	dropped, err := lru.New(droppedCacheSize)
+	if err != nil {
+		panic(err)
+	}
+
+	return &txnStatusIndex{
+		dropped:   dropped,
+		retention: defaultIncludedRetention,
+		included:  make(map[consensus.Hash]includedTxn),
+		byRound:   make(map[uint64][]consensus.Hash),
+		changed:   make(chan struct{}),
+	}
+}
+
+func (s *txnStatusIndex) setRetention(rounds uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retention = rounds
+	s.prune()
+}
+
+func (s *txnStatusIndex) include(round uint64, block consensus.Hash, hashes []consensus.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, h := range hashes {
+		s.included[h] = includedTxn{round: round, block: block}
+	}
+	s.byRound[round] = append(s.byRound[round], hashes...)
+
+	if round > s.latest {
+		s.latest = round
+		s.prune()
+	}
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// prune forgets the txns included before the retained rounds, the
+// caller must hold s.mu.
+func (s *txnStatusIndex) prune() {
+	for r, hashes := range s.byRound {
+		if r+s.retention > s.latest {
+			continue
+		}
+
+		for _, h := range hashes {
+			// the txn may be included again by a block of
+			// another fork in a later round.
+			if s.included[h].round == r {
+				delete(s.included, h)
+			}
+		}
+		delete(s.byRound, r)
+	}
+}
+
+func (s *txnStatusIndex) includedAt(hash consensus.Hash) (includedTxn, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.included[hash]
+	return i, ok
+}
+
+// waitIncluded waits until the txn is included, a block rounds
+// rounds after the latest included round is included without the
+// txn, or the context is done. It returns false if the txn is not
+// included.
+func (s *txnStatusIndex) waitIncluded(ctx context.Context, hash consensus.Hash, rounds uint64) (includedTxn, bool) {
+	s.mu.Lock()
+	target := s.latest + rounds
+	s.mu.Unlock()
+
+	for {
+		s.mu.Lock()
+		i, ok := s.included[hash]
+		latest := s.latest
+		changed := s.changed
+		s.mu.Unlock()
+
+		if ok {
+			return i, true
+		}
+
+		if latest >= target {
+			return includedTxn{}, false
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return includedTxn{}, false
+		}
+	}
+}
+
+func (s *txnStatusIndex) drop(hash consensus.Hash, reason string) {
+	s.dropped.Add(hash, reason)
+}
@@ -1,6 +1,9 @@
 package dex
 
 import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/rlp"
@@ -44,3 +47,377 @@ func TestPlaceOrderEncodeDecode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, p, p0)
 }
+
+func TestPlaceOrderEncodeDecodeTIF(t *testing.T) {
+	for _, tif := range []TimeInForce{GTC, IOC, FOK} {
+		for _, sell := range []bool{false, true} {
+			p := PlaceOrderTxn{
+				SellSide: sell,
+				Quant:    100,
+				Price:    1000,
+				Market:   MarketSymbol{Base: 1, Quote: 2},
+				TIF:      tif,
+			}
+			var p0 PlaceOrderTxn
+			err := p0.Decode(p.Encode())
+			assert.Nil(t, err)
+			assert.Equal(t, p, p0)
+		}
+	}
+}
+
+func TestPlaceOrderDecodeWithoutTIF(t *testing.T) {
+	p := PlaceOrderTxn{
+		Quant:  100,
+		Price:  1000,
+		Market: MarketSymbol{Base: 1, Quote: 2},
+	}
+	// the payload encoded before the TIF field was added has a
+	// trailing 1 byte for the sell orders.
+	b := append(p.Encode(), 1)
+	var p0 PlaceOrderTxn
+	err := p0.Decode(b)
+	assert.Nil(t, err)
+	assert.True(t, p0.SellSide)
+	assert.Equal(t, GTC, p0.TIF)
+}
+
+func TestPlaceOrderDecodeOverflow(t *testing.T) {
+	// a varint of 11 bytes overflows uint64
+	overflow := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}
+	valid := []byte{0x01}
+	market := MarketSymbol{Base: 1, Quote: 2}
+	for i := 0; i < 3; i++ {
+		var b []byte
+		for j := 0; j < 3; j++ {
+			if i == j {
+				b = append(b, overflow...)
+			} else {
+				b = append(b, valid...)
+			}
+		}
+		b = append(b, market.Encode()...)
+
+		var p PlaceOrderTxn
+		assert.NotNil(t, p.Decode(b))
+	}
+
+	var p PlaceOrderTxn
+	assert.NotNil(t, p.Decode(nil))
+}
+
+func TestPlaceOrderEncodeDecodeMarketOrder(t *testing.T) {
+	p := PlaceOrderTxn{
+		Quant:            100,
+		Market:           MarketSymbol{Base: 1, Quote: 2},
+		MarketOrder:      true,
+		MaxSlippageQuote: 120000000,
+	}
+	var p0 PlaceOrderTxn
+	err := p0.Decode(p.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, p, p0)
+}
+
+func TestPlaceOrderEncodeDecodeStopOrder(t *testing.T) {
+	p := PlaceOrderTxn{
+		SellSide:  true,
+		Quant:     100,
+		Price:     1000,
+		Market:    MarketSymbol{Base: 1, Quote: 2},
+		StopPrice: 1200,
+	}
+	var p0 PlaceOrderTxn
+	err := p0.Decode(p.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, p, p0)
+}
+
+func TestPlaceOrderEncodeDecodeIceberg(t *testing.T) {
+	p := PlaceOrderTxn{
+		SellSide:     true,
+		Quant:        100,
+		Price:        1000,
+		Market:       MarketSymbol{Base: 1, Quote: 2},
+		StopPrice:    1200,
+		DisplayQuant: 10,
+	}
+	var p0 PlaceOrderTxn
+	err := p0.Decode(p.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, p, p0)
+}
+
+// gobEncode encodes the payload in the legacy format.
+func gobEncode(v interface{}) []byte {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// testChainID is the chain ID that the txns in the tests are signed
+// for.
+var testChainID consensus.Hash
+
+func TestPayloadGolden(t *testing.T) {
+	cases := []struct {
+		v      interface{}
+		golden string
+	}{
+		{SendTokenTxn{TokenID: 1, To: PK{1, 2}, Quant: 3}, "80c50182010203"},
+		{CancelOrderTxn{ID: OrderID{ID: 5, Market: MarketSymbol{Base: 1, Quote: 2}}}, "80c5c405c20102"},
+		{FreezeTokenTxn{TokenID: 0, AvailableRound: 10, Quant: 300}, "80c5800a82012c"},
+		{CancelAllOrdersTxn{Market: MarketSymbol{Base: 1, Quote: 2}, FilterSide: true}, "80c5c201020180"},
+		{BurnTokenTxn{ID: 2, Quant: 1024}, "80c402820400"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.golden, hex.EncodeToString(encodePayload(c.v)))
+	}
+}
+
+func TestPayloadDecodeLegacyGob(t *testing.T) {
+	send := SendTokenTxn{TokenID: 1, To: PK{1, 2}, Quant: 3}
+	freeze := FreezeTokenTxn{TokenID: 2, AvailableRound: 10, Quant: 300}
+	issue := IssueTokenTxn{
+		Info:    TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100},
+		Markets: []QuoteMarketParams{{Quote: 0, Params: DefaultMarketParams}},
+	}
+
+	for _, b := range [][]byte{gobEncode(send), encodePayload(send)} {
+		var v SendTokenTxn
+		assert.Nil(t, decodePayload(b, &v))
+		assert.Equal(t, send, v)
+	}
+
+	for _, b := range [][]byte{gobEncode(freeze), encodePayload(freeze)} {
+		var v FreezeTokenTxn
+		assert.Nil(t, decodePayload(b, &v))
+		assert.Equal(t, freeze, v)
+	}
+
+	for _, b := range [][]byte{gobEncode(issue), encodePayload(issue)} {
+		var v IssueTokenTxn
+		assert.Nil(t, decodePayload(b, &v))
+		assert.Equal(t, issue, v)
+		// a gob stream never starts with the version byte
+		assert.True(t, b[0] < 0x80 || b[0] >= 0xf8 || b[0] == payloadRLP)
+	}
+}
+
+func TestParseLegacyGobTxn(t *testing.T) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	send := SendTokenTxn{TokenID: 1, To: to, Quant: 3}
+	txn := &Txn{
+		T:       SendToken,
+		Owner:   pk.Addr(),
+		Data:    gobEncode(send),
+		version: TxnVersion,
+	}
+	txn.Sig = sk.Sign(txn.signingBytes(testChainID))
+
+	pt, err := parseTxn(txn.Encode(true), &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}})
+	assert.Nil(t, err)
+	assert.Equal(t, &send, pt.Decoded)
+}
+
+func TestTxnEnvelopeGolden(t *testing.T) {
+	txn := Txn{T: SendToken, Data: []byte{1, 2}, Nonce: 3, Owner: consensus.Addr{4}, Sig: Sig{5, 6}}
+	legacy := txn.Bytes()
+	txn.version = txnVersionNoFee
+	noFee := txn.Bytes()
+	txn.version = txnVersionNoOwnerPK
+	txn.Fee = 7
+	withFee := txn.Bytes()
+	txn.version = txnVersionNoSigs
+	txn.OwnerPK = PK{8, 9}
+	withOwnerPK := txn.Bytes()
+	txn.version = TxnVersion
+	txn.Sigs = []Sig{{10}}
+	withSigs := txn.Bytes()
+	assert.Equal(t, "dd0382010203940400000000000000000000000000000000000000820506", hex.EncodeToString(legacy))
+	assert.Equal(t, "02dd0382010203940400000000000000000000000000000000000000820506", hex.EncodeToString(noFee))
+	assert.Equal(t, "03de038201020394040000000000000000000000000000000000000007820506", hex.EncodeToString(withFee))
+	assert.Equal(t, "04e1038201020394040000000000000000000000000000000000000007820809820506", hex.EncodeToString(withOwnerPK))
+	assert.Equal(t, "05e3038201020394040000000000000000000000000000000000000007820809820506c10a", hex.EncodeToString(withSigs))
+
+	for _, b := range [][]byte{legacy, noFee, withFee, withOwnerPK, withSigs} {
+		d, err := decodeTxn(b)
+		assert.Nil(t, err)
+		assert.Equal(t, b, d.Bytes())
+	}
+}
+
+func TestParseTxnVersion(t *testing.T) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	b := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)
+	assert.Equal(t, byte(TxnVersion), b[0])
+	_, err := parseTxn(b, pker)
+	assert.Nil(t, err)
+
+	unknown := append([]byte{TxnVersion + 1}, b[1:]...)
+	_, err = parseTxn(unknown, pker)
+	assert.Equal(t, "error decode txn: unsupported txn version: 6", err.Error())
+
+	_, err = parseTxn(nil, pker)
+	assert.NotNil(t, err)
+}
+
+func TestParseTxnWithoutChainID(t *testing.T) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	for _, v := range []uint8{txnVersionLegacy, txnVersionNoChainID} {
+		txn := &Txn{
+			T:       SendToken,
+			Owner:   pk.Addr(),
+			Data:    encodePayload(SendTokenTxn{To: to, Quant: 1}),
+			version: v,
+		}
+		txn.Sig = sk.Sign(txn.Encode(false))
+		_, err := parseTxn(txn.Bytes(), pker)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestParseTxnOtherChain(t *testing.T) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	chainA := consensus.Hash{1}
+	chainB := consensus.Hash{2}
+	b := MakeSendTokenTxn(sk, chainA, pk.Addr(), to, 0, 1, 0)
+
+	_, err := parseTxn(b, &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}, chainID: chainA})
+	assert.Nil(t, err)
+
+	_, err = parseTxn(b, &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}, chainID: chainB})
+	assert.NotNil(t, err)
+}
+
+func TestParseTxnOwnerPK(t *testing.T) {
+	pk, sk := RandKeyPair()
+	other, _ := RandKeyPair()
+	to, _ := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{}}
+	b := MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 1, 0)
+	_, err := parseTxn(b, pker)
+	assert.Equal(t, errUnknownOwner, err)
+
+	withPK, err := WithOwnerPK(b, sk, testChainID, pk)
+	assert.Nil(t, err)
+	txn, err := parseTxn(withPK, pker)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(pk), txn.OwnerPK)
+
+	mismatch, err := WithOwnerPK(b, sk, testChainID, other)
+	assert.Nil(t, err)
+	_, err = parseTxn(mismatch, pker)
+	assert.NotNil(t, err)
+}
+
+func TestBuildTxnRoundTrip(t *testing.T) {
+	pk, sk := RandKeyPair()
+	to, _ := RandKeyPair()
+	owner := pk.Addr()
+	market := MarketSymbol{Base: 1, Quote: 0}
+	cases := []struct {
+		made  []byte
+		build func() ([]byte, consensus.Hash)
+	}{
+		{
+			MakePlaceOrderTxn(sk, testChainID, owner, PlaceOrderTxn{Quant: 1, Price: 2, Market: market}, 1),
+			func() ([]byte, consensus.Hash) {
+				return BuildPlaceOrderTxn(testChainID, owner, PlaceOrderTxn{Quant: 1, Price: 2, Market: market}, 1)
+			},
+		},
+		{
+			MakeCancelOrderTxn(sk, testChainID, owner, OrderID{ID: 3, Market: market}, 2),
+			func() ([]byte, consensus.Hash) {
+				return BuildCancelOrderTxn(testChainID, owner, OrderID{ID: 3, Market: market}, 2)
+			},
+		},
+		{
+			MakeCancelAllOrdersTxn(sk, testChainID, owner, CancelAllOrdersTxn{Market: market}, 3),
+			func() ([]byte, consensus.Hash) {
+				return BuildCancelAllOrdersTxn(testChainID, owner, CancelAllOrdersTxn{Market: market}, 3)
+			},
+		},
+		{
+			MakeSendTokenTxn(sk, testChainID, owner, to, 1, 4, 4),
+			func() ([]byte, consensus.Hash) {
+				return BuildSendTokenTxn(testChainID, owner, to, 1, 4, 4)
+			},
+		},
+		{
+			MakeIssueTokenTxn(sk, testChainID, owner, TokenInfo{Symbol: "XYZ", Decimals: 8, TotalUnits: 100}, 5),
+			func() ([]byte, consensus.Hash) {
+				return BuildIssueTokenTxn(testChainID, owner, TokenInfo{Symbol: "XYZ", Decimals: 8, TotalUnits: 100}, 5)
+			},
+		},
+		{
+			MakeFreezeTokenTxn(sk, testChainID, owner, FreezeTokenTxn{TokenID: 1, AvailableRound: 10, Quant: 5}, 6),
+			func() ([]byte, consensus.Hash) {
+				return BuildFreezeTokenTxn(testChainID, owner, FreezeTokenTxn{TokenID: 1, AvailableRound: 10, Quant: 5}, 6)
+			},
+		},
+		{
+			MakeBurnTokenTxn(sk, testChainID, owner, BurnTokenTxn{ID: 1, Quant: 6}, 7),
+			func() ([]byte, consensus.Hash) {
+				return BuildBurnTokenTxn(testChainID, owner, BurnTokenTxn{ID: 1, Quant: 6}, 7)
+			},
+		},
+		{
+			MakeMintTokenTxn(sk, testChainID, owner, MintTokenTxn{ID: 1, Quant: 7}, 8),
+			func() ([]byte, consensus.Hash) {
+				return BuildMintTokenTxn(testChainID, owner, MintTokenTxn{ID: 1, Quant: 7}, 8)
+			},
+		},
+		{
+			MakeUpdateAccountPolicyTxn(sk, testChainID, owner, UpdateAccountPolicyTxn{Policy: AccountPolicy{Owners: []PK{to}, Threshold: 1}}, 9),
+			func() ([]byte, consensus.Hash) {
+				return BuildUpdateAccountPolicyTxn(testChainID, owner, UpdateAccountPolicyTxn{Policy: AccountPolicy{Owners: []PK{to}, Threshold: 1}}, 9)
+			},
+		},
+	}
+
+	for i, c := range cases {
+		made, err := decodeTxn(c.made)
+		assert.Nil(t, err)
+
+		// the signature is not deterministic, attach the
+		// signature of the one-shot txn to compare the bytes.
+		unsigned, h := c.build()
+		assert.True(t, made.Sig.Verify(append(testChainID[:], unsigned...), pk), i)
+		b, err := AttachSignature(testChainID, unsigned, made.Sig, pk)
+		assert.Nil(t, err)
+		assert.Equal(t, c.made, b, i)
+
+		b, err = AttachSignature(testChainID, unsigned, sk.SignHash(h), pk)
+		assert.Nil(t, err)
+		_, err = parseTxn(b, &myPKer{m: map[consensus.Addr]PK{owner: pk}, chainID: testChainID})
+		assert.Nil(t, err, i)
+	}
+}
+
+func TestAttachSignatureInvalid(t *testing.T) {
+	pk, sk := RandKeyPair()
+	other, _ := RandKeyPair()
+	to, _ := RandKeyPair()
+	unsigned, h := BuildSendTokenTxn(testChainID, pk.Addr(), to, 0, 1, 0)
+	_, err := AttachSignature(testChainID, unsigned, sk.SignHash(h), other)
+	assert.NotNil(t, err)
+
+	var otherChain consensus.Hash
+	otherChain[0] = 1
+	_, err = AttachSignature(otherChain, unsigned, sk.SignHash(h), pk)
+	assert.NotNil(t, err)
+
+	_, err = AttachSignature(testChainID, unsigned, Sig{1, 2}, pk)
+	assert.NotNil(t, err)
+}
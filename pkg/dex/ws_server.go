@@ -0,0 +1,367 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/helinwang/dex/pkg/consensus"
+	log "github.com/helinwang/log15"
+)
+
+// The subscription topics of the WebSocket endpoint.
+const (
+	// TopicAccount is the balance and order changes of an
+	// account.
+	TopicAccount = "account"
+	// TopicTrades is the trades of a market.
+	TopicTrades = "trades"
+	// TopicRounds is the finalized rounds.
+	TopicRounds = "rounds"
+)
+
+const (
+	// wsBufferSize is the number of the messages buffered for a
+	// connection, the messages are dropped when the buffer is
+	// full.
+	wsBufferSize = 256
+	// the maximum number of subscriptions of a connection
+	maxWSSubscriptions = 1000
+	wsWriteTimeout     = 10 * time.Second
+	wsPongTimeout      = 60 * time.Second
+	wsPingPeriod       = wsPongTimeout * 9 / 10
+	maxWSRequestSize   = 4096
+)
+
+// DefaultWebSocketPath is the default HTTP path of the WebSocket
+// endpoint.
+const DefaultWebSocketPath = "/ws"
+
+// wsRequest is the subscribe or the unsubscribe request sent by the
+// client. The account topic takes Addr, the trades topic takes the
+// market's Base and Quote token IDs, they are hex strings.
+type wsRequest struct {
+	ID     uint64 `json:"id"`
+	Method string `json:"method"`
+	Topic  string `json:"topic"`
+	Addr   string `json:"addr"`
+	Base   string `json:"base"`
+	Quote  string `json:"quote"`
+}
+
+// wsMessage is the message sent to the client, it is either the
+// reply of a request or an event of a subscribed topic.
+//
+// Dropped is the number of the events dropped right before the
+// message since the client did not keep up, the client should
+// re-read the state it cares about when it is not 0.
+type wsMessage struct {
+	ID      uint64      `json:"id,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Topic   string      `json:"topic,omitempty"`
+	Round   uint64      `json:"round,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Dropped uint64      `json:"dropped,omitempty"`
+}
+
+type wsConn struct {
+	conn *websocket.Conn
+	send chan wsMessage
+
+	mu       sync.Mutex
+	closed   bool
+	dropped  uint64
+	accounts map[consensus.Addr]bool
+	markets  map[MarketSymbol]bool
+	rounds   bool
+}
+
+func newWSConn(conn *websocket.Conn) *wsConn {
+	return &wsConn{
+		conn:     conn,
+		send:     make(chan wsMessage, wsBufferSize),
+		accounts: make(map[consensus.Addr]bool),
+		markets:  make(map[MarketSymbol]bool),
+	}
+}
+
+// deliver queues the message without blocking, the message is
+// dropped and counted if the buffer is full. The caller must hold
+// c.mu.
+func (c *wsConn) deliver(m wsMessage) {
+	if c.closed {
+		return
+	}
+
+	m.Dropped = c.dropped
+	select {
+	case c.send <- m:
+		c.dropped = 0
+	default:
+		c.dropped++
+	}
+}
+
+func (c *wsConn) reply(m wsMessage) {
+	c.mu.Lock()
+	c.deliver(m)
+	c.mu.Unlock()
+}
+
+func (c *wsConn) close() {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+	c.mu.Unlock()
+}
+
+func (c *wsConn) publish(round uint64, root consensus.Hash, events *StateEvents) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if events != nil {
+		for _, e := range events.Accounts {
+			if c.accounts[e.Addr] {
+				c.deliver(wsMessage{Topic: TopicAccount, Round: round, Data: jsonValue(reflect.ValueOf(e))})
+			}
+		}
+
+		for _, e := range events.Trades {
+			if c.markets[e.Market] {
+				c.deliver(wsMessage{Topic: TopicTrades, Round: round, Data: jsonValue(reflect.ValueOf(e))})
+			}
+		}
+	}
+
+	if c.rounds {
+		e := RoundEvent{Round: round, StateRoot: root}
+		c.deliver(wsMessage{Topic: TopicRounds, Round: round, Data: jsonValue(reflect.ValueOf(e))})
+	}
+}
+
+func (c *wsConn) subscribe(req wsRequest) error {
+	var sub bool
+	switch req.Method {
+	case "subscribe":
+		sub = true
+	case "unsubscribe":
+	default:
+		return fmt.Errorf("unknown method %q", req.Method)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sub && len(c.accounts)+len(c.markets) >= maxWSSubscriptions {
+		return fmt.Errorf("too many subscriptions, max: %d", maxWSSubscriptions)
+	}
+
+	switch req.Topic {
+	case TopicAccount:
+		addr, err := parseAddrParam("addr", req.Addr)
+		if err != nil {
+			return err
+		}
+
+		if sub {
+			c.accounts[addr] = true
+		} else {
+			delete(c.accounts, addr)
+		}
+	case TopicTrades:
+		base, err := parseTokenIDParam("base", req.Base)
+		if err != nil {
+			return err
+		}
+
+		quote, err := parseTokenIDParam("quote", req.Quote)
+		if err != nil {
+			return err
+		}
+
+		m := MarketSymbol{Base: base, Quote: quote}
+		if !m.Valid() {
+			return fmt.Errorf("invalid market %v", m)
+		}
+
+		if sub {
+			c.markets[m] = true
+		} else {
+			delete(c.markets, m)
+		}
+	case TopicRounds:
+		c.rounds = sub
+	default:
+		return errors.New("unknown topic " + req.Topic)
+	}
+	return nil
+}
+
+// writeLoop writes the queued messages and the pings to the socket,
+// it returns when the connection is closed.
+func (c *wsConn) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case m, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, nil)
+				return
+			}
+
+			err := c.conn.WriteJSON(m)
+			if err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLoop serves the requests of the client, it returns when the
+// socket is closed or broken.
+func (c *wsConn) readLoop() {
+	c.conn.SetReadLimit(maxWSRequestSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		var req wsRequest
+		err := c.conn.ReadJSON(&req)
+		if err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok {
+				log.Debug("error reading websocket request", "err", err)
+			}
+			return
+		}
+
+		err = c.subscribe(req)
+		if err != nil {
+			c.reply(wsMessage{ID: req.ID, Error: err.Error()})
+			continue
+		}
+		c.reply(wsMessage{ID: req.ID})
+	}
+}
+
+// subscriber receives the events of the finalized rounds, publish
+// must not block.
+type subscriber interface {
+	publish(round uint64, root consensus.Hash, events *StateEvents)
+}
+
+// funcSubscriber is the subscriber of SubscribeEvents.
+type funcSubscriber struct {
+	f func(round uint64, root consensus.Hash, events *StateEvents)
+}
+
+func (s *funcSubscriber) publish(round uint64, root consensus.Hash, events *StateEvents) {
+	s.f(round, root, events)
+}
+
+// eventHub publishes the events to the WebSocket connections and the
+// other subscribers.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[subscriber]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[subscriber]bool)}
+}
+
+func (h *eventHub) add(s subscriber) {
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+}
+
+func (h *eventHub) remove(s subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// publish sends the events to the subscribers, it does not block on
+// the slow connections.
+func (h *eventHub) publish(round uint64, root consensus.Hash, events *StateEvents) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		s.publish(round, root, events)
+	}
+}
+
+// SubscribeEvents calls f with the events of each finalized round,
+// in round order, until the returned cancel function is called. f
+// is called with the events of all the accounts and markets, it must
+// not block since it delays the other subscribers.
+func (r *RPCServer) SubscribeEvents(f func(round uint64, root consensus.Hash, events *StateEvents)) (cancel func()) {
+	s := &funcSubscriber{f: f}
+	r.hub.add(s)
+	return func() {
+		r.hub.remove(s)
+	}
+}
+
+// wsHandler serves the subscriptions over WebSocket. The client
+// sends JSON requests like:
+//
+//	{"id": 1, "method": "subscribe", "topic": "account", "addr": "<hex>"}
+//	{"id": 2, "method": "subscribe", "topic": "trades", "base": "1", "quote": "0"}
+//	{"id": 3, "method": "unsubscribe", "topic": "rounds"}
+//
+// Each request is replied with a message of the same id, the events
+// are sent as they happen.
+type wsHandler struct {
+	hub      *eventHub
+	upgrader websocket.Upgrader
+}
+
+func newWSHandler(hub *eventHub) *wsHandler {
+	return &wsHandler{
+		hub: hub,
+		upgrader: websocket.Upgrader{
+			// the endpoint serves the public chain data
+			// only, the wallet UIs can be served from any
+			// origin.
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+	}
+}
+
+func (h *wsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// Upgrade already replied the error
+		log.Debug("error upgrading websocket connection", "err", err)
+		return
+	}
+
+	c := newWSConn(conn)
+	h.hub.add(c)
+	go c.writeLoop()
+	c.readLoop()
+	h.hub.remove(c)
+	c.close()
+}
@@ -0,0 +1,128 @@
+package dex
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+type wsTestMessage struct {
+	ID      uint64          `json:"id"`
+	Error   string          `json:"error"`
+	Topic   string          `json:"topic"`
+	Round   uint64          `json:"round"`
+	Data    json.RawMessage `json:"data"`
+	Dropped uint64          `json:"dropped"`
+}
+
+func TestWSAccountSubscription(t *testing.T) {
+	s, pk, sk, _, _ := newTIFTestState()
+	s.CommitCache()
+	to, _ := RandKeyPair()
+	pool := NewTxnPool(s)
+	pool.Update(s)
+	r := NewRPCServer()
+	r.SetTxnPool(pool)
+	r.Update(s)
+
+	h, err := r.Handler()
+	assert.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + DefaultWebSocketPath
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	reqs := []wsRequest{
+		{ID: 1, Method: "subscribe", Topic: TopicAccount, Addr: to.Addr().Hex()},
+		{ID: 2, Method: "subscribe", Topic: TopicRounds},
+		{ID: 3, Method: "subscribe", Topic: "unknown"},
+	}
+	for _, req := range reqs {
+		assert.Nil(t, conn.WriteJSON(req))
+		var m wsTestMessage
+		assert.Nil(t, conn.ReadJSON(&m))
+		assert.Equal(t, req.ID, m.ID)
+		assert.Equal(t, req.Topic == "unknown", m.Error != "")
+	}
+
+	txn, _, err := pool.AddTxn(MakeSendTokenTxn(sk, testChainID, pk.Addr(), to, 0, 10, 0))
+	assert.Nil(t, err)
+	trans := s.Transition(1, nil).(*Transition)
+	assert.Nil(t, trans.Record(txn))
+	ns := trans.Commit().(*State)
+	r.Update(ns)
+	r.Finalized(1, ns)
+
+	var m wsTestMessage
+	assert.Nil(t, conn.ReadJSON(&m))
+	assert.Equal(t, TopicAccount, m.Topic)
+	assert.Equal(t, uint64(1), m.Round)
+	var e struct {
+		Addr     string
+		Balances []struct {
+			Token     string
+			Available uint64
+		}
+	}
+	assert.Nil(t, json.Unmarshal(m.Data, &e))
+	assert.Equal(t, to.Addr().Hex(), e.Addr)
+	assert.Equal(t, 1, len(e.Balances))
+	assert.Equal(t, "0", e.Balances[0].Token)
+	assert.Equal(t, uint64(10), e.Balances[0].Available)
+
+	// the round event follows, no more account event
+	assert.Nil(t, conn.ReadJSON(&m))
+	assert.Equal(t, TopicRounds, m.Topic)
+	var round RoundEvent
+	assert.Nil(t, json.Unmarshal(m.Data, &struct{ Round *uint64 }{&round.Round}))
+	assert.Equal(t, uint64(1), round.Round)
+
+	conn.Close()
+	// the hub forgets the closed connection
+	for {
+		r.hub.mu.Lock()
+		n := len(r.hub.subs)
+		r.hub.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWSSlowClientDropped(t *testing.T) {
+	c := newWSConn(nil)
+	var addr consensus.Addr
+	c.accounts[addr] = true
+	events := &StateEvents{Accounts: []AccountEvent{{Addr: addr}}}
+	for i := 0; i < wsBufferSize+3; i++ {
+		c.publish(uint64(i+1), consensus.Hash{}, events)
+	}
+
+	assert.Equal(t, uint64(3), c.dropped)
+	for i := 0; i < wsBufferSize; i++ {
+		m := <-c.send
+		assert.Equal(t, uint64(i+1), m.Round)
+		assert.Equal(t, uint64(0), m.Dropped)
+	}
+
+	c.publish(100, consensus.Hash{}, events)
+	m := <-c.send
+	assert.Equal(t, uint64(100), m.Round)
+	assert.Equal(t, uint64(3), m.Dropped)
+	assert.Equal(t, uint64(0), c.dropped)
+
+	c.close()
+	c.publish(101, consensus.Hash{}, events)
+	_, ok := <-c.send
+	assert.False(t, ok)
+}